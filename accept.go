@@ -0,0 +1,45 @@
+package tcp
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Default values for the accept backoff policy.
+const (
+	defAcceptBackoffMin = 5 * time.Millisecond
+	defAcceptBackoffMax = time.Second
+)
+
+// acceptBackoff returns the time to wait after the specified number of
+// consecutive accept errors. The time grows exponentially up to the max
+// with jitter so multiple listeners don't retry in lockstep.
+func (t *TCP) acceptBackoff(failures int) time.Duration {
+	min := t.AcceptBackoffMin
+	if min <= 0 {
+		min = defAcceptBackoffMin
+	}
+
+	max := t.AcceptBackoffMax
+	if max <= 0 {
+		max = defAcceptBackoffMax
+	}
+
+	return backoff(failures, min, max)
+}
+
+// backoff returns the time to wait after the specified number of
+// consecutive failures, growing from min to max.
+func backoff(failures int, min, max time.Duration) time.Duration {
+	d := min
+	for i := 1; i < failures && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+
+	// Pick a random value between half and the full duration.
+	half := int64(d / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
@@ -0,0 +1,155 @@
+package tcp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Set of request outcomes reported in the access log.
+const (
+	OutcomeOK         = "ok"          // Every response was written.
+	OutcomeNoResponse = "no_response" // The handler sent no response.
+	OutcomeWriteError = "write_error" // A response failed to write.
+)
+
+// requestIDPrefix makes the request ids unique across processes.
+var requestIDPrefix = func() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}()
+
+// requestIDs is the sequence of the request ids of the process.
+var requestIDs uint64
+
+// newRequestID returns an id unique to the request.
+func newRequestID() string {
+	return requestIDPrefix + "-" + strconv.FormatUint(atomic.AddUint64(&requestIDs, 1), 36)
+}
+
+// requestIDKey is the context key for the id of a request.
+type requestIDKey struct{}
+
+// RequestIDFrom returns the id of the request the context belongs to.
+func RequestIDFrom(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// =============================================================================
+
+// AccessEntry is the access log record of a request.
+type AccessEntry struct {
+	ID         string        `json:"id"`
+	Time       time.Time     `json:"time"`
+	RemoteAddr string        `json:"remote_addr"`
+	BytesIn    int           `json:"bytes_in"`
+	BytesOut   int64         `json:"bytes_out"`
+	Responses  int64         `json:"responses"`
+	Duration   time.Duration `json:"duration_ns"`
+	Outcome    string        `json:"outcome"`
+}
+
+// AccessLogText writes the entry as a line of text.
+func AccessLogText(w io.Writer, e AccessEntry) error {
+	_, err := fmt.Fprintf(w, "%s %s %s in=%d out=%d responses=%d duration=%v outcome=%s\n",
+		e.Time.Format(time.RFC3339Nano), e.ID, e.RemoteAddr, e.BytesIn, e.BytesOut, e.Responses, e.Duration, e.Outcome)
+	return err
+}
+
+// AccessLogJSON writes the entry as a line of JSON.
+func AccessLogJSON(w io.Writer, e AccessEntry) error {
+	return json.NewEncoder(w).Encode(e)
+}
+
+// accessKey is the context key for the access record of a request.
+type accessKey struct{}
+
+// access accumulates the responses sent for a request. All fields are
+// accessed atomically.
+type access struct {
+	bytesOut    int64
+	responses   int64
+	writeErrors int64
+}
+
+// recordResponse adds the response to the access record of the request
+// the context belongs to.
+func recordResponse(ctx context.Context, length int, err error) {
+	if ctx == nil {
+		return
+	}
+
+	a, ok := ctx.Value(accessKey{}).(*access)
+	if !ok {
+		return
+	}
+
+	a.record(length, err)
+}
+
+// record adds the response written with the error to the access record.
+func (a *access) record(length int, err error) {
+	if a == nil {
+		return
+	}
+
+	if err != nil {
+		atomic.AddInt64(&a.writeErrors, 1)
+		return
+	}
+
+	atomic.AddInt64(&a.bytesOut, int64(length))
+	atomic.AddInt64(&a.responses, 1)
+}
+
+// accessEntry returns the access log entry of the processed request
+// without its responses.
+func (c *client) accessEntry(r *Request, d time.Duration) AccessEntry {
+	return AccessEntry{
+		ID:         r.ID,
+		Time:       r.ReadAt,
+		RemoteAddr: c.t.anonymize(c.ipAddress),
+		BytesIn:    r.Length,
+		Duration:   d,
+	}
+}
+
+// logAccess writes the access log entry once the responses of the request
+// are written.
+func (c *client) logAccess(e AccessEntry, a *access) {
+	e.BytesOut = atomic.LoadInt64(&a.bytesOut)
+	e.Responses = atomic.LoadInt64(&a.responses)
+	e.Outcome = OutcomeOK
+
+	switch {
+	case atomic.LoadInt64(&a.writeErrors) > 0:
+		e.Outcome = OutcomeWriteError
+	case e.Responses == 0:
+		e.Outcome = OutcomeNoResponse
+	}
+
+	format := c.t.AccessLogFormat
+	if format == nil {
+		format = AccessLogText
+	}
+
+	c.t.accessMu.Lock()
+	{
+		if err := format(c.t.AccessLog, e); err != nil {
+			c.t.Event(EvtRead, TypError, c.ipAddress, "access log : %v", err)
+		}
+	}
+	c.t.accessMu.Unlock()
+}
@@ -0,0 +1,288 @@
+package tcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// adminHelp lists the commands of the admin protocol.
+var adminHelp = []string{
+	"stats                     metrics as JSON",
+	"debug                     debug view as JSON",
+	"conns                     one line per connection",
+	"kick <ip:port>            close the connection",
+	"kick tag=<tag>            close the connections with the tag",
+	"kick cidr=<cidr>          close the connections from the network",
+	"kick idle=<duration>      close the connections idle for the duration",
+	"drop on|off               drop the new connections",
+	"ratelimit <duration>      accept one connection per duration, 0 to disable",
+	"drain <duration> [tag]    drain the connections, with the tag if any, waiting up to the duration",
+	"profile                   time spent in the handlers by phase and route",
+	"profile reset             discard the time recorded so far",
+	"quit                      close the admin connection",
+}
+
+// startAdmin starts the admin listener on the unix socket. Operators
+// connect to it, such as with nc -U, and send one command per line. Each
+// command is answered with its output lines followed by OK, or with ERR
+// and the reason it failed.
+func (t *TCP) startAdmin() error {
+	if err := removeStaleSocket(t.AdminSocket); err != nil {
+		return err
+	}
+
+	l, err := listenAdmin(t.AdminSocket)
+	if err != nil {
+		return err
+	}
+
+	t.wg.Add(2)
+	go func() {
+		defer t.wg.Done()
+
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					t.serverEvent(EvtAdmin, TypError, t.AdminSocket, "%v", err)
+				}
+				return
+			}
+
+			t.wg.Add(1)
+			go t.admin(conn)
+		}
+	}()
+
+	go func() {
+		<-t.done
+		l.Close()
+		os.Remove(t.AdminSocket)
+		t.wg.Done()
+	}()
+
+	t.serverEvent(EvtAdmin, TypInfo, t.AdminSocket, "waiting")
+	return nil
+}
+
+// removeStaleSocket removes a socket left behind by a process that
+// crashed. A socket a live process answers on and a path that is not a
+// socket are left alone.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return nil
+	}
+
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s : not a socket", path)
+	}
+
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s : in use", path)
+	}
+
+	return os.Remove(path)
+}
+
+// listenAdmin listens on a socket created in a directory only the user
+// running the process can enter. The socket is moved to the path once
+// its mode is 0600, so no other user can connect in between.
+func listenAdmin(path string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".admin")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "s")
+	l, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	// The socket is removed from the path it's moved to once stopped.
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	if err := os.Chmod(tmp, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// admin serves the commands of an admin connection until it's closed or
+// the TCP value is stopped.
+func (t *TCP) admin(conn net.Conn) {
+	defer t.wg.Done()
+
+	quit := make(chan struct{})
+	defer close(quit)
+
+	go func() {
+		select {
+		case <-t.done:
+			conn.Close()
+		case <-quit:
+		}
+	}()
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+		if args[0] == "quit" {
+			return
+		}
+
+		t.serverEvent(EvtAdmin, TypInfo, t.AdminSocket, "command : %s", strings.TrimSpace(line))
+
+		if err := t.adminCommand(w, args); err != nil {
+			fmt.Fprintf(w, "ERR %v\n", err)
+		} else {
+			fmt.Fprintln(w, "OK")
+		}
+
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// adminCommand runs the command of the admin protocol and writes its
+// output lines.
+func (t *TCP) adminCommand(w io.Writer, args []string) error {
+	switch args[0] {
+	case "help":
+		for _, line := range adminHelp {
+			fmt.Fprintln(w, line)
+		}
+		return nil
+
+	case "stats":
+		return json.NewEncoder(w).Encode(t.Metrics())
+
+	case "debug":
+		return json.NewEncoder(w).Encode(t.Debug())
+
+	case "conns":
+		now := t.now()
+		for _, s := range t.ClientStats() {
+			var idle time.Duration
+			if !s.LastAct.IsZero() {
+				idle = now.Sub(s.LastAct)
+			}
+			fmt.Fprintf(w, "%s age=%v idle=%v reads=%d writes=%d read=%d written=%d tags=%s\n",
+				s.IP, now.Sub(s.TimeConn), idle, s.Reads, s.Writes, s.BytesRead, s.BytesWritten, strings.Join(s.Tags, ","))
+		}
+		return nil
+
+	case "kick":
+		if len(args) != 2 {
+			return errors.New("usage : kick <ip:port>|tag=<tag>|cidr=<cidr>|idle=<duration>")
+		}
+
+		var match func(s Stat) bool
+		key, value, _ := strings.Cut(args[1], "=")
+		switch key {
+		case "tag":
+			match = MatchTag(value)
+		case "cidr":
+			_, network, err := net.ParseCIDR(value)
+			if err != nil {
+				return err
+			}
+			match = MatchCIDR(network)
+		case "idle":
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return err
+			}
+			match = MatchIdle(t.now().Add(-d))
+		default:
+			tcpAddr, err := net.ResolveTCPAddr("tcp", args[1])
+			if err != nil {
+				return err
+			}
+			return t.Drop(tcpAddr)
+		}
+
+		fmt.Fprintf(w, "kicked %d\n", t.Kick(match))
+		return nil
+
+	case "drop":
+		if len(args) != 2 || (args[1] != "on" && args[1] != "off") {
+			return errors.New("usage : drop on|off")
+		}
+		t.DropConnections(args[1] == "on")
+		return nil
+
+	case "ratelimit":
+		if len(args) != 2 {
+			return errors.New("usage : ratelimit <duration>")
+		}
+		d, err := time.ParseDuration(args[1])
+		if err != nil {
+			return err
+		}
+		return t.UpdateConfig(ConfigPatch{RateLimit: &d})
+
+	case "drain":
+		if len(args) < 2 || len(args) > 3 {
+			return errors.New("usage : drain <duration> [tag]")
+		}
+		d, err := time.ParseDuration(args[1])
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), d)
+		defer cancel()
+
+		match := func(s Stat) bool { return true }
+		if len(args) == 3 {
+			match = MatchTag(args[2])
+		}
+
+		n, err := t.Drain(ctx, match, nil)
+		fmt.Fprintf(w, "drained %d\n", n)
+		return err
+
+	case "profile":
+		switch {
+		case len(args) == 1:
+			return WriteProfile(w, t.Profile())
+		case len(args) == 2 && args[1] == "reset":
+			t.ResetProfile()
+			return nil
+		}
+		return errors.New("usage : profile [reset]")
+	}
+
+	return fmt.Errorf("unknown command %q, try help", args[0])
+}
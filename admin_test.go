@@ -0,0 +1,112 @@
+package tcp_test
+
+import (
+	"bufio"
+	"net"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/tcp"
+	"github.com/ardanlabs/tcp/tcptest"
+)
+
+// TestAdminProfile tests the profile is reported and reset through the
+// admin socket.
+func TestAdminProfile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix sockets are not supported")
+	}
+
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to read the profile of a running server.")
+	{
+		sock := filepath.Join(t.TempDir(), "admin")
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptProfile: tcp.OptProfile{
+				Profiling: true,
+			},
+			OptAdmin: tcp.OptAdmin{
+				AdminSocket: sock,
+			},
+		})
+
+		c := s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("Hello"), []byte("GOT IT"))
+
+		// The request is profiled once processed, after the response
+		// is sent.
+		for end := time.Now().Add(time.Second); len(s.Profile()) < 2 && time.Now().Before(end); {
+			time.Sleep(time.Millisecond)
+		}
+
+		admin, err := net.Dial("unix", sock)
+		if err != nil {
+			t.Fatal("\tShould be able to dial the admin socket.", failed, err)
+		}
+		defer admin.Close()
+
+		// command sends the command and returns its output lines and
+		// the status line.
+		ar := bufio.NewReader(admin)
+		command := func(cmd string) ([]string, string) {
+			admin.SetDeadline(time.Now().Add(5 * time.Second))
+			if _, err := admin.Write([]byte(cmd + "\n")); err != nil {
+				t.Fatal("\tShould be able to send the admin command.", failed, err)
+			}
+
+			var lines []string
+			for {
+				line, err := ar.ReadString('\n')
+				if err != nil {
+					t.Fatal("\tShould be able to read the admin answer.", failed, err)
+				}
+				line = strings.TrimSuffix(line, "\n")
+				if line == "OK" || strings.HasPrefix(line, "ERR ") {
+					return lines, line
+				}
+				lines = append(lines, line)
+			}
+		}
+
+		lines, status := command("help")
+		if status != "OK" || !strings.Contains(strings.Join(lines, "\n"), "profile reset") {
+			t.Fatal("\tShould list the profile commands.", failed, status, lines)
+		}
+		t.Log("\tShould list the profile commands.", success)
+
+		lines, status = command("profile")
+		if status != "OK" || len(lines) < 3 || !strings.HasPrefix(lines[0], "PHASE") {
+			t.Fatal("\tShould answer the profile as a table.", failed, status, lines)
+		}
+		phases := make(map[string]bool)
+		for _, line := range lines[1:] {
+			phases[strings.Fields(line)[0]] = true
+		}
+		if !phases[tcp.PhaseProcess] || !phases[tcp.PhaseWrite] {
+			t.Fatal("\tShould answer the time of each phase.", failed, lines)
+		}
+		t.Log("\tShould answer the profile as a table.", success)
+
+		if _, status = command("profile reset"); status != "OK" {
+			t.Fatal("\tShould reset the profile.", failed, status)
+		}
+		if lines, status = command("profile"); status != "OK" || len(lines) != 1 {
+			t.Fatal("\tShould reset the profile.", failed, status, lines)
+		}
+		t.Log("\tShould reset the profile.", success)
+
+		if _, status = command("profile bogus"); !strings.HasPrefix(status, "ERR ") {
+			t.Fatal("\tShould refuse an invalid profile command.", failed, status)
+		}
+		t.Log("\tShould refuse an invalid profile command.", success)
+	}
+}
@@ -0,0 +1,111 @@
+package tcp
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// accessList holds the networks of the Allow and Deny lists.
+type accessList struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// parseAccessList parses the IPs and CIDRs of the Allow and Deny lists.
+func parseAccessList(allow, deny []string) (accessList, error) {
+	var al accessList
+	var err error
+
+	if al.allow, err = parseNets(allow); err != nil {
+		return accessList{}, err
+	}
+	if al.deny, err = parseNets(deny); err != nil {
+		return accessList{}, err
+	}
+
+	return al, nil
+}
+
+// parseNets parses a list of IPs and CIDRs. An IP is a network of a single
+// address.
+func parseNets(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("%w : %q", ErrInvalidAdmission, s)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w : %q", ErrInvalidAdmission, s)
+		}
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+// permits reports whether the lists accept the address. Deny is checked
+// first and an empty Allow list accepts every address not denied.
+func (al accessList) permits(ip net.IP) bool {
+	for _, n := range al.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	if len(al.allow) == 0 {
+		return true
+	}
+
+	for _, n := range al.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// permitted reports whether the access lists accept the connection.
+func (t *TCP) permitted(conn net.Conn) bool {
+	var al accessList
+	t.configMu.RLock()
+	{
+		al = t.access
+	}
+	t.configMu.RUnlock()
+
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return true
+	}
+
+	return al.permits(addr.IP)
+}
+
+// atCapacity reports whether MaxConns connections are served already.
+func (t *TCP) atCapacity() (int, bool) {
+	var max int
+	t.configMu.RLock()
+	{
+		max = t.MaxConns
+	}
+	t.configMu.RUnlock()
+
+	if max <= 0 {
+		return 0, false
+	}
+
+	return max, t.Clients() >= max
+}
@@ -0,0 +1,50 @@
+package tcp
+
+import (
+	"io"
+	"time"
+)
+
+// defAuthTimeout is the time allowed for the authentication phase.
+const defAuthTimeout = 10 * time.Second
+
+// AuthHandler is implemented by the user to authenticate the clients with
+// a protocol specific handshake before any request is read, such as a
+// challenge and response or a token exchange.
+type AuthHandler interface {
+
+	// Authenticate is provided the reader and writer bound to the
+	// connection and must complete the handshake within the AuthTimeout.
+	// Writes must be flushed like in the RespHandler. The identity
+	// returned is reported to the handlers with every request, an error
+	// closes the connection.
+	Authenticate(ipAddress string, reader io.Reader, writer io.Writer) (identity string, err error)
+}
+
+// authenticate runs the authentication phase of the connection. A client
+// failing it is closed with CloseAuthFailed and earns a strike.
+func (c *client) authenticate() bool {
+	timeout := c.t.AuthTimeout
+	if timeout <= 0 {
+		timeout = defAuthTimeout
+	}
+
+	c.rw.SetDeadline(time.Now().Add(timeout))
+	identity, err := c.t.AuthHandler.Authenticate(c.ipAddress, c.reader, c.writer)
+	c.rw.SetDeadline(time.Time{})
+
+	if err != nil {
+		c.t.Event(EvtAuth, TypError, c.ipAddress, "authenticate : %v", err)
+		c.setCloseReason(CloseAuthFailed)
+		c.strike(StrikeAuthFailed)
+		return false
+	}
+
+	if identity != "" {
+		c.identity = identity
+		c.publishPeer()
+	}
+
+	c.t.Event(EvtAuth, TypInfo, c.ipAddress, "authenticated : Identity[ %s ]", c.identity)
+	return true
+}
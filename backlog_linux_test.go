@@ -0,0 +1,58 @@
+package tcp_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/tcp"
+)
+
+// TestBacklog tests new connections beyond the backlog are not queued.
+func TestBacklog(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to tune the depth of the accept queue.")
+	{
+		cfg := tcp.Config{
+			NetType: "tcp4",
+			Addr:    "127.0.0.1:0",
+
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptListen: tcp.OptListen{
+				Backlog: 2,
+			},
+		}
+
+		u, err := tcp.New("TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+		if err := u.Start(); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", failed, err)
+		}
+		defer u.Stop()
+
+		// Leave the connections in the accept queue.
+		u.Pause()
+
+		var queued int
+		for i := 0; i < 10; i++ {
+			conn, err := net.DialTimeout("tcp4", u.Addr().String(), 200*time.Millisecond)
+			if err != nil {
+				continue
+			}
+			defer conn.Close()
+			queued++
+		}
+
+		if queued == 0 || queued >= 10 {
+			t.Fatalf("\tShould only queue connections up to the backlog : %d %s", queued, failed)
+		}
+		t.Logf("\tShould only queue connections up to the backlog : %d %s", queued, success)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package tcp
+
+import (
+	"net"
+)
+
+// setBacklog leaves the accept queue to the runtime's default on the
+// platforms where it can't be changed after the listener is created.
+func setBacklog(l *net.TCPListener, backlog int) error {
+	return nil
+}
@@ -0,0 +1,32 @@
+//go:build unix
+
+package tcp
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// setBacklog changes the depth of the listener's accept queue by calling
+// listen again on the socket, which the kernel caps to its own limit such
+// as net.core.somaxconn on Linux.
+func setBacklog(l *net.TCPListener, backlog int) error {
+	rc, err := l.SyscallConn()
+	if err != nil {
+		return fmt.Errorf("backlog : %v", err)
+	}
+
+	var lerr error
+	if err := rc.Control(func(fd uintptr) {
+		lerr = syscall.Listen(int(fd), backlog)
+	}); err != nil {
+		return fmt.Errorf("backlog : %v", err)
+	}
+
+	if lerr != nil {
+		return fmt.Errorf("backlog : %v", lerr)
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package tcp
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Default values for the ban manager.
+const (
+	defBanWindow = time.Minute
+	defBanFor    = 10 * time.Minute
+)
+
+// Set of reasons of the strikes reported by the server itself.
+const (
+	StrikeCorruptFrame  = "corrupt frame"
+	StrikeFrameTooLarge = "frame too large"
+	StrikeTurnViolation = "turn violation"
+	StrikeAuthFailed    = "auth failed"
+)
+
+// Strike reports a strike against the IP, such as an authentication
+// failure the application detected. Once BanStrikes strikes are reported
+// within a window of BanWindow the IP is banned for BanFor and its new
+// connections are closed as they are accepted. The server reports strikes
+// itself for corrupt frames, frames too large, turn violations and failed
+// authentications. It reports whether the strike banned the IP.
+func (t *TCP) Strike(ip net.IP, reason string) bool {
+	if t.BanStrikes <= 0 {
+		return false
+	}
+
+	now := t.now()
+	key := ip.String()
+
+	n, err := t.Store.Record("strike/"+key, now, t.banWindow())
+	if err != nil {
+		t.Event(EvtBan, TypError, key, "strike : store : %v", err)
+		return false
+	}
+
+	t.Event(EvtBan, TypInfo, key, "strike : %s : Strikes[ %d ]", reason, n)
+
+	ban := n >= int64(t.BanStrikes)
+	if ban {
+
+		// Start counting again once banned.
+		if err := t.Store.Delete("strike/" + key); err != nil {
+			t.Event(EvtBan, TypError, key, "strike : store : %v", err)
+		}
+		t.ban(ip, t.banFor(), reason)
+	}
+
+	return ban
+}
+
+// Ban bans the IP for the duration, BanFor when it's 0, so its new
+// connections are closed as they are accepted. Connections already
+// served are left alone.
+func (t *TCP) Ban(ip net.IP, d time.Duration) {
+	if d <= 0 {
+		d = t.banFor()
+	}
+	t.ban(ip, d, "banned by the user")
+}
+
+// ban records the ban of the IP and tells the user.
+func (t *TCP) ban(ip net.IP, d time.Duration, reason string) {
+	now := t.now()
+	if err := t.Store.Set("ban/"+ip.String(), now, now.Add(d)); err != nil {
+		t.Event(EvtBan, TypError, ip.String(), "ban : store : %v", err)
+		return
+	}
+
+	atomic.AddInt64(&t.metrics.bans, 1)
+	t.Event(EvtBan, TypTrigger, ip.String(), "banned : %s : For[ %v ]", reason, d)
+
+	if t.OnBan != nil {
+		t.OnBan(ip, reason)
+	}
+}
+
+// Unban lifts the ban of the IP and forgets its strikes.
+func (t *TCP) Unban(ip net.IP) {
+	if err := t.Store.Delete("ban/" + ip.String()); err != nil {
+		t.Event(EvtBan, TypError, ip.String(), "unban : store : %v", err)
+	}
+	if err := t.Store.Delete("strike/" + ip.String()); err != nil {
+		t.Event(EvtBan, TypError, ip.String(), "unban : store : %v", err)
+	}
+}
+
+// Banned reports whether the IP is banned. The store failing reports the
+// IP isn't.
+func (t *TCP) Banned(ip net.IP) bool {
+	until, err := t.Store.Until("ban/"+ip.String(), t.now())
+	if err != nil {
+		t.Event(EvtBan, TypError, ip.String(), "banned : store : %v", err)
+		return false
+	}
+
+	return !until.IsZero()
+}
+
+// bannedConn reports whether the connection comes from a banned IP.
+func (t *TCP) bannedConn(conn net.Conn) bool {
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	return t.Banned(addr.IP)
+}
+
+// banWindow returns the time the strikes count toward a ban.
+func (cfg *Config) banWindow() time.Duration {
+	if cfg.BanWindow > 0 {
+		return cfg.BanWindow
+	}
+	return defBanWindow
+}
+
+// banFor returns how long an IP stays banned.
+func (cfg *Config) banFor() time.Duration {
+	if cfg.BanFor > 0 {
+		return cfg.BanFor
+	}
+	return defBanFor
+}
+
+// strike reports a strike against the client for the reason.
+func (c *client) strike(reason string) {
+	if c.t.BanStrikes <= 0 {
+		return
+	}
+
+	if addr, ok := c.conn.RemoteAddr().(*net.TCPAddr); ok {
+		c.t.Strike(addr.IP, reason)
+	}
+}
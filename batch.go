@@ -0,0 +1,181 @@
+package tcp
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default values for batching the writes.
+const (
+	defBatchBytes   = 64 * 1024
+	defBatchTimeout = 5 * time.Second
+)
+
+// batchConn coalesces the small writes to the connection, such as the
+// responses of a chatty protocol, so they reach the socket in one call.
+// The batch is written once it's been waiting for the delay or grows to
+// the batch size. An error writing a batch is returned by the next write.
+type batchConn struct {
+	net.Conn
+	t     *TCP
+	delay time.Duration
+	max   int
+
+	mu    sync.Mutex
+	buf   []byte
+	timer Timer
+	err   error
+}
+
+// newBatchConn wraps the connection to batch its writes.
+func newBatchConn(t *TCP, conn net.Conn) *batchConn {
+	max := t.BatchBytes
+	if max <= 0 {
+		max = defBatchBytes
+	}
+
+	bc := batchConn{
+		Conn:  conn,
+		t:     t,
+		delay: t.BatchDelay,
+		max:   max,
+	}
+
+	return &bc
+}
+
+// Write implements the io.Writer interface. Writes as large as a batch go
+// straight to the connection after the batch waiting before them.
+func (bc *batchConn) Write(p []byte) (int, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if bc.err != nil {
+		return 0, bc.err
+	}
+
+	if len(bc.buf)+len(p) > bc.max {
+		if err := bc.flushLocked(); err != nil {
+			return 0, err
+		}
+	}
+
+	if len(p) >= bc.max {
+		n, err := bc.Conn.Write(p)
+		bc.err = err
+		return n, err
+	}
+
+	bc.buf = append(bc.buf, p...)
+	if bc.timer == nil {
+		bc.timer = bc.t.clock().AfterFunc(bc.delay, bc.expire)
+	}
+
+	return len(p), nil
+}
+
+// expire writes the batch once its delay passed, within the write
+// timeout since nothing else is waiting on it.
+func (bc *batchConn) expire() {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	bc.timer = nil
+	bc.Conn.SetWriteDeadline(time.Now().Add(bc.timeout()))
+	bc.flushLocked()
+	bc.Conn.SetWriteDeadline(time.Time{})
+}
+
+// timeout returns the time allowed to write a batch nothing waits on.
+func (bc *batchConn) timeout() time.Duration {
+	if d := bc.t.writeTimeout(); d > 0 {
+		return d
+	}
+	return defBatchTimeout
+}
+
+// Flush writes the batch waiting, if any.
+func (bc *batchConn) Flush() error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	return bc.flushLocked()
+}
+
+// tryFlush writes the batch waiting unless a write holds the batch, such
+// as one blocked on a client that stopped reading.
+func (bc *batchConn) tryFlush() {
+	if !bc.mu.TryLock() {
+		return
+	}
+	defer bc.mu.Unlock()
+
+	bc.Conn.SetWriteDeadline(time.Now().Add(bc.timeout()))
+	bc.flushLocked()
+}
+
+// flushLocked writes the batch waiting. The caller must hold mu.
+func (bc *batchConn) flushLocked() error {
+	if bc.timer != nil {
+		bc.timer.Stop()
+		bc.timer = nil
+	}
+
+	if bc.err != nil || len(bc.buf) == 0 {
+		return bc.err
+	}
+
+	atomic.AddInt64(&bc.t.metrics.writeBatches, 1)
+	_, bc.err = bc.Conn.Write(bc.buf)
+	bc.buf = bc.buf[:0]
+
+	return bc.err
+}
+
+// CloseWrite writes the batch waiting and closes the write side of the
+// connection.
+func (bc *batchConn) CloseWrite() error {
+
+	// closeWriter is declared to test for the existence of the
+	// method coming from the net and tls packages.
+	type closeWriter interface {
+		CloseWrite() error
+	}
+
+	cw, ok := bc.Conn.(closeWriter)
+	if !ok {
+		return ErrNoHalfClose
+	}
+
+	if err := bc.Flush(); err != nil {
+		return err
+	}
+
+	return cw.CloseWrite()
+}
+
+// Close writes the batch waiting and closes the connection.
+func (bc *batchConn) Close() error {
+	bc.Flush()
+	return bc.Conn.Close()
+}
+
+// flushBatch writes the responses still waiting in the batch, within the
+// write timeout, before the connection is closed.
+func (c *client) flushBatch() {
+	if bc := c.batch.Load(); bc != nil {
+		bc.SetWriteDeadline(time.Now().Add(bc.timeout()))
+		bc.Flush()
+	}
+}
+
+// flushIdleBatch writes the responses waiting in the batch of a connection
+// being dropped, within the write timeout, unless a write is in progress.
+// The blocked write is released by closing the connection instead.
+func (c *client) flushIdleBatch() {
+	if bc := c.batch.Load(); bc != nil {
+		bc.tryFlush()
+	}
+}
@@ -0,0 +1,82 @@
+package tcp
+
+import (
+	"sync"
+	"time"
+)
+
+// breaker maintains the state of the circuit breaker handlers can trip
+// when downstream dependencies are down.
+type breaker struct {
+	mu     sync.Mutex
+	until  time.Time
+	reason string
+	timer  Timer
+}
+
+// TripBreaker switches the TCP value into rejecting new connections for
+// the specified duration. New connections receive the configured breaker
+// message through the RespHandler and are closed. Tripping the breaker
+// again replaces the reason and the duration.
+func (t *TCP) TripBreaker(reason string, d time.Duration) {
+	t.breaker.mu.Lock()
+	{
+		t.breaker.until = t.now().Add(d)
+		t.breaker.reason = reason
+
+		// Report when the breaker recovers on its own.
+		if t.breaker.timer != nil {
+			t.breaker.timer.Stop()
+		}
+		t.breaker.timer = t.clock().AfterFunc(d, func() {
+			t.Event(EvtBreaker, TypInfo, "", "recovered")
+		})
+	}
+	t.breaker.mu.Unlock()
+
+	t.Event(EvtBreaker, TypError, "", "tripped : Reason[ %s ] Dur[ %v ]", reason, d)
+}
+
+// ResetBreaker returns the TCP value to accepting new connections.
+func (t *TCP) ResetBreaker() {
+	t.breaker.mu.Lock()
+	{
+		t.breaker.until = time.Time{}
+		if t.breaker.timer != nil {
+			t.breaker.timer.Stop()
+			t.breaker.timer = nil
+		}
+	}
+	t.breaker.mu.Unlock()
+
+	t.Event(EvtBreaker, TypInfo, "", "reset")
+}
+
+// stopBreaker stops the timer reporting the recovery of the breaker so it
+// doesn't fire once the TCP value is stopped.
+func (t *TCP) stopBreaker() {
+	t.breaker.mu.Lock()
+	{
+		if t.breaker.timer != nil {
+			t.breaker.timer.Stop()
+			t.breaker.timer = nil
+		}
+	}
+	t.breaker.mu.Unlock()
+}
+
+// Breaker returns the reason the breaker was tripped and whether it is
+// still rejecting new connections.
+func (t *TCP) Breaker() (string, bool) {
+	var reason string
+	var open bool
+
+	t.breaker.mu.Lock()
+	{
+		reason = t.breaker.reason
+		open = t.now().Before(t.breaker.until)
+	}
+	t.breaker.mu.Unlock()
+
+	return reason, open
+}
@@ -0,0 +1,50 @@
+package tcp
+
+import (
+	"context"
+	"time"
+)
+
+// Remaining returns the time left before the context's deadline. The
+// boolean is false when the context has no deadline.
+func Remaining(ctx context.Context) (time.Duration, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+
+	return time.Until(deadline), true
+}
+
+// Budget derives a context that is given the fraction of the time left
+// before the parent's deadline, such as 0.8 for the downstream calls of a
+// request, leaving the rest for writing the response. Without a deadline
+// on the parent, the child has no deadline either.
+func Budget(ctx context.Context, fraction float64) (context.Context, context.CancelFunc) {
+	remaining, ok := Remaining(ctx)
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+
+	if fraction < 0 {
+		fraction = 0
+	}
+	if fraction > 1 {
+		fraction = 1
+	}
+
+	return context.WithTimeout(ctx, time.Duration(float64(remaining)*fraction))
+}
+
+// Reserve derives a context whose deadline is the specified duration
+// before the parent's deadline, keeping that time in reserve for the work
+// that follows, such as writing the response. Without a deadline on the
+// parent, the child has no deadline either.
+func Reserve(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithDeadline(ctx, deadline.Add(-d))
+}
@@ -0,0 +1,47 @@
+package tcp_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/tcp"
+)
+
+// TestBudget tests child budgets are derived from the parent deadline.
+func TestBudget(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to split a request deadline into budgets.")
+	{
+		parent, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		ctx, cancel := tcp.Budget(parent, 0.5)
+		defer cancel()
+
+		remaining, ok := tcp.Remaining(ctx)
+		if !ok || remaining > 500*time.Millisecond || remaining < 400*time.Millisecond {
+			t.Fatal("\tShould get half of the remaining time.", failed, remaining)
+		}
+		t.Log("\tShould get half of the remaining time.", success)
+
+		ctx, cancel = tcp.Reserve(parent, 800*time.Millisecond)
+		defer cancel()
+
+		remaining, ok = tcp.Remaining(ctx)
+		if !ok || remaining > 200*time.Millisecond || remaining < 100*time.Millisecond {
+			t.Fatal("\tShould keep the reserved time for later.", failed, remaining)
+		}
+		t.Log("\tShould keep the reserved time for later.", success)
+
+		ctx, cancel = tcp.Budget(context.Background(), 0.5)
+		defer cancel()
+
+		if _, ok := tcp.Remaining(ctx); ok {
+			t.Fatal("\tShould not add a deadline when the parent has none.", failed)
+		}
+		t.Log("\tShould not add a deadline when the parent has none.", success)
+	}
+}
@@ -0,0 +1,74 @@
+package tcp
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// Buffer sizes are pooled by powers of two between these sizes.
+const (
+	minPoolShift = 6  // 64 bytes
+	maxPoolShift = 16 // 64k
+)
+
+// bufferPools holds a pool for each buffer size.
+var bufferPools [maxPoolShift - minPoolShift + 1]sync.Pool
+
+// poolIndex returns the pool for buffers with the capacity, or -1 when the
+// capacity is not one of the pooled sizes.
+func poolIndex(capacity int) int {
+	if capacity < 1<<minPoolShift || capacity > 1<<maxPoolShift || capacity&(capacity-1) != 0 {
+		return -1
+	}
+	return bits.TrailingZeros(uint(capacity)) - minPoolShift
+}
+
+// GetBuffer returns a buffer of the size from the pool. Buffers larger
+// than 64k are allocated and never pooled. Request and response data is
+// returned to the pool with Release.
+func GetBuffer(size int) []byte {
+	capacity := 1 << minPoolShift
+	for capacity < size {
+		capacity <<= 1
+	}
+
+	i := poolIndex(capacity)
+	if i < 0 {
+		return make([]byte, size)
+	}
+
+	if b, ok := bufferPools[i].Get().(*[]byte); ok {
+		return (*b)[:size]
+	}
+	return make([]byte, size, capacity)
+}
+
+// PutBuffer returns a buffer from GetBuffer to the pool. The buffer must
+// not be used afterwards.
+func PutBuffer(b []byte) {
+	i := poolIndex(cap(b))
+	if i < 0 {
+		return
+	}
+
+	b = b[:0]
+	bufferPools[i].Put(&b)
+}
+
+// Release returns the request data to the pool. When the TCP value is
+// configured with PoolBuffers, this happens once Process returns.
+func (r *Request) Release() {
+	if r.Data != nil {
+		PutBuffer(r.Data)
+		r.Data = nil
+	}
+}
+
+// Release returns the response data to the pool. When the TCP value is
+// configured with PoolBuffers, this happens once the response is written.
+func (r *Response) Release() {
+	if r.Data != nil {
+		PutBuffer(r.Data)
+		r.Data = nil
+	}
+}
@@ -0,0 +1,86 @@
+package tcp_test
+
+import (
+	"bufio"
+	"testing"
+
+	"github.com/ardanlabs/tcp"
+	"github.com/ardanlabs/tcp/tcptest"
+)
+
+// TestBuffers tests buffers are pooled by size.
+func TestBuffers(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to reuse request and response buffers.")
+	{
+		b := tcp.GetBuffer(100)
+		if len(b) != 100 || cap(b) != 128 {
+			t.Fatal("\tShould round the capacity up to a pooled size.", failed, len(b), cap(b))
+		}
+		t.Log("\tShould round the capacity up to a pooled size.", success)
+
+		r := tcp.Request{Data: b}
+		r.Release()
+		if r.Data != nil {
+			t.Fatal("\tShould clear the data on release.", failed)
+		}
+		t.Log("\tShould clear the data on release.", success)
+
+		if b := tcp.GetBuffer(1 << 20); len(b) != 1<<20 {
+			t.Fatal("\tShould allocate buffers too large to pool.", failed, len(b))
+		}
+		t.Log("\tShould allocate buffers too large to pool.", success)
+
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptBuffers: tcp.OptBuffers{
+				PoolBuffers: true,
+			},
+		})
+
+		c := s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("Hello"), []byte("GOT IT"))
+		c.RoundTrip([]byte("Hello"), []byte("GOT IT"))
+		t.Log("\tShould serve requests with the pool owning the buffers.", success)
+	}
+}
+
+// TestBufferSize tests connections are bound to pooled buffers of the
+// configured size.
+func TestBufferSize(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to tune the buffers of each connection.")
+	{
+		s := tcptest.NewServer(t, tcp.Config{
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptBufferSize: tcp.OptBufferSize{
+				ReadBufferSize:  128,
+				WriteBufferSize: 128,
+			},
+		})
+
+		for i := 0; i < 3; i++ {
+			c := s.Dial(t, tcptest.Lines)
+			c.RoundTrip([]byte("Hello"), []byte("GOT IT"))
+			c.Close()
+		}
+		t.Log("\tShould serve connections without a ConnHandler.", success)
+
+		h := tcp.BufferedConnHandler{ReadBufferSize: 128}
+		r, w := h.Bind(nil)
+		if r.(*bufio.Reader).Size() != 128 || w.(*bufio.Writer).Size() != 4096 {
+			t.Fatal("\tShould size the reader and writer.", failed)
+		}
+		h.Unbind(r, w)
+		t.Log("\tShould size the reader and writer.", success)
+	}
+}
@@ -0,0 +1,116 @@
+package tcp
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"sync"
+)
+
+// defBufferSize is the buffer size used when none is configured.
+const defBufferSize = 4096
+
+// Unbinder is implemented by connection handlers that want the reader and
+// writer back once the connection is closed or rebound, such as to return
+// them to a pool.
+type Unbinder interface {
+	Unbind(reader io.Reader, writer io.Writer)
+}
+
+// BufferedConnHandler binds connections to a bufio.Reader and bufio.Writer
+// of the configured sizes. The reader and writer are pooled so connections
+// reuse them instead of allocating new ones, so the RespHandler must flush
+// the writer. It's the ConnHandler used when the configuration sets buffer
+// sizes without a ConnHandler.
+type BufferedConnHandler struct {
+	ReadBufferSize  int // Defaults to 4k.
+	WriteBufferSize int // Defaults to 4k.
+}
+
+// bufioPools holds the reader and writer pools for each buffer size.
+var bufioPools struct {
+	mu      sync.Mutex
+	readers map[int]*sync.Pool
+	writers map[int]*sync.Pool
+}
+
+// bufioPool returns the pool for the size from the map.
+func bufioPool(pools *map[int]*sync.Pool, size int) *sync.Pool {
+	bufioPools.mu.Lock()
+	defer bufioPools.mu.Unlock()
+
+	if *pools == nil {
+		*pools = make(map[int]*sync.Pool)
+	}
+
+	p, ok := (*pools)[size]
+	if !ok {
+		p = &sync.Pool{}
+		(*pools)[size] = p
+	}
+
+	return p
+}
+
+// sizes returns the buffer sizes with the defaults applied.
+func (h BufferedConnHandler) sizes() (int, int) {
+	rs, ws := h.ReadBufferSize, h.WriteBufferSize
+	if rs <= 0 {
+		rs = defBufferSize
+	}
+	if ws <= 0 {
+		ws = defBufferSize
+	}
+	return rs, ws
+}
+
+// Bind implements the ConnHandler interface.
+func (h BufferedConnHandler) Bind(conn net.Conn) (io.Reader, io.Writer) {
+	rs, ws := h.sizes()
+
+	r, ok := bufioPool(&bufioPools.readers, rs).Get().(*bufio.Reader)
+	if ok {
+		r.Reset(conn)
+	} else {
+		r = bufio.NewReaderSize(conn, rs)
+	}
+
+	w, ok := bufioPool(&bufioPools.writers, ws).Get().(*bufio.Writer)
+	if ok {
+		w.Reset(conn)
+	} else {
+		w = bufio.NewWriterSize(conn, ws)
+	}
+
+	return r, w
+}
+
+// Unbind implements the Unbinder interface.
+func (h BufferedConnHandler) Unbind(reader io.Reader, writer io.Writer) {
+	rs, ws := h.sizes()
+
+	if r, ok := reader.(*bufio.Reader); ok && r.Size() == rs {
+		r.Reset(nil)
+		bufioPool(&bufioPools.readers, rs).Put(r)
+	}
+
+	if w, ok := writer.(*bufio.Writer); ok && w.Size() == ws {
+		w.Reset(nil)
+		bufioPool(&bufioPools.writers, ws).Put(w)
+	}
+}
+
+// unbind gives the reader and writer back to the connection handler when
+// it implements Unbinder. The caller must hold writeMu.
+func (c *client) unbind() {
+	if c.reader == nil && c.writer == nil {
+		return
+	}
+
+	if u, ok := c.handlers.ConnHandler.(Unbinder); ok {
+		u.Unbind(c.reader, c.writer)
+	}
+
+	c.reader = nil
+	c.writer = nil
+}
@@ -0,0 +1,317 @@
+package tcp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Default values for the client.
+const (
+	defMaxConns    = 4
+	defDialTimeout = 10 * time.Second
+)
+
+// ErrClientClosed is returned by Call once the client is closed.
+var ErrClientClosed = fmt.Errorf("client closed : %w", ErrShutdown)
+
+// ClientConfig provides the configuration for a Client. The handlers are
+// the ones written for the server with the roles reversed: the RespHandler
+// writes the requests and the ReqHandler reads the responses.
+type ClientConfig struct {
+	NetType string // "tcp", tcp4" or "tcp6"
+	Addr    string // "host:port" or "[ipv6-host%zone]:port"
+
+	ConnHandler ConnHandler // Binds each pooled connection to a reader and writer.
+	ReqHandler  ReqHandler  // Read is used to read the responses.
+	RespHandler RespHandler // Write is used to write the requests.
+
+	// *************************************************************************
+	// ** Not Required, optional                                              **
+	// *************************************************************************
+
+	MaxConns    int           // Pooled connections, defaults to 4.
+	DialTimeout time.Duration // Defaults to 10 seconds.
+}
+
+// Validate checks the configuration to required items.
+func (cfg *ClientConfig) Validate() error {
+	if cfg == nil {
+		return ErrInvalidConfiguration
+	}
+
+	if cfg.NetType != "tcp" && cfg.NetType != "tcp4" && cfg.NetType != "tcp6" {
+		return ErrInvalidNetType
+	}
+
+	if cfg.ConnHandler == nil {
+		return ErrInvalidConnHandler
+	}
+
+	if cfg.ReqHandler == nil {
+		return ErrInvalidReqHandler
+	}
+
+	if cfg.RespHandler == nil {
+		return ErrInvalidRespHandler
+	}
+
+	return nil
+}
+
+// Client calls a server over a pool of connections. Each call writes a
+// request and reads the response on a connection it has to itself.
+type Client struct {
+	ClientConfig
+
+	tokens chan struct{}
+
+	mu     sync.Mutex
+	idle   []*poolConn
+	closed bool
+}
+
+// poolConn is a pooled connection bound to its reader and writer.
+type poolConn struct {
+	conn   net.Conn
+	reader io.Reader
+	writer io.Writer
+}
+
+// NewClient creates a client for the server at the configured address.
+// Connections are dialed when they are first needed.
+func NewClient(cfg ClientConfig) (*Client, error) {
+
+	// Validate the configuration.
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	if cfg.MaxConns <= 0 {
+		cfg.MaxConns = defMaxConns
+	}
+
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = defDialTimeout
+	}
+
+	c := Client{
+		ClientConfig: cfg,
+		tokens:       make(chan struct{}, cfg.MaxConns),
+	}
+
+	return &c, nil
+}
+
+// CallOption changes how a call is made.
+type CallOption func(co *callOptions)
+
+// callOptions holds the options of a call.
+type callOptions struct {
+	hedgeDelay time.Duration
+}
+
+// WithHedge issues the request again on a second connection if no response
+// arrived after the delay. The first response is returned and the other
+// attempt is cancelled, which trades extra load for a shorter tail latency.
+func WithHedge(delay time.Duration) CallOption {
+	return func(co *callOptions) {
+		co.hedgeDelay = delay
+	}
+}
+
+// Call writes the request and returns the response read for it.
+func (c *Client) Call(ctx context.Context, data []byte, opts ...CallOption) ([]byte, error) {
+	var co callOptions
+	for _, opt := range opts {
+		opt(&co)
+	}
+
+	if co.hedgeDelay <= 0 {
+		return c.call(ctx, data)
+	}
+
+	return c.hedge(ctx, data, co.hedgeDelay)
+}
+
+// hedge races a second attempt against the first once the delay passes.
+func (c *Client) hedge(ctx context.Context, data []byte, delay time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		data []byte
+		err  error
+	}
+
+	results := make(chan result, 2)
+	attempt := func() {
+		resp, err := c.call(ctx, data)
+		results <- result{resp, err}
+	}
+
+	go attempt()
+	attempts := 1
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var err error
+	for received := 0; received < attempts; {
+		select {
+		case <-timer.C:
+			go attempt()
+			attempts++
+
+		case res := <-results:
+			received++
+			if res.err == nil {
+				return res.data, nil
+			}
+			err = res.err
+		}
+	}
+
+	return nil, err
+}
+
+// call makes one attempt on a pooled connection. The connection goes back
+// to the pool only when the exchange completes.
+func (c *Client) call(ctx context.Context, data []byte) ([]byte, error) {
+	pc, err := c.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Close the connection if the context is done mid-exchange, which
+	// unblocks the reads and writes.
+	stop := make(chan struct{})
+	watched := make(chan struct{})
+	go func() {
+		defer close(watched)
+		select {
+		case <-ctx.Done():
+			pc.conn.Close()
+		case <-stop:
+		}
+	}()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		pc.conn.SetDeadline(deadline)
+	} else {
+		pc.conn.SetDeadline(time.Time{})
+	}
+
+	resp, err := c.exchange(pc, data)
+
+	close(stop)
+	<-watched
+
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+
+	if err != nil {
+		c.discard(pc)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	c.release(pc)
+	return resp, nil
+}
+
+// exchange writes the request and reads the response.
+func (c *Client) exchange(pc *poolConn, data []byte) ([]byte, error) {
+	r := Response{
+		TCPAddr: pc.conn.RemoteAddr().(*net.TCPAddr),
+		Data:    data,
+		Length:  len(data),
+	}
+
+	if err := c.RespHandler.Write(&r, pc.writer); err != nil {
+		return nil, err
+	}
+
+	resp, _, err := c.ReqHandler.Read(pc.conn.RemoteAddr().String(), pc.reader)
+	return resp, err
+}
+
+// acquire returns an idle connection or dials a new one when the pool has
+// room. It waits for a connection to be released otherwise.
+func (c *Client) acquire(ctx context.Context) (*poolConn, error) {
+	select {
+	case c.tokens <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	c.mu.Lock()
+	{
+		if c.closed {
+			c.mu.Unlock()
+			<-c.tokens
+			return nil, ErrClientClosed
+		}
+
+		if n := len(c.idle); n > 0 {
+			pc := c.idle[n-1]
+			c.idle = c.idle[:n-1]
+			c.mu.Unlock()
+			return pc, nil
+		}
+	}
+	c.mu.Unlock()
+
+	d := net.Dialer{Timeout: c.DialTimeout}
+	conn, err := d.DialContext(ctx, c.NetType, c.Addr)
+	if err != nil {
+		<-c.tokens
+		return nil, err
+	}
+
+	r, w := c.ConnHandler.Bind(conn)
+	return &poolConn{conn: conn, reader: r, writer: w}, nil
+}
+
+// release returns the connection to the pool.
+func (c *Client) release(pc *poolConn) {
+	c.mu.Lock()
+	{
+		if c.closed {
+			pc.conn.Close()
+		} else {
+			c.idle = append(c.idle, pc)
+		}
+	}
+	c.mu.Unlock()
+
+	<-c.tokens
+}
+
+// discard closes a connection that can't be reused.
+func (c *Client) discard(pc *poolConn) {
+	pc.conn.Close()
+	<-c.tokens
+}
+
+// Close closes the idle connections. Connections in use are closed when
+// their calls return.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	{
+		c.closed = true
+		for _, pc := range c.idle {
+			pc.conn.Close()
+		}
+		c.idle = nil
+	}
+	c.mu.Unlock()
+
+	return nil
+}
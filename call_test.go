@@ -0,0 +1,82 @@
+package tcp_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/tcp"
+)
+
+// TestClientHedge tests a slow call is hedged on a second connection.
+func TestClientHedge(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to cut the tail latency of calls.")
+	{
+		cfg := tcp.Config{
+			NetType:     "tcp4",
+			Addr:        "127.0.0.1:0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  &stallReqHandler{},
+			RespHandler: tcpRespHandler{},
+		}
+
+		u, err := tcp.New("TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+		if err := u.Start(); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", failed, err)
+		}
+		defer u.Stop()
+
+		c, err := tcp.NewClient(tcp.ClientConfig{
+			NetType:     "tcp4",
+			Addr:        u.Addr().String(),
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+		})
+		if err != nil {
+			t.Fatal("\tShould be able to create a client.", failed, err)
+		}
+		defer c.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		start := time.Now()
+		resp, err := c.Call(ctx, []byte("Hello\n"), tcp.WithHedge(20*time.Millisecond))
+		if err != nil || string(resp) != "GOT IT\n" {
+			t.Fatal("\tShould receive the response.", failed, string(resp), err)
+		}
+		t.Log("\tShould receive the response.", success)
+
+		if d := time.Since(start); d >= 500*time.Millisecond {
+			t.Fatal("\tShould answer with the hedged attempt.", failed, d)
+		}
+		t.Log("\tShould answer with the hedged attempt.", success)
+
+		if resp, err := c.Call(ctx, []byte("Hello\n")); err != nil || string(resp) != "GOT IT\n" {
+			t.Fatal("\tShould reuse the pooled connection.", failed, string(resp), err)
+		}
+		t.Log("\tShould reuse the pooled connection.", success)
+	}
+}
+
+// stallReqHandler stalls the first request it processes.
+type stallReqHandler struct {
+	tcpReqHandler
+	n int32
+}
+
+// Process is used to handle the processing of the message.
+func (h *stallReqHandler) Process(r *tcp.Request) {
+	if atomic.AddInt32(&h.n, 1) == 1 {
+		time.Sleep(time.Second)
+	}
+	h.tcpReqHandler.Process(r)
+}
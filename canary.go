@@ -0,0 +1,98 @@
+package tcp
+
+import (
+	"sync/atomic"
+)
+
+// Names of the handler sets a connection can be assigned to.
+const (
+	SetStable = "stable"
+	SetCanary = "canary"
+)
+
+// setMetrics maintains the counters of a handler set. All fields are
+// accessed atomically.
+type setMetrics struct {
+	connections int64
+	active      int64
+	requests    int64
+	readErrors  int64
+	writeErrors int64
+}
+
+// SetMetrics represents the statistics of the connections assigned to a
+// handler set.
+type SetMetrics struct {
+	Connections int64 // Connections assigned to the set since the start.
+	Active      int64 // Connections assigned to the set still open.
+	Requests    int64
+	ReadErrors  int64
+	WriteErrors int64
+}
+
+// canary assigns new connections between the stable and canary handler
+// sets. All fields are accessed atomically.
+type canary struct {
+	percent int32
+	conns   int64
+	stable  setMetrics
+	canary  setMetrics
+}
+
+// SetCanaryPercent changes the percentage of new connections assigned to
+// the canary handlers. Open connections keep the handlers they have.
+func (t *TCP) SetCanaryPercent(percent int) {
+	if percent < 0 {
+		percent = 0
+	}
+	if percent > 100 {
+		percent = 100
+	}
+
+	atomic.StoreInt32(&t.canary.percent, int32(percent))
+	t.serverEvent(EvtAccept, TypInfo, join(t.ipAddress, t.port), "canary percent : %d", percent)
+}
+
+// CanaryPercent returns the percentage of new connections assigned to the
+// canary handlers.
+func (t *TCP) CanaryPercent() int {
+	return int(atomic.LoadInt32(&t.canary.percent))
+}
+
+// HandlerSetMetrics returns the statistics of the stable and canary
+// handler sets, keyed by SetStable and SetCanary.
+func (t *TCP) HandlerSetMetrics() map[string]SetMetrics {
+	return map[string]SetMetrics{
+		SetStable: t.canary.stable.snapshot(),
+		SetCanary: t.canary.canary.snapshot(),
+	}
+}
+
+// snapshot reads the counters.
+func (m *setMetrics) snapshot() SetMetrics {
+	return SetMetrics{
+		Connections: atomic.LoadInt64(&m.connections),
+		Active:      atomic.LoadInt64(&m.active),
+		Requests:    atomic.LoadInt64(&m.requests),
+		ReadErrors:  atomic.LoadInt64(&m.readErrors),
+		WriteErrors: atomic.LoadInt64(&m.writeErrors),
+	}
+}
+
+// pickSet returns the handlers for a new connection and the counters of
+// the set they belong to. Connections are spread evenly so any run of 100
+// connections has the configured percentage assigned to the canary.
+func (t *TCP) pickSet() (HandlerSet, *setMetrics) {
+	handlers := t.handlers()
+	if t.Canary == nil {
+		return handlers, &t.canary.stable
+	}
+
+	p := int64(atomic.LoadInt32(&t.canary.percent))
+	n := atomic.AddInt64(&t.canary.conns, 1)
+	if n*p/100 == (n-1)*p/100 {
+		return handlers, &t.canary.stable
+	}
+
+	return t.Canary.merge(handlers), &t.canary.canary
+}
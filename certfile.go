@@ -0,0 +1,78 @@
+package tcp
+
+import (
+	"os"
+	"time"
+)
+
+// defCertCheckEvery is the time between checks of the certificate files.
+const defCertCheckEvery = time.Minute
+
+// certStamp identifies a version of the certificate files.
+type certStamp struct {
+	certMod  time.Time
+	certSize int64
+	keyMod   time.Time
+	keySize  int64
+}
+
+// loadCertFiles creates the store serving the certificate from the files
+// and returns the version of the files loaded.
+func loadCertFiles(certFile, keyFile string) (*CertStore, certStamp, error) {
+	stamp, err := statCertFiles(certFile, keyFile)
+	if err != nil {
+		return nil, certStamp{}, err
+	}
+
+	certs := NewCertStore()
+	if err := certs.AddFiles("", certFile, keyFile); err != nil {
+		return nil, certStamp{}, err
+	}
+
+	return certs, stamp, nil
+}
+
+// statCertFiles returns the current version of the certificate files.
+func statCertFiles(certFile, keyFile string) (certStamp, error) {
+	cert, err := os.Stat(certFile)
+	if err != nil {
+		return certStamp{}, err
+	}
+
+	key, err := os.Stat(keyFile)
+	if err != nil {
+		return certStamp{}, err
+	}
+
+	stamp := certStamp{
+		certMod:  cert.ModTime(),
+		certSize: cert.Size(),
+		keyMod:   key.ModTime(),
+		keySize:  key.Size(),
+	}
+
+	return stamp, nil
+}
+
+// checkCertFiles reloads the certificate once the files change. Renewals
+// often write the two files one after the other, so a pair that doesn't
+// load is tried again on the next check.
+func (t *TCP) checkCertFiles() {
+	stamp, err := statCertFiles(t.CertFile, t.KeyFile)
+	if err != nil {
+		t.serverEvent(EvtTLS, TypError, join(t.ipAddress, t.port), "certificate files : %v", err)
+		return
+	}
+
+	if stamp == t.certVersion {
+		return
+	}
+
+	if err := t.certs.Reload(); err != nil {
+		t.serverEvent(EvtTLS, TypError, join(t.ipAddress, t.port), "certificate reload : %v", err)
+		return
+	}
+
+	t.certVersion = stamp
+	t.serverEvent(EvtTLS, TypInfo, join(t.ipAddress, t.port), "certificate reloaded")
+}
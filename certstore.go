@@ -0,0 +1,169 @@
+package tcp
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// ErrNoCertificate is returned when the store has no certificate for the
+// server name requested.
+var ErrNoCertificate = errors.New("no certificate for the server name")
+
+// certFiles are the files a certificate was loaded from.
+type certFiles struct {
+	certFile string
+	keyFile  string
+}
+
+// CertStore maps the server names requested through SNI to certificates.
+// Its GetCertificate method is used as the GetCertificate hook of the
+// configuration. Certificates loaded from files can be reloaded without
+// a restart.
+type CertStore struct {
+	mu    sync.RWMutex
+	certs map[string]*tls.Certificate
+	files map[string]certFiles
+}
+
+// NewCertStore creates an empty certificate store.
+func NewCertStore() *CertStore {
+	s := CertStore{
+		certs: make(map[string]*tls.Certificate),
+		files: make(map[string]certFiles),
+	}
+
+	return &s
+}
+
+// Add sets the certificate for the server name. Names may start with a
+// wildcard label such as "*.example.com", and the empty name sets the
+// certificate used when no other name matches.
+func (s *CertStore) Add(name string, cert tls.Certificate) {
+	s.mu.Lock()
+	{
+		s.certs[strings.ToLower(name)] = &cert
+		delete(s.files, strings.ToLower(name))
+	}
+	s.mu.Unlock()
+}
+
+// AddFiles loads the certificate for the server name from PEM encoded
+// files and remembers the files so Reload can load them again.
+func (s *CertStore) AddFiles(name, certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("cert store : %s : %v", name, err)
+	}
+
+	s.mu.Lock()
+	{
+		s.certs[strings.ToLower(name)] = &cert
+		s.files[strings.ToLower(name)] = certFiles{certFile: certFile, keyFile: keyFile}
+	}
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Reload loads the certificates added with AddFiles again. A certificate
+// that fails to load keeps being served and its error is returned.
+func (s *CertStore) Reload() error {
+	files := make(map[string]certFiles)
+	s.mu.RLock()
+	{
+		for name, f := range s.files {
+			files[name] = f
+		}
+	}
+	s.mu.RUnlock()
+
+	var errs CltError
+	for name, f := range files {
+		cert, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cert store : %s : %v", name, err))
+			continue
+		}
+
+		s.mu.Lock()
+		{
+			// Skip names replaced while the files were loading.
+			if cur, ok := s.files[name]; ok && cur == f {
+				s.certs[name] = &cert
+			}
+		}
+		s.mu.Unlock()
+	}
+
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
+
+// ReloadOnSignal calls Reload every time the process receives one of the
+// signals, SIGHUP when none are specified. The errors are passed to the
+// report function when it's not nil. Calling the returned function stops
+// watching for the signals.
+func (s *CertStore) ReloadOnSignal(report func(err error), sigs ...os.Signal) (stop func()) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGHUP}
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ch:
+				if err := s.Reload(); err != nil && report != nil {
+					report(err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(done)
+		})
+	}
+}
+
+// GetCertificate returns the certificate for the server name requested in
+// the handshake. An exact name is preferred to a wildcard, and the default
+// certificate is returned when neither matches.
+func (s *CertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if cert, ok := s.certs[name]; ok && name != "" {
+		return cert, nil
+	}
+
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if cert, ok := s.certs["*"+name[i:]]; ok {
+			return cert, nil
+		}
+	}
+
+	if cert, ok := s.certs[""]; ok {
+		return cert, nil
+	}
+
+	return nil, ErrNoCertificate
+}
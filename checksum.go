@@ -0,0 +1,106 @@
+package tcp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync/atomic"
+)
+
+// ErrChecksum is matched by the errors of frames whose checksum doesn't
+// match their bytes.
+var ErrChecksum = errors.New("checksum mismatch")
+
+// crcTable is the Castagnoli table used for the checksum of the frames.
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checksumSize is the number of bytes of the checksum trailing each frame.
+const checksumSize = 4
+
+// ChecksumError is returned for a frame whose checksum doesn't match its
+// bytes. The frame was read whole, so the connection keeps being read
+// unless the codec closes on corruption.
+type ChecksumError struct {
+	Want  uint32 // Checksum trailing the frame.
+	Got   uint32 // Checksum of the bytes received.
+	Size  int
+	close bool
+}
+
+// Error implements the error interface for ChecksumError.
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("%v : Size[ %d ] Want[ %08x ] Got[ %08x ]", ErrChecksum, e.Size, e.Want, e.Got)
+}
+
+// Unwrap returns ErrChecksum.
+func (e *ChecksumError) Unwrap() error {
+	return ErrChecksum
+}
+
+// Temporary reports whether the connection can keep being read.
+func (e *ChecksumError) Temporary() bool {
+	return !e.close
+}
+
+// AppendChecksum appends the CRC32C of the frame to it, big endian, the way
+// ChecksumCodec expects frames to end. Clients use it to frame requests.
+func AppendChecksum(frame []byte) []byte {
+	return binary.BigEndian.AppendUint32(frame, crc32.Checksum(frame, crcTable))
+}
+
+// ChecksumCodec wraps the codec of a protocol to trail every frame with its
+// CRC32C, for links where the checksums of TCP have proven insufficient.
+// Requests whose checksum doesn't match are reported as corrupt and
+// skipped, or close the connection with CloseOnCorrupt.
+type ChecksumCodec[Req, Resp any] struct {
+	Codec          Codec[Req, Resp]
+	CloseOnCorrupt bool
+}
+
+// Read implements the Codec interface. The frame is returned without its
+// checksum.
+func (cc ChecksumCodec[Req, Resp]) Read(reader io.Reader) ([]byte, error) {
+	frame, err := cc.Codec.Read(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	var trailer [checksumSize]byte
+	if _, err := io.ReadFull(reader, trailer[:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	want := binary.BigEndian.Uint32(trailer[:])
+	if got := crc32.Checksum(frame, crcTable); got != want {
+		return nil, &ChecksumError{Want: want, Got: got, Size: len(frame), close: cc.CloseOnCorrupt}
+	}
+
+	return frame, nil
+}
+
+// Decode implements the Codec interface.
+func (cc ChecksumCodec[Req, Resp]) Decode(data []byte) (Req, error) {
+	return cc.Codec.Decode(data)
+}
+
+// Encode implements the Codec interface.
+func (cc ChecksumCodec[Req, Resp]) Encode(resp Resp) ([]byte, error) {
+	data, err := cc.Codec.Encode(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return AppendChecksum(data), nil
+}
+
+// corrupt reports a frame that failed its checksum.
+func (c *client) corrupt(err error) {
+	atomic.AddInt64(&c.t.metrics.corruptFrames, 1)
+	c.t.Event(EvtRead, TypError, c.ipAddress, "corrupt frame : %v", err)
+	c.strike(StrikeCorruptFrame)
+}
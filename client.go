@@ -3,46 +3,99 @@ package tcp
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"errors"
 	"io"
 	"net"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// defHandshakeTimeout is the time allowed for a TLS handshake.
+const defHandshakeTimeout = 10 * time.Second
+
 // client represents a single networked connection.
 type client struct {
 	t         *TCP
 	conn      net.Conn
 	ipAddress string
 	isIPv6    bool
+	identity  string
+	quota     Quota
+	quotaConn bool
+	handlers  HandlerSet
+	set       *setMetrics
+	tags      atomic.Pointer[[]taggedMetrics]
+	rw        net.Conn
+	tlsConn   *tls.Conn
+	prof      *profileConn
+	ra        *readAheadConn
+	batch     atomic.Pointer[batchConn]
+	tarpit    *tarpitConn
+	seq       sequencer
+	inflight  chan struct{}
+	jobs      sync.WaitGroup
 	reader    io.Reader
 	writer    io.Writer
+	writeMu   sync.Mutex
+	pendingMu sync.Mutex
+	pending   []func() error
+	closing   int32
+	draining  int32
+	goodbye   []byte
+	turn      int32
 	wg        sync.WaitGroup
 
+	writeClosed int32 // Set once the write side is closed.
+	peerClosed  int32 // Set once the client closed its write side.
+	halfDone    chan struct{}
+	halfOnce    sync.Once
+
+	dropped  chan struct{} // Closed once the connection is dropped.
+	dropOnce sync.Once
+
+	deadlineMu sync.Mutex
+	closeBy    time.Time // Read deadline of a connection being closed.
+
+	parked   int32   // Set while a poller waits on the connection.
+	poller   *poller // Poller the connection is parked on.
+	pollFD   int
+	reactive bool // Set while a poller serves the connection.
+
+	cancelStream context.CancelFunc // Cancels the context of a Stream.
+
+	peer atomic.Pointer[peerState] // Identity and TLS state for the stats.
+
+	proxy int32 // How the proxied connection moves its bytes.
+	prio  int32 // Priority of the connection.
+
+	buffered int64 // Bytes of the requests and responses buffered.
+
+	congestion  congestion
+	counts      countConn
+	stats       connStats
+	closeReason atomic.Pointer[CloseReason]
+
 	timeConn time.Time
-	lastAct  time.Time
-	nReads   int
-	nWrites  int
+	lastAct  int64 // Unix nanoseconds of the last read.
+	nReads   int64
+	nWrites  int64
 }
 
 // newClient creates a new client for an incoming connection.
-func newClient(t *TCP, conn net.Conn) *client {
-	now := time.Now().UTC()
+func newClient(t *TCP, conn net.Conn, acceptedAt time.Time) *client {
 	ipAddress := conn.RemoteAddr().String()
 
-	// Ask the user to bind the reader and writer they want to
-	// use for this connection.
-	r, w := t.ConnHandler.Bind(conn)
-
 	c := client{
 		t:         t,
 		conn:      conn,
 		ipAddress: ipAddress,
-		reader:    r,
-		writer:    w,
-		timeConn:  now,
-		lastAct:   now,
+		timeConn:  acceptedAt,
+		lastAct:   acceptedAt.UnixNano(),
+		halfDone:  make(chan struct{}),
+		dropped:   make(chan struct{}),
 	}
 
 	// Check to see if this connection is ipv6.
@@ -50,6 +103,9 @@ func newClient(t *TCP, conn net.Conn) *client {
 		c.isIPv6 = true
 	}
 
+	// Accept the connections of flagged IPs into the tarpit.
+	c.tarpit = newTarpitConn(t, conn)
+
 	// Launch a goroutine for this connection.
 	c.wg.Add(1)
 	go c.read()
@@ -57,76 +113,857 @@ func newClient(t *TCP, conn net.Conn) *client {
 	return &c
 }
 
+// stat returns the statistics of the connection.
+func (c *client) stat() Stat {
+	s := Stat{
+		IP:           c.ipAddress,
+		Tags:         c.tagNames(),
+		Reads:        int(atomic.LoadInt64(&c.nReads)),
+		Writes:       int(atomic.LoadInt64(&c.nWrites)),
+		BytesRead:    atomic.LoadInt64(&c.counts.read),
+		BytesWritten: atomic.LoadInt64(&c.counts.written),
+		TimeConn:     c.timeConn,
+		LastAct:      c.lastActivity(),
+		Proxy:        c.proxyMode(),
+		Priority:     c.priority(),
+		Buffered:     atomic.LoadInt64(&c.buffered),
+		Requests:     atomic.LoadInt64(&c.stats.requests),
+		Congestion:   c.congestionNow(),
+	}
+
+	// The identity and the TLS state are known once the connection is
+	// bound.
+	if ps := c.peer.Load(); ps != nil {
+		s.Identity = ps.identity
+		s.TLS = ps.tls
+	}
+
+	return s
+}
+
+// peerState is the identity and the TLS state of a connection as they were
+// last published. It's never modified once published.
+type peerState struct {
+	identity string
+	tls      *tls.ConnectionState
+}
+
+// publishPeer publishes the identity and the TLS state of the connection
+// so its stats are taken without waiting on a write. It's called as the
+// connection is bound and whenever they change.
+func (c *client) publishPeer() {
+	ps := peerState{
+		identity: c.identity,
+	}
+	if c.tlsConn != nil {
+		cs := c.tlsConn.ConnectionState()
+		ps.tls = &cs
+	}
+
+	c.peer.Store(&ps)
+}
+
+// touch records a read of the connection.
+func (c *client) touch() {
+	atomic.StoreInt64(&c.lastAct, c.t.now().UnixNano())
+	atomic.AddInt64(&c.nReads, 1)
+}
+
+// lastActivity returns the time of the last read of the connection.
+func (c *client) lastActivity() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.lastAct)).UTC()
+}
+
 // drop closes the client connection and read operation.
-func (c *client) drop() {
+func (c *client) drop(reason CloseReason) {
 
-	// Close the connection.
+	// Close the connection once the responses batched are written. A
+	// write blocked on a client that stopped reading is released by the
+	// close instead of being waited on.
+	c.setCloseReason(reason)
+	c.flushIdleBatch()
 	c.conn.Close()
+	c.dropOnce.Do(func() { close(c.dropped) })
+	c.stopStream()
+	if c.tarpit != nil {
+		c.tarpit.release()
+	}
+	c.wake()
+	c.endHalfOpen()
 	c.wg.Wait()
 
 	c.t.Event(EvtDrop, TypInfo, c.ipAddress, "connect dropped")
 }
 
+// bind performs the TLS handshake when configured and asks the user to
+// bind the reader and writer they want to use for this connection. This
+// happens on the connection's goroutine so a slow handshake never blocks
+// the accept routine.
+func (c *client) bind() error {
+	conn := c.conn
+	if c.tarpit != nil {
+		conn = c.tarpit
+	}
+	c.keepAlive()
+	handlers, set := c.t.pickSet()
+
+	// Tag the connection before anything is read from it.
+	var tags []string
+	if c.t.Tags != nil {
+		tags = c.t.Tags(conn)
+	}
+
+	// Ask the geo policy about the client before anything is read.
+	if c.t.GeoPolicy != nil {
+		geoTags, err := c.geo()
+		if err != nil {
+			return err
+		}
+		tags = append(tags, geoTags...)
+	}
+
+	// Read ahead on streaming connections below any TLS layer.
+	if c.t.ReadAhead > 0 && (c.t.Streaming == nil || c.t.Streaming(conn)) {
+		c.ra = newReadAheadConn(conn, c.t.ReadAhead)
+		conn = c.ra
+	}
+
+	// Track when data arrives below any TLS layer for the profiler.
+	if c.t.Profiling {
+		c.prof = &profileConn{Conn: conn}
+		conn = c.prof
+	}
+
+	// Count the bytes below any TLS layer for the stats.
+	c.counts.Conn = conn
+	conn = &c.counts
+
+	// Sniff the protocol below any TLS layer, which the route decides.
+	useTLS := c.t.TLSConfig != nil
+	if len(c.t.Sniff) > 0 {
+		route, sc, err := c.sniff(conn)
+		if err != nil {
+			return err
+		}
+
+		conn = sc
+		handlers = route.Handlers.merge(handlers)
+		useTLS = route.TLS
+	}
+
+	if useTLS {
+		tlsConn, err := c.handshake(conn, c.t.TLSConfig)
+		if err != nil {
+			return err
+		}
+
+		// Select the handlers registered for the negotiated protocol.
+		if hs, ok := c.t.Protocols[tlsConn.ConnectionState().NegotiatedProtocol]; ok {
+			handlers = hs.merge(handlers)
+		}
+
+		conn = tlsConn
+	}
+
+	// Batch the small writes above any TLS layer so they are
+	// encrypted and written together.
+	if c.t.BatchDelay > 0 {
+		bc := newBatchConn(c.t, conn)
+		c.batch.Store(bc)
+		conn = bc
+	}
+
+	// Copy the bytes above any TLS layer to the tap.
+	if c.t.tapped() {
+		conn = &tapConn{Conn: conn, t: c.t, ipAddress: c.t.anonymize(c.ipAddress)}
+	}
+
+	// Select the virtual server hosting the connection.
+	var err error
+	conn, handlers, err = c.virtual(conn, handlers)
+	if err != nil {
+		return err
+	}
+
+	// Compress the bytes the handlers read and write.
+	if conn, err = c.compress(conn); err != nil {
+		return err
+	}
+
+	c.t.metrics.acceptLatency(c.t.now().Sub(c.timeConn))
+	r, w := handlers.ConnHandler.Bind(conn)
+
+	atomic.AddInt64(&set.connections, 1)
+	atomic.AddInt64(&set.active, 1)
+
+	// Add the tags from the handlers bound to the connection.
+	if tagger, ok := handlers.ConnHandler.(Tagger); ok {
+		tags = append(tags, tagger.Tags(conn)...)
+	}
+
+	var tms []taggedMetrics
+	if tags = uniqueTags(tags); len(tags) > 0 {
+		tms = c.t.tag(tags)
+	}
+	atomic.StoreInt32(&c.prio, int32(c.t.priorityOf(tags)))
+
+	c.tags.Store(&tms)
+
+	c.writeMu.Lock()
+	{
+		c.handlers = handlers
+		c.set = set
+		c.rw = conn
+		c.reader = r
+		c.writer = w
+	}
+	c.writeMu.Unlock()
+
+	c.publishPeer()
+
+	return nil
+}
+
+// handshake performs the server side of a TLS handshake over the
+// connection and lets the user reject or identify the peer before any
+// request is processed.
+func (c *client) handshake(conn net.Conn, cfg *tls.Config) (*tls.Conn, error) {
+	tlsConn := tls.Server(conn, cfg)
+
+	tlsConn.SetDeadline(time.Now().Add(c.t.handshakeTimeout()))
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+
+	if c.t.VerifyPeer != nil {
+		identity, err := c.t.VerifyPeer(c.ipAddress, tlsConn.ConnectionState())
+		if err != nil {
+			return nil, err
+		}
+		c.identity = identity
+	}
+
+	c.tlsConn = tlsConn
+	return tlsConn, nil
+}
+
+// schedule registers a function to run on the connection's goroutine once
+// the request being processed returns. This is how the reader and writer
+// are swapped safely in the middle of a connection.
+func (c *client) schedule(fn func() error) {
+	c.pendingMu.Lock()
+	{
+		c.pending = append(c.pending, fn)
+	}
+	c.pendingMu.Unlock()
+}
+
+// runPending runs the functions registered with schedule.
+func (c *client) runPending() error {
+	var pending []func() error
+	c.pendingMu.Lock()
+	{
+		pending = c.pending
+		c.pending = nil
+	}
+	c.pendingMu.Unlock()
+
+	for _, fn := range pending {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// write delivers the response through the response handler. Writes are
+// serialized since the writer belongs to a single connection. Failures are
+// handed to OnWriteError, which decides what happens next.
+func (c *client) write(r *Response) error {
+	done := c.trackWrite(r.Length)
+	defer done()
+
+	end := c.throttle()
+	defer end()
+
+	for attempt := 1; ; attempt++ {
+		werr := c.writeOnce(r)
+		if werr == nil {
+			return nil
+		}
+		werr.Attempt = attempt
+
+		if c.t.OnWriteError == nil {
+			return werr
+		}
+
+		switch c.t.OnWriteError(r, werr) {
+		case WriteRetry:
+			continue
+
+		case WriteClose:
+			c.t.Event(EvtWrite, TypInfo, c.ipAddress, "closing : %v", werr)
+			if c.t.Classify(werr) == ErrDeadline {
+				c.setCloseReason(CloseWriteTimeout)
+			} else {
+				c.setCloseReason(CloseWriteError)
+			}
+			c.conn.Close()
+			c.wake()
+		}
+
+		return werr
+	}
+}
+
+// writeOnce makes one attempt to write the response within the write
+// timeout.
+func (c *client) writeOnce(r *Response) *WriteError {
+	var set *setMetrics
+	var written int64
+	var err error
+
+	c.writeMu.Lock()
+	{
+		set = c.set
+		switch {
+		case c.writer == nil:
+			err = errors.New("connection is not ready")
+		case atomic.LoadInt32(&c.writeClosed) == 1:
+			err = ErrWriteClosed
+		default:
+			timeout := c.t.writeTimeout()
+			if timeout > 0 {
+				c.rw.SetWriteDeadline(time.Now().Add(timeout))
+			}
+
+			before := atomic.LoadInt64(&c.counts.written)
+			c.t.profile(PhaseWrite, "", func() {
+				if vh, ok := c.handlers.RespHandler.(VectorRespHandler); ok {
+					err = c.writeVector(vh, r)
+				} else {
+					err = c.handlers.RespHandler.Write(r, c.writer)
+				}
+				if err == nil && r.Body != nil {
+					err = c.stream(r.Body)
+				}
+			})
+			written = atomic.LoadInt64(&c.counts.written) - before
+
+			if timeout > 0 {
+				c.rw.SetWriteDeadline(time.Time{})
+			}
+		}
+	}
+	c.writeMu.Unlock()
+
+	if err == nil {
+		atomic.AddInt64(&c.stats.responses, 1)
+		return nil
+	}
+
+	// The client that half closed the connection is gone.
+	if atomic.LoadInt32(&c.peerClosed) == 1 {
+		c.endHalfOpen()
+	}
+
+	atomic.AddInt64(&c.stats.writeErrors, 1)
+	atomic.AddInt64(&c.t.metrics.writeErrors, 1)
+	if set != nil {
+		atomic.AddInt64(&set.writeErrors, 1)
+	}
+	for _, tm := range c.tagMetrics() {
+		atomic.AddInt64(&tm.writeErrors, 1)
+	}
+
+	return &WriteError{
+		TCPAddr: c.conn.RemoteAddr().(*net.TCPAddr),
+		Written: written,
+		Err:     err,
+	}
+}
+
+// stream copies the body to the connection once anything the writer has
+// buffered is flushed. The copy goes straight to the connection so the
+// runtime can use sendfile or splice when the body is a file or socket.
+func (c *client) stream(body io.Reader) error {
+	if closer, ok := body.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	// flusher is declared to test for the existence of the method
+	// coming from the bufio package.
+	type flusher interface {
+		Flush() error
+	}
+
+	if f, ok := c.writer.(flusher); ok {
+		if err := f.Flush(); err != nil {
+			return err
+		}
+	}
+
+	_, err := io.Copy(c.rw, body)
+	return err
+}
+
+// copyBody copies the n bytes following the request from the reader
+// bound to the connection, then from the connection itself.
+func (c *client) copyBody(w io.Writer, n int64) (int64, error) {
+
+	// buffered is declared to test for the existence of the method
+	// coming from the bufio package.
+	type buffered interface {
+		Buffered() int
+	}
+
+	b, ok := c.reader.(buffered)
+	if !ok {
+		return io.CopyN(w, c.reader, n)
+	}
+
+	ahead := int64(b.Buffered())
+	if ahead > n {
+		ahead = n
+	}
+
+	copied, err := io.CopyN(w, c.reader, ahead)
+	if err != nil {
+		return copied, err
+	}
+
+	rest, err := io.CopyN(w, c.rw, n-copied)
+	return copied + rest, err
+}
+
+// goAway writes the message and closes the connection gracefully. The
+// write side is closed so the client sees the end of the stream after
+// the message, and the connection is dropped once the client closes its
+// side or the grace period ends.
+func (c *client) goAway(msg []byte, grace time.Duration) error {
+	if !atomic.CompareAndSwapInt32(&c.closing, 0, 1) {
+		return nil
+	}
+	c.setCloseReason(CloseGoAway)
+
+	if msg != nil {
+		r := Response{
+			TCPAddr: c.conn.RemoteAddr().(*net.TCPAddr),
+			Data:    msg,
+			Length:  len(msg),
+		}
+
+		if err := c.write(&r); err != nil {
+			c.conn.Close()
+			c.wake()
+			return err
+		}
+	}
+
+	// closeWriter is declared to test for the existence of the
+	// method coming from the net and tls packages.
+	type closeWriter interface {
+		CloseWrite() error
+	}
+
+	var conn net.Conn
+	c.writeMu.Lock()
+	{
+		conn = c.rw
+	}
+	c.writeMu.Unlock()
+
+	if cw, ok := conn.(closeWriter); ok {
+		cw.CloseWrite()
+	}
+
+	err := c.setCloseBy(time.Now().Add(grace))
+	c.wake()
+
+	return err
+}
+
+// setCloseBy sets the read deadline the connection being closed must
+// notice it by.
+func (c *client) setCloseBy(d time.Time) error {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+
+	c.closeBy = d
+	return c.conn.SetReadDeadline(d)
+}
+
+// setReadDeadline sets the read deadline of the connection, keeping the
+// deadline of a connection being closed when it's earlier.
+func (c *client) setReadDeadline(d time.Time) error {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+
+	if !c.closeBy.IsZero() && (d.IsZero() || c.closeBy.Before(d)) {
+		d = c.closeBy
+	}
+	return c.conn.SetReadDeadline(d)
+}
+
 // read waits for a message and sends it to the user for procesing.
 func (c *client) read() {
+	if err := c.bind(); err != nil {
+		c.t.Event(EvtTLS, TypError, c.ipAddress, "bind : %v", err)
+		c.setCloseReason(CloseBindError)
+		c.t.remove(c.conn)
+		if c.ra != nil {
+			c.ra.Close()
+		}
+		reason := c.closed()
+		c.summarize(nil, reason)
+		c.wg.Done()
+		c.t.Event(EvtDrop, TypTrigger, c.ipAddress, "dropped connection : Reason[ %s ]", reason)
+		return
+	}
+
 	c.t.Event(EvtRead, TypTrigger, c.ipAddress, "ready")
 
-close:
+	// Tunnel the connection to the host of its CONNECT request.
+	if c.t.Connect {
+		c.connect()
+		c.setCloseReason(CloseStreamEnded)
+		c.finish()
+		return
+	}
+
+	// Forward the connection instead of reading requests when proxying.
+	if c.t.proxying() {
+		c.forward()
+		c.setCloseReason(CloseStreamEnded)
+		c.finish()
+		return
+	}
+
+	// Authenticate the client before anything else is read.
+	if c.t.AuthHandler != nil && !c.authenticate() {
+		c.finish()
+		return
+	}
+
+	// Hold the client to the quota of its identity.
+	if c.t.Quotas != nil && !c.admitQuota() {
+		c.finish()
+		return
+	}
+
+	// Publish the identity of the client so it can be pushed to.
+	c.register()
+
+	// Hand the connection to the stream handler when configured.
+	if c.t.StreamHandler != nil {
+		c.serveStream()
+		c.setCloseReason(CloseStreamEnded)
+		c.finish()
+		return
+	}
+
+	if c.t.Pipeline > 0 {
+		c.inflight = make(chan struct{}, c.t.Pipeline)
+	}
+
+	c.serve(false)
+}
+
+// serve reads and processes the requests of the connection until it's
+// closed or parked. A connection that became readable is read before it
+// can be parked again.
+func (c *client) serve(ready bool) {
 	for {
+		if !ready && c.park() {
+			return
+		}
+		ready = false
 
-		// Wait for a message to arrive.
-		data, length, err := c.t.ReqHandler.Read(c.ipAddress, c.reader)
-		c.lastAct = time.Now().UTC()
-		c.nReads++
+		if c.step() {
+			break
+		}
+	}
 
-		if err != nil {
+	c.finish()
+}
 
-			// temporary is declared to test for the existence of
-			// the method coming from the net package.
-			type temporary interface {
-				Temporary() bool
-			}
+// step reads and processes the next request. It reports whether the
+// connection must be closed.
+func (c *client) step() bool {
 
-			if e, ok := err.(temporary); ok {
-				if !e.Temporary() {
-					break close
-				}
+	// A drained connection reads no more requests.
+	if atomic.LoadInt32(&c.draining) == 1 {
+		return true
+	}
+
+	// Hold back reading while the connection buffers too much.
+	if c.t.memoryLimited() {
+		if err := c.reserve(); err != nil {
+			c.t.Event(EvtRead, TypError, c.ipAddress, "%v", err)
+			return true
+		}
+	}
+
+	// Wait for a message to arrive.
+	var data []byte
+	var length int
+	var err error
+	if c.prof != nil {
+		data, length, err = c.readProfiled()
+	} else {
+		data, length, err = c.handlers.ReqHandler.Read(c.ipAddress, c.reader)
+	}
+	if err == nil {
+		err = c.checkSize(length)
+		if cc, ok := c.rw.(*compressConn); ok {
+			cc.resetRead()
+		}
+	}
+	c.touch()
+
+	if err != nil {
+
+		// A connection being closed gracefully stops reading on
+		// the first error, including the grace period ending.
+		if atomic.LoadInt32(&c.closing) == 1 {
+			return true
+		}
+
+		// A client that stopped answering the keepalive probes is
+		// gone, which is not an error of the protocol.
+		if peerDead(err) {
+			c.t.Event(EvtRead, TypError, c.ipAddress, "keepalive : peer dead : %v", err)
+			c.setCloseReason(CloseKeepAlive)
+			return true
+		}
+
+		if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+			atomic.AddInt64(&c.stats.readErrors, 1)
+			atomic.AddInt64(&c.t.metrics.readErrors, 1)
+			atomic.AddInt64(&c.set.readErrors, 1)
+			for _, tm := range c.tagMetrics() {
+				atomic.AddInt64(&tm.readErrors, 1)
 			}
+		}
+
+		// Report the frames that arrived corrupt.
+		if errors.Is(err, ErrChecksum) {
+			c.corrupt(err)
+		}
+
+		// A poller can't wait on the rest of a message that's late.
+		if c.reactive && c.t.Classify(err) == ErrDeadline {
+			c.t.Event(EvtRead, TypError, c.ipAddress, "poller read : %v", err)
+			c.setCloseReason(CloseReadTimeout)
+			return true
+		}
 
-			if err == io.EOF {
-				break close
+		// A frame too large leaves the stream in an unknown state
+		// so the connection can't be read any further.
+		if class := c.t.Classify(err); class == ErrFrameTooLarge {
+			c.rejectFrame(err)
+			return true
+		}
+
+		// temporary is declared to test for the existence of
+		// the method coming from the net package.
+		type temporary interface {
+			Temporary() bool
+		}
+
+		if e, ok := err.(temporary); ok {
+			if !e.Temporary() {
+				c.setCloseReason(c.t.readCloseReason(err))
+				return true
 			}
+		}
 
-			continue
+		if err == io.EOF {
+			c.setCloseReason(CloseEOF)
+
+			// Keep writing the responses when the client only closed
+			// its write side.
+			if c.t.HalfOpen && atomic.LoadInt32(&c.draining) == 0 {
+				c.halfOpen()
+			}
+			return true
 		}
 
-		// Convert the IP:socket for populating TCPAddr value.
-		parts := bytes.Split([]byte(c.ipAddress), []byte(":"))
-		ipAddress := string(parts[0])
-		port, _ := strconv.Atoi(string(parts[1]))
+		return false
+	}
+
+	// Skip the request once the identity used up its quota.
+	if c.t.Quotas != nil && !c.checkQuota(length) {
+		return false
+	}
+
+	// Convert the IP:socket for populating TCPAddr value.
+	parts := bytes.Split([]byte(c.ipAddress), []byte(":"))
+	ipAddress := string(parts[0])
+	port, _ := strconv.Atoi(string(parts[1]))
+
+	tcpAddr := net.TCPAddr{
+		IP:   net.ParseIP(ipAddress),
+		Port: port,
+		Zone: c.t.tcpAddr.Zone,
+	}
+
+	// Start the span for this request. Handlers receive the span,
+	// the id of the request and the congestion of the client
+	// through the request context.
+	ctx, span := c.startRequestSpan(&tcpAddr, length)
+	ctx = c.requestDeadline(ctx)
+	ctx = context.WithValue(ctx, congestionKey{}, c)
+
+	id := newRequestID()
+	ctx = context.WithValue(ctx, requestIDKey{}, id)
 
-		// Create the request.
-		r := Request{
-			TCP: c.t,
-			TCPAddr: &net.TCPAddr{
-				IP:   net.ParseIP(ipAddress),
-				Port: port,
-				Zone: c.t.tcpAddr.Zone,
-			},
-			IsIPv6:  c.isIPv6,
-			ReadAt:  c.lastAct,
-			Context: context.Background(),
-			Data:    data,
-			Length:  length,
+	// Record the responses of the request for the access log.
+	if c.t.AccessLog != nil {
+		ctx = context.WithValue(ctx, accessKey{}, &access{})
+	}
+
+	// Create the request.
+	r := Request{
+		ID:       id,
+		TCP:      c.t,
+		TCPAddr:  &tcpAddr,
+		IsIPv6:   c.isIPv6,
+		Identity: c.identity,
+		Tags:     c.tagNames(),
+		ReadAt:   c.lastActivity(),
+		Context:  ctx,
+		Data:     data,
+		Length:   length,
+	}
+
+	// The request is buffered until it's processed.
+	c.account(length)
+
+	// Process pipelined requests on the worker pool. The
+	// responses are written in the order the requests were read.
+	if c.inflight != nil {
+		c.inflight <- struct{}{}
+		sl := c.seq.open(c)
+		sl.access, _ = ctx.Value(accessKey{}).(*access)
+		r.Context = context.WithValue(r.Context, slotKey{}, sl)
+
+		c.jobs.Add(1)
+		c.t.submit(c.priority(), func() {
+			c.process(&r, span)
+			c.seq.close(sl)
+			<-c.inflight
+			c.jobs.Done()
+		})
+	} else {
+
+		// Hand the turn to the server in half duplex mode.
+		if c.t.HalfDuplex && !c.requestTurn() {
+			c.account(-length)
+			if span != nil {
+				span.End()
+			}
+			c.setCloseReason(CloseTurnViolation)
+			return true
 		}
 
 		// Process the request on this goroutine that is
-		// handling the socket connection.
-		c.t.ReqHandler.Process(&r)
+		// handling the socket connection, which lets the
+		// handler copy a body following the request.
+		r.c = c
+		c.process(&r, span)
+		r.c = nil
+
+		if c.t.HalfDuplex {
+			c.endTurn()
+		}
+	}
+
+	// Apply any changes to the connection the request asked for.
+	if err := c.runPending(); err != nil {
+		c.t.Event(EvtRead, TypError, c.ipAddress, "%v", err)
+		c.setCloseReason(CloseHandlerError)
+		return true
+	}
+
+	return false
+}
+
+// finish releases the connection once it's closed.
+func (c *client) finish() {
+	// Wait for the pipelined requests to finish writing.
+	c.jobs.Wait()
+
+	// Say goodbye to drained connections.
+	if atomic.LoadInt32(&c.draining) == 1 {
+		c.sayGoodbye()
 	}
 
+	// Write the responses still waiting to be batched.
+	c.flushBatch()
+
 	// Remove from the list of connections and report we are done.
+	tags := c.tagNames()
+	c.t.untag(c)
+	if c.poller != nil {
+		c.poller.forget(c)
+	}
+	c.deregister()
+	c.releaseQuota()
 	c.t.remove(c.conn)
+	if c.ra != nil {
+		c.ra.Close()
+	}
+
+	c.writeMu.Lock()
+	{
+		c.unbind()
+	}
+	c.writeMu.Unlock()
+
+	reason := c.closed()
+	atomic.AddInt64(&c.set.active, -1)
+	c.summarize(tags, reason)
+
 	c.wg.Done()
-	c.t.Event(EvtDrop, TypTrigger, c.ipAddress, "dropped connection")
+	c.t.Event(EvtDrop, TypTrigger, c.ipAddress, "dropped connection : Reason[ %s ]", reason)
+}
+
+// process hands the request to the user and ends its span.
+func (c *client) process(r *Request, span Span) {
+	atomic.AddInt64(&c.stats.requests, 1)
+	atomic.AddInt64(&c.t.metrics.requests, 1)
+	atomic.AddInt64(&c.set.requests, 1)
+	atomic.AddInt64(&c.t.metrics.processing, 1)
+	start := c.t.now()
+	c.t.profile(PhaseProcess, "", func() {
+		c.handlers.ReqHandler.Process(r)
+	})
+	atomic.AddInt64(&c.t.metrics.processing, -1)
+	c.account(-r.Length)
+	took := c.t.now().Sub(start)
+	c.timedOut(r, took)
+	c.releaseDeadline(r)
+
+	// The responses of a pipelined request may still be held, so its
+	// entry is logged once they are written.
+	if a, ok := r.Context.Value(accessKey{}).(*access); ok {
+		e := c.accessEntry(r, took)
+		if sl, ok := r.Context.Value(slotKey{}).(*slot); ok && sl.c == c {
+			sl.entry = e
+		} else {
+			c.logAccess(e, a)
+		}
+	}
+
+	// The pool owns the buffer once the request is processed.
+	if c.t.PoolBuffers {
+		r.Release()
+	}
+
+	if span != nil {
+		span.End()
+	}
 }
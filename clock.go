@@ -0,0 +1,66 @@
+package tcp
+
+import "time"
+
+// Clock provides the time to a TCP value. Rate limiting, stats timestamps,
+// the breaker and the periodic routines use the clock, so tests can
+// control time instead of sleeping. Socket deadlines always use the system
+// clock since the runtime enforces them.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer represents a single event provided by a Clock.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// Ticker delivers ticks at intervals provided by a Clock.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// systemClock implements the Clock interface with the time package.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) NewTimer(d time.Duration) Timer { return systemTimer{time.NewTimer(d)} }
+
+func (systemClock) NewTicker(d time.Duration) Ticker { return systemTicker{time.NewTicker(d)} }
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return systemTimer{time.AfterFunc(d, f)}
+}
+
+// systemTimer adapts a time.Timer to the Timer interface.
+type systemTimer struct {
+	*time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time { return t.Timer.C }
+
+// systemTicker adapts a time.Ticker to the Ticker interface.
+type systemTicker struct {
+	*time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// clock returns the configured clock or the system clock.
+func (cfg *Config) clock() Clock {
+	if cfg.Clock == nil {
+		return systemClock{}
+	}
+	return cfg.Clock
+}
+
+// now returns the current time in UTC from the configured clock.
+func (cfg *Config) now() time.Time {
+	return cfg.clock().Now().UTC()
+}
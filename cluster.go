@@ -0,0 +1,249 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Registry publishes the identities connected to each server of a cluster,
+// such as through a key value store or a gossip protocol, so a response
+// can be pushed to a client connected to any of them.
+type Registry interface {
+
+	// Register records the identity is connected to the node.
+	Register(identity, node string) error
+
+	// Deregister records the identity is no longer connected to the node.
+	Deregister(identity, node string) error
+
+	// Lookup returns the nodes the identity is connected to.
+	Lookup(identity string) ([]string, error)
+}
+
+// identities indexes the connections of the TCP value by identity.
+type identities struct {
+	mu      sync.Mutex
+	clients map[string][]*client
+}
+
+// register indexes the connection by its identity and registers the
+// identity with the node when it's the first connection of the identity.
+func (c *client) register() {
+	if c.identity == "" {
+		return
+	}
+
+	var first bool
+	ids := &c.t.identities
+	ids.mu.Lock()
+	{
+		if ids.clients == nil {
+			ids.clients = make(map[string][]*client)
+		}
+		first = len(ids.clients[c.identity]) == 0
+		ids.clients[c.identity] = append(ids.clients[c.identity], c)
+	}
+	ids.mu.Unlock()
+
+	if first && c.t.Registry != nil {
+		if err := c.t.Registry.Register(c.identity, c.t.Node); err != nil {
+			c.t.Event(EvtCluster, TypError, c.ipAddress, "register : %s : %v", c.identity, err)
+		}
+	}
+}
+
+// deregister removes the connection from the index and deregisters the
+// identity from the node when it was the last connection of the identity.
+func (c *client) deregister() {
+	if c.identity == "" {
+		return
+	}
+
+	var last, found bool
+	ids := &c.t.identities
+	ids.mu.Lock()
+	{
+		clts := ids.clients[c.identity]
+		for i, clt := range clts {
+			if clt == c {
+				clts = append(clts[:i], clts[i+1:]...)
+				found = true
+				break
+			}
+		}
+
+		if last = found && len(clts) == 0; last {
+			delete(ids.clients, c.identity)
+		} else if found {
+			ids.clients[c.identity] = clts
+		}
+	}
+	ids.mu.Unlock()
+
+	if last && c.t.Registry != nil {
+		if err := c.t.Registry.Deregister(c.identity, c.t.Node); err != nil {
+			c.t.Event(EvtCluster, TypError, c.ipAddress, "deregister : %s : %v", c.identity, err)
+		}
+	}
+}
+
+// PushLocal delivers the response to the connections of the identity
+// served by this TCP value. It's what a node calls with the responses the
+// Forward hook of another node handed to it. The response must not have a
+// Body since it can be written to several connections.
+func (t *TCP) PushLocal(ctx context.Context, identity string, r *Response) error {
+	err := t.pushLocal(identity, r)
+
+	// The pool owns the buffer once the response is written.
+	if t.PoolBuffers {
+		r.Release()
+	}
+
+	return err
+}
+
+// pushLocal writes the response to the connections of the identity served
+// by this TCP value, like SendAll does for every connection.
+func (t *TCP) pushLocal(identity string, r *Response) error {
+	var clts []*client
+	t.identities.mu.Lock()
+	{
+		clts = append(clts, t.identities.clients[identity]...)
+	}
+	t.identities.mu.Unlock()
+
+	if len(clts) == 0 {
+		return fmt.Errorf("Identity[ %s ] : %w", identity, ErrDisconnected)
+	}
+
+	var errs []error
+	for _, c := range clts {
+		if err := c.replyTurn(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := c.write(r); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Push delivers the response to the connections of the identity wherever
+// they are in the cluster: the connections served by this TCP value get it
+// directly and the other nodes the registry has for the identity through
+// the Forward hook. Without a registry only the local connections are
+// pushed to.
+func (t *TCP) Push(ctx context.Context, identity string, r *Response) error {
+
+	// The pool owns the buffer once written here and forwarded.
+	if t.PoolBuffers {
+		defer r.Release()
+	}
+
+	err := t.pushLocal(identity, r)
+	if t.Registry == nil {
+		return err
+	}
+
+	var delivered bool
+	var errs []error
+	switch {
+	case err == nil:
+		delivered = true
+	case !errors.Is(err, ErrDisconnected):
+		errs = append(errs, err)
+	}
+
+	nodes, lerr := t.Registry.Lookup(identity)
+	if lerr != nil {
+		errs = append(errs, fmt.Errorf("lookup : %s : %w", identity, lerr))
+	}
+
+	for _, node := range nodes {
+		if node == t.Node {
+			continue
+		}
+
+		if ferr := t.Forward(ctx, node, identity, r); ferr != nil {
+			errs = append(errs, fmt.Errorf("forward : %s : %w", node, ferr))
+			continue
+		}
+		delivered = true
+	}
+
+	if !delivered && len(errs) == 0 {
+		return fmt.Errorf("Identity[ %s ] : %w", identity, ErrDisconnected)
+	}
+
+	return errors.Join(errs...)
+}
+
+// =============================================================================
+
+// MemoryRegistry keeps the registry in the memory of the process, for the
+// nodes of a cluster living in one process such as in tests.
+type MemoryRegistry struct {
+	mu    sync.Mutex
+	nodes map[string]map[string]int
+}
+
+// NewMemoryRegistry creates an empty registry.
+func NewMemoryRegistry() *MemoryRegistry {
+	r := MemoryRegistry{
+		nodes: make(map[string]map[string]int),
+	}
+
+	return &r
+}
+
+// Register implements the Registry interface.
+func (r *MemoryRegistry) Register(identity, node string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.nodes[identity] == nil {
+		r.nodes[identity] = make(map[string]int)
+	}
+	r.nodes[identity][node]++
+
+	return nil
+}
+
+// Deregister implements the Registry interface.
+func (r *MemoryRegistry) Deregister(identity, node string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	nodes, ok := r.nodes[identity]
+	if !ok {
+		return nil
+	}
+
+	if nodes[node]--; nodes[node] <= 0 {
+		delete(nodes, node)
+	}
+	if len(nodes) == 0 {
+		delete(r.nodes, identity)
+	}
+
+	return nil
+}
+
+// Lookup implements the Registry interface.
+func (r *MemoryRegistry) Lookup(identity string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var nodes []string
+	for node := range r.nodes[identity] {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	return nodes, nil
+}
@@ -0,0 +1,57 @@
+// Command tcpbench generates load against a running server built on the
+// tcp package and reports the throughput and latency percentiles.
+//
+//	tcpbench -addr localhost:6000 -conns 100 -rate 10000 -duration 30s
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/ardanlabs/tcp/tcpbench"
+	"github.com/ardanlabs/tcp/tcptest"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:6000", "address of the server")
+	conns := flag.Int("conns", 10, "connections sending requests at once")
+	rate := flag.Float64("rate", 0, "requests per second over all the connections, 0 as fast as possible")
+	duration := flag.Duration("duration", 10*time.Second, "time the run lasts")
+	timeout := flag.Duration("timeout", 5*time.Second, "time allowed for each request")
+	payload := flag.String("payload", "PING", "message of each request")
+	framing := flag.String("framing", "lines", "framing of the messages: lines or length")
+	flag.Parse()
+
+	cfg := tcpbench.Config{
+		Addr:     *addr,
+		Conns:    *conns,
+		Rate:     *rate,
+		Duration: *duration,
+		Timeout:  *timeout,
+		Payload:  func(conn, seq int) []byte { return []byte(*payload) },
+	}
+
+	switch *framing {
+	case "lines":
+		cfg.Framing = tcptest.Lines
+	case "length":
+		cfg.Framing = tcptest.LengthPrefixed
+	default:
+		fmt.Fprintf(os.Stderr, "tcpbench : unknown framing %q\n", *framing)
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	res, err := tcpbench.Run(ctx, cfg)
+	fmt.Println(res)
+	if err != nil && ctx.Err() == nil {
+		fmt.Fprintf(os.Stderr, "tcpbench : %v\n", err)
+		os.Exit(1)
+	}
+}
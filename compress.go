@@ -0,0 +1,283 @@
+package tcp
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// Set of compressions provided by the package. Others, such as snappy or
+// zstd, are added through the Compressors of the configuration.
+const (
+	CompressGzip    = "gzip"
+	CompressDeflate = "deflate"
+)
+
+// Compressor is implemented to compress the bytes of a connection. The
+// writer is flushed after every write to the connection so each response
+// reaches the client whole. A read of the compressed stream that fails,
+// such as on a deadline, closes the connection.
+type Compressor interface {
+	NewReader(r io.Reader) (io.Reader, error)
+	NewWriter(w io.Writer) (CompressWriter, error)
+}
+
+// CompressWriter is the writer of a Compressor. Close ends the compressed
+// stream without closing the connection.
+type CompressWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// GzipCompressor compresses with gzip at the level, which defaults to
+// gzip.DefaultCompression.
+type GzipCompressor struct {
+	Level int
+}
+
+// NewReader implements the Compressor interface.
+func (GzipCompressor) NewReader(r io.Reader) (io.Reader, error) {
+	return gzip.NewReader(r)
+}
+
+// NewWriter implements the Compressor interface.
+func (gc GzipCompressor) NewWriter(w io.Writer) (CompressWriter, error) {
+	level := gc.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	return gzip.NewWriterLevel(w, level)
+}
+
+// DeflateCompressor compresses with deflate at the level, which defaults to
+// flate.DefaultCompression.
+type DeflateCompressor struct {
+	Level int
+}
+
+// NewReader implements the Compressor interface.
+func (DeflateCompressor) NewReader(r io.Reader) (io.Reader, error) {
+	return flate.NewReader(r), nil
+}
+
+// NewWriter implements the Compressor interface.
+func (dc DeflateCompressor) NewWriter(w io.Writer) (CompressWriter, error) {
+	level := dc.Level
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	return flate.NewWriter(w, level)
+}
+
+// compressor returns the compressor registered under the name.
+func (cfg *Config) compressor(name string) (Compressor, bool) {
+	if cmp, ok := cfg.Compressors[name]; ok {
+		return cmp, true
+	}
+
+	switch name {
+	case CompressGzip:
+		return GzipCompressor{}, true
+	case CompressDeflate:
+		return DeflateCompressor{}, true
+	}
+
+	return nil, false
+}
+
+// =============================================================================
+
+// compressConn decompresses the bytes read from the connection and
+// compresses the bytes written to it. The reader is created on the first
+// read since the header of the stream is read with it.
+type compressConn struct {
+	net.Conn
+	cmp Compressor
+
+	readOnce sync.Once
+	r        io.Reader
+	rErr     error
+
+	max  int
+	read int
+
+	w CompressWriter
+}
+
+// newCompressConn wraps the connection with the compressor. The bytes
+// decompressed between two requests are capped at max, 0 disables.
+func newCompressConn(conn net.Conn, cmp Compressor, max int) (*compressConn, error) {
+	w, err := cmp.NewWriter(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	cc := compressConn{
+		Conn: conn,
+		cmp:  cmp,
+		w:    w,
+		max:  max,
+	}
+
+	return &cc, nil
+}
+
+// compressError reports the compressed stream can't be read any further.
+// Decompressors keep failing once a read failed, even on a deadline.
+type compressError struct {
+	err error
+}
+
+// Error implements the error interface for compressError.
+func (e *compressError) Error() string {
+	return "compression : " + e.err.Error()
+}
+
+// Unwrap returns the error of the decompressor.
+func (e *compressError) Unwrap() error {
+	return e.err
+}
+
+// Temporary reports the connection can't be read any further.
+func (e *compressError) Temporary() bool {
+	return false
+}
+
+// Read implements the io.Reader interface. A client closing the
+// connection in the middle of the stream fails the read with an error
+// matching io.ErrUnexpectedEOF. Decompressing more than MaxRequestBytes
+// since the last request fails the read with a FrameTooLargeError, so a
+// small stream can't expand without bound.
+func (cc *compressConn) Read(p []byte) (int, error) {
+	cc.readOnce.Do(func() {
+		cc.r, cc.rErr = cc.cmp.NewReader(cc.Conn)
+	})
+
+	if cc.max > 0 && len(p) > cc.max-cc.read+1 {
+		p = p[:cc.max-cc.read+1]
+	}
+
+	n, err := 0, cc.rErr
+	if err == nil {
+		n, err = cc.r.Read(p)
+	}
+
+	cc.read += n
+	if cc.max > 0 && cc.read > cc.max {
+		return 0, &FrameTooLargeError{Size: cc.read, Max: cc.max}
+	}
+
+	switch err {
+	case nil, io.EOF:
+		return n, err
+	}
+
+	return n, &compressError{err: err}
+}
+
+// resetRead starts counting the decompressed bytes of the next request.
+func (cc *compressConn) resetRead() {
+	cc.read = 0
+}
+
+// Write implements the io.Writer interface.
+func (cc *compressConn) Write(p []byte) (int, error) {
+	n, err := cc.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+
+	return n, cc.w.Flush()
+}
+
+// CloseWrite ends the compressed stream and closes the write side of the
+// connection.
+func (cc *compressConn) CloseWrite() error {
+
+	// closeWriter is declared to test for the existence of the
+	// method coming from the net and tls packages.
+	type closeWriter interface {
+		CloseWrite() error
+	}
+
+	cw, ok := cc.Conn.(closeWriter)
+	if !ok {
+		return ErrNoHalfClose
+	}
+
+	if err := cc.w.Close(); err != nil {
+		return err
+	}
+
+	return cw.CloseWrite()
+}
+
+// Compress switches the compression of the client connection to the
+// named compressor in response to a protocol command negotiating it. An
+// empty name turns compression off, ending the compressed stream. The
+// switch happens once the request being processed returns, so the
+// response agreeing to it is sent with the compression in use before. The
+// reader and writer are rebound through the ConnHandler, and bytes the
+// client sent ahead of the switch are lost.
+func (t *TCP) Compress(tcpAddr *net.TCPAddr, name string) error {
+	var cmp Compressor
+	if name != "" {
+		var ok bool
+		if cmp, ok = t.compressor(name); !ok {
+			return ErrInvalidCompression
+		}
+	}
+
+	c, err := t.client(tcpAddr)
+	if err != nil {
+		return err
+	}
+
+	c.schedule(func() error {
+		c.writeMu.Lock()
+		defer c.writeMu.Unlock()
+
+		conn := c.rw
+		if cc, ok := conn.(*compressConn); ok {
+			if err := cc.w.Close(); err != nil {
+				return err
+			}
+			conn = cc.Conn
+		}
+
+		if cmp != nil {
+			cc, err := newCompressConn(conn, cmp, t.MaxRequestBytes)
+			if err != nil {
+				return err
+			}
+			conn = cc
+		}
+
+		c.unbind()
+		c.rw = conn
+		c.reader, c.writer = c.handlers.ConnHandler.Bind(conn)
+
+		t.Event(EvtRead, TypInfo, c.ipAddress, "compression : Name[ %s ]", name)
+		return nil
+	})
+
+	return nil
+}
+
+// compress wraps the connection with the compression every connection
+// starts with.
+func (c *client) compress(conn net.Conn) (net.Conn, error) {
+	if c.t.Compression == "" {
+		return conn, nil
+	}
+
+	cmp, ok := c.t.compressor(c.t.Compression)
+	if !ok {
+		return nil, errors.New("compression : unknown compressor")
+	}
+
+	return newCompressConn(conn, cmp, c.t.MaxRequestBytes)
+}
@@ -0,0 +1,121 @@
+package tcp
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Default values for detecting congested clients.
+const (
+	defWriteStall  = 50 * time.Millisecond
+	defStallWindow = 10 * time.Second
+)
+
+// congestionKey is the context key for the client of a request.
+type congestionKey struct{}
+
+// congestion tracks how well a client keeps up with its responses. All
+// fields are accessed atomically.
+type congestion struct {
+	queued      int32
+	stalls      int32
+	lastStall   int64
+	stallAt     int64
+	queuedBytes int64
+	aboveHigh   int32
+}
+
+// Congestion describes how well a client keeps up with its responses, so
+// handlers can send less to clients that are falling behind.
+type Congestion struct {
+	Queued      int           // Responses waiting to be written.
+	QueuedBytes int           // Bytes of the responses waiting to be written.
+	Stalls      int           // Writes slower than WriteStall within the StallWindow.
+	LastStall   time.Duration // Time the latest of those writes took.
+}
+
+// Congested reports whether responses are queued or writes have stalled.
+func (cg Congestion) Congested() bool {
+	return cg.Queued > 0 || cg.Stalls > 0
+}
+
+// CongestionFrom returns the current congestion of the client that sent
+// the request with the context. The values are read when it's called, so
+// long running handlers can check again.
+func CongestionFrom(ctx context.Context) (Congestion, bool) {
+	if ctx == nil {
+		return Congestion{}, false
+	}
+
+	c, ok := ctx.Value(congestionKey{}).(*client)
+	if !ok {
+		return Congestion{}, false
+	}
+
+	return c.congestionNow(), true
+}
+
+// congestionNow returns the current congestion of the client.
+func (c *client) congestionNow() Congestion {
+	cg := Congestion{
+		Queued:      int(atomic.LoadInt32(&c.congestion.queued)),
+		QueuedBytes: int(atomic.LoadInt64(&c.congestion.queuedBytes)),
+		LastStall:   time.Duration(atomic.LoadInt64(&c.congestion.lastStall)),
+	}
+
+	// Stalls older than the window no longer count.
+	if c.t.now().Sub(c.lastStallAt()) < c.t.stallWindow() {
+		cg.Stalls = int(atomic.LoadInt32(&c.congestion.stalls))
+	}
+
+	return cg
+}
+
+// trackWrite records a write of n bytes that is about to start and returns
+// the function to call once it's done.
+func (c *client) trackWrite(n int) func() {
+	atomic.AddInt32(&c.congestion.queued, 1)
+	c.queueBytes(n)
+	start := c.t.now()
+
+	return func() {
+		atomic.AddInt32(&c.congestion.queued, -1)
+		c.queueBytes(-n)
+
+		d := c.t.now().Sub(start)
+		if d < c.t.writeStall() {
+			return
+		}
+
+		// Start counting again once the previous stalls are too old.
+		if c.t.now().Sub(c.lastStallAt()) >= c.t.stallWindow() {
+			atomic.StoreInt32(&c.congestion.stalls, 0)
+		}
+
+		atomic.AddInt32(&c.congestion.stalls, 1)
+		atomic.StoreInt64(&c.congestion.lastStall, int64(d))
+		atomic.StoreInt64(&c.congestion.stallAt, c.t.now().UnixNano())
+	}
+}
+
+// lastStallAt returns when the latest stall ended.
+func (c *client) lastStallAt() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.congestion.stallAt))
+}
+
+// writeStall returns the time a write takes to count as a stall.
+func (cfg *Config) writeStall() time.Duration {
+	if cfg.WriteStall > 0 {
+		return cfg.WriteStall
+	}
+	return defWriteStall
+}
+
+// stallWindow returns how long stalls count toward congestion.
+func (cfg *Config) stallWindow() time.Duration {
+	if cfg.StallWindow > 0 {
+		return cfg.StallWindow
+	}
+	return defStallWindow
+}
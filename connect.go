@@ -0,0 +1,98 @@
+package tcp
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// defConnectTimeout is the time allowed for the CONNECT request to arrive.
+const defConnectTimeout = 10 * time.Second
+
+// connect answers the HTTP CONNECT request the connection starts with and
+// tunnels it to the host requested, for clients that can only leave their
+// network through an HTTP proxy.
+func (c *client) connect() {
+	timeout := c.t.ConnectTimeout
+	if timeout <= 0 {
+		timeout = defConnectTimeout
+	}
+
+	br := bufio.NewReader(c.rw)
+
+	c.rw.SetReadDeadline(time.Now().Add(timeout))
+	req, err := http.ReadRequest(br)
+	c.rw.SetReadDeadline(time.Time{})
+
+	if err != nil {
+		c.t.Event(EvtProxy, TypError, c.ipAddress, "connect : %v", err)
+		c.refuseConnect(http.StatusBadRequest)
+		return
+	}
+
+	if req.Method != http.MethodConnect {
+		c.t.Event(EvtProxy, TypError, c.ipAddress, "connect : method %s", req.Method)
+		c.refuseConnect(http.StatusMethodNotAllowed)
+		return
+	}
+
+	addr := req.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		c.t.Event(EvtProxy, TypError, c.ipAddress, "connect : %v", err)
+		c.refuseConnect(http.StatusBadRequest)
+		return
+	}
+
+	if c.t.ConnectAllow == nil || !c.t.ConnectAllow(addr) {
+		c.t.Event(EvtProxy, TypError, c.ipAddress, "connect : %s : not allowed", addr)
+		c.refuseConnect(http.StatusForbidden)
+		return
+	}
+
+	// The tunnels are counted together since the hosts are the clients'.
+	us := &c.t.proxy.connect
+	atomic.AddInt64(&us.dials, 1)
+
+	upstream, err := c.t.proxyDial(addr)
+	if err != nil {
+		atomic.AddInt64(&us.dialErrors, 1)
+		c.t.Event(EvtProxy, TypError, c.ipAddress, "dial : %s : %v", addr, err)
+		c.replyConnect(http.StatusBadGateway)
+		c.setCloseReason(CloseUpstreamError)
+		return
+	}
+
+	if err := c.replyConnect(http.StatusOK); err != nil {
+		upstream.Close()
+		c.setCloseReason(CloseWriteError)
+		return
+	}
+
+	// Forward the bytes the client sent past the request.
+	if n := br.Buffered(); n > 0 {
+		b, _ := br.Peek(n)
+		if _, err := upstream.Write(b); err != nil {
+			upstream.Close()
+			c.setCloseReason(CloseUpstreamError)
+			return
+		}
+		atomic.AddInt64(&us.bytesUp, int64(n))
+	}
+
+	c.tunnel(upstream, us)
+}
+
+// replyConnect writes the status line answering the CONNECT request.
+func (c *client) replyConnect(code int) error {
+	_, err := fmt.Fprintf(c.rw, "HTTP/1.1 %d %s\r\n\r\n", code, http.StatusText(code))
+	return err
+}
+
+// refuseConnect answers a CONNECT request that can't be tunneled.
+func (c *client) refuseConnect(code int) {
+	c.replyConnect(code)
+	c.setCloseReason(CloseConnectRefused)
+}
@@ -0,0 +1,160 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// deadlineKey finds the deadline context of a request.
+type deadlineKey struct{}
+
+// deadlineCtx is the context of a request whose deadline can be moved
+// while the request is processed. It's canceled with DeadlineExceeded once
+// the deadline passes.
+type deadlineCtx struct {
+	context.Context
+	cancel context.CancelCauseFunc
+	clock  Clock
+
+	mu       sync.Mutex
+	deadline time.Time
+	timer    Timer
+}
+
+// withDeadline derives a deadline context from the parent that expires at
+// the specified time of the clock. A zero time leaves it without a
+// deadline.
+func withDeadline(parent context.Context, clock Clock, deadline time.Time) *deadlineCtx {
+	ctx, cancel := context.WithCancelCause(parent)
+	d := deadlineCtx{
+		Context: ctx,
+		cancel:  cancel,
+		clock:   clock,
+	}
+	d.setDeadline(deadline)
+
+	return &d
+}
+
+// Deadline implements the context.Context interface.
+func (d *deadlineCtx) Deadline() (time.Time, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.deadline, !d.deadline.IsZero()
+}
+
+// Err implements the context.Context interface.
+func (d *deadlineCtx) Err() error {
+	err := d.Context.Err()
+	if err != nil && errors.Is(context.Cause(d.Context), context.DeadlineExceeded) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// Value implements the context.Context interface.
+func (d *deadlineCtx) Value(key interface{}) interface{} {
+	if key == (deadlineKey{}) {
+		return d
+	}
+	return d.Context.Value(key)
+}
+
+// setDeadline moves the deadline, replacing any deadline set before. A
+// deadline already passed cancels the context right away.
+func (d *deadlineCtx) setDeadline(deadline time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	d.deadline = deadline
+
+	if deadline.IsZero() {
+		return
+	}
+
+	f := func() {
+		d.cancel(context.DeadlineExceeded)
+	}
+
+	if wait := deadline.Sub(d.clock.Now()); wait > 0 {
+		d.timer = d.clock.AfterFunc(wait, f)
+		return
+	}
+	f()
+}
+
+// release stops the timer and cancels the context once the request is
+// processed, so neither outlives it.
+func (d *deadlineCtx) release() {
+	d.mu.Lock()
+	{
+		if d.timer != nil {
+			d.timer.Stop()
+			d.timer = nil
+		}
+	}
+	d.mu.Unlock()
+
+	d.cancel(context.Canceled)
+}
+
+// SetDeadline bounds the processing of the request, replacing the deadline
+// from the RequestTimeout. The request context is canceled with
+// context.DeadlineExceeded once the deadline passes and the request is
+// reported as timed out. A zero time removes the deadline. Derive the
+// contexts for the work of the request from the Context after setting the
+// deadline.
+func (r *Request) SetDeadline(deadline time.Time) {
+	if d, ok := r.Context.Value(deadlineKey{}).(*deadlineCtx); ok {
+		d.setDeadline(deadline)
+		return
+	}
+
+	ctx := r.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	var clock Clock = systemClock{}
+	if r.TCP != nil {
+		clock = r.TCP.clock()
+	}
+	r.Context = withDeadline(ctx, clock, deadline)
+}
+
+// requestDeadline gives the request context the deadline of the
+// RequestTimeout.
+func (c *client) requestDeadline(ctx context.Context) context.Context {
+	if c.t.RequestTimeout <= 0 {
+		return ctx
+	}
+
+	clock := c.t.clock()
+	return withDeadline(ctx, clock, clock.Now().Add(c.t.RequestTimeout))
+}
+
+// timedOut reports a request whose processing outran its deadline.
+func (c *client) timedOut(r *Request, took time.Duration) {
+	d, ok := r.Context.Value(deadlineKey{}).(*deadlineCtx)
+	if !ok || d.Err() != context.DeadlineExceeded {
+		return
+	}
+
+	atomic.AddInt64(&c.t.metrics.requestTimeouts, 1)
+	c.t.Event(EvtProcess, TypError, c.ipAddress, "request timeout : ID[ %s ] Took[ %v ]", r.ID, took)
+}
+
+// releaseDeadline frees the deadline of the processed request.
+func (c *client) releaseDeadline(r *Request) {
+	if d, ok := r.Context.Value(deadlineKey{}).(*deadlineCtx); ok {
+		d.release()
+	}
+}
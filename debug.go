@@ -0,0 +1,149 @@
+package tcp
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Debug is a point in time view of the internal state of a TCP value for
+// live troubleshooting. It's encoded as JSON by the debug endpoint.
+type Debug struct {
+	Name    string
+	Time    time.Time
+	Addr    string
+	Config  map[string]string // Numeric and duration fields set, by name.
+	Metrics Metrics
+
+	Paused      bool
+	Dropping    bool
+	Maintenance bool
+	Breaker     string // Reason the breaker is open, if it is.
+	Overloaded  string // Reason the process is overloaded, if it is.
+	Load        Load
+	Tarpitted   int // IPs flagged into the tarpit.
+
+	Workers     int64 // Size of the worker pool serving pipelined requests.
+	WorkersBusy int64 // Workers processing a request.
+
+	Clients []DebugClient
+}
+
+// DebugClient is the state of a connection in the debug view.
+type DebugClient struct {
+	Stat
+	Age  time.Duration // Time since the connection was accepted.
+	Idle time.Duration // Time since the last request was read.
+}
+
+// Debug captures the internal state of the TCP value.
+func (t *TCP) Debug() Debug {
+	now := t.now()
+
+	d := Debug{
+		Name:        t.Name,
+		Time:        now,
+		Config:      make(map[string]string),
+		Metrics:     t.Metrics(),
+		Paused:      t.Paused(),
+		Dropping:    atomic.LoadInt32(&t.dropConns) == 1,
+		Maintenance: atomic.LoadInt32(&t.maintenance) == 1,
+		Workers:     atomic.LoadInt64(&t.workers),
+		WorkersBusy: atomic.LoadInt64(&t.workersBusy),
+	}
+
+	if addr := t.Addr(); addr != nil {
+		d.Addr = addr.String()
+	}
+
+	// UpdateConfig changes some of the fields while running.
+	var cfg Config
+	t.configMu.RLock()
+	{
+		cfg = t.Config
+	}
+	t.configMu.RUnlock()
+
+	for _, v := range cfg.intFields() {
+		if v.value != 0 {
+			d.Config[v.field] = fmt.Sprint(v.value)
+		}
+	}
+	for _, v := range cfg.durationFields() {
+		if v.value != 0 {
+			d.Config[v.field] = v.value.String()
+		}
+	}
+	if rateLimit := cfg.RateLimit; rateLimit != nil {
+		d.Config["OptRateLimit.RateLimit"] = rateLimit().String()
+	}
+
+	if reason, open := t.Breaker(); open {
+		d.Breaker = reason
+	}
+	reason, over, load := t.Overloaded()
+	if over {
+		d.Overloaded = reason
+	}
+	d.Load = load
+
+	t.tarpit.mu.Lock()
+	{
+		for _, until := range t.tarpit.ips {
+			if now.Before(until) {
+				d.Tarpitted++
+			}
+		}
+	}
+	t.tarpit.mu.Unlock()
+
+	// The addresses are anonymized like in the recorded snapshots since
+	// the view is published.
+	for _, stat := range t.ClientStats() {
+		stat.IP = t.anonymize(stat.IP)
+		dc := DebugClient{
+			Stat: stat,
+			Age:  now.Sub(stat.TimeConn),
+		}
+		if !stat.LastAct.IsZero() {
+			dc.Idle = now.Sub(stat.LastAct)
+		}
+		d.Clients = append(d.Clients, dc)
+	}
+
+	return d
+}
+
+// PublishDebug publishes the debug view under the expvar map named by the
+// prefix, keyed by the name of the TCP value. The view is captured each
+// time the expvars are read, such as at /debug/vars.
+func (t *TCP) PublishDebug(prefix string) {
+	m, ok := expvar.Get(prefix).(*expvar.Map)
+	if !ok {
+		m = expvar.NewMap(prefix)
+	}
+
+	m.Set(t.Name, expvar.Func(func() interface{} {
+		return t.Debug()
+	}))
+}
+
+// DebugHandler returns a handler serving the debug views of the TCP values
+// as JSON, keyed by name. It exposes the IPs of the clients, so it must
+// only be served to operators.
+func DebugHandler(servers ...*TCP) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		views := make(map[string]Debug, len(servers))
+		for _, t := range servers {
+			views[t.Name] = t.Debug()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(views)
+	})
+}
@@ -0,0 +1,114 @@
+package tcp_test
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/tcp"
+	"github.com/ardanlabs/tcp/tcptest"
+)
+
+// TestDebug tests the debug view reports the state of the connections.
+func TestDebug(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to troubleshoot a live server.")
+	{
+		clock := tcptest.NewClock(time.Now())
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptPipeline: tcp.OptPipeline{
+				Pipeline: 2,
+				Workers:  3,
+			},
+			OptClock: tcp.OptClock{
+				Clock: clock,
+			},
+		})
+
+		c := s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("hello"), []byte("hello"))
+		clock.Advance(time.Minute)
+
+		d := s.Debug()
+		if d.Config["OptPipeline.Workers"] != "3" || d.Workers != 3 {
+			t.Fatalf("\tShould report the configuration and the workers : %v %d %s", d.Config, d.Workers, failed)
+		}
+		t.Log("\tShould report the configuration and the workers.", success)
+
+		if len(d.Clients) != 1 || d.Clients[0].Age < time.Minute || d.Clients[0].BytesRead != 6 {
+			t.Fatalf("\tShould report the connections with their age and bytes : %+v %s", d.Clients, failed)
+		}
+		t.Log("\tShould report the connections with their age and bytes.", success)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 100; i++ {
+				wt := time.Duration(i) * time.Millisecond
+				s.UpdateConfig(tcp.ConfigPatch{WriteTimeout: &wt})
+			}
+		}()
+		for i := 0; i < 100; i++ {
+			s.Debug()
+		}
+		<-done
+		t.Log("\tShould read the configuration safely while it is updated.", success)
+
+		rec := httptest.NewRecorder()
+		tcp.DebugHandler(s.TCP).ServeHTTP(rec, httptest.NewRequest("GET", "/debug/tcp", nil))
+
+		var views map[string]tcp.Debug
+		if err := json.Unmarshal(rec.Body.Bytes(), &views); err != nil {
+			t.Fatal("\tShould serve the debug views as JSON.", failed, err)
+		}
+		if v, ok := views[s.Name]; !ok || len(v.Clients) != 1 {
+			t.Fatal("\tShould serve the debug views as JSON.", failed, rec.Body.String())
+		}
+		t.Log("\tShould serve the debug views as JSON.", success)
+
+		s.PublishDebug("debug")
+		v := expvar.Get("debug").(*expvar.Map).Get(s.Name)
+		if v == nil || !strings.Contains(v.String(), `"WorkersBusy"`) {
+			t.Fatal("\tShould publish the debug view as an expvar.", failed, v)
+		}
+		t.Log("\tShould publish the debug view as an expvar.", success)
+	}
+
+	t.Log("Given the need to keep client addresses private in the debug view.")
+	{
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptPrivacy: tcp.OptPrivacy{
+				Anonymize: tcp.AnonymizeTruncate,
+			},
+		})
+
+		c := s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("hello"), []byte("hello"))
+
+		addr := c.LocalAddr().String()
+		d := s.Debug()
+		if len(d.Clients) != 1 || d.Clients[0].IP != tcp.AnonymizeTruncate(addr) {
+			t.Fatalf("\tShould anonymize the addresses of the clients : %+v %s", d.Clients, failed)
+		}
+
+		s.PublishDebug("debugprivate")
+		v := expvar.Get("debugprivate").(*expvar.Map).Get(s.Name)
+		if v == nil || strings.Contains(v.String(), addr) {
+			t.Fatalf("\tShould anonymize the addresses of the clients : %v %s", v, failed)
+		}
+		t.Log("\tShould anonymize the addresses of the clients.", success)
+	}
+}
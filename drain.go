@@ -0,0 +1,116 @@
+package tcp
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Drain closes the connections the function matches once they finish the
+// requests in flight. They stop reading new requests and are sent the
+// goodbye message through the RespHandler, when it's not nil, before they
+// are closed. Drain waits for the connections to close or the context to
+// be done and returns the number of connections matched. The function is
+// given the IP, Tags and TimeConn of each connection.
+func (t *TCP) Drain(ctx context.Context, match func(s Stat) bool, goodbye []byte) (int, error) {
+	clts := t.clientList()
+
+	var drained []*client
+	for _, c := range clts {
+		s := Stat{
+			IP:       c.ipAddress,
+			Tags:     c.tagNames(),
+			TimeConn: c.timeConn,
+		}
+
+		if match(s) && c.drain(goodbye) {
+			drained = append(drained, c)
+		}
+	}
+
+	t.serverEvent(EvtDrop, TypInfo, join(t.ipAddress, t.port), "draining : Conns[ %d ]", len(drained))
+
+	for _, c := range drained {
+		done := make(chan struct{})
+		go func(c *client) {
+			c.wg.Wait()
+			close(done)
+		}(c)
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return len(drained), ctx.Err()
+		}
+	}
+
+	return len(drained), nil
+}
+
+// DrainTag drains the connections with the tag.
+func (t *TCP) DrainTag(ctx context.Context, tag string, goodbye []byte) (int, error) {
+	return t.Drain(ctx, MatchTag(tag), goodbye)
+}
+
+// Shutdown stops the TCP value gracefully. New connections stay in the
+// listen backlog while every connection is drained with the goodbye
+// message, and the TCP value is stopped once they closed or the context
+// is done, closing the connections left.
+func (t *TCP) Shutdown(ctx context.Context, goodbye []byte) error {
+	t.Pause()
+
+	_, err := t.Drain(ctx, func(Stat) bool { return true }, goodbye)
+	if serr := t.Stop(); serr != nil {
+		return serr
+	}
+
+	return err
+}
+
+// drain marks the client to close once the requests in flight finish. It
+// reports false when the client is already closing.
+func (c *client) drain(goodbye []byte) bool {
+	if !atomic.CompareAndSwapInt32(&c.closing, 0, 1) {
+		return false
+	}
+	c.setCloseReason(CloseDrained)
+
+	c.writeMu.Lock()
+	{
+		c.goodbye = goodbye
+	}
+	c.writeMu.Unlock()
+	atomic.StoreInt32(&c.draining, 1)
+
+	// Wake the read waiting for the next request.
+	c.setCloseBy(time.Now())
+	c.wake()
+
+	c.t.Event(EvtDrop, TypInfo, c.ipAddress, "draining")
+	return true
+}
+
+// sayGoodbye writes the goodbye message to a drained client.
+func (c *client) sayGoodbye() {
+	var goodbye []byte
+	c.writeMu.Lock()
+	{
+		goodbye = c.goodbye
+	}
+	c.writeMu.Unlock()
+
+	if goodbye == nil {
+		return
+	}
+
+	r := Response{
+		TCPAddr: c.conn.RemoteAddr().(*net.TCPAddr),
+		Data:    goodbye,
+		Length:  len(goodbye),
+	}
+
+	if err := c.write(&r); err != nil {
+		c.t.Event(EvtDrop, TypError, c.ipAddress, "goodbye : %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package tcp
+
+import (
+	"net"
+	"time"
+)
+
+// defDropBanners is the number of banners written at once to the dropped
+// connections.
+const defDropBanners = 64
+
+// DropMode is how new connections are closed when they are dropped by
+// DropConnections, the rate limit or MaxConns.
+type DropMode int
+
+// Set of drop modes.
+const (
+	DropFIN    DropMode = iota // Close the connection gracefully.
+	DropRST                    // Reset the connection, freeing it right away without a TIME_WAIT.
+	DropBanner                 // Write the DropMsg through the RespHandler before closing.
+)
+
+// reject closes a new connection that is dropped the way DropMode asks.
+func (t *TCP) reject(conn net.Conn, acceptedAt time.Time) {
+	switch t.DropMode {
+	case DropRST:
+
+		// lingerer is declared to test for the existence of the
+		// method coming from the net package.
+		type lingerer interface {
+			SetLinger(sec int) error
+		}
+
+		if l, ok := conn.(lingerer); ok {
+			l.SetLinger(0)
+		}
+		conn.Close()
+
+	case DropBanner:
+
+		// A flood of connections must not turn into as many banners.
+		select {
+		case t.dropBanners <- struct{}{}:
+			t.wg.Add(1)
+			go t.dropBanner(conn)
+		default:
+			conn.Close()
+		}
+
+	default:
+		conn.Close()
+	}
+}
+
+// dropBanner writes the DropMsg through the RespHandler bound to the raw
+// connection and closes it. Unlike the maintenance banner nothing else is
+// done for the connection, not even the TLS handshake, so dropping stays
+// cheap.
+func (t *TCP) dropBanner(conn net.Conn) {
+	defer t.wg.Done()
+	defer func() { <-t.dropBanners }()
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(defBannerTimeout))
+
+	handlers := t.handlers()
+	reader, writer := handlers.ConnHandler.Bind(conn)
+
+	r := Response{
+		TCPAddr: conn.RemoteAddr().(*net.TCPAddr),
+		Data:    t.DropMsg,
+		Length:  len(t.DropMsg),
+	}
+
+	if err := handlers.RespHandler.Write(&r, writer); err != nil {
+		t.Event(EvtAccept, TypError, conn.RemoteAddr().String(), "drop banner : %v", err)
+	}
+
+	if u, ok := handlers.ConnHandler.(Unbinder); ok {
+		u.Unbind(reader, writer)
+	}
+}
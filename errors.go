@@ -0,0 +1,90 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// Set of error classes returned by the library. Errors are matched to a
+// class with errors.Is, so callers can branch without matching strings.
+var (
+	ErrDeadline      = errors.New("deadline exceeded")
+	ErrFrameTooLarge = errors.New("frame too large")
+	ErrRateLimited   = errors.New("rate limited")
+	ErrShutdown      = errors.New("shutting down")
+	ErrDisconnected  = errors.New("disconnected")
+)
+
+// errorClasses are the classes in the order they are matched.
+var errorClasses = []error{ErrDeadline, ErrFrameTooLarge, ErrRateLimited, ErrShutdown, ErrDisconnected}
+
+// Classify returns the class of the error, or nil when it's none of them.
+// The ClassifyError function of the configuration is asked first, which
+// lets handlers map their own errors, such as a frame exceeding the limit
+// of their protocol, to a class.
+func (cfg *Config) Classify(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if cfg.ClassifyError != nil {
+		if class := cfg.ClassifyError(err); class != nil {
+			err = class
+		}
+	}
+
+	for _, class := range errorClasses {
+		if errors.Is(err, class) {
+			return class
+		}
+	}
+
+	// A client the network declared gone is not a deadline.
+	if peerDead(err) {
+		return ErrDisconnected
+	}
+
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return ErrDeadline
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrDeadline
+	}
+
+	return nil
+}
+
+// Retryable reports whether the operation that failed with the error can
+// be tried again. The ClassifyError function of the configuration is asked
+// first, so the errors handlers map to a class retry as that class does.
+func (cfg *Config) Retryable(err error) bool {
+	if cfg.ClassifyError != nil {
+		if class := cfg.ClassifyError(err); class != nil {
+			return Retryable(class)
+		}
+	}
+
+	return Retryable(err)
+}
+
+// Retryable reports whether the operation that failed with the error can
+// be tried again as is, such as after a deadline or once the rate limit
+// allows it.
+func Retryable(err error) bool {
+	switch {
+	case errors.Is(err, ErrDeadline), errors.Is(err, ErrRateLimited):
+		return true
+	case errors.Is(err, ErrFrameTooLarge), errors.Is(err, ErrShutdown), errors.Is(err, ErrDisconnected), peerDead(err):
+		return false
+	}
+
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, context.DeadlineExceeded)
+}
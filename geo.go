@@ -0,0 +1,42 @@
+package tcp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+)
+
+// ErrGeoDenied is returned binding a connection the geo policy denied.
+var ErrGeoDenied = errors.New("denied by the geo policy")
+
+// GeoDecision is what the geo policy decided for the IP of a connection,
+// usually from the country or network a resolver like a GeoIP database
+// locates it in.
+type GeoDecision struct {
+	Deny bool     // Close the connection before anything is read.
+	Tags []string // Added to the tags of the connection, such as its country.
+}
+
+// geo asks the geo policy about the client and returns the tags to add to
+// the connection. A connection denied is closed with CloseGeoDenied.
+func (c *client) geo() ([]string, error) {
+	addr, ok := c.conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return nil, nil
+	}
+
+	d, err := c.t.GeoPolicy(addr.IP)
+	if err != nil {
+		c.t.Event(EvtAccept, TypError, c.ipAddress, "geo : %v", err)
+		d = GeoDecision{Deny: c.t.GeoFailClosed}
+	}
+
+	if d.Deny {
+		atomic.AddInt64(&c.t.metrics.geoDenied, 1)
+		c.setCloseReason(CloseGeoDenied)
+		return nil, fmt.Errorf("%w : %s", ErrGeoDenied, c.t.anonymize(addr.IP.String()))
+	}
+
+	return d.Tags, nil
+}
@@ -0,0 +1,296 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Set of errors returned adding a value to a group.
+var (
+	ErrGroupStarted = errors.New("group already started")
+	ErrGroupMember  = errors.New("value already in the group")
+)
+
+// Group manages the TCP values of a service so they start and stop
+// together. Values are added to numbered stages: on shutdown the stages
+// are stopped from the lowest to the highest, one stage at a time, so a
+// public listener in stage 0 is drained before an admin listener in
+// stage 1. Values are started in the opposite order. The events of the
+// values are merged into one stream and their metrics are aggregated, for
+// processes exposing several listeners. A Group can be run by tcprun.Run.
+type Group struct {
+	mu       sync.Mutex
+	members  []groupMember
+	timeouts map[int]time.Duration
+	started  bool
+	event    atomic.Value
+}
+
+// GroupEvent defines an handler used to provide the events of the values in
+// a group, along with the name of the value firing them.
+type GroupEvent func(name string, evt, typ int, ipAddress string, format string, a ...interface{})
+
+// groupMember binds a TCP value to its stage.
+type groupMember struct {
+	t     *TCP
+	stage int
+}
+
+// Add adds the TCP value to the stage. Values must be added before the
+// group and the value are started since their events are routed through
+// the group. A value is added to a group once.
+func (g *Group) Add(t *TCP, stage int) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.started {
+		return ErrGroupStarted
+	}
+	for _, m := range g.members {
+		if m.t == t {
+			return ErrGroupMember
+		}
+	}
+
+	// Keep firing the events to the handler of the value.
+	own := t.OptEvent.Event
+	t.OptEvent.Event = func(evt, typ int, ipAddress string, format string, a ...interface{}) {
+		if own != nil {
+			own(evt, typ, ipAddress, format, a...)
+		}
+		if fn, ok := g.event.Load().(GroupEvent); ok && fn != nil {
+			fn(t.Name, evt, typ, ipAddress, format, a...)
+		}
+	}
+
+	g.members = append(g.members, groupMember{t: t, stage: stage})
+	return nil
+}
+
+// OnEvent sets the handler receiving the events of all the values in the
+// group. It can be called at any time.
+func (g *Group) OnEvent(fn GroupEvent) {
+	g.event.Store(fn)
+}
+
+// StageTimeout sets the time allowed for the values in the stage to stop.
+// The next stage is stopped once the timeout passes even if some values
+// are still stopping.
+func (g *Group) StageTimeout(stage int, d time.Duration) {
+	g.mu.Lock()
+	{
+		if g.timeouts == nil {
+			g.timeouts = make(map[int]time.Duration)
+		}
+		g.timeouts[stage] = d
+	}
+	g.mu.Unlock()
+}
+
+// list returns the TCP values in the group.
+func (g *Group) list() []*TCP {
+	var list []*TCP
+	g.mu.Lock()
+	{
+		for _, m := range g.members {
+			list = append(list, m.t)
+		}
+	}
+	g.mu.Unlock()
+
+	return list
+}
+
+// stages returns the members grouped by stage with the stage numbers and
+// timeouts, lowest stage first.
+func (g *Group) stages() ([][]groupMember, []int, []time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	byStage := make(map[int][]groupMember)
+	var order []int
+	for _, m := range g.members {
+		if _, ok := byStage[m.stage]; !ok {
+			order = append(order, m.stage)
+		}
+		byStage[m.stage] = append(byStage[m.stage], m)
+	}
+	sort.Ints(order)
+
+	stages := make([][]groupMember, len(order))
+	timeouts := make([]time.Duration, len(order))
+	for i, stage := range order {
+		stages[i] = byStage[stage]
+		timeouts[i] = g.timeouts[stage]
+	}
+
+	return stages, order, timeouts
+}
+
+// Start starts the TCP values from the highest stage to the lowest. If a
+// value fails to start, the values already started are stopped.
+func (g *Group) Start() error {
+	g.mu.Lock()
+	{
+		g.started = true
+	}
+	g.mu.Unlock()
+
+	stages, _, _ := g.stages()
+
+	var started []*TCP
+	for i := len(stages) - 1; i >= 0; i-- {
+		for _, m := range stages[i] {
+			if err := m.t.Start(); err != nil {
+				for j := len(started) - 1; j >= 0; j-- {
+					started[j].Stop()
+				}
+				return fmt.Errorf("start %s : %v", m.t.Name, err)
+			}
+			started = append(started, m.t)
+		}
+	}
+
+	return nil
+}
+
+// Stop stops the TCP values stage by stage.
+func (g *Group) Stop() error {
+	return g.shutdown(context.Background(), (*TCP).Stop)
+}
+
+// Shutdown shuts the TCP values down stage by stage with the goodbye, as
+// TCP.Shutdown does for one value. Once the context is done, the
+// connections still open are closed and the remaining stages are stopped
+// without waiting.
+func (g *Group) Shutdown(ctx context.Context, goodbye []byte) error {
+	return g.shutdown(ctx, func(t *TCP) error {
+		return t.Shutdown(ctx, goodbye)
+	})
+}
+
+// shutdown stops the TCP values stage by stage with the function. The
+// values in a stage are stopped at the same time and the next stage starts
+// once they are all stopped or the stage timeout passes.
+func (g *Group) shutdown(ctx context.Context, stop func(t *TCP) error) error {
+	stages, numbers, timeouts := g.stages()
+
+	// Values still stopping after a timeout report their errors late,
+	// so the errors are collected under a lock.
+	var mu sync.Mutex
+	var errs CltError
+	report := func(err error) {
+		mu.Lock()
+		{
+			errs = append(errs, err)
+		}
+		mu.Unlock()
+	}
+
+	for i, stage := range stages {
+		var wg sync.WaitGroup
+		wg.Add(len(stage))
+		for _, m := range stage {
+			go func(t *TCP) {
+				defer wg.Done()
+				if err := stop(t); err != nil {
+					report(fmt.Errorf("stop %s : %v", t.Name, err))
+				}
+			}(m.t)
+		}
+
+		stopped := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(stopped)
+		}()
+
+		var timeout <-chan time.Time
+		if timeouts[i] > 0 {
+			timer := time.NewTimer(timeouts[i])
+			timeout = timer.C
+			defer timer.Stop()
+		}
+
+		select {
+		case <-stopped:
+		case <-timeout:
+			report(fmt.Errorf("stage %d : timed out after %v", numbers[i], timeouts[i]))
+		case <-ctx.Done():
+			report(fmt.Errorf("stage %d : %v", numbers[i], ctx.Err()))
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if errs != nil {
+		return append(CltError(nil), errs...)
+	}
+	return nil
+}
+
+// MemberMetrics returns the statistics of each TCP value by name.
+func (g *Group) MemberMetrics() map[string]Metrics {
+	members := g.list()
+
+	ms := make(map[string]Metrics, len(members))
+	for _, m := range members {
+		ms[m.Name] = m.Metrics()
+	}
+	return ms
+}
+
+// Metrics returns the statistics of the TCP values added together. The
+// maximums are the largest of the values and AcceptLatency is the largest
+// latest accept latency.
+func (g *Group) Metrics() Metrics {
+	members := g.list()
+
+	total := Metrics{
+		Closes: make(map[CloseReason]int64),
+	}
+
+	var latencyTotal, latencyCount int64
+	for _, t := range members {
+		m := t.Metrics()
+
+		total.Connections += m.Connections
+		total.Requests += m.Requests
+		total.Processing += m.Processing
+		total.ReadErrors += m.ReadErrors
+		total.WriteErrors += m.WriteErrors
+		total.RequestTimeouts += m.RequestTimeouts
+		total.CorruptFrames += m.CorruptFrames
+		total.WriteBatches += m.WriteBatches
+		total.LoadRejects += m.LoadRejects
+		total.BufferedBytes += m.BufferedBytes
+		total.BufferedBytesMax = max(total.BufferedBytesMax, m.BufferedBytesMax)
+		total.MemoryStalls += m.MemoryStalls
+		total.Tarpitted += m.Tarpitted
+		total.Bans += m.Bans
+		total.BanRejects += m.BanRejects
+		total.GeoDenied += m.GeoDenied
+		total.QuotaRejects += m.QuotaRejects
+		total.AcceptLatency = max(total.AcceptLatency, m.AcceptLatency)
+		total.AcceptLatencyMax = max(total.AcceptLatencyMax, m.AcceptLatencyMax)
+
+		for reason, n := range m.Closes {
+			total.Closes[reason] += n
+		}
+
+		latencyTotal += atomic.LoadInt64(&t.metrics.acceptLatencyTotal)
+		latencyCount += atomic.LoadInt64(&t.metrics.acceptLatencyCount)
+	}
+
+	if latencyCount > 0 {
+		total.AcceptLatencyAvg = time.Duration(latencyTotal / latencyCount)
+	}
+
+	return total
+}
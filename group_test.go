@@ -0,0 +1,430 @@
+package tcp_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/tcp"
+	"github.com/ardanlabs/tcp/tcprun"
+	"github.com/ardanlabs/tcp/tcptest"
+)
+
+// TestGroup tests the values in a group stop in stage order.
+func TestGroup(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to stop listeners in a predictable sequence.")
+	{
+		var mu sync.Mutex
+		var stopped []string
+
+		newTCP := func(name string) *tcp.TCP {
+			cfg := tcp.Config{
+				NetType:     "tcp4",
+				Addr:        ":0",
+				ConnHandler: tcpConnHandler{},
+				ReqHandler:  tcpReqHandler{},
+				RespHandler: tcpRespHandler{},
+
+				OptEvent: tcp.OptEvent{
+					Event: func(evt, typ int, ipAddress string, format string, a ...interface{}) {
+						if evt == tcp.EvtAccept && format == "shutdown" {
+							mu.Lock()
+							stopped = append(stopped, name)
+							mu.Unlock()
+						}
+					},
+				},
+			}
+
+			u, err := tcp.New(name, cfg)
+			if err != nil {
+				t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+			}
+			return u
+		}
+
+		var g tcp.Group
+		admin := newTCP("admin")
+		g.Add(admin, 1)
+		g.Add(newTCP("public"), 0)
+
+		if err := g.Add(admin, 1); !errors.Is(err, tcp.ErrGroupMember) {
+			t.Fatal("\tShould not add a value twice.", failed, err)
+		}
+		t.Log("\tShould not add a value twice.", success)
+
+		if err := g.Start(); err != nil {
+			t.Fatal("\tShould be able to start the group.", failed, err)
+		}
+		t.Log("\tShould be able to start the group.", success)
+
+		if err := g.Add(newTCP("late"), 0); !errors.Is(err, tcp.ErrGroupStarted) {
+			t.Fatal("\tShould not add a value once the group started.", failed, err)
+		}
+		t.Log("\tShould not add a value once the group started.", success)
+
+		if err := g.Stop(); err != nil {
+			t.Fatal("\tShould be able to stop the group.", failed, err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if len(stopped) != 2 || stopped[0] != "public" || stopped[1] != "admin" {
+			t.Fatal("\tShould stop the lowest stage first.", failed, stopped)
+		}
+		t.Log("\tShould stop the lowest stage first.", success)
+	}
+}
+
+// TestGroupMetrics tests the events and metrics of the values in a group
+// are merged.
+func TestGroupMetrics(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to watch several listeners as one.")
+	{
+		newTCP := func(name string) *tcp.TCP {
+			cfg := tcp.Config{
+				NetType:     "tcp4",
+				Addr:        ":0",
+				ConnHandler: tcpConnHandler{},
+				ReqHandler:  tcpReqHandler{},
+				RespHandler: tcpRespHandler{},
+			}
+
+			u, err := tcp.New(name, cfg)
+			if err != nil {
+				t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+			}
+			return u
+		}
+
+		var mu sync.Mutex
+		joined := make(map[string]int)
+
+		var g tcp.Group
+		g.OnEvent(func(name string, evt, typ int, ipAddress string, format string, a ...interface{}) {
+			if evt == tcp.EvtJoin {
+				mu.Lock()
+				joined[name]++
+				mu.Unlock()
+			}
+		})
+
+		public := newTCP("public")
+		admin := newTCP("admin")
+		g.Add(public, 0)
+		g.Add(admin, 1)
+
+		if err := g.Start(); err != nil {
+			t.Fatal("\tShould be able to start the group.", failed, err)
+		}
+		t.Log("\tShould be able to start the group.", success)
+
+		defer g.Stop()
+
+		for _, u := range []*tcp.TCP{public, admin, public} {
+			conn, err := net.Dial("tcp4", u.Addr().String())
+			if err != nil {
+				t.Fatal("\tShould be able to dial a new TCP connection.", failed, err)
+			}
+			defer conn.Close()
+
+			if _, err := conn.Write([]byte("Hello\n")); err != nil {
+				t.Fatal("\tShould be able to send data to the connection.", failed, err)
+			}
+			if _, err := bufio.NewReader(conn).ReadString('\n'); err != nil {
+				t.Fatal("\tShould be able to read the response from the connection.", failed, err)
+			}
+		}
+		t.Log("\tShould be able to send requests to both listeners.", success)
+
+		mu.Lock()
+		{
+			if joined["public"] != 2 || joined["admin"] != 1 {
+				t.Error("\tShould receive the events of both listeners by name.", failed, joined)
+			} else {
+				t.Log("\tShould receive the events of both listeners by name.", success)
+			}
+		}
+		mu.Unlock()
+
+		if m := g.Metrics(); m.Requests != 3 || m.Connections != 3 {
+			t.Error("\tShould add the metrics of the listeners together.", failed, m.Requests, m.Connections)
+		} else {
+			t.Log("\tShould add the metrics of the listeners together.", success)
+		}
+
+		ms := g.MemberMetrics()
+		if ms["public"].Requests != 2 || ms["admin"].Requests != 1 {
+			t.Error("\tShould report the metrics of each listener.", failed, ms["public"].Requests, ms["admin"].Requests)
+		} else {
+			t.Log("\tShould report the metrics of each listener.", success)
+		}
+	}
+}
+
+// TestGroupRun tests a group is shut down by tcprun.Run.
+func TestGroupRun(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to run the values of a group as a process.")
+	{
+		cfg := tcp.Config{
+			NetType:     "tcp4",
+			Addr:        ":0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+		}
+
+		u, err := tcp.New("public", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+
+		var g tcp.Group
+		g.Add(u, 0)
+		if err := g.Start(); err != nil {
+			t.Fatal("\tShould be able to start the group.", failed, err)
+		}
+
+		conn, err := net.Dial("tcp4", u.Addr().String())
+		if err != nil {
+			t.Fatal("\tShould be able to dial a new TCP connection.", failed, err)
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		conn.Write([]byte("Hello\n"))
+		if _, err := r.ReadString('\n'); err != nil {
+			t.Fatal("\tShould be able to read the response from the connection.", failed, err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := tcprun.Run(ctx, tcprun.Config{Goodbye: []byte("BYE\n")}, &g); err != nil {
+			t.Fatal("\tShould shut the group down.", failed, err)
+		}
+		if line, err := r.ReadString('\n'); err != nil || line != "BYE\n" {
+			t.Fatal("\tShould say goodbye to the connections.", failed, line, err)
+		}
+		t.Log("\tShould shut the group down with a goodbye.", success)
+	}
+}
+
+// TestGroupMerge tests the events of the values still reach their own
+// handlers and every metric is merged across the values.
+func TestGroupMerge(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to watch several listeners as one without losing their own handlers.")
+	{
+		// The clock moves while the connections are tagged, which sets
+		// the accept latency of each value.
+		clock := tcptest.NewClock(time.Now())
+		var mu sync.Mutex
+		own := make(map[string]int)
+
+		newTCP := func(name string, latency time.Duration) (*tcp.TCP, *tcptest.Listener) {
+			l := tcptest.NewListener()
+			cfg := tcp.Config{
+				NetType:     "tcp4",
+				Addr:        "127.0.0.1:0",
+				ConnHandler: tcpConnHandler{},
+				ReqHandler:  echoReqHandler{},
+				RespHandler: tcpRespHandler{},
+
+				OptListen: tcp.OptListen{
+					Listen: l.Listen,
+				},
+				OptTags: tcp.OptTags{
+					Tags: func(conn net.Conn) []string {
+						clock.Advance(latency)
+						return nil
+					},
+				},
+				OptClock: tcp.OptClock{
+					Clock: clock,
+				},
+				OptEvent: tcp.OptEvent{
+					Event: func(evt, typ int, ipAddress string, format string, a ...interface{}) {
+						if evt == tcp.EvtJoin {
+							mu.Lock()
+							own[name]++
+							mu.Unlock()
+						}
+					},
+				},
+			}
+
+			u, err := tcp.New(name, cfg)
+			if err != nil {
+				t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+			}
+			return u, l
+		}
+
+		public, publicL := newTCP("public", 10*time.Millisecond)
+		admin, adminL := newTCP("admin", 40*time.Millisecond)
+
+		var g tcp.Group
+		g.Add(public, 0)
+		g.Add(admin, 1)
+		if err := g.Start(); err != nil {
+			t.Fatal("\tShould be able to start the group.", failed, err)
+		}
+		defer g.Stop()
+
+		// The group handler is set once the values run.
+		var merged int32
+		g.OnEvent(func(name string, evt, typ int, ipAddress string, format string, a ...interface{}) {
+			if evt == tcp.EvtJoin {
+				atomic.AddInt32(&merged, 1)
+			}
+		})
+
+		var conns []net.Conn
+		for _, l := range []*tcptest.Listener{publicL, publicL, adminL} {
+			conn, err := l.Dial()
+			if err != nil {
+				t.Fatal("\tShould be able to dial the listener.", failed, err)
+			}
+			conns = append(conns, conn)
+
+			conn.Write([]byte("Hello\n"))
+			if _, err := bufio.NewReader(conn).ReadString('\n'); err != nil {
+				t.Fatal("\tShould be able to read the response from the connection.", failed, err)
+			}
+		}
+
+		mu.Lock()
+		{
+			if own["public"] != 2 || own["admin"] != 1 || atomic.LoadInt32(&merged) != 3 {
+				t.Error("\tShould keep firing the events to the handler of each value.", failed, own, merged)
+			} else {
+				t.Log("\tShould keep firing the events to the handler of each value.", success)
+			}
+		}
+		mu.Unlock()
+
+		for _, conn := range conns {
+			conn.Close()
+		}
+		m := g.Metrics()
+		for end := time.Now().Add(time.Second); m.Closes[tcp.CloseEOF] < 3 && time.Now().Before(end); m = g.Metrics() {
+			time.Sleep(time.Millisecond)
+		}
+		if m.Closes[tcp.CloseEOF] != 3 {
+			t.Fatal("\tShould add the close reasons of the values together.", failed, m.Closes)
+		}
+		t.Log("\tShould add the close reasons of the values together.", success)
+
+		// The average is taken over every connection, not over the
+		// averages of the values.
+		if m.AcceptLatencyAvg != 20*time.Millisecond || m.AcceptLatencyMax != 40*time.Millisecond {
+			t.Fatal("\tShould merge the accept latency of the values.", failed, m.AcceptLatencyAvg, m.AcceptLatencyMax)
+		}
+		t.Log("\tShould merge the accept latency of the values.", success)
+	}
+}
+
+// TestGroupShutdown tests a group shuts its values down with a goodbye on
+// its own, stage by stage.
+func TestGroupShutdown(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to shut a group down without tcprun.")
+	{
+		var mu sync.Mutex
+		var stopped []string
+
+		newTCP := func(name string) *tcp.TCP {
+			cfg := tcp.Config{
+				NetType:     "tcp4",
+				Addr:        ":0",
+				ConnHandler: tcpConnHandler{},
+				ReqHandler:  tcpReqHandler{},
+				RespHandler: tcpRespHandler{},
+
+				OptEvent: tcp.OptEvent{
+					Event: func(evt, typ int, ipAddress string, format string, a ...interface{}) {
+						if evt == tcp.EvtAccept && format == "shutdown" {
+							mu.Lock()
+							stopped = append(stopped, name)
+							mu.Unlock()
+						}
+					},
+				},
+			}
+
+			u, err := tcp.New(name, cfg)
+			if err != nil {
+				t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+			}
+			return u
+		}
+
+		public := newTCP("public")
+		admin := newTCP("admin")
+
+		var g tcp.Group
+		g.Add(public, 0)
+		g.Add(admin, 1)
+		if err := g.Start(); err != nil {
+			t.Fatal("\tShould be able to start the group.", failed, err)
+		}
+
+		var readers []*bufio.Reader
+		for _, u := range []*tcp.TCP{public, admin} {
+			conn, err := net.Dial("tcp4", u.Addr().String())
+			if err != nil {
+				t.Fatal("\tShould be able to dial a new TCP connection.", failed, err)
+			}
+			defer conn.Close()
+
+			r := bufio.NewReader(conn)
+			conn.Write([]byte("Hello\n"))
+			if _, err := r.ReadString('\n'); err != nil {
+				t.Fatal("\tShould be able to read the response from the connection.", failed, err)
+			}
+			readers = append(readers, r)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := g.Shutdown(ctx, []byte("BYE\n")); err != nil {
+			t.Fatal("\tShould shut the group down.", failed, err)
+		}
+		for _, r := range readers {
+			if line, err := r.ReadString('\n'); err != nil || line != "BYE\n" {
+				t.Fatal("\tShould say goodbye to the connections of every value.", failed, line, err)
+			}
+		}
+		t.Log("\tShould say goodbye to the connections of every value.", success)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if len(stopped) != 2 || stopped[0] != "public" || stopped[1] != "admin" {
+			t.Fatal("\tShould stop the lowest stage first.", failed, stopped)
+		}
+		if err := public.Stop(); !errors.Is(err, tcp.ErrShutdown) {
+			t.Fatal("\tShould leave the values stopped.", failed, err)
+		}
+		t.Log("\tShould stop the values stage by stage.", success)
+	}
+}
@@ -0,0 +1,119 @@
+package tcp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// defHalfOpenTimeout is the time a connection half closed by the client
+// stays open for the responses by default.
+const defHalfOpenTimeout = 30 * time.Second
+
+// ErrWriteClosed is returned when writing to a connection whose write side
+// was closed with CloseWrite.
+var ErrWriteClosed = fmt.Errorf("write side closed : %w", ErrDisconnected)
+
+// ErrNoHalfClose is returned by CloseWrite when the connection doesn't
+// support closing its write side.
+var ErrNoHalfClose = errors.New("connection can't be half closed")
+
+// CloseWrite closes the write side of the connection once what's buffered
+// is flushed, so the client reads the end of the stream while it can still
+// send requests. Any response written afterwards fails with ErrWriteClosed.
+func (t *TCP) CloseWrite(tcpAddr *net.TCPAddr) error {
+	c, err := t.client(tcpAddr)
+	if err != nil {
+		return err
+	}
+
+	return c.closeWrite()
+}
+
+// CloseWrite closes the write side of the stream, as TCP.CloseWrite does.
+func (s *Stream) CloseWrite() error {
+	return s.c.closeWrite()
+}
+
+// =============================================================================
+
+// closeWrite flushes the writer and closes the write side of the
+// connection.
+func (c *client) closeWrite() error {
+
+	// flusher is declared to test for the existence of the method
+	// coming from the bufio package.
+	type flusher interface {
+		Flush() error
+	}
+
+	// closeWriter is declared to test for the existence of the
+	// method coming from the net and tls packages.
+	type closeWriter interface {
+		CloseWrite() error
+	}
+
+	var err error
+	c.writeMu.Lock()
+	{
+		cw, ok := c.rw.(closeWriter)
+		switch {
+		case atomic.LoadInt32(&c.writeClosed) == 1:
+		case !ok:
+			err = ErrNoHalfClose
+		default:
+			if f, ok := c.writer.(flusher); ok {
+				err = f.Flush()
+			}
+			if err == nil {
+				err = cw.CloseWrite()
+			}
+			atomic.StoreInt32(&c.writeClosed, 1)
+		}
+	}
+	c.writeMu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	c.t.Event(EvtWrite, TypInfo, c.ipAddress, "write side closed")
+	c.endHalfOpen()
+
+	return nil
+}
+
+// halfOpen keeps the connection the client half closed open for the
+// responses, until the write side is closed, the connection is dropped or
+// the timeout passes.
+func (c *client) halfOpen() {
+	atomic.StoreInt32(&c.peerClosed, 1)
+	c.t.Event(EvtRead, TypInfo, c.ipAddress, "half closed by peer")
+
+	if c.t.OnHalfClose != nil {
+		c.t.OnHalfClose(c.conn.RemoteAddr().(*net.TCPAddr))
+	}
+
+	timeout := c.t.HalfOpenTimeout
+	if timeout <= 0 {
+		timeout = defHalfOpenTimeout
+	}
+
+	timer := c.t.clock().NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-c.halfDone:
+	case <-timer.C():
+	case <-c.t.done:
+	}
+}
+
+// endHalfOpen releases the connection waiting in halfOpen.
+func (c *client) endHalfOpen() {
+	c.halfOnce.Do(func() {
+		close(c.halfDone)
+	})
+}
@@ -2,27 +2,97 @@ package tcp
 
 import (
 	"context"
+	"errors"
 	"io"
 	"net"
 	"time"
 )
 
+// ErrNoBody is returned by CopyBody for a request whose body can't be
+// read from the connection, such as a pipelined or UDP request.
+var ErrNoBody = errors.New("request body can't be read")
+
 // Request is the message received by the client.
 type Request struct {
-	TCP     *TCP
-	TCPAddr *net.TCPAddr
-	IsIPv6  bool
-	ReadAt  time.Time
-	Context context.Context
-	Data    []byte
-	Length  int
+	ID       string // Unique to the request, also available through RequestIDFrom.
+	TCP      *TCP
+	UDP      *UDP
+	TCPAddr  *net.TCPAddr
+	IsIPv6   bool
+	Identity string
+	Tags     []string // Tags of the connection.
+	ReadAt   time.Time
+	Context  context.Context
+	Data     []byte
+	Length   int
+
+	c *client
+}
+
+// Send delivers the response through the TCP or UDP value that read the
+// request, so the same handlers can serve both.
+func (r *Request) Send(ctx context.Context, resp *Response) error {
+	if r.UDP != nil {
+		return r.UDP.Send(ctx, resp)
+	}
+	return r.TCP.Send(ctx, resp)
 }
 
-// Response is message to send to the client.
+// CopyBody copies the n bytes following the request on the connection to
+// the writer, for a payload too large to hold in memory such as a file
+// upload announced by the request. The bytes the reader of the ConnHandler
+// buffered are copied first and the rest straight from the connection, so
+// the runtime can use splice when the writer is a file or socket. It's
+// called from Process, before it returns, and fails with ErrNoBody for
+// pipelined requests since the next request is read in the meantime.
+func (r *Request) CopyBody(w io.Writer, n int64) (int64, error) {
+	if r.c == nil {
+		return 0, ErrNoBody
+	}
+	return r.c.copyBody(w, n)
+}
+
+// event fires the event through the TCP or UDP value that read the request.
+func (r *Request) event(evt, typ int, format string, a ...interface{}) {
+	switch {
+	case r.UDP != nil:
+		r.UDP.Event(evt, typ, r.TCPAddr.String(), format, a...)
+	case r.TCP != nil:
+		r.TCP.Event(evt, typ, r.TCPAddr.String(), format, a...)
+	}
+}
+
+// Response is message to send to the client. Body streams a payload too
+// large to hold in memory, such as a file, after the RespHandler writes
+// the message. It's closed once copied when it implements io.Closer.
+// Request.CopyBody streams the payload of a request the same way.
 type Response struct {
 	TCPAddr *net.TCPAddr
 	Data    []byte
 	Length  int
+	Body    io.Reader
+}
+
+// HandlerSet groups the handlers used to service a connection. Handlers
+// left nil are taken from the configuration.
+type HandlerSet struct {
+	ConnHandler ConnHandler
+	ReqHandler  ReqHandler
+	RespHandler RespHandler
+}
+
+// merge returns a copy of the set with nil handlers taken from def.
+func (hs HandlerSet) merge(def HandlerSet) HandlerSet {
+	if hs.ConnHandler == nil {
+		hs.ConnHandler = def.ConnHandler
+	}
+	if hs.ReqHandler == nil {
+		hs.ReqHandler = def.ReqHandler
+	}
+	if hs.RespHandler == nil {
+		hs.RespHandler = def.RespHandler
+	}
+	return hs
 }
 
 // ConnHandler is implemented by the user to bind the connection
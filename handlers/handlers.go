@@ -0,0 +1,197 @@
+// Package handlers provides reference implementations of the tcp request
+// handlers for the classic test services: echo, discard, time and
+// chargen. They are meant for smoke tests, examples and checking a
+// deployment end to end, and work with any ConnHandler.
+//
+//	cfg := tcp.Config{
+//	    NetType:     "tcp4",
+//	    Addr:        ":7007",
+//	    ReqHandler:  handlers.Echo{},
+//	    RespHandler: handlers.Writer{},
+//
+//	    OptBufferSize: tcp.OptBufferSize{
+//	        ReadBufferSize: 4096,
+//	    },
+//	}
+package handlers
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/ardanlabs/tcp"
+)
+
+// defChunkSize is the size of the chunks read off the connection.
+const defChunkSize = 4096
+
+// readChunk reads the bytes available on the reader, up to the size.
+func readChunk(reader io.Reader, size int) ([]byte, int, error) {
+	if size <= 0 {
+		size = defChunkSize
+	}
+
+	data := make([]byte, size)
+	n, err := reader.Read(data)
+	if n == 0 && err != nil {
+		return nil, 0, err
+	}
+
+	return data[:n], n, nil
+}
+
+// reply sends the data back to the client of the request.
+func reply(r *tcp.Request, data []byte) {
+	resp := tcp.Response{
+		TCPAddr: r.TCPAddr,
+		Data:    data,
+		Length:  len(data),
+	}
+
+	r.Send(r.Context, &resp)
+}
+
+// =============================================================================
+
+// Echo sends back every byte it reads, like the echo service of RFC 862.
+type Echo struct {
+	ChunkSize int // Bytes read at once, defaults to 4k.
+}
+
+// Read implements the tcp.ReqHandler interface.
+func (h Echo) Read(ipAddress string, reader io.Reader) ([]byte, int, error) {
+	return readChunk(reader, h.ChunkSize)
+}
+
+// Process implements the tcp.ReqHandler interface.
+func (Echo) Process(r *tcp.Request) {
+	data := r.Data[:r.Length]
+
+	// The response owns the buffer once sent, so the request must not
+	// release it again under PoolBuffers.
+	r.Data = nil
+	reply(r, data)
+}
+
+// =============================================================================
+
+// Discard reads and drops every byte, like the discard service of RFC 863.
+type Discard struct {
+	ChunkSize int // Bytes read at once, defaults to 4k.
+}
+
+// Read implements the tcp.ReqHandler interface.
+func (h Discard) Read(ipAddress string, reader io.Reader) ([]byte, int, error) {
+	return readChunk(reader, h.ChunkSize)
+}
+
+// Process implements the tcp.ReqHandler interface.
+func (Discard) Process(r *tcp.Request) {}
+
+// =============================================================================
+
+// Time answers every request with the current time as a line, like the
+// daytime service of RFC 867. The bytes of the request are ignored.
+type Time struct {
+	Layout string           // Defaults to time.RFC3339.
+	Now    func() time.Time // Defaults to time.Now.
+}
+
+// Read implements the tcp.ReqHandler interface.
+func (Time) Read(ipAddress string, reader io.Reader) ([]byte, int, error) {
+	return readChunk(reader, 0)
+}
+
+// Process implements the tcp.ReqHandler interface.
+func (h Time) Process(r *tcp.Request) {
+	layout := h.Layout
+	if layout == "" {
+		layout = time.RFC3339
+	}
+
+	now := time.Now
+	if h.Now != nil {
+		now = h.Now
+	}
+
+	reply(r, []byte(now().Format(layout)+"\r\n"))
+}
+
+// =============================================================================
+
+// Set of values of the chargen pattern.
+const (
+	chargenFirst = ' '
+	chargenChars = 95 // The printable ASCII characters.
+	chargenWidth = 72 // Characters on each line.
+)
+
+// Chargen answers every request with lines of the rotating pattern of the
+// printable ASCII characters, like the character generator service of
+// RFC 864. Each line starts one character after the previous one, over
+// all the requests served.
+type Chargen struct {
+	Lines int // Lines sent for each request, defaults to 1.
+
+	line uint64
+}
+
+// Read implements the tcp.ReqHandler interface.
+func (*Chargen) Read(ipAddress string, reader io.Reader) ([]byte, int, error) {
+	return readChunk(reader, 0)
+}
+
+// Process implements the tcp.ReqHandler interface.
+func (h *Chargen) Process(r *tcp.Request) {
+	lines := h.Lines
+	if lines <= 0 {
+		lines = 1
+	}
+
+	// Reserve the lines so concurrent requests continue the pattern.
+	last := atomic.AddUint64(&h.line, uint64(lines))
+
+	data := make([]byte, 0, lines*(chargenWidth+2))
+	for l := last - uint64(lines); l < last; l++ {
+		data = append(data, ChargenLine(int(l%chargenChars))...)
+	}
+
+	reply(r, data)
+}
+
+// ChargenLine returns the line of the chargen pattern at the offset,
+// including the trailing CR LF.
+func ChargenLine(offset int) []byte {
+	line := make([]byte, chargenWidth, chargenWidth+2)
+	for i := range line {
+		line[i] = byte(chargenFirst + (offset+i)%chargenChars)
+	}
+
+	return append(line, '\r', '\n')
+}
+
+// =============================================================================
+
+// Writer writes the data of the responses, flushing the writer when it's
+// buffered.
+type Writer struct{}
+
+// Write implements the tcp.RespHandler interface.
+func (Writer) Write(r *tcp.Response, writer io.Writer) error {
+	if _, err := writer.Write(r.Data[:r.Length]); err != nil {
+		return err
+	}
+
+	// flusher is declared to test for the existence of the method
+	// coming from the bufio package.
+	type flusher interface {
+		Flush() error
+	}
+
+	if f, ok := writer.(flusher); ok {
+		return f.Flush()
+	}
+
+	return nil
+}
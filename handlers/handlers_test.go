@@ -0,0 +1,64 @@
+package handlers_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/tcp"
+	"github.com/ardanlabs/tcp/handlers"
+	"github.com/ardanlabs/tcp/tcptest"
+)
+
+// Success and failure markers.
+var (
+	success = "✓"
+	failed  = "✗"
+)
+
+// serve starts a server with the request handler.
+func serve(t *testing.T, rh tcp.ReqHandler) *tcptest.Server {
+	return tcptest.NewServer(t, tcp.Config{
+		ReqHandler:  rh,
+		RespHandler: handlers.Writer{},
+
+		OptBufferSize: tcp.OptBufferSize{
+			ReadBufferSize: 1024,
+		},
+	})
+}
+
+// TestHandlers tests the reference handlers serve their protocols.
+func TestHandlers(t *testing.T) {
+	t.Log("Given the need for reference services to smoke test deployments.")
+	{
+		c := serve(t, handlers.Echo{}).Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("Hello"), []byte("Hello"))
+		t.Log("\tShould echo the bytes received.", success)
+
+		c = serve(t, handlers.Discard{}).Dial(t, tcptest.Lines)
+		c.Send([]byte("Hello"))
+		c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		if n, err := c.Read(make([]byte, 1)); n != 0 || err == nil {
+			t.Fatalf("\tShould discard the bytes received : %d %v %s", n, err, failed)
+		}
+		t.Log("\tShould discard the bytes received.", success)
+
+		now := time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+		c = serve(t, handlers.Time{Now: func() time.Time { return now }}).Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("now"), []byte("2024-02-01T12:00:00Z\r"))
+		t.Log("\tShould answer with the current time.", success)
+
+		c = serve(t, &handlers.Chargen{Lines: 2}).Dial(t, tcptest.Lines)
+		c.Send([]byte("go"))
+		first, second := c.Recv(), c.Recv()
+		if !bytes.HasPrefix(first, []byte(` !"#$%&`)) || !bytes.HasPrefix(second, []byte(`!"#$%&'`)) || len(first) != 73 {
+			t.Fatalf("\tShould answer with the rotating pattern : %q %q %s", first, second, failed)
+		}
+		c.Send([]byte("go"))
+		if third := c.Recv(); !bytes.HasPrefix(third, []byte(`"#$%&'(`)) {
+			t.Fatalf("\tShould continue the pattern over the requests : %q %s", third, failed)
+		}
+		t.Log("\tShould answer with the rotating pattern.", success)
+	}
+}
@@ -0,0 +1,158 @@
+package tcp
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defHealthWindow is the window the error rate is measured over.
+const defHealthWindow = 10 * time.Second
+
+// health maintains the counters sampled at the start of the window and
+// the error rate of the last window, so every caller of Health sees the
+// same rate.
+type health struct {
+	mu       sync.Mutex
+	requests int64
+	errors   int64
+	rate     float64
+}
+
+// Health represents the detailed status of a TCP value.
+type Health struct {
+	Healthy        bool    `json:"healthy"`
+	Accepting      bool    `json:"accepting"`       // The accept routine is running.
+	Paused         bool    `json:"paused"`          // New connections wait in the listen backlog.
+	Dropping       bool    `json:"dropping"`        // New connections are being dropped.
+	Maintenance    bool    `json:"maintenance"`     // New connections are answered with the maintenance banner.
+	Breaker        bool    `json:"breaker"`         // The breaker was tripped by the handlers.
+	AcceptFailures int     `json:"accept_failures"` // Consecutive accept errors.
+	Connections    int     `json:"connections"`
+	Processing     int64   `json:"processing"`   // Requests currently being processed.
+	Workers        int64   `json:"workers"`      // Size of the worker pool, zero when requests aren't pipelined.
+	WorkersBusy    int64   `json:"workers_busy"` // Workers processing a request.
+	WorkQueued     int64   `json:"work_queued"`  // Requests waiting for a worker.
+	Saturated      bool    `json:"saturated"`    // Every worker is busy and requests are waiting.
+	ErrorRate      float64 `json:"error_rate"`   // Read and write errors per request over the last window.
+}
+
+// Health reports whether the TCP value is able to service clients. The
+// error rate is calculated over the last window of HealthWindow, so it
+// doesn't depend on how often Health is called.
+func (t *TCP) Health() Health {
+	m := t.Metrics()
+
+	_, open := t.Breaker()
+
+	h := Health{
+		Accepting:      atomic.LoadInt32(&t.accepting) == 1,
+		Paused:         t.Paused(),
+		Dropping:       atomic.LoadInt32(&t.dropConns) == 1,
+		Maintenance:    atomic.LoadInt32(&t.maintenance) == 1,
+		Breaker:        open,
+		AcceptFailures: int(atomic.LoadInt32(&t.acceptFailures)),
+		Connections:    m.Connections,
+		Processing:     m.Processing,
+		Workers:        atomic.LoadInt64(&t.workers),
+		WorkersBusy:    atomic.LoadInt64(&t.workersBusy),
+		WorkQueued:     atomic.LoadInt64(&t.workQueued),
+	}
+	h.Saturated = h.Workers > 0 && h.WorkersBusy >= h.Workers && h.WorkQueued > 0
+
+	t.health.mu.Lock()
+	{
+		h.ErrorRate = t.health.rate
+	}
+	t.health.mu.Unlock()
+
+	h.Healthy = h.Accepting && !h.Paused && !h.Dropping && !h.Maintenance && !h.Breaker && h.AcceptFailures == 0 && !h.Saturated
+	if t.HealthMaxErrorRate > 0 && h.ErrorRate > t.HealthMaxErrorRate {
+		h.Healthy = false
+	}
+
+	return h
+}
+
+// startHealthWindow starts measuring the error rate over each window,
+// from the counters at Start.
+func (t *TCP) startHealthWindow() {
+	window := t.HealthWindow
+	if window <= 0 {
+		window = defHealthWindow
+	}
+
+	// The activity before Start belongs to no window.
+	t.sampleHealth()
+	t.health.mu.Lock()
+	{
+		t.health.rate = 0
+	}
+	t.health.mu.Unlock()
+
+	t.runEvery(window, t.sampleHealth)
+}
+
+// sampleHealth calculates the error rate of the window that ended and
+// starts the next one.
+func (t *TCP) sampleHealth() {
+	m := t.Metrics()
+	errors := m.ReadErrors + m.WriteErrors
+
+	t.health.mu.Lock()
+	{
+		requests := m.Requests - t.health.requests
+		failed := errors - t.health.errors
+
+		t.health.rate = 0
+		if requests+failed > 0 {
+			t.health.rate = float64(failed) / float64(requests+failed)
+		}
+
+		t.health.requests = m.Requests
+		t.health.errors = errors
+	}
+	t.health.mu.Unlock()
+}
+
+// startHealth starts the HTTP health listener. It responds with the
+// health as JSON and a 503 status when the TCP value is unhealthy.
+func (t *TCP) startHealth() error {
+	l, err := net.Listen("tcp", t.HealthAddr)
+	if err != nil {
+		return err
+	}
+
+	srv := http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := t.Health()
+
+			w.Header().Set("Content-Type", "application/json")
+			if !h.Healthy {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+
+			json.NewEncoder(w).Encode(h)
+		}),
+	}
+
+	t.wg.Add(2)
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			t.serverEvent(EvtHealth, TypError, t.HealthAddr, "%v", err)
+		}
+		t.wg.Done()
+	}()
+
+	go func() {
+		<-t.done
+		srv.Close()
+		t.wg.Done()
+	}()
+
+	t.serverEvent(EvtHealth, TypInfo, l.Addr().String(), "waiting")
+	return nil
+}
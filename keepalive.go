@@ -0,0 +1,37 @@
+package tcp
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// keepAlive applies the keepalive settings to the connection. Connections
+// from a listener that are not TCP connections are left as they are.
+func (c *client) keepAlive() {
+	if !c.t.NoKeepAlive && c.t.KeepAliveIdle == 0 && c.t.KeepAliveInterval == 0 && c.t.KeepAliveCount == 0 {
+		return
+	}
+
+	tc, ok := c.conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+
+	err := tc.SetKeepAliveConfig(net.KeepAliveConfig{
+		Enable:   !c.t.NoKeepAlive,
+		Idle:     c.t.KeepAliveIdle,
+		Interval: c.t.KeepAliveInterval,
+		Count:    c.t.KeepAliveCount,
+	})
+	if err != nil {
+		c.t.Event(EvtAccept, TypError, c.ipAddress, "keepalive : %v", err)
+	}
+}
+
+// peerDead reports whether the error comes from the network declaring the
+// client gone, such as when it stopped answering the keepalive probes.
+// It's not a deadline of the package even though it reports a timeout.
+func peerDead(err error) bool {
+	return errors.Is(err, syscall.ETIMEDOUT)
+}
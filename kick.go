@@ -0,0 +1,65 @@
+package tcp
+
+import (
+	"net"
+	"time"
+)
+
+// Kick closes the connections the function matches and returns how many
+// it closed. Like Drop, the connections are closed in the background, so
+// a handler can kick its own connection. The function is given the stats
+// of each connection and can be built with the Match functions.
+func (t *TCP) Kick(match func(s Stat) bool) int {
+	var n int
+	for _, c := range t.clientList() {
+		if !match(c.stat()) {
+			continue
+		}
+
+		// Skip the connections already closing, such as the ones a
+		// previous call kicked.
+		if !c.setCloseReason(CloseDropped) {
+			continue
+		}
+
+		// Close the connection here so a write blocked on a client
+		// that stopped reading is released.
+		t.Event(EvtDrop, TypInfo, c.ipAddress, "kicked")
+		c.conn.Close()
+		go c.drop(CloseDropped)
+		n++
+	}
+
+	return n
+}
+
+// MatchTag matches the connections with the tag.
+func MatchTag(tag string) func(s Stat) bool {
+	return func(s Stat) bool {
+		for _, st := range s.Tags {
+			if st == tag {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MatchCIDR matches the connections from an IP in the network.
+func MatchCIDR(network *net.IPNet) func(s Stat) bool {
+	return func(s Stat) bool {
+		host, _, err := net.SplitHostPort(s.IP)
+		if err != nil {
+			return false
+		}
+		return network.Contains(net.ParseIP(host))
+	}
+}
+
+// MatchIdle matches the connections with no request read since the time,
+// such as time.Now().Add(-5 * time.Minute).
+func MatchIdle(since time.Time) func(s Stat) bool {
+	return func(s Stat) bool {
+		return s.LastAct.Before(since)
+	}
+}
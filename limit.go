@@ -0,0 +1,64 @@
+package tcp
+
+import (
+	"fmt"
+	"net"
+)
+
+// FrameTooLargeError is returned for a request over MaxRequestBytes. Codecs
+// return it for a frame over the limit of their protocol, so the server
+// can reject the request the way the protocol expects. It matches
+// ErrFrameTooLarge with errors.Is.
+type FrameTooLargeError struct {
+	Size int // Bytes of the frame, or read so far when it was cut short.
+	Max  int
+}
+
+// Error implements the error interface for FrameTooLargeError.
+func (e *FrameTooLargeError) Error() string {
+	return fmt.Sprintf("%v : Size[ %d ] Max[ %d ]", ErrFrameTooLarge, e.Size, e.Max)
+}
+
+// Unwrap returns ErrFrameTooLarge.
+func (e *FrameTooLargeError) Unwrap() error {
+	return ErrFrameTooLarge
+}
+
+// Temporary reports the connection can't be read any further.
+func (e *FrameTooLargeError) Temporary() bool {
+	return false
+}
+
+// checkSize fails the request read when it's over MaxRequestBytes.
+func (c *client) checkSize(length int) error {
+	if c.t.MaxRequestBytes <= 0 || length <= c.t.MaxRequestBytes {
+		return nil
+	}
+
+	return &FrameTooLargeError{Size: length, Max: c.t.MaxRequestBytes}
+}
+
+// rejectFrame writes the response of OnFrameTooLarge, if any, before the
+// connection closes for the frame too large. The response follows the
+// responses of the requests already read.
+func (c *client) rejectFrame(err error) {
+	c.t.Event(EvtRead, TypError, c.ipAddress, "%v", err)
+	c.setCloseReason(CloseFrameTooLarge)
+	c.strike(StrikeFrameTooLarge)
+
+	if c.t.OnFrameTooLarge == nil {
+		return
+	}
+
+	tcpAddr := c.conn.RemoteAddr().(*net.TCPAddr)
+	resp := c.t.OnFrameTooLarge(tcpAddr, err)
+	if resp == nil {
+		return
+	}
+
+	c.jobs.Wait()
+	resp.TCPAddr = tcpAddr
+	if werr := c.write(resp); werr != nil {
+		c.t.Event(EvtWrite, TypError, c.ipAddress, "frame too large : %v", werr)
+	}
+}
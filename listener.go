@@ -0,0 +1,138 @@
+package tcp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// listenFDStart is the first file descriptor systemd passes when using
+// socket activation.
+const listenFDStart = 3
+
+// listen creates the listener for the TCP value. The first time it is
+// called, a listener provided or inherited is used if there is one, which
+// is recorded in provided since it can't be bound again.
+func (t *TCP) listen() (net.Listener, error) {
+
+	// Provided and inherited listeners can only be taken over once. If
+	// the listener has to be re-established, we bind to the configured
+	// address.
+	t.provided = false
+	if !t.inherited {
+		t.inherited = true
+
+		if t.Listener != nil {
+			t.provided = true
+			return t.Listener, nil
+		}
+
+		f, err := t.inheritedFile()
+		if err != nil {
+			return nil, err
+		}
+
+		if f != nil {
+			t.provided = true
+			return fileListener(f)
+		}
+	}
+
+	if t.Listen != nil {
+		return t.Listen(t.NetType, t.Config.Addr)
+	}
+
+	l, err := net.ListenTCP(t.NetType, t.tcpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tune the accept queue if configured.
+	if t.Backlog > 0 {
+		if err := setBacklog(l, t.Backlog); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+
+	return l, nil
+}
+
+// inheritedFile returns the file for the inherited listener if one was
+// configured or passed through systemd socket activation.
+func (t *TCP) inheritedFile() (*os.File, error) {
+	if t.ListenerFile != nil {
+		return t.ListenerFile, nil
+	}
+
+	if !t.SocketActivation {
+		return nil, nil
+	}
+
+	// Validate the file descriptors were passed to this process.
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, errors.New("socket activation : LISTEN_PID does not match this process")
+	}
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, errors.New("socket activation : LISTEN_FDS has no file descriptors")
+	}
+
+	// Unset the variables so child processes don't try to use them.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	return os.NewFile(listenFDStart, "listener"), nil
+}
+
+// fileListener converts the file into a TCP listener. The file is closed
+// since net.FileListener works on a copy of the file descriptor.
+func fileListener(f *os.File) (net.Listener, error) {
+	defer f.Close()
+
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("inherit listener : %v", err)
+	}
+
+	if _, ok := l.(*net.TCPListener); !ok {
+		l.Close()
+		return nil, fmt.Errorf("inherit listener : %T is not a TCP listener", l)
+	}
+
+	return l, nil
+}
+
+// File returns a copy of the listener's file descriptor. The file can be
+// passed to a new process, which provides it through Config.ListenerFile to
+// take over the listener without dropping new connections. It is the
+// caller's responsibility to close the file when done.
+func (t *TCP) File() (*os.File, error) {
+	var listener net.Listener
+	t.listenerMu.Lock()
+	{
+		listener = t.listener
+	}
+	t.listenerMu.Unlock()
+
+	if listener == nil {
+		return nil, errors.New("this TCP has not been started")
+	}
+
+	// filer is declared to test for the existence of the method
+	// coming from the net package.
+	type filer interface {
+		File() (*os.File, error)
+	}
+
+	f, ok := listener.(filer)
+	if !ok {
+		return nil, fmt.Errorf("%T does not provide a file", listener)
+	}
+
+	return f.File()
+}
@@ -0,0 +1,153 @@
+package tcp
+
+import (
+	"fmt"
+	"runtime"
+	rtmetrics "runtime/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defLoadCheckEvery is how often the load is checked when not configured.
+const defLoadCheckEvery = time.Second
+
+// heapMetric is the runtime metric of the bytes of the live and unswept
+// objects on the heap.
+const heapMetric = "/memory/classes/heap/objects:bytes"
+
+// Load is a sample of the load of the process checked by the admission
+// controller.
+type Load struct {
+	Goroutines int
+	HeapBytes  uint64
+	Processing int64 // Requests being processed.
+}
+
+// load maintains the state of the admission controller.
+type load struct {
+	mu         sync.Mutex
+	overloaded bool
+	reason     string
+	last       Load
+	recovered  chan struct{} // Closed once the overload ends.
+}
+
+// sampleLoad reads the load of the process.
+func (t *TCP) sampleLoad() Load {
+	sample := []rtmetrics.Sample{{Name: heapMetric}}
+	rtmetrics.Read(sample)
+
+	l := Load{
+		Goroutines: runtime.NumGoroutine(),
+		Processing: atomic.LoadInt64(&t.metrics.processing),
+	}
+	if sample[0].Value.Kind() == rtmetrics.KindUint64 {
+		l.HeapBytes = sample[0].Value.Uint64()
+	}
+
+	return l
+}
+
+// overload returns the first threshold the load exceeds, empty when the
+// load is within all of them.
+func (cfg *Config) overload(l Load) string {
+	switch {
+	case cfg.MaxGoroutines > 0 && l.Goroutines > cfg.MaxGoroutines:
+		return fmt.Sprintf("goroutines %d over %d", l.Goroutines, cfg.MaxGoroutines)
+	case cfg.MaxHeapBytes > 0 && l.HeapBytes > cfg.MaxHeapBytes:
+		return fmt.Sprintf("heap %d bytes over %d", l.HeapBytes, cfg.MaxHeapBytes)
+	case cfg.MaxProcessing > 0 && l.Processing > int64(cfg.MaxProcessing):
+		return fmt.Sprintf("processing %d requests over %d", l.Processing, cfg.MaxProcessing)
+	}
+	return ""
+}
+
+// loadLimited reports whether any load threshold is configured.
+func (cfg *Config) loadLimited() bool {
+	return cfg.MaxGoroutines > 0 || cfg.MaxHeapBytes > 0 || cfg.MaxProcessing > 0
+}
+
+// loadCheckEvery returns how often the load is checked.
+func (cfg *Config) loadCheckEvery() time.Duration {
+	if cfg.LoadCheckEvery > 0 {
+		return cfg.LoadCheckEvery
+	}
+	return defLoadCheckEvery
+}
+
+// checkLoad samples the load and switches the admission of new
+// connections when it crosses the thresholds.
+func (t *TCP) checkLoad() {
+	l := t.sampleLoad()
+	reason := t.overload(l)
+
+	var was bool
+	t.load.mu.Lock()
+	{
+		was = t.load.overloaded
+		t.load.overloaded = reason != ""
+		t.load.last = l
+		if reason != "" {
+			t.load.reason = reason
+		}
+
+		switch {
+		case reason != "" && !was:
+			t.load.recovered = make(chan struct{})
+		case reason == "" && was:
+			close(t.load.recovered)
+		}
+	}
+	t.load.mu.Unlock()
+
+	switch {
+	case reason != "" && !was:
+		t.Event(EvtLoad, TypError, "", "overloaded : %s", reason)
+	case reason == "" && was:
+		t.Event(EvtLoad, TypInfo, "", "recovered")
+	}
+}
+
+// Overloaded returns why the admission controller is holding back new
+// connections and whether it still is, along with the last load sampled.
+func (t *TCP) Overloaded() (string, bool, Load) {
+	t.load.mu.Lock()
+	defer t.load.mu.Unlock()
+
+	return t.load.reason, t.load.overloaded, t.load.last
+}
+
+// admit reports whether a new connection is admitted given the load. While
+// the process is overloaded, the accept waits up to the LoadDelay for the
+// load to recover, leaving the new connections in the backlog of the
+// listener, before the connection is rejected.
+func (t *TCP) admit() (string, bool) {
+	var reason string
+	var over bool
+	var recovered chan struct{}
+
+	t.load.mu.Lock()
+	{
+		reason = t.load.reason
+		over = t.load.overloaded
+		recovered = t.load.recovered
+	}
+	t.load.mu.Unlock()
+
+	if !over || t.LoadDelay <= 0 {
+		return reason, !over
+	}
+
+	timer := t.clock().NewTimer(t.LoadDelay)
+	defer timer.Stop()
+
+	select {
+	case <-recovered:
+	case <-timer.C():
+	case <-t.done:
+	}
+
+	reason, over, _ = t.Overloaded()
+	return reason, !over
+}
@@ -0,0 +1,117 @@
+package tcp
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// defBannerTimeout is the time allowed to deliver a banner before the
+// connection is closed.
+const defBannerTimeout = 5 * time.Second
+
+// defBanners is the number of banners written at once to the connections
+// turned away.
+const defBanners = 64
+
+// Maintenance sets a flag to tell the accept routine to answer new
+// connections with the configured maintenance message and close them.
+func (t *TCP) Maintenance(on bool) {
+	if on {
+		atomic.StoreInt32(&t.maintenance, 1)
+		return
+	}
+
+	atomic.StoreInt32(&t.maintenance, 0)
+}
+
+// startBanner writes the message to the connection on its own goroutine
+// so the accept routine is not blocked. A flood of connections must not
+// turn into as many banners, so the connections past the limit are
+// closed without one.
+func (t *TCP) startBanner(conn net.Conn, acceptedAt time.Time, msg []byte) {
+	select {
+	case t.banners <- struct{}{}:
+		t.wg.Add(1)
+		go func() {
+			t.banner(conn, acceptedAt, msg)
+			<-t.banners
+		}()
+	default:
+		conn.Close()
+	}
+}
+
+// banner binds the connection to the handlers, writes the message through
+// the response handler and closes the connection.
+func (t *TCP) banner(conn net.Conn, acceptedAt time.Time, msg []byte) {
+	defer t.wg.Done()
+	defer conn.Close()
+
+	ipAddress := conn.RemoteAddr().String()
+	deadline := time.Now().Add(defBannerTimeout)
+	conn.SetDeadline(deadline)
+
+	c := client{
+		t:         t,
+		conn:      conn,
+		ipAddress: ipAddress,
+		timeConn:  acceptedAt,
+	}
+
+	if err := c.bindBanner(); err != nil {
+		t.Event(EvtAccept, TypError, ipAddress, "banner : %v", err)
+		return
+	}
+
+	// The handshake clears the deadline, so it's set again for the
+	// rest of the time allowed.
+	conn.SetDeadline(deadline)
+
+	r := Response{
+		TCPAddr: conn.RemoteAddr().(*net.TCPAddr),
+		Data:    msg,
+		Length:  len(msg),
+	}
+
+	if err := c.write(&r); err != nil {
+		t.Event(EvtAccept, TypError, ipAddress, "banner : %v", err)
+	}
+
+	c.writeMu.Lock()
+	{
+		c.unbind()
+	}
+	c.writeMu.Unlock()
+}
+
+// bindBanner binds the connection with only what writing the banner
+// needs: the TLS layer and the connection handler. The client is turned
+// away, so nothing is read from it or counted as a connection served.
+func (c *client) bindBanner() error {
+	handlers := c.t.handlers()
+
+	// A sniffed port waits for the client to speak first to know if it
+	// uses TLS, so its banner is written in the clear.
+	var conn net.Conn = c.conn
+	if c.t.TLSConfig != nil && len(c.t.Sniff) == 0 {
+		tlsConn, err := c.handshake(conn, c.t.TLSConfig)
+		if err != nil {
+			return err
+		}
+		conn = tlsConn
+	}
+
+	r, w := handlers.ConnHandler.Bind(conn)
+
+	c.writeMu.Lock()
+	{
+		c.handlers = handlers
+		c.rw = conn
+		c.reader = r
+		c.writer = w
+	}
+	c.writeMu.Unlock()
+
+	return nil
+}
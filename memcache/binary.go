@@ -0,0 +1,177 @@
+package memcache
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/ardanlabs/tcp"
+)
+
+// headerSize is the size of the header of a binary packet.
+const headerSize = 24
+
+// Set of magic bytes of the binary protocol.
+const (
+	MagicRequest  = 0x80
+	MagicResponse = 0x81
+)
+
+// Opcode is the command of a binary packet.
+type Opcode byte
+
+// Set of opcodes of the binary protocol.
+const (
+	OpGet       Opcode = 0x00
+	OpSet       Opcode = 0x01
+	OpAdd       Opcode = 0x02
+	OpReplace   Opcode = 0x03
+	OpDelete    Opcode = 0x04
+	OpIncrement Opcode = 0x05
+	OpDecrement Opcode = 0x06
+	OpQuit      Opcode = 0x07
+	OpFlush     Opcode = 0x08
+	OpGetQ      Opcode = 0x09
+	OpNoop      Opcode = 0x0A
+	OpVersion   Opcode = 0x0B
+	OpGetK      Opcode = 0x0C
+	OpGetKQ     Opcode = 0x0D
+	OpAppend    Opcode = 0x0E
+	OpPrepend   Opcode = 0x0F
+	OpStat      Opcode = 0x10
+	OpSetQ      Opcode = 0x11
+	OpTouch     Opcode = 0x1C
+	OpGAT       Opcode = 0x1D
+)
+
+// Set of status codes of the binary responses.
+const (
+	StatusOK             uint16 = 0x00
+	StatusKeyNotFound    uint16 = 0x01
+	StatusKeyExists      uint16 = 0x02
+	StatusValueTooLarge  uint16 = 0x03
+	StatusInvalidArgs    uint16 = 0x04
+	StatusNotStored      uint16 = 0x05
+	StatusNonNumeric     uint16 = 0x06
+	StatusUnknownCommand uint16 = 0x81
+	StatusOutOfMemory    uint16 = 0x82
+)
+
+// Packet is a request or response of the binary protocol. Status holds the
+// vbucket id of a request.
+type Packet struct {
+	Magic    byte
+	Opcode   Opcode
+	DataType byte
+	Status   uint16
+	Opaque   uint32
+	CAS      uint64
+	Extras   []byte
+	Key      []byte
+	Value    []byte
+}
+
+// Kind returns the opcode of the packet, to key an OpRouter.
+func (p Packet) Kind() Opcode {
+	return p.Opcode
+}
+
+// Response returns a response to the request with the status, keeping its
+// opcode and opaque so the client can match them.
+func (p Packet) Response(status uint16) Packet {
+	return Packet{
+		Magic:  MagicResponse,
+		Opcode: p.Opcode,
+		Status: status,
+		Opaque: p.Opaque,
+	}
+}
+
+// Append appends the encoding of the packet to the buffer.
+func (p Packet) Append(b []byte) []byte {
+	var hdr [headerSize]byte
+	hdr[0] = p.Magic
+	hdr[1] = byte(p.Opcode)
+	binary.BigEndian.PutUint16(hdr[2:], uint16(len(p.Key)))
+	hdr[4] = byte(len(p.Extras))
+	hdr[5] = p.DataType
+	binary.BigEndian.PutUint16(hdr[6:], p.Status)
+	binary.BigEndian.PutUint32(hdr[8:], uint32(len(p.Extras)+len(p.Key)+len(p.Value)))
+	binary.BigEndian.PutUint32(hdr[12:], p.Opaque)
+	binary.BigEndian.PutUint64(hdr[16:], p.CAS)
+
+	b = append(b, hdr[:]...)
+	b = append(b, p.Extras...)
+	b = append(b, p.Key...)
+	return append(b, p.Value...)
+}
+
+// BinaryCodec reads and writes the packets of the binary protocol. It
+// implements tcp.Codec.
+type BinaryCodec struct {
+	MaxBodySize int // Largest body of a packet, defaults to 1MB.
+}
+
+// Read implements the tcp.Codec interface. It returns the bytes of the
+// next packet.
+func (c BinaryCodec) Read(reader io.Reader) ([]byte, error) {
+	hdr := make([]byte, headerSize)
+	if _, err := io.ReadFull(reader, hdr); err != nil {
+		return nil, err
+	}
+
+	if hdr[0] != MagicRequest && hdr[0] != MagicResponse {
+		return nil, &ProtocolError{Reason: fmt.Sprintf("invalid magic %#x", hdr[0])}
+	}
+
+	body := int(binary.BigEndian.Uint32(hdr[8:]))
+	if keys := int(binary.BigEndian.Uint16(hdr[2:])) + int(hdr[4]); keys > body {
+		return nil, &ProtocolError{Reason: fmt.Sprintf("key and extras of %d bytes over the body of %d", keys, body)}
+	}
+
+	max := c.MaxBodySize
+	if max <= 0 {
+		max = defMaxValueSize
+	}
+	if body > max {
+		return nil, fmt.Errorf("memcache : body of %d bytes over %d : %w", body, max, tcp.ErrFrameTooLarge)
+	}
+
+	return readChunks(reader, hdr, body)
+}
+
+// Decode implements the tcp.Codec interface.
+func (BinaryCodec) Decode(data []byte) (Packet, error) {
+	if len(data) < headerSize {
+		return Packet{}, &ProtocolError{Reason: "packet too short"}
+	}
+
+	keyLen := int(binary.BigEndian.Uint16(data[2:]))
+	extLen := int(data[4])
+	body := int(binary.BigEndian.Uint32(data[8:]))
+	if len(data)-headerSize != body || keyLen+extLen > body {
+		return Packet{}, &ProtocolError{Reason: "body length mismatch"}
+	}
+
+	rest := data[headerSize:]
+	return Packet{
+		Magic:    data[0],
+		Opcode:   Opcode(data[1]),
+		DataType: data[5],
+		Status:   binary.BigEndian.Uint16(data[6:]),
+		Opaque:   binary.BigEndian.Uint32(data[12:]),
+		CAS:      binary.BigEndian.Uint64(data[16:]),
+		Extras:   rest[:extLen],
+		Key:      rest[extLen : extLen+keyLen],
+		Value:    rest[extLen+keyLen:],
+	}, nil
+}
+
+// Encode implements the tcp.Codec interface.
+func (BinaryCodec) Encode(p Packet) ([]byte, error) {
+	if len(p.Key) > 0xFFFF || len(p.Extras) > 0xFF {
+		return nil, fmt.Errorf("memcache : key of %d bytes or extras of %d bytes : %w", len(p.Key), len(p.Extras), tcp.ErrFrameTooLarge)
+	}
+
+	return p.Append(nil), nil
+}
@@ -0,0 +1,250 @@
+// Package memcache provides codecs for the memcached text and binary
+// protocols, so caches and memcached compatible proxies can be built on
+// the tcp package. TextCodec reads the commands of the text protocol and
+// writes the replies as they are given, and BinaryCodec reads and writes
+// the packets of the binary protocol. Both implement tcp.Codec and the
+// commands can key an OpRouter:
+//
+//	rt := tcp.NewOpRouter[string, memcache.TextCommand, []byte](memcache.TextCommand.Command)
+//	rt.Handle("version", func(r *tcp.Request, cmd memcache.TextCommand) ([]byte, error) {
+//	    return []byte("VERSION 1.6.0\r\n"), nil
+//	})
+//	t, err := tcp.Serve("cache", cfg, memcache.TextCodec{}, rt.Process)
+package memcache
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ardanlabs/tcp"
+)
+
+// Default limits of the commands read.
+const (
+	defMaxLineSize  = 2048
+	defMaxValueSize = 1 << 20
+)
+
+// chunkSize is the most bytes allocated ahead of the ones received, so a
+// length alone can't make the reader allocate up to the limits.
+const chunkSize = 64 << 10
+
+// Set of replies of the text protocol.
+var (
+	Stored     = []byte("STORED\r\n")
+	NotStored  = []byte("NOT_STORED\r\n")
+	Exists     = []byte("EXISTS\r\n")
+	NotFound   = []byte("NOT_FOUND\r\n")
+	Deleted    = []byte("DELETED\r\n")
+	Touched    = []byte("TOUCHED\r\n")
+	End        = []byte("END\r\n")
+	ErrorReply = []byte("ERROR\r\n")
+)
+
+// ErrNoBufio is returned when the connection is not bound to a
+// bufio.Reader.
+var ErrNoBufio = errors.New("memcache : reader must be a *bufio.Reader")
+
+// ProtocolError is returned when a command breaks the protocol. The
+// connection is closed since it can't be read any further.
+type ProtocolError struct {
+	Reason string
+}
+
+// Error implements the error interface for ProtocolError.
+func (pe *ProtocolError) Error() string {
+	return "memcache : " + pe.Reason
+}
+
+// Temporary reports the connection can't be read after a protocol error.
+func (pe *ProtocolError) Temporary() bool {
+	return false
+}
+
+// =============================================================================
+
+// TextCommand is a command of the text protocol. Data holds the data block
+// of the storage commands.
+type TextCommand struct {
+	Name string   // Lower cased, such as "get" or "set".
+	Args []string // Fields after the name, such as the key, flags, exptime and bytes of a set.
+	Data []byte
+}
+
+// Command returns the name of the command, to key an OpRouter.
+func (tc TextCommand) Command() string {
+	return tc.Name
+}
+
+// NoReply reports whether the client asked for no reply, in which case the
+// handler returns tcp.ErrNoReply.
+func (tc TextCommand) NoReply() bool {
+	return len(tc.Args) > 0 && tc.Args[len(tc.Args)-1] == "noreply"
+}
+
+// storage reports whether the command is followed by a data block.
+func storage(name string) bool {
+	switch name {
+	case "set", "add", "replace", "append", "prepend", "cas":
+		return true
+	}
+	return false
+}
+
+// TextCodec reads the commands of the text protocol. The replies are
+// written as they are given, such as Stored or the output of AppendValue.
+// It implements tcp.Codec.
+type TextCodec struct {
+	MaxLineSize  int // Largest command line, defaults to 2k.
+	MaxValueSize int // Largest data block, defaults to 1MB.
+}
+
+// Read implements the tcp.Codec interface. It returns the bytes of the
+// next command including its data block.
+func (c TextCodec) Read(reader io.Reader) ([]byte, error) {
+	br, ok := reader.(*bufio.Reader)
+	if !ok {
+		return nil, ErrNoBufio
+	}
+
+	maxLine := c.MaxLineSize
+	if maxLine <= 0 {
+		maxLine = defMaxLineSize
+	}
+
+	var line []byte
+	for {
+		frag, err := br.ReadSlice('\n')
+		line = append(line, frag...)
+		if len(line) > maxLine {
+			return nil, fmt.Errorf("memcache : line over %d bytes : %w", maxLine, tcp.ErrFrameTooLarge)
+		}
+		if err == nil {
+			break
+		}
+		if err != bufio.ErrBufferFull {
+			if err == io.EOF && len(line) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) == 0 || !storage(strings.ToLower(fields[0])) {
+		return line, nil
+	}
+
+	n, err := c.dataSize(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	return readChunks(br, line, n+2)
+}
+
+// readChunks appends the next n bytes of the reader to the data. The bytes
+// are allocated in chunks as they arrive.
+func readChunks(reader io.Reader, data []byte, n int) ([]byte, error) {
+	end := len(data) + n
+	for len(data) < end {
+		start := len(data)
+		data = slices.Grow(data, min(end-start, chunkSize))
+		data = data[:min(end, cap(data))]
+
+		if _, err := io.ReadFull(reader, data[start:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+// dataSize returns the size of the data block of a storage command.
+func (c TextCodec) dataSize(fields []string) (int, error) {
+	if len(fields) < 5 {
+		return 0, &ProtocolError{Reason: fmt.Sprintf("%s needs key, flags, exptime and bytes", fields[0])}
+	}
+
+	n, err := strconv.Atoi(fields[4])
+	if err != nil || n < 0 {
+		return 0, &ProtocolError{Reason: fmt.Sprintf("invalid bytes %q", fields[4])}
+	}
+
+	max := c.MaxValueSize
+	if max <= 0 {
+		max = defMaxValueSize
+	}
+	if n > max {
+		return 0, fmt.Errorf("memcache : value of %d bytes over %d : %w", n, max, tcp.ErrFrameTooLarge)
+	}
+
+	return n, nil
+}
+
+// Decode implements the tcp.Codec interface.
+func (c TextCodec) Decode(data []byte) (TextCommand, error) {
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		return TextCommand{}, &ProtocolError{Reason: "missing line ending"}
+	}
+
+	fields := strings.Fields(string(data[:i]))
+	if len(fields) == 0 {
+		return TextCommand{}, &ProtocolError{Reason: "empty command"}
+	}
+
+	tc := TextCommand{
+		Name: strings.ToLower(fields[0]),
+		Args: fields[1:],
+	}
+
+	if storage(tc.Name) {
+		n, err := c.dataSize(fields)
+		if err != nil {
+			return TextCommand{}, err
+		}
+
+		block := data[i+1:]
+		if len(block) != n+2 || !bytes.HasSuffix(block, []byte("\r\n")) {
+			return TextCommand{}, &ProtocolError{Reason: "bad data chunk"}
+		}
+		tc.Data = block[:n]
+	}
+
+	return tc, nil
+}
+
+// Encode implements the tcp.Codec interface.
+func (TextCodec) Encode(reply []byte) ([]byte, error) {
+	return reply, nil
+}
+
+// AppendValue appends the VALUE line and data block of an item found by a
+// retrieval command to the reply. The cas unique is written when it's not
+// zero, as the gets command requires. Append End once every item found
+// is appended.
+func AppendValue(b []byte, key string, flags uint32, data []byte, cas uint64) []byte {
+	b = append(b, "VALUE "...)
+	b = append(b, key...)
+	b = append(b, ' ')
+	b = strconv.AppendUint(b, uint64(flags), 10)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, int64(len(data)), 10)
+	if cas != 0 {
+		b = append(b, ' ')
+		b = strconv.AppendUint(b, cas, 10)
+	}
+	b = append(b, "\r\n"...)
+	b = append(b, data...)
+	return append(b, "\r\n"...)
+}
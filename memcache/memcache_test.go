@@ -0,0 +1,214 @@
+package memcache_test
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/ardanlabs/tcp"
+	"github.com/ardanlabs/tcp/memcache"
+	"github.com/ardanlabs/tcp/tcptest"
+)
+
+// Success and failure markers.
+var (
+	success = "✓"
+	failed  = "✗"
+)
+
+// TestTextCodec tests the commands of the text protocol are framed.
+func TestTextCodec(t *testing.T) {
+	t.Log("Given the need to read the commands of the memcached text protocol.")
+	{
+		codec := memcache.TextCodec{MaxValueSize: 16}
+
+		tests := []struct {
+			name string
+			wire string
+			cmd  memcache.TextCommand
+		}{
+			{"get", "get a b\r\n", memcache.TextCommand{Name: "get", Args: []string{"a", "b"}}},
+			{"set", "SET k 5 0 5\r\nhel\nl\r\n", memcache.TextCommand{Name: "set", Args: []string{"k", "5", "0", "5"}, Data: []byte("hel\nl")}},
+			{"cas", "cas k 0 0 2 7 noreply\r\nhi\r\n", memcache.TextCommand{Name: "cas", Args: []string{"k", "0", "0", "2", "7", "noreply"}, Data: []byte("hi")}},
+		}
+
+		for _, tt := range tests {
+			br := bufio.NewReader(strings.NewReader(tt.wire + "version\r\n"))
+			data, err := codec.Read(br)
+			if err != nil || string(data) != tt.wire {
+				t.Errorf("\tShould read %s : %q %v %s", tt.name, data, err, failed)
+				continue
+			}
+
+			cmd, err := codec.Decode(data)
+			if err != nil || cmd.Name != tt.cmd.Name || strings.Join(cmd.Args, " ") != strings.Join(tt.cmd.Args, " ") || !bytes.Equal(cmd.Data, tt.cmd.Data) {
+				t.Errorf("\tShould decode %s : %+v %v %s", tt.name, cmd, err, failed)
+				continue
+			}
+			t.Logf("\tShould frame %s. %s", tt.name, success)
+		}
+
+		if cmd, _ := codec.Decode([]byte(tests[2].wire)); !cmd.NoReply() {
+			t.Fatalf("\tShould report a command asking for no reply %s", failed)
+		}
+		t.Log("\tShould report a command asking for no reply.", success)
+
+		var pe *memcache.ProtocolError
+		if _, err := codec.Read(bufio.NewReader(strings.NewReader("set k 0 0\r\n"))); !errors.As(err, &pe) {
+			t.Fatalf("\tShould reject a storage command without bytes : %v %s", err, failed)
+		}
+		t.Log("\tShould reject a storage command without bytes.", success)
+
+		if _, err := codec.Read(bufio.NewReader(strings.NewReader("set k 0 0 100\r\n"))); !errors.Is(err, tcp.ErrFrameTooLarge) {
+			t.Fatalf("\tShould reject values over the max size : %v %s", err, failed)
+		}
+		t.Log("\tShould reject values over the max size.", success)
+
+		if _, err := codec.Read(bufio.NewReader(strings.NewReader("set k 0 0 5\r\nhi"))); err != io.ErrUnexpectedEOF {
+			t.Fatalf("\tShould report a value cut short : %v %s", err, failed)
+		}
+		t.Log("\tShould report a value cut short.", success)
+
+		big := strings.Repeat("x", 100<<10)
+		wire := fmt.Sprintf("set k 0 0 %d\r\n%s\r\n", len(big), big)
+		if data, err := (memcache.TextCodec{}).Read(bufio.NewReader(strings.NewReader(wire))); err != nil || string(data) != wire {
+			t.Fatalf("\tShould read values larger than a chunk : %d %v %s", len(data), err, failed)
+		}
+
+		// The value announced is allocated as its bytes arrive.
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		huge := memcache.TextCodec{MaxValueSize: 64 << 20}
+		if _, err := huge.Read(bufio.NewReader(strings.NewReader("set k 0 0 67108864\r\nhi"))); err != io.ErrUnexpectedEOF {
+			t.Fatalf("\tShould report a large value cut short : %v %s", err, failed)
+		}
+		runtime.ReadMemStats(&after)
+		if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+			t.Fatalf("\tShould allocate the value as its bytes arrive : %d %s", n, failed)
+		}
+		t.Log("\tShould allocate the value as its bytes arrive.", success)
+
+		got := memcache.AppendValue(nil, "k", 5, []byte("hi"), 7)
+		if string(got) != "VALUE k 5 2 7\r\nhi\r\n" {
+			t.Fatalf("\tShould append the value of an item : %q %s", got, failed)
+		}
+		t.Log("\tShould append the value of an item.", success)
+	}
+}
+
+// TestBinaryCodec tests the packets of the binary protocol are framed.
+func TestBinaryCodec(t *testing.T) {
+	t.Log("Given the need to read the packets of the memcached binary protocol.")
+	{
+		codec := memcache.BinaryCodec{MaxBodySize: 64}
+
+		p := memcache.Packet{
+			Magic:  memcache.MagicRequest,
+			Opcode: memcache.OpSet,
+			Opaque: 9,
+			CAS:    3,
+			Extras: []byte{0, 0, 0, 5, 0, 0, 0, 0},
+			Key:    []byte("key"),
+			Value:  []byte("value"),
+		}
+
+		wire, err := codec.Encode(p)
+		if err != nil || len(wire) != 24+16 || wire[0] != 0x80 || wire[1] != 0x01 || wire[3] != 3 || wire[4] != 8 || wire[11] != 16 {
+			t.Fatalf("\tShould encode the packet : %x %v %s", wire, err, failed)
+		}
+		t.Log("\tShould encode the packet.", success)
+
+		data, err := codec.Read(bytes.NewReader(append(wire, 0x80)))
+		if err != nil || !bytes.Equal(data, wire) {
+			t.Fatalf("\tShould read the packet : %x %v %s", data, err, failed)
+		}
+
+		got, err := codec.Decode(data)
+		if err != nil || got.Opcode != p.Opcode || got.Opaque != 9 || got.CAS != 3 || !bytes.Equal(got.Extras, p.Extras) || string(got.Key) != "key" || string(got.Value) != "value" {
+			t.Fatalf("\tShould decode the packet : %+v %v %s", got, err, failed)
+		}
+		t.Log("\tShould decode the packet.", success)
+
+		var pe *memcache.ProtocolError
+		bad := append([]byte{0x42}, wire[1:]...)
+		if _, err := codec.Read(bytes.NewReader(bad)); !errors.As(err, &pe) {
+			t.Fatalf("\tShould reject an invalid magic : %v %s", err, failed)
+		}
+		t.Log("\tShould reject an invalid magic.", success)
+
+		p.Value = bytes.Repeat([]byte("x"), 100)
+		wire, _ = codec.Encode(p)
+		if _, err := codec.Read(bytes.NewReader(wire)); !errors.Is(err, tcp.ErrFrameTooLarge) {
+			t.Fatalf("\tShould reject bodies over the max size : %v %s", err, failed)
+		}
+		t.Log("\tShould reject bodies over the max size.", success)
+	}
+}
+
+// TestCodec tests the codecs serve commands through an OpRouter.
+func TestCodec(t *testing.T) {
+	t.Log("Given the need to build a memcached compatible cache on the handler pipeline.")
+	{
+		rt := tcp.NewOpRouter[string, memcache.TextCommand, []byte](memcache.TextCommand.Command)
+		rt.Handle("set", func(r *tcp.Request, cmd memcache.TextCommand) ([]byte, error) {
+			if cmd.NoReply() {
+				return nil, tcp.ErrNoReply
+			}
+			return memcache.Stored, nil
+		})
+		rt.Handle("get", func(r *tcp.Request, cmd memcache.TextCommand) ([]byte, error) {
+			reply := memcache.AppendValue(nil, cmd.Args[0], 0, []byte("hi"), 0)
+			return append(reply, memcache.End...), nil
+		})
+
+		hs := tcp.Handlers[memcache.TextCommand, []byte](memcache.TextCodec{}, rt.Process)
+		s := tcptest.NewServer(t, tcp.Config{
+			ReqHandler:  hs.ReqHandler,
+			RespHandler: hs.RespHandler,
+
+			OptBufferSize: tcp.OptBufferSize{
+				ReadBufferSize: 1024,
+			},
+		})
+
+		c := s.Dial(t, tcptest.Lines)
+		c.Write([]byte("set k 0 0 2 noreply\r\nhi\r\nset k 0 0 2\r\nhi\r\n"))
+		c.Expect([]byte("STORED\r"))
+		t.Log("\tShould answer the storage commands.", success)
+
+		c.Write([]byte("get k\r\n"))
+		c.Expect([]byte("VALUE k 0 2\r"))
+		c.Expect([]byte("hi\r"))
+		c.Expect([]byte("END\r"))
+		t.Log("\tShould answer the retrieval commands.", success)
+
+		bhs := tcp.Handlers[memcache.Packet, memcache.Packet](memcache.BinaryCodec{}, echoPacket)
+		bs := tcptest.NewServer(t, tcp.Config{
+			ReqHandler:  bhs.ReqHandler,
+			RespHandler: bhs.RespHandler,
+
+			OptBufferSize: tcp.OptBufferSize{
+				ReadBufferSize: 1024,
+			},
+		})
+
+		bc := bs.Dial(t, tcptest.Lines)
+		req := memcache.Packet{Magic: memcache.MagicRequest, Opcode: memcache.OpNoop, Opaque: 5}
+		bc.Write(req.Append(nil))
+		resp := make([]byte, 24)
+		if _, err := io.ReadFull(bc, resp); err != nil || resp[0] != memcache.MagicResponse || resp[1] != byte(memcache.OpNoop) || resp[15] != 5 {
+			t.Fatalf("\tShould answer the binary packets : %x %v %s", resp, err, failed)
+		}
+		t.Log("\tShould answer the binary packets.", success)
+	}
+}
+
+// echoPacket answers a request with a successful response.
+func echoPacket(r *tcp.Request, p memcache.Packet) (memcache.Packet, error) {
+	return p.Response(memcache.StatusOK), nil
+}
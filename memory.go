@@ -0,0 +1,117 @@
+package tcp
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// memory signals the readers held back by the memory caps when buffered
+// bytes are released.
+type memory struct {
+	mu    sync.Mutex
+	freed chan struct{} // Closed on the next release, nil with no readers waiting.
+}
+
+// waitFreed returns the channel closed on the next release of buffered
+// bytes.
+func (m *memory) waitFreed() <-chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.freed == nil {
+		m.freed = make(chan struct{})
+	}
+	return m.freed
+}
+
+// release wakes the readers waiting for buffered bytes to be released.
+func (m *memory) release() {
+	m.mu.Lock()
+	{
+		if m.freed != nil {
+			close(m.freed)
+			m.freed = nil
+		}
+	}
+	m.mu.Unlock()
+}
+
+// memoryLimited reports whether any memory cap is configured.
+func (cfg *Config) memoryLimited() bool {
+	return cfg.MaxConnBytes > 0 || cfg.MaxBufferedBytes > 0
+}
+
+// account adds n to the bytes buffered by the connection, the requests
+// read and not yet processed and the responses not yet written.
+func (c *client) account(n int) {
+	if n == 0 {
+		return
+	}
+
+	atomic.AddInt64(&c.buffered, int64(n))
+	total := atomic.AddInt64(&c.t.metrics.bufferedBytes, int64(n))
+
+	switch {
+	case n > 0:
+		storeMax(&c.t.metrics.bufferedBytesMax, total)
+	case c.t.memoryLimited():
+		c.t.mem.release()
+	}
+}
+
+// overMemory returns the cap the buffered bytes reached, empty when they
+// are below all of them.
+func (c *client) overMemory() string {
+	if max := int64(c.t.MaxConnBytes); max > 0 {
+		if n := atomic.LoadInt64(&c.buffered); n >= max {
+			return fmt.Sprintf("connection buffers %d bytes of %d", n, max)
+		}
+	}
+
+	if max := int64(c.t.MaxBufferedBytes); max > 0 {
+		if n := atomic.LoadInt64(&c.t.metrics.bufferedBytes); n >= max {
+			return fmt.Sprintf("connections buffer %d bytes of %d", n, max)
+		}
+	}
+
+	return ""
+}
+
+// reserve holds back reading the next request while the buffered bytes
+// are over a cap, until enough of them are released. It returns an error
+// when the connection must be closed instead, as CloseOverMemory asks or
+// since it was dropped while waiting.
+func (c *client) reserve() error {
+	reason := c.overMemory()
+	if reason == "" {
+		return nil
+	}
+
+	if c.t.CloseOverMemory {
+		c.setCloseReason(CloseMemoryLimit)
+		return fmt.Errorf("memory : closing : %s", reason)
+	}
+
+	atomic.AddInt64(&c.t.metrics.memoryStalls, 1)
+	c.t.Event(EvtRead, TypInfo, c.ipAddress, "memory : waiting : %s", reason)
+
+	for {
+
+		// Take the channel before checking again so a release in
+		// between is not missed.
+		freed := c.t.mem.waitFreed()
+		if c.overMemory() == "" {
+			return nil
+		}
+
+		select {
+		case <-freed:
+		case <-c.dropped:
+			return fmt.Errorf("memory : dropped while waiting : %w", ErrDisconnected)
+		case <-c.t.done:
+			c.setCloseReason(CloseShutdown)
+			return fmt.Errorf("memory : stopped while waiting : %w", ErrShutdown)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package tcp
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metrics maintains the counters reported by Metrics. All fields are
+// accessed atomically except the close reasons, guarded by mu.
+type metrics struct {
+	requests    int64
+	processing  int64
+	readErrors  int64
+	writeErrors int64
+
+	requestTimeouts int64
+	corruptFrames   int64
+	writeBatches    int64
+	loadRejects     int64
+	memoryStalls    int64
+	tarpitted       int64
+	bans            int64
+	banRejects      int64
+	geoDenied       int64
+	quotaRejects    int64
+
+	bufferedBytes    int64
+	bufferedBytesMax int64
+
+	acceptLatencyLast  int64
+	acceptLatencyTotal int64
+	acceptLatencyCount int64
+	acceptLatencyMax   int64
+
+	mu     sync.Mutex
+	closes map[CloseReason]int64
+}
+
+// Metrics represents the aggregated statistics of a TCP value.
+type Metrics struct {
+	Connections      int
+	Requests         int64
+	Processing       int64 // Requests currently being processed.
+	ReadErrors       int64
+	WriteErrors      int64
+	RequestTimeouts  int64         // Requests processed past their deadline.
+	CorruptFrames    int64         // Requests whose checksum didn't match.
+	WriteBatches     int64         // Batches of writes coalesced into one write to the socket.
+	LoadRejects      int64         // Connections rejected while the process was overloaded.
+	BufferedBytes    int64         // Bytes of the requests and responses buffered by the connections.
+	BufferedBytesMax int64         // Most bytes buffered at once.
+	MemoryStalls     int64         // Reads held back while the buffered bytes were over a cap.
+	Tarpitted        int64         // Connections accepted into the tarpit.
+	Bans             int64         // IPs banned, for their strikes or by the user.
+	BanRejects       int64         // Connections closed for coming from a banned IP.
+	GeoDenied        int64         // Connections the geo policy denied.
+	QuotaRejects     int64         // Requests and connections rejected over the quota of their identity.
+	AcceptLatency    time.Duration // Time from accept to Bind for the latest connection, including any TLS handshake.
+	AcceptLatencyAvg time.Duration
+	AcceptLatencyMax time.Duration
+	Closes           map[CloseReason]int64 // Connections closed by reason.
+}
+
+// Metrics returns the aggregated statistics. Growth in the accept latency
+// is the earliest sign the accept routine or the Bind hook is becoming a
+// bottleneck.
+func (t *TCP) Metrics() Metrics {
+	m := Metrics{
+		Connections:      t.Connections(),
+		Requests:         atomic.LoadInt64(&t.metrics.requests),
+		Processing:       atomic.LoadInt64(&t.metrics.processing),
+		ReadErrors:       atomic.LoadInt64(&t.metrics.readErrors),
+		WriteErrors:      atomic.LoadInt64(&t.metrics.writeErrors),
+		RequestTimeouts:  atomic.LoadInt64(&t.metrics.requestTimeouts),
+		CorruptFrames:    atomic.LoadInt64(&t.metrics.corruptFrames),
+		WriteBatches:     atomic.LoadInt64(&t.metrics.writeBatches),
+		LoadRejects:      atomic.LoadInt64(&t.metrics.loadRejects),
+		BufferedBytes:    atomic.LoadInt64(&t.metrics.bufferedBytes),
+		BufferedBytesMax: atomic.LoadInt64(&t.metrics.bufferedBytesMax),
+		MemoryStalls:     atomic.LoadInt64(&t.metrics.memoryStalls),
+		Tarpitted:        atomic.LoadInt64(&t.metrics.tarpitted),
+		Bans:             atomic.LoadInt64(&t.metrics.bans),
+		BanRejects:       atomic.LoadInt64(&t.metrics.banRejects),
+		GeoDenied:        atomic.LoadInt64(&t.metrics.geoDenied),
+		QuotaRejects:     atomic.LoadInt64(&t.metrics.quotaRejects),
+		AcceptLatency:    time.Duration(atomic.LoadInt64(&t.metrics.acceptLatencyLast)),
+		AcceptLatencyMax: time.Duration(atomic.LoadInt64(&t.metrics.acceptLatencyMax)),
+	}
+
+	if n := atomic.LoadInt64(&t.metrics.acceptLatencyCount); n > 0 {
+		m.AcceptLatencyAvg = time.Duration(atomic.LoadInt64(&t.metrics.acceptLatencyTotal) / n)
+	}
+
+	t.metrics.mu.Lock()
+	{
+		m.Closes = make(map[CloseReason]int64, len(t.metrics.closes))
+		for reason, n := range t.metrics.closes {
+			m.Closes[reason] = n
+		}
+	}
+	t.metrics.mu.Unlock()
+
+	return m
+}
+
+// acceptLatency records the time a connection waited between being
+// accepted and being bound to the handlers.
+func (m *metrics) acceptLatency(d time.Duration) {
+	atomic.StoreInt64(&m.acceptLatencyLast, int64(d))
+	atomic.AddInt64(&m.acceptLatencyTotal, int64(d))
+	atomic.AddInt64(&m.acceptLatencyCount, 1)
+	storeMax(&m.acceptLatencyMax, int64(d))
+}
+
+// closed counts a connection closed for the reason.
+func (m *metrics) closed(reason CloseReason) {
+	m.mu.Lock()
+	{
+		if m.closes == nil {
+			m.closes = make(map[CloseReason]int64)
+		}
+		m.closes[reason]++
+	}
+	m.mu.Unlock()
+}
+
+// storeMax replaces the value at addr when v is larger.
+func storeMax(addr *int64, v int64) {
+	for {
+		cur := atomic.LoadInt64(addr)
+		if v <= cur || atomic.CompareAndSwapInt64(addr, cur, v) {
+			return
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package tcp
+
+import (
+	"bufio"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrExpvarTaken is returned publishing the metrics under a name already
+// used by an expvar that isn't a map.
+var ErrExpvarTaken = errors.New("expvar name already taken")
+
+// expvarMu serializes the creation of the expvar maps, since expvar panics
+// publishing the same name twice.
+var expvarMu sync.Mutex
+
+// Metric names shared by the exporters. The names follow the Prometheus
+// conventions: counters end in _total and durations are in seconds. Every
+// metric is labeled with the name of the TCP value as "server", so the
+// same dashboards work for every service built on this package.
+const (
+	MetricConnections      = "connections"                // Gauge of open client connections.
+	MetricRequests         = "requests_total"             // Counter of requests read.
+	MetricProcessing       = "requests_in_flight"         // Gauge of requests being processed.
+	MetricReadErrors       = "read_errors_total"          // Counter of failed reads.
+	MetricWriteErrors      = "write_errors_total"         // Counter of failed writes.
+	MetricAcceptLatency    = "accept_latency_seconds"     // Gauge of the latest accept to Bind time.
+	MetricAcceptLatencyAvg = "accept_latency_avg_seconds" // Gauge of the average accept to Bind time.
+	MetricAcceptLatencyMax = "accept_latency_max_seconds" // Gauge of the largest accept to Bind time.
+	MetricRequestTimeouts  = "request_timeouts_total"     // Counter of requests processed past their deadline.
+	MetricCorruptFrames    = "corrupt_frames_total"       // Counter of requests whose checksum didn't match.
+	MetricWriteBatches     = "write_batches_total"        // Counter of batches of writes coalesced into one write.
+	MetricLoadRejects      = "load_rejects_total"         // Counter of connections rejected while overloaded.
+	MetricBufferedBytes    = "buffered_bytes"             // Gauge of the bytes buffered by the connections.
+	MetricBufferedBytesMax = "buffered_bytes_max"         // Gauge of the most bytes buffered at once.
+	MetricMemoryStalls     = "memory_stalls_total"        // Counter of reads held back over a memory cap.
+	MetricTarpitted        = "tarpitted_total"            // Counter of connections accepted into the tarpit.
+	MetricBans             = "bans_total"                 // Counter of IPs banned.
+	MetricBanRejects       = "ban_rejects_total"          // Counter of connections closed for a banned IP.
+	MetricGeoDenied        = "geo_denied_total"           // Counter of connections the geo policy denied.
+	MetricQuotaRejects     = "quota_rejects_total"        // Counter of requests and connections over their quota.
+	MetricCloses           = "closes_total"               // Counter of closed connections by reason.
+)
+
+// MetricLabel is the label holding the name of the TCP value.
+const MetricLabel = "server"
+
+// MetricReasonLabel is the label holding the reason of MetricCloses.
+const MetricReasonLabel = "reason"
+
+// metricDef describes a metric for the exporters.
+type metricDef struct {
+	name    string
+	help    string
+	counter bool
+	value   func(m Metrics) float64
+}
+
+// metricDefs lists the exported metrics in the order they are written.
+var metricDefs = []metricDef{
+	{MetricConnections, "Open client connections.", false, func(m Metrics) float64 { return float64(m.Connections) }},
+	{MetricRequests, "Requests read.", true, func(m Metrics) float64 { return float64(m.Requests) }},
+	{MetricProcessing, "Requests being processed.", false, func(m Metrics) float64 { return float64(m.Processing) }},
+	{MetricReadErrors, "Failed reads.", true, func(m Metrics) float64 { return float64(m.ReadErrors) }},
+	{MetricWriteErrors, "Failed writes.", true, func(m Metrics) float64 { return float64(m.WriteErrors) }},
+	{MetricAcceptLatency, "Time from accept to Bind for the latest connection.", false, func(m Metrics) float64 { return m.AcceptLatency.Seconds() }},
+	{MetricAcceptLatencyAvg, "Average time from accept to Bind.", false, func(m Metrics) float64 { return m.AcceptLatencyAvg.Seconds() }},
+	{MetricAcceptLatencyMax, "Largest time from accept to Bind.", false, func(m Metrics) float64 { return m.AcceptLatencyMax.Seconds() }},
+	{MetricRequestTimeouts, "Requests processed past their deadline.", true, func(m Metrics) float64 { return float64(m.RequestTimeouts) }},
+	{MetricCorruptFrames, "Requests whose checksum didn't match.", true, func(m Metrics) float64 { return float64(m.CorruptFrames) }},
+	{MetricWriteBatches, "Batches of writes coalesced into one write to the socket.", true, func(m Metrics) float64 { return float64(m.WriteBatches) }},
+	{MetricLoadRejects, "Connections rejected while the process was overloaded.", true, func(m Metrics) float64 { return float64(m.LoadRejects) }},
+	{MetricBufferedBytes, "Bytes of the requests and responses buffered by the connections.", false, func(m Metrics) float64 { return float64(m.BufferedBytes) }},
+	{MetricBufferedBytesMax, "Most bytes buffered at once.", false, func(m Metrics) float64 { return float64(m.BufferedBytesMax) }},
+	{MetricMemoryStalls, "Reads held back while the buffered bytes were over a cap.", true, func(m Metrics) float64 { return float64(m.MemoryStalls) }},
+	{MetricTarpitted, "Connections accepted into the tarpit.", true, func(m Metrics) float64 { return float64(m.Tarpitted) }},
+	{MetricBans, "IPs banned, for their strikes or by the user.", true, func(m Metrics) float64 { return float64(m.Bans) }},
+	{MetricBanRejects, "Connections closed for coming from a banned IP.", true, func(m Metrics) float64 { return float64(m.BanRejects) }},
+	{MetricGeoDenied, "Connections the geo policy denied.", true, func(m Metrics) float64 { return float64(m.GeoDenied) }},
+	{MetricQuotaRejects, "Requests and connections rejected over the quota of their identity.", true, func(m Metrics) float64 { return float64(m.QuotaRejects) }},
+}
+
+// labeledMetricDef describes a metric with a value for each value of its
+// label.
+type labeledMetricDef struct {
+	name    string
+	help    string
+	counter bool
+	label   string
+	values  func(m Metrics) map[string]float64
+}
+
+// labeledMetricDefs lists the exported labeled metrics, written after the
+// others.
+var labeledMetricDefs = []labeledMetricDef{
+	{MetricCloses, "Connections closed.", true, MetricReasonLabel, func(m Metrics) map[string]float64 {
+		values := make(map[string]float64, len(m.Closes))
+		for reason, n := range m.Closes {
+			values[string(reason)] = float64(n)
+		}
+		return values
+	}},
+}
+
+// sortedKeys returns the label values in order so the output is stable.
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// RegisterMetrics publishes the metrics of the TCP values as an expvar
+// map named by the prefix and serves them in the Prometheus text format
+// at /metrics on the mux. The mux can be nil to only publish expvars.
+func RegisterMetrics(mux *http.ServeMux, prefix string, servers ...*TCP) error {
+	for _, t := range servers {
+		if err := t.PublishExpvar(prefix); err != nil {
+			return err
+		}
+	}
+
+	if mux != nil {
+		mux.Handle("/metrics", PrometheusHandler(prefix, servers...))
+	}
+	return nil
+}
+
+// PublishExpvar publishes the metrics under the expvar map named by the
+// prefix, keyed by the name of the TCP value. Publishing again replaces
+// the previous entry.
+func (t *TCP) PublishExpvar(prefix string) error {
+	m, err := expvarMap(prefix)
+	if err != nil {
+		return err
+	}
+
+	m.Set(t.Name, expvar.Func(func() interface{} {
+		metrics := t.Metrics()
+
+		values := make(map[string]interface{})
+		for _, def := range metricDefs {
+			values[def.name] = def.value(metrics)
+		}
+		for _, def := range labeledMetricDefs {
+			values[def.name] = def.values(metrics)
+		}
+		return values
+	}))
+	return nil
+}
+
+// expvarMap returns the expvar map named by the prefix, creating it the
+// first time.
+func expvarMap(prefix string) (*expvar.Map, error) {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+
+	switch v := expvar.Get(prefix).(type) {
+	case nil:
+		return expvar.NewMap(prefix), nil
+	case *expvar.Map:
+		return v, nil
+	default:
+		return nil, fmt.Errorf("%s : %w", prefix, ErrExpvarTaken)
+	}
+}
+
+// WritePrometheus writes the metrics of the TCP values in the Prometheus
+// text format with the prefix and an underscore before each name.
+func WritePrometheus(w io.Writer, prefix string, servers ...*TCP) error {
+	metrics := make([]Metrics, len(servers))
+	for i, t := range servers {
+		metrics[i] = t.Metrics()
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, def := range metricDefs {
+		name := prefix + "_" + def.name
+		writePrometheusHeader(bw, name, def.help, def.counter)
+		for i, t := range servers {
+			fmt.Fprintf(bw, "%s{%s=\"%s\"} %v\n", name, MetricLabel, labelEscaper.Replace(t.Name), def.value(metrics[i]))
+		}
+	}
+
+	for _, def := range labeledMetricDefs {
+		name := prefix + "_" + def.name
+		writePrometheusHeader(bw, name, def.help, def.counter)
+		for i, t := range servers {
+			values := def.values(metrics[i])
+			for _, k := range sortedKeys(values) {
+				fmt.Fprintf(bw, "%s{%s=\"%s\",%s=\"%s\"} %v\n", name, MetricLabel, labelEscaper.Replace(t.Name), def.label, labelEscaper.Replace(k), values[k])
+			}
+		}
+	}
+
+	return bw.Flush()
+}
+
+// writePrometheusHeader writes the HELP and TYPE lines of the metric.
+func writePrometheusHeader(bw *bufio.Writer, name string, help string, counter bool) {
+	typ := "gauge"
+	if counter {
+		typ = "counter"
+	}
+
+	fmt.Fprintf(bw, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(bw, "# TYPE %s %s\n", name, typ)
+}
+
+// labelEscaper escapes Prometheus label values.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// PrometheusHandler returns a handler serving the metrics of the TCP
+// values in the Prometheus text format.
+func PrometheusHandler(prefix string, servers ...*TCP) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WritePrometheus(w, prefix, servers...)
+	})
+}
+
+// WriteStatsD writes the metrics of the TCP values as StatsD gauges named
+// prefix.server.metric. Counters are sent as gauges holding the running
+// total so a lost packet never skews them.
+func WriteStatsD(w io.Writer, prefix string, servers ...*TCP) error {
+	bw := bufio.NewWriter(w)
+	for _, t := range servers {
+		metrics := t.Metrics()
+		for _, def := range metricDefs {
+			fmt.Fprintf(bw, "%s.%s.%s:%v|g\n", prefix, t.Name, def.name, def.value(metrics))
+		}
+		for _, def := range labeledMetricDefs {
+			values := def.values(metrics)
+			for _, k := range sortedKeys(values) {
+				fmt.Fprintf(bw, "%s.%s.%s.%s:%v|g\n", prefix, t.Name, def.name, k, values[k])
+			}
+		}
+	}
+
+	return bw.Flush()
+}
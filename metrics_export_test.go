@@ -0,0 +1,178 @@
+package tcp_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ardanlabs/tcp"
+)
+
+// TestMetricsExport tests the exporters use the same metric names.
+func TestMetricsExport(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to export metrics under a common prefix.")
+	{
+		cfg := tcp.Config{
+			NetType:     "tcp4",
+			Addr:        ":0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+		}
+
+		u, err := tcp.New("EXPORT", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+
+		var prom bytes.Buffer
+		if err := tcp.WritePrometheus(&prom, "svc", u); err != nil {
+			t.Fatal("\tShould be able to write the Prometheus format.", failed, err)
+		}
+
+		want := `svc_` + tcp.MetricRequests + `{server="EXPORT"} 0`
+		if !strings.Contains(prom.String(), want+"\n") || !strings.Contains(prom.String(), "# TYPE svc_requests_total counter\n") {
+			t.Fatal("\tShould write the Prometheus metrics.", failed, prom.String())
+		}
+		t.Log("\tShould write the Prometheus metrics.", success)
+
+		names := []string{
+			tcp.MetricCloses,
+			tcp.MetricRequestTimeouts,
+			tcp.MetricCorruptFrames,
+			tcp.MetricWriteBatches,
+			tcp.MetricLoadRejects,
+			tcp.MetricBufferedBytes,
+			tcp.MetricBufferedBytesMax,
+			tcp.MetricMemoryStalls,
+			tcp.MetricTarpitted,
+			tcp.MetricBans,
+			tcp.MetricBanRejects,
+			tcp.MetricGeoDenied,
+			tcp.MetricQuotaRejects,
+		}
+		for _, name := range names {
+			if !strings.Contains(prom.String(), "# TYPE svc_"+name+" ") {
+				t.Fatalf("\tShould export every metric : %s %s", name, failed)
+			}
+		}
+		t.Log("\tShould export every metric.", success)
+
+		var statsd bytes.Buffer
+		if err := tcp.WriteStatsD(&statsd, "svc", u); err != nil {
+			t.Fatal("\tShould be able to write the StatsD format.", failed, err)
+		}
+
+		if !strings.Contains(statsd.String(), "svc.EXPORT."+tcp.MetricConnections+":0|g\n") {
+			t.Fatal("\tShould write the StatsD metrics.", failed, statsd.String())
+		}
+		t.Log("\tShould write the StatsD metrics.", success)
+
+		if err := tcp.RegisterMetrics(nil, "svc", u); err != nil {
+			t.Fatal("\tShould be able to publish the expvar metrics.", failed, err)
+		}
+		v := expvar.Get("svc").(*expvar.Map).Get("EXPORT")
+		if v == nil || !strings.Contains(v.String(), `"`+tcp.MetricReadErrors+`":0`) {
+			t.Fatal("\tShould publish the expvar metrics.", failed, v)
+		}
+		t.Log("\tShould publish the expvar metrics.", success)
+	}
+}
+
+// TestPublishExpvar tests the metrics are published as an expvar map and
+// publishing the same name again doesn't panic.
+func TestPublishExpvar(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to read the metrics through expvar.")
+	{
+		cfg := tcp.Config{
+			NetType:     "tcp4",
+			Addr:        ":0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+		}
+
+		u, err := tcp.New("EXPVAR", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+
+		// The first publish of the prefix creates the map, so publishing
+		// at once must not create it twice.
+		var wg sync.WaitGroup
+		errs := make(chan error, 4)
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- u.PublishExpvar("expvarsvc")
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatal("\tShould be able to publish the same name again.", failed, err)
+			}
+		}
+		t.Log("\tShould be able to publish the same name again.", success)
+
+		m, ok := expvar.Get("expvarsvc").(*expvar.Map)
+		if !ok || m.Get("EXPVAR") == nil {
+			t.Fatal("\tShould publish the metrics under the prefix.", failed, expvar.Get("expvarsvc"))
+		}
+
+		var values map[string]interface{}
+		if err := json.Unmarshal([]byte(m.Get("EXPVAR").String()), &values); err != nil {
+			t.Fatal("\tShould publish the metrics as JSON.", failed, err)
+		}
+
+		names := []string{
+			tcp.MetricConnections,
+			tcp.MetricRequests,
+			tcp.MetricProcessing,
+			tcp.MetricReadErrors,
+			tcp.MetricWriteErrors,
+			tcp.MetricAcceptLatency,
+			tcp.MetricAcceptLatencyAvg,
+			tcp.MetricAcceptLatencyMax,
+			tcp.MetricRequestTimeouts,
+			tcp.MetricCorruptFrames,
+			tcp.MetricWriteBatches,
+			tcp.MetricLoadRejects,
+			tcp.MetricBufferedBytes,
+			tcp.MetricBufferedBytesMax,
+			tcp.MetricMemoryStalls,
+			tcp.MetricTarpitted,
+			tcp.MetricBans,
+			tcp.MetricBanRejects,
+			tcp.MetricGeoDenied,
+			tcp.MetricQuotaRejects,
+			tcp.MetricCloses,
+		}
+		for _, name := range names {
+			if _, ok := values[name]; !ok {
+				t.Fatalf("\tShould publish every metric : %s %v %s", name, values, failed)
+			}
+		}
+		t.Log("\tShould publish every metric.", success)
+
+		if expvar.Get("expvartaken") == nil {
+			expvar.NewInt("expvartaken")
+		}
+		if err := u.PublishExpvar("expvartaken"); !errors.Is(err, tcp.ErrExpvarTaken) {
+			t.Fatal("\tShould refuse a name used by another expvar.", failed, err)
+		}
+		t.Log("\tShould refuse a name used by another expvar.", success)
+	}
+}
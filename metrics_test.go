@@ -0,0 +1,67 @@
+package tcp_test
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/tcp"
+	"github.com/ardanlabs/tcp/tcptest"
+)
+
+// TestAcceptLatency tests the time from accept to Bind is reported for the
+// connections bound.
+func TestAcceptLatency(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to know how long connections wait to be bound.")
+	{
+		// The clock moves while the connections are tagged, which
+		// happens between the accept and the Bind.
+		var n int32
+		clock := tcptest.NewClock(time.Now())
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptTags: tcp.OptTags{
+				Tags: func(conn net.Conn) []string {
+					clock.Advance(time.Duration(atomic.AddInt32(&n, 1)) * 10 * time.Millisecond)
+					return nil
+				},
+			},
+			OptClock: tcp.OptClock{
+				Clock: clock,
+			},
+		})
+
+		if m := s.Metrics(); m.AcceptLatency != 0 || m.AcceptLatencyAvg != 0 || m.AcceptLatencyMax != 0 {
+			t.Fatalf("\tShould report no latency before a connection is bound : %+v %s", m, failed)
+		}
+		t.Log("\tShould report no latency before a connection is bound.", success)
+
+		// The connections are bound one after the other so each one
+		// waits for its own advance only.
+		first := s.Dial(t, tcptest.Lines)
+		first.RoundTrip([]byte("hello"), []byte("hello"))
+		m := s.Metrics()
+		if m.AcceptLatency != 10*time.Millisecond || m.AcceptLatencyAvg != 10*time.Millisecond || m.AcceptLatencyMax != 10*time.Millisecond {
+			t.Fatalf("\tShould report the latency of the connection : %+v %s", m, failed)
+		}
+		t.Log("\tShould report the latency of the connection.", success)
+
+		second := s.Dial(t, tcptest.Lines)
+		second.RoundTrip([]byte("hello"), []byte("hello"))
+		m = s.Metrics()
+		if m.AcceptLatency != 20*time.Millisecond || m.AcceptLatencyMax != 20*time.Millisecond {
+			t.Fatalf("\tShould report the latest and largest latency : %+v %s", m, failed)
+		}
+		if m.AcceptLatencyAvg != 15*time.Millisecond {
+			t.Fatalf("\tShould average the latency over every connection : %+v %s", m, failed)
+		}
+		t.Log("\tShould report the latest, average and largest latency.", success)
+	}
+}
@@ -0,0 +1,62 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// ErrUnknownHandlerSet is returned migrating a connection to a handler set
+// that isn't registered.
+var ErrUnknownHandlerSet = errors.New("unknown handler set")
+
+// Migrate hands the client connection off to the handler set registered
+// under the name, such as once a handshake negotiated the protocol. Like
+// StartTLS, the handoff happens once the request being processed returns,
+// so its response is written by the current handlers, and pipelined
+// requests still being processed finish first. Bytes the client sent past
+// the request and already read are served to the new handlers.
+func (t *TCP) Migrate(tcpAddr *net.TCPAddr, name string) error {
+	hs, ok := t.HandlerSets[name]
+	if !ok {
+		return fmt.Errorf("%w : %s", ErrUnknownHandlerSet, name)
+	}
+	hs = hs.merge(t.handlers())
+
+	c, err := t.client(tcpAddr)
+	if err != nil {
+		return err
+	}
+
+	c.schedule(func() error {
+
+		// Let the pipelined requests finish with the handlers that
+		// read them.
+		c.jobs.Wait()
+
+		c.writeMu.Lock()
+		defer c.writeMu.Unlock()
+
+		// Copy the bytes read past the request since the reader can
+		// go back to a pool once unbound.
+		conn := c.rw
+		if br, ok := c.reader.(*bufio.Reader); ok && br.Buffered() > 0 {
+			left, _ := br.Peek(br.Buffered())
+			r := bufio.NewReaderSize(bytes.NewReader(append([]byte(nil), left...)), len(left))
+			r.Peek(len(left))
+			conn = &peekConn{Conn: conn, reader: r}
+		}
+
+		c.unbind()
+		c.handlers = hs
+		c.rw = conn
+		c.reader, c.writer = hs.ConnHandler.Bind(conn)
+
+		t.Event(EvtRoute, TypInfo, c.ipAddress, "migrate : Set[ %s ]", name)
+		return nil
+	})
+
+	return nil
+}
@@ -0,0 +1,251 @@
+// Package mqtt provides a codec framing MQTT control packets for the tcp
+// package, so brokers and bridges can be built on it without parsing the
+// fixed header themselves. Packets are framed by their fixed header and
+// remaining length and the maximum packet size is enforced before the
+// packet is read. The variable header and payload are left to the
+// handlers. The Type of a Packet can key an OpRouter:
+//
+//	rt := tcp.NewOpRouter[mqtt.PacketType, mqtt.Packet, mqtt.Packet](mqtt.Packet.Kind)
+//	rt.Handle(mqtt.PINGREQ, func(r *tcp.Request, p mqtt.Packet) (mqtt.Packet, error) {
+//	    return mqtt.Packet{Type: mqtt.PINGRESP}, nil
+//	})
+//	t, err := tcp.Serve("broker", cfg, mqtt.Codec{}, rt.Process)
+package mqtt
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/ardanlabs/tcp"
+)
+
+// Limits of the protocol.
+const (
+	maxRemainingLength = 268435455 // Largest remaining length that can be encoded.
+	maxLengthBytes     = 4
+)
+
+// defMaxPacketSize is the largest packet read by default.
+const defMaxPacketSize = 1 << 20
+
+// PacketType is the type of a control packet.
+type PacketType byte
+
+// Set of control packet types.
+const (
+	CONNECT     PacketType = 1
+	CONNACK     PacketType = 2
+	PUBLISH     PacketType = 3
+	PUBACK      PacketType = 4
+	PUBREC      PacketType = 5
+	PUBREL      PacketType = 6
+	PUBCOMP     PacketType = 7
+	SUBSCRIBE   PacketType = 8
+	SUBACK      PacketType = 9
+	UNSUBSCRIBE PacketType = 10
+	UNSUBACK    PacketType = 11
+	PINGREQ     PacketType = 12
+	PINGRESP    PacketType = 13
+	DISCONNECT  PacketType = 14
+	AUTH        PacketType = 15 // MQTT 5 only.
+)
+
+// packetNames maps the packet types to their names.
+var packetNames = [...]string{"RESERVED", "CONNECT", "CONNACK", "PUBLISH", "PUBACK", "PUBREC", "PUBREL", "PUBCOMP", "SUBSCRIBE", "SUBACK", "UNSUBSCRIBE", "UNSUBACK", "PINGREQ", "PINGRESP", "DISCONNECT", "AUTH"}
+
+// String implements the fmt.Stringer interface.
+func (pt PacketType) String() string {
+	if int(pt) < len(packetNames) {
+		return packetNames[pt]
+	}
+	return fmt.Sprintf("PacketType(%d)", byte(pt))
+}
+
+// flags returns the flags the packet type requires, or false when the
+// flags vary like for PUBLISH.
+func (pt PacketType) flags() (byte, bool) {
+	switch pt {
+	case PUBLISH:
+		return 0, false
+	case PUBREL, SUBSCRIBE, UNSUBSCRIBE:
+		return 0x2, true
+	}
+	return 0, true
+}
+
+// Packet is a control packet. Body holds the variable header and the
+// payload that follow the fixed header.
+type Packet struct {
+	Type  PacketType
+	Flags byte // The low 4 bits of the fixed header, such as the QoS of a PUBLISH.
+	Body  []byte
+}
+
+// Kind returns the type of the packet, to key an OpRouter.
+func (p Packet) Kind() PacketType {
+	return p.Type
+}
+
+// Append appends the encoding of the packet to the buffer. The flags the
+// packet type requires are set for it.
+func (p Packet) Append(b []byte) []byte {
+	flags := p.Flags & 0x0F
+	if required, ok := p.Type.flags(); ok {
+		flags = required
+	}
+
+	b = append(b, byte(p.Type)<<4|flags)
+	b = appendLength(b, len(p.Body))
+	return append(b, p.Body...)
+}
+
+// appendLength appends the remaining length as a variable byte integer.
+func appendLength(b []byte, n int) []byte {
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if n == 0 {
+			return b
+		}
+	}
+}
+
+// =============================================================================
+
+// ProtocolError is returned when a packet breaks the protocol. The
+// connection is closed since it can't be read any further.
+type ProtocolError struct {
+	Reason string
+}
+
+// Error implements the error interface for ProtocolError.
+func (pe *ProtocolError) Error() string {
+	return "mqtt : " + pe.Reason
+}
+
+// Temporary reports the connection can't be read after a protocol error.
+func (pe *ProtocolError) Temporary() bool {
+	return false
+}
+
+// Codec frames control packets. It implements tcp.Codec.
+type Codec struct {
+	MaxPacketSize int // Largest packet read including the fixed header, defaults to 1MB.
+}
+
+// Read implements the tcp.Codec interface. It returns the bytes of the
+// next packet.
+func (c Codec) Read(reader io.Reader) ([]byte, error) {
+	max := c.MaxPacketSize
+	if max <= 0 {
+		max = defMaxPacketSize
+	}
+
+	return ReadPacket(reader, max)
+}
+
+// Decode implements the tcp.Codec interface.
+func (Codec) Decode(data []byte) (Packet, error) {
+	if len(data) < 2 {
+		return Packet{}, &ProtocolError{Reason: "packet too short"}
+	}
+
+	p := Packet{
+		Type:  PacketType(data[0] >> 4),
+		Flags: data[0] & 0x0F,
+	}
+
+	i := 1
+	var length, shift int
+	for {
+		if i >= len(data) || i > maxLengthBytes {
+			return Packet{}, &ProtocolError{Reason: "malformed remaining length"}
+		}
+		digit := data[i]
+		i++
+		length |= int(digit&0x7F) << shift
+		shift += 7
+		if digit&0x80 == 0 {
+			break
+		}
+	}
+
+	if len(data)-i != length {
+		return Packet{}, &ProtocolError{Reason: fmt.Sprintf("remaining length %d for %d bytes", length, len(data)-i)}
+	}
+	p.Body = data[i:]
+
+	return p, nil
+}
+
+// Encode implements the tcp.Codec interface.
+func (Codec) Encode(p Packet) ([]byte, error) {
+	if len(p.Body) > maxRemainingLength {
+		return nil, fmt.Errorf("mqtt : %d bytes : %w", len(p.Body), tcp.ErrFrameTooLarge)
+	}
+
+	return p.Append(nil), nil
+}
+
+// ReadPacket reads the bytes of the next packet. The fixed header is
+// checked before the rest is read, and a packet larger than the max fails
+// with an error wrapping tcp.ErrFrameTooLarge without being read.
+func ReadPacket(reader io.Reader, max int) ([]byte, error) {
+	hdr := make([]byte, 1, 1+maxLengthBytes)
+	if _, err := io.ReadFull(reader, hdr); err != nil {
+		return nil, err
+	}
+
+	pt := PacketType(hdr[0] >> 4)
+	if pt == 0 {
+		return nil, &ProtocolError{Reason: "reserved packet type"}
+	}
+	if required, ok := pt.flags(); ok && hdr[0]&0x0F != required {
+		return nil, &ProtocolError{Reason: fmt.Sprintf("invalid flags %#x for %v", hdr[0]&0x0F, pt)}
+	}
+	if pt == PUBLISH && hdr[0]&0x06 == 0x06 {
+		return nil, &ProtocolError{Reason: "invalid QoS 3 for PUBLISH"}
+	}
+
+	var length, shift int
+	var digit [1]byte
+	for i := 0; ; i++ {
+		if i == maxLengthBytes {
+			return nil, &ProtocolError{Reason: "malformed remaining length"}
+		}
+		if _, err := io.ReadFull(reader, digit[:]); err != nil {
+			return nil, unexpected(err)
+		}
+		hdr = append(hdr, digit[0])
+
+		length |= int(digit[0]&0x7F) << shift
+		shift += 7
+		if digit[0]&0x80 == 0 {
+			break
+		}
+	}
+
+	if size := len(hdr) + length; size > max {
+		return nil, fmt.Errorf("mqtt : %v of %d bytes over %d : %w", pt, size, max, tcp.ErrFrameTooLarge)
+	}
+
+	data := make([]byte, len(hdr)+length)
+	copy(data, hdr)
+	if _, err := io.ReadFull(reader, data[len(hdr):]); err != nil {
+		return nil, unexpected(err)
+	}
+
+	return data, nil
+}
+
+// unexpected reports a packet cut short.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
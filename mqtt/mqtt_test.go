@@ -0,0 +1,119 @@
+package mqtt_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/ardanlabs/tcp"
+	"github.com/ardanlabs/tcp/mqtt"
+	"github.com/ardanlabs/tcp/tcptest"
+)
+
+// Success and failure markers.
+var (
+	success = "✓"
+	failed  = "✗"
+)
+
+// TestReadPacket tests packets are framed by their fixed header.
+func TestReadPacket(t *testing.T) {
+	t.Log("Given the need to frame MQTT control packets.")
+	{
+		var codec mqtt.Codec
+
+		tests := []struct {
+			name string
+			p    mqtt.Packet
+			wire []byte
+		}{
+			{"PINGREQ", mqtt.Packet{Type: mqtt.PINGREQ}, []byte{0xC0, 0x00}},
+			{"SUBSCRIBE", mqtt.Packet{Type: mqtt.SUBSCRIBE, Flags: 0x2, Body: []byte{0, 1}}, []byte{0x82, 0x02, 0, 1}},
+			{"PUBLISH", mqtt.Packet{Type: mqtt.PUBLISH, Flags: 0x3, Body: bytes.Repeat([]byte("x"), 200)}, append([]byte{0x33, 0xC8, 0x01}, bytes.Repeat([]byte("x"), 200)...)},
+		}
+
+		for _, tt := range tests {
+			wire, err := codec.Encode(tt.p)
+			if err != nil || !bytes.Equal(wire, tt.wire) {
+				t.Errorf("\tShould encode %s : %x %v %s", tt.name, wire, err, failed)
+				continue
+			}
+
+			data, err := codec.Read(bytes.NewReader(append(tt.wire, 0xE0, 0x00)))
+			if err != nil || !bytes.Equal(data, tt.wire) {
+				t.Errorf("\tShould read %s : %x %v %s", tt.name, data, err, failed)
+				continue
+			}
+
+			p, err := codec.Decode(data)
+			if err != nil || p.Type != tt.p.Type || p.Flags != tt.p.Flags || !bytes.Equal(p.Body, tt.p.Body) {
+				t.Errorf("\tShould decode %s : %+v %v %s", tt.name, p, err, failed)
+				continue
+			}
+			t.Logf("\tShould frame %s. %s", tt.name, success)
+		}
+
+		var pe *mqtt.ProtocolError
+		malformed := []struct {
+			name string
+			wire []byte
+		}{
+			{"reserved type", []byte{0x00, 0x00}},
+			{"invalid flags", []byte{0x80, 0x00}},
+			{"QoS 3", []byte{0x36, 0x00}},
+			{"remaining length over 4 bytes", []byte{0x30, 0xFF, 0xFF, 0xFF, 0xFF, 0x7F}},
+		}
+		for _, tt := range malformed {
+			if _, err := codec.Read(bytes.NewReader(tt.wire)); !errors.As(err, &pe) {
+				t.Errorf("\tShould reject a packet with a %s : %v %s", tt.name, err, failed)
+				continue
+			}
+			t.Logf("\tShould reject a packet with a %s. %s", tt.name, success)
+		}
+
+		small := mqtt.Codec{MaxPacketSize: 64}
+		if _, err := small.Read(bytes.NewReader(tests[2].wire)); !errors.Is(err, tcp.ErrFrameTooLarge) {
+			t.Fatalf("\tShould reject packets over the max size : %v %s", err, failed)
+		}
+		t.Log("\tShould reject packets over the max size.", success)
+
+		if _, err := codec.Read(bytes.NewReader([]byte{0x30, 0x05, 'a'})); err != io.ErrUnexpectedEOF {
+			t.Fatalf("\tShould report a packet cut short : %v %s", err, failed)
+		}
+		t.Log("\tShould report a packet cut short.", success)
+	}
+}
+
+// TestCodec tests the codec serves packets through an OpRouter.
+func TestCodec(t *testing.T) {
+	t.Log("Given the need to build a broker on the handler pipeline.")
+	{
+		rt := tcp.NewOpRouter[mqtt.PacketType, mqtt.Packet, mqtt.Packet](mqtt.Packet.Kind)
+		rt.Handle(mqtt.PINGREQ, func(r *tcp.Request, p mqtt.Packet) (mqtt.Packet, error) {
+			return mqtt.Packet{Type: mqtt.PINGRESP}, nil
+		})
+
+		hs := tcp.Handlers[mqtt.Packet, mqtt.Packet](mqtt.Codec{MaxPacketSize: 16}, rt.Process)
+		s := tcptest.NewServer(t, tcp.Config{
+			ReqHandler:  hs.ReqHandler,
+			RespHandler: hs.RespHandler,
+
+			OptBufferSize: tcp.OptBufferSize{
+				ReadBufferSize: 1024,
+			},
+		})
+
+		c := s.Dial(t, tcptest.Lines)
+		c.Write([]byte{0xC0, 0x00})
+		resp := make([]byte, 2)
+		if _, err := io.ReadFull(c, resp); err != nil || !bytes.Equal(resp, []byte{0xD0, 0x00}) {
+			t.Fatalf("\tShould answer a PINGREQ with a PINGRESP : %x %v %s", resp, err, failed)
+		}
+		t.Log("\tShould answer a PINGREQ with a PINGRESP.", success)
+
+		c.Write([]byte{0x30, 0x20})
+		c.ExpectClosed()
+		t.Log("\tShould close the connection on a packet over the max size.", success)
+	}
+}
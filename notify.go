@@ -0,0 +1,38 @@
+package tcp
+
+import (
+	"net"
+	"os"
+)
+
+// notify sends the state to systemd through the NOTIFY_SOCKET, such as
+// READY=1 once the listener accepts connections, when SystemdNotify is set.
+// Processes not started by a unit with Type=notify have no socket to
+// notify.
+func (t *TCP) notify(state string) {
+	if !t.SystemdNotify {
+		return
+	}
+
+	if err := sdNotify(state); err != nil {
+		t.serverEvent(EvtAccept, TypError, join(t.ipAddress, t.port), "notify : %s : %v", state, err)
+	}
+}
+
+// sdNotify writes the state to the datagram socket systemd named in the
+// NOTIFY_SOCKET. A name starting with @ is in the abstract namespace.
+func sdNotify(state string) error {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return nil
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
@@ -0,0 +1,96 @@
+package tcp
+
+import (
+	"time"
+)
+
+// Pause stops accepting new connections without closing the listener, so
+// they wait in the listen backlog until Resume is called. Open connections
+// are not affected. A listener without SetDeadline finishes the Accept it
+// is blocked in, and that connection waits for Resume too.
+func (t *TCP) Pause() {
+	t.pauseMu.Lock()
+	{
+		if t.resume != nil {
+			t.pauseMu.Unlock()
+			return
+		}
+		t.resume = make(chan struct{})
+	}
+	t.pauseMu.Unlock()
+
+	t.serverEvent(EvtAccept, TypInfo, join(t.ipAddress, t.port), "paused")
+
+	// Wake the accept routine blocked in Accept.
+	t.setAcceptDeadline(time.Now())
+}
+
+// Resume starts accepting new connections again after Pause.
+func (t *TCP) Resume() {
+	t.pauseMu.Lock()
+	{
+		if t.resume == nil {
+			t.pauseMu.Unlock()
+			return
+		}
+		t.setAcceptDeadline(time.Time{})
+		close(t.resume)
+		t.resume = nil
+	}
+	t.pauseMu.Unlock()
+
+	t.serverEvent(EvtAccept, TypInfo, join(t.ipAddress, t.port), "resumed")
+}
+
+// Paused reports whether accepting new connections is paused.
+func (t *TCP) Paused() bool {
+	var paused bool
+	t.pauseMu.Lock()
+	{
+		paused = t.resume != nil
+	}
+	t.pauseMu.Unlock()
+
+	return paused
+}
+
+// waitResume blocks while accepting is paused and reports whether it
+// waited. It returns early once the TCP value is stopping.
+func (t *TCP) waitResume() bool {
+	var resume chan struct{}
+	t.pauseMu.Lock()
+	{
+		resume = t.resume
+	}
+	t.pauseMu.Unlock()
+
+	if resume == nil {
+		return false
+	}
+
+	select {
+	case <-resume:
+	case <-t.done:
+	}
+
+	return true
+}
+
+// setAcceptDeadline sets the deadline of the listener when it supports
+// one.
+func (t *TCP) setAcceptDeadline(deadline time.Time) {
+
+	// deadliner is declared to test for the existence of the method
+	// coming from the net package.
+	type deadliner interface {
+		SetDeadline(t time.Time) error
+	}
+
+	t.listenerMu.Lock()
+	{
+		if d, ok := t.listener.(deadliner); ok {
+			d.SetDeadline(deadline)
+		}
+	}
+	t.listenerMu.Unlock()
+}
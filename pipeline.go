@@ -0,0 +1,254 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrRequestDone is returned when sending a response with the context of a
+// pipelined request whose responses were already written, since it can't
+// be delivered in request order anymore.
+var ErrRequestDone = errors.New("pipelined request already done")
+
+// slotKey is the context key for the slot of a pipelined request.
+type slotKey struct{}
+
+// slot holds the responses of a pipelined request until the responses of
+// the requests read before it have been written.
+type slot struct {
+	c       *client
+	seq     uint64
+	pending []*Response
+	done    bool
+	access  *access     // Access record of the request, if logged.
+	entry   AccessEntry // Access log entry written once the slot is done.
+}
+
+// sequencer writes the responses of a connection in the order the
+// requests were read, no matter the order they finish processing. The
+// responses ready to go are moved out under the lock and written after
+// it by the one caller holding the flushing turn, so a client that stops
+// reading only blocks that caller.
+type sequencer struct {
+	mu       sync.Mutex
+	last     uint64
+	next     uint64
+	slots    map[uint64]*slot
+	out      []outItem
+	flushing bool
+}
+
+// outItem is a response ready to be written, or the access entry of a slot
+// to log once its responses are written when r is nil.
+type outItem struct {
+	sl *slot
+	r  *Response
+}
+
+// open creates the slot for the next request read.
+func (s *sequencer) open(c *client) *slot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.slots == nil {
+		s.slots = make(map[uint64]*slot)
+	}
+
+	sl := slot{c: c, seq: s.last}
+	s.slots[sl.seq] = &sl
+	s.last++
+
+	return &sl
+}
+
+// hold counts the response as queued until it's written.
+func (sl *slot) hold(r *Response) {
+	atomic.AddInt32(&sl.c.congestion.queued, 1)
+	sl.c.queueBytes(r.Length)
+}
+
+// write writes the response when the slot is the oldest one still open,
+// otherwise it's held until it is. A response is also held while another
+// caller has the flushing turn, and written by it. Errors writing held
+// responses are reported through events since the caller has already
+// returned. A slot whose responses were all written takes no more.
+func (s *sequencer) write(sl *slot, r *Response) (held bool, err error) {
+	s.mu.Lock()
+
+	if sl.seq < s.next {
+		s.mu.Unlock()
+		return false, ErrRequestDone
+	}
+
+	if sl.seq != s.next {
+		sl.pending = append(sl.pending, r)
+		sl.hold(r)
+		s.mu.Unlock()
+		return true, nil
+	}
+
+	if s.flushing {
+		s.out = append(s.out, outItem{sl: sl, r: r})
+		sl.hold(r)
+		s.mu.Unlock()
+		return true, nil
+	}
+
+	s.flushing = true
+	s.mu.Unlock()
+
+	err = sl.c.write(r)
+	s.flush()
+
+	return false, err
+}
+
+// close marks the slot done and moves out the responses held by the slots
+// that are now the oldest.
+func (s *sequencer) close(sl *slot) {
+	s.mu.Lock()
+
+	sl.done = true
+
+	for {
+		head, ok := s.slots[s.next]
+		if !ok || !head.done {
+			break
+		}
+
+		// Every response of the slot is written before its entry.
+		if head.access != nil {
+			s.out = append(s.out, outItem{sl: head})
+		}
+
+		delete(s.slots, s.next)
+		s.next++
+
+		// Move the responses the new oldest slot wrote while waiting
+		// out before the ones it writes from now on.
+		if next, ok := s.slots[s.next]; ok {
+			for _, r := range next.pending {
+				s.out = append(s.out, outItem{sl: next, r: r})
+			}
+			next.pending = nil
+		}
+	}
+
+	if s.flushing || len(s.out) == 0 {
+		s.mu.Unlock()
+		return
+	}
+
+	s.flushing = true
+	s.mu.Unlock()
+
+	s.flush()
+}
+
+// flush writes the responses moved out and logs the entries of the slots
+// done, in order, until none is left. The caller holds the flushing turn
+// which is given up once done.
+func (s *sequencer) flush() {
+	for {
+		s.mu.Lock()
+		items := s.out
+		s.out = nil
+		if len(items) == 0 {
+			s.flushing = false
+		}
+		s.mu.Unlock()
+
+		if len(items) == 0 {
+			return
+		}
+
+		for _, it := range items {
+			sl := it.sl
+			if it.r == nil {
+				sl.c.logAccess(sl.entry, sl.access)
+				continue
+			}
+
+			r := it.r
+			atomic.AddInt32(&sl.c.congestion.queued, -1)
+			sl.c.queueBytes(-r.Length)
+			err := sl.c.write(r)
+			if err != nil {
+				sl.c.t.Event(EvtWrite, TypError, sl.c.ipAddress, "pipelined write : %v", err)
+			}
+			sl.access.record(r.Length, err)
+			if sl.c.t.PoolBuffers {
+				r.Release()
+			}
+		}
+	}
+}
+
+// =============================================================================
+
+// startWorkers starts the pool processing pipelined requests until the TCP
+// value is stopped.
+func (t *TCP) startWorkers() {
+	workers := t.Workers
+	if workers <= 0 {
+		workers = 4 * runtime.GOMAXPROCS(0)
+	}
+
+	for i := range t.work {
+		t.work[i] = make(chan func())
+	}
+
+	atomic.StoreInt64(&t.workers, int64(workers))
+
+	for i := 0; i < workers; i++ {
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+
+			for {
+				fn, ok := t.nextWork()
+				if !ok {
+					return
+				}
+				atomic.AddInt64(&t.workersBusy, 1)
+				fn()
+				atomic.AddInt64(&t.workersBusy, -1)
+			}
+		}()
+	}
+}
+
+// submit runs the function on the worker pool, which prefers the work of
+// the higher priorities. The function runs on the calling goroutine once
+// the TCP value is stopping.
+func (t *TCP) submit(p Priority, fn func()) {
+	atomic.AddInt64(&t.workQueued, 1)
+
+	select {
+	case t.work[p.queue()] <- fn:
+		atomic.AddInt64(&t.workQueued, -1)
+	case <-t.done:
+		atomic.AddInt64(&t.workQueued, -1)
+		fn()
+	}
+}
+
+// send writes the response in request order when the context belongs to
+// a pipelined request of this connection. Held reports the response is
+// waiting for the responses of earlier requests.
+func (c *client) send(ctx context.Context, r *Response) (held bool, err error) {
+	if err := c.replyTurn(); err != nil {
+		return false, err
+	}
+
+	if ctx != nil {
+		if sl, ok := ctx.Value(slotKey{}).(*slot); ok && sl.c == c {
+			return c.seq.write(sl, r)
+		}
+	}
+
+	return false, c.write(r)
+}
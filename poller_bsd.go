@@ -0,0 +1,156 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package tcp
+
+import (
+	"errors"
+	"sync"
+	"syscall"
+)
+
+// pollerSupported reports whether the platform has a poller.
+const pollerSupported = true
+
+// poller waits on connections with kqueue. Each connection is armed to be
+// reported once when it becomes readable and armed again once it's parked.
+type poller struct {
+	kq      int
+	wake    [2]int
+	once    sync.Once
+	mu      sync.Mutex
+	clients map[int]*client
+}
+
+// newPoller creates the kqueue and the pipe used to stop waiting.
+func newPoller() (*poller, error) {
+	kq, err := syscall.Kqueue()
+	if err != nil {
+		return nil, err
+	}
+	syscall.CloseOnExec(kq)
+
+	p := poller{
+		kq:      kq,
+		clients: make(map[int]*client),
+	}
+
+	if err := syscall.Pipe(p.wake[:]); err != nil {
+		syscall.Close(kq)
+		return nil, err
+	}
+	for _, fd := range p.wake {
+		syscall.CloseOnExec(fd)
+		syscall.SetNonblock(fd, true)
+	}
+
+	if err := p.control(p.wake[0], syscall.EV_ADD); err != nil {
+		p.closeFDs()
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// control changes the read filter of the file descriptor.
+func (p *poller) control(fd int, flags int) error {
+	var ev syscall.Kevent_t
+	syscall.SetKevent(&ev, fd, syscall.EVFILT_READ, flags)
+
+	_, err := syscall.Kevent(p.kq, []syscall.Kevent_t{ev}, nil, nil)
+	return err
+}
+
+// arm reports the connection once it becomes readable.
+func (p *poller) arm(c *client) error {
+	sc, ok := c.conn.(syscall.Conn)
+	if !ok {
+		return errors.New("poller : connection has no file descriptor")
+	}
+
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var armErr error
+	err = raw.Control(func(fd uintptr) {
+		p.mu.Lock()
+		{
+			p.clients[int(fd)] = c
+			c.pollFD = int(fd)
+
+			armErr = p.control(int(fd), syscall.EV_ADD|syscall.EV_ONESHOT)
+			if armErr != nil {
+				delete(p.clients, int(fd))
+			}
+		}
+		p.mu.Unlock()
+	})
+
+	if err != nil {
+		return err
+	}
+	return armErr
+}
+
+// forget stops waiting on the connection. It's called before the
+// connection is closed so its file descriptor can't be reused yet.
+func (p *poller) forget(c *client) {
+	p.mu.Lock()
+	{
+		if p.clients[c.pollFD] == c {
+			delete(p.clients, c.pollFD)
+			p.control(c.pollFD, syscall.EV_DELETE)
+		}
+	}
+	p.mu.Unlock()
+}
+
+// wait calls ready with every connection that becomes readable until the
+// poller is closed.
+func (p *poller) wait(ready func(c *client)) {
+	defer p.closeFDs()
+
+	events := make([]syscall.Kevent_t, 128)
+	for {
+		n, err := syscall.Kevent(p.kq, nil, events, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		for i := 0; i < n; i++ {
+			fd := int(events[i].Ident)
+			if fd == p.wake[0] {
+				return
+			}
+
+			var c *client
+			p.mu.Lock()
+			{
+				c = p.clients[fd]
+			}
+			p.mu.Unlock()
+
+			if c != nil {
+				ready(c)
+			}
+		}
+	}
+}
+
+// close makes wait return.
+func (p *poller) close() {
+	p.once.Do(func() {
+		syscall.Write(p.wake[1], []byte{1})
+	})
+}
+
+// closeFDs releases the kqueue and the pipe.
+func (p *poller) closeFDs() {
+	syscall.Close(p.kq)
+	syscall.Close(p.wake[0])
+	syscall.Close(p.wake[1])
+}
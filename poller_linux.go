@@ -0,0 +1,153 @@
+//go:build linux
+
+package tcp
+
+import (
+	"errors"
+	"sync"
+	"syscall"
+)
+
+// pollerSupported reports whether the platform has a poller.
+const pollerSupported = true
+
+// poller waits on connections with epoll. Each connection is armed to be
+// reported once when it becomes readable and armed again once it's parked.
+type poller struct {
+	epfd    int
+	wake    [2]int
+	once    sync.Once
+	mu      sync.Mutex
+	clients map[int]*client
+}
+
+// newPoller creates the epoll instance and the pipe used to stop waiting.
+func newPoller() (*poller, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+
+	p := poller{
+		epfd:    epfd,
+		clients: make(map[int]*client),
+	}
+
+	if err := syscall.Pipe2(p.wake[:], syscall.O_CLOEXEC|syscall.O_NONBLOCK); err != nil {
+		syscall.Close(epfd)
+		return nil, err
+	}
+
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(p.wake[0])}
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, p.wake[0], &ev); err != nil {
+		p.closeFDs()
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// arm reports the connection once it becomes readable.
+func (p *poller) arm(c *client) error {
+	sc, ok := c.conn.(syscall.Conn)
+	if !ok {
+		return errors.New("poller : connection has no file descriptor")
+	}
+
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var armErr error
+	err = raw.Control(func(fd uintptr) {
+		ev := syscall.EpollEvent{
+			Events: syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT,
+			Fd:     int32(fd),
+		}
+
+		p.mu.Lock()
+		{
+			op := syscall.EPOLL_CTL_MOD
+			if p.clients[int(fd)] != c {
+				op = syscall.EPOLL_CTL_ADD
+			}
+
+			p.clients[int(fd)] = c
+			c.pollFD = int(fd)
+
+			armErr = syscall.EpollCtl(p.epfd, op, int(fd), &ev)
+			if armErr != nil {
+				delete(p.clients, int(fd))
+			}
+		}
+		p.mu.Unlock()
+	})
+
+	if err != nil {
+		return err
+	}
+	return armErr
+}
+
+// forget stops waiting on the connection. It's called before the
+// connection is closed so its file descriptor can't be reused yet.
+func (p *poller) forget(c *client) {
+	p.mu.Lock()
+	{
+		if p.clients[c.pollFD] == c {
+			delete(p.clients, c.pollFD)
+			syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_DEL, c.pollFD, nil)
+		}
+	}
+	p.mu.Unlock()
+}
+
+// wait calls ready with every connection that becomes readable until the
+// poller is closed.
+func (p *poller) wait(ready func(c *client)) {
+	defer p.closeFDs()
+
+	events := make([]syscall.EpollEvent, 128)
+	for {
+		n, err := syscall.EpollWait(p.epfd, events, -1)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		for i := 0; i < n; i++ {
+			fd := int(events[i].Fd)
+			if fd == p.wake[0] {
+				return
+			}
+
+			var c *client
+			p.mu.Lock()
+			{
+				c = p.clients[fd]
+			}
+			p.mu.Unlock()
+
+			if c != nil {
+				ready(c)
+			}
+		}
+	}
+}
+
+// close makes wait return.
+func (p *poller) close() {
+	p.once.Do(func() {
+		syscall.Write(p.wake[1], []byte{1})
+	})
+}
+
+// closeFDs releases the epoll instance and the pipe.
+func (p *poller) closeFDs() {
+	syscall.Close(p.epfd)
+	syscall.Close(p.wake[0])
+	syscall.Close(p.wake[1])
+}
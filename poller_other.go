@@ -0,0 +1,28 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package tcp
+
+// pollerSupported reports whether the platform has a poller.
+const pollerSupported = false
+
+// poller is not supported on this platform.
+type poller struct{}
+
+// newPoller returns an error since the platform has no poller.
+func newPoller() (*poller, error) {
+	return nil, errNoPoller
+}
+
+// arm is not supported on this platform.
+func (p *poller) arm(c *client) error {
+	return errNoPoller
+}
+
+// forget is not supported on this platform.
+func (p *poller) forget(c *client) {}
+
+// wait is not supported on this platform.
+func (p *poller) wait(ready func(c *client)) {}
+
+// close is not supported on this platform.
+func (p *poller) close() {}
@@ -0,0 +1,113 @@
+package tcp
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// Priority is the class of service of a connection. The worker pool
+// processes the pipelined requests of the higher classes first and the
+// writes of bulk connections are throttled, so control connections stay
+// responsive under load.
+type Priority int
+
+// Set of priorities a connection can have.
+const (
+	PriorityBulk    Priority = -1 // Bulk data, throttled under load.
+	PriorityNormal  Priority = 0  // The priority of the connections by default.
+	PriorityControl Priority = 1  // Admin and control traffic, served first.
+)
+
+// priorities is the number of priorities, indexing the work queues.
+const priorities = 3
+
+// queue returns the index of the work queue of the priority.
+func (p Priority) queue() int {
+	switch {
+	case p < PriorityNormal:
+		return 0
+	case p > PriorityNormal:
+		return 2
+	}
+	return 1
+}
+
+// priorityOf returns the highest priority of the tags, PriorityNormal when
+// none of them has one.
+func (cfg *Config) priorityOf(tags []string) Priority {
+	var p Priority
+	var found bool
+	for _, tag := range tags {
+		if tp, ok := cfg.PriorityTags[tag]; ok && (!found || tp > p) {
+			p = tp
+			found = true
+		}
+	}
+
+	return p
+}
+
+// priority returns the priority of the connection.
+func (c *client) priority() Priority {
+	return Priority(atomic.LoadInt32(&c.prio))
+}
+
+// SetPriority changes the priority of the client connection, such as once
+// it authenticated as an admin. It applies to the requests read from then
+// on.
+func (t *TCP) SetPriority(tcpAddr *net.TCPAddr, p Priority) error {
+	c, err := t.client(tcpAddr)
+	if err != nil {
+		return err
+	}
+
+	atomic.StoreInt32(&c.prio, int32(p))
+	return nil
+}
+
+// nextWork waits for work, taking it from the highest priority queue
+// that has some. It reports false once the TCP value is stopped.
+func (t *TCP) nextWork() (func(), bool) {
+	high, normal, bulk := t.work[2], t.work[1], t.work[0]
+
+	select {
+	case fn := <-high:
+		return fn, true
+	default:
+	}
+
+	select {
+	case fn := <-high:
+		return fn, true
+	case fn := <-normal:
+		return fn, true
+	default:
+	}
+
+	select {
+	case fn := <-high:
+		return fn, true
+	case fn := <-normal:
+		return fn, true
+	case fn := <-bulk:
+		return fn, true
+	case <-t.done:
+		return nil, false
+	}
+}
+
+// throttle waits for a turn to write when the connection is bulk and the
+// bulk writes are limited. The returned function ends the turn.
+func (c *client) throttle() func() {
+	if c.t.bulkWrites == nil || c.priority() >= PriorityNormal {
+		return func() {}
+	}
+
+	select {
+	case c.t.bulkWrites <- struct{}{}:
+	case <-c.t.done:
+		return func() {}
+	}
+
+	return func() { <-c.t.bulkWrites }
+}
@@ -0,0 +1,72 @@
+package tcp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"strings"
+)
+
+// splitIP separates the IP from an "ip:port" address. Values without a
+// port are returned as is.
+func splitIP(ipAddress string) string {
+	if host, _, err := net.SplitHostPort(ipAddress); err == nil {
+		return host
+	}
+	return ipAddress
+}
+
+// AnonymizeTruncate removes the port and the host portion of the address,
+// keeping the /24 network for IPv4 and the /48 network for IPv6.
+func AnonymizeTruncate(ipAddress string) string {
+	if ipAddress == "" {
+		return ""
+	}
+
+	ip := net.ParseIP(splitIP(ipAddress))
+	if ip == nil {
+		return "invalid"
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// AnonymizeHash returns a function that replaces the address with a keyed
+// hash of its IP so the same client can still be correlated across events
+// without revealing who it is. The salt should be kept secret.
+func AnonymizeHash(salt []byte) func(ipAddress string) string {
+	return func(ipAddress string) string {
+		if ipAddress == "" {
+			return ""
+		}
+
+		mac := hmac.New(sha256.New, salt)
+		mac.Write([]byte(splitIP(ipAddress)))
+		return hex.EncodeToString(mac.Sum(nil)[:8])
+	}
+}
+
+// anonymize applies the configured anonymization to the address.
+func (cfg *Config) anonymize(ipAddress string) string {
+	if cfg.Anonymize == nil {
+		return ipAddress
+	}
+	return cfg.Anonymize(ipAddress)
+}
+
+// scrub replaces the address and its IP in the message with their
+// anonymized forms. Errors such as a net.OpError or a WriteError carry the
+// raw address in their strings.
+func (cfg *Config) scrub(ipAddress string, msg string) string {
+	if cfg.Anonymize == nil || ipAddress == "" {
+		return msg
+	}
+
+	ip := splitIP(ipAddress)
+	return strings.NewReplacer(ipAddress, cfg.Anonymize(ipAddress), ip, cfg.Anonymize(ip)).Replace(msg)
+}
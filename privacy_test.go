@@ -0,0 +1,144 @@
+package tcp_test
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/tcp"
+	"github.com/ardanlabs/tcp/tcptest"
+)
+
+// TestAnonymize tests client addresses can be anonymized.
+func TestAnonymize(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to keep client addresses out of the logs.")
+	{
+		tests := []struct {
+			addr string
+			want string
+		}{
+			{"192.168.10.77:5000", "192.168.10.0"},
+			{"10.1.2.3", "10.1.2.0"},
+			{"[2001:db8:85a3:1:2:3:4:5]:5000", "2001:db8:85a3::"},
+		}
+
+		for _, tt := range tests {
+			if got := tcp.AnonymizeTruncate(tt.addr); got != tt.want {
+				t.Errorf("\tShould truncate %q to %q. %s got %q", tt.addr, tt.want, failed, got)
+				continue
+			}
+			t.Logf("\tShould truncate %q to %q. %s", tt.addr, tt.want, success)
+		}
+
+		hash := tcp.AnonymizeHash([]byte("salt"))
+		a, b := hash("192.168.10.77:5000"), hash("192.168.10.77:6000")
+		if a != b || a == "192.168.10.77" {
+			t.Error("\tShould hash the same IP to the same value.", failed, a, b)
+		} else {
+			t.Log("\tShould hash the same IP to the same value.", success)
+		}
+	}
+}
+
+// TestAnonymizeEvents tests the address is anonymized in the events, their
+// messages and the Tap.
+func TestAnonymizeEvents(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to keep client addresses out of the events.")
+	{
+		var mu sync.Mutex
+		var msgs, addrs []string
+		var listen string
+		deny := make(chan bool, 1)
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptEvent: tcp.OptEvent{
+				Event: func(evt, typ int, ipAddress string, format string, a ...interface{}) {
+					mu.Lock()
+					{
+						// The listener is the server's own address.
+						if evt == tcp.EvtAccept && format == "waiting" {
+							listen = ipAddress
+						} else {
+							msgs = append(msgs, fmt.Sprintf(format, a...))
+							addrs = append(addrs, ipAddress)
+						}
+					}
+					mu.Unlock()
+				},
+			},
+			OptPrivacy: tcp.OptPrivacy{
+				Anonymize: tcp.AnonymizeTruncate,
+			},
+			OptTap: tcp.OptTap{
+				Tap: func(ipAddress string, dir tcp.TapDir, data []byte) {
+					mu.Lock()
+					{
+						addrs = append(addrs, ipAddress)
+					}
+					mu.Unlock()
+				},
+			},
+			OptGeo: tcp.OptGeo{
+				GeoPolicy: func(ip net.IP) (tcp.GeoDecision, error) {
+					return tcp.GeoDecision{Deny: <-deny}, nil
+				},
+			},
+		})
+
+		deny <- false
+		c := s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("hello"), []byte("hello"))
+
+		deny <- true
+		c = s.Dial(t, tcptest.Lines)
+		c.ExpectClosed()
+
+		var denied bool
+		for end := time.Now().Add(time.Second); !denied && time.Now().Before(end); time.Sleep(time.Millisecond) {
+			mu.Lock()
+			{
+				for _, msg := range msgs {
+					denied = denied || strings.Contains(msg, tcp.ErrGeoDenied.Error())
+				}
+			}
+			mu.Unlock()
+		}
+		if !denied {
+			t.Fatal("\tShould report the connection denied.", failed)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, addr := range addrs {
+			if addr != "127.0.0.0" {
+				t.Fatalf("\tShould anonymize the address of the events and the Tap : %q %s", addr, failed)
+			}
+		}
+		t.Log("\tShould anonymize the address of the events and the Tap.", success)
+
+		for _, msg := range msgs {
+			if strings.Contains(msg, "127.0.0.1") {
+				t.Fatalf("\tShould anonymize the address in the messages : %q %s", msg, failed)
+			}
+		}
+		t.Log("\tShould anonymize the address in the messages.", success)
+
+		if listen != "127.0.0.1:0" {
+			t.Fatalf("\tShould report the address of the listener as is : %q %s", listen, failed)
+		}
+		t.Log("\tShould report the address of the listener as is.", success)
+	}
+}
@@ -0,0 +1,209 @@
+package tcp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"runtime/pprof"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Phases reported by the profiler.
+const (
+	PhaseRead    = "read"
+	PhaseProcess = "process"
+	PhaseWrite   = "write"
+	PhaseRoute   = "route"
+)
+
+// profileKey identifies a phase and route being profiled.
+type profileKey struct {
+	phase string
+	route string
+}
+
+// profiler accumulates the time spent in each phase and route.
+type profiler struct {
+	mu    sync.Mutex
+	stats map[profileKey]*ProfileStat
+}
+
+// ProfileStat represents the time spent in a phase of the request
+// workflow. The route phase breaks down the time spent processing the
+// requests dispatched by a Router by the matched pattern.
+type ProfileStat struct {
+	Phase string
+	Route string
+	Count int64
+	Total time.Duration
+	Max   time.Duration
+}
+
+// Avg returns the average time spent per call.
+func (ps ProfileStat) Avg() time.Duration {
+	if ps.Count == 0 {
+		return 0
+	}
+	return ps.Total / time.Duration(ps.Count)
+}
+
+// Profile returns the time spent in the handlers by phase and route, the
+// most expensive first. Nothing is recorded unless Profiling is set in
+// the configuration.
+func (t *TCP) Profile() []ProfileStat {
+	var stats []ProfileStat
+	t.profiler.mu.Lock()
+	{
+		for _, ps := range t.profiler.stats {
+			stats = append(stats, *ps)
+		}
+	}
+	t.profiler.mu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Total != stats[j].Total {
+			return stats[i].Total > stats[j].Total
+		}
+		if stats[i].Phase != stats[j].Phase {
+			return stats[i].Phase < stats[j].Phase
+		}
+		return stats[i].Route < stats[j].Route
+	})
+
+	return stats
+}
+
+// ResetProfile discards the time recorded so far.
+func (t *TCP) ResetProfile() {
+	t.profiler.mu.Lock()
+	{
+		t.profiler.stats = nil
+	}
+	t.profiler.mu.Unlock()
+}
+
+// WriteProfile writes the profile as a table.
+func WriteProfile(w io.Writer, stats []ProfileStat) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "PHASE\tROUTE\tCOUNT\tTOTAL\tAVG\tMAX")
+	for _, ps := range stats {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%v\t%v\t%v\n", ps.Phase, ps.Route, ps.Count, ps.Total, ps.Avg(), ps.Max)
+	}
+	return tw.Flush()
+}
+
+// record adds the time spent in a phase and route.
+func (p *profiler) record(phase, route string, d time.Duration) {
+	key := profileKey{phase: phase, route: route}
+
+	p.mu.Lock()
+	{
+		if p.stats == nil {
+			p.stats = make(map[profileKey]*ProfileStat)
+		}
+
+		ps, ok := p.stats[key]
+		if !ok {
+			ps = &ProfileStat{Phase: phase, Route: route}
+			p.stats[key] = ps
+		}
+
+		ps.Count++
+		ps.Total += d
+		if d > ps.Max {
+			ps.Max = d
+		}
+	}
+	p.mu.Unlock()
+}
+
+// profile runs the function as the specified phase and route. The time
+// is measured with the system clock since it reports work being done, and
+// the goroutine carries pprof labels while the function runs so CPU
+// profiles taken with runtime/pprof can be broken down the same way.
+func (t *TCP) profile(phase, route string, fn func()) {
+	if !t.Profiling {
+		fn()
+		return
+	}
+
+	start := time.Now()
+	pprof.Do(context.Background(), t.profileLabels(phase, route), func(context.Context) {
+		fn()
+	})
+	t.profiler.record(phase, route, time.Since(start))
+}
+
+// profileLabels returns the pprof labels for the phase and route.
+func (t *TCP) profileLabels(phase, route string) pprof.LabelSet {
+	if route == "" {
+		return pprof.Labels("tcp.server", t.Name, "tcp.phase", phase)
+	}
+	return pprof.Labels("tcp.server", t.Name, "tcp.phase", phase, "tcp.route", route)
+}
+
+// readProfiled waits for the next request as the read phase. The time
+// recorded starts when data arrives for the request.
+func (c *client) readProfiled() (data []byte, length int, err error) {
+	called := time.Now()
+	pprof.Do(context.Background(), c.t.profileLabels(PhaseRead, ""), func(context.Context) {
+		data, length, err = c.handlers.ReqHandler.Read(c.ipAddress, c.reader)
+	})
+
+	start := c.prof.readStarted(called)
+	if err == nil {
+		c.t.profiler.record(PhaseRead, "", time.Since(start))
+	}
+
+	return data, length, err
+}
+
+// =============================================================================
+
+// profileConn records when data arrives on a connection so the read phase
+// starts when there is something to read, not when the connection goes
+// idle waiting for the next request.
+type profileConn struct {
+	net.Conn
+	arrived time.Time
+}
+
+// Read implements the io.Reader interface.
+func (pc *profileConn) Read(p []byte) (int, error) {
+	n, err := pc.Conn.Read(p)
+	if n > 0 && pc.arrived.IsZero() {
+		pc.arrived = time.Now()
+	}
+	return n, err
+}
+
+// readStarted returns when the current read phase started and resets the
+// arrival time for the next request. Data already buffered by the reader
+// is available immediately.
+func (pc *profileConn) readStarted(called time.Time) time.Time {
+	arrived := pc.arrived
+	pc.arrived = time.Time{}
+
+	if arrived.After(called) {
+		return arrived
+	}
+	return called
+}
+
+// CloseWrite closes the write side of the connection when it supports it.
+func (pc *profileConn) CloseWrite() error {
+	if cw, ok := pc.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// ReadFrom copies from the reader to the connection, keeping the fast
+// paths the connection provides for files and sockets.
+func (pc *profileConn) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(pc.Conn, r)
+}
@@ -0,0 +1,299 @@
+package tcp
+
+import (
+	"context"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defProxyDialTimeout is the time allowed to dial an upstream by default.
+const defProxyDialTimeout = 5 * time.Second
+
+// Balance selects the upstream a proxied connection is forwarded to.
+type Balance int
+
+// Set of balancing strategies.
+const (
+	BalanceFailover   Balance = iota // Always dials the first upstream, then the next ones on failure.
+	BalanceRoundRobin                // Dials the upstreams in turn.
+	BalanceHash                      // Dials the upstream the key of the client hashes to, so a client always lands on the same one.
+)
+
+// UpstreamConnect is the Addr of the UpstreamStat reporting the tunnels of
+// the CONNECT requests, whatever host they reach.
+const UpstreamConnect = "CONNECT"
+
+// UpstreamStat reports the connections forwarded to an upstream. BytesUp
+// is sent from the clients to the upstream and BytesDown the other way.
+type UpstreamStat struct {
+	Addr       string
+	Dials      int64
+	DialErrors int64
+	Active     int64
+	BytesUp    int64
+	BytesDown  int64
+	Healthy    bool
+}
+
+// upstreamStats maintains the counters of an upstream. All fields are
+// accessed atomically.
+type upstreamStats struct {
+	dials      int64
+	dialErrors int64
+	active     int64
+	bytesUp    int64
+	bytesDown  int64
+
+	down   int32 // Set while the upstream is out of the rotation.
+	fails  int32 // Dials failed in a row.
+	downAt int64 // Time it was taken out of the rotation in nanoseconds.
+}
+
+// stat reads the counters of the upstream.
+func (us *upstreamStats) stat(addr string) UpstreamStat {
+	return UpstreamStat{
+		Addr:       addr,
+		Dials:      atomic.LoadInt64(&us.dials),
+		DialErrors: atomic.LoadInt64(&us.dialErrors),
+		Active:     atomic.LoadInt64(&us.active),
+		BytesUp:    atomic.LoadInt64(&us.bytesUp),
+		BytesDown:  atomic.LoadInt64(&us.bytesDown),
+		Healthy:    atomic.LoadInt32(&us.down) == 0,
+	}
+}
+
+// proxy maintains the state of the proxy mode.
+type proxy struct {
+	next uint32
+
+	ringOnce sync.Once
+	ring     hashRing
+
+	mu        sync.Mutex
+	upstreams map[string]*upstreamStats
+	connect   upstreamStats // Counters of the CONNECT tunnels.
+}
+
+// proxying reports whether the connections are forwarded to upstreams
+// instead of being served by the handlers.
+func (cfg *Config) proxying() bool {
+	return len(cfg.Upstreams) > 0 || cfg.PickUpstream != nil || cfg.Connect
+}
+
+// upstream returns the counters of the upstream.
+func (t *TCP) upstream(addr string) *upstreamStats {
+	t.proxy.mu.Lock()
+	defer t.proxy.mu.Unlock()
+
+	if t.proxy.upstreams == nil {
+		t.proxy.upstreams = make(map[string]*upstreamStats)
+	}
+
+	us, ok := t.proxy.upstreams[addr]
+	if !ok {
+		us = &upstreamStats{}
+		t.proxy.upstreams[addr] = us
+	}
+
+	return us
+}
+
+// rotation returns the upstreams in the order they're dialed. The
+// unhealthy upstreams are left out unless none is healthy.
+func (t *TCP) rotation(order []string) []string {
+	addrs := make([]string, 0, len(order))
+	for _, addr := range order {
+		if t.available(t.upstream(addr)) {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	if len(addrs) == 0 {
+		return order
+	}
+
+	return addrs
+}
+
+// order returns the upstreams in the order the balance dials them for the
+// client.
+func (t *TCP) order(ipAddress string) []string {
+	switch t.ProxyBalance {
+	case BalanceRoundRobin:
+		n := len(t.Upstreams)
+		start := int((atomic.AddUint32(&t.proxy.next, 1) - 1) % uint32(n))
+
+		addrs := make([]string, n)
+		for i := range addrs {
+			addrs[i] = t.Upstreams[(start+i)%n]
+		}
+		return addrs
+
+	case BalanceHash:
+		return t.ring().walk(t.hashKey(ipAddress))
+	}
+
+	return t.Upstreams
+}
+
+// pickUpstream returns the upstream to dial for the attempt.
+func (t *TCP) pickUpstream(ipAddress string, rotation []string, attempt int) string {
+	if t.PickUpstream != nil {
+		return t.PickUpstream(ipAddress, attempt)
+	}
+	return rotation[attempt%len(rotation)]
+}
+
+// proxyDial dials the address with the ProxyDial within the dial timeout.
+func (t *TCP) proxyDial(addr string) (net.Conn, error) {
+	dial := t.ProxyDial
+	if dial == nil {
+		var d net.Dialer
+		dial = d.DialContext
+	}
+
+	timeout := t.ProxyDialTimeout
+	if timeout <= 0 {
+		timeout = defProxyDialTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return dial(ctx, t.NetType, addr)
+}
+
+// dialUpstream dials an upstream for the client, moving to the next one
+// when a dial fails until the retries run out.
+func (t *TCP) dialUpstream(ipAddress string) (net.Conn, *upstreamStats, error) {
+	var rotation []string
+	if t.PickUpstream == nil {
+		rotation = t.rotation(t.order(ipAddress))
+	}
+
+	var err error
+	for attempt := 0; attempt <= t.ProxyRetries; attempt++ {
+		addr := t.pickUpstream(ipAddress, rotation, attempt)
+		us := t.upstream(addr)
+		atomic.AddInt64(&us.dials, 1)
+
+		var conn net.Conn
+		conn, err = t.proxyDial(addr)
+		if err == nil {
+			t.dialed(addr, us)
+			return conn, us, nil
+		}
+
+		atomic.AddInt64(&us.dialErrors, 1)
+		t.Event(EvtProxy, TypError, ipAddress, "dial : %s : %v", addr, err)
+		t.dialFailed(addr, us, err)
+	}
+
+	return nil, nil, err
+}
+
+// ProxyStats returns the statistics of the upstreams dialed, sorted by
+// address. The CONNECT tunnels are reported as UpstreamConnect.
+func (t *TCP) ProxyStats() []UpstreamStat {
+	var stats []UpstreamStat
+	t.proxy.mu.Lock()
+	{
+		for addr, us := range t.proxy.upstreams {
+			stats = append(stats, us.stat(addr))
+		}
+	}
+	t.proxy.mu.Unlock()
+
+	if t.Connect {
+		stats = append(stats, t.proxy.connect.stat(UpstreamConnect))
+	}
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Addr < stats[j].Addr })
+	return stats
+}
+
+// =============================================================================
+
+// forward pipes the bytes of the connection to an upstream until either
+// side closes.
+func (c *client) forward() {
+	upstream, us, err := c.t.dialUpstream(c.ipAddress)
+	if err != nil {
+		c.setCloseReason(CloseUpstreamError)
+		return
+	}
+
+	c.tunnel(upstream, us)
+}
+
+// tunnel pipes the bytes of the connection to the upstream until either
+// side closes. A client that stops sending half closes the upstream, so
+// the responses still arrive.
+func (c *client) tunnel(upstream net.Conn, us *upstreamStats) {
+	defer upstream.Close()
+
+	atomic.AddInt64(&us.active, 1)
+	defer atomic.AddInt64(&us.active, -1)
+
+	// Move the bytes in the kernel when nothing above the sockets
+	// needs to see them.
+	cc, uc, spliced := c.spliceConns(upstream)
+	mode := proxyCopy
+	if spliced {
+		mode = proxySplice
+	}
+	atomic.StoreInt32(&c.proxy, mode)
+
+	c.t.Event(EvtProxy, TypInfo, c.ipAddress, "forwarding : %s : Mode[ %s ]", upstream.RemoteAddr(), c.proxyMode())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if spliced {
+			spliceCopy(cc, uc, &c.counts.written, &us.bytesDown)
+		} else {
+			io.Copy(&countWriter{w: c.rw, n: &us.bytesDown}, upstream)
+		}
+
+		// Unblock the copy from the client.
+		c.conn.SetReadDeadline(time.Now())
+	}()
+
+	// closeWriter is declared to test for the existence of the method
+	// coming from the net package.
+	type closeWriter interface {
+		CloseWrite() error
+	}
+
+	var err error
+	if spliced {
+		err = spliceCopy(uc, cc, &c.counts.read, &us.bytesUp)
+	} else {
+		_, err = io.Copy(&countWriter{w: upstream, n: &us.bytesUp}, c.rw)
+	}
+	if cw, ok := upstream.(closeWriter); ok && err == nil {
+		cw.CloseWrite()
+	} else {
+		upstream.Close()
+	}
+
+	<-done
+	c.setCloseReason(CloseEOF)
+}
+
+// countWriter adds the bytes written to a counter.
+type countWriter struct {
+	w io.Writer
+	n *int64
+}
+
+// Write implements the io.Writer interface.
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	atomic.AddInt64(cw.n, int64(n))
+	return n, err
+}
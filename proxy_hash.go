@@ -0,0 +1,97 @@
+package tcp
+
+import (
+	"hash/fnv"
+	"net"
+	"sort"
+	"strconv"
+)
+
+// ringReplicas is the number of points of each upstream on the ring, so
+// the clients spread evenly and only the clients of an upstream move when
+// it's taken out.
+const ringReplicas = 128
+
+// hashRing places the upstreams on a ring of hashes for consistent
+// hashing.
+type hashRing struct {
+	points []uint64
+	addrs  []string // Upstream at each point.
+}
+
+// newHashRing places the points of each upstream on the ring.
+func newHashRing(upstreams []string) hashRing {
+	var r hashRing
+	for _, addr := range upstreams {
+		for i := 0; i < ringReplicas; i++ {
+			r.points = append(r.points, hash64(addr+"#"+strconv.Itoa(i)))
+			r.addrs = append(r.addrs, addr)
+		}
+	}
+
+	sort.Sort(&r)
+	return r
+}
+
+// Len implements the sort.Interface interface.
+func (r *hashRing) Len() int { return len(r.points) }
+
+// Less implements the sort.Interface interface.
+func (r *hashRing) Less(i, j int) bool { return r.points[i] < r.points[j] }
+
+// Swap implements the sort.Interface interface.
+func (r *hashRing) Swap(i, j int) {
+	r.points[i], r.points[j] = r.points[j], r.points[i]
+	r.addrs[i], r.addrs[j] = r.addrs[j], r.addrs[i]
+}
+
+// walk returns the upstreams in the order they're met walking the ring
+// from the point of the key.
+func (r *hashRing) walk(key string) []string {
+	n := len(r.points)
+	if n == 0 {
+		return nil
+	}
+
+	h := hash64(key)
+	start := sort.Search(n, func(i int) bool { return r.points[i] >= h })
+
+	seen := make(map[string]bool)
+	var addrs []string
+	for i := 0; i < n && len(addrs) < n/ringReplicas; i++ {
+		addr := r.addrs[(start+i)%n]
+		if !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+
+	return addrs
+}
+
+// hash64 returns the hash of the string.
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// ring returns the ring of the upstreams, placing them on first use.
+func (t *TCP) ring() *hashRing {
+	t.proxy.ringOnce.Do(func() {
+		t.proxy.ring = newHashRing(t.Upstreams)
+	})
+	return &t.proxy.ring
+}
+
+// hashKey returns the key of the client hashed on the ring.
+func (t *TCP) hashKey(ipAddress string) string {
+	if t.ProxyHashKey != nil {
+		return t.ProxyHashKey(ipAddress)
+	}
+
+	if host, _, err := net.SplitHostPort(ipAddress); err == nil {
+		return host
+	}
+	return ipAddress
+}
@@ -0,0 +1,120 @@
+package tcp
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defProxyDownTime is the time an upstream taken out of the rotation by
+// failed dials waits to be dialed again without active checks.
+const defProxyDownTime = 30 * time.Second
+
+// markDown takes the upstream out of the rotation.
+func (t *TCP) markDown(addr string, us *upstreamStats, reason error) {
+	atomic.StoreInt64(&us.downAt, t.now().UnixNano())
+	if atomic.CompareAndSwapInt32(&us.down, 0, 1) {
+		t.serverEvent(EvtProxy, TypInfo, addr, "upstream down : %v", reason)
+	}
+}
+
+// markUp puts the upstream back in the rotation.
+func (t *TCP) markUp(addr string, us *upstreamStats) {
+	atomic.StoreInt32(&us.fails, 0)
+	if atomic.CompareAndSwapInt32(&us.down, 1, 0) {
+		t.serverEvent(EvtProxy, TypInfo, addr, "upstream up")
+	}
+}
+
+// available reports whether the upstream can be dialed. Without active
+// checks, an upstream taken out of the rotation is dialed again once the
+// down time passes, and the dial decides whether it's back.
+func (t *TCP) available(us *upstreamStats) bool {
+	if atomic.LoadInt32(&us.down) == 0 {
+		return true
+	}
+	if t.ProxyHealthEvery > 0 {
+		return false
+	}
+
+	downTime := t.ProxyDownTime
+	if downTime <= 0 {
+		downTime = defProxyDownTime
+	}
+
+	return t.now().Sub(time.Unix(0, atomic.LoadInt64(&us.downAt))) >= downTime
+}
+
+// dialed records a successful dial of the upstream. The active checks
+// decide when the upstream is back when they're configured.
+func (t *TCP) dialed(addr string, us *upstreamStats) {
+	atomic.StoreInt32(&us.fails, 0)
+	if t.ProxyHealthEvery <= 0 {
+		t.markUp(addr, us)
+	}
+}
+
+// dialFailed records a failed dial of the upstream. The upstream is taken
+// out of the rotation once too many dials failed in a row.
+func (t *TCP) dialFailed(addr string, us *upstreamStats, err error) {
+	if t.ProxyMaxFails <= 0 {
+		return
+	}
+
+	if atomic.AddInt32(&us.fails, 1) >= int32(t.ProxyMaxFails) {
+		t.markDown(addr, us, err)
+	}
+}
+
+// checkUpstreams dials each upstream, and runs the probe over the
+// connection when configured, to move it in or out of the rotation.
+func (t *TCP) checkUpstreams() {
+	dial := t.ProxyDial
+	if dial == nil {
+		var d net.Dialer
+		dial = d.DialContext
+	}
+
+	timeout := t.ProxyDialTimeout
+	if timeout <= 0 {
+		timeout = defProxyDialTimeout
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(len(t.Upstreams))
+	for _, addr := range t.Upstreams {
+		go func(addr string) {
+			defer wg.Done()
+
+			us := t.upstream(addr)
+			if err := t.probeUpstream(dial, addr, timeout); err != nil {
+				t.markDown(addr, us, err)
+				return
+			}
+			t.markUp(addr, us)
+		}(addr)
+	}
+	wg.Wait()
+}
+
+// probeUpstream dials the upstream and runs the probe over the connection
+// within the timeout.
+func (t *TCP) probeUpstream(dial func(ctx context.Context, network, addr string) (net.Conn, error), addr string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	conn, err := dial(ctx, t.NetType, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if t.ProxyProbe == nil {
+		return nil
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	return t.ProxyProbe(conn)
+}
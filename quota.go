@@ -0,0 +1,135 @@
+package tcp
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Quota declares the limits of the plan of an identity. Fields left 0 are
+// unlimited.
+type Quota struct {
+	RequestsPerSec int64 // Requests read per second over all the connections.
+	BytesPerDay    int64 // Bytes of the requests read per day, reset at midnight UTC.
+	Connections    int64 // Connections open at once.
+}
+
+// QuotaError is returned for a request or connection over the quota of its
+// identity. It matches ErrRateLimited with errors.Is.
+type QuotaError struct {
+	Identity string
+	Quota    string // Name of the limit, such as "requests_per_sec".
+	Limit    int64
+}
+
+// Error implements the error interface for QuotaError.
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%v : quota exceeded : Identity[ %s ] Quota[ %s ] Limit[ %d ]", ErrRateLimited, e.Identity, e.Quota, e.Limit)
+}
+
+// Unwrap returns ErrRateLimited.
+func (e *QuotaError) Unwrap() error {
+	return ErrRateLimited
+}
+
+// admitQuota resolves the quota of the client once its identity is known
+// and counts the connection against it. A client over its quota of
+// connections is closed with CloseQuotaExceeded.
+func (c *client) admitQuota() bool {
+	c.quota = c.t.Quotas(c.identity)
+	if c.quota.Connections <= 0 {
+		return true
+	}
+
+	n, err := c.t.Store.Add(c.quotaKey("connections"), c.t.now(), 0, 1)
+	if err != nil {
+		c.t.Event(EvtAuth, TypError, c.ipAddress, "quota : %v", err)
+		return true
+	}
+	c.quotaConn = true
+
+	if n > c.quota.Connections {
+		c.overQuota(&QuotaError{Identity: c.identity, Quota: "connections", Limit: c.quota.Connections})
+		c.setCloseReason(CloseQuotaExceeded)
+		return false
+	}
+
+	return true
+}
+
+// releaseQuota gives the connection back to the quota of the client.
+func (c *client) releaseQuota() {
+	if !c.quotaConn {
+		return
+	}
+
+	if _, err := c.t.Store.Add(c.quotaKey("connections"), c.t.now(), 0, -1); err != nil {
+		c.t.Event(EvtAuth, TypError, c.ipAddress, "quota : %v", err)
+	}
+}
+
+// checkQuota counts the request against the quota of the client. A request
+// over the quota isn't processed, the connection stays open. The store
+// failing lets the request through.
+func (c *client) checkQuota(length int) bool {
+	now := c.t.now()
+
+	limits := []struct {
+		quota  string
+		limit  int64
+		window time.Duration
+		n      int64
+	}{
+		{"requests_per_sec", c.quota.RequestsPerSec, time.Second, 1},
+		{"bytes_per_day", c.quota.BytesPerDay, 24 * time.Hour, int64(length)},
+	}
+
+	for _, l := range limits {
+		if l.limit <= 0 {
+			continue
+		}
+
+		n, err := c.t.Store.Add(c.quotaKey(l.quota), now, l.window, l.n)
+		if err != nil {
+			c.t.Event(EvtAuth, TypError, c.ipAddress, "quota : %v", err)
+			continue
+		}
+
+		if n > l.limit {
+			c.overQuota(&QuotaError{Identity: c.identity, Quota: l.quota, Limit: l.limit})
+			return false
+		}
+	}
+
+	return true
+}
+
+// overQuota reports the client went over its quota and writes the response
+// of OnQuotaExceeded, if any, after the responses of the requests already
+// read.
+func (c *client) overQuota(err error) {
+	atomic.AddInt64(&c.t.metrics.quotaRejects, 1)
+	c.t.Event(EvtAuth, TypError, c.ipAddress, "%v", err)
+
+	if c.t.OnQuotaExceeded == nil {
+		return
+	}
+
+	tcpAddr := c.conn.RemoteAddr().(*net.TCPAddr)
+	resp := c.t.OnQuotaExceeded(tcpAddr, err)
+	if resp == nil {
+		return
+	}
+
+	c.jobs.Wait()
+	resp.TCPAddr = tcpAddr
+	if werr := c.write(resp); werr != nil {
+		c.t.Event(EvtWrite, TypError, c.ipAddress, "quota exceeded : %v", werr)
+	}
+}
+
+// quotaKey returns the key of the usage of the limit by the identity.
+func (c *client) quotaKey(quota string) string {
+	return "quota/" + c.identity + "/" + quota
+}
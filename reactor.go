@@ -0,0 +1,203 @@
+package tcp
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defPollerReadTimeout is the time a poller waits for the rest of a
+// message by default.
+const defPollerReadTimeout = 5 * time.Second
+
+// errNoPoller is returned when the platform has no poller.
+var errNoPoller = errors.New("poller : not supported on this platform")
+
+// Model is the way connections are served.
+type Model int
+
+// Set of models.
+const (
+	ModelGoroutine Model = iota // A goroutine per connection blocks reading.
+	ModelReactor                // A few poller goroutines serve the connections as they become readable.
+)
+
+// reactor holds the pollers waiting on the connections that are parked.
+type reactor struct {
+	pollers []*poller
+	next    uint32
+	wg      sync.WaitGroup
+}
+
+// startReactor starts the pollers of the configured model.
+func (t *TCP) startReactor() error {
+	if t.Model != ModelReactor && t.IdlePark <= 0 {
+		return nil
+	}
+
+	n := t.Pollers
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+
+	var r reactor
+	for i := 0; i < n; i++ {
+		p, err := newPoller()
+		if err != nil {
+			r.stop()
+			return err
+		}
+		r.pollers = append(r.pollers, p)
+
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			p.wait(func(c *client) {
+				if !atomic.CompareAndSwapInt32(&c.parked, 1, 0) {
+					return
+				}
+
+				// Connections parked while idle get their goroutine
+				// back for as long as they're busy.
+				if t.Model != ModelReactor {
+					go c.resume(false)
+					return
+				}
+				c.resume(true)
+			})
+		}()
+	}
+
+	t.reactor = &r
+	return nil
+}
+
+// stop closes the pollers and waits for them to return.
+func (r *reactor) stop() {
+	for _, p := range r.pollers {
+		p.close()
+	}
+	r.wg.Wait()
+}
+
+// pick returns the poller for a connection parked for the first time.
+func (r *reactor) pick() *poller {
+	i := atomic.AddUint32(&r.next, 1)
+	return r.pollers[int(i)%len(r.pollers)]
+}
+
+// =============================================================================
+
+// park hands the connection to a poller when it has nothing left to read,
+// so no goroutine is blocked reading it. It reports whether the connection
+// was parked.
+func (c *client) park() bool {
+	if c.t.reactor == nil || !c.parkable() {
+		return false
+	}
+
+	// In the goroutine model only the connections idle for a while are
+	// parked.
+	if c.t.Model != ModelReactor && !c.idle() {
+		return false
+	}
+
+	// A connection being closed must notice it on its next read.
+	if atomic.LoadInt32(&c.closing) == 1 || atomic.LoadInt32(&c.draining) == 1 {
+		return false
+	}
+
+	if c.reactive {
+		c.rw.SetReadDeadline(time.Time{})
+	}
+
+	if c.poller == nil {
+		c.poller = c.t.reactor.pick()
+	}
+
+	atomic.StoreInt32(&c.parked, 1)
+	if err := c.poller.arm(c); err != nil {
+		if atomic.CompareAndSwapInt32(&c.parked, 1, 0) {
+			c.t.Event(EvtRead, TypError, c.ipAddress, "park : %v", err)
+			return false
+		}
+	}
+
+	return true
+}
+
+// parkable reports whether a poller can tell the connection has something
+// to read. Layers holding bytes read from the socket, like TLS, read ahead
+// and virtual servers, keep the connection on its own goroutine, and so
+// does the tarpit to not hold up the pollers.
+func (c *client) parkable() bool {
+	if c.tlsConn != nil || c.ra != nil || c.tarpit != nil {
+		return false
+	}
+
+	if _, ok := c.rw.(*peekConn); ok {
+		return false
+	}
+
+	// buffered is declared to test for the existence of the method
+	// coming from the bufio package.
+	type buffered interface {
+		Buffered() int
+	}
+
+	b, ok := c.reader.(buffered)
+	return ok && b.Buffered() == 0
+}
+
+// idle waits for the next request for the idle park time. It reports
+// whether the connection stayed idle.
+func (c *client) idle() bool {
+
+	// peeker is declared to test for the existence of the method
+	// coming from the bufio package.
+	type peeker interface {
+		Peek(n int) ([]byte, error)
+	}
+
+	p, ok := c.reader.(peeker)
+	if !ok || atomic.LoadInt32(&c.closing) == 1 {
+		return false
+	}
+
+	// The deadline of a connection being closed is kept when earlier.
+	c.setReadDeadline(time.Now().Add(c.t.IdlePark))
+	_, err := p.Peek(1)
+
+	if atomic.LoadInt32(&c.closing) == 1 {
+		return false
+	}
+	c.setReadDeadline(time.Time{})
+
+	return err != nil && c.t.Classify(err) == ErrDeadline
+}
+
+// resume serves the connection once it's readable. The pollers serve the
+// connections they wait on themselves, bounding the time they wait for the
+// rest of a message.
+func (c *client) resume(reactive bool) {
+	c.reactive = reactive
+	if reactive {
+		timeout := c.t.PollerReadTimeout
+		if timeout <= 0 {
+			timeout = defPollerReadTimeout
+		}
+		c.rw.SetReadDeadline(time.Now().Add(timeout))
+	}
+
+	c.serve(true)
+}
+
+// wake serves the parked connection on its own goroutine so it notices
+// it's being closed.
+func (c *client) wake() {
+	if atomic.CompareAndSwapInt32(&c.parked, 1, 0) {
+		go c.resume(false)
+	}
+}
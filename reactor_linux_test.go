@@ -0,0 +1,184 @@
+package tcp_test
+
+import (
+	"bufio"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/tcp"
+)
+
+// TestReactor tests idle connections are parked on the pollers.
+func TestReactor(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to hold many idle connections without a goroutine each.")
+	{
+		const conns = 200
+
+		u, err := tcp.New("TEST", tcp.Config{
+			NetType:     "tcp4",
+			Addr:        "127.0.0.1:0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptModel: tcp.OptModel{
+				Model:   tcp.ModelReactor,
+				Pollers: 2,
+			},
+		})
+		if err != nil {
+			t.Fatalf("\tShould be able to create a new TCP listener : %v %s", err, failed)
+		}
+		if err := u.Start(); err != nil {
+			t.Fatalf("\tShould be able to start the TCP listener : %v %s", err, failed)
+		}
+
+		base := runtime.NumGoroutine()
+
+		clients := make([]net.Conn, conns)
+		readers := make([]*bufio.Reader, conns)
+		for i := range clients {
+			conn, err := net.Dial("tcp4", u.Addr().String())
+			if err != nil {
+				t.Fatalf("\tShould be able to dial the server : %v %s", err, failed)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			clients[i] = conn
+			readers[i] = bufio.NewReader(conn)
+		}
+
+		for i := 0; i < 100 && u.Clients() < conns; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if u.Clients() != conns {
+			t.Fatalf("\tShould accept the connections : %d %s", u.Clients(), failed)
+		}
+
+		// Give the connections time to park.
+		time.Sleep(100 * time.Millisecond)
+		if g := runtime.NumGoroutine(); g-base > conns/10 {
+			t.Fatalf("\tShould not hold a goroutine per idle connection : %d %d %s", base, g, failed)
+		}
+		t.Log("\tShould not hold a goroutine per idle connection.", success)
+
+		for round := 0; round < 2; round++ {
+			for i, conn := range clients {
+				if _, err := conn.Write([]byte("Hello\n")); err != nil {
+					t.Fatalf("\tShould be able to send a message : %v %s", err, failed)
+				}
+				line, err := readers[i].ReadString('\n')
+				if err != nil || line != "Hello\n" {
+					t.Fatalf("\tShould receive the response : %q %v %s", line, err, failed)
+				}
+			}
+		}
+		t.Log("\tShould serve the connections once they are readable.", success)
+
+		clients[0].Close()
+		for i := 0; i < 100 && u.Clients() != conns-1; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if u.Clients() != conns-1 {
+			t.Fatalf("\tShould remove a connection the client closed : %d %s", u.Clients(), failed)
+		}
+		t.Log("\tShould remove a connection the client closed.", success)
+
+		if err := u.Stop(); err != nil {
+			t.Fatalf("\tShould be able to stop the TCP listener : %v %s", err, failed)
+		}
+		if u.Clients() != 0 {
+			t.Fatalf("\tShould close the parked connections : %d %s", u.Clients(), failed)
+		}
+		if _, err := readers[1].ReadString('\n'); err == nil {
+			t.Fatalf("\tShould close the parked connections %s", failed)
+		}
+		t.Log("\tShould close the parked connections.", success)
+	}
+}
+
+// TestIdlePark tests idle connections are parked in the goroutine model.
+func TestIdlePark(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to release the goroutines of idle connections.")
+	{
+		const conns = 200
+
+		u, err := tcp.New("TEST", tcp.Config{
+			NetType:     "tcp4",
+			Addr:        "127.0.0.1:0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptModel: tcp.OptModel{
+				Pollers:  1,
+				IdlePark: 50 * time.Millisecond,
+			},
+		})
+		if err != nil {
+			t.Fatalf("\tShould be able to create a new TCP listener : %v %s", err, failed)
+		}
+		if err := u.Start(); err != nil {
+			t.Fatalf("\tShould be able to start the TCP listener : %v %s", err, failed)
+		}
+		defer u.Stop()
+
+		base := runtime.NumGoroutine()
+
+		clients := make([]net.Conn, conns)
+		readers := make([]*bufio.Reader, conns)
+		for i := range clients {
+			conn, err := net.Dial("tcp4", u.Addr().String())
+			if err != nil {
+				t.Fatalf("\tShould be able to dial the server : %v %s", err, failed)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			clients[i] = conn
+			readers[i] = bufio.NewReader(conn)
+		}
+
+		// parked reports whether the goroutines of the connections
+		// were released.
+		parked := func() bool {
+			for i := 0; i < 100; i++ {
+				if g := runtime.NumGoroutine(); u.Clients() == conns && g-base <= conns/10 {
+					return true
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			return false
+		}
+
+		if !parked() {
+			t.Fatalf("\tShould park the idle connections : %d %d %s", base, runtime.NumGoroutine(), failed)
+		}
+		t.Log("\tShould park the idle connections.", success)
+
+		for i, conn := range clients {
+			if _, err := conn.Write([]byte("Hello\n")); err != nil {
+				t.Fatalf("\tShould be able to send a message : %v %s", err, failed)
+			}
+			line, err := readers[i].ReadString('\n')
+			if err != nil || line != "Hello\n" {
+				t.Fatalf("\tShould receive the response : %q %v %s", line, err, failed)
+			}
+		}
+		t.Log("\tShould serve the parked connections once they are readable.", success)
+
+		if !parked() {
+			t.Fatalf("\tShould park the connections idle again : %d %d %s", base, runtime.NumGoroutine(), failed)
+		}
+		t.Log("\tShould park the connections idle again.", success)
+	}
+}
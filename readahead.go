@@ -0,0 +1,139 @@
+package tcp
+
+import (
+	"io"
+	"net"
+	"sync"
+)
+
+// maxReadAheadChunk is the most read from the socket at once.
+const maxReadAheadChunk = 32 * 1024
+
+// readAheadConn reads from the connection on its own goroutine, keeping up
+// to size bytes ready for the next call to Read. Parsing the next message
+// and receiving the one after it overlap this way.
+type readAheadConn struct {
+	net.Conn
+	size int
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    []byte
+	err    error
+	final  bool
+	closed bool
+}
+
+// newReadAheadConn starts reading ahead on the connection.
+func newReadAheadConn(conn net.Conn, size int) *readAheadConn {
+	rc := readAheadConn{
+		Conn: conn,
+		size: size,
+	}
+	rc.cond = sync.NewCond(&rc.mu)
+
+	go rc.fill()
+
+	return &rc
+}
+
+// fill reads from the connection while there is room in the buffer. A
+// read error is held until it's delivered by Read, and reading resumes
+// after a timeout since the deadline may be extended.
+func (rc *readAheadConn) fill() {
+	chunk := rc.size
+	if chunk > maxReadAheadChunk {
+		chunk = maxReadAheadChunk
+	}
+	tmp := make([]byte, chunk)
+
+	for {
+		rc.mu.Lock()
+		{
+			for !rc.closed && (len(rc.buf) >= rc.size || rc.err != nil) {
+				rc.cond.Wait()
+			}
+
+			if rc.closed {
+				rc.mu.Unlock()
+				return
+			}
+		}
+		rc.mu.Unlock()
+
+		n, err := rc.Conn.Read(tmp)
+
+		rc.mu.Lock()
+		{
+			rc.buf = append(rc.buf, tmp[:n]...)
+			rc.err = err
+			rc.cond.Broadcast()
+
+			if e, ok := err.(net.Error); err != nil && (!ok || !e.Timeout()) {
+				rc.final = true
+				rc.mu.Unlock()
+				return
+			}
+		}
+		rc.mu.Unlock()
+	}
+}
+
+// Read implements the io.Reader interface.
+func (rc *readAheadConn) Read(p []byte) (int, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	for len(rc.buf) == 0 && rc.err == nil && !rc.closed {
+		rc.cond.Wait()
+	}
+
+	if len(rc.buf) > 0 {
+		n := copy(p, rc.buf)
+		rc.buf = rc.buf[n:]
+		if len(rc.buf) == 0 {
+			rc.buf = nil
+		}
+		rc.cond.Broadcast()
+		return n, nil
+	}
+
+	if rc.closed {
+		return 0, net.ErrClosed
+	}
+
+	// Errors ending the connection are returned to every call.
+	err := rc.err
+	if !rc.final {
+		rc.err = nil
+		rc.cond.Broadcast()
+	}
+
+	return 0, err
+}
+
+// Close stops reading ahead and closes the connection.
+func (rc *readAheadConn) Close() error {
+	rc.mu.Lock()
+	{
+		rc.closed = true
+		rc.cond.Broadcast()
+	}
+	rc.mu.Unlock()
+
+	return rc.Conn.Close()
+}
+
+// CloseWrite closes the write side of the connection when it supports it.
+func (rc *readAheadConn) CloseWrite() error {
+	if cw, ok := rc.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// ReadFrom copies from the reader to the connection, keeping the fast
+// paths the connection provides for files and sockets.
+func (rc *readAheadConn) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(rc.Conn, r)
+}
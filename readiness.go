@@ -0,0 +1,68 @@
+package tcp
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Default values for waiting on the dependencies.
+const (
+	defReadinessTimeout = 30 * time.Second
+	minReadinessRetry   = 100 * time.Millisecond
+	maxReadinessRetry   = 2 * time.Second
+)
+
+// waitDependencies checks the dependencies until all of them are ready or
+// the readiness timeout is reached. Dependencies that are ready are not
+// checked again.
+func (t *TCP) waitDependencies() error {
+	if len(t.Dependencies) == 0 {
+		return nil
+	}
+
+	timeout := t.ReadinessTimeout
+	if timeout <= 0 {
+		timeout = defReadinessTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	pending := t.Dependencies
+	retry := minReadinessRetry
+
+	for {
+		var failed []Dependency
+		var lastErr error
+
+		for _, dep := range pending {
+			if err := dep.Check(ctx); err != nil {
+				t.Event(EvtDependency, TypError, "", "%s : %v", dep.Name, err)
+				failed = append(failed, dep)
+				lastErr = err
+				continue
+			}
+
+			t.Event(EvtDependency, TypInfo, "", "%s : ready", dep.Name)
+		}
+
+		if len(failed) == 0 {
+			return nil
+		}
+
+		pending = failed
+
+		timer := t.clock().NewTimer(retry)
+		select {
+		case <-timer.C():
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("dependency %s is not ready : %v", pending[0].Name, lastErr)
+		}
+
+		if retry *= 2; retry > maxReadinessRetry {
+			retry = maxReadinessRetry
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package tcp
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// defGoAwayGrace is the time a client has to close the connection after
+// being asked to reconnect.
+const defGoAwayGrace = 30 * time.Second
+
+// rebalance asks the longest-lived connections to reconnect so load
+// naturally spreads across a horizontally scaled fleet.
+func (t *TCP) rebalance() {
+	var clts []*client
+	for _, c := range t.clientList() {
+		if atomic.LoadInt32(&c.closing) == 0 {
+			clts = append(clts, c)
+		}
+	}
+
+	// Oldest connections first.
+	sort.Slice(clts, func(i, j int) bool {
+		return clts[i].timeConn.Before(clts[j].timeConn)
+	})
+
+	n := t.RebalanceConns
+	if n <= 0 {
+		n = 1
+	}
+	if n > len(clts) {
+		n = len(clts)
+	}
+
+	grace := t.RebalanceGrace
+	if grace <= 0 {
+		grace = defGoAwayGrace
+	}
+
+	for _, c := range clts[:n] {
+		t.Event(EvtRebalance, TypInfo, c.ipAddress, "go away : Conn[ %v ]", c.timeConn.Format(time.RFC3339))
+		if err := c.goAway(t.GoAwayMsg, grace); err != nil {
+			t.Event(EvtRebalance, TypError, c.ipAddress, "go away : %v", err)
+		}
+	}
+}
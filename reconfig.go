@@ -0,0 +1,140 @@
+package tcp
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrInvalidPatch is returned by UpdateConfig for a patch that can't be
+// applied, such as a negative timeout.
+var ErrInvalidPatch = errors.New("invalid configuration patch")
+
+// ConfigPatch holds the settings UpdateConfig changes on a running TCP
+// value. Nil fields keep their current setting.
+type ConfigPatch struct {
+	RateLimit        *time.Duration // Zero disables the rate limit.
+	HandshakeTimeout *time.Duration
+	WriteTimeout     *time.Duration
+	MaxConns         *int
+	Allow            *[]string
+	Deny             *[]string
+}
+
+// UpdateConfig applies the patch to the running TCP value without a Stop
+// and Start. The patched configuration is validated before it replaces
+// the current one, so an invalid patch changes nothing. Connections
+// already accepted are not checked against new limits or access lists.
+func (t *TCP) UpdateConfig(patch ConfigPatch) error {
+	for _, d := range []*time.Duration{patch.RateLimit, patch.HandshakeTimeout, patch.WriteTimeout} {
+		if d != nil && *d < 0 {
+			return ErrInvalidPatch
+		}
+	}
+
+	var cfg Config
+	t.configMu.RLock()
+	{
+		cfg = t.Config
+	}
+	t.configMu.RUnlock()
+
+	var changed []string
+	if patch.RateLimit != nil {
+		d := *patch.RateLimit
+		cfg.RateLimit = nil
+		if d > 0 {
+			cfg.RateLimit = func() time.Duration { return d }
+		}
+		changed = append(changed, "RateLimit")
+	}
+	if patch.HandshakeTimeout != nil {
+		cfg.HandshakeTimeout = *patch.HandshakeTimeout
+		changed = append(changed, "HandshakeTimeout")
+	}
+	if patch.WriteTimeout != nil {
+		cfg.WriteTimeout = *patch.WriteTimeout
+		changed = append(changed, "WriteTimeout")
+	}
+	if patch.MaxConns != nil {
+		cfg.MaxConns = *patch.MaxConns
+		changed = append(changed, "MaxConns")
+	}
+	if patch.Allow != nil {
+		cfg.Allow = append([]string(nil), *patch.Allow...)
+		changed = append(changed, "Allow")
+	}
+	if patch.Deny != nil {
+		cfg.Deny = append([]string(nil), *patch.Deny...)
+		changed = append(changed, "Deny")
+	}
+
+	if len(changed) == 0 {
+		return nil
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	access, err := parseAccessList(cfg.Allow, cfg.Deny)
+	if err != nil {
+		return err
+	}
+
+	t.configMu.Lock()
+	{
+		t.RateLimit = cfg.RateLimit
+		t.HandshakeTimeout = cfg.HandshakeTimeout
+		t.WriteTimeout = cfg.WriteTimeout
+		t.MaxConns = cfg.MaxConns
+		t.Allow = cfg.Allow
+		t.Deny = cfg.Deny
+		t.access = access
+	}
+	t.configMu.Unlock()
+
+	t.Event(EvtConfig, TypInfo, "", "applied : %s", strings.Join(changed, ", "))
+
+	return nil
+}
+
+// rateLimit returns the rate limit function in effect.
+func (t *TCP) rateLimit() func() time.Duration {
+	var f func() time.Duration
+	t.configMu.RLock()
+	{
+		f = t.RateLimit
+	}
+	t.configMu.RUnlock()
+
+	return f
+}
+
+// handshakeTimeout returns the time allowed for a TLS handshake.
+func (t *TCP) handshakeTimeout() time.Duration {
+	var d time.Duration
+	t.configMu.RLock()
+	{
+		d = t.HandshakeTimeout
+	}
+	t.configMu.RUnlock()
+
+	if d <= 0 {
+		d = defHandshakeTimeout
+	}
+
+	return d
+}
+
+// writeTimeout returns the time allowed for each response.
+func (t *TCP) writeTimeout() time.Duration {
+	var d time.Duration
+	t.configMu.RLock()
+	{
+		d = t.WriteTimeout
+	}
+	t.configMu.RUnlock()
+
+	return d
+}
@@ -0,0 +1,274 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// Default values for the flight recorder.
+const (
+	defRecordFiles = 10
+	defRecordEvery = 10 * time.Second
+)
+
+// snapshotMagic identifies the binary snapshot format and its version.
+var snapshotMagic = []byte{'T', 'C', 'P', 'S', 2}
+
+// Snapshot is a point in time view of the internal state of a TCP value.
+type Snapshot struct {
+	Name     string
+	Time     time.Time
+	Dropping bool
+	Clients  []Stat
+}
+
+// Snapshot captures the current stats and connection states. The TLS state
+// of a client keeps the version, cipher suite, negotiated protocol and
+// server name once encoded, not the certificates.
+func (t *TCP) Snapshot() Snapshot {
+	return Snapshot{
+		Name:     t.Name,
+		Time:     t.now(),
+		Dropping: atomic.LoadInt32(&t.dropConns) == 1,
+		Clients:  t.ClientStats(),
+	}
+}
+
+// startRecorder periodically writes snapshots to a ring of files until
+// the TCP value is stopped.
+func (t *TCP) startRecorder() {
+	every := t.RecordEvery
+	if every <= 0 {
+		every = defRecordEvery
+	}
+
+	files := t.RecordFiles
+	if files <= 0 {
+		files = defRecordFiles
+	}
+
+	var i int
+	t.runEvery(every, func() {
+		if err := t.record(i); err != nil {
+			t.Event(EvtRecord, TypError, "", "snapshot : %v", err)
+		}
+		i = (i + 1) % files
+	})
+}
+
+// record writes a snapshot to the file at the specified position in the
+// ring. The snapshot is written to a temporary file first so a crash
+// during the write never leaves a partial snapshot behind.
+func (t *TCP) record(i int) error {
+	name := filepath.Join(t.RecordDir, fmt.Sprintf("%s-%d.snap", t.Name, i))
+	tmp := name + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	encode := t.RecordEncoder
+	if encode == nil {
+		encode = EncodeSnapshot
+	}
+
+	// Snapshots outlive the process so they must not reveal clients
+	// when anonymization is configured.
+	s := t.Snapshot()
+	for i := range s.Clients {
+		s.Clients[i].IP = t.anonymize(s.Clients[i].IP)
+	}
+
+	w := bufio.NewWriter(f)
+	if err := encode(w, s); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, name)
+}
+
+// EncodeSnapshot writes the snapshot in a compact binary format.
+func EncodeSnapshot(w io.Writer, s Snapshot) error {
+	var b bytes.Buffer
+	b.Write(snapshotMagic)
+
+	putString(&b, s.Name)
+	putInt(&b, s.Time.UnixNano())
+
+	var dropping byte
+	if s.Dropping {
+		dropping = 1
+	}
+	b.WriteByte(dropping)
+
+	putInt(&b, int64(len(s.Clients)))
+	for _, c := range s.Clients {
+		putString(&b, c.IP)
+		putInt(&b, int64(len(c.Tags)))
+		for _, tag := range c.Tags {
+			putString(&b, tag)
+		}
+		putInt(&b, int64(c.Reads))
+		putInt(&b, int64(c.Writes))
+		putInt(&b, c.BytesRead)
+		putInt(&b, c.BytesWritten)
+		putInt(&b, c.TimeConn.UnixNano())
+		putInt(&b, c.LastAct.UnixNano())
+		putString(&b, c.Proxy)
+		putInt(&b, int64(c.Priority))
+		putInt(&b, c.Buffered)
+		putString(&b, c.Identity)
+		putInt(&b, c.Requests)
+		putInt(&b, int64(c.Congestion.Queued))
+		putInt(&b, int64(c.Congestion.QueuedBytes))
+		putInt(&b, int64(c.Congestion.Stalls))
+		putInt(&b, int64(c.Congestion.LastStall))
+
+		if c.TLS == nil {
+			b.WriteByte(0)
+			continue
+		}
+		b.WriteByte(1)
+		putInt(&b, int64(c.TLS.Version))
+		putInt(&b, int64(c.TLS.CipherSuite))
+		putString(&b, c.TLS.NegotiatedProtocol)
+		putString(&b, c.TLS.ServerName)
+	}
+
+	_, err := b.WriteTo(w)
+	return err
+}
+
+// DecodeSnapshot reads a snapshot written by EncodeSnapshot.
+func DecodeSnapshot(r io.Reader) (Snapshot, error) {
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return Snapshot{}, err
+	}
+
+	if !bytes.Equal(magic, snapshotMagic) {
+		return Snapshot{}, errors.New("invalid snapshot format")
+	}
+
+	var s Snapshot
+	var err error
+
+	// getInt, getString and getByte stop reading once an error occurs.
+	getInt := func() int64 {
+		if err != nil {
+			return 0
+		}
+		var v int64
+		v, err = binary.ReadVarint(br)
+		return v
+	}
+
+	getString := func() string {
+		l := getInt()
+		if err != nil {
+			return ""
+		}
+		if l < 0 || l > 1<<16 {
+			err = errors.New("invalid snapshot string length")
+			return ""
+		}
+		b := make([]byte, l)
+		_, err = io.ReadFull(br, b)
+		return string(b)
+	}
+
+	getByte := func() byte {
+		if err != nil {
+			return 0
+		}
+		var v byte
+		v, err = br.ReadByte()
+		return v
+	}
+
+	s.Name = getString()
+	s.Time = time.Unix(0, getInt()).UTC()
+
+	s.Dropping = getByte() == 1
+
+	n := getInt()
+	for i := int64(0); i < n && err == nil; i++ {
+		var c Stat
+		c.IP = getString()
+
+		tags := getInt()
+		for j := int64(0); j < tags && err == nil; j++ {
+			c.Tags = append(c.Tags, getString())
+		}
+
+		c.Reads = int(getInt())
+		c.Writes = int(getInt())
+		c.BytesRead = getInt()
+		c.BytesWritten = getInt()
+		c.TimeConn = time.Unix(0, getInt()).UTC()
+		c.LastAct = time.Unix(0, getInt()).UTC()
+		c.Proxy = getString()
+		c.Priority = Priority(getInt())
+		c.Buffered = getInt()
+		c.Identity = getString()
+		c.Requests = getInt()
+		c.Congestion = Congestion{
+			Queued:      int(getInt()),
+			QueuedBytes: int(getInt()),
+			Stalls:      int(getInt()),
+			LastStall:   time.Duration(getInt()),
+		}
+
+		if getByte() == 1 {
+			c.TLS = &tls.ConnectionState{
+				Version:            uint16(getInt()),
+				CipherSuite:        uint16(getInt()),
+				NegotiatedProtocol: getString(),
+				ServerName:         getString(),
+			}
+		}
+
+		s.Clients = append(s.Clients, c)
+	}
+
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	return s, nil
+}
+
+// putInt writes the value as a varint.
+func putInt(b *bytes.Buffer, v int64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], v)
+	b.Write(buf[:n])
+}
+
+// putString writes the length of the string followed by its bytes.
+func putString(b *bytes.Buffer, s string) {
+	putInt(b, int64(len(s)))
+	b.WriteString(s)
+}
@@ -0,0 +1,87 @@
+package tcp_test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/tcp"
+)
+
+// TestSnapshotEncoding tests a snapshot survives the binary encoding.
+func TestSnapshotEncoding(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to record snapshots for post-mortem analysis.")
+	{
+		now := time.Now().UTC()
+
+		s := tcp.Snapshot{
+			Name:     "TEST",
+			Time:     now,
+			Dropping: true,
+			Clients: []tcp.Stat{
+				{
+					IP:           "127.0.0.1:5000",
+					Tags:         []string{"tenant-a", "beta"},
+					Reads:        10,
+					Writes:       5,
+					BytesRead:    1024,
+					BytesWritten: 512,
+					TimeConn:     now.Add(-time.Minute),
+					LastAct:      now,
+					Proxy:        tcp.ProxyCopy,
+					Priority:     tcp.Priority(2),
+					Buffered:     64,
+					Identity:     "client-1",
+					Requests:     10,
+					Congestion:   tcp.Congestion{Queued: 2, QueuedBytes: 128, Stalls: 1, LastStall: time.Second},
+					TLS:          &tls.ConnectionState{Version: tls.VersionTLS13, CipherSuite: tls.TLS_AES_128_GCM_SHA256, NegotiatedProtocol: "h2", ServerName: "example.com"},
+				},
+				{IP: "[::1]:5001", Reads: 1, Writes: 0, TimeConn: now, LastAct: now},
+			},
+		}
+
+		var b bytes.Buffer
+		if err := tcp.EncodeSnapshot(&b, s); err != nil {
+			t.Fatal("\tShould be able to encode the snapshot.", failed, err)
+		}
+		t.Log("\tShould be able to encode the snapshot.", success)
+
+		got, err := tcp.DecodeSnapshot(&b)
+		if err != nil {
+			t.Fatal("\tShould be able to decode the snapshot.", failed, err)
+		}
+		t.Log("\tShould be able to decode the snapshot.", success)
+
+		if got.Name != s.Name || !got.Time.Equal(s.Time) || got.Dropping != s.Dropping || len(got.Clients) != len(s.Clients) {
+			t.Fatalf("\tShould get back the same snapshot. %s\n%+v", failed, got)
+		}
+
+		for i := range s.Clients {
+			w, g := s.Clients[i], got.Clients[i]
+			if g.IP != w.IP || g.Reads != w.Reads || g.Writes != w.Writes || !g.TimeConn.Equal(w.TimeConn) || !g.LastAct.Equal(w.LastAct) {
+				t.Fatalf("\tShould get back the same client stats. %s\n%+v", failed, g)
+			}
+			if strings.Join(g.Tags, ",") != strings.Join(w.Tags, ",") || g.BytesRead != w.BytesRead || g.BytesWritten != w.BytesWritten || g.Proxy != w.Proxy || g.Priority != w.Priority {
+				t.Fatalf("\tShould get back the same client stats. %s\n%+v", failed, g)
+			}
+			if g.Buffered != w.Buffered || g.Identity != w.Identity || g.Requests != w.Requests || g.Congestion != w.Congestion {
+				t.Fatalf("\tShould get back the same client stats. %s\n%+v", failed, g)
+			}
+			if (g.TLS == nil) != (w.TLS == nil) || (w.TLS != nil && (g.TLS.Version != w.TLS.Version || g.TLS.CipherSuite != w.TLS.CipherSuite || g.TLS.NegotiatedProtocol != w.TLS.NegotiatedProtocol || g.TLS.ServerName != w.TLS.ServerName)) {
+				t.Fatalf("\tShould get back the same TLS state. %s\n%+v", failed, g.TLS)
+			}
+		}
+		t.Log("\tShould get back the same snapshot.", success)
+
+		if _, err := tcp.DecodeSnapshot(bytes.NewReader([]byte("garbage"))); err == nil {
+			t.Error("\tShould reject data that is not a snapshot.", failed)
+		} else {
+			t.Log("\tShould reject data that is not a snapshot.", success)
+		}
+	}
+}
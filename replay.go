@@ -0,0 +1,227 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defReplayWait is the time allowed for the responses by default.
+const defReplayWait = time.Second
+
+// TraceSource provides the records of a trace in the order they were
+// recorded, such as a TraceReader.
+type TraceSource interface {
+	Next() (TraceRecord, error)
+}
+
+// ReplayConfig declares how a trace is replayed.
+type ReplayConfig struct {
+	Dial  func(ctx context.Context) (net.Conn, error) // Connects a client to the server under test.
+	Speed float64                                     // 1 keeps the original timing, 2 is twice as fast, 0 as fast as possible.
+	Wait  time.Duration                               // Time allowed for the responses once the trace ends, defaults to 1 second.
+}
+
+// ReplayResult reports the traffic of a replay. The responses of each
+// connection are compared to the bytes recorded for it.
+type ReplayResult struct {
+	Conns         int
+	BytesSent     int64
+	BytesExpected int64
+	BytesReceived int64
+	Mismatches    []string // Recorded addresses whose responses differ.
+}
+
+// Replay sends the bytes clients sent in the trace to a server, opening a
+// connection for each client recorded. The connections stay open until
+// the trace ends and the responses arrive or the Wait passes, so the
+// responses can be compared to the recorded ones.
+func Replay(ctx context.Context, src TraceSource, cfg ReplayConfig) (ReplayResult, error) {
+	if cfg.Dial == nil {
+		return ReplayResult{}, errors.New("replay : Dial is required")
+	}
+
+	wait := cfg.Wait
+	if wait <= 0 {
+		wait = defReplayWait
+	}
+
+	var res ReplayResult
+	conns := make(map[string]*replayConn)
+	defer func() {
+		for _, rc := range conns {
+			rc.conn.Close()
+		}
+	}()
+
+	var start time.Time
+	var first time.Time
+
+	for {
+		rec, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return res, err
+		}
+
+		// Keep the time between the records, scaled by the speed.
+		if cfg.Speed > 0 {
+			if first.IsZero() {
+				first, start = rec.Time, time.Now()
+			}
+			at := start.Add(time.Duration(float64(rec.Time.Sub(first)) / cfg.Speed))
+			if err := sleepUntil(ctx, at); err != nil {
+				return res, err
+			}
+		}
+
+		rc, ok := conns[rec.IP]
+		if !ok {
+			conn, err := cfg.Dial(ctx)
+			if err != nil {
+				return res, err
+			}
+
+			rc = newReplayConn(conn)
+			conns[rec.IP] = rc
+			res.Conns++
+		}
+
+		switch rec.Dir {
+		case TapIn:
+			if _, err := rc.conn.Write(rec.Data); err != nil {
+				return res, err
+			}
+			res.BytesSent += int64(len(rec.Data))
+
+		case TapOut:
+			rc.expected.Write(rec.Data)
+			res.BytesExpected += int64(len(rec.Data))
+		}
+	}
+
+	deadline := time.Now().Add(wait)
+	ips := make([]string, 0, len(conns))
+	for ip, rc := range conns {
+		received := rc.wait(ctx, deadline)
+		res.BytesReceived += int64(len(received))
+
+		if !bytes.Equal(received, rc.expected.Bytes()) {
+			ips = append(ips, ip)
+		}
+	}
+
+	sort.Strings(ips)
+	res.Mismatches = ips
+
+	return res, ctx.Err()
+}
+
+// sleepUntil waits for the time or for the context to be done.
+func sleepUntil(ctx context.Context, at time.Time) error {
+	d := time.Until(at)
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// =============================================================================
+
+// replayConn is a client connection of a replay. The responses are read
+// in the background so the server is never blocked writing them.
+type replayConn struct {
+	conn     net.Conn
+	expected bytes.Buffer
+
+	mu       sync.Mutex
+	received bytes.Buffer
+	grew     chan struct{}
+	done     chan struct{}
+}
+
+// newReplayConn starts reading the responses of the connection.
+func newReplayConn(conn net.Conn) *replayConn {
+	rc := replayConn{
+		conn: conn,
+		grew: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(rc.done)
+
+		buf := make([]byte, 4096)
+		for {
+			n, err := conn.Read(buf)
+			if n > 0 {
+				rc.mu.Lock()
+				{
+					rc.received.Write(buf[:n])
+				}
+				rc.mu.Unlock()
+
+				select {
+				case rc.grew <- struct{}{}:
+				default:
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return &rc
+}
+
+// wait returns the responses once as many bytes as recorded arrived, the
+// server closed the connection or the deadline passed.
+func (rc *replayConn) wait(ctx context.Context, deadline time.Time) []byte {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	for {
+		var received []byte
+		rc.mu.Lock()
+		{
+			received = append([]byte(nil), rc.received.Bytes()...)
+		}
+		rc.mu.Unlock()
+
+		if len(received) >= rc.expected.Len() {
+			return received
+		}
+
+		select {
+		case <-rc.grew:
+		case <-rc.done:
+			rc.mu.Lock()
+			{
+				received = append([]byte(nil), rc.received.Bytes()...)
+			}
+			rc.mu.Unlock()
+			return received
+		case <-timer.C:
+			return received
+		case <-ctx.Done():
+			return received
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+
+	"github.com/ardanlabs/tcp"
+)
+
+// Default limits of the values read.
+const (
+	defMaxBulkSize = 8 << 20
+	defMaxElems    = 64 << 10
+	defMaxDepth    = 32
+	defMaxInline   = 64 << 10
+)
+
+// Most bytes and elements allocated ahead of the ones received, so a
+// length alone can't make the reader allocate up to the limits.
+const (
+	chunkSize   = 64 << 10
+	maxPrealloc = 1024
+)
+
+// ErrNoBufio is returned when the connection is not bound to a
+// bufio.Reader.
+var ErrNoBufio = errors.New("resp : reader must be a *bufio.Reader")
+
+// ProtocolError is returned when the data breaks the protocol. The
+// connection is closed since it can't be read any further.
+type ProtocolError struct {
+	Reason string
+}
+
+// Error implements the error interface for ProtocolError.
+func (pe *ProtocolError) Error() string {
+	return "resp : " + pe.Reason
+}
+
+// Temporary reports the connection can't be read after a protocol error.
+func (pe *ProtocolError) Temporary() bool {
+	return false
+}
+
+// Codec reads and writes values. Requests not starting with a type byte
+// are read as inline commands, split on spaces, like Redis does for
+// clients such as telnet. It implements tcp.Codec.
+type Codec struct {
+	MaxBulkSize int // Largest bulk string, defaults to 8MB.
+	MaxElems    int // Most elements of an aggregate, defaults to 64k.
+	MaxDepth    int // Most nested aggregates, defaults to 32.
+}
+
+// Read implements the tcp.Codec interface. It returns the bytes of the
+// next value.
+func (c Codec) Read(reader io.Reader) ([]byte, error) {
+	br, ok := reader.(*bufio.Reader)
+	if !ok {
+		return nil, ErrNoBufio
+	}
+
+	src := readSource{br: br, raw: true}
+	if _, err := c.parser(&src).value(0); err != nil {
+		return nil, err
+	}
+
+	return src.buf, nil
+}
+
+// Decode implements the tcp.Codec interface.
+func (c Codec) Decode(data []byte) (Value, error) {
+	src := bytesSource{data: data}
+	return c.parser(&src).value(0)
+}
+
+// Encode implements the tcp.Codec interface.
+func (Codec) Encode(v Value) ([]byte, error) {
+	return v.Append(nil), nil
+}
+
+// ReadValue reads the next value off the reader.
+func (c Codec) ReadValue(br *bufio.Reader) (Value, error) {
+	return c.parser(&readSource{br: br}).value(0)
+}
+
+// parser returns a parser of the source with the limits applied.
+func (c Codec) parser(src source) *parser {
+	p := parser{
+		src:      src,
+		maxBulk:  c.MaxBulkSize,
+		maxElems: c.MaxElems,
+		maxDepth: c.MaxDepth,
+	}
+	if p.maxBulk <= 0 {
+		p.maxBulk = defMaxBulkSize
+	}
+	if p.maxElems <= 0 {
+		p.maxElems = defMaxElems
+	}
+	if p.maxDepth <= 0 {
+		p.maxDepth = defMaxDepth
+	}
+
+	return &p
+}
+
+// =============================================================================
+
+// source provides the lines and the payloads of the values.
+type source interface {
+	line() ([]byte, error)      // Next line without its CR LF.
+	full(n int) ([]byte, error) // Next n bytes followed by a CR LF.
+}
+
+// readSource reads from a bufio.Reader, keeping the raw bytes read when
+// asked to.
+type readSource struct {
+	br  *bufio.Reader
+	raw bool
+	buf []byte
+}
+
+// line implements the source interface.
+func (rs *readSource) line() ([]byte, error) {
+	var line []byte
+	for {
+		frag, err := rs.br.ReadSlice('\n')
+		if err == nil {
+			line = append(line, frag...)
+			break
+		}
+		if err != bufio.ErrBufferFull {
+			if err == io.EOF && (len(line) > 0 || len(frag) > 0) {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+
+		line = append(line, frag...)
+		if len(line) > defMaxInline {
+			return nil, fmt.Errorf("resp : line over %d bytes : %w", defMaxInline, tcp.ErrFrameTooLarge)
+		}
+	}
+
+	if rs.raw {
+		rs.buf = append(rs.buf, line...)
+	}
+
+	return trimCRLF(line)
+}
+
+// full implements the source interface.
+func (rs *readSource) full(n int) ([]byte, error) {
+	data := make([]byte, 0, min(n+2, chunkSize))
+	for len(data) < n+2 {
+		start := len(data)
+		data = slices.Grow(data, min(n+2-start, chunkSize))
+		data = data[:min(n+2, cap(data))]
+
+		if _, err := io.ReadFull(rs.br, data[start:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+
+	if rs.raw {
+		rs.buf = append(rs.buf, data...)
+	}
+
+	return trimPayload(data, n)
+}
+
+// bytesSource reads from the bytes of a value.
+type bytesSource struct {
+	data []byte
+}
+
+// line implements the source interface.
+func (bs *bytesSource) line() ([]byte, error) {
+	i := bytes.IndexByte(bs.data, '\n')
+	if i < 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	line := bs.data[:i+1]
+	bs.data = bs.data[i+1:]
+
+	return trimCRLF(line)
+}
+
+// full implements the source interface.
+func (bs *bytesSource) full(n int) ([]byte, error) {
+	if len(bs.data) < n+2 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	data := bs.data[:n+2]
+	bs.data = bs.data[n+2:]
+
+	return trimPayload(data, n)
+}
+
+// trimCRLF removes the CR LF ending the data. Inline commands may end in
+// a bare LF.
+func trimCRLF(data []byte) ([]byte, error) {
+	if !bytes.HasSuffix(data, []byte("\n")) {
+		return nil, &ProtocolError{Reason: "missing line ending"}
+	}
+
+	return bytes.TrimSuffix(data[:len(data)-1], []byte("\r")), nil
+}
+
+// trimPayload removes the CR LF ending the payload of n bytes. Unlike a
+// line, the payload must be followed by both.
+func trimPayload(data []byte, n int) ([]byte, error) {
+	if data[n] != '\r' || data[n+1] != '\n' {
+		return nil, &ProtocolError{Reason: "payload not followed by CR LF"}
+	}
+
+	return data[:n], nil
+}
+
+// =============================================================================
+
+// parser reads values from a source within the limits.
+type parser struct {
+	src      source
+	maxBulk  int
+	maxElems int
+	maxDepth int
+}
+
+// value reads the next value at the depth of nesting.
+func (p *parser) value(depth int) (Value, error) {
+	if depth > p.maxDepth {
+		return Value{}, &ProtocolError{Reason: fmt.Sprintf("nested over %d aggregates", p.maxDepth)}
+	}
+
+	// Empty lines between the commands are skipped like Redis does.
+	var line []byte
+	for len(line) == 0 {
+		var err error
+		if line, err = p.src.line(); err != nil {
+			return Value{}, err
+		}
+		if len(line) == 0 && depth > 0 {
+			return Value{}, &ProtocolError{Reason: "empty line"}
+		}
+	}
+
+	v := Value{Kind: Kind(line[0])}
+	text := string(line[1:])
+
+	var err error
+	switch v.Kind {
+	case KindSimple, KindError, KindBigNumber:
+		v.Str = text
+
+	case KindInteger:
+		if v.Int, err = strconv.ParseInt(text, 10, 64); err != nil {
+			return Value{}, &ProtocolError{Reason: fmt.Sprintf("invalid integer %q", text)}
+		}
+
+	case KindNull:
+
+	case KindBoolean:
+		switch text {
+		case "t":
+			v.Bool = true
+		case "f":
+		default:
+			return Value{}, &ProtocolError{Reason: fmt.Sprintf("invalid boolean %q", text)}
+		}
+
+	case KindDouble:
+		if v.Float, err = strconv.ParseFloat(text, 64); err != nil {
+			return Value{}, &ProtocolError{Reason: fmt.Sprintf("invalid double %q", text)}
+		}
+
+	case KindBulk, KindBulkError, KindVerbatim:
+		n, err := p.length(text, p.maxBulk)
+		if err != nil {
+			return Value{}, err
+		}
+		if n < 0 {
+			v.Null = true
+			break
+		}
+
+		data, err := p.src.full(n)
+		if err != nil {
+			return Value{}, err
+		}
+		v.Str = string(data)
+
+	case KindArray, KindSet, KindPush, KindMap, KindAttribute:
+		n, err := p.length(text, p.maxElems)
+		if err != nil {
+			return Value{}, err
+		}
+		if n < 0 {
+			v.Null = true
+			break
+		}
+		if v.Kind == KindMap || v.Kind == KindAttribute {
+			n *= 2
+		}
+
+		v.Elems = make([]Value, 0, min(n, maxPrealloc))
+		for i := 0; i < n; i++ {
+			elem, err := p.value(depth + 1)
+			if err != nil {
+				return Value{}, err
+			}
+			v.Elems = append(v.Elems, elem)
+		}
+
+	default:
+		if depth > 0 {
+			return Value{}, &ProtocolError{Reason: fmt.Sprintf("unknown type %q", line[0])}
+		}
+		return inline(line), nil
+	}
+
+	return v, nil
+}
+
+// length parses the length of a bulk string or aggregate, -1 for null.
+func (p *parser) length(text string, max int) (int, error) {
+	n, err := strconv.Atoi(text)
+	if err != nil || n < -1 {
+		return 0, &ProtocolError{Reason: fmt.Sprintf("invalid length %q", text)}
+	}
+	if n > max {
+		return 0, fmt.Errorf("resp : length %d over %d : %w", n, max, tcp.ErrFrameTooLarge)
+	}
+
+	return n, nil
+}
+
+// inline returns the inline command as an array of bulk strings.
+func inline(line []byte) Value {
+	fields := bytes.Fields(line)
+
+	v := Value{Kind: KindArray, Elems: make([]Value, len(fields))}
+	for i, f := range fields {
+		v.Elems[i] = Bulk(string(f))
+	}
+
+	return v
+}
@@ -0,0 +1,186 @@
+// Package resp provides a codec for the Redis serialization protocol, RESP2
+// and RESP3, so Redis compatible services and proxies can be built on the
+// tcp package. The Codec reads and writes Values and implements tcp.Codec,
+// and the Command method of a Value can key an OpRouter:
+//
+//	rt := tcp.NewOpRouter[string, resp.Value, resp.Value](resp.Value.Command)
+//	rt.Handle("PING", func(r *tcp.Request, v resp.Value) (resp.Value, error) {
+//	    return resp.Simple("PONG"), nil
+//	})
+//	t, err := tcp.Serve("redis", cfg, resp.Codec{}, rt.Process)
+package resp
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Kind is the type of a value, given by its first byte on the wire.
+type Kind byte
+
+// Set of kinds of RESP2.
+const (
+	KindSimple  Kind = '+'
+	KindError   Kind = '-'
+	KindInteger Kind = ':'
+	KindBulk    Kind = '$'
+	KindArray   Kind = '*'
+)
+
+// Set of kinds added by RESP3.
+const (
+	KindNull      Kind = '_'
+	KindBoolean   Kind = '#'
+	KindDouble    Kind = ','
+	KindBigNumber Kind = '('
+	KindBulkError Kind = '!'
+	KindVerbatim  Kind = '='
+	KindMap       Kind = '%'
+	KindSet       Kind = '~'
+	KindPush      Kind = '>'
+	KindAttribute Kind = '|'
+)
+
+// Value is a value of the protocol. The field used depends on the kind:
+// Str holds the strings, errors, big numbers and verbatim strings with
+// their format prefix, Int the integers, Bool the booleans and Float the
+// doubles. Elems holds the elements of arrays, sets and pushes, and the
+// keys and values of maps and attributes one after the other. Null marks
+// the null bulk string and null array of RESP2.
+type Value struct {
+	Kind  Kind
+	Str   string
+	Int   int64
+	Bool  bool
+	Float float64
+	Elems []Value
+	Null  bool
+}
+
+// Simple returns a simple string.
+func Simple(s string) Value {
+	return Value{Kind: KindSimple, Str: s}
+}
+
+// Error returns a simple error, such as "ERR unknown command".
+func Error(msg string) Value {
+	return Value{Kind: KindError, Str: msg}
+}
+
+// Int returns an integer.
+func Int(n int64) Value {
+	return Value{Kind: KindInteger, Int: n}
+}
+
+// Bulk returns a bulk string.
+func Bulk(s string) Value {
+	return Value{Kind: KindBulk, Str: s}
+}
+
+// Array returns an array of the values.
+func Array(elems ...Value) Value {
+	return Value{Kind: KindArray, Elems: elems}
+}
+
+// NullBulk returns the null bulk string of RESP2.
+func NullBulk() Value {
+	return Value{Kind: KindBulk, Null: true}
+}
+
+// Null returns the null of RESP3.
+func Null() Value {
+	return Value{Kind: KindNull}
+}
+
+// Command returns the name of the command the value holds, upper cased,
+// or an empty string when it's not an array starting with a string.
+func (v Value) Command() string {
+	if v.Kind != KindArray || len(v.Elems) == 0 {
+		return ""
+	}
+
+	switch name := v.Elems[0]; name.Kind {
+	case KindBulk, KindSimple:
+		return strings.ToUpper(name.Str)
+	}
+
+	return ""
+}
+
+// Args returns the strings of the command after its name.
+func (v Value) Args() []string {
+	if v.Kind != KindArray || len(v.Elems) < 2 {
+		return nil
+	}
+
+	args := make([]string, len(v.Elems)-1)
+	for i, e := range v.Elems[1:] {
+		args[i] = e.Str
+	}
+
+	return args
+}
+
+// Append appends the encoding of the value to the buffer.
+func (v Value) Append(b []byte) []byte {
+	b = append(b, byte(v.Kind))
+
+	switch v.Kind {
+	case KindSimple, KindError, KindBigNumber:
+		b = append(b, v.Str...)
+
+	case KindInteger:
+		b = strconv.AppendInt(b, v.Int, 10)
+
+	case KindBulk, KindBulkError, KindVerbatim:
+		if v.Null {
+			return append(b, "-1\r\n"...)
+		}
+		b = strconv.AppendInt(b, int64(len(v.Str)), 10)
+		b = append(b, "\r\n"...)
+		b = append(b, v.Str...)
+
+	case KindNull:
+
+	case KindBoolean:
+		if v.Bool {
+			b = append(b, 't')
+		} else {
+			b = append(b, 'f')
+		}
+
+	case KindDouble:
+		switch {
+		case math.IsInf(v.Float, 1):
+			b = append(b, "inf"...)
+		case math.IsInf(v.Float, -1):
+			b = append(b, "-inf"...)
+		case math.IsNaN(v.Float):
+			b = append(b, "nan"...)
+		default:
+			b = strconv.AppendFloat(b, v.Float, 'g', -1, 64)
+		}
+
+	case KindArray, KindSet, KindPush:
+		if v.Null {
+			return append(b, "-1\r\n"...)
+		}
+		b = strconv.AppendInt(b, int64(len(v.Elems)), 10)
+		b = append(b, "\r\n"...)
+		for _, e := range v.Elems {
+			b = e.Append(b)
+		}
+		return b
+
+	case KindMap, KindAttribute:
+		b = strconv.AppendInt(b, int64(len(v.Elems)/2), 10)
+		b = append(b, "\r\n"...)
+		for _, e := range v.Elems[:len(v.Elems)/2*2] {
+			b = e.Append(b)
+		}
+		return b
+	}
+
+	return append(b, "\r\n"...)
+}
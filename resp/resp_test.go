@@ -0,0 +1,154 @@
+package resp_test
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ardanlabs/tcp"
+	"github.com/ardanlabs/tcp/resp"
+	"github.com/ardanlabs/tcp/tcptest"
+)
+
+// Success and failure markers.
+var (
+	success = "✓"
+	failed  = "✗"
+)
+
+// TestValues tests the values are encoded and read back.
+func TestValues(t *testing.T) {
+	t.Log("Given the need to read and write the values of RESP2 and RESP3.")
+	{
+		tests := []struct {
+			name string
+			v    resp.Value
+			wire string
+		}{
+			{"simple string", resp.Simple("OK"), "+OK\r\n"},
+			{"error", resp.Error("ERR bad"), "-ERR bad\r\n"},
+			{"integer", resp.Int(-42), ":-42\r\n"},
+			{"bulk string", resp.Bulk("a\r\nb"), "$4\r\na\r\nb\r\n"},
+			{"null bulk string", resp.NullBulk(), "$-1\r\n"},
+			{"array", resp.Array(resp.Bulk("GET"), resp.Bulk("k")), "*2\r\n$3\r\nGET\r\n$1\r\nk\r\n"},
+			{"null", resp.Null(), "_\r\n"},
+			{"boolean", resp.Value{Kind: resp.KindBoolean, Bool: true}, "#t\r\n"},
+			{"double", resp.Value{Kind: resp.KindDouble, Float: 1.5}, ",1.5\r\n"},
+			{"infinity", resp.Value{Kind: resp.KindDouble, Float: math.Inf(-1)}, ",-inf\r\n"},
+			{"big number", resp.Value{Kind: resp.KindBigNumber, Str: "3492890328409238509324850943850943825024385"}, "(3492890328409238509324850943850943825024385\r\n"},
+			{"verbatim string", resp.Value{Kind: resp.KindVerbatim, Str: "txt:Some string"}, "=15\r\ntxt:Some string\r\n"},
+			{"map", resp.Value{Kind: resp.KindMap, Elems: []resp.Value{resp.Simple("a"), resp.Int(1)}}, "%1\r\n+a\r\n:1\r\n"},
+			{"set", resp.Value{Kind: resp.KindSet, Elems: []resp.Value{resp.Int(1)}}, "~1\r\n:1\r\n"},
+		}
+
+		var codec resp.Codec
+		for _, tt := range tests {
+			if wire := string(tt.v.Append(nil)); wire != tt.wire {
+				t.Errorf("\tShould encode the %s : %q %s", tt.name, wire, failed)
+				continue
+			}
+
+			v, err := codec.ReadValue(bufio.NewReader(strings.NewReader(tt.wire)))
+			if err != nil || !reflect.DeepEqual(v, tt.v) {
+				t.Errorf("\tShould read the %s : %+v %v %s", tt.name, v, err, failed)
+				continue
+			}
+			t.Logf("\tShould encode and read the %s. %s", tt.name, success)
+		}
+
+		v, err := codec.ReadValue(bufio.NewReader(strings.NewReader("\r\nset key  value\r\n")))
+		if err != nil || v.Command() != "SET" || !reflect.DeepEqual(v.Args(), []string{"key", "value"}) {
+			t.Fatalf("\tShould read inline commands : %+v %v %s", v, err, failed)
+		}
+		t.Log("\tShould read inline commands.", success)
+
+		big := strings.Repeat("x", 100<<10)
+		if v, err := codec.ReadValue(bufio.NewReader(strings.NewReader(fmt.Sprintf("$%d\r\n%s\r\n", len(big), big)))); err != nil || v.Str != big {
+			t.Fatalf("\tShould read bulk strings larger than a chunk : %d %v %s", len(v.Str), err, failed)
+		}
+		if _, err := codec.ReadValue(bufio.NewReader(strings.NewReader("$8000000\r\nhello\r\n"))); err != io.ErrUnexpectedEOF {
+			t.Fatalf("\tShould fail on a bulk string shorter than its length : %v %s", err, failed)
+		}
+		t.Log("\tShould read bulk strings as their bytes arrive.", success)
+
+		limited := resp.Codec{MaxBulkSize: 4, MaxDepth: 2}
+		if _, err := limited.ReadValue(bufio.NewReader(strings.NewReader("$5\r\nhello\r\n"))); !errors.Is(err, tcp.ErrFrameTooLarge) {
+			t.Fatalf("\tShould reject bulk strings over the max : %v %s", err, failed)
+		}
+		var pe *resp.ProtocolError
+		if _, err := limited.ReadValue(bufio.NewReader(strings.NewReader("*1\r\n*1\r\n*1\r\n:1\r\n"))); !errors.As(err, &pe) {
+			t.Fatalf("\tShould reject values nested too deep : %v %s", err, failed)
+		}
+		if _, err := codec.ReadValue(bufio.NewReader(strings.NewReader(":x\r\n"))); !errors.As(err, &pe) {
+			t.Fatalf("\tShould reject values breaking the protocol : %v %s", err, failed)
+		}
+		if _, err := codec.Decode([]byte("$3\r\nab\r\n")); err == nil {
+			t.Fatalf("\tShould reject bulk strings shorter than their length %s", failed)
+		}
+		for _, bad := range []string{"$3\r\nabcX\n", "$3\r\nab\r\n\n"} {
+			if v, err := codec.ReadValue(bufio.NewReader(strings.NewReader(bad))); !errors.As(err, &pe) {
+				t.Fatalf("\tShould reject bulk strings not ending at their length : %q %+v %v %s", bad, v, err, failed)
+			}
+			if v, err := codec.Decode([]byte(bad)); !errors.As(err, &pe) {
+				t.Fatalf("\tShould reject bulk strings not ending at their length : %q %+v %v %s", bad, v, err, failed)
+			}
+		}
+		t.Log("\tShould reject values over the limits or breaking the protocol.", success)
+	}
+}
+
+// TestCodec tests the codec serves commands through an OpRouter.
+func TestCodec(t *testing.T) {
+	t.Log("Given the need to build a Redis compatible service.")
+	{
+		rt := tcp.NewOpRouter[string, resp.Value, resp.Value](resp.Value.Command)
+		rt.Handle("PING", func(r *tcp.Request, v resp.Value) (resp.Value, error) {
+			return resp.Simple("PONG"), nil
+		})
+		rt.Handle("ECHO", func(r *tcp.Request, v resp.Value) (resp.Value, error) {
+			return resp.Bulk(strings.Join(v.Args(), " ")), nil
+		})
+		rt.NotFound = func(r *tcp.Request, v resp.Value) (resp.Value, error) {
+			return resp.Error("ERR unknown command"), nil
+		}
+
+		hs := tcp.Handlers[resp.Value, resp.Value](resp.Codec{}, rt.Process)
+		s := tcptest.NewServer(t, tcp.Config{
+			ReqHandler:  hs.ReqHandler,
+			RespHandler: hs.RespHandler,
+
+			OptBufferSize: tcp.OptBufferSize{
+				ReadBufferSize: 16,
+			},
+		})
+
+		c := s.Dial(t, tcptest.Lines)
+		c.Write([]byte("*1\r\n$4\r\nPING\r\n"))
+		c.Expect([]byte("+PONG\r"))
+		t.Log("\tShould answer the commands.", success)
+
+		// The bulk string is larger than the buffer of the reader.
+		arg := strings.Repeat("x", 40)
+		c.Write(resp.Array(resp.Bulk("echo"), resp.Bulk(arg)).Append(nil))
+		c.Expect([]byte("$40\r"))
+		c.Expect([]byte(arg + "\r"))
+		t.Log("\tShould read values larger than the buffer.", success)
+
+		c.Write([]byte("FLUSHALL\r\n"))
+		c.Expect([]byte("-ERR unknown command\r"))
+		t.Log("\tShould answer unknown commands with the NotFound handler.", success)
+
+		var buf bytes.Buffer
+		buf.Write([]byte("*1\r\n$4\r\nPING\r\n"))
+		if data, err := (resp.Codec{}).Read(&buf); err == nil {
+			t.Fatalf("\tShould require a bufio.Reader : %q %s", data, failed)
+		}
+		t.Log("\tShould require a bufio.Reader.", success)
+	}
+}
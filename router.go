@@ -0,0 +1,230 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownOp is returned by an OpRouter for an opcode with no handler
+// when it has no NotFound handler.
+var ErrUnknownOp = errors.New("unknown opcode")
+
+// HandlerFunc processes a request dispatched by a Router.
+type HandlerFunc func(r *Request)
+
+// prefixRoute binds a wildcard pattern to its handler.
+type prefixRoute struct {
+	prefix string
+	fn     HandlerFunc
+}
+
+// Router dispatches requests to handler functions by the command the
+// Key function extracts from each request. A Router provides the Process
+// method of the ReqHandler interface, so it can be embedded in a type
+// that implements Read.
+//
+// A pattern ending in "*" matches every command starting with the text
+// before it and the pattern "*" matches every command. Exact patterns
+// take precedence, then the longest matching wildcard pattern.
+type Router struct {
+	Key      func(r *Request) string // Extracts the command from the request.
+	Fallback HandlerFunc             // Handles commands no pattern matches.
+
+	mu       sync.RWMutex
+	exact    map[string]HandlerFunc
+	prefixes []prefixRoute
+}
+
+// NewRouter creates a router that uses the key function to extract the
+// command from a request.
+func NewRouter(key func(r *Request) string) *Router {
+	return &Router{
+		Key:   key,
+		exact: make(map[string]HandlerFunc),
+	}
+}
+
+// Handle registers the handler function for the pattern.
+func (rt *Router) Handle(pattern string, fn HandlerFunc) {
+	rt.mu.Lock()
+	{
+		if rt.exact == nil {
+			rt.exact = make(map[string]HandlerFunc)
+		}
+
+		if !strings.HasSuffix(pattern, "*") {
+			rt.exact[pattern] = fn
+			rt.mu.Unlock()
+			return
+		}
+
+		// Replace the handler if the pattern is already registered.
+		prefix := strings.TrimSuffix(pattern, "*")
+		for i := range rt.prefixes {
+			if rt.prefixes[i].prefix == prefix {
+				rt.prefixes[i].fn = fn
+				rt.mu.Unlock()
+				return
+			}
+		}
+
+		// Keep the longest prefixes first so they are matched first.
+		rt.prefixes = append(rt.prefixes, prefixRoute{prefix: prefix, fn: fn})
+		sort.SliceStable(rt.prefixes, func(i, j int) bool {
+			return len(rt.prefixes[i].prefix) > len(rt.prefixes[j].prefix)
+		})
+	}
+	rt.mu.Unlock()
+}
+
+// match finds the handler function for the command and the pattern it
+// was registered with.
+func (rt *Router) match(cmd string) (HandlerFunc, string) {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	if fn, ok := rt.exact[cmd]; ok {
+		return fn, cmd
+	}
+
+	for _, pr := range rt.prefixes {
+		if strings.HasPrefix(cmd, pr.prefix) {
+			return pr.fn, pr.prefix + "*"
+		}
+	}
+
+	return nil, ""
+}
+
+// Process dispatches the request to the handler function registered for
+// its command. Unknown commands are given to the Fallback handler so the
+// connection can stay open. When the TCP value is profiling, the time
+// spent is recorded by the matched pattern.
+func (rt *Router) Process(r *Request) {
+	cmd := rt.Key(r)
+
+	fn, pattern := rt.match(cmd)
+	if fn == nil && rt.Fallback != nil {
+		fn, pattern = rt.Fallback, "(fallback)"
+	}
+
+	if fn != nil {
+		if r.TCP == nil {
+			fn(r)
+			return
+		}
+		r.TCP.profile(PhaseRoute, pattern, func() {
+			fn(r)
+		})
+		return
+	}
+
+	if r.TCP != nil {
+		r.TCP.Event(EvtRoute, TypError, r.TCPAddr.String(), "unknown command : %q", cmd)
+	}
+}
+
+// Reply returns a handler function that sends the data back to the client,
+// such as a protocol specific "unknown command" frame for a Fallback.
+func Reply(data []byte) HandlerFunc {
+	return func(r *Request) {
+
+		// Pooled response data is released once written, so every reply
+		// gets its own copy of the data shared by the requests.
+		b := data
+		if r.TCP != nil && r.TCP.PoolBuffers {
+			b = GetBuffer(len(data))
+			copy(b, data)
+		}
+
+		resp := Response{
+			TCPAddr: r.TCPAddr,
+			Data:    b,
+			Length:  len(b),
+		}
+
+		ctx := r.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		if err := r.Send(ctx, &resp); err != nil && r.TCP != nil {
+			r.TCP.Event(EvtRoute, TypError, r.TCPAddr.String(), "reply : %v", err)
+		}
+	}
+}
+
+// =============================================================================
+
+// OpRouter dispatches decoded requests to typed handlers by the opcode the
+// Key function extracts from each request, such as the message type of a
+// binary protocol. Its Process method is a Handler, so the router can be
+// given to Serve or Handlers. When the TCP value is profiling, the time
+// spent is recorded by opcode.
+type OpRouter[Op comparable, Req, Resp any] struct {
+	Key      func(req Req) Op   // Extracts the opcode from the request.
+	NotFound Handler[Req, Resp] // Handles the opcodes with no handler.
+
+	mu     sync.RWMutex
+	routes map[Op]Handler[Req, Resp]
+}
+
+// NewOpRouter creates a router that uses the key function to extract the
+// opcode from a request.
+func NewOpRouter[Op comparable, Req, Resp any](key func(req Req) Op) *OpRouter[Op, Req, Resp] {
+	return &OpRouter[Op, Req, Resp]{
+		Key:    key,
+		routes: make(map[Op]Handler[Req, Resp]),
+	}
+}
+
+// Handle registers the handler for the opcode.
+func (rt *OpRouter[Op, Req, Resp]) Handle(op Op, fn Handler[Req, Resp]) {
+	rt.mu.Lock()
+	{
+		if rt.routes == nil {
+			rt.routes = make(map[Op]Handler[Req, Resp])
+		}
+		rt.routes[op] = fn
+	}
+	rt.mu.Unlock()
+}
+
+// Process dispatches the request to the handler registered for its opcode.
+// Unknown opcodes are given to the NotFound handler, or fail with
+// ErrUnknownOp so the connection can stay open.
+func (rt *OpRouter[Op, Req, Resp]) Process(r *Request, req Req) (Resp, error) {
+	op := rt.Key(req)
+
+	var fn Handler[Req, Resp]
+	rt.mu.RLock()
+	{
+		fn = rt.routes[op]
+	}
+	rt.mu.RUnlock()
+
+	route := fmt.Sprint(op)
+	if fn == nil {
+		if rt.NotFound == nil {
+			var zero Resp
+			return zero, fmt.Errorf("%w : %v", ErrUnknownOp, op)
+		}
+		fn, route = rt.NotFound, "(not found)"
+	}
+
+	if r.TCP == nil {
+		return fn(r, req)
+	}
+
+	var resp Resp
+	var err error
+	r.TCP.profile(PhaseRoute, route, func() {
+		resp, err = fn(r, req)
+	})
+
+	return resp, err
+}
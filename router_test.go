@@ -0,0 +1,194 @@
+package tcp_test
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/ardanlabs/tcp"
+)
+
+// TestRouter tests requests are dispatched to the matching pattern.
+func TestRouter(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to dispatch requests by command.")
+	{
+		var got string
+		route := func(name string) tcp.HandlerFunc {
+			return func(r *tcp.Request) { got = name }
+		}
+
+		rt := tcp.NewRouter(func(r *tcp.Request) string { return string(r.Data) })
+		rt.Handle("GET", route("GET"))
+		rt.Handle("user.*", route("user.*"))
+		rt.Handle("user.admin.*", route("user.admin.*"))
+		rt.Handle("*", route("*"))
+		rt.Fallback = route("fallback")
+
+		tests := []struct {
+			cmd  string
+			want string
+		}{
+			{"GET", "GET"},
+			{"user.list", "user.*"},
+			{"user.admin.drop", "user.admin.*"},
+			{"SET", "*"},
+		}
+
+		for _, tt := range tests {
+			got = ""
+			rt.Process(&tcp.Request{Data: []byte(tt.cmd)})
+			if got != tt.want {
+				t.Errorf("\tShould route %q to %q. %s got %q", tt.cmd, tt.want, failed, got)
+				continue
+			}
+			t.Logf("\tShould route %q to %q. %s", tt.cmd, tt.want, success)
+		}
+
+		rt = tcp.NewRouter(func(r *tcp.Request) string { return string(r.Data) })
+		rt.Handle("GET", route("GET"))
+		rt.Fallback = route("fallback")
+
+		got = ""
+		rt.Process(&tcp.Request{Data: []byte("SET")})
+		if got != "fallback" {
+			t.Errorf("\tShould route unknown commands to the fallback. %s got %q", failed, got)
+		} else {
+			t.Log("\tShould route unknown commands to the fallback.", success)
+		}
+	}
+}
+
+// TestOpRouter tests decoded requests are dispatched by opcode.
+func TestOpRouter(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to dispatch decoded requests by opcode.")
+	{
+		type message struct {
+			Op   byte
+			Body string
+		}
+
+		rt := tcp.NewOpRouter[byte, message, string](func(m message) byte { return m.Op })
+		rt.Handle(1, func(r *tcp.Request, m message) (string, error) {
+			return "upper:" + strings.ToUpper(m.Body), nil
+		})
+		rt.Handle(2, func(r *tcp.Request, m message) (string, error) {
+			return "lower:" + strings.ToLower(m.Body), nil
+		})
+
+		tests := []struct {
+			msg  message
+			want string
+		}{
+			{message{1, "Go"}, "upper:GO"},
+			{message{2, "Go"}, "lower:go"},
+		}
+
+		for _, tt := range tests {
+			got, err := rt.Process(&tcp.Request{}, tt.msg)
+			if err != nil || got != tt.want {
+				t.Errorf("\tShould route opcode %d to its handler. %s got %q %v", tt.msg.Op, failed, got, err)
+				continue
+			}
+			t.Logf("\tShould route opcode %d to its handler. %s", tt.msg.Op, success)
+		}
+
+		if _, err := rt.Process(&tcp.Request{}, message{9, "Go"}); !errors.Is(err, tcp.ErrUnknownOp) {
+			t.Errorf("\tShould fail unknown opcodes without a NotFound handler. %s got %v", failed, err)
+		} else {
+			t.Log("\tShould fail unknown opcodes without a NotFound handler.", success)
+		}
+
+		rt.NotFound = func(r *tcp.Request, m message) (string, error) {
+			return fmt.Sprintf("unknown:%d", m.Op), nil
+		}
+		if got, err := rt.Process(&tcp.Request{}, message{9, "Go"}); err != nil || got != "unknown:9" {
+			t.Errorf("\tShould route unknown opcodes to the NotFound handler. %s got %q %v", failed, got, err)
+		} else {
+			t.Log("\tShould route unknown opcodes to the NotFound handler.", success)
+		}
+	}
+}
+
+// TestProfile tests the time spent in each phase and route is reported.
+func TestProfile(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to find the hot paths of a protocol.")
+	{
+		rt := tcp.NewRouter(func(r *tcp.Request) string { return strings.TrimSpace(string(r.Data)) })
+		rt.Handle("PING", tcp.Reply([]byte("PONG\n")))
+
+		cfg := tcp.Config{
+			NetType:     "tcp4",
+			Addr:        "127.0.0.1:0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  routedReqHandler{rt},
+			RespHandler: tcpRespHandler{},
+
+			OptProfile: tcp.OptProfile{
+				Profiling: true,
+			},
+		}
+
+		u, err := tcp.New("TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+		if err := u.Start(); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", failed, err)
+		}
+
+		conn, err := net.Dial("tcp4", u.Addr().String())
+		if err != nil {
+			t.Fatal("\tShould be able to dial a new TCP connection.", failed, err)
+		}
+
+		conn.Write([]byte("PING\n"))
+		if resp, err := bufio.NewReader(conn).ReadString('\n'); err != nil || resp != "PONG\n" {
+			t.Fatal("\tShould receive the reply.", failed, resp, err)
+		}
+		conn.Close()
+
+		// Stopping waits for the request to finish being recorded.
+		u.Stop()
+
+		phases := make(map[string]tcp.ProfileStat)
+		for _, ps := range u.Profile() {
+			phases[ps.Phase+" "+ps.Route] = ps
+		}
+
+		for _, key := range []string{"read ", "process ", "write ", "route PING"} {
+			if ps, ok := phases[key]; !ok || ps.Count != 1 {
+				t.Fatalf("\tShould report the %q phase once. %s %+v", key, failed, ps)
+			}
+			t.Logf("\tShould report the %q phase once. %s", key, success)
+		}
+
+		u.ResetProfile()
+		if len(u.Profile()) != 0 {
+			t.Fatal("\tShould be able to reset the profile.", failed)
+		}
+		t.Log("\tShould be able to reset the profile.", success)
+	}
+}
+
+// routedReqHandler reads lines and dispatches them with a router.
+type routedReqHandler struct {
+	*tcp.Router
+}
+
+// Read implements the tcp.ReqHandler interface.
+func (routedReqHandler) Read(ipAddress string, reader io.Reader) ([]byte, int, error) {
+	return tcpReqHandler{}.Read(ipAddress, reader)
+}
@@ -0,0 +1,207 @@
+package tcp
+
+import (
+	"hash/fnv"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defShardQueue is the number of accepted connections queued per shard by
+// default.
+const defShardQueue = 128
+
+// shard holds a part of the connections so joining and removing them
+// only contends on the lock of one shard. With ShardAccept, the accepted
+// connections of the shard are queued to its own goroutine to join.
+type shard struct {
+	mu      sync.Mutex
+	clients map[string]*client
+	queue   chan acceptedConn
+	stats   shardStats
+}
+
+// shardStats maintains the counters of a shard. All fields are accessed
+// atomically.
+type shardStats struct {
+	joined  int64
+	removed int64
+}
+
+// acceptedConn is a connection accepted and waiting to join its shard.
+type acceptedConn struct {
+	conn       net.Conn
+	acceptedAt time.Time
+}
+
+// ShardStat represents the statistics of a shard of the connections.
+type ShardStat struct {
+	Shard   int
+	Clients int
+	Joined  int64
+	Removed int64
+	Queued  int // Connections accepted and waiting to join.
+}
+
+// newShards creates the shards of the connections, one per GOMAXPROCS
+// unless configured.
+func newShards(n int) []*shard {
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+
+	shards := make([]*shard, n)
+	for i := range shards {
+		shards[i] = &shard{
+			clients: make(map[string]*client),
+		}
+	}
+
+	return shards
+}
+
+// shardOf returns the shard holding the connection of the address.
+func (t *TCP) shardOf(ipAddress string) *shard {
+	if len(t.shards) == 1 {
+		return t.shards[0]
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(ipAddress))
+	return t.shards[h.Sum32()%uint32(len(t.shards))]
+}
+
+// startShards starts the goroutines joining the connections queued to
+// each shard when ShardAccept is configured.
+func (t *TCP) startShards() {
+	if !t.ShardAccept {
+		return
+	}
+
+	size := t.ShardQueue
+	if size <= 0 {
+		size = defShardQueue
+	}
+
+	for _, s := range t.shards {
+		queue := make(chan acceptedConn, size)
+		s.mu.Lock()
+		{
+			s.queue = queue
+		}
+		s.mu.Unlock()
+
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+
+			for ac := range queue {
+
+				// Connections still queued as the server stops are
+				// not served.
+				if atomic.LoadInt32(&t.shuttingDown) == 1 {
+					ac.conn.Close()
+					continue
+				}
+				t.join(ac.conn, ac.acceptedAt)
+			}
+		}()
+	}
+}
+
+// enqueue hands the accepted connection to its shard, joining it on the
+// accept routine unless ShardAccept is configured. The accept routine
+// waits while the queue of the shard is full.
+func (t *TCP) enqueue(conn net.Conn, acceptedAt time.Time) {
+	s := t.shardOf(conn.RemoteAddr().String())
+	if s.queue == nil {
+		t.join(conn, acceptedAt)
+		return
+	}
+
+	s.queue <- acceptedConn{conn: conn, acceptedAt: acceptedAt}
+}
+
+// stopShards ends the goroutines of the shards. It's called by the accept
+// routine, the only one queueing connections, as it returns.
+func (t *TCP) stopShards() {
+	for _, s := range t.shards {
+		s.mu.Lock()
+		{
+			if s.queue != nil {
+				close(s.queue)
+				s.queue = nil
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// clientList returns the connections of all the shards.
+func (t *TCP) clientList() []*client {
+	var clts []*client
+	for _, s := range t.shards {
+		s.mu.Lock()
+		{
+			for _, c := range s.clients {
+				clts = append(clts, c)
+			}
+		}
+		s.mu.Unlock()
+	}
+
+	return clts
+}
+
+// ActiveConnections returns the number of client connections without
+// taking any lock.
+func (t *TCP) ActiveConnections() int {
+	return int(atomic.LoadInt64(&t.active))
+}
+
+// ForEachConn calls the function with the statistics of each connection
+// until it returns false. Each shard is only locked to copy its
+// connections, so the function never blocks accepting or removing them.
+// Connections joining or leaving during the call may be missed.
+func (t *TCP) ForEachConn(fn func(s Stat) bool) {
+	var clts []*client
+	for _, s := range t.shards {
+		clts = clts[:0]
+		s.mu.Lock()
+		{
+			for _, c := range s.clients {
+				clts = append(clts, c)
+			}
+		}
+		s.mu.Unlock()
+
+		for _, c := range clts {
+			if !fn(c.stat()) {
+				return
+			}
+		}
+	}
+}
+
+// ShardStats returns the statistics of each shard of the connections.
+func (t *TCP) ShardStats() []ShardStat {
+	stats := make([]ShardStat, len(t.shards))
+	for i, s := range t.shards {
+		stats[i] = ShardStat{
+			Shard:   i,
+			Joined:  atomic.LoadInt64(&s.stats.joined),
+			Removed: atomic.LoadInt64(&s.stats.removed),
+		}
+
+		s.mu.Lock()
+		{
+			stats[i].Clients = len(s.clients)
+			stats[i].Queued = len(s.queue)
+		}
+		s.mu.Unlock()
+	}
+
+	return stats
+}
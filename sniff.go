@@ -0,0 +1,139 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Default values for sniffing the protocol of the connections.
+const (
+	defSniffBytes   = 16
+	defSniffTimeout = 5 * time.Second
+)
+
+// ErrNoProtocol is returned binding a connection whose first bytes match
+// none of the sniff routes.
+var ErrNoProtocol = errors.New("no protocol matched")
+
+// Sniffed is what a sniff route makes of the first bytes of a connection.
+type Sniffed int
+
+// Set of results of matching the first bytes.
+const (
+	SniffNoMatch  Sniffed = iota // The bytes are not of the protocol.
+	SniffMatch                   // The bytes are of the protocol.
+	SniffNeedMore                // More bytes are needed to tell.
+)
+
+// SniffRoute serves the connections whose first bytes match a protocol,
+// so several protocols share one port. The first bytes are matched below
+// any TLS layer and are not consumed, so the handlers read them as usual.
+// A route without Match serves the connections no other route matches.
+type SniffRoute struct {
+	Name     string
+	Match    func(first []byte) Sniffed // Called with the bytes received so far, up to SniffBytes.
+	TLS      bool                       // Perform the TLS handshake with the TLSConfig before binding.
+	Handlers HandlerSet
+}
+
+// SniffTLS matches the first bytes of a TLS handshake record, which starts
+// with the ClientHello.
+func SniffTLS(first []byte) Sniffed {
+	return SniffPrefix([]byte{0x16, 0x03})(first)
+}
+
+// SniffPrefix returns a matcher of the first bytes starting with the
+// prefix, such as the method of an HTTP request.
+func SniffPrefix(prefix []byte) func(first []byte) Sniffed {
+	return func(first []byte) Sniffed {
+		switch {
+		case bytes.HasPrefix(first, prefix):
+			return SniffMatch
+		case bytes.HasPrefix(prefix, first):
+			return SniffNeedMore
+		}
+		return SniffNoMatch
+	}
+}
+
+// sniff peeks at the first bytes of the connection to select its route and
+// returns the connection the rest of the layers must be built on.
+func (c *client) sniff(conn net.Conn) (*SniffRoute, net.Conn, error) {
+	max := c.t.SniffBytes
+	if max <= 0 {
+		max = defSniffBytes
+	}
+
+	timeout := c.t.SniffTimeout
+	if timeout <= 0 {
+		timeout = defSniffTimeout
+	}
+
+	pc := peekConn{Conn: conn, reader: bufio.NewReaderSize(conn, max)}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	route, first, err := c.t.matchSniff(pc.reader, max)
+	conn.SetReadDeadline(time.Time{})
+
+	// A connection closed before sending anything has nothing to serve.
+	if err == io.EOF && len(first) == 0 {
+		return nil, nil, err
+	}
+
+	if route == nil {
+		return nil, nil, fmt.Errorf("%w : First[ %q ]", ErrNoProtocol, first)
+	}
+
+	c.t.Event(EvtRoute, TypInfo, c.ipAddress, "sniffed : %s", route.Name)
+	return route, &pc, nil
+}
+
+// matchSniff peeks at the bytes as they arrive until they match a route, no
+// route needs more of them, SniffBytes were received or reading fails, such
+// as for the timeout. The route without a matcher is selected when no other
+// route matched.
+func (t *TCP) matchSniff(r *bufio.Reader, max int) (*SniffRoute, []byte, error) {
+	var first []byte
+	var err error
+
+	for n := 1; n <= max; n = len(first) + 1 {
+		if _, err = r.Peek(n); err != nil {
+			first, _ = r.Peek(r.Buffered())
+			break
+		}
+
+		first, _ = r.Peek(min(r.Buffered(), max))
+
+		var more bool
+		for i := range t.Sniff {
+			match := t.Sniff[i].Match
+			if match == nil {
+				continue
+			}
+
+			switch match(first) {
+			case SniffMatch:
+				return &t.Sniff[i], first, nil
+			case SniffNeedMore:
+				more = true
+			}
+		}
+
+		if !more {
+			break
+		}
+	}
+
+	for i := range t.Sniff {
+		if t.Sniff[i].Match == nil {
+			return &t.Sniff[i], first, err
+		}
+	}
+
+	return nil, first, err
+}
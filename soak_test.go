@@ -0,0 +1,222 @@
+//go:build soak
+
+// The soak test runs the server against a large number of simulated
+// clients for a long time to catch leaks in the concurrency of the
+// package. It only builds with the soak tag:
+//
+//	go test -tags soak -run TestSoak -timeout 0 -soak.duration 4h
+package tcp_test
+
+import (
+	"bufio"
+	"flag"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/tcp"
+	"github.com/ardanlabs/tcp/tcptest"
+)
+
+var (
+	soakDuration = flag.Duration("soak.duration", time.Hour, "time the soak test runs for")
+	soakClients  = flag.Int("soak.clients", 20000, "clients connected at once")
+	soakSample   = flag.Duration("soak.sample", time.Minute, "time between stability checks")
+	soakWarmup   = flag.Duration("soak.warmup", 10*time.Minute, "time the baseline is taken over")
+	soakGrowth   = flag.Float64("soak.growth", 1.5, "growth allowed over the baseline")
+)
+
+// TestSoak runs the server with clients that connect, send a random number
+// of messages and disconnect, some of them abruptly, while the rate limit
+// is switched on and off. The goroutines and heap in use must stay within
+// the peaks seen during the warmup and the goroutines must return to the
+// baseline once the server is stopped.
+func TestSoak(t *testing.T) {
+	t.Log("Given the need to keep the server stable under load for hours.")
+	{
+		baseGoroutines := runtime.NumGoroutine()
+
+		var rateLimit int64
+		l := tcptest.NewListener()
+		srv, err := tcp.New("soak", tcp.Config{
+			NetType:     "tcp4",
+			Addr:        "127.0.0.1:0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptRateLimit: tcp.OptRateLimit{
+				RateLimit: func() time.Duration {
+					return time.Duration(atomic.LoadInt64(&rateLimit))
+				},
+			},
+			OptListen: tcp.OptListen{
+				Listen: l.Listen,
+			},
+		})
+		if err != nil {
+			t.Fatalf("\tShould be able to create a new TCP listener : %v %s", err, failed)
+		}
+		if err := srv.Start(); err != nil {
+			t.Fatalf("\tShould be able to start the TCP listener : %v %s", err, failed)
+		}
+
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		var exchanges, dropped int64
+
+		// Switch the rate limit on and off so connections are dropped
+		// in bursts.
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ticker := time.NewTicker(5 * time.Second)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+					if atomic.LoadInt64(&rateLimit) == 0 {
+						atomic.StoreInt64(&rateLimit, int64(time.Millisecond))
+					} else {
+						atomic.StoreInt64(&rateLimit, 0)
+					}
+				case <-done:
+					return
+				}
+			}
+		}()
+
+		for i := 0; i < *soakClients; i++ {
+			wg.Add(1)
+			go func(seed int64) {
+				defer wg.Done()
+
+				rnd := rand.New(rand.NewSource(seed))
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+
+					// Back off like a real client when dropped.
+					if !soakClient(l, rnd) {
+						atomic.AddInt64(&dropped, 1)
+						time.Sleep(time.Duration(100+rnd.Intn(900)) * time.Millisecond)
+						continue
+					}
+					atomic.AddInt64(&exchanges, 1)
+				}
+			}(int64(i))
+		}
+
+		// The usage swings with the clients the rate limit lets in, so
+		// the baseline is the peak seen during the warmup.
+		var goroutines int
+		var heap uint64
+		warmup := time.Now().Add(*soakWarmup)
+
+		end := time.After(*soakDuration)
+		ticker := time.NewTicker(*soakSample)
+
+	soak:
+		for {
+			select {
+			case <-ticker.C:
+				g, h := soakUsage()
+				t.Logf("\tSample : Goroutines[%d] Heap[%d] Clients[%d] Exchanges[%d] Dropped[%d]", g, h, srv.Clients(), atomic.LoadInt64(&exchanges), atomic.LoadInt64(&dropped))
+
+				if time.Now().Before(warmup) {
+					if g > goroutines {
+						goroutines = g
+					}
+					if h > heap {
+						heap = h
+					}
+					continue
+				}
+
+				if float64(g) > float64(goroutines)**soakGrowth {
+					t.Errorf("\tShould keep the goroutines stable : Baseline[%d] Now[%d] %s", goroutines, g, failed)
+					break soak
+				}
+				if float64(h) > float64(heap)**soakGrowth {
+					t.Errorf("\tShould keep the heap stable : Baseline[%d] Now[%d] %s", heap, h, failed)
+					break soak
+				}
+
+			case <-end:
+				break soak
+			}
+		}
+		ticker.Stop()
+
+		close(done)
+		wg.Wait()
+
+		if err := srv.Stop(); err != nil {
+			t.Fatalf("\tShould be able to stop the TCP listener : %v %s", err, failed)
+		}
+
+		// Give the runtime a moment to retire the goroutines.
+		deadline := time.Now().Add(10 * time.Second)
+		for runtime.NumGoroutine() > baseGoroutines && time.Now().Before(deadline) {
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		if g := runtime.NumGoroutine(); g > baseGoroutines {
+			t.Fatalf("\tShould return to the baseline goroutines once stopped : Baseline[%d] Now[%d] %s", baseGoroutines, g, failed)
+		}
+		t.Log("\tShould return to the baseline goroutines once stopped.", success)
+	}
+}
+
+// soakClient connects, sends a random number of messages and disconnects,
+// abruptly a third of the time. It reports false when the connection was
+// dropped by the server.
+func soakClient(l *tcptest.Listener, rnd *rand.Rand) bool {
+	conn, err := l.Dial()
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	for n := rnd.Intn(20); n > 0; n-- {
+
+		// Disconnect in the middle of a message.
+		if rnd.Intn(3) == 0 && n == 1 {
+			conn.Write([]byte("Hel"))
+			return true
+		}
+
+		if _, err := conn.Write([]byte("Hello\n")); err != nil {
+			return false
+		}
+		if _, err := reader.ReadString('\n'); err != nil {
+			return false
+		}
+
+		time.Sleep(time.Duration(rnd.Intn(50)) * time.Millisecond)
+	}
+
+	return true
+}
+
+// soakUsage returns the goroutines running and the heap in use after a
+// garbage collection.
+func soakUsage() (int, uint64) {
+	runtime.GC()
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	return runtime.NumGoroutine(), ms.HeapInuse
+}
@@ -0,0 +1,337 @@
+package socks5
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// version is the version of the protocol.
+const version = 0x05
+
+// Set of authentication methods.
+const (
+	methodNone     = 0x00
+	methodPassword = 0x02
+	methodRefused  = 0xFF
+)
+
+// Set of commands.
+const (
+	cmdConnect = 0x01
+)
+
+// Set of address types.
+const (
+	atypIPv4   = 0x01
+	atypDomain = 0x03
+	atypIPv6   = 0x04
+)
+
+// Set of reply codes.
+const (
+	repSucceeded        = 0x00
+	repFailure          = 0x01
+	repNotAllowed       = 0x02
+	repNetUnreachable   = 0x03
+	repHostUnreachable  = 0x04
+	repRefused          = 0x05
+	repTTLExpired       = 0x06
+	repCmdNotSupported  = 0x07
+	repAddrNotSupported = 0x08
+)
+
+// Set of values of the username/password negotiation.
+const (
+	passwordVersion       = 0x01
+	passwordStatusSuccess = 0x00
+	passwordStatusFailure = 0x01
+)
+
+// serve negotiates the session of the connection and tunnels the bytes to
+// the destination. It returns io.EOF once the tunnel closes.
+func (s *Server) serve(conn net.Conn) error {
+	conn.SetDeadline(time.Now().Add(s.cfg.HandshakeTimeout))
+
+	if err := s.authenticate(conn); err != nil {
+		return err
+	}
+
+	host, port, err := s.request(conn)
+	if err != nil {
+		return err
+	}
+
+	// Resolve a domain name so it's checked against the networks of the
+	// policies.
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.DialTimeout)
+	ips, err := s.lookup(ctx, host)
+	cancel()
+	if err != nil {
+		reply(conn, replyCode(err), nil)
+		return io.EOF
+	}
+
+	// Refuse the destination before anything is dialed.
+	p := s.policy(host, ips, port)
+	if (p == nil && s.cfg.DenyUnmatched) || (p != nil && p.Deny) {
+		reply(conn, repNotAllowed, nil)
+		return io.EOF
+	}
+
+	dial, timeout := s.cfg.Dial, s.cfg.DialTimeout
+	if p != nil && p.Dial != nil {
+		dial = p.Dial
+	}
+	if p != nil && p.DialTimeout > 0 {
+		timeout = p.DialTimeout
+	}
+
+	// The default dialer connects to the addresses checked, so the name
+	// can't resolve to another network once allowed.
+	addrs := []string{net.JoinHostPort(host, strconv.Itoa(port))}
+	if s.direct && (p == nil || p.Dial == nil) && len(ips) > 0 {
+		addrs = addrs[:0]
+		for _, ip := range ips {
+			addrs = append(addrs, net.JoinHostPort(ip.String(), strconv.Itoa(port)))
+		}
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	var upstream net.Conn
+	for _, addr := range addrs {
+		if upstream, err = dial(ctx, "tcp", addr); err == nil {
+			break
+		}
+	}
+	cancel()
+	if err != nil {
+		reply(conn, replyCode(err), nil)
+		return io.EOF
+	}
+	defer upstream.Close()
+
+	if err := reply(conn, repSucceeded, upstream.LocalAddr()); err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+
+	tunnel(conn, upstream)
+	return io.EOF
+}
+
+// lookup returns the addresses of the host when it has to be checked
+// against the networks of the policies. An IP is its own address.
+func (s *Server) lookup(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	if !s.resolve {
+		return nil, nil
+	}
+	return s.cfg.Lookup(ctx, host)
+}
+
+// authenticate negotiates the method of the client and checks the
+// credentials when they're required.
+func (s *Server) authenticate(conn net.Conn) error {
+	var hdr [2]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return err
+	}
+	if hdr[0] != version {
+		return &ProtocolError{Reason: fmt.Sprintf("unsupported version %d", hdr[0])}
+	}
+
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return err
+	}
+
+	want := byte(methodNone)
+	if s.cfg.Credentials != nil {
+		want = methodPassword
+	}
+
+	var offered bool
+	for _, m := range methods {
+		if m == want {
+			offered = true
+			break
+		}
+	}
+
+	if !offered {
+		conn.Write([]byte{version, methodRefused})
+		return io.EOF
+	}
+	if _, err := conn.Write([]byte{version, want}); err != nil {
+		return err
+	}
+
+	if want == methodNone {
+		return nil
+	}
+
+	// The username/password negotiation of RFC 1929.
+	var ver [2]byte
+	if _, err := io.ReadFull(conn, ver[:]); err != nil {
+		return err
+	}
+	if ver[0] != passwordVersion {
+		return &ProtocolError{Reason: fmt.Sprintf("unsupported password version %d", ver[0])}
+	}
+
+	user := make([]byte, ver[1])
+	if _, err := io.ReadFull(conn, user); err != nil {
+		return err
+	}
+
+	var plen [1]byte
+	if _, err := io.ReadFull(conn, plen[:]); err != nil {
+		return err
+	}
+	password := make([]byte, plen[0])
+	if _, err := io.ReadFull(conn, password); err != nil {
+		return err
+	}
+
+	if !s.cfg.Credentials(string(user), string(password)) {
+		conn.Write([]byte{passwordVersion, passwordStatusFailure})
+		return io.EOF
+	}
+
+	_, err := conn.Write([]byte{passwordVersion, passwordStatusSuccess})
+	return err
+}
+
+// request reads the command of the client and returns its destination.
+func (s *Server) request(conn net.Conn) (string, int, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return "", 0, err
+	}
+	if hdr[0] != version {
+		return "", 0, &ProtocolError{Reason: fmt.Sprintf("unsupported version %d", hdr[0])}
+	}
+
+	var host string
+	switch hdr[3] {
+	case atypIPv4, atypIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if hdr[3] == atypIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", 0, err
+		}
+		host = ip.String()
+
+	case atypDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			return "", 0, err
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return "", 0, err
+		}
+		host = string(name)
+
+	default:
+		reply(conn, repAddrNotSupported, nil)
+		return "", 0, io.EOF
+	}
+
+	var port [2]byte
+	if _, err := io.ReadFull(conn, port[:]); err != nil {
+		return "", 0, err
+	}
+
+	// The destination is read in full before the command is refused so
+	// the reply is the next thing the client reads.
+	if hdr[1] != cmdConnect {
+		reply(conn, repCmdNotSupported, nil)
+		return "", 0, io.EOF
+	}
+
+	return host, int(binary.BigEndian.Uint16(port[:])), nil
+}
+
+// reply writes the reply to the request with the address bound to the
+// destination.
+func reply(conn net.Conn, code byte, addr net.Addr) error {
+	ip := net.IPv4zero.To4()
+	var port int
+	if ta, ok := addr.(*net.TCPAddr); ok {
+		ip, port = ta.IP, ta.Port
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+	}
+
+	atyp := byte(atypIPv4)
+	if len(ip) == net.IPv6len {
+		atyp = atypIPv6
+	}
+
+	b := append([]byte{version, code, 0x00, atyp}, ip...)
+	b = binary.BigEndian.AppendUint16(b, uint16(port))
+
+	_, err := conn.Write(b)
+	return err
+}
+
+// replyCode maps the error dialing a destination to a reply code.
+func replyCode(err error) byte {
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return repRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return repNetUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.As(err, &dnsErr):
+		return repHostUnreachable
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return repTTLExpired
+	}
+	return repFailure
+}
+
+// =============================================================================
+
+// tunnel copies the bytes between the client and the destination until
+// the destination closes or either side fails. A client that stops sending
+// half closes the destination, so the responses still arrive.
+func tunnel(conn, upstream net.Conn) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		io.Copy(conn, upstream)
+
+		// Unblock the copy from the client.
+		conn.SetReadDeadline(time.Now())
+	}()
+
+	// closeWriter is declared to test for the existence of the method
+	// coming from the net package.
+	type closeWriter interface {
+		CloseWrite() error
+	}
+
+	_, err := io.Copy(upstream, conn)
+	if cw, ok := upstream.(closeWriter); ok && err == nil {
+		cw.CloseWrite()
+	} else {
+		upstream.Close()
+	}
+
+	<-done
+}
@@ -0,0 +1,280 @@
+// Package socks5 provides a SOCKS5 proxy served by the handlers of a
+// tcp.TCP value, so the proxy gets the accept, admission, rate limiting
+// and stats of the package. The CONNECT command is supported with the no
+// authentication and username/password methods, and each destination is
+// checked against policies that can deny it or pick how it's dialed:
+//
+//	s, err := socks5.New(socks5.Config{
+//	    Policies: []socks5.Policy{
+//	        {Networks: []string{"10.0.0.0/8"}, Deny: true},
+//	    },
+//	})
+//	hs := s.Handlers()
+//	t, err := tcp.New("proxy", tcp.Config{
+//	    NetType:     "tcp4",
+//	    Addr:        ":1080",
+//	    ConnHandler: hs.ConnHandler,
+//	    ReqHandler:  hs.ReqHandler,
+//	    RespHandler: hs.RespHandler,
+//	})
+package socks5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/ardanlabs/tcp"
+)
+
+// Default timeouts of a proxied connection.
+const (
+	defHandshakeTimeout = 10 * time.Second
+	defDialTimeout      = 10 * time.Second
+)
+
+// ErrInvalidPolicy is returned by New when a policy can't be parsed.
+var ErrInvalidPolicy = errors.New("socks5 : invalid policy")
+
+// ProtocolError is returned when a client breaks the SOCKS5 protocol. The
+// connection is closed since it can't be read any further.
+type ProtocolError struct {
+	Reason string
+}
+
+// Error implements the error interface for ProtocolError.
+func (pe *ProtocolError) Error() string {
+	return "socks5 : " + pe.Reason
+}
+
+// Temporary reports the connection can't be read after a protocol error.
+func (pe *ProtocolError) Temporary() bool {
+	return false
+}
+
+// DialFunc connects to an upstream destination.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// LookupFunc resolves the addresses of a domain name.
+type LookupFunc func(ctx context.Context, host string) ([]net.IP, error)
+
+// Policy applies to the destinations it matches. A destination matches
+// when its host is in Hosts or its IP is in Networks, and its port is in
+// Ports. A domain name matches Networks when one of its addresses does.
+// Empty lists match every destination.
+type Policy struct {
+	Hosts       []string      // Domain names, "*.example.com" matches the subdomains.
+	Networks    []string      // IPs and CIDRs of the destinations.
+	Ports       []int         // Ports of the destinations.
+	Deny        bool          // Refuses the destinations matched.
+	Dial        DialFunc      // Dials the destinations matched, defaults to Config.Dial.
+	DialTimeout time.Duration // Time allowed to dial, defaults to Config.DialTimeout.
+
+	nets []*net.IPNet
+}
+
+// matches reports whether the policy applies to the destination. The ips
+// are the addresses of the host, resolved when it's a domain name.
+func (p *Policy) matches(host string, ips []net.IP, port int) bool {
+	if len(p.Ports) > 0 {
+		var found bool
+		for _, pt := range p.Ports {
+			if pt == port {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(p.Hosts) == 0 && len(p.nets) == 0 {
+		return true
+	}
+
+	// A domain name is checked against the networks of the addresses it
+	// resolves to, so a name can't reach a network denied.
+	for _, ip := range ips {
+		for _, n := range p.nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+	}
+	if net.ParseIP(host) != nil {
+		return false
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, h := range p.Hosts {
+		h = strings.ToLower(h)
+		if suffix, ok := strings.CutPrefix(h, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+			continue
+		}
+		if host == h {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Config declares how the proxy authenticates clients and dials the
+// destinations.
+type Config struct {
+	Credentials      func(user, password string) bool // Enables the username/password method, required of every client when set.
+	Dial             DialFunc                         // Dials the destinations, defaults to a net.Dialer.
+	Lookup           LookupFunc                       // Resolves the domain names checked against Networks, defaults to net.DefaultResolver.
+	DialTimeout      time.Duration                    // Time allowed to dial, defaults to 10 seconds.
+	HandshakeTimeout time.Duration                    // Time allowed to negotiate, defaults to 10 seconds.
+	Policies         []Policy                         // Checked in order, the first match applies.
+	DenyUnmatched    bool                             // Refuses the destinations no policy matches.
+}
+
+// Server is a SOCKS5 proxy. Its handlers are bound to the connections of
+// a tcp.TCP value.
+type Server struct {
+	cfg     Config
+	resolve bool // Domain names are resolved since a policy has networks.
+	direct  bool // Resolved destinations are dialed by address.
+}
+
+// New creates a proxy with the configuration.
+func New(cfg Config) (*Server, error) {
+	direct := cfg.Dial == nil
+	if cfg.Dial == nil {
+		var d net.Dialer
+		cfg.Dial = d.DialContext
+	}
+	if cfg.Lookup == nil {
+		cfg.Lookup = func(ctx context.Context, host string) ([]net.IP, error) {
+			return net.DefaultResolver.LookupIP(ctx, "ip", host)
+		}
+	}
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = defDialTimeout
+	}
+	if cfg.HandshakeTimeout <= 0 {
+		cfg.HandshakeTimeout = defHandshakeTimeout
+	}
+
+	// Copy the policies so the networks parsed are not shared with
+	// the caller.
+	var resolve bool
+	policies := make([]Policy, len(cfg.Policies))
+	for i, p := range cfg.Policies {
+		for _, port := range p.Ports {
+			if port <= 0 || port > 0xFFFF {
+				return nil, fmt.Errorf("%w : port %d", ErrInvalidPolicy, port)
+			}
+		}
+
+		nets, err := parseNets(p.Networks)
+		if err != nil {
+			return nil, err
+		}
+		p.nets = nets
+		policies[i] = p
+		resolve = resolve || len(nets) > 0
+	}
+	cfg.Policies = policies
+
+	return &Server{cfg: cfg, resolve: resolve, direct: direct}, nil
+}
+
+// parseNets parses a list of IPs and CIDRs. An IP is a network of a single
+// address.
+func parseNets(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("%w : %q", ErrInvalidPolicy, s)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w : %q", ErrInvalidPolicy, s)
+		}
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+// policy returns the policy that applies to the destination, nil when none
+// matches.
+func (s *Server) policy(host string, ips []net.IP, port int) *Policy {
+	for i := range s.cfg.Policies {
+		if s.cfg.Policies[i].matches(host, ips, port) {
+			return &s.cfg.Policies[i]
+		}
+	}
+	return nil
+}
+
+// Handlers returns the handlers serving the proxy. The whole session of a
+// connection runs in the Read of the ReqHandler, which returns io.EOF once
+// the tunnel closes, so no request is ever processed.
+func (s *Server) Handlers() tcp.HandlerSet {
+	return tcp.HandlerSet{
+		ConnHandler: connHandler{},
+		ReqHandler:  reqHandler{s: s},
+		RespHandler: respHandler{},
+	}
+}
+
+// =============================================================================
+
+// connHandler binds the connection itself, so the session can read the
+// negotiation and tunnel the bytes.
+type connHandler struct{}
+
+// Bind implements the tcp.ConnHandler interface.
+func (connHandler) Bind(conn net.Conn) (io.Reader, io.Writer) {
+	return conn, conn
+}
+
+// reqHandler runs the session of each connection.
+type reqHandler struct {
+	s *Server
+}
+
+// Read implements the tcp.ReqHandler interface.
+func (rh reqHandler) Read(ipAddress string, reader io.Reader) ([]byte, int, error) {
+	conn, ok := reader.(net.Conn)
+	if !ok {
+		return nil, 0, &ProtocolError{Reason: "connection must be bound by the proxy handlers"}
+	}
+
+	return nil, 0, rh.s.serve(conn)
+}
+
+// Process implements the tcp.ReqHandler interface.
+func (reqHandler) Process(r *tcp.Request) {}
+
+// respHandler writes the responses sent to a proxied connection as they
+// are given.
+type respHandler struct{}
+
+// Write implements the tcp.RespHandler interface.
+func (respHandler) Write(r *tcp.Response, writer io.Writer) error {
+	_, err := writer.Write(r.Data[:r.Length])
+	return err
+}
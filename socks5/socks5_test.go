@@ -0,0 +1,191 @@
+package socks5_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/ardanlabs/tcp"
+	"github.com/ardanlabs/tcp/socks5"
+	"github.com/ardanlabs/tcp/tcptest"
+)
+
+// Success and failure markers.
+var (
+	success = "✓"
+	failed  = "✗"
+)
+
+// TestProxy tests clients are tunneled to the destinations allowed.
+func TestProxy(t *testing.T) {
+	t.Log("Given the need to proxy clients with the SOCKS5 protocol.")
+	{
+		dialed := make(chan string, 10)
+		s, err := socks5.New(socks5.Config{
+			Credentials: func(user, password string) bool {
+				return user == "bill" && password == "secret"
+			},
+			Dial:   echoDial(dialed),
+			Lookup: lookup,
+			Policies: []socks5.Policy{
+				{Networks: []string{"10.0.0.0/8"}, Deny: true},
+				{Hosts: []string{"*.internal"}, Deny: true},
+				{Hosts: []string{"echo.example.com"}, Ports: []int{7}},
+			},
+			DenyUnmatched: true,
+		})
+		if err != nil {
+			t.Fatalf("\tShould be able to create the proxy : %v %s", err, failed)
+		}
+
+		hs := s.Handlers()
+		srv := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: hs.ConnHandler,
+			ReqHandler:  hs.ReqHandler,
+			RespHandler: hs.RespHandler,
+		})
+
+		c := srv.Dial(t, tcptest.Lines)
+		c.Write([]byte{5, 1, 0})
+		expect(t, c, []byte{5, 0xFF}, "Should refuse a client without credentials")
+		c.ExpectClosed()
+
+		c = srv.Dial(t, tcptest.Lines)
+		c.Write([]byte{5, 1, 2})
+		expect(t, c, []byte{5, 2}, "Should ask for the credentials")
+		c.Write([]byte{1, 4, 'b', 'i', 'l', 'l', 3, 'b', 'a', 'd'})
+		expect(t, c, []byte{1, 1}, "Should refuse the wrong credentials")
+		c.ExpectClosed()
+
+		c = srv.Dial(t, tcptest.Lines)
+		login(t, c)
+		c.Write(connect(atypDomain("echo.example.com"), 7))
+		expect(t, c, []byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}, "Should connect to the destination allowed")
+		if addr := <-dialed; addr != "echo.example.com:7" {
+			t.Fatalf("\tShould dial the destination : %s %s", addr, failed)
+		}
+		c.RoundTrip([]byte("Hello"), []byte("Hello"))
+		t.Log("\tShould tunnel the bytes to the destination.", success)
+
+		denied := []struct {
+			name string
+			addr []byte
+			port int
+		}{
+			{"network", []byte{1, 10, 1, 2, 3}, 80},
+			{"name resolving into the network", atypDomain("db.corp"), 80},
+			{"host", atypDomain("db.internal"), 5432},
+			{"port", atypDomain("echo.example.com"), 8},
+			{"unmatched destination", atypDomain("other.example.com"), 7},
+		}
+		for _, tt := range denied {
+			c := srv.Dial(t, tcptest.Lines)
+			login(t, c)
+			c.Write(connect(tt.addr, tt.port))
+			expect(t, c, []byte{5, 2, 0, 1, 0, 0, 0, 0, 0, 0}, "Should refuse the "+tt.name+" denied")
+			c.ExpectClosed()
+		}
+
+		c = srv.Dial(t, tcptest.Lines)
+		login(t, c)
+		c.Write([]byte{5, 2, 0, 1, 127, 0, 0, 1, 0, 80})
+		expect(t, c, []byte{5, 7, 0, 1, 0, 0, 0, 0, 0, 0}, "Should refuse the commands not supported")
+		c.ExpectClosed()
+
+		if _, err := socks5.New(socks5.Config{Policies: []socks5.Policy{{Networks: []string{"bad"}}}}); !errors.Is(err, socks5.ErrInvalidPolicy) {
+			t.Fatalf("\tShould reject a policy that can't be parsed : %v %s", err, failed)
+		}
+		t.Log("\tShould reject a policy that can't be parsed.", success)
+	}
+}
+
+// TestProxyResolve tests a domain name is refused when it resolves into a
+// network denied.
+func TestProxyResolve(t *testing.T) {
+	t.Log("Given the need to deny networks to the names resolving into them.")
+	{
+		s, err := socks5.New(socks5.Config{
+			Policies: []socks5.Policy{
+				{Networks: []string{"127.0.0.0/8", "::1"}, Deny: true},
+			},
+		})
+		if err != nil {
+			t.Fatalf("\tShould be able to create the proxy : %v %s", err, failed)
+		}
+
+		hs := s.Handlers()
+		srv := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: hs.ConnHandler,
+			ReqHandler:  hs.ReqHandler,
+			RespHandler: hs.RespHandler,
+		})
+
+		c := srv.Dial(t, tcptest.Lines)
+		c.Write([]byte{5, 1, 0})
+		expect(t, c, []byte{5, 0}, "Should accept a client without credentials")
+		c.Write(connect(atypDomain("localhost"), 80))
+		expect(t, c, []byte{5, 2, 0, 1, 0, 0, 0, 0, 0, 0}, "Should refuse localhost in the network denied")
+		c.ExpectClosed()
+	}
+}
+
+// =============================================================================
+
+// lookup resolves db.corp into a private network and the other names into
+// a public one.
+func lookup(ctx context.Context, host string) ([]net.IP, error) {
+	if host == "db.corp" {
+		return []net.IP{net.ParseIP("10.20.30.40")}, nil
+	}
+	return []net.IP{net.ParseIP("192.0.2.10")}, nil
+}
+
+// echoDial connects to an in-memory destination echoing the bytes back.
+func echoDial(dialed chan<- string) socks5.DialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed <- addr
+
+		client, server := net.Pipe()
+		go func() {
+			io.Copy(server, server)
+			server.Close()
+		}()
+
+		return client, nil
+	}
+}
+
+// login negotiates the username/password method.
+func login(t *testing.T, c *tcptest.Conn) {
+	t.Helper()
+
+	c.Write([]byte{5, 2, 0, 2})
+	expect(t, c, []byte{5, 2}, "Should ask for the credentials")
+	c.Write([]byte{1, 4, 'b', 'i', 'l', 'l', 6, 's', 'e', 'c', 'r', 'e', 't'})
+	expect(t, c, []byte{1, 0}, "Should accept the credentials")
+}
+
+// atypDomain encodes the domain name as the address of a request.
+func atypDomain(name string) []byte {
+	return append([]byte{3, byte(len(name))}, name...)
+}
+
+// connect encodes a CONNECT request to the address.
+func connect(addr []byte, port int) []byte {
+	b := append([]byte{5, 1, 0}, addr...)
+	return append(b, byte(port>>8), byte(port))
+}
+
+// expect reads the bytes the proxy sent and compares them.
+func expect(t *testing.T, c *tcptest.Conn, want []byte, msg string) {
+	t.Helper()
+
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(c, got); err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("\t%s : %v %v %s", msg, got, err, failed)
+	}
+	t.Log("\t"+msg+".", success)
+}
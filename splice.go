@@ -0,0 +1,75 @@
+package tcp
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+)
+
+// Set of ways a proxied connection moves its bytes, reported in its Stat.
+const (
+	ProxySplice = "splice" // In the kernel with splice between the sockets, on Linux.
+	ProxyCopy   = "copy"   // Through a buffer, when splice can't be used.
+)
+
+// Set of values for the proxy mode of a client.
+const (
+	proxyNone int32 = iota
+	proxySplice
+	proxyCopy
+)
+
+// proxyMode returns how the proxied connection moves its bytes, empty when
+// it's not proxied.
+func (c *client) proxyMode() string {
+	switch atomic.LoadInt32(&c.proxy) {
+	case proxySplice:
+		return ProxySplice
+	case proxyCopy:
+		return ProxyCopy
+	}
+	return ""
+}
+
+// spliceConns returns the sockets below the client connection and the
+// upstream when the bytes can move between them in the kernel. Any layer
+// above the client socket, such as TLS, a tap or bytes read ahead, needs
+// the bytes in user space.
+func (c *client) spliceConns(upstream net.Conn) (*net.TCPConn, *net.TCPConn, bool) {
+	if !spliceSupported {
+		return nil, nil, false
+	}
+
+	var rw net.Conn
+	c.writeMu.Lock()
+	{
+		rw = c.rw
+	}
+	c.writeMu.Unlock()
+
+	if rw != net.Conn(&c.counts) {
+		return nil, nil, false
+	}
+
+	cc, ok := c.counts.Conn.(*net.TCPConn)
+	if !ok {
+		return nil, nil, false
+	}
+
+	uc, ok := upstream.(*net.TCPConn)
+	if !ok {
+		return nil, nil, false
+	}
+
+	return cc, uc, true
+}
+
+// spliceCopy copies between the sockets, which the runtime does with
+// splice, and adds the bytes to the counters once the copy is done.
+func spliceCopy(dst, src *net.TCPConn, counters ...*int64) error {
+	n, err := io.Copy(dst, src)
+	for _, counter := range counters {
+		atomic.AddInt64(counter, n)
+	}
+	return err
+}
@@ -0,0 +1,5 @@
+package tcp
+
+// spliceSupported reports whether the bytes of a proxied connection can
+// move between the sockets in the kernel with splice.
+const spliceSupported = true
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package tcp
+
+// spliceSupported reports whether the bytes of a proxied connection can
+// move between the sockets in the kernel with splice.
+const spliceSupported = false
@@ -0,0 +1,50 @@
+package tcp
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+)
+
+// StartTLS upgrades a plaintext client connection to TLS in response to a
+// protocol command like STARTTLS. The upgrade happens once the request
+// being processed returns, so the response telling the client to begin
+// the handshake must be sent first. The reader and writer are rebound to
+// the TLS connection through the ConnHandler. A failed handshake closes
+// the connection.
+func (t *TCP) StartTLS(tcpAddr *net.TCPAddr, cfg *tls.Config) error {
+	if cfg == nil {
+		return ErrInvalidTLS
+	}
+
+	c, err := t.client(tcpAddr)
+	if err != nil {
+		return err
+	}
+
+	c.schedule(func() error {
+		c.writeMu.Lock()
+		defer c.writeMu.Unlock()
+
+		if c.tlsConn != nil {
+			return errors.New("starttls : connection is already using tls")
+		}
+
+		// Writes are blocked until the handshake is done so nothing
+		// can be written in plaintext once the client expects TLS.
+		tlsConn, err := c.handshake(c.rw, cfg)
+		if err != nil {
+			return err
+		}
+
+		c.unbind()
+		c.rw = tlsConn
+		c.reader, c.writer = c.handlers.ConnHandler.Bind(tlsConn)
+		c.publishPeer()
+
+		t.Event(EvtTLS, TypInfo, c.ipAddress, "starttls : upgraded")
+		return nil
+	})
+
+	return nil
+}
@@ -0,0 +1,218 @@
+package tcp
+
+import (
+	"sync"
+	"time"
+)
+
+// Store keeps the state of the rate limit, the bans and the quotas, such as
+// in a Redis shared by a fleet of servers so the limits hold over all of
+// them instead of per process. Counters count within windows and holds keep
+// a key until a time. The time of the server is passed in so stores can
+// follow the configured clock.
+type Store interface {
+
+	// Record records an event of the key at now and returns the events of
+	// the key within the window ending at now. Older events are forgotten.
+	Record(key string, now time.Time, window time.Duration) (int64, error)
+
+	// Add adds n, which can be negative, to the counter of the key in the
+	// window of the length now falls in and returns the counter. The
+	// counter of a window starts at 0 and is forgotten once the window
+	// ends. A zero length is a window that never ends.
+	Add(key string, now time.Time, window time.Duration, n int64) (int64, error)
+
+	// Hold holds the key until the time unless it's already held past
+	// now, and reports whether it did.
+	Hold(key string, now, until time.Time) (bool, error)
+
+	// Set holds the key until the time, replacing any hold.
+	Set(key string, now, until time.Time) error
+
+	// Until returns the time the key is held until, or the zero time when
+	// it's not held past now.
+	Until(key string, now time.Time) (time.Time, error)
+
+	// Delete forgets the events, the counter and the hold of the key.
+	Delete(key string) error
+}
+
+// storeSweepEvery is the time between the sweeps of the memory store
+// forgetting the keys that expired.
+const storeSweepEvery = time.Minute
+
+// MemoryStore keeps the state in the memory of the process. It's the Store
+// used when none is configured.
+type MemoryStore struct {
+	mu     sync.Mutex
+	events map[string]storeEvents
+	counts map[string]storeCount
+	holds  map[string]time.Time
+	swept  time.Time
+}
+
+// storeEvents are the events of a key within its window.
+type storeEvents struct {
+	window time.Duration
+	times  []time.Time
+}
+
+// storeCount is the counter of a key in its latest window.
+type storeCount struct {
+	start  time.Time
+	window time.Duration
+	n      int64
+}
+
+// NewMemoryStore creates an empty store.
+func NewMemoryStore() *MemoryStore {
+	s := MemoryStore{
+		events: make(map[string]storeEvents),
+		counts: make(map[string]storeCount),
+		holds:  make(map[string]time.Time),
+	}
+
+	return &s
+}
+
+// Record implements the Store interface.
+func (s *MemoryStore) Record(key string, now time.Time, window time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+
+	ev := s.events[key]
+	ev.window = window
+
+	from := now.Add(-window)
+	i := 0
+	for i < len(ev.times) && !ev.times[i].After(from) {
+		i++
+	}
+	ev.times = append(ev.times[i:], now)
+	s.events[key] = ev
+
+	return int64(len(ev.times)), nil
+}
+
+// Add implements the Store interface. Only the latest window of a key is
+// kept, and a counter back to 0 is forgotten.
+func (s *MemoryStore) Add(key string, now time.Time, window time.Duration, n int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+
+	var start time.Time
+	if window > 0 {
+		start = now.Truncate(window)
+	}
+
+	c := s.counts[key]
+	if !c.start.Equal(start) || c.window != window {
+		c = storeCount{start: start, window: window}
+	}
+	c.n += n
+
+	if c.n == 0 {
+		delete(s.counts, key)
+		return 0, nil
+	}
+	s.counts[key] = c
+
+	return c.n, nil
+}
+
+// Hold implements the Store interface.
+func (s *MemoryStore) Hold(key string, now, until time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if held, ok := s.holds[key]; ok && held.After(now) {
+		return false, nil
+	}
+	s.holds[key] = until
+
+	return true, nil
+}
+
+// Set implements the Store interface. The holds that expired are forgotten
+// so the map doesn't grow.
+func (s *MemoryStore) Set(key string, now, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, held := range s.holds {
+		if !held.After(now) {
+			delete(s.holds, k)
+		}
+	}
+	s.holds[key] = until
+
+	return nil
+}
+
+// Until implements the Store interface.
+func (s *MemoryStore) Until(key string, now time.Time) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	held, ok := s.holds[key]
+	if !ok || !held.After(now) {
+		return time.Time{}, nil
+	}
+
+	return held, nil
+}
+
+// Delete implements the Store interface.
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.events, key)
+	delete(s.counts, key)
+	delete(s.holds, key)
+
+	return nil
+}
+
+// sweep forgets the keys whose events are all out of their window and the
+// counters whose window ended, at most once per storeSweepEvery, so the
+// keys not seen again don't stay for good. The caller must hold mu.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.swept) < storeSweepEvery {
+		return
+	}
+	s.swept = now
+
+	for k, ev := range s.events {
+		if n := len(ev.times); n == 0 || !ev.times[n-1].Add(ev.window).After(now) {
+			delete(s.events, k)
+		}
+	}
+
+	for k, c := range s.counts {
+		if c.window > 0 && !c.start.Add(c.window).After(now) {
+			delete(s.counts, k)
+		}
+	}
+}
+
+// =============================================================================
+
+// holdRate holds the rate limit of the TCP value for the duration so only
+// one connection is accepted per duration. The store failing lets the
+// connection through.
+func (t *TCP) holdRate(d time.Duration) bool {
+	now := t.now()
+
+	ok, err := t.Store.Hold("rate/"+t.Name, now, now.Add(d))
+	if err != nil {
+		t.Event(EvtAccept, TypError, "", "rate limit : store : %v", err)
+		return true
+	}
+
+	return ok
+}
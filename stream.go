@@ -0,0 +1,172 @@
+package tcp
+
+import (
+	"context"
+	"io"
+	"net"
+)
+
+// defStreamChunk is the largest read returned by Recv without a ReqHandler.
+const defStreamChunk = 4096
+
+// StreamHandler is implemented by the user to serve each connection as a
+// duplex stream instead of reading, processing and writing one request at
+// a time, for protocols that aren't strictly request/response.
+type StreamHandler interface {
+
+	// ServeStream is called on the goroutine of the connection once it's
+	// bound. The connection is closed when it returns.
+	ServeStream(s *Stream) error
+}
+
+// StreamFunc adapts a function to the StreamHandler interface.
+type StreamFunc func(s *Stream) error
+
+// ServeStream implements the StreamHandler interface.
+func (fn StreamFunc) ServeStream(s *Stream) error {
+	return fn(s)
+}
+
+// Stream is a connection served by a StreamHandler. Recv is called by one
+// goroutine at a time, while Send can be called from any goroutine, such
+// as one receiving and another sending.
+type Stream struct {
+	TCP      *TCP
+	TCPAddr  *net.TCPAddr
+	IsIPv6   bool
+	Identity string
+
+	c   *client
+	ctx context.Context
+}
+
+// Context returns the context of the stream, done once the connection is
+// closing or the TCP value is stopped.
+func (s *Stream) Context() context.Context {
+	return s.ctx
+}
+
+// Recv returns the next message read with the ReqHandler, or the bytes
+// available when there's none. It returns io.EOF once the client closes
+// the connection.
+func (s *Stream) Recv() ([]byte, error) {
+	c := s.c
+
+	data, length, err := c.handlers.ReqHandler.Read(c.ipAddress, c.reader)
+	c.touch()
+
+	if err != nil {
+		switch {
+		case err == io.EOF:
+			c.setCloseReason(CloseEOF)
+		case peerDead(err):
+			c.setCloseReason(CloseKeepAlive)
+		}
+		return nil, err
+	}
+
+	return data[:length], nil
+}
+
+// Send writes the data with the RespHandler, or as they are when there's
+// none. As with TCP.Send, the data is owned by the pool once written when
+// the TCP value is configured with PoolBuffers.
+func (s *Stream) Send(data []byte) error {
+	return s.TCP.Send(s.ctx, &Response{
+		TCPAddr: s.TCPAddr,
+		Data:    data,
+		Length:  len(data),
+	})
+}
+
+// =============================================================================
+
+// serveStream hands the connection to the StreamHandler until it returns.
+func (c *client) serveStream() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Dropping the connection cancels the stream.
+	c.writeMu.Lock()
+	{
+		c.cancelStream = cancel
+	}
+	c.writeMu.Unlock()
+
+	go func() {
+		select {
+		case <-c.t.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	s := Stream{
+		TCP:      c.t,
+		TCPAddr:  c.conn.RemoteAddr().(*net.TCPAddr),
+		IsIPv6:   c.isIPv6,
+		Identity: c.identity,
+		c:        c,
+		ctx:      ctx,
+	}
+
+	if err := c.t.StreamHandler.ServeStream(&s); err != nil && err != io.EOF {
+		c.t.Event(EvtRead, TypError, c.ipAddress, "stream : %v", err)
+		c.setCloseReason(CloseHandlerError)
+		return
+	}
+
+	c.setCloseReason(CloseEOF)
+}
+
+// stopStream cancels the context of the stream served on the connection.
+func (c *client) stopStream() {
+	var cancel context.CancelFunc
+	c.writeMu.Lock()
+	{
+		cancel = c.cancelStream
+	}
+	c.writeMu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// rawReqHandler reads the bytes available for a Stream without a
+// ReqHandler.
+type rawReqHandler struct{}
+
+// Read implements the ReqHandler interface.
+func (rawReqHandler) Read(ipAddress string, reader io.Reader) ([]byte, int, error) {
+	buf := make([]byte, defStreamChunk)
+	n, err := reader.Read(buf)
+	if n > 0 {
+		return buf, n, nil
+	}
+	return nil, 0, err
+}
+
+// Process implements the ReqHandler interface.
+func (rawReqHandler) Process(r *Request) {}
+
+// rawRespHandler writes the data of a Stream without a RespHandler.
+type rawRespHandler struct{}
+
+// Write implements the RespHandler interface.
+func (rawRespHandler) Write(r *Response, writer io.Writer) error {
+	if _, err := writer.Write(r.Data[:r.Length]); err != nil {
+		return err
+	}
+
+	// flusher is declared to test for the existence of the method
+	// coming from the bufio package.
+	type flusher interface {
+		Flush() error
+	}
+
+	if f, ok := writer.(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package tcp
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// CloseReason describes why a connection was closed. It's reported in the
+// ConnSummary, the events, the metrics and to a CloseNotifier.
+type CloseReason string
+
+// Set of reasons a connection was closed.
+const (
+	CloseEOF            CloseReason = "eof"             // The client closed the connection.
+	CloseReadError      CloseReason = "read_error"      // Reading a request failed.
+	CloseReadTimeout    CloseReason = "read_timeout"    // Reading a request didn't finish in time.
+	CloseWriteError     CloseReason = "write_error"     // OnWriteError asked to close the connection.
+	CloseWriteTimeout   CloseReason = "write_timeout"   // OnWriteError asked to close the connection after a write timed out.
+	CloseRateLimited    CloseReason = "rate_limited"    // The ReqHandler rejected the client with ErrRateLimited.
+	CloseFrameTooLarge  CloseReason = "frame_too_large" // A request was over MaxRequestBytes or the limit of the protocol.
+	CloseBindError      CloseReason = "bind_error"      // The handshake or bind failed.
+	CloseHandlerError   CloseReason = "handler_error"   // A change the handlers asked for failed, such as StartTLS.
+	CloseTurnViolation  CloseReason = "turn_violation"  // The client broke the turns in half duplex mode.
+	CloseGoAway         CloseReason = "go_away"         // The connection was closed gracefully, such as to rebalance.
+	CloseDrained        CloseReason = "drained"         // The connection was drained with Drain.
+	CloseDropped        CloseReason = "dropped"         // The handlers or the user asked for the connection to close with Drop or Kick.
+	CloseIdle           CloseReason = "idle"            // The connection was groomed for being idle.
+	CloseShutdown       CloseReason = "shutdown"        // The TCP value was stopped.
+	CloseUpstreamError  CloseReason = "upstream_error"  // No upstream of the proxy could be dialed.
+	CloseKeepAlive      CloseReason = "keepalive"       // The client stopped answering the keepalive probes.
+	CloseMemoryLimit    CloseReason = "memory_limit"    // The connection buffered more than the memory caps allow.
+	CloseGeoDenied      CloseReason = "geo_denied"      // The geo policy denied the client.
+	CloseConnectRefused CloseReason = "connect_refused" // The CONNECT request was malformed or not allowed.
+	CloseAuthFailed     CloseReason = "auth_failed"     // The AuthHandler failed to authenticate the client.
+	CloseQuotaExceeded  CloseReason = "quota_exceeded"  // The identity had its quota of connections open.
+	CloseStreamEnded    CloseReason = "stream_ended"    // The StreamHandler returned or the tunnel of Connect or the proxy ended.
+)
+
+// CloseNotifier is implemented by connection handlers that want to know
+// why each connection closed, such as to release the state they keep for
+// the client. Closed is called once the connection is closed and unbound.
+type CloseNotifier interface {
+	Closed(conn net.Conn, reason CloseReason)
+}
+
+// connStats maintains the counters of a connection for its summary. All
+// fields are accessed atomically.
+type connStats struct {
+	requests    int64
+	responses   int64
+	readErrors  int64
+	writeErrors int64
+}
+
+// ConnSummary is the record of a closed connection, such as for metering
+// the usage of each client. The field names and reasons are stable.
+type ConnSummary struct {
+	Addr         string        `json:"addr"`
+	Identity     string        `json:"identity,omitempty"`
+	Tags         []string      `json:"tags,omitempty"`
+	ConnectedAt  time.Time     `json:"connected_at"`
+	Duration     time.Duration `json:"duration_ns"`
+	Requests     int64         `json:"requests"`
+	Responses    int64         `json:"responses"`
+	BytesRead    int64         `json:"bytes_read"`
+	BytesWritten int64         `json:"bytes_written"`
+	ReadErrors   int64         `json:"read_errors"`
+	WriteErrors  int64         `json:"write_errors"`
+	Reason       CloseReason   `json:"reason"`
+}
+
+// setCloseReason records why the connection is closing and reports if it
+// was the first reason recorded, which is the one reported. It never waits
+// on a write so a client that stopped reading can always be closed.
+func (c *client) setCloseReason(reason CloseReason) bool {
+	return c.closeReason.CompareAndSwap(nil, &reason)
+}
+
+// readCloseReason classifies a read error that closes the connection.
+func (t *TCP) readCloseReason(err error) CloseReason {
+	switch t.Classify(err) {
+	case ErrDeadline:
+		return CloseReadTimeout
+	case ErrRateLimited:
+		return CloseRateLimited
+	}
+	return CloseReadError
+}
+
+// closed reports the reason the connection closed to the metrics and the
+// connection handler, and returns it. Connections closing without a reason
+// recorded were closed by the client.
+func (c *client) closed() CloseReason {
+	c.setCloseReason(CloseEOF)
+	reason := *c.closeReason.Load()
+
+	c.t.metrics.closed(reason)
+
+	if cn, ok := c.handlers.ConnHandler.(CloseNotifier); ok {
+		cn.Closed(c.conn, reason)
+	}
+
+	return reason
+}
+
+// summarize delivers the summary of the closed connection to OnClose.
+func (c *client) summarize(tags []string, reason CloseReason) {
+	if c.t.OnClose == nil {
+		return
+	}
+
+	s := ConnSummary{
+		Addr:         c.t.anonymize(c.ipAddress),
+		Identity:     c.identity,
+		Tags:         tags,
+		ConnectedAt:  c.timeConn,
+		Duration:     c.t.now().Sub(c.timeConn),
+		Requests:     atomic.LoadInt64(&c.stats.requests),
+		Responses:    atomic.LoadInt64(&c.stats.responses),
+		BytesRead:    atomic.LoadInt64(&c.counts.read),
+		BytesWritten: atomic.LoadInt64(&c.counts.written),
+		ReadErrors:   atomic.LoadInt64(&c.stats.readErrors),
+		WriteErrors:  atomic.LoadInt64(&c.stats.writeErrors),
+		Reason:       reason,
+	}
+
+	c.t.OnClose(s)
+}
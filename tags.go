@@ -0,0 +1,207 @@
+package tcp
+
+import (
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Tagger is implemented by connection handlers that want to tag the
+// connections they bind, such as with the tenant named in the client
+// certificate. Tags is called once the connection is bound.
+type Tagger interface {
+	Tags(conn net.Conn) []string
+}
+
+// tagMetrics maintains the counters of a tag. The bytes are those of the
+// closed connections, the open ones are counted by their countConn. All
+// fields are accessed atomically.
+type tagMetrics struct {
+	connections  int64
+	readErrors   int64
+	writeErrors  int64
+	bytesRead    int64
+	bytesWritten int64
+}
+
+// tagStats holds the counters of every tag seen.
+type tagStats struct {
+	mu sync.Mutex
+	m  map[string]*tagMetrics
+}
+
+// TagStat represents the statistics of the connections with a tag.
+type TagStat struct {
+	Tag          string
+	Connections  int64 // Connections tagged since the start.
+	Active       int   // Tagged connections still open.
+	BytesRead    int64
+	BytesWritten int64
+	ReadErrors   int64
+	WriteErrors  int64
+}
+
+// TagStats returns the statistics of every tag sorted by tag.
+func (t *TCP) TagStats() []TagStat {
+	t.tagStats.mu.Lock()
+	defer t.tagStats.mu.Unlock()
+
+	stats := make(map[string]*TagStat, len(t.tagStats.m))
+	for tag, m := range t.tagStats.m {
+		stats[tag] = &TagStat{
+			Tag:          tag,
+			Connections:  atomic.LoadInt64(&m.connections),
+			BytesRead:    atomic.LoadInt64(&m.bytesRead),
+			BytesWritten: atomic.LoadInt64(&m.bytesWritten),
+			ReadErrors:   atomic.LoadInt64(&m.readErrors),
+			WriteErrors:  atomic.LoadInt64(&m.writeErrors),
+		}
+	}
+
+	// Add the bytes of the open connections.
+	clts := t.clientList()
+
+	for _, c := range clts {
+		for _, tm := range c.tagMetrics() {
+			s := stats[tm.tag]
+			s.Active++
+			s.BytesRead += atomic.LoadInt64(&c.counts.read)
+			s.BytesWritten += atomic.LoadInt64(&c.counts.written)
+		}
+	}
+
+	list := make([]TagStat, 0, len(stats))
+	for _, s := range stats {
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Tag < list[j].Tag })
+
+	return list
+}
+
+// taggedMetrics pairs a tag with its counters.
+type taggedMetrics struct {
+	tag string
+	*tagMetrics
+}
+
+// tag returns the counters for the tags, creating the ones not seen yet.
+func (t *TCP) tag(tags []string) []taggedMetrics {
+	t.tagStats.mu.Lock()
+	defer t.tagStats.mu.Unlock()
+
+	if t.tagStats.m == nil {
+		t.tagStats.m = make(map[string]*tagMetrics)
+	}
+
+	tms := make([]taggedMetrics, 0, len(tags))
+	for _, tag := range tags {
+		m, ok := t.tagStats.m[tag]
+		if !ok {
+			m = &tagMetrics{}
+			t.tagStats.m[tag] = m
+		}
+		atomic.AddInt64(&m.connections, 1)
+		tms = append(tms, taggedMetrics{tag: tag, tagMetrics: m})
+	}
+
+	return tms
+}
+
+// untag adds the bytes of the closing client to its tags. The client
+// is no longer counted as open once it's done.
+func (t *TCP) untag(c *client) {
+	t.tagStats.mu.Lock()
+	defer t.tagStats.mu.Unlock()
+
+	tms := c.tags.Swap(nil)
+	if tms == nil {
+		return
+	}
+
+	for _, tm := range *tms {
+		atomic.AddInt64(&tm.bytesRead, atomic.LoadInt64(&c.counts.read))
+		atomic.AddInt64(&tm.bytesWritten, atomic.LoadInt64(&c.counts.written))
+	}
+}
+
+// tagMetrics returns the counters of the tags of the client. The tags are
+// read without waiting on a write so the stats of a client that stopped
+// reading can still be taken.
+func (c *client) tagMetrics() []taggedMetrics {
+	if tms := c.tags.Load(); tms != nil {
+		return *tms
+	}
+	return nil
+}
+
+// tagNames returns the tags of the client.
+func (c *client) tagNames() []string {
+	tms := c.tagMetrics()
+	if len(tms) == 0 {
+		return nil
+	}
+
+	tags := make([]string, len(tms))
+	for i, tm := range tms {
+		tags[i] = tm.tag
+	}
+	return tags
+}
+
+// uniqueTags returns the tags without duplicates or empty tags, keeping
+// the order they were given in.
+func uniqueTags(tags []string) []string {
+	var unique []string
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		unique = append(unique, tag)
+	}
+	return unique
+}
+
+// =============================================================================
+
+// countConn counts the bytes read and written on a connection. The
+// counters are accessed atomically.
+type countConn struct {
+	net.Conn
+	read    int64
+	written int64
+}
+
+// Read implements the io.Reader interface.
+func (cc *countConn) Read(p []byte) (int, error) {
+	n, err := cc.Conn.Read(p)
+	atomic.AddInt64(&cc.read, int64(n))
+	return n, err
+}
+
+// Write implements the io.Writer interface.
+func (cc *countConn) Write(p []byte) (int, error) {
+	n, err := cc.Conn.Write(p)
+	atomic.AddInt64(&cc.written, int64(n))
+	return n, err
+}
+
+// CloseWrite closes the write side of the connection when it supports it.
+func (cc *countConn) CloseWrite() error {
+	if cw, ok := cc.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// ReadFrom copies from the reader to the connection, keeping the fast
+// paths the connection provides for files and sockets.
+func (cc *countConn) ReadFrom(r io.Reader) (int64, error) {
+	n, err := io.Copy(cc.Conn, r)
+	atomic.AddInt64(&cc.written, n)
+	return n, err
+}
@@ -0,0 +1,98 @@
+package tcp
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"sync/atomic"
+)
+
+// TapDir is the direction of the bytes passed to the Tap.
+type TapDir int
+
+// Set of tap directions.
+const (
+	TapIn  TapDir = iota + 1 // Bytes read from the client.
+	TapOut                   // Bytes written to the client.
+)
+
+// String implements the fmt.Stringer interface.
+func (d TapDir) String() string {
+	switch d {
+	case TapIn:
+		return "in"
+	case TapOut:
+		return "out"
+	}
+	return "unknown"
+}
+
+// tapped selects the connections passed to the Tap.
+func (t *TCP) tapped() bool {
+	if t.Tap == nil {
+		return false
+	}
+
+	return t.TapRate <= 0 || t.TapRate >= 1 || rand.Float64() < t.TapRate
+}
+
+// =============================================================================
+
+// tapConn passes copies of the bytes read and written to the Tap. The
+// bytes of each direction are counted atomically to apply the limit.
+type tapConn struct {
+	net.Conn
+	t         *TCP
+	ipAddress string
+	in        int64
+	out       int64
+}
+
+// Read implements the io.Reader interface.
+func (tc *tapConn) Read(p []byte) (int, error) {
+	n, err := tc.Conn.Read(p)
+	if n > 0 {
+		tc.tap(TapIn, &tc.in, p[:n])
+	}
+	return n, err
+}
+
+// Write implements the io.Writer interface.
+func (tc *tapConn) Write(p []byte) (int, error) {
+	n, err := tc.Conn.Write(p)
+	if n > 0 {
+		tc.tap(TapOut, &tc.out, p[:n])
+	}
+	return n, err
+}
+
+// CloseWrite closes the write side of the connection when it supports it.
+func (tc *tapConn) CloseWrite() error {
+	if cw, ok := tc.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// ReadFrom copies from the reader to the connection through Write so the
+// bytes are tapped.
+func (tc *tapConn) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(struct{ io.Writer }{tc}, r)
+}
+
+// tap passes a copy of the bytes to the Tap until the limit of the
+// direction is reached.
+func (tc *tapConn) tap(dir TapDir, tapped *int64, b []byte) {
+	if max := tc.t.TapMaxBytes; max > 0 {
+		end := atomic.AddInt64(tapped, int64(len(b)))
+		start := end - int64(len(b))
+		if start >= max {
+			return
+		}
+		if end > max {
+			b = b[:max-start]
+		}
+	}
+
+	tc.t.Tap(tc.ipAddress, dir, append([]byte(nil), b...))
+}
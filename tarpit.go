@@ -0,0 +1,211 @@
+package tcp
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default values for the tarpit.
+const (
+	defTarpitDelay = 10 * time.Second
+	defTarpitBytes = 1
+	defTarpitFor   = time.Hour
+)
+
+// tarpit holds the IPs flagged into the tarpit and when their flag
+// expires.
+type tarpit struct {
+	mu  sync.Mutex
+	ips map[string]time.Time
+}
+
+// Tarpit flags the IP so its new connections are accepted into the tarpit,
+// where their reads and writes crawl to waste the resources of scanners
+// and abusive clients. The flag expires after the duration, TarpitFor when
+// it's 0. Handlers flag the clients they find abusive with the IP of the
+// request.
+func (t *TCP) Tarpit(ip net.IP, d time.Duration) {
+	if d <= 0 {
+		d = t.tarpitFor()
+	}
+
+	now := t.now()
+	t.tarpit.mu.Lock()
+	{
+		if t.tarpit.ips == nil {
+			t.tarpit.ips = make(map[string]time.Time)
+		}
+
+		// Forget the flags that expired so the map doesn't grow.
+		for k, until := range t.tarpit.ips {
+			if !now.Before(until) {
+				delete(t.tarpit.ips, k)
+			}
+		}
+
+		t.tarpit.ips[ip.String()] = now.Add(d)
+	}
+	t.tarpit.mu.Unlock()
+
+	t.Event(EvtAccept, TypInfo, ip.String(), "tarpit : flagged : For[ %v ]", d)
+}
+
+// Untarpit removes the flag of the IP. Connections already in the tarpit
+// stay there.
+func (t *TCP) Untarpit(ip net.IP) {
+	t.tarpit.mu.Lock()
+	{
+		delete(t.tarpit.ips, ip.String())
+	}
+	t.tarpit.mu.Unlock()
+}
+
+// Tarpitted reports whether the IP is flagged into the tarpit.
+func (t *TCP) Tarpitted(ip net.IP) bool {
+	now := t.now()
+
+	t.tarpit.mu.Lock()
+	defer t.tarpit.mu.Unlock()
+
+	until, ok := t.tarpit.ips[ip.String()]
+	if !ok {
+		return false
+	}
+
+	if !now.Before(until) {
+		delete(t.tarpit.ips, ip.String())
+		return false
+	}
+
+	return true
+}
+
+// tarpitFor returns how long an IP stays flagged by default.
+func (cfg *Config) tarpitFor() time.Duration {
+	if cfg.TarpitFor > 0 {
+		return cfg.TarpitFor
+	}
+	return defTarpitFor
+}
+
+// =============================================================================
+
+// tarpitConn delays every read and write of the connection and moves only
+// a few bytes at a time.
+type tarpitConn struct {
+	net.Conn
+	t     *TCP
+	delay time.Duration
+	bytes int
+
+	once sync.Once
+	stop chan struct{}
+}
+
+// newTarpitConn wraps the connection into the tarpit when its IP is
+// flagged, otherwise it returns nil.
+func newTarpitConn(t *TCP, conn net.Conn) *tarpitConn {
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok || !t.Tarpitted(addr.IP) {
+		return nil
+	}
+
+	atomic.AddInt64(&t.metrics.tarpitted, 1)
+	t.Event(EvtAccept, TypInfo, conn.RemoteAddr().String(), "tarpit")
+
+	tc := tarpitConn{
+		Conn:  conn,
+		t:     t,
+		delay: t.TarpitDelay,
+		bytes: t.TarpitBytes,
+		stop:  make(chan struct{}),
+	}
+	if tc.delay <= 0 {
+		tc.delay = defTarpitDelay
+	}
+	if tc.bytes <= 0 {
+		tc.bytes = defTarpitBytes
+	}
+
+	return &tc
+}
+
+// wait waits for the delay. It reports false once the connection is
+// closed or the TCP value is stopped.
+func (tc *tarpitConn) wait() bool {
+	timer := tc.t.clock().NewTimer(tc.delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C():
+		return true
+	case <-tc.stop:
+	case <-tc.t.done:
+	}
+	return false
+}
+
+// Read implements the io.Reader interface.
+func (tc *tarpitConn) Read(p []byte) (int, error) {
+	if !tc.wait() {
+		return 0, net.ErrClosed
+	}
+
+	if len(p) > tc.bytes {
+		p = p[:tc.bytes]
+	}
+	return tc.Conn.Read(p)
+}
+
+// Write implements the io.Writer interface.
+func (tc *tarpitConn) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		if !tc.wait() {
+			return n, net.ErrClosed
+		}
+
+		chunk := p
+		if len(chunk) > tc.bytes {
+			chunk = chunk[:tc.bytes]
+		}
+
+		w, err := tc.Conn.Write(chunk)
+		n += w
+		if err != nil {
+			return n, err
+		}
+		p = p[w:]
+	}
+
+	return n, nil
+}
+
+// CloseWrite closes the write side of the connection.
+func (tc *tarpitConn) CloseWrite() error {
+
+	// closeWriter is declared to test for the existence of the
+	// method coming from the net package.
+	type closeWriter interface {
+		CloseWrite() error
+	}
+
+	cw, ok := tc.Conn.(closeWriter)
+	if !ok {
+		return ErrNoHalfClose
+	}
+	return cw.CloseWrite()
+}
+
+// Close ends the delays waiting and closes the connection.
+func (tc *tarpitConn) Close() error {
+	tc.release()
+	return tc.Conn.Close()
+}
+
+// release ends the delays waiting, such as when the connection is dropped.
+func (tc *tarpitConn) release() {
+	tc.once.Do(func() { close(tc.stop) })
+}
@@ -3,9 +3,12 @@ package tcp
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
+	"os"
+	"sort"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -19,6 +22,13 @@ var (
 	ErrInvalidConnHandler   = errors.New("invalid connection handler configuration")
 	ErrInvalidReqHandler    = errors.New("invalid request handler configuration")
 	ErrInvalidRespHandler   = errors.New("invalid response handler configuration")
+	ErrInvalidTLS           = errors.New("invalid tls configuration")
+	ErrInvalidCanary        = errors.New("invalid canary configuration")
+	ErrInvalidWatermark     = errors.New("invalid watermark configuration")
+	ErrInvalidHalfDuplex    = errors.New("invalid half duplex configuration")
+	ErrInvalidAdmission     = errors.New("invalid admission configuration")
+	ErrInvalidProxy         = errors.New("invalid proxy configuration")
+	ErrInvalidCompression   = errors.New("invalid compression configuration")
 )
 
 // Set of event types.
@@ -29,6 +39,22 @@ const (
 	EvtRemove
 	EvtDrop
 	EvtGroom
+	EvtRecord
+	EvtRoute
+	EvtTLS
+	EvtRebalance
+	EvtHealth
+	EvtDependency
+	EvtBreaker
+	EvtWrite
+	EvtConfig
+	EvtProxy
+	EvtProcess
+	EvtLoad
+	EvtBan
+	EvtAuth
+	EvtCluster
+	EvtAdmin
 )
 
 // Set of event sub types.
@@ -60,18 +86,60 @@ type TCP struct {
 	port      int
 	tcpAddr   *net.TCPAddr
 
-	listener   *net.TCPListener
+	listener   net.Listener
 	listenerMu sync.Mutex
+	inherited  bool
+	provided   bool
 
-	clients   map[string]*client
-	clientsMu sync.Mutex
+	shards []*shard
+	active int64
 
-	wg sync.WaitGroup
+	wg   sync.WaitGroup
+	done chan struct{}
+	work [priorities]chan func()
+
+	workers     int64 // Size of the worker pool.
+	workersBusy int64 // Workers running a function.
+	workQueued  int64 // Functions waiting for a worker.
+
+	bulkWrites  chan struct{}
+	dropBanners chan struct{}
+	banners     chan struct{}
 
 	dropConns    int32
+	maintenance  int32
 	shuttingDown int32
 
-	lastAcceptedConnection time.Time
+	accepting      int32
+	acceptFailures int32
+
+	pauseMu sync.Mutex
+	resume  chan struct{}
+
+	health  health
+	breaker breaker
+	load    load
+	mem     memory
+	tarpit  tarpit
+
+	identities identities
+
+	certs       *CertStore
+	certVersion certStamp
+
+	accessMu sync.Mutex
+
+	configMu sync.RWMutex
+	access   accessList
+
+	reactor *reactor
+	proxy   proxy
+
+	metrics  metrics
+	canary   canary
+	tagStats tagStats
+	profiler profiler
+	tracer   Tracer
 }
 
 // New creates a new manager to service clients.
@@ -82,13 +150,83 @@ func New(name string, cfg Config) (*TCP, error) {
 		return nil, err
 	}
 
+	// Bind connections to pooled buffers when only the sizes are given.
+	if cfg.ConnHandler == nil {
+		cfg.ConnHandler = BufferedConnHandler{
+			ReadBufferSize:  cfg.ReadBufferSize,
+			WriteBufferSize: cfg.WriteBufferSize,
+		}
+	}
+
+	// Read and write the bytes of a stream as they are without handlers.
+	if cfg.StreamHandler != nil {
+		if cfg.ReqHandler == nil {
+			cfg.ReqHandler = rawReqHandler{}
+		}
+		if cfg.RespHandler == nil {
+			cfg.RespHandler = rawRespHandler{}
+		}
+	}
+
+	// Serve the certificate from the files, reloading it as they change.
+	getCertificate := cfg.GetCertificate
+	var certs *CertStore
+	var certVersion certStamp
+	if cfg.CertFile != "" {
+		var err error
+		certs, certVersion, err = loadCertFiles(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		getCertificate = certs.GetCertificate
+	}
+
+	// Enable TLS with the certificates from the hook.
+	if getCertificate != nil {
+		if cfg.TLSConfig == nil {
+			cfg.TLSConfig = &tls.Config{}
+		} else {
+			cfg.TLSConfig = cfg.TLSConfig.Clone()
+		}
+		cfg.TLSConfig.GetCertificate = getCertificate
+	}
+
+	// Advertise the registered ALPN protocols if the user has not
+	// provided the list.
+	if len(cfg.Protocols) > 0 && len(cfg.TLSConfig.NextProtos) == 0 {
+		cfg.TLSConfig = cfg.TLSConfig.Clone()
+		for proto := range cfg.Protocols {
+			cfg.TLSConfig.NextProtos = append(cfg.TLSConfig.NextProtos, proto)
+		}
+		sort.Strings(cfg.TLSConfig.NextProtos)
+	}
+
+	// Keep the state of the limits in memory when no store is provided.
+	if cfg.Store == nil {
+		cfg.Store = NewMemoryStore()
+	}
+
+	// Take the addr from the listener provided.
+	if cfg.Addr == "" && cfg.Listener != nil {
+		cfg.Addr = cfg.Listener.Addr().String()
+	}
+
 	// Resolve the addr that is provided.
 	tcpAddr, err := net.ResolveTCPAddr(cfg.NetType, cfg.Addr)
 	if err != nil {
 		return nil, err
 	}
 
+	// Create the tracer if tracing is configured.
+	var tracer Tracer
+	if cfg.TracerProvider != nil {
+		tracer = cfg.TracerProvider.Tracer(tracerName)
+	}
+
 	// Create a TCP for this ipaddress and port.
+	// Validate made sure the access lists parse.
+	access, _ := parseAccessList(cfg.Allow, cfg.Deny)
+
 	t := TCP{
 		Config: cfg,
 		Name:   name,
@@ -97,9 +235,35 @@ func New(name string, cfg Config) (*TCP, error) {
 		port:      tcpAddr.Port,
 		tcpAddr:   tcpAddr,
 
-		clients: make(map[string]*client),
+		shards: newShards(cfg.Shards),
+		tracer: tracer,
+
+		certs:       certs,
+		certVersion: certVersion,
+		access:      access,
+	}
+	t.canary.percent = int32(cfg.CanaryPercent)
+
+	if cfg.BulkWrites > 0 {
+		t.bulkWrites = make(chan struct{}, cfg.BulkWrites)
 	}
 
+	if cfg.DropMode == DropBanner {
+		n := cfg.DropBanners
+		if n <= 0 {
+			n = defDropBanners
+		}
+		t.dropBanners = make(chan struct{}, n)
+	}
+
+	// Maintenance can be turned on at any time so the banners are
+	// always limited.
+	n := cfg.MaintenanceBanners
+	if n <= 0 {
+		n = defBanners
+	}
+	t.banners = make(chan struct{}, n)
+
 	return &t, nil
 }
 
@@ -120,44 +284,146 @@ func (t *TCP) Start() error {
 	}
 	t.listenerMu.Unlock()
 
-	// We need to wait for the goroutine we are about to
-	// create to initialize itself.
-	var waitStart sync.WaitGroup
-	waitStart.Add(1)
+	// Wait for the dependencies to be ready so clients never connect
+	// before the backends are available.
+	if err := t.waitDependencies(); err != nil {
+		return err
+	}
+
+	t.listenerMu.Lock()
+	{
+		// Validate no other call started the listener while we waited.
+		if t.listener != nil {
+			t.listenerMu.Unlock()
+			return errors.New("this TCP has already been started")
+		}
+
+		// Start a listener for the specified addr and port or take
+		// over the listener inherited from a previous process.
+		listener, err := t.listen()
+		if err != nil {
+			t.listenerMu.Unlock()
+			return err
+		}
+
+		t.listener = listener
+	}
+	t.listenerMu.Unlock()
+
+	atomic.StoreInt32(&t.shuttingDown, 0)
+	t.serverEvent(EvtAccept, TypInfo, join(t.ipAddress, t.port), "waiting")
+
+	// Background routines run until this channel is closed.
+	t.done = make(chan struct{})
+
+	// Measure the error rate reported by Health.
+	t.startHealthWindow()
+
+	// Start the health listener if configured.
+	if t.HealthAddr != "" {
+		if err := t.startHealth(); err != nil {
+			t.abortStart()
+			return err
+		}
+	}
+
+	// Start the admin listener if configured.
+	if t.AdminSocket != "" {
+		if err := t.startAdmin(); err != nil {
+			t.abortStart()
+			return err
+		}
+	}
+
+	// Start the pollers serving the connections if configured.
+	if err := t.startReactor(); err != nil {
+		t.abortStart()
+		return err
+	}
+
+	// Start the workers processing pipelined requests if configured.
+	if t.Pipeline > 0 {
+		t.startWorkers()
+	}
+
+	// Start the flight recorder if configured.
+	if t.RecordDir != "" {
+		t.startRecorder()
+	}
+
+	// Start watching the certificate files if configured.
+	if t.certs != nil {
+		every := t.CertCheckEvery
+		if every <= 0 {
+			every = defCertCheckEvery
+		}
+		t.runEvery(every, t.checkCertFiles)
+	}
+
+	// Start rebalancing connections if configured.
+	if t.RebalanceEvery > 0 {
+		t.runEvery(t.RebalanceEvery, t.rebalance)
+	}
+
+	// Start checking the upstreams of the proxy if configured.
+	if t.ProxyHealthEvery > 0 && len(t.Upstreams) > 0 {
+		t.runEvery(t.ProxyHealthEvery, t.checkUpstreams)
+	}
+
+	// Check the load of the process if the admission depends on it.
+	if t.loadLimited() {
+		t.runEvery(t.loadCheckEvery(), t.checkLoad)
+	}
+
+	// Start the shards joining the accepted connections if configured.
+	t.startShards()
 
 	// Start the connection accept routine.
+	atomic.StoreInt32(&t.accepting, 1)
 	t.wg.Add(1)
 	go func() {
-		var listener *net.TCPListener
+		var listener net.Listener
+		var failures int
+		var relisten bool
 
 		for {
+
+			// Wait while accepting is paused so new connections queue
+			// in the listen backlog.
+			t.waitResume()
+
+			var err error
 			t.listenerMu.Lock()
 			{
-				// Start a listener for the specified addr and port is one
-				// does not exist.
-				if t.listener == nil {
-					var err error
-					listener, err = net.ListenTCP(t.NetType, t.tcpAddr)
-					if err != nil {
-						panic(err)
+				// Start a new listener for the specified addr and port if
+				// the last one failed.
+				if relisten {
+					var l net.Listener
+					if l, err = t.listen(); err == nil {
+						t.listener, relisten = l, false
+						t.serverEvent(EvtAccept, TypInfo, join(t.ipAddress, t.port), "waiting")
 					}
-
-					t.listener = listener
-					waitStart.Done()
-
-					t.Event(EvtAccept, TypInfo, join(t.ipAddress, t.port), "waiting")
 				}
+
+				listener = t.listener
 			}
 			t.listenerMu.Unlock()
 
+			// Stop the TCP value if the listener can't be bound again.
+			// The failed listener is kept until Stop closes it again.
+			if err != nil {
+				t.serverEvent(EvtAccept, TypError, join(t.ipAddress, t.port), "listen : %v : shutting down", err)
+				t.stopAccepting()
+				break
+			}
+
 			// Listen for new connections.
 			conn, err := listener.Accept()
+			acceptedAt := t.now()
 			if err != nil {
 				shutdown := atomic.LoadInt32(&t.shuttingDown)
 
-				if shutdown == 0 {
-					t.Event(EvtAccept, TypError, conn.RemoteAddr().String(), err.Error())
-				} else {
+				if shutdown == 1 {
 					t.listenerMu.Lock()
 					{
 						t.listener = nil
@@ -166,67 +432,222 @@ func (t *TCP) Start() error {
 					break
 				}
 
+				// Pause wakes Accept with a deadline which is not an
+				// error to count, even when Resume came first.
+				if t.waitResume() || errors.Is(err, os.ErrDeadlineExceeded) {
+					continue
+				}
+
+				failures++
+				atomic.StoreInt32(&t.acceptFailures, int32(failures))
+				t.serverEvent(EvtAccept, TypError, join(t.ipAddress, t.port), "Failures[ %d ] : %v", failures, err)
+				if t.OnAcceptError != nil {
+					t.OnAcceptError(err, failures)
+				}
+
+				// Stop the TCP value if the errors persist. The call to
+				// Stop closes the listener which ends this routine.
+				if t.AcceptErrorLimit > 0 && failures >= t.AcceptErrorLimit {
+					t.serverEvent(EvtAccept, TypError, join(t.ipAddress, t.port), "accept errors persisted : shutting down")
+					go t.Stop()
+					<-t.done
+					continue
+				}
+
 				// temporary is declared to test for the existence of
 				// the method coming from the net package.
 				type temporary interface {
 					Temporary() bool
 				}
 
+				// A listener provided or inherited can't be bound again,
+				// so the TCP value stops rather than listen on another
+				// transport.
 				if e, ok := err.(temporary); ok && !e.Temporary() {
+					var provided bool
 					t.listenerMu.Lock()
 					{
-						t.listener.Close()
-						t.listener = nil
+						provided = t.provided
+						if !provided {
+							t.listener.Close()
+							relisten = true
+						}
 					}
 					t.listenerMu.Unlock()
 
-					// Don't want to add a flag. So setting this back to
-					// 1 so when the listener is re-established, the call
-					// to Done does not fail.
-					waitStart.Add(1)
+					if provided {
+						t.serverEvent(EvtAccept, TypError, join(t.ipAddress, t.port), "listener provided failed : shutting down")
+						t.stopAccepting()
+						break
+					}
+				}
+
+				// Back off before accepting again so errors like running
+				// out of file descriptors don't spin this routine.
+				backoff := t.clock().NewTimer(t.acceptBackoff(failures))
+				select {
+				case <-backoff.C():
+				case <-t.done:
+					backoff.Stop()
 				}
 
 				continue
 			}
 
+			failures = 0
+			atomic.StoreInt32(&t.acceptFailures, 0)
+
+			// Hold a connection accepted as the pause started until
+			// accepting resumes.
+			if t.waitResume() && atomic.LoadInt32(&t.shuttingDown) == 1 {
+				conn.Close()
+				continue
+			}
+
+			// Check if the filter rejects the connection before anything
+			// is allocated for it.
+			if t.AcceptFilter != nil {
+				if err := t.AcceptFilter(conn); err != nil {
+					t.Event(EvtAccept, TypInfo, conn.RemoteAddr().String(), "filtered : %v", err)
+					conn.Close()
+					continue
+				}
+			}
+
+			// Check if the access lists reject the client.
+			if !t.permitted(conn) {
+				t.Event(EvtAccept, TypInfo, conn.RemoteAddr().String(), "access denied")
+				conn.Close()
+				continue
+			}
+
+			// Check if the client is banned for its strikes.
+			if t.bannedConn(conn) {
+				atomic.AddInt64(&t.metrics.banRejects, 1)
+				t.Event(EvtBan, TypInfo, conn.RemoteAddr().String(), "rejecting : banned")
+				conn.Close()
+				continue
+			}
+
 			// Check if we are being asked to drop all new connections.
 			if drop := atomic.LoadInt32(&t.dropConns); drop == 1 {
 				t.Event(EvtAccept, TypInfo, "", "dropping new connection")
+				t.reject(conn, acceptedAt)
+				continue
+			}
+
+			// Check if we are in maintenance and only answer with the banner.
+			if m := atomic.LoadInt32(&t.maintenance); m == 1 {
+				t.Event(EvtAccept, TypInfo, conn.RemoteAddr().String(), "maintenance banner")
+				t.startBanner(conn, acceptedAt, t.MaintenanceMsg)
+				continue
+			}
+
+			// Check if the breaker was tripped by the handlers.
+			if reason, open := t.Breaker(); open {
+				t.Event(EvtBreaker, TypInfo, conn.RemoteAddr().String(), "rejecting : %s", reason)
+				if t.BreakerMsg == nil {
+					conn.Close()
+					continue
+				}
+				t.startBanner(conn, acceptedAt, t.BreakerMsg(reason))
+				continue
+			}
+
+			// Check if the process is too loaded to take the connection.
+			if reason, ok := t.admit(); !ok {
+				atomic.AddInt64(&t.metrics.loadRejects, 1)
+				t.Event(EvtLoad, TypInfo, conn.RemoteAddr().String(), "rejecting : %s", reason)
 				conn.Close()
 				continue
 			}
 
 			// Check if rate limit is enabled.
-			if t.RateLimit != nil {
-				now := time.Now().UTC()
+			if rateLimit := t.rateLimit(); rateLimit != nil {
 
 				// We will only accept 1 connection per duration. Anything
 				// connection above that must be dropped.
-				if t.lastAcceptedConnection.Add(t.RateLimit()).After(now) {
-					t.Event(EvtAccept, TypError, conn.RemoteAddr().String(), "rate limit drop : Local[ %v ] Limit[ %v ]", conn.LocalAddr(), t.RateLimit())
-					conn.Close()
+				if !t.holdRate(rateLimit()) {
+					t.Event(EvtAccept, TypError, conn.RemoteAddr().String(), "%v : Local[ %v ] Limit[ %v ]", ErrRateLimited, conn.LocalAddr(), rateLimit())
+					t.reject(conn, acceptedAt)
 					continue
 				}
+			}
 
-				// Since we accepted connection, mark the time.
-				t.lastAcceptedConnection = now
+			// Check if the connections served are at the limit.
+			if max, full := t.atCapacity(); full {
+				t.Event(EvtAccept, TypError, conn.RemoteAddr().String(), "max connections : Max[ %d ]", max)
+				t.reject(conn, acceptedAt)
+				continue
 			}
 
 			// Add this new connection to the manager map.
-			t.join(conn)
+			t.enqueue(conn, acceptedAt)
 		}
 
 		// Shutting down the routine.
+		t.stopShards()
+		atomic.StoreInt32(&t.accepting, 0)
 		t.wg.Done()
-		t.Event(EvtAccept, TypError, join(t.ipAddress, t.port), "shutdown")
+		t.serverEvent(EvtAccept, TypError, join(t.ipAddress, t.port), "shutdown")
 	}()
 
-	// Wait for the goroutine to initialize itself.
-	waitStart.Wait()
+	// Tell systemd the service accepts connections.
+	t.notify("READY=1")
 
 	return nil
 }
 
+// stopAccepting stops the TCP value from the accept routine, which must
+// return once it's done since Stop waits for it. The listener is cleared
+// as it is when the routine sees the shutdown.
+func (t *TCP) stopAccepting() {
+	go t.Stop()
+	<-t.done
+
+	t.listenerMu.Lock()
+	{
+		t.listener.Close()
+		t.listener = nil
+	}
+	t.listenerMu.Unlock()
+}
+
+// abortStart stops the routines and the listener started by a Start that
+// failed.
+func (t *TCP) abortStart() {
+	close(t.done)
+	t.wg.Wait()
+
+	t.listenerMu.Lock()
+	{
+		t.listener.Close()
+		t.listener = nil
+	}
+	t.listenerMu.Unlock()
+}
+
+// runEvery calls the function on its own goroutine every time the
+// duration elapses until the TCP value is stopped.
+func (t *TCP) runEvery(d time.Duration, fn func()) {
+	t.wg.Add(1)
+	go func() {
+		ticker := t.clock().NewTicker(d)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C():
+				fn()
+
+			case <-t.done:
+				t.wg.Done()
+				return
+			}
+		}
+	}()
+}
+
 // Stop shuts down the manager and closes all connections.
 func (t *TCP) Stop() error {
 	t.listenerMu.Lock()
@@ -234,44 +655,51 @@ func (t *TCP) Stop() error {
 		// If the listener has been stopped already, return an error.
 		if t.listener == nil {
 			t.listenerMu.Unlock()
-			return errors.New("this TCP has already been stopped")
+			return fmt.Errorf("this TCP has already been stopped : %w", ErrShutdown)
 		}
 	}
 	t.listenerMu.Unlock()
 
-	// Mark that we are shutting down.
-	atomic.StoreInt32(&t.shuttingDown, 1)
+	// Mark that we are shutting down. Only one caller can do this.
+	if !atomic.CompareAndSwapInt32(&t.shuttingDown, 0, 1) {
+		return fmt.Errorf("this TCP has already been stopped : %w", ErrShutdown)
+	}
+
+	t.notify("STOPPING=1")
+
+	// Signal the background routines to terminate.
+	close(t.done)
+	t.stopBreaker()
 
 	// Don't accept anymore client connections.
 	t.listenerMu.Lock()
 	{
-		t.listener.Close()
+		if t.listener != nil {
+			t.listener.Close()
+		}
 	}
 	t.listenerMu.Unlock()
 
 	// Make a copy of all the connections. We need to do this
 	// since we have to lock the map to read it. Dropping a
 	// connection requires locks as well.
-	var clients map[string]*client
-	t.clientsMu.Lock()
-	{
-		clients = make(map[string]*client)
-		for k, v := range t.clients {
-			clients[k] = v
-		}
-	}
-	t.clientsMu.Unlock()
+	clients := t.clientList()
 
 	// Drop all the existing connections.
 	for _, c := range clients {
 
 		// This waits for each routine to terminate.
-		c.drop()
+		c.drop(CloseShutdown)
 	}
 
 	// Wait for the accept routine to terminate.
 	t.wg.Wait()
 
+	// Stop the pollers once no connection is parked on them.
+	if t.reactor != nil {
+		t.reactor.stop()
+	}
+
 	return nil
 }
 
@@ -279,22 +707,33 @@ func (t *TCP) Stop() error {
 func (t *TCP) Drop(tcpAddr *net.TCPAddr) error {
 
 	// Find the client connection for this IPAddress.
+	c, err := t.client(tcpAddr)
+	if err != nil {
+		return err
+	}
+
+	// Drop the connection using a goroutine since we are on the
+	// socket goroutine most likely.
+	go c.drop(CloseDropped)
+	return nil
+}
+
+// client finds the client connection for this IPAddress.
+func (t *TCP) client(tcpAddr *net.TCPAddr) (*client, error) {
 	var c *client
-	t.clientsMu.Lock()
+	sh := t.shardOf(tcpAddr.String())
+	sh.mu.Lock()
 	{
 		// Validate this ipaddress and socket exists first.
 		var ok bool
-		if c, ok = t.clients[tcpAddr.String()]; !ok {
-			t.clientsMu.Unlock()
-			return fmt.Errorf("IP[ %s ] : disconnected", tcpAddr.String())
+		if c, ok = sh.clients[tcpAddr.String()]; !ok {
+			sh.mu.Unlock()
+			return nil, fmt.Errorf("IP[ %s ] : %w", tcpAddr.String(), ErrDisconnected)
 		}
 	}
-	t.clientsMu.Unlock()
+	sh.mu.Unlock()
 
-	// Drop the connection using a goroutine since we are on the
-	// socket goroutine most likely.
-	go c.drop()
-	return nil
+	return c, nil
 }
 
 // Send will deliver the response back to the client.
@@ -302,42 +741,79 @@ func (t *TCP) Send(ctx context.Context, r *Response) error {
 
 	// Find the client connection for this IPAddress.
 	var c *client
-	t.clientsMu.Lock()
+	sh := t.shardOf(r.TCPAddr.String())
+	sh.mu.Lock()
 	{
 		// Validate this ipaddress and socket exists first.
 		var ok bool
-		if c, ok = t.clients[r.TCPAddr.String()]; !ok {
-			t.clientsMu.Unlock()
-			return fmt.Errorf("IP[ %s ] : disconnected", r.TCPAddr.String())
+		if c, ok = sh.clients[r.TCPAddr.String()]; !ok {
+			sh.mu.Unlock()
+			if atomic.LoadInt32(&t.shuttingDown) == 1 {
+				return fmt.Errorf("IP[ %s ] : %w", r.TCPAddr.String(), ErrShutdown)
+			}
+			return fmt.Errorf("IP[ %s ] : %w", r.TCPAddr.String(), ErrDisconnected)
 		}
 
 		// Increment the number of writes.
-		c.nWrites++
+		atomic.AddInt64(&c.nWrites, 1)
 	}
-	t.clientsMu.Unlock()
+	sh.mu.Unlock()
+
+	// Trace the write as part of the request in the context.
+	_, span := t.startSpan(ctx, "tcp.write")
 
 	// Send the response.
-	return t.RespHandler.Write(r, c.writer)
+	// Held responses are recorded once they are written.
+	held, err := c.send(ctx, r)
+	if !held {
+		recordResponse(ctx, r.Length, err)
+	}
+
+	// The pool owns the buffer once the response is written.
+	if t.PoolBuffers && !held {
+		defer r.Release()
+	}
+
+	if span != nil {
+		span.SetAttribute("tcp.write.bytes", r.Length)
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.End()
+	}
+
+	return err
 }
 
 // SendAll will deliver the response back to all connected clients.
 func (t *TCP) SendAll(ctx context.Context, r *Response) error {
 	var clts []*client
-	t.clientsMu.Lock()
-	{
-		for _, c := range t.clients {
-			clts = append(clts, c)
-			c.nWrites++
+	for _, sh := range t.shards {
+		sh.mu.Lock()
+		{
+			for _, c := range sh.clients {
+				clts = append(clts, c)
+				atomic.AddInt64(&c.nWrites, 1)
+			}
 		}
+		sh.mu.Unlock()
 	}
-	t.clientsMu.Unlock()
 
 	// TODO: Consider doing this in parallel.
 	var errors CltError
 	for _, c := range clts {
-		if err := t.RespHandler.Write(r, c.writer); err != nil {
+		if err := c.replyTurn(); err != nil {
 			errors = append(errors, err)
+			continue
 		}
+		if err := c.write(r); err != nil {
+			errors = append(errors, err)
+		}
+	}
+
+	// The pool owns the buffer once the response is written.
+	if t.PoolBuffers {
+		r.Release()
 	}
 
 	if errors != nil {
@@ -347,7 +823,7 @@ func (t *TCP) SendAll(ctx context.Context, r *Response) error {
 }
 
 // DropConnections sets a flag to tell the accept routine to immediately
-// drop connections that come in.
+// drop connections that come in, the way DropMode asks.
 func (t *TCP) DropConnections(drop bool) {
 	if drop {
 		atomic.StoreInt32(&t.dropConns, 1)
@@ -371,128 +847,133 @@ func (t *TCP) Addr() net.Addr {
 
 // Connections returns the number of client connections.
 func (t *TCP) Connections() int {
-	var l int
-
-	t.clientsMu.Lock()
-	{
-		l = len(t.clients)
-	}
-	t.clientsMu.Unlock()
-
-	return l
+	return t.ActiveConnections()
 }
 
 // Stat represents a client statistic.
 type Stat struct {
-	IP       string
-	Reads    int
-	Writes   int
-	TimeConn time.Time
-	LastAct  time.Time
+	IP           string
+	Tags         []string
+	Reads        int
+	Writes       int
+	BytesRead    int64
+	BytesWritten int64
+	TimeConn     time.Time
+	LastAct      time.Time
+	Proxy        string // ProxySplice or ProxyCopy for a proxied connection.
+	Priority     Priority
+	Buffered     int64                // Bytes of the requests and responses buffered.
+	Identity     string               // Set by VerifyPeer or the AuthHandler.
+	Requests     int64                // Requests processed.
+	Congestion   Congestion           // Responses waiting to be written and the stalled writes.
+	TLS          *tls.ConnectionState // Nil for a connection not using TLS.
 }
 
 // ClientStats return details for all active clients.
 func (t *TCP) ClientStats() []Stat {
-	var clts []*client
-	t.clientsMu.Lock()
-	{
-		for _, v := range t.clients {
-			clts = append(clts, v)
-		}
-	}
-	t.clientsMu.Unlock()
+	clts := t.clientList()
 
 	stats := make([]Stat, len(clts))
 	for i, c := range clts {
-		stats[i] = Stat{
-			IP:       c.ipAddress,
-			Reads:    c.nReads,
-			Writes:   c.nWrites,
-			TimeConn: c.timeConn,
-			LastAct:  c.lastAct,
-		}
+		stats[i] = c.stat()
 	}
 
 	return stats
 }
 
-// Clients returns the number of active clients connected.
-func (t *TCP) Clients() int {
-	var count int
-	t.clientsMu.Lock()
-	{
-		count = len(t.clients)
+// ClientStat returns the details of the client connection.
+func (t *TCP) ClientStat(tcpAddr *net.TCPAddr) (Stat, error) {
+	c, err := t.client(tcpAddr)
+	if err != nil {
+		return Stat{}, err
 	}
-	t.clientsMu.Unlock()
 
-	return count
+	return c.stat(), nil
+}
+
+// Clients returns the number of active clients connected.
+func (t *TCP) Clients() int {
+	return t.ActiveConnections()
 }
 
 // Groom drops connections that are not active for the specified duration.
 func (t *TCP) Groom(d time.Duration) {
-	var clts []*client
-	t.clientsMu.Lock()
-	{
-		for _, v := range t.clients {
-			clts = append(clts, v)
-		}
-	}
-	t.clientsMu.Unlock()
+	clts := t.clientList()
 
-	now := time.Now().UTC()
+	now := t.now()
 	for _, c := range clts {
-		sub := now.Sub(c.lastAct)
+		lastAct := c.lastActivity()
+		sub := now.Sub(lastAct)
 		if sub >= d {
 
 			// TODO
 			// This is a blocking call that waits for the socket goroutine
 			// to report its done. This parallel call should work well since
 			// there is no error handling needed.
-			t.Event(EvtGroom, TypInfo, c.ipAddress, "Last[ %v ] Dur[ %v ]", c.lastAct.Format(time.RFC3339), sub)
-			go c.drop()
+			t.Event(EvtGroom, TypInfo, c.ipAddress, "Last[ %v ] Dur[ %v ]", lastAct.Format(time.RFC3339), sub)
+			go c.drop(CloseIdle)
 		}
 	}
 }
 
 // join takes a new connection and adds it to the manager.
-func (t *TCP) join(conn net.Conn) {
+func (t *TCP) join(conn net.Conn, acceptedAt time.Time) {
 	ipAddress := conn.RemoteAddr().String()
 	t.Event(EvtJoin, TypTrigger, ipAddress, "new connection")
 
-	t.clientsMu.Lock()
+	sh := t.shardOf(ipAddress)
+	sh.mu.Lock()
 	{
 		// Validate this has not been joined already.
-		if _, ok := t.clients[ipAddress]; ok {
+		if _, ok := sh.clients[ipAddress]; ok {
 			t.Event(EvtJoin, TypError, ipAddress, "already connected")
 			conn.Close()
 
-			t.clientsMu.Unlock()
+			sh.mu.Unlock()
 			return
 		}
 
+		// Stop drops the connections in the map once done is closed,
+		// so a connection joining after that is not served.
+		select {
+		case <-t.done:
+			conn.Close()
+
+			sh.mu.Unlock()
+			return
+		default:
+		}
+
 		// Add the client connection to the map.
-		t.clients[ipAddress] = newClient(t, conn)
+		sh.clients[ipAddress] = newClient(t, conn, acceptedAt)
+		atomic.AddInt64(&t.active, 1)
 	}
-	t.clientsMu.Unlock()
+	sh.mu.Unlock()
+
+	atomic.AddInt64(&sh.stats.joined, 1)
 }
 
 // remove deletes a connection from the manager.
 func (t *TCP) remove(conn net.Conn) {
 	ipAddress := conn.RemoteAddr().String()
 
-	t.clientsMu.Lock()
+	sh := t.shardOf(ipAddress)
+	sh.mu.Lock()
 	{
 		// Validate this has not been removed already.
-		if _, ok := t.clients[ipAddress]; !ok {
+		if _, ok := sh.clients[ipAddress]; !ok {
 			t.Event(EvtRemove, TypError, ipAddress, "already removed")
-			t.clientsMu.Unlock()
+			sh.mu.Unlock()
 			return
 		}
 
 		// Remove the client connection from the map.
-		delete(t.clients, ipAddress)
+		delete(sh.clients, ipAddress)
+		atomic.AddInt64(&t.active, -1)
 	}
-	t.clientsMu.Unlock()
+	sh.mu.Unlock()
+
+	atomic.AddInt64(&sh.stats.removed, 1)
 
 	// Close the connection for safe keeping.
 	conn.Close()
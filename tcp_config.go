@@ -1,6 +1,15 @@
 package tcp
 
-import "time"
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
 
 // OptRateLimit declares fields for the user to provide configuration
 // for connection rate limit.
@@ -8,6 +17,544 @@ type OptRateLimit struct {
 	RateLimit func() time.Duration // Connection rate limit per single connection.
 }
 
+// OptInherit declares fields for the user to provide a listener inherited
+// from a previous process for zero-downtime restarts, and to integrate with
+// the units of systemd.
+type OptInherit struct {
+	ListenerFile     *os.File // Inherited listener, such as os.NewFile(3, "listener"). It is closed once taken over.
+	SocketActivation bool     // Use the listener passed by systemd through LISTEN_FDS.
+	SystemdNotify    bool     // Notify systemd with READY=1 once started and STOPPING=1 as it stops.
+}
+
+// OptRecorder declares fields for the user to enable a flight recorder that
+// periodically writes snapshots of the internal state to a ring of files.
+type OptRecorder struct {
+	RecordDir     string                              // Directory for the snapshot files, empty to disable.
+	RecordFiles   int                                 // Number of files in the ring, defaults to 10.
+	RecordEvery   time.Duration                       // Time between snapshots, defaults to 10 seconds.
+	RecordEncoder func(w io.Writer, s Snapshot) error // Serialization of a snapshot, defaults to EncodeSnapshot.
+}
+
+// OptTLS declares fields for the user to serve connections over TLS.
+type OptTLS struct {
+	TLSConfig        *tls.Config                                                       // Enables TLS for all connections.
+	HandshakeTimeout time.Duration                                                     // Time allowed for the handshake, defaults to 10 seconds.
+	VerifyPeer       func(ipAddress string, state tls.ConnectionState) (string, error) // Rejects a peer or returns its identity.
+	Protocols        map[string]HandlerSet                                             // Handlers selected by the negotiated ALPN protocol.
+	GetCertificate   func(hello *tls.ClientHelloInfo) (*tls.Certificate, error)        // Selects the certificate by SNI, such as CertStore.GetCertificate.
+	CertFile         string                                                            // PEM certificate reloaded when the file changes, such as on renewal.
+	KeyFile          string                                                            // PEM key of the CertFile.
+	CertCheckEvery   time.Duration                                                     // Time between checks of the files for changes, defaults to 1 minute.
+}
+
+// OptMaintenance declares fields for the user to provide the message new
+// connections receive while the TCP value is in maintenance.
+type OptMaintenance struct {
+	MaintenanceMsg     []byte // Written through the RespHandler before the connection is closed.
+	MaintenanceBanners int    // Banners written at once, defaults to 64. The connections past it are closed.
+}
+
+// OptRebalance declares fields for the user to periodically ask the
+// longest-lived connections to reconnect so load rebalances across a fleet.
+type OptRebalance struct {
+	RebalanceEvery time.Duration // Time between rounds, zero to disable.
+	RebalanceConns int           // Number of connections asked to reconnect each round, defaults to 1.
+	RebalanceGrace time.Duration // Time a client has to close the connection, defaults to 30 seconds.
+	GoAwayMsg      []byte        // Written through the RespHandler before the write side is closed.
+}
+
+// OptAcceptRetry declares fields for the user to configure how the accept
+// routine handles errors like running out of file descriptors.
+type OptAcceptRetry struct {
+	AcceptBackoffMin time.Duration                 // Wait after the first error, defaults to 5ms.
+	AcceptBackoffMax time.Duration                 // Longest wait between retries, defaults to 1s.
+	AcceptErrorLimit int                           // Consecutive errors before the TCP value stops itself, zero for no limit.
+	OnAcceptError    func(err error, failures int) // Called with every accept error.
+}
+
+// OptHealth declares fields for the user to enable a lightweight health
+// listener suitable for load balancer checks.
+type OptHealth struct {
+	HealthAddr         string        // "host:port" for the HTTP health listener, empty to disable.
+	HealthMaxErrorRate float64       // Error rate above which the TCP value reports unhealthy, zero to ignore.
+	HealthWindow       time.Duration // Window the error rate is measured over, defaults to 10 seconds.
+}
+
+// OptPrivacy declares fields for the user to anonymize client addresses
+// in events, spans, the Tap and recorded snapshots. The address is also
+// replaced in the messages of the events. Full addresses remain available
+// to the handlers and admission control. The addresses of the server, such
+// as its listener, are reported as they are.
+type OptPrivacy struct {
+	Anonymize func(ipAddress string) string // Such as AnonymizeTruncate or AnonymizeHash.
+}
+
+// OptTracing declares fields for the user to trace every request. A span
+// covers each request with child spans for the writes made through Send.
+type OptTracing struct {
+	TracerProvider TracerProvider
+}
+
+// Dependency is a backend that must be ready before connections are
+// accepted, such as a database that must answer a ping.
+type Dependency struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// OptReadiness declares fields for the user to register the dependencies
+// Start waits for before opening the listener.
+type OptReadiness struct {
+	Dependencies     []Dependency
+	ReadinessTimeout time.Duration // Time Start waits for the dependencies, defaults to 30 seconds.
+}
+
+// OptBreaker declares fields for the user to provide the message new
+// connections receive while the breaker is tripped.
+type OptBreaker struct {
+	BreakerMsg func(reason string) []byte // Written through the RespHandler, nil to close without a message. Limited like the maintenance banners.
+}
+
+// OptClock declares fields for the user to provide the clock, such as the
+// one in the tcptest package for tests.
+type OptClock struct {
+	Clock Clock // Defaults to the system clock.
+}
+
+// OptProfile declares fields for the user to enable the profiler that
+// reports the time spent in each phase of the request workflow.
+type OptProfile struct {
+	Profiling bool
+}
+
+// OptListen declares fields for the user to provide the listener, such as
+// the in-memory listener in the tcptest package or one wrapping another
+// transport. The connections accepted must report a unique *net.TCPAddr
+// as their remote address. A pre-built Listener is served by the first
+// Start only, like an inherited listener, and Addr defaults to its address.
+// The TCP value stops if either fails for good, rather than bind a new one.
+// The backlog is applied where the platform allows to the listeners
+// created by net.ListenTCP, not to inherited or provided listeners.
+type OptListen struct {
+	Listener net.Listener                                     // Served instead of creating a listener on the first Start.
+	Listen   func(netType, addr string) (net.Listener, error) // Defaults to net.ListenTCP.
+	Backlog  int                                              // Depth of the accept queue, defaults to the system's maximum.
+}
+
+// OptReadAhead declares fields for the user to read ahead on streaming
+// connections, so the socket is read while the current message is being
+// processed.
+type OptReadAhead struct {
+	ReadAhead int                      // Bytes kept pre-read from the socket, 0 disables.
+	Streaming func(conn net.Conn) bool // Selects the connections to read ahead on, defaults to all.
+}
+
+// OptPipeline declares fields for the user to process the requests read
+// from a connection concurrently on a pool of workers. Responses sent with
+// the request context are written in the order the requests were read.
+// Changes to the connection, such as StartTLS, are not safe to make while
+// other requests are in flight.
+type OptPipeline struct {
+	Pipeline int // Requests processed at once per connection, 0 processes them one at a time.
+	Workers  int // Size of the pool shared by the connections, defaults to 4 times GOMAXPROCS.
+}
+
+// OptBuffers declares fields for the user to hand the ownership of the
+// request and response data to the TCP value. Request data must come from
+// GetBuffer and is returned to the pool once Process returns, so handlers
+// must copy any data they keep. Response data sent with Send or SendAll
+// must come from GetBuffer and is returned to the pool once written.
+type OptBuffers struct {
+	PoolBuffers bool
+}
+
+// OptBufferSize declares fields for the user to size the buffered reader
+// and writer of each connection. Without a ConnHandler, connections are
+// bound by a BufferedConnHandler using these sizes.
+type OptBufferSize struct {
+	ReadBufferSize  int
+	WriteBufferSize int
+}
+
+// OptCongestion declares fields for the user to tune when a client is
+// reported as congested through CongestionFrom.
+type OptCongestion struct {
+	WriteStall  time.Duration // Time a write takes to count as a stall, defaults to 50ms.
+	StallWindow time.Duration // Time a stall counts toward congestion, defaults to 10 seconds.
+}
+
+// OptAcceptFilter declares fields for the user to reject connections as
+// soon as they are accepted, before the handlers are involved.
+type OptAcceptFilter struct {
+	AcceptFilter func(conn net.Conn) error // Returning an error drops the connection.
+}
+
+// OptCanary declares fields for the user to roll out new handlers on the
+// same listener. The percentage of new connections is assigned to the
+// Canary handlers, where nil handlers are taken from the configuration.
+type OptCanary struct {
+	Canary        *HandlerSet
+	CanaryPercent int // Percentage of new connections, from 0 to 100.
+}
+
+// OptTags declares fields for the user to tag connections as they are
+// accepted, such as with the tenant the address belongs to. Tags are added
+// to the ones from a ConnHandler implementing Tagger and reported through
+// TagStats.
+type OptTags struct {
+	Tags func(conn net.Conn) []string
+}
+
+// OptWatermark declares fields for the user to be told when the bytes
+// waiting to be written to a client cross the thresholds, so publishers
+// can throttle or conflate until the client catches up. The callbacks run
+// on the goroutine writing the response and must not block.
+type OptWatermark struct {
+	HighWatermark   int                                    // Bytes queued to call OnHighWatermark, 0 disables.
+	LowWatermark    int                                    // Bytes queued to call OnLowWatermark, defaults to half the high watermark.
+	OnHighWatermark func(tcpAddr *net.TCPAddr, queued int) // Called once when the queue grows to the high watermark.
+	OnLowWatermark  func(tcpAddr *net.TCPAddr, queued int) // Called once when the queue drains to the low watermark.
+}
+
+// OptWriteTimeout declares fields for the user to bound the time a
+// response takes to write and decide what happens when a write fails.
+// Writes that fail after part of the response reached the connection leave
+// the client with a partial message, which WriteError reports. A buffered
+// writer keeps failing once a write to it failed, so retries need a
+// RespHandler that writes straight to the connection.
+type OptWriteTimeout struct {
+	WriteTimeout time.Duration                                  // Time allowed for each response, 0 disables.
+	OnWriteError func(r *Response, err *WriteError) WriteAction // Defaults to WriteDrop.
+}
+
+// OptHalfDuplex declares fields for the user to enforce strict turns on
+// every connection: a request is read, one response is written and only
+// then is the next request read. Responses written out of turn are refused
+// and a client sending a request before its response closes the
+// connection. Pipelining can't be used with half duplex.
+type OptHalfDuplex struct {
+	HalfDuplex      bool
+	OnTurnViolation func(tcpAddr *net.TCPAddr, err error) // Called with the ErrTurn error for each violation.
+}
+
+// OptSummary declares fields for the user to receive a summary of every
+// connection once it's closed, such as for billing. OnClose is called on
+// the connection's goroutine.
+type OptSummary struct {
+	OnClose func(s ConnSummary)
+}
+
+// OptVirtual declares fields for the user to host several logical services
+// on the listener, selected by the TLS SNI or the first bytes the client
+// sends. Handlers left nil in a VirtualServer are taken from the
+// configuration.
+type OptVirtual struct {
+	Virtual         []VirtualServer
+	VirtualFallback *HandlerSet   // Serves connections no virtual server matches, defaults to the configuration.
+	VirtualTimeout  time.Duration // Time allowed for the first bytes to arrive, defaults to 5 seconds.
+}
+
+// OptAccessLog declares fields for the user to record every request once
+// it's processed, such as its remote address, bytes and outcome. Only the
+// responses sent with the request context are attributed to the request.
+type OptAccessLog struct {
+	AccessLog       io.Writer                              // Receives the entries, nil disables the access log.
+	AccessLogFormat func(w io.Writer, e AccessEntry) error // Defaults to AccessLogText.
+}
+
+// OptErrors declares fields for the user to map the errors of their
+// handlers to the error classes of the package, such as ErrFrameTooLarge
+// for a frame exceeding the limit of their protocol.
+type OptErrors struct {
+	ClassifyError func(err error) error // Returns the class of the error or nil.
+}
+
+// OptAdmission declares fields for the user to limit the connections
+// accepted by count and by the address of the client. UpdateConfig
+// changes them while the TCP value runs.
+type OptAdmission struct {
+	MaxConns int      // Connections served at once, zero for no limit.
+	Allow    []string // IPs or CIDRs accepted, empty to accept all.
+	Deny     []string // IPs or CIDRs rejected, even when allowed.
+}
+
+// OptTap declares fields for the user to receive copies of the bytes read
+// from and written to the connections, such as to record protocol traces
+// without changing the handlers. The bytes are above the TLS layer of the
+// connections accepted with TLS. Tap is called on the goroutine reading or
+// writing and must not block. The address is anonymized with OptPrivacy.
+type OptTap struct {
+	Tap         func(ipAddress string, dir TapDir, data []byte) // Receives a copy it may keep.
+	TapRate     float64                                         // Fraction of the connections tapped, defaults to all.
+	TapMaxBytes int64                                           // Bytes tapped per connection in each direction, 0 for no limit.
+}
+
+// OptModel declares fields for the user to choose how the connections are
+// served. The reactor model parks the connections with nothing to read on
+// a few poller goroutines instead of a goroutine each, for servers holding
+// a very large number of idle connections. A poller serves the connection
+// it finds readable itself, so handlers must not block for long. In the
+// goroutine model, IdlePark parks the connections idle for that long and
+// gives them a goroutine again when a request arrives. The connections
+// using TLS or read ahead are always served by a goroutine. Parking uses
+// epoll on Linux and kqueue on the BSDs and macOS.
+type OptModel struct {
+	Model             Model         // Defaults to ModelGoroutine.
+	Pollers           int           // Poller goroutines, defaults to GOMAXPROCS.
+	PollerReadTimeout time.Duration // Time a poller waits for the rest of a message, defaults to 5 seconds.
+	IdlePark          time.Duration // Time a connection is idle before it's parked in the goroutine model, zero to never park.
+}
+
+// OptShards declares fields for the user to spread the connections over
+// shards, each with its own lock, to reduce contention under high churn.
+// With ShardAccept, the accept routine only queues the connections it
+// accepts and the goroutine of each shard joins them.
+type OptShards struct {
+	Shards      int  // Shards of the connections, defaults to GOMAXPROCS.
+	ShardAccept bool // Join the accepted connections on the goroutine of their shard.
+	ShardQueue  int  // Accepted connections queued per shard, defaults to 128.
+}
+
+// OptProxy declares fields for the user to forward each connection to an
+// upstream instead of serving it with the handlers, such as to put the
+// admission, rate limiting, TLS and stats of the package in front of
+// another server. The bytes are piped both ways until either side closes.
+// PickUpstream replaces the Upstreams and is asked again on each retry.
+// The Upstreams are taken out of the rotation when an active check fails,
+// or when ProxyMaxFails dials failed in a row, and put back once they're
+// dialed successfully.
+type OptProxy struct {
+	Upstreams        []string                                                          // Addresses of the upstreams.
+	ProxyBalance     Balance                                                           // Defaults to BalanceFailover.
+	ProxyHashKey     func(ipAddress string) string                                     // Key of the client for BalanceHash, defaults to its IP.
+	PickUpstream     func(ipAddress string, attempt int) string                        // Returns the upstream to dial for the client.
+	ProxyDial        func(ctx context.Context, network, addr string) (net.Conn, error) // Defaults to a net.Dialer.
+	ProxyDialTimeout time.Duration                                                     // Time allowed to dial, defaults to 5 seconds.
+	ProxyRetries     int                                                               // Upstreams dialed after a failed dial.
+	ProxyHealthEvery time.Duration                                                     // Time between active checks of the Upstreams, zero for none.
+	ProxyProbe       func(conn net.Conn) error                                         // Exchange of an active check after the dial, such as a ping.
+	ProxyMaxFails    int                                                               // Dials failed in a row taking an upstream out, zero to never.
+	ProxyDownTime    time.Duration                                                     // Time an upstream is out without active checks, defaults to 30 seconds.
+}
+
+// OptConnect declares fields for the user to answer the HTTP CONNECT
+// request each connection starts with and tunnel it to the host requested,
+// dialed with the ProxyDial, for clients that can only leave their network
+// through an HTTP proxy. ConnectAllow is required so the listener is never
+// an open proxy by mistake.
+type OptConnect struct {
+	Connect        bool
+	ConnectAllow   func(addr string) bool // Reports whether the host:port requested can be tunneled to.
+	ConnectTimeout time.Duration          // Time allowed for the CONNECT request to arrive, defaults to 10 seconds.
+}
+
+// OptStream declares fields for the user to serve each connection with a
+// StreamHandler instead of the request/response cycle. The ReqHandler and
+// RespHandler are optional and frame the messages of the Stream.
+type OptStream struct {
+	StreamHandler StreamHandler
+}
+
+// OptHalfClose declares fields for the user to keep the connections the
+// clients half closed open for the responses. Without HalfOpen, reading
+// the end of the stream closes the connection once the requests read are
+// answered.
+type OptHalfClose struct {
+	HalfOpen        bool                       // Keep writing once the client closed its write side.
+	HalfOpenTimeout time.Duration              // Time the connection stays open, defaults to 30 seconds.
+	OnHalfClose     func(tcpAddr *net.TCPAddr) // Called when the client closed its write side.
+}
+
+// OptKeepAlive declares fields for the user to tune the TCP keepalive
+// probes of the connections accepted, which are enabled by default. A
+// client that stops answering them is closed with CloseKeepAlive. Zero
+// values keep the defaults of the net package.
+type OptKeepAlive struct {
+	NoKeepAlive       bool          // Disables the keepalive probes.
+	KeepAliveIdle     time.Duration // Time idle before the first probe.
+	KeepAliveInterval time.Duration // Time between the probes.
+	KeepAliveCount    int           // Probes unanswered before the client is declared gone.
+}
+
+// OptRequestTimeout declares fields for the user to bound the time each
+// request takes to process. The request context carries the deadline, so
+// the handlers can stop waiting once it passes, and Request.SetDeadline
+// moves it for a single request. Requests processed past their deadline
+// are reported as timeouts.
+type OptRequestTimeout struct {
+	RequestTimeout time.Duration // Time allowed to process each request, 0 disables.
+}
+
+// OptRequestLimit declares fields for the user to bound the size of each
+// request. A request over MaxRequestBytes, or a ReqHandler error matching
+// ErrFrameTooLarge, leaves the stream in an unknown state so the
+// connection is closed. OnFrameTooLarge can answer with the rejection of
+// the protocol first, which is written through the RespHandler.
+type OptRequestLimit struct {
+	MaxRequestBytes int                                             // Bytes allowed in each request, 0 disables.
+	OnFrameTooLarge func(tcpAddr *net.TCPAddr, err error) *Response // Returns the response to write before closing, nil closes right away.
+}
+
+// OptCompression declares fields for the user to compress the bytes of
+// the connections below the handlers, so the ReqHandler reads decompressed
+// requests and the responses of the RespHandler are compressed. Gzip and
+// deflate are provided and Compressors adds others by name, such as snappy
+// or zstd. Compress switches a single connection once the protocol
+// negotiated it. The bytes decompressed between two requests are capped at
+// MaxRequestBytes, counting what the reader of the ConnHandler buffers.
+type OptCompression struct {
+	Compression string                // Name of the compressor every connection starts with, empty for none.
+	Compressors map[string]Compressor // Compressors by name in addition to gzip and deflate.
+}
+
+// OptBatch declares fields for the user to coalesce the small writes of
+// the connections, such as the responses of a chatty protocol, so they
+// reach the socket in one call. A response waits up to the delay for more
+// to join it, and the responses written before a batch fills up are sent
+// together. Send returns once the response is batched, so errors writing
+// the batch are returned by the next Send.
+type OptBatch struct {
+	BatchDelay time.Duration // Time a write waits for others, such as 1ms, 0 disables.
+	BatchBytes int           // Bytes of a batch, defaults to 64k.
+}
+
+// OptPriority declares fields for the user to give the connections a
+// class of service through their tags, such as PriorityControl for admin
+// connections and PriorityBulk for data transfers. SetPriority changes the
+// priority of a single connection. The worker pool processes the pipelined
+// requests of the higher priorities first and BulkWrites throttles the
+// writes of the bulk connections.
+type OptPriority struct {
+	PriorityTags map[string]Priority // Priority of the connections with the tag, the highest one wins.
+	BulkWrites   int                 // Writes of bulk connections at once across all of them, 0 for no limit.
+}
+
+// OptLoad declares fields for the user to hold back new connections while
+// the process is overloaded, so the connections already served keep being
+// served well. The load is checked periodically against the thresholds
+// set and new connections are admitted again once it's back within all of
+// them. While overloaded, the accept waits up to the LoadDelay for the load
+// to recover before rejecting the connection.
+type OptLoad struct {
+	MaxGoroutines  int           // Goroutines of the process, 0 for no limit.
+	MaxHeapBytes   uint64        // Bytes of the objects on the heap, 0 for no limit.
+	MaxProcessing  int           // Requests being processed at once, 0 for no limit.
+	LoadCheckEvery time.Duration // Defaults to 1 second.
+	LoadDelay      time.Duration // Time a new connection waits for the load to recover, 0 rejects it right away.
+}
+
+// OptMemory declares fields for the user to cap the bytes buffered, the
+// requests read and not yet processed and the responses not yet written,
+// so a client that sends faster than it reads can't exhaust the memory.
+// Connections over a cap stop being read until enough is released, or are
+// closed with CloseOverMemory.
+type OptMemory struct {
+	MaxConnBytes     int  // Bytes buffered per connection, 0 for no limit.
+	MaxBufferedBytes int  // Bytes buffered by all the connections, 0 for no limit.
+	CloseOverMemory  bool // Close the connections over a cap instead of holding back their reads.
+}
+
+// OptDrop declares fields for the user to choose how new connections are
+// closed when they are dropped by DropConnections, the rate limit or
+// MaxConns. Resetting them frees the sockets right away while a banner
+// tells the clients why they are turned away.
+type OptDrop struct {
+	DropMode    DropMode // Defaults to DropFIN.
+	DropMsg     []byte   // Written through the RespHandler with DropBanner.
+	DropBanners int      // Banners written at once, defaults to 64. The connections dropped past it are closed.
+}
+
+// OptTarpit declares fields for the user to tune the tarpit the connections
+// of the IPs flagged with Tarpit are accepted into, where every read and
+// write waits for the delay and moves a few bytes, wasting the time of
+// scanners and abusive clients instead of turning them away.
+type OptTarpit struct {
+	TarpitDelay time.Duration // Delay before each read and write, defaults to 10 seconds.
+	TarpitBytes int           // Bytes moved per delay, defaults to 1.
+	TarpitFor   time.Duration // Time an IP stays flagged by default, defaults to 1 hour.
+}
+
+// OptBan declares fields for the user to ban the IPs the handlers and the
+// server report strikes against, such as for protocol errors or failed
+// authentications. An IP with BanStrikes strikes within the BanWindow is
+// banned for BanFor and its new connections are closed as they are
+// accepted.
+type OptBan struct {
+	BanStrikes int                            // Strikes within the window to ban an IP, 0 disables the strikes.
+	BanWindow  time.Duration                  // Time the strikes count, defaults to 1 minute.
+	BanFor     time.Duration                  // Time an IP stays banned, defaults to 10 minutes.
+	OnBan      func(ip net.IP, reason string) // Called when an IP is banned.
+}
+
+// OptGeo declares fields for the user to decide on the connections by the
+// location of their IP as they are accepted, such as with a GeoIP database.
+// The policy denies a connection or tags it, with the tags reported to the
+// handlers through the requests and in the stats like any other.
+type OptGeo struct {
+	GeoPolicy     func(ip net.IP) (GeoDecision, error) // Called with the IP of each connection before anything is read.
+	GeoFailClosed bool                                 // Deny the connections the policy fails on instead of allowing them.
+}
+
+// OptSniff declares fields for the user to serve several protocols on one
+// port, such as TLS and plaintext or HTTP and a custom protocol, selected
+// by the first bytes the clients send. With routes, a connection goes
+// through TLS only when its route asks for it and a connection no route
+// matches is closed. Protocols where the server speaks first can't be
+// sniffed.
+type OptSniff struct {
+	Sniff        []SniffRoute
+	SniffBytes   int           // Most bytes peeked at to match a route, defaults to 16.
+	SniffTimeout time.Duration // Time allowed for the first bytes to arrive, defaults to 5 seconds.
+}
+
+// OptMigrate declares fields for the user to register the handler sets an
+// established connection can be handed off to with Migrate, such as once
+// the protocol is negotiated or the client is authenticated. Handlers left
+// nil are taken from the configuration.
+type OptMigrate struct {
+	HandlerSets map[string]HandlerSet
+}
+
+// OptAuth declares fields for the user to authenticate the clients before
+// their requests are read.
+type OptAuth struct {
+	AuthHandler AuthHandler
+	AuthTimeout time.Duration // Time allowed for the authentication, defaults to 10 seconds.
+}
+
+// OptQuota declares fields for the user to hold the identities of the
+// connections, set by VerifyPeer or the AuthHandler, to the quotas of their
+// plans. A request over a quota isn't processed and OnQuotaExceeded can
+// answer it, a connection over the quota of connections is closed.
+type OptQuota struct {
+	Quotas          func(identity string) Quota                     // Returns the quota of the identity, called once per connection.
+	OnQuotaExceeded func(tcpAddr *net.TCPAddr, err error) *Response // Returns the response to write for the rejection, nil writes nothing.
+}
+
+// OptStore declares fields for the user to keep the state of the rate
+// limit, the bans and the quotas out of the process, so a fleet of servers
+// shares the limits.
+type OptStore struct {
+	Store Store // Defaults to the memory of the process.
+}
+
+// OptCluster declares fields for the user to publish the identities
+// connected to this server in a registry shared by the servers of a
+// cluster, so Push reaches a client connected to any of them.
+type OptCluster struct {
+	Registry Registry
+	Node     string                                                              // Name of this server in the registry, such as the address Forward reaches it at.
+	Forward  func(ctx context.Context, node, identity string, r *Response) error // Hands the response to the node, which passes it to PushLocal.
+}
+
+// OptAdmin declares fields for the user to enable the admin listener, a
+// unix socket operators send commands to, one per line, to query the stats,
+// list and kick connections, drop new connections, adjust the rate limit
+// and drain. Send help for the list of commands.
+type OptAdmin struct {
+	AdminSocket string // Path of the unix socket, empty to disable.
+}
+
 // OptEvent defines an handler used to provide events.
 type OptEvent struct {
 	Event func(evt, typ int, ipAddress string, format string, a ...interface{})
@@ -28,36 +575,404 @@ type Config struct {
 
 	OptRateLimit
 	OptEvent
+	OptInherit
+	OptRecorder
+	OptTLS
+	OptMaintenance
+	OptRebalance
+	OptAcceptRetry
+	OptHealth
+	OptPrivacy
+	OptTracing
+	OptReadiness
+	OptBreaker
+	OptClock
+	OptProfile
+	OptListen
+	OptReadAhead
+	OptPipeline
+	OptBuffers
+	OptBufferSize
+	OptCongestion
+	OptAcceptFilter
+	OptCanary
+	OptTags
+	OptWatermark
+	OptWriteTimeout
+	OptHalfDuplex
+	OptSummary
+	OptVirtual
+	OptAccessLog
+	OptErrors
+	OptAdmission
+	OptTap
+	OptModel
+	OptShards
+	OptProxy
+	OptConnect
+	OptStream
+	OptHalfClose
+	OptKeepAlive
+	OptRequestTimeout
+	OptRequestLimit
+	OptCompression
+	OptBatch
+	OptPriority
+	OptLoad
+	OptMemory
+	OptDrop
+	OptTarpit
+	OptBan
+	OptGeo
+	OptSniff
+	OptMigrate
+	OptAuth
+	OptQuota
+	OptStore
+	OptCluster
+	OptAdmin
+}
+
+// ConfigProblem is a problem Validate found with a field of the
+// configuration.
+type ConfigProblem struct {
+	Field  string // Such as "NetType" or "OptTLS.CertFile".
+	Reason string
+	Err    error // One of the ErrInvalid errors.
+}
+
+// Error implements the error interface for ConfigProblem.
+func (p ConfigProblem) Error() string {
+	return fmt.Sprintf("%s : %s : %v", p.Field, p.Reason, p.Err)
+}
+
+// Unwrap returns the ErrInvalid error of the problem.
+func (p ConfigProblem) Unwrap() error {
+	return p.Err
+}
+
+// ConfigError lists every problem Validate found with the configuration.
+// It matches the ErrInvalid error of each problem with errors.Is.
+type ConfigError []ConfigProblem
+
+// Error implements the error interface for ConfigError.
+func (ce ConfigError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v : %d problem(s)", ErrInvalidConfiguration, len(ce))
+	for _, p := range ce {
+		b.WriteString("\n\t")
+		b.WriteString(p.Error())
+	}
+	return b.String()
 }
 
-// Validate checks the configuration to required items.
+// Unwrap returns the problems so errors.Is and errors.As can match them.
+func (ce ConfigError) Unwrap() []error {
+	errs := make([]error, len(ce))
+	for i, p := range ce {
+		errs[i] = p
+	}
+	return errs
+}
+
+// add records a problem with the field.
+func (ce *ConfigError) add(field string, err error, reason string) {
+	*ce = append(*ce, ConfigProblem{Field: field, Reason: reason, Err: err})
+}
+
+// Validate checks the whole configuration, including options that would
+// otherwise only fail once the TCP value is started or serving, and
+// returns a ConfigError listing every problem found.
 func (cfg *Config) Validate() error {
 	if cfg == nil {
 		return ErrInvalidConfiguration
 	}
 
+	var ce ConfigError
+
 	if cfg.NetType != "tcp" && cfg.NetType != "tcp4" && cfg.NetType != "tcp6" {
-		return ErrInvalidNetType
+		ce.add("NetType", ErrInvalidNetType, fmt.Sprintf("%q is not tcp, tcp4 or tcp6", cfg.NetType))
+	}
+
+	if cfg.Listener == nil || cfg.Addr != "" {
+		if _, _, err := net.SplitHostPort(cfg.Addr); err != nil {
+			ce.add("Addr", ErrInvalidConfiguration, err.Error())
+		}
+	}
+
+	if cfg.Listener != nil && (cfg.ListenerFile != nil || cfg.SocketActivation) {
+		ce.add("OptListen.Listener", ErrInvalidConfiguration, "conflicts with an inherited listener")
+	}
+
+	if cfg.ConnHandler == nil && cfg.ReadBufferSize <= 0 && cfg.WriteBufferSize <= 0 && !cfg.proxying() {
+		ce.add("ConnHandler", ErrInvalidConnHandler, "nil without buffer sizes to bind connections with")
+	}
+
+	// The handlers are not used when proxying and optional for streams.
+	if cfg.ReqHandler == nil && !cfg.proxying() && cfg.StreamHandler == nil {
+		ce.add("ReqHandler", ErrInvalidReqHandler, "nil")
+	}
+
+	if cfg.RespHandler == nil && !cfg.proxying() && cfg.StreamHandler == nil {
+		ce.add("RespHandler", ErrInvalidRespHandler, "nil")
+	}
+
+	if cfg.CanaryPercent < 0 || cfg.CanaryPercent > 100 {
+		ce.add("OptCanary.CanaryPercent", ErrInvalidCanary, fmt.Sprintf("%d is not from 0 to 100", cfg.CanaryPercent))
+	}
+
+	if cfg.HighWatermark < 0 || cfg.LowWatermark < 0 || (cfg.HighWatermark > 0 && cfg.LowWatermark >= cfg.HighWatermark) {
+		ce.add("OptWatermark.LowWatermark", ErrInvalidWatermark, fmt.Sprintf("%d is not below the high watermark %d", cfg.LowWatermark, cfg.HighWatermark))
+	}
+
+	if cfg.HalfDuplex && cfg.Pipeline > 0 {
+		ce.add("OptHalfDuplex.HalfDuplex", ErrInvalidHalfDuplex, "conflicts with Pipeline")
+	}
+
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		ce.add("OptTLS.CertFile", ErrInvalidTLS, "CertFile and KeyFile must be set together")
+	}
+
+	if cfg.CertFile != "" && cfg.GetCertificate != nil {
+		ce.add("OptTLS.GetCertificate", ErrInvalidTLS, "conflicts with CertFile")
+	}
+
+	if (cfg.VerifyPeer != nil || len(cfg.Protocols) > 0) && cfg.TLSConfig == nil && cfg.GetCertificate == nil && cfg.CertFile == "" {
+		ce.add("OptTLS.TLSConfig", ErrInvalidTLS, "VerifyPeer and Protocols need TLS enabled")
+	}
+
+	if cfg.MaxConns < 0 {
+		ce.add("OptAdmission.MaxConns", ErrInvalidAdmission, "negative")
+	}
+
+	if _, err := parseAccessList(cfg.Allow, cfg.Deny); err != nil {
+		ce.add("OptAdmission", err, "invalid access list")
 	}
 
-	if cfg.ConnHandler == nil {
-		return ErrInvalidConnHandler
+	for i, route := range cfg.Sniff {
+		if route.TLS && cfg.TLSConfig == nil && cfg.GetCertificate == nil && cfg.CertFile == "" {
+			ce.add(fmt.Sprintf("OptSniff.Sniff[%d]", i), ErrInvalidTLS, "TLS needs TLS enabled")
+		}
 	}
 
-	if cfg.ReqHandler == nil {
-		return ErrInvalidReqHandler
+	if cfg.Registry != nil && (cfg.Node == "" || cfg.Forward == nil) {
+		ce.add("OptCluster.Registry", ErrInvalidConfiguration, "needs Node and Forward")
 	}
 
-	if cfg.RespHandler == nil {
-		return ErrInvalidRespHandler
+	for i, vs := range cfg.Virtual {
+		if vs.ServerName == "" && len(vs.Prefix) == 0 {
+			ce.add(fmt.Sprintf("OptVirtual.Virtual[%d]", i), ErrInvalidConfiguration, "neither ServerName nor Prefix is set")
+		}
+	}
+
+	if cfg.AcceptBackoffMax > 0 && cfg.AcceptBackoffMin > cfg.AcceptBackoffMax {
+		ce.add("OptAcceptRetry.AcceptBackoffMin", ErrInvalidConfiguration, "above AcceptBackoffMax")
+	}
+
+	if cfg.HealthMaxErrorRate < 0 || cfg.HealthMaxErrorRate > 1 {
+		ce.add("OptHealth.HealthMaxErrorRate", ErrInvalidConfiguration, fmt.Sprintf("%v is not from 0 to 1", cfg.HealthMaxErrorRate))
+	}
+
+	if cfg.TapRate < 0 || cfg.TapRate > 1 {
+		ce.add("OptTap.TapRate", ErrInvalidConfiguration, fmt.Sprintf("%v is not from 0 to 1", cfg.TapRate))
+	}
+
+	if cfg.TapMaxBytes < 0 {
+		ce.add("OptTap.TapMaxBytes", ErrInvalidConfiguration, "negative")
+	}
+
+	switch cfg.Model {
+	case ModelGoroutine:
+		if cfg.IdlePark > 0 && !pollerSupported {
+			ce.add("OptModel.IdlePark", errNoPoller, "idle parking")
+		}
+	case ModelReactor:
+		if !pollerSupported {
+			ce.add("OptModel.Model", errNoPoller, "reactor model")
+		}
+		if cfg.HalfOpen {
+			ce.add("OptHalfClose.HalfOpen", ErrInvalidConfiguration, "conflicts with the reactor model")
+		}
+	default:
+		ce.add("OptModel.Model", ErrInvalidConfiguration, fmt.Sprintf("unknown model %d", cfg.Model))
+	}
+
+	switch cfg.DropMode {
+	case DropFIN, DropRST:
+	case DropBanner:
+		if len(cfg.DropMsg) == 0 {
+			ce.add("OptDrop.DropMsg", ErrInvalidConfiguration, "no banner for DropBanner")
+		}
+	default:
+		ce.add("OptDrop.DropMode", ErrInvalidConfiguration, fmt.Sprintf("unknown drop mode %d", cfg.DropMode))
+	}
+
+	if cfg.ProxyBalance != BalanceFailover && cfg.ProxyBalance != BalanceRoundRobin && cfg.ProxyBalance != BalanceHash {
+		ce.add("OptProxy.ProxyBalance", ErrInvalidProxy, fmt.Sprintf("unknown balance %d", cfg.ProxyBalance))
+	}
+
+	if cfg.proxying() && (cfg.Pipeline > 0 || cfg.HalfDuplex) {
+		ce.add("OptProxy.Upstreams", ErrInvalidProxy, "conflicts with Pipeline and HalfDuplex")
+	}
+
+	if cfg.StreamHandler != nil && (cfg.proxying() || cfg.Pipeline > 0 || cfg.HalfDuplex) {
+		ce.add("OptStream.StreamHandler", ErrInvalidConfiguration, "conflicts with the proxy, Pipeline and HalfDuplex")
+	}
+
+	if cfg.Connect && cfg.ConnectAllow == nil {
+		ce.add("OptConnect.ConnectAllow", ErrInvalidProxy, "required to tunnel CONNECT requests")
+	}
+
+	for i, addr := range cfg.Upstreams {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			ce.add(fmt.Sprintf("OptProxy.Upstreams[%d]", i), ErrInvalidProxy, err.Error())
+		}
+	}
+
+	if cfg.Compression != "" {
+		if _, ok := cfg.compressor(cfg.Compression); !ok {
+			ce.add("OptCompression.Compression", ErrInvalidCompression, fmt.Sprintf("unknown compressor %q", cfg.Compression))
+		}
+	}
+
+	for name, cmp := range cfg.Compressors {
+		if cmp == nil {
+			ce.add(fmt.Sprintf("OptCompression.Compressors[%q]", name), ErrInvalidCompression, "nil compressor")
+		}
+	}
+
+	for _, v := range cfg.intFields() {
+		if v.value < 0 {
+			ce.add(v.field, ErrInvalidConfiguration, "negative")
+		}
+	}
+
+	for _, v := range cfg.durationFields() {
+		if v.value < 0 {
+			ce.add(v.field, ErrInvalidConfiguration, "negative")
+		}
+	}
+
+	if ce != nil {
+		return ce
 	}
 
 	return nil
 }
 
+// configInt and configDuration name a numeric field of the configuration.
+type configInt struct {
+	field string
+	value int
+}
+
+type configDuration struct {
+	field string
+	value time.Duration
+}
+
+// intFields returns the integer fields of the configuration by name.
+func (cfg *Config) intFields() []configInt {
+	return []configInt{
+		{"OptRecorder.RecordFiles", cfg.RecordFiles},
+		{"OptRebalance.RebalanceConns", cfg.RebalanceConns},
+		{"OptAcceptRetry.AcceptErrorLimit", cfg.AcceptErrorLimit},
+		{"OptListen.Backlog", cfg.Backlog},
+		{"OptReadAhead.ReadAhead", cfg.ReadAhead},
+		{"OptPipeline.Pipeline", cfg.Pipeline},
+		{"OptPipeline.Workers", cfg.Workers},
+		{"OptBufferSize.ReadBufferSize", cfg.ReadBufferSize},
+		{"OptBufferSize.WriteBufferSize", cfg.WriteBufferSize},
+		{"OptModel.Pollers", cfg.Pollers},
+		{"OptShards.Shards", cfg.Shards},
+		{"OptShards.ShardQueue", cfg.ShardQueue},
+		{"OptProxy.ProxyRetries", cfg.ProxyRetries},
+		{"OptProxy.ProxyMaxFails", cfg.ProxyMaxFails},
+		{"OptKeepAlive.KeepAliveCount", cfg.KeepAliveCount},
+		{"OptRequestLimit.MaxRequestBytes", cfg.MaxRequestBytes},
+		{"OptBatch.BatchBytes", cfg.BatchBytes},
+		{"OptPriority.BulkWrites", cfg.BulkWrites},
+		{"OptDrop.DropBanners", cfg.DropBanners},
+		{"OptMaintenance.MaintenanceBanners", cfg.MaintenanceBanners},
+		{"OptLoad.MaxGoroutines", cfg.MaxGoroutines},
+		{"OptLoad.MaxProcessing", cfg.MaxProcessing},
+		{"OptMemory.MaxConnBytes", cfg.MaxConnBytes},
+		{"OptMemory.MaxBufferedBytes", cfg.MaxBufferedBytes},
+		{"OptTarpit.TarpitBytes", cfg.TarpitBytes},
+		{"OptBan.BanStrikes", cfg.BanStrikes},
+		{"OptSniff.SniffBytes", cfg.SniffBytes},
+	}
+}
+
+// durationFields returns the duration fields of the configuration by name.
+func (cfg *Config) durationFields() []configDuration {
+	return []configDuration{
+		{"OptRecorder.RecordEvery", cfg.RecordEvery},
+		{"OptHealth.HealthWindow", cfg.HealthWindow},
+		{"OptTLS.HandshakeTimeout", cfg.HandshakeTimeout},
+		{"OptTLS.CertCheckEvery", cfg.CertCheckEvery},
+		{"OptRebalance.RebalanceEvery", cfg.RebalanceEvery},
+		{"OptRebalance.RebalanceGrace", cfg.RebalanceGrace},
+		{"OptAcceptRetry.AcceptBackoffMin", cfg.AcceptBackoffMin},
+		{"OptAcceptRetry.AcceptBackoffMax", cfg.AcceptBackoffMax},
+		{"OptReadiness.ReadinessTimeout", cfg.ReadinessTimeout},
+		{"OptCongestion.WriteStall", cfg.WriteStall},
+		{"OptCongestion.StallWindow", cfg.StallWindow},
+		{"OptWriteTimeout.WriteTimeout", cfg.WriteTimeout},
+		{"OptVirtual.VirtualTimeout", cfg.VirtualTimeout},
+		{"OptModel.PollerReadTimeout", cfg.PollerReadTimeout},
+		{"OptModel.IdlePark", cfg.IdlePark},
+		{"OptProxy.ProxyDialTimeout", cfg.ProxyDialTimeout},
+		{"OptProxy.ProxyHealthEvery", cfg.ProxyHealthEvery},
+		{"OptProxy.ProxyDownTime", cfg.ProxyDownTime},
+		{"OptHalfClose.HalfOpenTimeout", cfg.HalfOpenTimeout},
+		{"OptKeepAlive.KeepAliveIdle", cfg.KeepAliveIdle},
+		{"OptKeepAlive.KeepAliveInterval", cfg.KeepAliveInterval},
+		{"OptRequestTimeout.RequestTimeout", cfg.RequestTimeout},
+		{"OptBatch.BatchDelay", cfg.BatchDelay},
+		{"OptLoad.LoadCheckEvery", cfg.LoadCheckEvery},
+		{"OptLoad.LoadDelay", cfg.LoadDelay},
+		{"OptTarpit.TarpitDelay", cfg.TarpitDelay},
+		{"OptTarpit.TarpitFor", cfg.TarpitFor},
+		{"OptBan.BanWindow", cfg.BanWindow},
+		{"OptBan.BanFor", cfg.BanFor},
+		{"OptSniff.SniffTimeout", cfg.SniffTimeout},
+		{"OptAuth.AuthTimeout", cfg.AuthTimeout},
+		{"OptConnect.ConnectTimeout", cfg.ConnectTimeout},
+	}
+}
+
+// handlers returns the configured handlers as a set.
+func (cfg *Config) handlers() HandlerSet {
+	return HandlerSet{
+		ConnHandler: cfg.ConnHandler,
+		ReqHandler:  cfg.ReqHandler,
+		RespHandler: cfg.RespHandler,
+	}
+}
+
 // Event fires events back to the user for important events.
 func (cfg *Config) Event(evt, typ int, ipAddress string, format string, a ...interface{}) {
-	if cfg.OptEvent.Event != nil {
-		cfg.OptEvent.Event(evt, typ, ipAddress, format, a...)
+	if cfg.OptEvent.Event == nil {
+		return
 	}
+
+	if cfg.Anonymize != nil && ipAddress != "" {
+		msg := cfg.scrub(ipAddress, fmt.Sprintf(format, a...))
+		cfg.OptEvent.Event(evt, typ, cfg.anonymize(ipAddress), "%s", msg)
+		return
+	}
+
+	cfg.OptEvent.Event(evt, typ, ipAddress, format, a...)
+}
+
+// serverEvent fires an event about the server itself, such as its listen,
+// health or admin address or an upstream. The address is no client's so
+// it's never anonymized.
+func (cfg *Config) serverEvent(evt, typ int, addr string, format string, a ...interface{}) {
+	if cfg.OptEvent.Event == nil {
+		return
+	}
+
+	cfg.OptEvent.Event(evt, typ, addr, format, a...)
 }
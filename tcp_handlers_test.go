@@ -2,8 +2,15 @@ package tcp_test
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"io"
 	"net"
+	"os"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -18,6 +25,35 @@ func (tch tcpConnHandler) Bind(conn net.Conn) (io.Reader, io.Writer) {
 	return bufio.NewReader(conn), bufio.NewWriter(conn)
 }
 
+// unbindConnHandler counts the readers and writers bound and given back.
+type unbindConnHandler struct {
+	tcpConnHandler
+	binds   *int32
+	unbinds *int32
+}
+
+// Bind is called to init to reader and writer.
+func (h unbindConnHandler) Bind(conn net.Conn) (io.Reader, io.Writer) {
+	atomic.AddInt32(h.binds, 1)
+	return h.tcpConnHandler.Bind(conn)
+}
+
+// Unbind implements the tcp.Unbinder interface.
+func (h unbindConnHandler) Unbind(reader io.Reader, writer io.Writer) {
+	atomic.AddInt32(h.unbinds, 1)
+}
+
+// tagConnHandler tags every connection it binds with the tag.
+type tagConnHandler struct {
+	tcpConnHandler
+	tag string
+}
+
+// Tags implements the tcp.Tagger interface.
+func (h tagConnHandler) Tags(conn net.Conn) []string {
+	return []string{h.tag}
+}
+
 // tcpReqHandler is required to process client messages.
 type tcpReqHandler struct{}
 
@@ -51,6 +87,277 @@ func (tcpReqHandler) Process(r *tcp.Request) {
 	atomic.StoreInt64(&dur, d)
 }
 
+// altReqHandler answers every message with a different reply so tests
+// can tell which handlers processed a connection.
+type altReqHandler struct {
+	tcpReqHandler
+}
+
+// Process is used to handle the processing of the message.
+func (altReqHandler) Process(r *tcp.Request) {
+	resp := tcp.Response{
+		TCPAddr: r.TCPAddr,
+		Data:    []byte("ALT\n"),
+		Length:  4,
+	}
+
+	r.TCP.Send(r.Context, &resp)
+}
+
+// startTLSReqHandler upgrades the connection to TLS when it receives the
+// STARTTLS command.
+type startTLSReqHandler struct {
+	tcpReqHandler
+	cfg *tls.Config
+}
+
+// echoReqHandler answers every message with the message. Messages starting
+// with "slow" take longer to process than the others.
+type echoReqHandler struct {
+	tcpReqHandler
+}
+
+// Process is used to handle the processing of the message.
+func (echoReqHandler) Process(r *tcp.Request) {
+	if strings.HasPrefix(string(r.Data), "slow") {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	resp := tcp.Response{
+		TCPAddr: r.TCPAddr,
+		Data:    r.Data,
+		Length:  r.Length,
+	}
+
+	r.TCP.Send(r.Context, &resp)
+}
+
+// errLineTooLong is returned by frameReqHandler for lines over its limit.
+var errLineTooLong = errors.New("line too long")
+
+// frameReqHandler echoes lines of up to 8 bytes and fails to read longer
+// ones, like a protocol with a limit on its frames.
+type frameReqHandler struct {
+	echoReqHandler
+}
+
+// Read implements the udp.ReqHandler interface.
+func (h frameReqHandler) Read(ipAddress string, reader io.Reader) ([]byte, int, error) {
+	data, length, err := h.echoReqHandler.Read(ipAddress, reader)
+	if err == nil && length > 8 {
+		return nil, 0, fmt.Errorf("%d bytes : %w", length, errLineTooLong)
+	}
+
+	return data, length, err
+}
+
+// limitError rejects a client for going over its limit, ending the
+// connection.
+type limitError struct{}
+
+func (limitError) Error() string   { return "over the limit" }
+func (limitError) Unwrap() error   { return tcp.ErrRateLimited }
+func (limitError) Temporary() bool { return false }
+
+// limitReqHandler echoes lines and rejects the client sending LIMIT.
+type limitReqHandler struct {
+	echoReqHandler
+}
+
+// Read implements the udp.ReqHandler interface.
+func (h limitReqHandler) Read(ipAddress string, reader io.Reader) ([]byte, int, error) {
+	data, length, err := h.echoReqHandler.Read(ipAddress, reader)
+	if err == nil && string(data[:length]) == "LIMIT\n" {
+		return nil, 0, limitError{}
+	}
+
+	return data, length, err
+}
+
+// closeConnHandler reports the reason each connection closed.
+type closeConnHandler struct {
+	tcpConnHandler
+	reasons chan tcp.CloseReason
+}
+
+// Closed implements the tcp.CloseNotifier interface.
+func (h closeConnHandler) Closed(conn net.Conn, reason tcp.CloseReason) {
+	h.reasons <- reason
+}
+
+// deadlineReqHandler answers with what it learned from the deadline of
+// the request: WAIT waits for the deadline to pass and EXTEND moves the
+// deadline an hour away.
+type deadlineReqHandler struct {
+	tcpReqHandler
+}
+
+// Process is used to handle the processing of the message.
+func (deadlineReqHandler) Process(r *tcp.Request) {
+	var reply string
+	switch strings.TrimSpace(string(r.Data)) {
+	case "WAIT":
+		<-r.Context.Done()
+		reply = r.Context.Err().Error()
+
+	case "EXTEND":
+		r.SetDeadline(time.Now().Add(time.Hour))
+		remaining, _ := tcp.Remaining(r.Context)
+		reply = fmt.Sprint(remaining > time.Minute)
+
+	default:
+		_, ok := r.Context.Deadline()
+		reply = fmt.Sprint(ok)
+	}
+
+	resp := tcp.Response{
+		TCPAddr: r.TCPAddr,
+		Data:    []byte(reply + "\n"),
+		Length:  len(reply) + 1,
+	}
+
+	r.TCP.Send(context.Background(), &resp)
+}
+
+// compressReqHandler echoes lines and switches the connection to the
+// compressor named after COMPRESS once it answered OK.
+type compressReqHandler struct {
+	echoReqHandler
+}
+
+// Process is used to handle the processing of the message.
+func (h compressReqHandler) Process(r *tcp.Request) {
+	name, ok := strings.CutPrefix(strings.TrimSpace(string(r.Data)), "COMPRESS ")
+	if !ok {
+		h.echoReqHandler.Process(r)
+		return
+	}
+
+	resp := tcp.Response{
+		TCPAddr: r.TCPAddr,
+		Data:    []byte("OK\n"),
+		Length:  3,
+	}
+
+	r.TCP.Send(r.Context, &resp)
+	r.TCP.Compress(r.TCPAddr, name)
+}
+
+// twiceReqHandler answers every message twice.
+type twiceReqHandler struct {
+	tcpReqHandler
+}
+
+// Process is used to handle the processing of the message.
+func (twiceReqHandler) Process(r *tcp.Request) {
+	for i := 0; i < 2; i++ {
+		resp := tcp.Response{
+			TCPAddr: r.TCPAddr,
+			Data:    []byte("GOT IT\n"),
+			Length:  7,
+		}
+
+		r.TCP.Send(r.Context, &resp)
+	}
+}
+
+// streamReqHandler answers every message with a header followed by the
+// contents of the file.
+type streamReqHandler struct {
+	tcpReqHandler
+	path string
+}
+
+// Process is used to handle the processing of the message.
+func (h streamReqHandler) Process(r *tcp.Request) {
+	f, err := os.Open(h.path)
+	if err != nil {
+		return
+	}
+
+	resp := tcp.Response{
+		TCPAddr: r.TCPAddr,
+		Data:    []byte("BEGIN\n"),
+		Length:  6,
+		Body:    f,
+	}
+
+	r.TCP.Send(r.Context, &resp)
+}
+
+// congestionReqHandler answers every message with the number of recent
+// write stalls of the client.
+type congestionReqHandler struct {
+	tcpReqHandler
+}
+
+// Process is used to handle the processing of the message.
+func (congestionReqHandler) Process(r *tcp.Request) {
+	cg, _ := tcp.CongestionFrom(r.Context)
+	data := []byte(fmt.Sprintf("STALLS %d\n", cg.Stalls))
+
+	resp := tcp.Response{
+		TCPAddr: r.TCPAddr,
+		Data:    data,
+		Length:  len(data),
+	}
+
+	r.TCP.Send(r.Context, &resp)
+}
+
+// uploadReqHandler copies the body announced by "PUT <size>" to a file
+// and answers with the bytes written. Other messages are echoed.
+type uploadReqHandler struct {
+	echoReqHandler
+	path string
+}
+
+// Process is used to handle the processing of the message.
+func (h uploadReqHandler) Process(r *tcp.Request) {
+	var size int64
+	if _, err := fmt.Sscanf(string(r.Data), "PUT %d\n", &size); err != nil {
+		h.echoReqHandler.Process(r)
+		return
+	}
+
+	f, err := os.Create(h.path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	n, err := r.CopyBody(f, size)
+	if err != nil {
+		return
+	}
+
+	data := []byte(fmt.Sprintf("OK %d\n", n))
+	resp := tcp.Response{
+		TCPAddr: r.TCPAddr,
+		Data:    data,
+		Length:  len(data),
+	}
+
+	r.TCP.Send(r.Context, &resp)
+}
+
+// Process is used to handle the processing of the message.
+func (h startTLSReqHandler) Process(r *tcp.Request) {
+	if string(r.Data) != "STARTTLS\n" {
+		h.tcpReqHandler.Process(r)
+		return
+	}
+
+	resp := tcp.Response{
+		TCPAddr: r.TCPAddr,
+		Data:    []byte("GO AHEAD\n"),
+		Length:  9,
+	}
+
+	r.TCP.Send(r.Context, &resp)
+	r.TCP.StartTLS(r.TCPAddr, h.cfg)
+}
+
 type tcpRespHandler struct{}
 
 // Write is provided the user-defined writer and the data to write.
@@ -62,3 +369,224 @@ func (tcpRespHandler) Write(r *tcp.Response, writer io.Writer) error {
 
 	return bufWriter.Flush()
 }
+
+// vectorRespHandler writes every response as a header with its length
+// followed by its data.
+type vectorRespHandler struct {
+	tcpRespHandler
+}
+
+// Buffers implements the tcp.VectorRespHandler interface.
+func (vectorRespHandler) Buffers(r *tcp.Response) (net.Buffers, error) {
+	header := []byte(fmt.Sprintf("%d ", r.Length))
+	return net.Buffers{header, r.Data[:r.Length]}, nil
+}
+
+// orderReqHandler echoes lines and reports the order they are processed
+// in. Processing WAIT waits for the gate to open.
+type orderReqHandler struct {
+	echoReqHandler
+	gate  chan struct{}
+	order chan string
+}
+
+// Process is used to handle the processing of the message.
+func (h orderReqHandler) Process(r *tcp.Request) {
+	line := strings.TrimSpace(string(r.Data))
+	if line == "WAIT" {
+		<-h.gate
+	}
+	h.order <- line
+
+	h.echoReqHandler.Process(r)
+}
+
+// keepReqHandler answers every message with the message and hands over
+// the requests it processed, so tests can send with their context later.
+type keepReqHandler struct {
+	echoReqHandler
+	kept chan *tcp.Request
+}
+
+// Process is used to handle the processing of the message.
+func (h keepReqHandler) Process(r *tcp.Request) {
+	h.echoReqHandler.Process(r)
+	h.kept <- r
+}
+
+// tagsReqHandler answers every message with the tags of the connection.
+type tagsReqHandler struct {
+	tcpReqHandler
+}
+
+// Process is used to handle the processing of the message.
+func (tagsReqHandler) Process(r *tcp.Request) {
+	data := []byte(strings.Join(r.Tags, ",") + "\n")
+	resp := tcp.Response{
+		TCPAddr: r.TCPAddr,
+		Data:    data,
+		Length:  len(data),
+	}
+
+	r.TCP.Send(r.Context, &resp)
+}
+
+// migrateReqHandler echoes lines and hands the connection off to the
+// handler set named after MIGRATE once it answered OK.
+type migrateReqHandler struct {
+	echoReqHandler
+}
+
+// Process is used to handle the processing of the message.
+func (h migrateReqHandler) Process(r *tcp.Request) {
+	name, ok := strings.CutPrefix(strings.TrimSpace(string(r.Data)), "MIGRATE ")
+	if !ok {
+		h.echoReqHandler.Process(r)
+		return
+	}
+
+	resp := tcp.Response{
+		TCPAddr: r.TCPAddr,
+		Data:    []byte("OK\n"),
+		Length:  3,
+	}
+
+	r.TCP.Send(r.Context, &resp)
+	r.TCP.Migrate(r.TCPAddr, name)
+}
+
+// tokenAuthHandler authenticates the clients sending the token as their
+// first line and identifies them as the user.
+type tokenAuthHandler struct {
+	token string
+	user  string
+}
+
+// Authenticate implements the tcp.AuthHandler interface.
+func (h tokenAuthHandler) Authenticate(ipAddress string, reader io.Reader, writer io.Writer) (string, error) {
+	line, err := reader.(*bufio.Reader).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	if strings.TrimSpace(line) != h.token {
+		return "", errors.New("bad token")
+	}
+
+	bufWriter := writer.(*bufio.Writer)
+	bufWriter.WriteString("WELCOME\n")
+	return h.user, bufWriter.Flush()
+}
+
+// identityReqHandler answers every message with the identity of the client.
+type identityReqHandler struct {
+	tcpReqHandler
+}
+
+// Process is used to handle the processing of the message.
+func (identityReqHandler) Process(r *tcp.Request) {
+	data := []byte(r.Identity + "\n")
+	resp := tcp.Response{
+		TCPAddr: r.TCPAddr,
+		Data:    data,
+		Length:  len(data),
+	}
+
+	r.TCP.Send(r.Context, &resp)
+}
+
+// sumReq is the request decoded by sumCodec.
+type sumReq struct {
+	A, B int
+}
+
+// sumCodec decodes lines of two numbers and encodes their sum as a line.
+type sumCodec struct{}
+
+// Read implements the tcp.Codec interface.
+func (sumCodec) Read(reader io.Reader) ([]byte, error) {
+	return reader.(*bufio.Reader).ReadBytes('\n')
+}
+
+// Decode implements the tcp.Codec interface.
+func (sumCodec) Decode(data []byte) (sumReq, error) {
+	var req sumReq
+	if _, err := fmt.Sscanf(string(data), "%d %d\n", &req.A, &req.B); err != nil {
+		return sumReq{}, err
+	}
+	return req, nil
+}
+
+// Encode implements the tcp.Codec interface.
+func (sumCodec) Encode(sum int) ([]byte, error) {
+	return []byte(fmt.Sprintf("%d\n", sum)), nil
+}
+
+// =============================================================================
+
+// errAcceptFailed is returned by failListener for every accept.
+var errAcceptFailed = tempError{}
+
+// tempError is a temporary error, like running out of file descriptors.
+type tempError struct{}
+
+func (tempError) Error() string   { return "accept failed" }
+func (tempError) Temporary() bool { return true }
+
+// errListenerLost is returned by a failListener whose socket is gone.
+var errListenerLost = permError{}
+
+// permError is an error the listener can't recover from.
+type permError struct{}
+
+func (permError) Error() string   { return "listener lost" }
+func (permError) Temporary() bool { return false }
+
+// failListener fails every accept and records when each was attempted.
+// Accept fails with errAcceptFailed unless err is set.
+type failListener struct {
+	mu      sync.Mutex
+	accepts []time.Time
+	closed  chan struct{}
+	once    sync.Once
+	err     error
+}
+
+// Accept implements the net.Listener interface.
+func (l *failListener) Accept() (net.Conn, error) {
+	select {
+	case <-l.closed:
+		return nil, net.ErrClosed
+	default:
+	}
+
+	l.mu.Lock()
+	{
+		l.accepts = append(l.accepts, time.Now())
+	}
+	l.mu.Unlock()
+
+	if l.err != nil {
+		return nil, l.err
+	}
+	return nil, errAcceptFailed
+}
+
+// Close implements the net.Listener interface.
+func (l *failListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+// Addr implements the net.Listener interface.
+func (l *failListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+// attempts returns the times of the accepts attempted.
+func (l *failListener) attempts() []time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]time.Time(nil), l.accepts...)
+}
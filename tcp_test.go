@@ -3,13 +3,27 @@ package tcp_test
 import (
 	"bufio"
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net"
+	"net/http"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/ardanlabs/tcp"
+	"github.com/ardanlabs/tcp/tcptest"
 )
 
 // TestTCP provide a test of listening for a connection and
@@ -312,6 +326,4854 @@ func TestRateLimit(t *testing.T) {
 	}
 }
 
+// TestInheritListener tests a new TCP value can take over the listener
+// of a running one.
+func TestInheritListener(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to restart without closing the listener.")
+	{
+		cfg := tcp.Config{
+			NetType:     "tcp4",
+			Addr:        ":0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+		}
+
+		// Create and start the original TCP value.
+		u1, err := tcp.New("TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+		if err := u1.Start(); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", success)
+
+		addr := u1.Addr().String()
+
+		// Export the listener file.
+		f, err := u1.File()
+		if err != nil {
+			t.Fatal("\tShould be able to export the listener file.", failed, err)
+		}
+		t.Log("\tShould be able to export the listener file.", success)
+
+		// Start a new TCP value with the inherited listener.
+		cfg.ListenerFile = f
+		u2, err := tcp.New("TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create the inheriting TCP listener.", failed, err)
+		}
+		if err := u2.Start(); err != nil {
+			t.Fatal("\tShould be able to start the inheriting TCP listener.", failed, err)
+		}
+		t.Log("\tShould be able to start the inheriting TCP listener.", success)
+
+		defer u2.Stop()
+
+		if u2.Addr().String() == addr {
+			t.Log("\tShould be listening on the same address.", success)
+		} else {
+			t.Error("\tShould be listening on the same address.", failed, u2.Addr())
+		}
+
+		// Shut down the original TCP value.
+		u1.Stop()
+
+		conn, err := net.Dial("tcp4", addr)
+		if err != nil {
+			t.Fatal("\tShould be able to dial a new TCP connection.", failed, err)
+		}
+		t.Log("\tShould be able to dial a new TCP connection.", success)
+
+		defer conn.Close()
+
+		if _, err := conn.Write([]byte("Hello\n")); err != nil {
+			t.Fatal("\tShould be able to send data to the connection.", failed, err)
+		}
+
+		response, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			t.Fatal("\tShould be able to read the response from the connection.", failed, err)
+		}
+
+		if response == "GOT IT\n" {
+			t.Log("\tShould receive the string \"GOT IT\" from the new listener.", success)
+		} else {
+			t.Error("\tShould receive the string \"GOT IT\" from the new listener.", failed, response)
+		}
+	}
+}
+
+// TestMaintenance tests new connections receive the maintenance banner
+// and are closed.
+func TestMaintenance(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to tell clients the service is in maintenance.")
+	{
+		cfg := tcp.Config{
+			NetType:     "tcp4",
+			Addr:        ":0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptMaintenance: tcp.OptMaintenance{
+				MaintenanceMsg: []byte("MAINTENANCE\n"),
+			},
+		}
+
+		u, err := tcp.New("TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+		t.Log("\tShould be able to create a new TCP listener.", success)
+
+		u.Maintenance(true)
+
+		if err := u.Start(); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", success)
+
+		defer u.Stop()
+
+		conn, err := net.Dial("tcp4", u.Addr().String())
+		if err != nil {
+			t.Fatal("\tShould be able to dial a new TCP connection.", failed, err)
+		}
+		t.Log("\tShould be able to dial a new TCP connection.", success)
+
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		banner, err := r.ReadString('\n')
+		if err != nil || banner != "MAINTENANCE\n" {
+			t.Fatal("\tShould receive the maintenance banner.", failed, banner, err)
+		}
+		t.Log("\tShould receive the maintenance banner.", success)
+
+		if _, err := r.ReadByte(); err == nil {
+			t.Fatal("\tShould have the connection closed after the banner.", failed)
+		}
+		t.Log("\tShould have the connection closed after the banner.", success)
+
+		if m := u.HandlerSetMetrics()[tcp.SetStable]; m.Connections != 0 || m.Active != 0 {
+			t.Fatalf("\tShould not count the connection turned away : %+v %s", m, failed)
+		}
+		if m := u.Metrics(); m.AcceptLatencyMax != 0 {
+			t.Fatalf("\tShould not count the connection turned away : %+v %s", m, failed)
+		}
+		t.Log("\tShould not count the connection turned away.", success)
+	}
+
+	t.Log("Given the need to write the banner before the client speaks.")
+	{
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptMaintenance: tcp.OptMaintenance{
+				MaintenanceMsg: []byte("MAINTENANCE\n"),
+			},
+			OptSniff: tcp.OptSniff{
+				Sniff: []tcp.SniffRoute{
+					{Name: "hello", Match: tcp.SniffPrefix([]byte("HELLO"))},
+				},
+			},
+		})
+		s.Maintenance(true)
+
+		c := s.Dial(t, tcptest.Lines)
+		c.Expect([]byte("MAINTENANCE"))
+		c.ExpectClosed()
+		t.Log("\tShould write the banner without sniffing the protocol.", success)
+	}
+
+	t.Log("Given the need to limit the banners written at once.")
+	{
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptMaintenance: tcp.OptMaintenance{
+				MaintenanceMsg:     []byte("MAINTENANCE\n"),
+				MaintenanceBanners: 1,
+			},
+		})
+		s.Maintenance(true)
+
+		// The in-memory connection blocks the banner until it's read,
+		// so the first connection holds the only banner allowed.
+		first := s.Dial(t, tcptest.Lines)
+		second := s.Dial(t, tcptest.Lines)
+
+		second.ExpectClosed()
+		t.Log("\tShould close the connections past the limit without a banner.", success)
+
+		first.Expect([]byte("MAINTENANCE"))
+		first.ExpectClosed()
+		t.Log("\tShould write the banner to the connections within the limit.", success)
+	}
+}
+
+// TestRebalance tests long-lived connections are asked to reconnect.
+func TestRebalance(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to rebalance long-lived connections.")
+	{
+		cfg := tcp.Config{
+			NetType:     "tcp4",
+			Addr:        ":0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptRebalance: tcp.OptRebalance{
+				RebalanceEvery: 50 * time.Millisecond,
+				GoAwayMsg:      []byte("GOAWAY\n"),
+			},
+		}
+
+		u, err := tcp.New("TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+		if err := u.Start(); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", success)
+
+		defer u.Stop()
+
+		conn, err := net.Dial("tcp4", u.Addr().String())
+		if err != nil {
+			t.Fatal("\tShould be able to dial a new TCP connection.", failed, err)
+		}
+		defer conn.Close()
+
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+		r := bufio.NewReader(conn)
+		msg, err := r.ReadString('\n')
+		if err != nil || msg != "GOAWAY\n" {
+			t.Fatal("\tShould be asked to reconnect.", failed, msg, err)
+		}
+		t.Log("\tShould be asked to reconnect.", success)
+
+		if _, err := r.ReadByte(); err == nil {
+			t.Fatal("\tShould see the server close its side.", failed)
+		}
+		t.Log("\tShould see the server close its side.", success)
+	}
+}
+
+// TestHealth tests the health reflects the state of the TCP value.
+func TestHealth(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to report health to a load balancer.")
+	{
+		cfg := tcp.Config{
+			NetType:     "tcp4",
+			Addr:        ":0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+		}
+
+		u, err := tcp.New("TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+
+		if h := u.Health(); h.Healthy {
+			t.Fatal("\tShould be unhealthy before Start.", failed, h)
+		}
+		t.Log("\tShould be unhealthy before Start.", success)
+
+		if err := u.Start(); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", failed, err)
+		}
+		defer u.Stop()
+
+		if h := u.Health(); !h.Healthy || !h.Accepting {
+			t.Fatal("\tShould be healthy after Start.", failed, h)
+		}
+		t.Log("\tShould be healthy after Start.", success)
+
+		u.DropConnections(true)
+		if h := u.Health(); h.Healthy || !h.Dropping {
+			t.Fatal("\tShould be unhealthy while dropping connections.", failed, h)
+		}
+		t.Log("\tShould be unhealthy while dropping connections.", success)
+	}
+
+	t.Log("Given the need to report a saturated worker pool.")
+	{
+		h := keepReqHandler{
+			kept: make(chan *tcp.Request),
+		}
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  h,
+			RespHandler: tcpRespHandler{},
+
+			OptPipeline: tcp.OptPipeline{
+				Pipeline: 2,
+				Workers:  1,
+			},
+		})
+
+		// The only worker is kept by the first request so the second
+		// waits for it.
+		c := s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("one"), []byte("one"))
+		c.Send([]byte("two"))
+
+		hl := s.Health()
+		for end := time.Now().Add(time.Second); hl.WorkQueued == 0 && time.Now().Before(end); hl = s.Health() {
+			time.Sleep(time.Millisecond)
+		}
+		if hl.Healthy || !hl.Saturated || hl.Workers != 1 || hl.WorkersBusy != 1 || hl.WorkQueued != 1 {
+			t.Fatalf("\tShould be unhealthy while the pool is saturated : %+v %s", hl, failed)
+		}
+		t.Log("\tShould be unhealthy while the pool is saturated.", success)
+
+		<-h.kept
+		c.Expect([]byte("two"))
+		<-h.kept
+
+		hl = s.Health()
+		for end := time.Now().Add(time.Second); (hl.WorkersBusy != 0 || hl.WorkQueued != 0) && time.Now().Before(end); hl = s.Health() {
+			time.Sleep(time.Millisecond)
+		}
+		if !hl.Healthy || hl.Saturated || hl.WorkQueued != 0 {
+			t.Fatalf("\tShould be healthy once the pool catches up : %+v %s", hl, failed)
+		}
+		t.Log("\tShould be healthy once the pool catches up.", success)
+	}
+
+	t.Log("Given the need to report the error rate over a fixed window.")
+	{
+		clock := tcptest.NewClock(time.Now())
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  frameReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptHealth: tcp.OptHealth{
+				HealthMaxErrorRate: 0.4,
+				HealthWindow:       time.Second,
+			},
+			OptClock: tcp.OptClock{
+				Clock: clock,
+			},
+		})
+
+		// One request is served and one fails to be read.
+		c := s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("Hello"), []byte("Hello"))
+		c.Send([]byte("Hello World"))
+
+		if hl := s.Health(); hl.ErrorRate != 0 || !hl.Healthy {
+			t.Fatalf("\tShould report no errors before the window ends : %+v %s", hl, failed)
+		}
+		t.Log("\tShould report no errors before the window ends.", success)
+
+		for end := time.Now().Add(time.Second); s.Metrics().ReadErrors == 0 && time.Now().Before(end); {
+			time.Sleep(time.Millisecond)
+		}
+		clock.Advance(time.Second)
+
+		hl := s.Health()
+		for end := time.Now().Add(time.Second); hl.ErrorRate == 0 && time.Now().Before(end); hl = s.Health() {
+			time.Sleep(time.Millisecond)
+		}
+		if hl.ErrorRate != 0.5 || hl.Healthy {
+			t.Fatalf("\tShould report the error rate of the window : %+v %s", hl, failed)
+		}
+		if hl = s.Health(); hl.ErrorRate != 0.5 {
+			t.Fatalf("\tShould report the same rate to every caller : %+v %s", hl, failed)
+		}
+		t.Log("\tShould report the same rate to every caller.", success)
+
+		clock.Advance(time.Second)
+		hl = s.Health()
+		for end := time.Now().Add(time.Second); hl.ErrorRate != 0 && time.Now().Before(end); hl = s.Health() {
+			time.Sleep(time.Millisecond)
+		}
+		if hl.ErrorRate != 0 || !hl.Healthy {
+			t.Fatalf("\tShould start over with the next window : %+v %s", hl, failed)
+		}
+		t.Log("\tShould start over with the next window.", success)
+	}
+}
+
+// TestDependencies tests Start waits for the dependencies to be ready.
+func TestDependencies(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to wait for backends before accepting clients.")
+	{
+		var checks int32
+
+		cfg := tcp.Config{
+			NetType:     "tcp4",
+			Addr:        ":0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptReadiness: tcp.OptReadiness{
+				Dependencies: []tcp.Dependency{
+					{
+						Name: "database",
+						Check: func(ctx context.Context) error {
+							if atomic.AddInt32(&checks, 1) < 3 {
+								return errors.New("not ready")
+							}
+							return nil
+						},
+					},
+				},
+			},
+		}
+
+		u, err := tcp.New("TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+		if err := u.Start(); err != nil {
+			t.Fatal("\tShould start once the dependency is ready.", failed, err)
+		}
+		u.Stop()
+
+		if n := atomic.LoadInt32(&checks); n != 3 {
+			t.Fatal("\tShould start once the dependency is ready.", failed, n)
+		}
+		t.Log("\tShould start once the dependency is ready.", success)
+
+		cfg.ReadinessTimeout = 300 * time.Millisecond
+		cfg.Dependencies[0].Check = func(ctx context.Context) error {
+			return errors.New("down")
+		}
+
+		u, err = tcp.New("TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+		if err := u.Start(); err == nil {
+			u.Stop()
+			t.Fatal("\tShould fail to start when a dependency is down.", failed)
+		}
+		t.Log("\tShould fail to start when a dependency is down.", success)
+
+		if u.Addr() != nil {
+			t.Fatal("\tShould not open the listener when a dependency is down.", failed)
+		}
+		t.Log("\tShould not open the listener when a dependency is down.", success)
+	}
+
+	t.Log("Given the need to drive the retries with the clock.")
+	{
+		var checks int32
+		clock := tcptest.NewClock(time.Now())
+
+		u, err := tcp.New("TEST", tcp.Config{
+			NetType:     "tcp4",
+			Addr:        ":0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptReadiness: tcp.OptReadiness{
+				Dependencies: []tcp.Dependency{
+					{
+						Name: "database",
+						Check: func(ctx context.Context) error {
+							if atomic.AddInt32(&checks, 1) < 3 {
+								return errors.New("not ready")
+							}
+							return nil
+						},
+					},
+				},
+			},
+			OptClock: tcp.OptClock{
+				Clock: clock,
+			},
+		})
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+
+		started := make(chan error, 1)
+		go func() { started <- u.Start() }()
+
+		// The first retry waits longer than this unless the clock moves.
+		time.Sleep(300 * time.Millisecond)
+		if n := atomic.LoadInt32(&checks); n != 1 {
+			t.Fatal("\tShould wait for the clock to retry.", failed, n)
+		}
+		t.Log("\tShould wait for the clock to retry.", success)
+
+		for {
+			clock.Advance(time.Second)
+			select {
+			case err := <-started:
+				if err != nil {
+					t.Fatal("\tShould start once the clock moved past the retries.", failed, err)
+				}
+				u.Stop()
+				t.Log("\tShould start once the clock moved past the retries.", success)
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}
+}
+
+// TestBreaker tests new connections are rejected while the breaker is
+// tripped and accepted again once it recovers.
+func TestBreaker(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to reject clients while a dependency is down.")
+	{
+		cfg := tcp.Config{
+			NetType:     "tcp4",
+			Addr:        ":0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptBreaker: tcp.OptBreaker{
+				BreakerMsg: func(reason string) []byte { return []byte("DOWN " + reason + "\n") },
+			},
+		}
+
+		u, err := tcp.New("TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+		if err := u.Start(); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", failed, err)
+		}
+		defer u.Stop()
+
+		call := func() (string, error) {
+			conn, err := net.Dial("tcp4", u.Addr().String())
+			if err != nil {
+				return "", err
+			}
+			defer conn.Close()
+
+			conn.Write([]byte("Hello\n"))
+			return bufio.NewReader(conn).ReadString('\n')
+		}
+
+		u.TripBreaker("database", 200*time.Millisecond)
+
+		if resp, err := call(); err != nil || resp != "DOWN database\n" {
+			t.Fatal("\tShould reject connections with the breaker message.", failed, resp, err)
+		}
+		t.Log("\tShould reject connections with the breaker message.", success)
+
+		time.Sleep(250 * time.Millisecond)
+
+		if resp, err := call(); err != nil || resp != "GOT IT\n" {
+			t.Fatal("\tShould accept connections once the breaker recovers.", failed, resp, err)
+		}
+		t.Log("\tShould accept connections once the breaker recovers.", success)
+
+		var recovered int32
+		clock := tcptest.NewClock(time.Now())
+		cfg.Clock = clock
+		cfg.OptEvent.Event = func(evt, typ int, ipAddress string, format string, a ...interface{}) {
+			if evt == tcp.EvtBreaker && format == "recovered" {
+				atomic.AddInt32(&recovered, 1)
+			}
+		}
+
+		u, err = tcp.New("TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+		if err := u.Start(); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", failed, err)
+		}
+		u.TripBreaker("database", time.Minute)
+		u.Stop()
+		clock.Advance(time.Hour)
+
+		if n := atomic.LoadInt32(&recovered); n != 0 {
+			t.Fatal("\tShould not report the recovery once stopped.", failed, n)
+		}
+		t.Log("\tShould not report the recovery once stopped.", success)
+	}
+
+	t.Log("Given the need to limit the banners while the breaker is tripped.")
+	{
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptMaintenance: tcp.OptMaintenance{
+				MaintenanceBanners: 1,
+			},
+			OptBreaker: tcp.OptBreaker{
+				BreakerMsg: func(reason string) []byte { return []byte("DOWN " + reason + "\n") },
+			},
+		})
+		s.TripBreaker("database", time.Minute)
+
+		// The in-memory connection blocks the banner until it's read,
+		// so the first connection holds the only banner allowed.
+		first := s.Dial(t, tcptest.Lines)
+		second := s.Dial(t, tcptest.Lines)
+
+		second.ExpectClosed()
+		t.Log("\tShould close the connections past the limit without a banner.", success)
+
+		first.Expect([]byte("DOWN database"))
+		first.ExpectClosed()
+		t.Log("\tShould write the banner to the connections within the limit.", success)
+	}
+}
+
+// TestRateLimitClock tests the rate limit follows the configured clock
+// so no sleeping is required.
+func TestRateLimitClock(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to test the rate limit without sleeping.")
+	{
+		clock := tcptest.NewClock(time.Now())
+
+		cfg := tcp.Config{
+			NetType:     "tcp4",
+			Addr:        ":0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptRateLimit: tcp.OptRateLimit{
+				RateLimit: func() time.Duration { return time.Hour },
+			},
+			OptClock: tcp.OptClock{
+				Clock: clock,
+			},
+		}
+
+		u, err := tcp.New("TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+		if err := u.Start(); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", failed, err)
+		}
+		defer u.Stop()
+
+		call := func() (string, error) {
+			conn, err := net.Dial("tcp4", u.Addr().String())
+			if err != nil {
+				return "", err
+			}
+			defer conn.Close()
+
+			conn.Write([]byte("Hello\n"))
+			return bufio.NewReader(conn).ReadString('\n')
+		}
+
+		if _, err := call(); err != nil {
+			t.Fatal("\tShould accept the first connection.", failed, err)
+		}
+		t.Log("\tShould accept the first connection.", success)
+
+		if _, err := call(); err == nil {
+			t.Fatal("\tShould drop the connection within the limit.", failed)
+		}
+		t.Log("\tShould drop the connection within the limit.", success)
+
+		clock.Advance(time.Hour)
+
+		if _, err := call(); err != nil {
+			t.Fatal("\tShould accept a connection once the clock moves past the limit.", failed, err)
+		}
+		t.Log("\tShould accept a connection once the clock moves past the limit.", success)
+	}
+}
+
+// TestReadAhead tests pipelined messages are served while reading ahead.
+func TestReadAhead(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to read ahead on streaming connections.")
+	{
+		cfg := tcp.Config{
+			NetType:     "tcp4",
+			Addr:        ":0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptReadAhead: tcp.OptReadAhead{
+				ReadAhead: 8,
+			},
+		}
+
+		u, err := tcp.New("TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+		if err := u.Start(); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", failed, err)
+		}
+		defer u.Stop()
+
+		conn, err := net.Dial("tcp4", u.Addr().String())
+		if err != nil {
+			t.Fatal("\tShould be able to dial a new TCP connection.", failed, err)
+		}
+		defer conn.Close()
+
+		// Send more than the read ahead buffer holds at once.
+		if _, err := conn.Write([]byte(strings.Repeat("Hello\n", 10))); err != nil {
+			t.Fatal("\tShould be able to send the messages.", failed, err)
+		}
+
+		r := bufio.NewReader(conn)
+		for i := 0; i < 10; i++ {
+			if resp, err := r.ReadString('\n'); err != nil || resp != "GOT IT\n" {
+				t.Fatal("\tShould receive a response to every message.", failed, resp, err)
+			}
+		}
+		t.Log("\tShould receive a response to every message.", success)
+	}
+}
+
+// TestPipeline tests pipelined requests are answered in order.
+func TestPipeline(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to process pipelined requests concurrently.")
+	{
+		cfg := tcp.Config{
+			NetType:     "tcp4",
+			Addr:        ":0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptPipeline: tcp.OptPipeline{
+				Pipeline: 4,
+				Workers:  4,
+			},
+		}
+
+		u, err := tcp.New("TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+		if err := u.Start(); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", failed, err)
+		}
+		defer u.Stop()
+
+		conn, err := net.Dial("tcp4", u.Addr().String())
+		if err != nil {
+			t.Fatal("\tShould be able to dial a new TCP connection.", failed, err)
+		}
+		defer conn.Close()
+
+		msgs := []string{"slow 1\n", "fast 2\n", "slow 3\n", "fast 4\n", "fast 5\n"}
+		start := time.Now()
+		if _, err := conn.Write([]byte(strings.Join(msgs, ""))); err != nil {
+			t.Fatal("\tShould be able to send the messages.", failed, err)
+		}
+
+		r := bufio.NewReader(conn)
+		for _, msg := range msgs {
+			if resp, err := r.ReadString('\n'); err != nil || resp != msg {
+				t.Fatal("\tShould receive the responses in request order.", failed, resp, err)
+			}
+		}
+		t.Log("\tShould receive the responses in request order.", success)
+
+		if d := time.Since(start); d >= 100*time.Millisecond {
+			t.Fatal("\tShould process the requests concurrently.", failed, d)
+		}
+		t.Log("\tShould process the requests concurrently.", success)
+	}
+
+	t.Log("Given the need to refuse responses to requests already done.")
+	{
+		h := keepReqHandler{
+			kept: make(chan *tcp.Request, 2),
+		}
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  h,
+			RespHandler: tcpRespHandler{},
+
+			OptPipeline: tcp.OptPipeline{
+				Pipeline: 4,
+			},
+		})
+
+		// The second response is written once the first request is
+		// done.
+		c := s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("first"), []byte("first"))
+		c.RoundTrip([]byte("second"), []byte("second"))
+		r := <-h.kept
+
+		resp := tcp.Response{
+			TCPAddr: r.TCPAddr,
+			Data:    []byte("late\n"),
+			Length:  5,
+		}
+		if err := s.Send(r.Context, &resp); !errors.Is(err, tcp.ErrRequestDone) {
+			t.Fatalf("\tShould refuse a response to a request already done : %v %s", err, failed)
+		}
+		t.Log("\tShould refuse a response to a request already done.", success)
+	}
+
+	t.Log("Given the need to keep serving while a client stops reading.")
+	{
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptPipeline: tcp.OptPipeline{
+				Pipeline: 4,
+				Workers:  2,
+			},
+		})
+
+		// The first response blocks the worker writing it, the others
+		// are held behind it and must not block the other worker.
+		stuck := s.Dial(t, tcptest.Lines)
+		for _, msg := range []string{"one", "two", "three"} {
+			stuck.Send([]byte(msg))
+		}
+
+		c := s.Dial(t, tcptest.Lines)
+		c.Timeout = time.Second
+		for _, msg := range []string{"four", "five"} {
+			c.RoundTrip([]byte(msg), []byte(msg))
+		}
+		t.Log("\tShould serve other clients while one stops reading.", success)
+
+		for _, msg := range []string{"one", "two", "three"} {
+			stuck.Expect([]byte(msg))
+		}
+		t.Log("\tShould write the responses held in request order.", success)
+	}
+}
+
+// TestStreamBody tests a response body is streamed after the message.
+func TestStreamBody(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to stream large payloads from files.")
+	{
+		body := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+		path := filepath.Join(t.TempDir(), "payload")
+		if err := os.WriteFile(path, body, 0644); err != nil {
+			t.Fatal("\tShould be able to write the payload file.", failed, err)
+		}
+
+		cfg := tcp.Config{
+			NetType:     "tcp4",
+			Addr:        ":0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  streamReqHandler{path: path},
+			RespHandler: tcpRespHandler{},
+		}
+
+		u, err := tcp.New("TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+		if err := u.Start(); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", failed, err)
+		}
+		defer u.Stop()
+
+		conn, err := net.Dial("tcp4", u.Addr().String())
+		if err != nil {
+			t.Fatal("\tShould be able to dial a new TCP connection.", failed, err)
+		}
+		defer conn.Close()
+
+		conn.Write([]byte("Hello\n"))
+
+		got := make([]byte, 6+len(body))
+		if _, err := io.ReadFull(conn, got); err != nil {
+			t.Fatal("\tShould receive the message and the body.", failed, err)
+		}
+
+		if string(got[:6]) != "BEGIN\n" || !bytes.Equal(got[6:], body) {
+			t.Fatal("\tShould receive the message followed by the body.", failed)
+		}
+		t.Log("\tShould receive the message followed by the body.", success)
+	}
+}
+
+// TestCopyBody tests the body following a request is copied from the
+// connection.
+func TestCopyBody(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to stream large payloads to files.")
+	{
+		body := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+		path := filepath.Join(t.TempDir(), "upload")
+
+		cfg := tcp.Config{
+			NetType:     "tcp4",
+			Addr:        ":0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  uploadReqHandler{path: path},
+			RespHandler: tcpRespHandler{},
+		}
+
+		u, err := tcp.New("TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+		if err := u.Start(); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", failed, err)
+		}
+		defer u.Stop()
+
+		conn, err := net.Dial("tcp4", u.Addr().String())
+		if err != nil {
+			t.Fatal("\tShould be able to dial a new TCP connection.", failed, err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		req := append([]byte(fmt.Sprintf("PUT %d\n", len(body))), body...)
+		conn.Write(append(req, "Hello\n"...))
+
+		br := bufio.NewReader(conn)
+		if line, err := br.ReadString('\n'); err != nil || line != fmt.Sprintf("OK %d\n", len(body)) {
+			t.Fatalf("\tShould copy the whole body : %q %v %s", line, err, failed)
+		}
+
+		got, err := os.ReadFile(path)
+		if err != nil || !bytes.Equal(got, body) {
+			t.Fatal("\tShould write the body to the file.", failed, err)
+		}
+		t.Log("\tShould copy the body following the request to the file.", success)
+
+		if line, err := br.ReadString('\n'); err != nil || line != "Hello\n" {
+			t.Fatalf("\tShould read the request following the body : %q %v %s", line, err, failed)
+		}
+		t.Log("\tShould read the request following the body.", success)
+	}
+}
+
+// TestCongestion tests handlers see the write stalls of the client.
+func TestCongestion(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to adapt responses to congested clients.")
+	{
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  congestionReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptCongestion: tcp.OptCongestion{
+				WriteStall: time.Nanosecond,
+			},
+		})
+
+		c := s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("Hello"), []byte("STALLS 0"))
+		t.Log("\tShould report no stalls before any write.", success)
+
+		c.RoundTrip([]byte("Hello"), []byte("STALLS 1"))
+		t.Log("\tShould report the stalled writes.", success)
+	}
+}
+
+// TestAcceptFilter tests connections rejected by the filter are dropped.
+func TestAcceptFilter(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to reject connections as they are accepted.")
+	{
+		var accepted int32
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptAcceptFilter: tcp.OptAcceptFilter{
+				AcceptFilter: func(conn net.Conn) error {
+					if atomic.AddInt32(&accepted, 1) == 1 {
+						return nil
+					}
+					return errors.New("only one connection")
+				},
+			},
+		})
+
+		c := s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("Hello"), []byte("GOT IT"))
+		t.Log("\tShould serve connections the filter allows.", success)
+
+		c = s.Dial(t, tcptest.Lines)
+		c.ExpectClosed()
+		t.Log("\tShould drop connections the filter rejects.", success)
+
+		if s.Clients() != 1 {
+			t.Fatalf("\tShould only track the allowed connection : Clients[%d] %s", s.Clients(), failed)
+		}
+		t.Log("\tShould only track the allowed connection.", success)
+	}
+}
+
+// TestAcceptRetry tests accept errors are reported and retried with a
+// growing backoff until they persist past the limit.
+func TestAcceptRetry(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to survive accept errors that persist.")
+	{
+		l := failListener{closed: make(chan struct{})}
+		var failures []int
+		var mu sync.Mutex
+
+		u, err := tcp.New("TEST", tcp.Config{
+			NetType:     "tcp4",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptListen: tcp.OptListen{
+				Listener: &l,
+			},
+			OptAcceptRetry: tcp.OptAcceptRetry{
+				AcceptBackoffMin: 20 * time.Millisecond,
+				AcceptBackoffMax: 80 * time.Millisecond,
+				AcceptErrorLimit: 4,
+				OnAcceptError: func(err error, n int) {
+					mu.Lock()
+					defer mu.Unlock()
+					if errors.Is(err, errAcceptFailed) {
+						failures = append(failures, n)
+					}
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("\tShould be able to create a new TCP listener : %v %s", err, failed)
+		}
+		if err := u.Start(); err != nil {
+			t.Fatalf("\tShould be able to start the TCP listener : %v %s", err, failed)
+		}
+		defer u.Stop()
+
+		select {
+		case <-l.closed:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("\tShould stop once the errors persist past the limit %s", failed)
+		}
+		if err := u.Stop(); !errors.Is(err, tcp.ErrShutdown) {
+			t.Fatalf("\tShould stop once the errors persist past the limit : %v %s", err, failed)
+		}
+		t.Log("\tShould stop once the errors persist past the limit.", success)
+
+		mu.Lock()
+		got := fmt.Sprint(failures)
+		mu.Unlock()
+		if got != "[1 2 3 4]" {
+			t.Fatalf("\tShould report every error with the failures so far : %s %s", got, failed)
+		}
+		t.Log("\tShould report every error with the failures so far.", success)
+
+		// Each wait is between half and the full backoff, which doubles
+		// from the min after every failure.
+		at := l.attempts()
+		if len(at) != 4 {
+			t.Fatalf("\tShould retry until the limit : %d %s", len(at), failed)
+		}
+		for i, min := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond} {
+			if d := at[i+1].Sub(at[i]); d < min {
+				t.Fatalf("\tShould back off between retries : wait %d took %v %s", i+1, d, failed)
+			}
+		}
+		if first, last := at[1].Sub(at[0]), at[3].Sub(at[2]); last <= first {
+			t.Fatalf("\tShould back off longer as the errors persist : %v %v %s", first, last, failed)
+		}
+		t.Log("\tShould back off longer as the errors persist.", success)
+	}
+}
+
+// TestAcceptListenerLost tests a listener provided isn't replaced by a new
+// one when it fails, and a listener that can't be bound again stops the
+// TCP value.
+func TestAcceptListenerLost(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to stop when the listener is lost.")
+	{
+		var mu sync.Mutex
+		var events []string
+		event := func(evt, typ int, ipAddress string, format string, a ...interface{}) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, fmt.Sprintf(format, a...))
+		}
+
+		// stopped waits for the accept routine to shut down and checks
+		// the reason was reported.
+		stopped := func(u *tcp.TCP, msg string) {
+			for end := time.Now().Add(2 * time.Second); ; time.Sleep(time.Millisecond) {
+				mu.Lock()
+				n := len(events)
+				last := ""
+				if n > 0 {
+					last = events[n-1]
+				}
+				mu.Unlock()
+
+				if last == "shutdown" {
+					break
+				}
+				if time.Now().After(end) {
+					t.Fatalf("\tShould stop the TCP value : %s %s", msg, failed)
+				}
+			}
+			if err := u.Stop(); !errors.Is(err, tcp.ErrShutdown) {
+				t.Fatalf("\tShould stop the TCP value : %v %s", err, failed)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			for _, e := range events {
+				if strings.Contains(e, msg) && strings.HasSuffix(e, "shutting down") {
+					return
+				}
+			}
+			t.Fatalf("\tShould report the listener lost : %q %s", events, failed)
+		}
+
+		l := failListener{closed: make(chan struct{}), err: errListenerLost}
+		u, err := tcp.New("TEST", tcp.Config{
+			NetType:     "tcp4",
+			Addr:        "127.0.0.1:0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptListen: tcp.OptListen{
+				Listener: &l,
+			},
+			OptEvent: tcp.OptEvent{
+				Event: event,
+			},
+		})
+		if err != nil {
+			t.Fatalf("\tShould be able to create a new TCP listener : %v %s", err, failed)
+		}
+		if err := u.Start(); err != nil {
+			t.Fatalf("\tShould be able to start the TCP listener : %v %s", err, failed)
+		}
+		defer u.Stop()
+
+		stopped(u, "listener provided failed")
+		if n := len(l.attempts()); n != 1 {
+			t.Fatalf("\tShould not accept again on the listener provided : %d %s", n, failed)
+		}
+		t.Log("\tShould stop rather than bind a listener of its own.", success)
+
+		mu.Lock()
+		events = nil
+		mu.Unlock()
+
+		var listens int32
+		fl := failListener{closed: make(chan struct{}), err: errListenerLost}
+		u, err = tcp.New("TEST", tcp.Config{
+			NetType:     "tcp4",
+			Addr:        "127.0.0.1:0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptListen: tcp.OptListen{
+				Listen: func(netType, addr string) (net.Listener, error) {
+					if atomic.AddInt32(&listens, 1) == 1 {
+						return &fl, nil
+					}
+					return nil, errors.New("address in use")
+				},
+			},
+			OptEvent: tcp.OptEvent{
+				Event: event,
+			},
+		})
+		if err != nil {
+			t.Fatalf("\tShould be able to create a new TCP listener : %v %s", err, failed)
+		}
+		if err := u.Start(); err != nil {
+			t.Fatalf("\tShould be able to start the TCP listener : %v %s", err, failed)
+		}
+		defer u.Stop()
+
+		stopped(u, "address in use")
+		if n := atomic.LoadInt32(&listens); n != 2 {
+			t.Fatalf("\tShould listen again once : %d %s", n, failed)
+		}
+		t.Log("\tShould stop once the listener can't be bound again.", success)
+	}
+}
+
+// TestCanary tests a percentage of new connections use the canary handlers.
+func TestCanary(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to roll out new handlers to part of the connections.")
+	{
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptCanary: tcp.OptCanary{
+				Canary:        &tcp.HandlerSet{ReqHandler: echoReqHandler{}},
+				CanaryPercent: 50,
+			},
+		})
+
+		for i := 0; i < 4; i++ {
+			want := "GOT IT"
+			if i%2 == 1 {
+				want = "Hello"
+			}
+
+			c := s.Dial(t, tcptest.Lines)
+			c.RoundTrip([]byte("Hello"), []byte(want))
+		}
+		t.Log("\tShould assign every other connection to the canary.", success)
+
+		m := s.HandlerSetMetrics()
+		if m[tcp.SetStable].Connections != 2 || m[tcp.SetCanary].Connections != 2 {
+			t.Fatalf("\tShould count the connections of each set : %+v %s", m, failed)
+		}
+		if m[tcp.SetStable].Requests != 2 || m[tcp.SetCanary].Requests != 2 {
+			t.Fatalf("\tShould count the requests of each set : %+v %s", m, failed)
+		}
+		t.Log("\tShould count the connections and requests of each set.", success)
+
+		s.SetCanaryPercent(100)
+		for i := 0; i < 2; i++ {
+			c := s.Dial(t, tcptest.Lines)
+			c.RoundTrip([]byte("Hello"), []byte("Hello"))
+		}
+		t.Log("\tShould assign every connection once the rollout is complete.", success)
+	}
+}
+
+// TestTags tests the stats of the tagged connections.
+func TestTags(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to report stats by the tags of the connections.")
+	{
+		var n int32
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tagConnHandler{tag: "lines"},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptTags: tcp.OptTags{
+				Tags: func(conn net.Conn) []string {
+					if atomic.AddInt32(&n, 1)%2 == 1 {
+						return []string{"tenant-a"}
+					}
+					return []string{"tenant-b"}
+				},
+			},
+		})
+
+		for i := 0; i < 3; i++ {
+			c := s.Dial(t, tcptest.Lines)
+			c.RoundTrip([]byte("Hello"), []byte("GOT IT"))
+		}
+
+		for _, st := range s.ClientStats() {
+			if len(st.Tags) != 2 || st.Tags[1] != "lines" {
+				t.Fatalf("\tShould tag the connections from the hook and the handler : %v %s", st.Tags, failed)
+			}
+		}
+		t.Log("\tShould tag the connections from the hook and the handler.", success)
+
+		// The bytes of a response are counted once its write returns,
+		// which can be after the client read it.
+		want := map[string]int64{"lines": 3, "tenant-a": 2, "tenant-b": 1}
+		stats := s.TagStats()
+		for end := time.Now().Add(time.Second); len(stats) > 0 && stats[0].BytesWritten < 7*want[stats[0].Tag] && time.Now().Before(end); stats = s.TagStats() {
+			time.Sleep(time.Millisecond)
+		}
+		if len(stats) != len(want) {
+			t.Fatalf("\tShould report every tag : %+v %s", stats, failed)
+		}
+		for _, st := range stats {
+			if st.Connections != want[st.Tag] || st.Active != int(want[st.Tag]) {
+				t.Fatalf("\tShould count the connections of each tag : %+v %s", st, failed)
+			}
+			if st.BytesRead != 6*want[st.Tag] || st.BytesWritten != 7*want[st.Tag] {
+				t.Fatalf("\tShould count the bytes of each tag : %+v %s", st, failed)
+			}
+		}
+		t.Log("\tShould count the connections and bytes of each tag.", success)
+	}
+
+	t.Log("Given the need to report the tags while a client stopped reading.")
+	{
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tagConnHandler{tag: "lines"},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+		})
+
+		// The in-memory connection blocks the response until it's read.
+		c := s.Dial(t, tcptest.Lines)
+		c.Send([]byte("Hello"))
+		for end := time.Now().Add(time.Second); s.Metrics().Requests == 0 && time.Now().Before(end); {
+			time.Sleep(time.Millisecond)
+		}
+
+		stats := make(chan []tcp.TagStat, 1)
+		go func() {
+			stats <- s.TagStats()
+		}()
+
+		select {
+		case st := <-stats:
+			if len(st) != 1 || st[0].Active != 1 {
+				t.Fatalf("\tShould report the tag of the blocked connection : %+v %s", st, failed)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("\tShould report the tags with a write blocked %s", failed)
+		}
+		t.Log("\tShould report the tags with a write blocked.", success)
+	}
+}
+
+// TestWatermark tests the callbacks run as the queued bytes cross the
+// watermarks.
+func TestWatermark(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to throttle publishing to slow clients.")
+	{
+		calls := make(chan string, 10)
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptWatermark: tcp.OptWatermark{
+				HighWatermark: 16,
+				OnHighWatermark: func(tcpAddr *net.TCPAddr, queued int) {
+					calls <- "high"
+				},
+				OnLowWatermark: func(tcpAddr *net.TCPAddr, queued int) {
+					calls <- "low"
+				},
+			},
+		})
+
+		c := s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("Hi"), []byte("Hi"))
+		if len(calls) != 0 {
+			t.Fatalf("\tShould not call the callbacks below the high watermark : %d %s", len(calls), failed)
+		}
+		t.Log("\tShould not call the callbacks below the high watermark.", success)
+
+		c.RoundTrip([]byte("Hello World, Hello World"), []byte("Hello World, Hello World"))
+		for _, want := range []string{"high", "low"} {
+			select {
+			case got := <-calls:
+				if got != want {
+					t.Fatalf("\tShould call the %s watermark callback : got %s %s", want, got, failed)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("\tShould call the %s watermark callback %s", want, failed)
+			}
+		}
+		t.Log("\tShould call the high and then the low watermark callbacks.", success)
+	}
+}
+
+// TestWriteTimeout tests writes to a client that stops reading time out.
+func TestWriteTimeout(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to bound the time a response takes to write.")
+	{
+		errs := make(chan *tcp.WriteError, 1)
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptWriteTimeout: tcp.OptWriteTimeout{
+				WriteTimeout: 50 * time.Millisecond,
+				OnWriteError: func(r *tcp.Response, err *tcp.WriteError) tcp.WriteAction {
+					errs <- err
+					return tcp.WriteClose
+				},
+			},
+		})
+
+		c := s.Dial(t, tcptest.Lines)
+		c.Send([]byte("Hello"))
+
+		select {
+		case err := <-errs:
+			if !err.Timeout() || err.Partial() || err.Attempt != 1 {
+				t.Fatalf("\tShould report the write timed out : %v %s", err, failed)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("\tShould report the write timed out %s", failed)
+		}
+		t.Log("\tShould report the write timed out.", success)
+
+		c.ExpectClosed()
+		t.Log("\tShould close the connection when asked to.", success)
+	}
+}
+
+// TestHalfDuplex tests the turns of half duplex connections are enforced.
+func TestHalfDuplex(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to enforce the turns of a half duplex protocol.")
+	{
+		violations := make(chan error, 10)
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  twiceReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptHalfDuplex: tcp.OptHalfDuplex{
+				HalfDuplex: true,
+				OnTurnViolation: func(tcpAddr *net.TCPAddr, err error) {
+					violations <- err
+				},
+			},
+		})
+
+		expect := func(want error) {
+			select {
+			case err := <-violations:
+				if err != want {
+					t.Fatalf("\tShould report %v : got %v %s", want, err, failed)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("\tShould report %v %s", want, failed)
+			}
+		}
+
+		c := s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("Hello"), []byte("GOT IT"))
+		expect(tcp.ErrTurnOutOfTurn)
+		c.RoundTrip([]byte("Hello"), []byte("GOT IT"))
+		expect(tcp.ErrTurnOutOfTurn)
+		t.Log("\tShould only write one response for each request.", success)
+
+		c.Write([]byte("Hello\nHello\n"))
+		expect(tcp.ErrTurnEarlyRead)
+		c.ExpectClosed()
+		t.Log("\tShould close clients sending requests before the response.", success)
+	}
+}
+
+// TestPause tests new connections wait in the backlog while paused.
+func TestPause(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to stop accepting connections without closing the listener.")
+	{
+		cfg := tcp.Config{
+			NetType: "tcp4",
+			Addr:    "127.0.0.1:0",
+
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+		}
+
+		u, err := tcp.New("TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+		if err := u.Start(); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", failed, err)
+		}
+		defer u.Stop()
+
+		u.Pause()
+		if !u.Paused() || u.Health().Healthy {
+			t.Fatal("\tShould report accepting is paused.", failed)
+		}
+		t.Log("\tShould report accepting is paused.", success)
+
+		conn, err := net.Dial("tcp4", u.Addr().String())
+		if err != nil {
+			t.Fatal("\tShould be able to dial into the backlog.", failed, err)
+		}
+		defer conn.Close()
+		t.Log("\tShould be able to dial into the backlog.", success)
+
+		conn.Write([]byte("Hello\n"))
+		bufReader := bufio.NewReader(conn)
+
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if _, err := bufReader.ReadString('\n'); err == nil || u.Clients() != 0 {
+			t.Fatal("\tShould not serve the connection while paused.", failed, err)
+		}
+		t.Log("\tShould not serve the connection while paused.", success)
+
+		u.Resume()
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if response, err := bufReader.ReadString('\n'); err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould serve the connection once resumed.", failed, response, err)
+		}
+		t.Log("\tShould serve the connection once resumed.", success)
+
+		for i := 0; i < 100; i++ {
+			u.Pause()
+			u.Resume()
+		}
+		time.Sleep(50 * time.Millisecond)
+		if n := u.Health().AcceptFailures; n != 0 {
+			t.Fatal("\tShould not count the pauses as accept failures.", failed, n)
+		}
+		t.Log("\tShould not count the pauses as accept failures.", success)
+	}
+}
+
+// TestCloseSummary tests a summary is delivered for each closed connection.
+func TestCloseSummary(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to meter the usage of each connection.")
+	{
+		summaries := make(chan tcp.ConnSummary, 10)
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptSummary: tcp.OptSummary{
+				OnClose: func(s tcp.ConnSummary) {
+					summaries <- s
+				},
+			},
+		})
+
+		next := func() tcp.ConnSummary {
+			select {
+			case s := <-summaries:
+				return s
+			case <-time.After(time.Second):
+				t.Fatalf("\tShould deliver the summary %s", failed)
+			}
+			return tcp.ConnSummary{}
+		}
+
+		c := s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("Hello"), []byte("GOT IT"))
+		c.RoundTrip([]byte("Hello"), []byte("GOT IT"))
+		c.Close()
+
+		sum := next()
+		if sum.Reason != tcp.CloseEOF || sum.Addr != c.LocalAddr().String() {
+			t.Fatalf("\tShould report the client closed the connection : %+v %s", sum, failed)
+		}
+		if sum.Requests != 2 || sum.Responses != 2 || sum.BytesRead != 12 || sum.BytesWritten != 14 {
+			t.Fatalf("\tShould report the usage of the connection : %+v %s", sum, failed)
+		}
+		t.Log("\tShould report the usage of a connection the client closed.", success)
+
+		c = s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("Hello"), []byte("GOT IT"))
+		s.Drop(c.LocalAddr().(*net.TCPAddr))
+
+		if sum := next(); sum.Reason != tcp.CloseDropped || sum.Requests != 1 {
+			t.Fatalf("\tShould report the connection was dropped : %+v %s", sum, failed)
+		}
+		t.Log("\tShould report the connection was dropped.", success)
+	}
+}
+
+// TestStopBlockedWrite tests the server stops while a response is blocked
+// on a client that stopped reading.
+func TestStopBlockedWrite(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to stop while a client stopped reading.")
+	{
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+		})
+
+		// The in-memory connection blocks the response until it's read.
+		c := s.Dial(t, tcptest.Lines)
+		c.Send([]byte("hello"))
+		for end := time.Now().Add(time.Second); s.Metrics().Requests == 0 && time.Now().Before(end); {
+			time.Sleep(time.Millisecond)
+		}
+
+		stopped := make(chan struct{})
+		go func() {
+			s.Stop()
+			close(stopped)
+		}()
+
+		select {
+		case <-stopped:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("\tShould stop with a write blocked %s", failed)
+		}
+		t.Log("\tShould stop with a write blocked.", success)
+	}
+}
+
+// TestVirtual tests the first bytes select the virtual server.
+func TestVirtual(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to host several services on one listener.")
+	{
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptVirtual: tcp.OptVirtual{
+				Virtual: []tcp.VirtualServer{
+					{Name: "echo", Prefix: []byte("ECHO "), Handlers: tcp.HandlerSet{ReqHandler: echoReqHandler{}}},
+					{Name: "alt", Prefix: []byte("ALT "), Handlers: tcp.HandlerSet{ReqHandler: altReqHandler{}}},
+				},
+				VirtualTimeout: 50 * time.Millisecond,
+			},
+		})
+
+		c := s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("ECHO Hello"), []byte("ECHO Hello"))
+		c.RoundTrip([]byte("Hello"), []byte("Hello"))
+		t.Log("\tShould serve the connection with the virtual server of the prefix.", success)
+
+		c = s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("ALT Hello"), []byte("ALT"))
+		t.Log("\tShould serve each connection with its own virtual server.", success)
+
+		c = s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("ECHT"), []byte("GOT IT"))
+		t.Log("\tShould serve unknown prefixes with the fallback.", success)
+
+		c = s.Dial(t, tcptest.Lines)
+		time.Sleep(100 * time.Millisecond)
+		c.RoundTrip([]byte("ECHO Hello"), []byte("GOT IT"))
+		t.Log("\tShould serve clients silent past the timeout with the fallback.", success)
+	}
+}
+
+// TestDrain tests drained connections finish their requests, are sent the
+// goodbye message and closed.
+func TestDrain(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to drain a group of connections for maintenance.")
+	{
+		var n int32
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptTags: tcp.OptTags{
+				Tags: func(conn net.Conn) []string {
+					if atomic.AddInt32(&n, 1) == 1 {
+						return []string{"old"}
+					}
+					return []string{"new"}
+				},
+			},
+		})
+
+		old := s.Dial(t, tcptest.Lines)
+		old.RoundTrip([]byte("Hello"), []byte("Hello"))
+		keep := s.Dial(t, tcptest.Lines)
+		keep.RoundTrip([]byte("Hello"), []byte("Hello"))
+
+		// Drain while a request is being processed.
+		old.Send([]byte("slow"))
+		time.Sleep(10 * time.Millisecond)
+
+		type result struct {
+			n   int
+			err error
+		}
+		drained := make(chan result, 1)
+		go func() {
+			n, err := s.DrainTag(context.Background(), "old", []byte("BYE\n"))
+			drained <- result{n, err}
+		}()
+
+		old.Expect([]byte("slow"))
+		t.Log("\tShould finish the request in flight.", success)
+
+		old.Expect([]byte("BYE"))
+		old.ExpectClosed()
+		t.Log("\tShould send the goodbye message and close the connection.", success)
+
+		if res := <-drained; res.err != nil || res.n != 1 {
+			t.Fatalf("\tShould drain the connections with the tag : %d %v %s", res.n, res.err, failed)
+		}
+		keep.RoundTrip([]byte("Hello"), []byte("Hello"))
+		t.Log("\tShould only drain the connections with the tag.", success)
+	}
+}
+
+// TestAccessLog tests every request is recorded in the access log.
+func TestAccessLog(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to record the requests in an access log.")
+	{
+		entries := make(chan tcp.AccessEntry, 10)
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptAccessLog: tcp.OptAccessLog{
+				AccessLog: io.Discard,
+				AccessLogFormat: func(w io.Writer, e tcp.AccessEntry) error {
+					entries <- e
+					return tcp.AccessLogJSON(w, e)
+				},
+			},
+		})
+
+		c := s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("Hello"), []byte("Hello"))
+		c.RoundTrip([]byte("Hello"), []byte("Hello"))
+
+		var ids []string
+		for i := 0; i < 2; i++ {
+			select {
+			case e := <-entries:
+				if e.Outcome != tcp.OutcomeOK || e.BytesIn != 6 || e.BytesOut != 6 || e.Responses != 1 || e.RemoteAddr != c.LocalAddr().String() {
+					t.Fatalf("\tShould record the request : %+v %s", e, failed)
+				}
+				ids = append(ids, e.ID)
+			case <-time.After(time.Second):
+				t.Fatalf("\tShould record the request %s", failed)
+			}
+		}
+		t.Log("\tShould record each request.", success)
+
+		if ids[0] == "" || ids[0] == ids[1] {
+			t.Fatalf("\tShould give each request its own id : %v %s", ids, failed)
+		}
+		t.Log("\tShould give each request its own id.", success)
+
+		s = tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptPipeline: tcp.OptPipeline{
+				Pipeline: 2,
+				Workers:  2,
+			},
+			OptAccessLog: tcp.OptAccessLog{
+				AccessLog: io.Discard,
+				AccessLogFormat: func(w io.Writer, e tcp.AccessEntry) error {
+					entries <- e
+					return nil
+				},
+			},
+		})
+
+		// The response to fast is held until the one to slow is written.
+		c = s.Dial(t, tcptest.Lines)
+		c.Send([]byte("slow"))
+		c.Send([]byte("fast 2"))
+		c.Expect([]byte("slow"))
+		c.Expect([]byte("fast 2"))
+
+		for _, want := range []int{5, 7} {
+			select {
+			case e := <-entries:
+				if e.BytesIn != want || e.Outcome != tcp.OutcomeOK || e.Responses != 1 {
+					t.Fatalf("\tShould record pipelined requests once their responses are written : %+v %s", e, failed)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("\tShould record pipelined requests once their responses are written %s", failed)
+			}
+		}
+		t.Log("\tShould record pipelined requests once their responses are written.", success)
+
+		var b bytes.Buffer
+		tcp.AccessLogJSON(&b, tcp.AccessEntry{ID: "id", Outcome: tcp.OutcomeNoResponse})
+		if !strings.Contains(b.String(), `"id":"id"`) || !strings.Contains(b.String(), `"outcome":"no_response"`) {
+			t.Fatalf("\tShould encode the entry as JSON : %s %s", b.String(), failed)
+		}
+		t.Log("\tShould encode the entry as JSON.", success)
+	}
+}
+
+// TestErrorClasses tests the errors are classified so callers can branch
+// on them without matching strings.
+func TestErrorClasses(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to branch on the errors of the package.")
+	{
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  frameReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptErrors: tcp.OptErrors{
+				ClassifyError: func(err error) error {
+					if errors.Is(err, errLineTooLong) {
+						return tcp.ErrFrameTooLarge
+					}
+					return nil
+				},
+			},
+		})
+
+		c := s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("Hello"), []byte("Hello"))
+		c.Send([]byte("Hello World"))
+		c.ExpectClosed()
+		t.Log("\tShould close the connection on a frame too large.", success)
+
+		err := s.Send(context.Background(), &tcp.Response{TCPAddr: c.LocalAddr().(*net.TCPAddr)})
+		if !errors.Is(err, tcp.ErrDisconnected) {
+			t.Fatalf("\tShould report sending to a disconnected client : %v %s", err, failed)
+		}
+		t.Log("\tShould report sending to a disconnected client.", success)
+
+		if err := s.Stop(); err != nil {
+			t.Fatalf("\tShould be able to stop the TCP listener : %v %s", err, failed)
+		}
+		err = s.Stop()
+		if !errors.Is(err, tcp.ErrShutdown) {
+			t.Fatalf("\tShould report stopping a stopped server : %v %s", err, failed)
+		}
+		t.Log("\tShould report stopping a stopped server.", success)
+
+		var cfg tcp.Config
+		ctx, cancel := context.WithTimeout(context.Background(), 0)
+		defer cancel()
+		<-ctx.Done()
+
+		if class := cfg.Classify(ctx.Err()); class != tcp.ErrDeadline || !tcp.Retryable(ctx.Err()) {
+			t.Fatalf("\tShould classify a deadline as retryable : %v %s", class, failed)
+		}
+		if class := cfg.Classify(os.ErrDeadlineExceeded); class != tcp.ErrDeadline {
+			t.Fatalf("\tShould classify a timeout as a deadline : %v %s", class, failed)
+		}
+		t.Log("\tShould classify deadlines as retryable.", success)
+
+		if class := cfg.Classify(err); class != tcp.ErrShutdown || tcp.Retryable(err) {
+			t.Fatalf("\tShould classify a shutdown as final : %v %s", class, failed)
+		}
+		if class := cfg.Classify(io.EOF); class != nil {
+			t.Fatalf("\tShould leave other errors unclassified : %v %s", class, failed)
+		}
+		t.Log("\tShould classify a shutdown as final.", success)
+
+		cfg.ClassifyError = func(err error) error {
+			switch {
+			case errors.Is(err, errLineTooLong):
+				return tcp.ErrRateLimited
+			case errors.Is(err, context.DeadlineExceeded):
+				return tcp.ErrShutdown
+			}
+			return nil
+		}
+		if !cfg.Retryable(errLineTooLong) || cfg.Retryable(ctx.Err()) {
+			t.Fatalf("\tShould retry the errors as their classification does %s", failed)
+		}
+		t.Log("\tShould retry the errors as their classification does.", success)
+	}
+}
+
+// TestUpdateConfig tests the configuration changes while the server runs.
+func TestUpdateConfig(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to change the configuration without a restart.")
+	{
+		applied := make(chan string, 10)
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptEvent: tcp.OptEvent{
+				Event: func(evt, typ int, ipAddress string, format string, a ...interface{}) {
+					if evt == tcp.EvtConfig {
+						applied <- fmt.Sprintf(format, a...)
+					}
+				},
+			},
+		})
+
+		timeout := -time.Second
+		if err := s.UpdateConfig(tcp.ConfigPatch{WriteTimeout: &timeout}); !errors.Is(err, tcp.ErrInvalidPatch) {
+			t.Fatalf("\tShould reject a negative timeout : %v %s", err, failed)
+		}
+		deny := []string{"127.0.0.1/33"}
+		if err := s.UpdateConfig(tcp.ConfigPatch{Deny: &deny}); !errors.Is(err, tcp.ErrInvalidAdmission) {
+			t.Fatalf("\tShould reject an invalid access list : %v %s", err, failed)
+		}
+		s.Dial(t, tcptest.Lines).RoundTrip([]byte("Hello"), []byte("Hello"))
+		t.Log("\tShould leave the configuration as is for an invalid patch.", success)
+
+		max := 1
+		if err := s.UpdateConfig(tcp.ConfigPatch{MaxConns: &max}); err != nil {
+			t.Fatalf("\tShould apply the max connections : %v %s", err, failed)
+		}
+		select {
+		case msg := <-applied:
+			if msg != "applied : MaxConns" {
+				t.Fatalf("\tShould report the settings applied : %s %s", msg, failed)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("\tShould report the settings applied %s", failed)
+		}
+		t.Log("\tShould report the settings applied.", success)
+
+		s.Dial(t, tcptest.Lines).ExpectClosed()
+		t.Log("\tShould reject connections over the max connections.", success)
+
+		max = 0
+		deny = []string{"127.0.0.0/8"}
+		if err := s.UpdateConfig(tcp.ConfigPatch{MaxConns: &max, Deny: &deny}); err != nil {
+			t.Fatalf("\tShould apply the access list : %v %s", err, failed)
+		}
+		s.Dial(t, tcptest.Lines).ExpectClosed()
+		t.Log("\tShould reject the clients denied.", success)
+
+		allow := []string{"127.0.0.1"}
+		deny = nil
+		if err := s.UpdateConfig(tcp.ConfigPatch{Allow: &allow, Deny: &deny}); err != nil {
+			t.Fatalf("\tShould apply the access list : %v %s", err, failed)
+		}
+		s.Dial(t, tcptest.Lines).RoundTrip([]byte("Hello"), []byte("Hello"))
+		t.Log("\tShould accept the clients allowed.", success)
+	}
+}
+
+// TestValidate tests every problem with the configuration is reported.
+func TestValidate(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to report every problem with the configuration.")
+	{
+		_, err := tcp.New("TEST", tcp.Config{
+			NetType:     "udp",
+			Addr:        "127.0.0.1",
+			ConnHandler: tcpConnHandler{},
+
+			OptHalfDuplex: tcp.OptHalfDuplex{
+				HalfDuplex: true,
+			},
+			OptPipeline: tcp.OptPipeline{
+				Pipeline: 2,
+			},
+			OptWriteTimeout: tcp.OptWriteTimeout{
+				WriteTimeout: -time.Second,
+			},
+		})
+
+		var ce tcp.ConfigError
+		if !errors.As(err, &ce) {
+			t.Fatalf("\tShould return a ConfigError : %v %s", err, failed)
+		}
+		t.Log("\tShould return a ConfigError.", success)
+
+		fields := map[string]bool{}
+		for _, p := range ce {
+			fields[p.Field] = true
+		}
+		for _, f := range []string{"NetType", "Addr", "ReqHandler", "RespHandler", "OptHalfDuplex.HalfDuplex", "OptWriteTimeout.WriteTimeout"} {
+			if !fields[f] {
+				t.Fatalf("\tShould report the problem with %s : %v %s", f, err, failed)
+			}
+		}
+		if len(ce) != 6 {
+			t.Fatalf("\tShould report only the problems : %v %s", err, failed)
+		}
+		t.Log("\tShould report every problem.", success)
+
+		for _, target := range []error{tcp.ErrInvalidNetType, tcp.ErrInvalidReqHandler, tcp.ErrInvalidRespHandler, tcp.ErrInvalidHalfDuplex} {
+			if !errors.Is(err, target) {
+				t.Fatalf("\tShould match %v : %v %s", target, err, failed)
+			}
+		}
+		if errors.Is(err, tcp.ErrInvalidTLS) {
+			t.Fatalf("\tShould not match the errors of other problems : %v %s", err, failed)
+		}
+		t.Log("\tShould match the error of each problem.", success)
+	}
+}
+
+// TestListener tests the server runs over a listener built by the caller.
+func TestListener(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to serve a listener built by the caller.")
+	{
+		l := tcptest.NewListener()
+		u, err := tcp.New("TEST", tcp.Config{
+			NetType:     "tcp4",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptListen: tcp.OptListen{
+				Listener: l,
+			},
+		})
+		if err != nil {
+			t.Fatalf("\tShould be able to create a new TCP listener : %v %s", err, failed)
+		}
+		if err := u.Start(); err != nil {
+			t.Fatalf("\tShould be able to start the TCP listener : %v %s", err, failed)
+		}
+		defer u.Stop()
+
+		if u.Addr() != l.Addr() {
+			t.Fatalf("\tShould serve the listener provided : %v %s", u.Addr(), failed)
+		}
+		t.Log("\tShould serve the listener provided.", success)
+
+		conn, err := l.Dial()
+		if err != nil {
+			t.Fatalf("\tShould be able to dial the listener : %v %s", err, failed)
+		}
+		defer conn.Close()
+
+		if _, err := conn.Write([]byte("Hello\n")); err != nil {
+			t.Fatalf("\tShould be able to send a message : %v %s", err, failed)
+		}
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil || line != "Hello\n" {
+			t.Fatalf("\tShould receive the response : %q %v %s", line, err, failed)
+		}
+		t.Log("\tShould serve the connections accepted.", success)
+
+		_, err = tcp.New("TEST", tcp.Config{
+			NetType:     "tcp4",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptListen: tcp.OptListen{
+				Listener: l,
+			},
+			OptInherit: tcp.OptInherit{
+				SocketActivation: true,
+			},
+		})
+		if !errors.Is(err, tcp.ErrInvalidConfiguration) {
+			t.Fatalf("\tShould reject an inherited listener as well : %v %s", err, failed)
+		}
+		t.Log("\tShould reject an inherited listener as well.", success)
+	}
+}
+
+// TestTap tests copies of the bytes are passed to the tap.
+func TestTap(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to record the bytes of the connections.")
+	{
+		type tapped struct {
+			dir  tcp.TapDir
+			data string
+		}
+		taps := make(chan tapped, 100)
+
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptTap: tcp.OptTap{
+				Tap: func(ipAddress string, dir tcp.TapDir, data []byte) {
+					taps <- tapped{dir: dir, data: string(data)}
+				},
+				TapMaxBytes: 8,
+			},
+		})
+
+		c := s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("Hello"), []byte("Hello"))
+		c.RoundTrip([]byte("World"), []byte("World"))
+
+		got := map[tcp.TapDir]string{}
+		timeout := time.After(time.Second)
+		for len(got[tcp.TapIn]) < 8 || len(got[tcp.TapOut]) < 8 {
+			select {
+			case tp := <-taps:
+				got[tp.dir] += tp.data
+			case <-timeout:
+				t.Fatalf("\tShould tap the bytes in each direction : %q %s", got, failed)
+			}
+		}
+
+		if got[tcp.TapIn] != "Hello\nWo" || got[tcp.TapOut] != "Hello\nWo" {
+			t.Fatalf("\tShould tap the bytes in each direction : %q %s", got, failed)
+		}
+		t.Log("\tShould tap the bytes in each direction.", success)
+
+		c.RoundTrip([]byte("Again"), []byte("Again"))
+		select {
+		case tp := <-taps:
+			t.Fatalf("\tShould stop tapping at the limit : %v %s", tp, failed)
+		case <-time.After(50 * time.Millisecond):
+		}
+		t.Log("\tShould stop tapping at the limit.", success)
+	}
+}
+
+// TestShards tests the connections are spread over the shards.
+func TestShards(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to spread the connections over shards.")
+	{
+		const conns = 20
+
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptShards: tcp.OptShards{
+				Shards:      4,
+				ShardAccept: true,
+			},
+		})
+
+		cs := make([]*tcptest.Conn, conns)
+		for i := range cs {
+			cs[i] = s.Dial(t, tcptest.Lines)
+			cs[i].RoundTrip([]byte("Hello"), []byte("Hello"))
+		}
+		t.Log("\tShould serve the connections joined by the shards.", success)
+
+		stats := s.ShardStats()
+		if len(stats) != 4 {
+			t.Fatalf("\tShould create the shards configured : %d %s", len(stats), failed)
+		}
+
+		var clients, joined, used int
+		for _, st := range stats {
+			clients += st.Clients
+			joined += int(st.Joined)
+			if st.Clients > 0 {
+				used++
+			}
+		}
+		if clients != conns || joined != conns || used < 2 {
+			t.Fatalf("\tShould spread the connections over the shards : %+v %s", stats, failed)
+		}
+		t.Log("\tShould spread the connections over the shards.", success)
+
+		cs[0].Close()
+		var removed int64
+		for i := 0; i < 100 && removed == 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+			removed = 0
+			for _, st := range s.ShardStats() {
+				removed += st.Removed
+			}
+		}
+		if removed != 1 || s.Clients() != conns-1 {
+			t.Fatalf("\tShould count the connections removed : %d %d %s", removed, s.Clients(), failed)
+		}
+		t.Log("\tShould count the connections removed.", success)
+	}
+}
+
+// TestForEachConn tests the connections are visited without blocking
+// accepts.
+func TestForEachConn(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to visit the connections while serving new ones.")
+	{
+		const conns = 5
+
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+		})
+
+		for i := 0; i < conns; i++ {
+			s.Dial(t, tcptest.Lines).RoundTrip([]byte("Hello"), []byte("Hello"))
+		}
+
+		if n := s.ActiveConnections(); n != conns {
+			t.Fatalf("\tShould count the active connections : %d %s", n, failed)
+		}
+		t.Log("\tShould count the active connections.", success)
+
+		// Connections are accepted while the function runs.
+		seen := make(map[string]bool)
+		s.ForEachConn(func(st tcp.Stat) bool {
+			if len(seen) == 0 {
+				s.Dial(t, tcptest.Lines).RoundTrip([]byte("Hello"), []byte("Hello"))
+			}
+			seen[st.IP] = true
+			return true
+		})
+		if len(seen) < conns || s.ActiveConnections() != conns+1 {
+			t.Fatalf("\tShould visit the connections without blocking accepts : %d %d %s", len(seen), s.ActiveConnections(), failed)
+		}
+		t.Log("\tShould visit the connections without blocking accepts.", success)
+
+		var visited int
+		s.ForEachConn(func(st tcp.Stat) bool {
+			visited++
+			return visited < 2
+		})
+		if visited != 2 {
+			t.Fatalf("\tShould stop once the function returns false : %d %s", visited, failed)
+		}
+		t.Log("\tShould stop once the function returns false.", success)
+	}
+}
+
+// TestServe tests typed handlers are served with the codec.
+func TestServe(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to handle typed requests and responses.")
+	{
+		var events int32
+		l := tcptest.NewListener()
+		u, err := tcp.Serve("TEST", tcp.Config{
+			NetType: "tcp4",
+
+			OptEvent: tcp.OptEvent{
+				Event: func(evt, typ int, ipAddress string, format string, a ...interface{}) {
+					if typ == tcp.TypError {
+						atomic.AddInt32(&events, 1)
+					}
+				},
+			},
+			OptListen: tcp.OptListen{
+				Listener: l,
+			},
+		}, sumCodec{}, func(r *tcp.Request, req sumReq) (int, error) {
+			if req.A < 0 {
+				return 0, tcp.ErrNoReply
+			}
+			return req.A + req.B, nil
+		})
+		if err != nil {
+			t.Fatalf("\tShould be able to serve the typed handler : %v %s", err, failed)
+		}
+		defer u.Stop()
+
+		conn, err := l.Dial()
+		if err != nil {
+			t.Fatalf("\tShould be able to dial the listener : %v %s", err, failed)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(time.Second))
+		reader := bufio.NewReader(conn)
+
+		roundTrip := func(req string) string {
+			if _, err := conn.Write([]byte(req)); err != nil {
+				t.Fatalf("\tShould be able to send a request : %v %s", err, failed)
+			}
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("\tShould receive a response : %v %s", err, failed)
+			}
+			return line
+		}
+
+		if resp := roundTrip("3 4\n"); resp != "7\n" {
+			t.Fatalf("\tShould answer with the typed response : %q %s", resp, failed)
+		}
+		t.Log("\tShould answer with the typed response.", success)
+
+		if resp := roundTrip("-1 4\nnot numbers\n1 1\n"); resp != "2\n" {
+			t.Fatalf("\tShould skip the requests without a reply : %q %s", resp, failed)
+		}
+		if n := atomic.LoadInt32(&events); n != 1 {
+			t.Fatalf("\tShould report the requests that don't decode : %d %s", n, failed)
+		}
+		t.Log("\tShould skip the requests without a reply or that don't decode.", success)
+	}
+}
+
+// TestProxy tests the connections are forwarded to the upstreams.
+func TestProxy(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to forward the connections to upstream servers.")
+	{
+		upstreams := make(map[string]*tcptest.Server)
+		for _, addr := range []string{"a:1", "b:1"} {
+			upstreams[addr] = tcptest.NewServer(t, tcp.Config{
+				ConnHandler: tcpConnHandler{},
+				ReqHandler:  echoReqHandler{},
+				RespHandler: tcpRespHandler{},
+			})
+		}
+
+		dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+			u, ok := upstreams[addr]
+			if !ok {
+				return nil, errors.New("connection refused")
+			}
+			return u.Listener.Dial()
+		}
+
+		s := tcptest.NewServer(t, tcp.Config{
+			OptProxy: tcp.OptProxy{
+				Upstreams:    []string{"down:1", "a:1"},
+				ProxyDial:    dial,
+				ProxyRetries: 1,
+			},
+		})
+
+		s.Dial(t, tcptest.Lines).RoundTrip([]byte("Hello"), []byte("Hello"))
+		s.Stop()
+
+		stats := s.ProxyStats()
+		if len(stats) != 2 || stats[0].Addr != "a:1" || stats[0].Dials != 1 || stats[1].DialErrors != 1 {
+			t.Fatalf("\tShould dial the next upstream when a dial fails : %+v %s", stats, failed)
+		}
+		t.Log("\tShould dial the next upstream when a dial fails.", success)
+
+		if stats[0].BytesUp != 6 || stats[0].BytesDown != 6 || stats[0].Active != 0 {
+			t.Fatalf("\tShould count the bytes in each direction : %+v %s", stats[0], failed)
+		}
+		t.Log("\tShould count the bytes in each direction.", success)
+
+		s = tcptest.NewServer(t, tcp.Config{
+			OptProxy: tcp.OptProxy{
+				Upstreams:    []string{"a:1", "b:1"},
+				ProxyBalance: tcp.BalanceRoundRobin,
+				ProxyDial:    dial,
+			},
+		})
+
+		for i := 0; i < 4; i++ {
+			s.Dial(t, tcptest.Lines).RoundTrip([]byte("Hello"), []byte("Hello"))
+		}
+
+		stats = s.ProxyStats()
+		if len(stats) != 2 || stats[0].Dials != 2 || stats[1].Dials != 2 {
+			t.Fatalf("\tShould dial the upstreams in turn : %+v %s", stats, failed)
+		}
+		t.Log("\tShould dial the upstreams in turn.", success)
+
+		s = tcptest.NewServer(t, tcp.Config{
+			OptProxy: tcp.OptProxy{
+				PickUpstream: func(ipAddress string, attempt int) string {
+					return "down:1"
+				},
+				ProxyDial:    dial,
+				ProxyRetries: 2,
+			},
+		})
+
+		s.Dial(t, tcptest.Lines).ExpectClosed()
+		if stats := s.ProxyStats(); len(stats) != 1 || stats[0].DialErrors != 3 {
+			t.Fatalf("\tShould close the connection once the retries run out : %+v %s", stats, failed)
+		}
+		t.Log("\tShould close the connection once the retries run out.", success)
+
+		_, err := tcp.New("TEST", tcp.Config{
+			NetType: "tcp4",
+			Addr:    ":0",
+
+			OptProxy: tcp.OptProxy{
+				Upstreams: []string{"no-port"},
+			},
+		})
+		if !errors.Is(err, tcp.ErrInvalidProxy) {
+			t.Fatalf("\tShould reject an upstream without a port : %v %s", err, failed)
+		}
+		t.Log("\tShould reject an upstream without a port.", success)
+	}
+}
+
+// TestProxyHealth tests the unhealthy upstreams are taken out of the
+// rotation.
+func TestProxyHealth(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to stop forwarding the connections to failing upstreams.")
+	{
+		a := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+		})
+
+		var failing int32
+		dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if addr != "a:1" || atomic.LoadInt32(&failing) == 1 {
+				return nil, errors.New("connection refused")
+			}
+			return a.Listener.Dial()
+		}
+
+		var downs, ups int32
+		event := func(evt, typ int, ipAddress string, format string, a ...interface{}) {
+			if evt != tcp.EvtProxy || typ != tcp.TypInfo {
+				return
+			}
+			switch {
+			case strings.HasPrefix(format, "upstream down"):
+				atomic.AddInt32(&downs, 1)
+			case strings.HasPrefix(format, "upstream up"):
+				atomic.AddInt32(&ups, 1)
+			}
+		}
+
+		s := tcptest.NewServer(t, tcp.Config{
+			OptEvent: tcp.OptEvent{
+				Event: event,
+			},
+			OptProxy: tcp.OptProxy{
+				Upstreams:     []string{"down:1", "a:1"},
+				ProxyDial:     dial,
+				ProxyRetries:  1,
+				ProxyMaxFails: 2,
+				ProxyDownTime: time.Hour,
+			},
+		})
+
+		for i := 0; i < 3; i++ {
+			s.Dial(t, tcptest.Lines).RoundTrip([]byte("Hello"), []byte("Hello"))
+		}
+
+		stats := s.ProxyStats()
+		if stats[1].Addr != "down:1" || stats[1].Dials != 2 || stats[1].Healthy || atomic.LoadInt32(&downs) != 1 {
+			t.Fatalf("\tShould take the upstream out once dials failed in a row : %+v %s", stats, failed)
+		}
+		t.Log("\tShould take the upstream out once dials failed in a row.", success)
+
+		var probes int32
+		s = tcptest.NewServer(t, tcp.Config{
+			OptEvent: tcp.OptEvent{
+				Event: event,
+			},
+			OptProxy: tcp.OptProxy{
+				Upstreams:        []string{"a:1"},
+				ProxyDial:        dial,
+				ProxyHealthEvery: 10 * time.Millisecond,
+				ProxyProbe: func(conn net.Conn) error {
+					atomic.AddInt32(&probes, 1)
+					if _, err := conn.Write([]byte("PING\n")); err != nil {
+						return err
+					}
+					_, err := bufio.NewReader(conn).ReadString('\n')
+					return err
+				},
+			},
+		})
+
+		waitHealthy := func(healthy bool) bool {
+			for i := 0; i < 100; i++ {
+				if stats := s.ProxyStats(); len(stats) == 1 && stats[0].Healthy == healthy {
+					return true
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			return false
+		}
+
+		atomic.StoreInt32(&failing, 1)
+		if !waitHealthy(false) {
+			t.Fatalf("\tShould take the upstream out when a check fails : %+v %s", s.ProxyStats(), failed)
+		}
+		t.Log("\tShould take the upstream out when a check fails.", success)
+
+		atomic.StoreInt32(&failing, 0)
+		if !waitHealthy(true) || atomic.LoadInt32(&probes) == 0 || atomic.LoadInt32(&ups) == 0 {
+			t.Fatalf("\tShould put the upstream back once the probe passes : %+v %s", s.ProxyStats(), failed)
+		}
+		t.Log("\tShould put the upstream back once the probe passes.", success)
+	}
+}
+
+// TestProxyHash tests a client always lands on the same upstream.
+func TestProxyHash(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to route a client to the same upstream every time.")
+	{
+		upstreams := make(map[string]*tcptest.Server)
+		for _, addr := range []string{"a:1", "b:1", "c:1"} {
+			upstreams[addr] = tcptest.NewServer(t, tcp.Config{
+				ConnHandler: tcpConnHandler{},
+				ReqHandler:  echoReqHandler{},
+				RespHandler: tcpRespHandler{},
+			})
+		}
+
+		var failing string
+		var mu sync.Mutex
+		dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+			mu.Lock()
+			down := addr == failing
+			mu.Unlock()
+			if down {
+				return nil, errors.New("connection refused")
+			}
+			return upstreams[addr].Listener.Dial()
+		}
+
+		dials := func(s *tcptest.Server) map[string]int64 {
+			m := make(map[string]int64)
+			for _, st := range s.ProxyStats() {
+				m[st.Addr] = st.Dials - st.DialErrors
+			}
+			return m
+		}
+
+		s := tcptest.NewServer(t, tcp.Config{
+			OptProxy: tcp.OptProxy{
+				Upstreams:     []string{"a:1", "b:1", "c:1"},
+				ProxyBalance:  tcp.BalanceHash,
+				ProxyDial:     dial,
+				ProxyRetries:  2,
+				ProxyMaxFails: 1,
+				ProxyDownTime: time.Hour,
+			},
+		})
+
+		for i := 0; i < 5; i++ {
+			s.Dial(t, tcptest.Lines).RoundTrip([]byte("Hello"), []byte("Hello"))
+		}
+
+		var sticky string
+		for addr, n := range dials(s) {
+			if n == 5 {
+				sticky = addr
+			}
+		}
+		if sticky == "" {
+			t.Fatalf("\tShould route the connections of a client to one upstream : %v %s", dials(s), failed)
+		}
+		t.Log("\tShould route the connections of a client to one upstream.", success)
+
+		mu.Lock()
+		failing = sticky
+		mu.Unlock()
+
+		for i := 0; i < 5; i++ {
+			s.Dial(t, tcptest.Lines).RoundTrip([]byte("Hello"), []byte("Hello"))
+		}
+
+		var moved int
+		for addr, n := range dials(s) {
+			if addr != sticky && n == 5 {
+				moved++
+			}
+		}
+		if moved != 1 {
+			t.Fatalf("\tShould route the client to one other upstream while it's unhealthy : %v %s", dials(s), failed)
+		}
+		t.Log("\tShould route the client to one other upstream while it's unhealthy.", success)
+
+		s = tcptest.NewServer(t, tcp.Config{
+			OptProxy: tcp.OptProxy{
+				Upstreams:    []string{"a:1", "b:1", "c:1"},
+				ProxyBalance: tcp.BalanceHash,
+				ProxyHashKey: func(ipAddress string) string {
+					return ipAddress
+				},
+				ProxyDial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					return upstreams[addr].Listener.Dial()
+				},
+			},
+		})
+
+		for i := 0; i < 30; i++ {
+			s.Dial(t, tcptest.Lines).RoundTrip([]byte("Hello"), []byte("Hello"))
+		}
+		if m := dials(s); len(m) < 2 {
+			t.Fatalf("\tShould spread the clients by the key of the user : %v %s", m, failed)
+		}
+		t.Log("\tShould spread the clients by the key of the user.", success)
+	}
+}
+
+// TestStream tests connections are served as duplex streams.
+func TestStream(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to serve protocols that aren't request/response.")
+	{
+		summaries := make(chan tcp.ConnSummary, 1)
+		s := tcptest.NewServer(t, tcp.Config{
+			OptBufferSize: tcp.OptBufferSize{
+				ReadBufferSize: 1024,
+			},
+			OptSummary: tcp.OptSummary{
+				OnClose: func(cs tcp.ConnSummary) {
+					summaries <- cs
+				},
+			},
+			OptStream: tcp.OptStream{
+				StreamHandler: tcp.StreamFunc(func(s *tcp.Stream) error {
+					if err := s.Send([]byte("Welcome\n")); err != nil {
+						return err
+					}
+
+					for {
+						data, err := s.Recv()
+						if err != nil {
+							return err
+						}
+
+						// Every message is answered twice.
+						for i := 0; i < 2; i++ {
+							if err := s.Send(data); err != nil {
+								return err
+							}
+						}
+					}
+				}),
+			},
+		})
+
+		c := s.Dial(t, tcptest.Lines)
+		c.Expect([]byte("Welcome"))
+		t.Log("\tShould let the server send first.", success)
+
+		c.Send([]byte("Hello"))
+		c.Expect([]byte("Hello"))
+		c.Expect([]byte("Hello"))
+		t.Log("\tShould send any number of messages for each one received.", success)
+
+		c.Close()
+		if cs := <-summaries; cs.Reason != tcp.CloseEOF || cs.BytesRead != 6 {
+			t.Fatalf("\tShould close the connection once the handler returns : %+v %s", cs, failed)
+		}
+		t.Log("\tShould close the connection once the handler returns.", success)
+
+		canceled := make(chan struct{})
+		s = tcptest.NewServer(t, tcp.Config{
+			OptBufferSize: tcp.OptBufferSize{
+				ReadBufferSize: 1024,
+			},
+			OptStream: tcp.OptStream{
+				StreamHandler: tcp.StreamFunc(func(s *tcp.Stream) error {
+					s.Send([]byte("Welcome\n"))
+					<-s.Context().Done()
+					close(canceled)
+					return nil
+				}),
+			},
+		})
+
+		c = s.Dial(t, tcptest.Lines)
+		c.Expect([]byte("Welcome"))
+		if err := s.Drop(c.LocalAddr().(*net.TCPAddr)); err != nil {
+			t.Fatalf("\tShould be able to drop the connection : %v %s", err, failed)
+		}
+
+		select {
+		case <-canceled:
+		case <-time.After(time.Second):
+			t.Fatalf("\tShould cancel the stream once the connection is dropped %s", failed)
+		}
+		t.Log("\tShould cancel the stream once the connection is dropped.", success)
+	}
+}
+
+// TestHalfClose tests the write side of a connection is closed apart from
+// its read side.
+func TestHalfClose(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to answer a client that closed its write side.")
+	{
+		var u *tcp.TCP
+		sendErr := make(chan error, 1)
+
+		cfg := tcp.Config{
+			NetType: "tcp4",
+			Addr:    "127.0.0.1:0",
+
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptHalfClose: tcp.OptHalfClose{
+				HalfOpen: true,
+				OnHalfClose: func(tcpAddr *net.TCPAddr) {
+					bye := []byte("Bye\n")
+					u.Send(context.Background(), &tcp.Response{TCPAddr: tcpAddr, Data: bye, Length: len(bye)})
+					u.CloseWrite(tcpAddr)
+					sendErr <- u.Send(context.Background(), &tcp.Response{TCPAddr: tcpAddr, Data: bye, Length: len(bye)})
+				},
+			},
+		}
+
+		var err error
+		if u, err = tcp.New("TEST", cfg); err != nil {
+			t.Fatalf("\tShould be able to create a new TCP listener : %v %s", err, failed)
+		}
+		if err := u.Start(); err != nil {
+			t.Fatalf("\tShould be able to start the TCP listener : %v %s", err, failed)
+		}
+		defer u.Stop()
+
+		conn, err := net.Dial("tcp4", u.Addr().String())
+		if err != nil {
+			t.Fatalf("\tShould be able to dial a new TCP connection : %v %s", err, failed)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		if _, err := conn.Write([]byte("Hello\n")); err != nil {
+			t.Fatalf("\tShould be able to send data to the connection : %v %s", err, failed)
+		}
+		conn.(*net.TCPConn).CloseWrite()
+
+		got, err := io.ReadAll(conn)
+		if err != nil || string(got) != "Hello\nBye\n" {
+			t.Fatalf("\tShould answer once the client half closed : %q %v %s", got, err, failed)
+		}
+		t.Log("\tShould answer once the client half closed.", success)
+		t.Log("\tShould end the stream once the server closed its write side.", success)
+
+		if err := <-sendErr; !errors.Is(err, tcp.ErrWriteClosed) {
+			t.Fatalf("\tShould refuse writes after the write side closed : %v %s", err, failed)
+		}
+		t.Log("\tShould refuse writes after the write side closed.", success)
+	}
+}
+
+// TestKeepAlive tests a client that stopped answering the keepalive probes
+// is told apart from a deadline.
+func TestKeepAlive(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to tell network death from protocol errors.")
+	{
+		var cfg tcp.Config
+		opErr := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ETIMEDOUT)}
+		if class := cfg.Classify(opErr); class != tcp.ErrDisconnected || tcp.Retryable(opErr) {
+			t.Fatalf("\tShould classify a dead peer as disconnected : %v %s", class, failed)
+		}
+		t.Log("\tShould classify a dead peer as disconnected.", success)
+
+		u, err := tcp.New("TEST", tcp.Config{
+			NetType: "tcp4",
+			Addr:    "127.0.0.1:0",
+
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptKeepAlive: tcp.OptKeepAlive{
+				KeepAliveIdle:     time.Second,
+				KeepAliveInterval: time.Second,
+				KeepAliveCount:    3,
+			},
+		})
+		if err != nil {
+			t.Fatalf("\tShould be able to create a new TCP listener : %v %s", err, failed)
+		}
+		if err := u.Start(); err != nil {
+			t.Fatalf("\tShould be able to start the TCP listener : %v %s", err, failed)
+		}
+		defer u.Stop()
+
+		conn, err := net.Dial("tcp4", u.Addr().String())
+		if err != nil {
+			t.Fatalf("\tShould be able to dial a new TCP connection : %v %s", err, failed)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		conn.Write([]byte("Hello\n"))
+		if line, err := bufio.NewReader(conn).ReadString('\n'); err != nil || line != "Hello\n" {
+			t.Fatalf("\tShould serve the connections with the keepalive settings : %q %v %s", line, err, failed)
+		}
+		t.Log("\tShould serve the connections with the keepalive settings.", success)
+
+		bad := tcp.Config{OptKeepAlive: tcp.OptKeepAlive{KeepAliveCount: -1}}
+		if err := bad.Validate(); !strings.Contains(fmt.Sprint(err), "OptKeepAlive.KeepAliveCount") {
+			t.Fatalf("\tShould reject a negative probe count : %v %s", err, failed)
+		}
+		t.Log("\tShould reject a negative probe count.", success)
+	}
+}
+
+// TestCloseReason tests the reason a connection closed is reported to the
+// connection handler and the metrics.
+func TestCloseReason(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to know why each connection closed.")
+	{
+		reasons := make(chan tcp.CloseReason, 10)
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: closeConnHandler{reasons: reasons},
+			ReqHandler:  limitReqHandler{},
+			RespHandler: tcpRespHandler{},
+		})
+
+		next := func() tcp.CloseReason {
+			select {
+			case reason := <-reasons:
+				return reason
+			case <-time.After(time.Second):
+				t.Fatalf("\tShould report the close reason %s", failed)
+			}
+			return ""
+		}
+
+		c := s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("Hello"), []byte("Hello"))
+		c.Close()
+
+		if reason := next(); reason != tcp.CloseEOF {
+			t.Fatalf("\tShould report the client closed the connection : %s %s", reason, failed)
+		}
+		t.Log("\tShould report the client closed the connection.", success)
+
+		c = s.Dial(t, tcptest.Lines)
+		c.Send([]byte("LIMIT"))
+
+		if reason := next(); reason != tcp.CloseRateLimited {
+			t.Fatalf("\tShould report the client was rate limited : %s %s", reason, failed)
+		}
+		c.ExpectClosed()
+		t.Log("\tShould report the client was rate limited.", success)
+
+		c = s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("Hello"), []byte("Hello"))
+		s.Drop(c.LocalAddr().(*net.TCPAddr))
+
+		if reason := next(); reason != tcp.CloseDropped {
+			t.Fatalf("\tShould report the connection was dropped : %s %s", reason, failed)
+		}
+		t.Log("\tShould report the connection was dropped.", success)
+
+		closes := s.Metrics().Closes
+		if closes[tcp.CloseEOF] != 1 || closes[tcp.CloseRateLimited] != 1 || closes[tcp.CloseDropped] != 1 {
+			t.Fatalf("\tShould count the connections closed by reason : %v %s", closes, failed)
+		}
+		t.Log("\tShould count the connections closed by reason.", success)
+	}
+}
+
+// TestRequestTimeout tests requests are given a deadline to process.
+func TestRequestTimeout(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to bound the time requests take to process.")
+	{
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  deadlineReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptRequestTimeout: tcp.OptRequestTimeout{
+				RequestTimeout: 50 * time.Millisecond,
+			},
+		})
+
+		c := s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("Hello"), []byte("true"))
+		t.Log("\tShould give the request a deadline.", success)
+
+		// The requests of a connection are processed in turn, so each
+		// round trip waits for the previous request to finish.
+		c.RoundTrip([]byte("WAIT"), []byte("context deadline exceeded"))
+		c.RoundTrip([]byte("EXTEND"), []byte("true"))
+		if n := s.Metrics().RequestTimeouts; n != 1 {
+			t.Fatalf("\tShould count the request that timed out : %d %s", n, failed)
+		}
+		t.Log("\tShould cancel the request once the deadline passed.", success)
+
+		c.RoundTrip([]byte("Hello"), []byte("true"))
+		if n := s.Metrics().RequestTimeouts; n != 1 {
+			t.Fatalf("\tShould not count the request with a deadline moved : %d %s", n, failed)
+		}
+		t.Log("\tShould let the handler move the deadline.", success)
+	}
+
+	t.Log("Given the need to time the deadlines with the clock.")
+	{
+		clock := tcptest.NewClock(time.Now())
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  deadlineReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptRequestTimeout: tcp.OptRequestTimeout{
+				RequestTimeout: time.Minute,
+			},
+			OptClock: tcp.OptClock{
+				Clock: clock,
+			},
+		})
+
+		c := s.Dial(t, tcptest.Lines)
+		c.Send([]byte("WAIT"))
+
+		// The deadline is set once the request is processed.
+		for end := time.Now().Add(time.Second); s.Metrics().Processing == 0 && time.Now().Before(end); {
+			time.Sleep(time.Millisecond)
+		}
+		clock.Advance(time.Minute)
+
+		c.Expect([]byte("context deadline exceeded"))
+		t.Log("\tShould cancel the request once the clock passed the deadline.", success)
+	}
+}
+
+// TestRequestLimit tests requests over the limit are rejected.
+func TestRequestLimit(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to bound the size of each request.")
+	{
+		var tooLarge tcp.FrameTooLargeError
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptRequestLimit: tcp.OptRequestLimit{
+				MaxRequestBytes: 8,
+				OnFrameTooLarge: func(tcpAddr *net.TCPAddr, err error) *tcp.Response {
+					var fe *tcp.FrameTooLargeError
+					if errors.As(err, &fe) {
+						tooLarge = *fe
+					}
+					return &tcp.Response{Data: []byte("TOO LARGE\n"), Length: 10}
+				},
+			},
+		})
+
+		c := s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("Hello"), []byte("Hello"))
+		c.RoundTrip([]byte("Hello World"), []byte("TOO LARGE"))
+		c.ExpectClosed()
+
+		if tooLarge.Size != 12 || tooLarge.Max != 8 {
+			t.Fatalf("\tShould report the size of the request : %+v %s", tooLarge, failed)
+		}
+		t.Log("\tShould answer the request over the limit before closing.", success)
+
+		summaries := make(chan tcp.ConnSummary, 1)
+		s = tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptRequestLimit: tcp.OptRequestLimit{
+				MaxRequestBytes: 8,
+			},
+			OptSummary: tcp.OptSummary{
+				OnClose: func(s tcp.ConnSummary) {
+					summaries <- s
+				},
+			},
+		})
+
+		c = s.Dial(t, tcptest.Lines)
+		c.Send([]byte("Hello World"))
+		c.ExpectClosed()
+
+		select {
+		case sum := <-summaries:
+			if sum.Reason != tcp.CloseFrameTooLarge {
+				t.Fatalf("\tShould report the request was too large : %s %s", sum.Reason, failed)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("\tShould deliver the summary %s", failed)
+		}
+		t.Log("\tShould close the connection right away without a response.", success)
+	}
+}
+
+// TestCompression tests the bytes of the connections are compressed below
+// the handlers.
+func TestCompression(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to compress the bytes of the connections.")
+	{
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  compressReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptCompression: tcp.OptCompression{
+				Compression: tcp.CompressGzip,
+			},
+		})
+
+		conn, err := s.Listener.Dial()
+		if err != nil {
+			t.Fatalf("\tShould dial the server : %v %s", err, failed)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(time.Second))
+
+		zw := gzip.NewWriter(conn)
+		zw.Write([]byte("Hello\n"))
+		zw.Flush()
+
+		zr, err := gzip.NewReader(conn)
+		if err != nil {
+			t.Fatalf("\tShould read the compressed stream : %v %s", err, failed)
+		}
+		if line, err := bufio.NewReader(zr).ReadString('\n'); err != nil || line != "Hello\n" {
+			t.Fatalf("\tShould echo the compressed request : %q %v %s", line, err, failed)
+		}
+		t.Log("\tShould compress the connections from the start.", success)
+
+		s = tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  compressReqHandler{},
+			RespHandler: tcpRespHandler{},
+		})
+
+		c := s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("COMPRESS deflate"), []byte("OK"))
+
+		fw, _ := flate.NewWriter(c, flate.DefaultCompression)
+		fw.Write([]byte("Hello\n"))
+		fw.Flush()
+
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		if line, err := bufio.NewReader(flate.NewReader(c)).ReadString('\n'); err != nil || line != "Hello\n" {
+			t.Fatalf("\tShould echo the compressed request : %q %v %s", line, err, failed)
+		}
+		t.Log("\tShould switch the compression once the protocol negotiated it.", success)
+
+		rejected := make(chan error, 1)
+		s = tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  compressReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptCompression: tcp.OptCompression{
+				Compression: tcp.CompressGzip,
+			},
+			OptRequestLimit: tcp.OptRequestLimit{
+				MaxRequestBytes: 1024,
+				OnFrameTooLarge: func(tcpAddr *net.TCPAddr, err error) *tcp.Response {
+					rejected <- err
+					return nil
+				},
+			},
+		})
+
+		conn, err = s.Listener.Dial()
+		if err != nil {
+			t.Fatalf("\tShould dial the server : %v %s", err, failed)
+		}
+		defer conn.Close()
+
+		zw = gzip.NewWriter(conn)
+		zw.Write(bytes.Repeat([]byte("a"), 1<<20))
+		zw.Flush()
+
+		select {
+		case err := <-rejected:
+			if !errors.Is(err, tcp.ErrFrameTooLarge) {
+				t.Fatalf("\tShould reject the stream as too large : %v %s", err, failed)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("\tShould cap the decompressed bytes at MaxRequestBytes. %s", failed)
+		}
+		t.Log("\tShould cap the decompressed bytes at MaxRequestBytes.", success)
+
+		_, err = tcp.New("TEST", tcp.Config{
+			NetType:     "tcp4",
+			Addr:        ":0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptCompression: tcp.OptCompression{
+				Compression: "lz4",
+			},
+		})
+		if !errors.Is(err, tcp.ErrInvalidCompression) {
+			t.Fatalf("\tShould reject an unknown compressor : %v %s", err, failed)
+		}
+		t.Log("\tShould reject an unknown compressor.", success)
+	}
+}
+
+// TestChecksum tests frames are trailed with their checksum and corrupt
+// frames are reported.
+func TestChecksum(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to check the integrity of every frame.")
+	{
+		hs := tcp.Handlers[sumReq, int](tcp.ChecksumCodec[sumReq, int]{Codec: sumCodec{}}, func(r *tcp.Request, req sumReq) (int, error) {
+			return req.A + req.B, nil
+		})
+
+		var corrupt int32
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  hs.ReqHandler,
+			RespHandler: hs.RespHandler,
+
+			OptEvent: tcp.OptEvent{
+				Event: func(evt, typ int, ipAddress string, format string, a ...interface{}) {
+					if evt == tcp.EvtRead && strings.HasPrefix(format, "corrupt frame") {
+						atomic.AddInt32(&corrupt, 1)
+					}
+				},
+			},
+		})
+
+		conn, err := s.Listener.Dial()
+		if err != nil {
+			t.Fatalf("\tShould dial the server : %v %s", err, failed)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(time.Second))
+		reader := bufio.NewReader(conn)
+
+		roundTrip := func(frame []byte) string {
+			if _, err := conn.Write(frame); err != nil {
+				t.Fatalf("\tShould send the frame : %v %s", err, failed)
+			}
+			line, err := reader.ReadBytes('\n')
+			if err != nil {
+				t.Fatalf("\tShould receive a response : %v %s", err, failed)
+			}
+			trailer := make([]byte, 4)
+			if _, err := io.ReadFull(reader, trailer); err != nil {
+				t.Fatalf("\tShould receive the checksum : %v %s", err, failed)
+			}
+			want := tcp.AppendChecksum(append([]byte(nil), line...))
+			if !bytes.Equal(want[len(line):], trailer) {
+				t.Fatalf("\tShould trail the response with its checksum : %x %s", trailer, failed)
+			}
+			return string(line)
+		}
+
+		if resp := roundTrip(tcp.AppendChecksum([]byte("1 2\n"))); resp != "3\n" {
+			t.Fatalf("\tShould answer the frame : %q %s", resp, failed)
+		}
+		t.Log("\tShould check and trail the frames with their checksum.", success)
+
+		bad := tcp.AppendChecksum([]byte("1 2\n"))
+		bad[0] = '5'
+		conn.Write(bad)
+
+		if resp := roundTrip(tcp.AppendChecksum([]byte("2 2\n"))); resp != "4\n" {
+			t.Fatalf("\tShould skip the corrupt frame : %q %s", resp, failed)
+		}
+		if n := atomic.LoadInt32(&corrupt); n != 1 || s.Metrics().CorruptFrames != 1 {
+			t.Fatalf("\tShould report the corrupt frame : %d %s", n, failed)
+		}
+		t.Log("\tShould report and skip the corrupt frame.", success)
+	}
+}
+
+// TestBatch tests the small responses are coalesced into one write.
+func TestBatch(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to coalesce the small writes of a chatty protocol.")
+	{
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  twiceReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptBatch: tcp.OptBatch{
+				BatchDelay: 10 * time.Millisecond,
+			},
+		})
+
+		conn, err := s.Listener.Dial()
+		if err != nil {
+			t.Fatalf("\tShould dial the server : %v %s", err, failed)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(time.Second))
+
+		if _, err := conn.Write([]byte("Hello\n")); err != nil {
+			t.Fatalf("\tShould send the request : %v %s", err, failed)
+		}
+
+		// Both responses arrive with a single read since they were
+		// written to the connection together.
+		buf := make([]byte, 64)
+		n, err := conn.Read(buf)
+		if err != nil || string(buf[:n]) != "GOT IT\nGOT IT\n" {
+			t.Fatalf("\tShould receive the responses together : %q %v %s", buf[:n], err, failed)
+		}
+		if n := s.Metrics().WriteBatches; n != 1 {
+			t.Fatalf("\tShould count the batch : %d %s", n, failed)
+		}
+		t.Log("\tShould write the responses in one batch.", success)
+	}
+
+	t.Log("Given the need to deliver the batch of a dropped connection.")
+	{
+		// The batch waits for good since the clock never moves.
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  twiceReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptBatch: tcp.OptBatch{
+				BatchDelay: time.Second,
+			},
+			OptClock: tcp.OptClock{
+				Clock: tcptest.NewClock(time.Now()),
+			},
+		})
+
+		c := s.Dial(t, tcptest.Lines)
+		c.Send([]byte("Hello"))
+
+		// The responses are batched once processed.
+		m := s.Metrics()
+		for end := time.Now().Add(time.Second); (m.Requests == 0 || m.Processing != 0) && time.Now().Before(end); m = s.Metrics() {
+			time.Sleep(time.Millisecond)
+		}
+		if err := s.Drop(c.LocalAddr().(*net.TCPAddr)); err != nil {
+			t.Fatalf("\tShould drop the connection : %v %s", err, failed)
+		}
+
+		c.Expect([]byte("GOT IT"))
+		c.Expect([]byte("GOT IT"))
+		c.ExpectClosed()
+		t.Log("\tShould write the batch before closing the connection.", success)
+	}
+}
+
+// TestVectorWrite tests responses encoded into several buffers are
+// written together.
+func TestVectorWrite(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to write a header and a body without copying them.")
+	{
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: vectorRespHandler{},
+		})
+
+		c := s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("Hello"), []byte("6 Hello"))
+		c.RoundTrip([]byte("Hello World"), []byte("12 Hello World"))
+		t.Log("\tShould write the buffers of the responses in order.", success)
+	}
+}
+
+// TestProxySplice tests the bytes proxied between sockets move in the
+// kernel where the platform supports it.
+func TestProxySplice(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to proxy connections without copying the bytes.")
+	{
+		start := func(cfg tcp.Config) *tcp.TCP {
+			cfg.NetType = "tcp4"
+			cfg.Addr = "127.0.0.1:0"
+
+			u, err := tcp.New("TEST", cfg)
+			if err != nil {
+				t.Fatalf("\tShould be able to create a new TCP listener : %v %s", err, failed)
+			}
+			if err := u.Start(); err != nil {
+				t.Fatalf("\tShould be able to start the TCP listener : %v %s", err, failed)
+			}
+			return u
+		}
+
+		upstream := start(tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+		})
+		defer upstream.Stop()
+
+		u := start(tcp.Config{
+			OptProxy: tcp.OptProxy{
+				Upstreams: []string{upstream.Addr().String()},
+			},
+		})
+
+		conn, err := net.Dial("tcp4", u.Addr().String())
+		if err != nil {
+			t.Fatalf("\tShould be able to dial a new TCP connection : %v %s", err, failed)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		if _, err := conn.Write([]byte("Hello\n")); err != nil {
+			t.Fatalf("\tShould be able to send data to the connection : %v %s", err, failed)
+		}
+		if line, err := bufio.NewReader(conn).ReadString('\n'); err != nil || line != "Hello\n" {
+			t.Fatalf("\tShould forward the request : %q %v %s", line, err, failed)
+		}
+
+		want := tcp.ProxyCopy
+		if runtime.GOOS == "linux" {
+			want = tcp.ProxySplice
+		}
+		if stats := u.ClientStats(); len(stats) != 1 || stats[0].Proxy != want {
+			t.Fatalf("\tShould report how the bytes move : %+v %s", stats, failed)
+		}
+		t.Logf("\tShould move the bytes with %s. %s", want, success)
+
+		u.Stop()
+		if stats := u.ProxyStats(); len(stats) != 1 || stats[0].BytesUp != 6 || stats[0].BytesDown != 6 {
+			t.Fatalf("\tShould count the bytes forwarded : %+v %s", stats, failed)
+		}
+		t.Log("\tShould count the bytes forwarded.", success)
+	}
+}
+
+// TestPriority tests the requests of control connections are processed
+// before those of bulk connections.
+func TestPriority(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to keep control connections responsive under load.")
+	{
+		h := orderReqHandler{
+			gate:  make(chan struct{}),
+			order: make(chan string, 10),
+		}
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tagConnHandler{tag: "admin"},
+			ReqHandler:  h,
+			RespHandler: tcpRespHandler{},
+
+			OptPipeline: tcp.OptPipeline{
+				Pipeline: 1,
+				Workers:  1,
+			},
+			OptPriority: tcp.OptPriority{
+				PriorityTags: map[string]tcp.Priority{"admin": tcp.PriorityControl},
+				BulkWrites:   1,
+			},
+		})
+
+		busy := s.Dial(t, tcptest.Lines)
+		bulk := s.Dial(t, tcptest.Lines)
+		control := s.Dial(t, tcptest.Lines)
+
+		// Wait for the connections to be bound.
+		for _, c := range []*tcptest.Conn{busy, bulk, control} {
+			c.RoundTrip([]byte("ready"), []byte("ready"))
+			<-h.order
+		}
+
+		if err := s.SetPriority(bulk.LocalAddr().(*net.TCPAddr), tcp.PriorityBulk); err != nil {
+			t.Fatalf("\tShould change the priority of the connection : %v %s", err, failed)
+		}
+
+		priorities := make(map[string]tcp.Priority)
+		for _, st := range s.ClientStats() {
+			priorities[st.IP] = st.Priority
+		}
+		if priorities[control.LocalAddr().String()] != tcp.PriorityControl || priorities[bulk.LocalAddr().String()] != tcp.PriorityBulk {
+			t.Fatalf("\tShould report the priority of the connections : %v %s", priorities, failed)
+		}
+		t.Log("\tShould give the connections the priority of their tags.", success)
+
+		// Keep the only worker busy while the other requests queue up.
+		busy.Send([]byte("WAIT"))
+		time.Sleep(50 * time.Millisecond)
+		bulk.Send([]byte("bulk"))
+		time.Sleep(50 * time.Millisecond)
+		control.Send([]byte("control"))
+		time.Sleep(50 * time.Millisecond)
+		close(h.gate)
+
+		// The responses are read as they come since writing them
+		// holds the worker.
+		steps := []struct {
+			c    *tcptest.Conn
+			want string
+		}{
+			{busy, "WAIT"},
+			{control, "control"},
+			{bulk, "bulk"},
+		}
+		for _, step := range steps {
+			select {
+			case got := <-h.order:
+				if got != step.want {
+					t.Fatalf("\tShould process %q next : got %q %s", step.want, got, failed)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("\tShould process %q %s", step.want, failed)
+			}
+			step.c.Expect([]byte(step.want))
+		}
+		t.Log("\tShould process the control request before the bulk one.", success)
+	}
+}
+
+// TestLoad tests new connections are held back while the process is
+// overloaded.
+func TestLoad(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to protect the process from taking more than it can serve.")
+	{
+		h := orderReqHandler{
+			gate:  make(chan struct{}),
+			order: make(chan string, 10),
+		}
+		clock := tcptest.NewClock(time.Now())
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  h,
+			RespHandler: tcpRespHandler{},
+
+			OptLoad: tcp.OptLoad{
+				MaxProcessing: 1,
+			},
+			OptClock: tcp.OptClock{
+				Clock: clock,
+			},
+		})
+
+		eventually := func(cond func() bool) bool {
+			for end := time.Now().Add(time.Second); time.Now().Before(end); time.Sleep(time.Millisecond) {
+				if cond() {
+					return true
+				}
+			}
+			return false
+		}
+		overloaded := func() bool {
+			_, over, _ := s.Overloaded()
+			return over
+		}
+
+		busy := []*tcptest.Conn{s.Dial(t, tcptest.Lines), s.Dial(t, tcptest.Lines)}
+		for _, c := range busy {
+			c.Send([]byte("WAIT"))
+		}
+		if !eventually(func() bool { return s.Metrics().Processing == 2 }) {
+			t.Fatalf("\tShould process both requests %s", failed)
+		}
+
+		clock.Advance(time.Second)
+		if !eventually(overloaded) {
+			t.Fatalf("\tShould detect the overload %s", failed)
+		}
+
+		s.Dial(t, tcptest.Lines).ExpectClosed()
+		if n := s.Metrics().LoadRejects; n != 1 {
+			t.Fatalf("\tShould count the connection rejected : %d %s", n, failed)
+		}
+		t.Log("\tShould reject new connections while overloaded.", success)
+
+		close(h.gate)
+		for _, c := range busy {
+			c.Expect([]byte("WAIT"))
+		}
+		if !eventually(func() bool { return s.Metrics().Processing == 0 }) {
+			t.Fatalf("\tShould finish both requests %s", failed)
+		}
+
+		clock.Advance(time.Second)
+		if !eventually(func() bool { return !overloaded() }) {
+			t.Fatalf("\tShould recover once the load dropped %s", failed)
+		}
+
+		s.Dial(t, tcptest.Lines).RoundTrip([]byte("Hello"), []byte("Hello"))
+		t.Log("\tShould admit new connections once recovered.", success)
+	}
+}
+
+// TestMemory tests the bytes buffered by the connections are capped.
+func TestMemory(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	eventually := func(cond func() bool) bool {
+		for end := time.Now().Add(time.Second); time.Now().Before(end); time.Sleep(time.Millisecond) {
+			if cond() {
+				return true
+			}
+		}
+		return false
+	}
+
+	t.Log("Given the need to cap the bytes a connection buffers.")
+	{
+		h := orderReqHandler{
+			gate:  make(chan struct{}),
+			order: make(chan string, 10),
+		}
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  h,
+			RespHandler: tcpRespHandler{},
+
+			OptPipeline: tcp.OptPipeline{
+				Pipeline: 4,
+			},
+			OptMemory: tcp.OptMemory{
+				MaxConnBytes: 4,
+			},
+		})
+
+		// Both requests arrive in one write so the second one is
+		// buffered while the reads are held back.
+		c := s.Dial(t, tcptest.Lines)
+		c.Send([]byte("WAIT\nnext"))
+
+		if !eventually(func() bool { return s.Metrics().MemoryStalls == 1 }) {
+			t.Fatalf("\tShould hold back reading the next request : %+v %s", s.Metrics(), failed)
+		}
+		if m := s.Metrics(); m.BufferedBytes < 4 || m.BufferedBytesMax < 4 {
+			t.Fatalf("\tShould report the bytes buffered : %+v %s", m, failed)
+		}
+		if st := s.ClientStats(); len(st) != 1 || st[0].Buffered < 4 {
+			t.Fatalf("\tShould report the bytes buffered by the connection : %+v %s", st, failed)
+		}
+		t.Log("\tShould hold back reading while the connection is over its cap.", success)
+
+		close(h.gate)
+		c.Expect([]byte("WAIT"))
+		c.Expect([]byte("next"))
+		for _, want := range []string{"WAIT", "next"} {
+			if got := <-h.order; got != want {
+				t.Fatalf("\tShould process %q : got %q %s", want, got, failed)
+			}
+		}
+
+		if !eventually(func() bool { return s.Metrics().BufferedBytes == 0 }) {
+			t.Fatalf("\tShould release the bytes buffered : %+v %s", s.Metrics(), failed)
+		}
+		t.Log("\tShould read again once the bytes are released.", success)
+	}
+
+	t.Log("Given the need to close the connections over the cap.")
+	{
+		h := orderReqHandler{
+			gate:  make(chan struct{}),
+			order: make(chan string, 10),
+		}
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  h,
+			RespHandler: tcpRespHandler{},
+
+			OptPipeline: tcp.OptPipeline{
+				Pipeline: 4,
+			},
+			OptMemory: tcp.OptMemory{
+				MaxBufferedBytes: 4,
+				CloseOverMemory:  true,
+			},
+		})
+
+		c := s.Dial(t, tcptest.Lines)
+		c.Send([]byte("WAIT\nnext"))
+		time.Sleep(50 * time.Millisecond)
+		close(h.gate)
+		c.Expect([]byte("WAIT"))
+
+		if !eventually(func() bool { return s.Metrics().Closes[tcp.CloseMemoryLimit] == 1 }) {
+			t.Fatalf("\tShould close the connection over the cap : %+v %s", s.Metrics(), failed)
+		}
+		if got := <-h.order; got != "WAIT" {
+			t.Fatalf("\tShould only process the request read : got %q %s", got, failed)
+		}
+		t.Log("\tShould close the connection once over the cap.", success)
+	}
+
+	t.Log("Given the need to drop a connection held back by the caps.")
+	{
+		h := orderReqHandler{
+			gate:  make(chan struct{}),
+			order: make(chan string, 10),
+		}
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  h,
+			RespHandler: tcpRespHandler{},
+
+			OptMemory: tcp.OptMemory{
+				MaxBufferedBytes: 4,
+			},
+		})
+		defer close(h.gate)
+
+		// The request held by the handler keeps the other connection
+		// over the cap.
+		busy := s.Dial(t, tcptest.Lines)
+		busy.Send([]byte("WAIT"))
+		if !eventually(func() bool { return s.Metrics().BufferedBytes >= 4 }) {
+			t.Fatalf("\tShould buffer the request held : %+v %s", s.Metrics(), failed)
+		}
+
+		c := s.Dial(t, tcptest.Lines)
+		if !eventually(func() bool { return s.Metrics().MemoryStalls == 1 }) {
+			t.Fatalf("\tShould hold back reading the other connection : %+v %s", s.Metrics(), failed)
+		}
+
+		if err := s.Drop(c.LocalAddr().(*net.TCPAddr)); err != nil {
+			t.Fatalf("\tShould drop the connection : %v %s", err, failed)
+		}
+		c.ExpectClosed()
+		if !eventually(func() bool { return s.Metrics().Closes[tcp.CloseDropped] == 1 }) {
+			t.Fatalf("\tShould stop waiting once dropped : %+v %s", s.Metrics(), failed)
+		}
+		t.Log("\tShould stop waiting once the connection is dropped.", success)
+	}
+}
+
+// TestDropMode tests the way dropped connections are closed.
+func TestDropMode(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	start := func(drop tcp.OptDrop) *tcp.TCP {
+		cfg := tcp.Config{
+			NetType:     "tcp4",
+			Addr:        "127.0.0.1:0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+			OptDrop:     drop,
+		}
+
+		u, err := tcp.New("TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+		u.DropConnections(true)
+
+		if err := u.Start(); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", failed, err)
+		}
+		return u
+	}
+
+	t.Log("Given the need to reset dropped connections.")
+	{
+		u := start(tcp.OptDrop{DropMode: tcp.DropRST})
+		defer u.Stop()
+
+		// The reset can come before the dial returns.
+		conn, err := net.Dial("tcp4", u.Addr().String())
+		if err == nil {
+			defer conn.Close()
+
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			b := make([]byte, 1)
+			_, err = conn.Read(b)
+		}
+		if !errors.Is(err, syscall.ECONNRESET) {
+			t.Fatalf("\tShould read a reset : %v %s", err, failed)
+		}
+		t.Log("\tShould read a reset.", success)
+	}
+
+	t.Log("Given the need to tell dropped connections why.")
+	{
+		u := start(tcp.OptDrop{DropMode: tcp.DropBanner, DropMsg: []byte("busy\n")})
+		defer u.Stop()
+
+		conn, err := net.Dial("tcp4", u.Addr().String())
+		if err != nil {
+			t.Fatal("\tShould be able to dial a new TCP connection.", failed, err)
+		}
+		defer conn.Close()
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		r := bufio.NewReader(conn)
+		if banner, err := r.ReadString('\n'); err != nil || banner != "busy\n" {
+			t.Fatalf("\tShould read the banner : %q %v %s", banner, err, failed)
+		}
+		if _, err := r.ReadByte(); err != io.EOF {
+			t.Fatalf("\tShould read the connection closed : %v %s", err, failed)
+		}
+		t.Log("\tShould read the banner before the connection closes.", success)
+	}
+
+	t.Log("Given the need to validate the drop mode.")
+	{
+		cfg := tcp.Config{
+			NetType:     "tcp4",
+			Addr:        "127.0.0.1:0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+			OptDrop:     tcp.OptDrop{DropMode: tcp.DropBanner},
+		}
+
+		if _, err := tcp.New("TEST", cfg); !errors.Is(err, tcp.ErrInvalidConfiguration) {
+			t.Fatalf("\tShould reject a banner mode without a banner : %v %s", err, failed)
+		}
+		t.Log("\tShould reject a banner mode without a banner.", success)
+	}
+}
+
+// TestTarpit tests the connections of flagged IPs crawl.
+func TestTarpit(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to waste the time of abusive clients.")
+	{
+		const delay = 20 * time.Millisecond
+
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptTarpit: tcp.OptTarpit{
+				TarpitDelay: delay,
+				TarpitBytes: 2,
+			},
+		})
+
+		ip := net.IPv4(127, 0, 0, 1)
+		s.Tarpit(ip, 0)
+		if !s.Tarpitted(ip) {
+			t.Fatalf("\tShould flag the IP %s", failed)
+		}
+
+		// The request and the response move 2 bytes per delay.
+		start := time.Now()
+		c := s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("hello"), []byte("GOT IT"))
+		if took := time.Since(start); took < 6*delay {
+			t.Fatalf("\tShould delay the reads and writes : took %v %s", took, failed)
+		}
+		if m := s.Metrics(); m.Tarpitted != 1 {
+			t.Fatalf("\tShould count the connection in the tarpit : %d %s", m.Tarpitted, failed)
+		}
+		t.Log("\tShould delay the reads and writes of the flagged IP.", success)
+
+		s.Untarpit(ip)
+		c = s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("hello"), []byte("GOT IT"))
+		if m := s.Metrics(); m.Tarpitted != 1 {
+			t.Fatalf("\tShould not tarpit the IP once removed : %d %s", m.Tarpitted, failed)
+		}
+		t.Log("\tShould serve the IP normally once its flag is removed.", success)
+	}
+
+	t.Log("Given the need for the flags to expire.")
+	{
+		clock := tcptest.NewClock(time.Now())
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptTarpit: tcp.OptTarpit{
+				TarpitFor: time.Minute,
+			},
+			OptClock: tcp.OptClock{
+				Clock: clock,
+			},
+		})
+
+		ip := net.IPv4(10, 0, 0, 1)
+		s.Tarpit(ip, 0)
+		clock.Advance(30 * time.Second)
+		if !s.Tarpitted(ip) {
+			t.Fatalf("\tShould keep the flag before it expires %s", failed)
+		}
+
+		clock.Advance(30 * time.Second)
+		if s.Tarpitted(ip) {
+			t.Fatalf("\tShould expire the flag after TarpitFor %s", failed)
+		}
+		t.Log("\tShould expire the flag after TarpitFor.", success)
+	}
+}
+
+// TestBan tests IPs are banned for their strikes.
+func TestBan(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to ban abusive clients.")
+	{
+		bans := make(chan string, 1)
+		clock := tcptest.NewClock(time.Now())
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptRequestLimit: tcp.OptRequestLimit{
+				MaxRequestBytes: 8,
+			},
+			OptBan: tcp.OptBan{
+				BanStrikes: 2,
+				BanFor:     time.Minute,
+				OnBan: func(ip net.IP, reason string) {
+					bans <- reason
+				},
+			},
+			OptClock: tcp.OptClock{
+				Clock: clock,
+			},
+		})
+
+		ip := net.IPv4(127, 0, 0, 1)
+		if s.Strike(ip, "auth failure") {
+			t.Fatalf("\tShould not ban the IP on its first strike %s", failed)
+		}
+
+		// The request too large is the second strike.
+		c := s.Dial(t, tcptest.Lines)
+		c.Send([]byte("Hello World"))
+		c.ExpectClosed()
+
+		select {
+		case reason := <-bans:
+			if reason != tcp.StrikeFrameTooLarge {
+				t.Fatalf("\tShould ban the IP for its last strike : %q %s", reason, failed)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("\tShould ban the IP %s", failed)
+		}
+		if !s.Banned(ip) {
+			t.Fatalf("\tShould report the IP banned %s", failed)
+		}
+		t.Log("\tShould ban the IP once it has enough strikes.", success)
+
+		conn, err := s.Listener.Dial()
+		if err != nil {
+			t.Fatalf("\tShould dial the server : %v %s", err, failed)
+		}
+		defer conn.Close()
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("\tShould close the connection of the banned IP : %v %s", err, failed)
+		}
+		if m := s.Metrics(); m.Bans != 1 || m.BanRejects != 1 {
+			t.Fatalf("\tShould count the ban and the rejection : %+v %s", m, failed)
+		}
+		t.Log("\tShould close the new connections of the banned IP.", success)
+
+		clock.Advance(time.Minute)
+		if s.Banned(ip) {
+			t.Fatalf("\tShould lift the ban after BanFor %s", failed)
+		}
+		c = s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("Hello"), []byte("Hello"))
+		t.Log("\tShould serve the IP again once the ban expires.", success)
+
+		s.Strike(ip, "auth failure")
+		clock.Advance(time.Minute)
+		if s.Strike(ip, "auth failure") {
+			t.Fatalf("\tShould forget the strikes older than the window %s", failed)
+		}
+		clock.Advance(40 * time.Second)
+		if !s.Strike(ip, "auth failure") {
+			t.Fatalf("\tShould count the strikes within the window ending now %s", failed)
+		}
+		t.Log("\tShould count the strikes within a sliding window.", success)
+	}
+}
+
+// TestGeoPolicy tests the geo policy denies and tags connections.
+func TestGeoPolicy(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to decide on connections by their location.")
+	{
+		decisions := make(chan tcp.GeoDecision, 1)
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tagsReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptGeo: tcp.OptGeo{
+				GeoPolicy: func(ip net.IP) (tcp.GeoDecision, error) {
+					if !ip.Equal(net.IPv4(127, 0, 0, 1)) {
+						return tcp.GeoDecision{}, fmt.Errorf("unexpected ip %s", ip)
+					}
+					return <-decisions, nil
+				},
+			},
+		})
+
+		decisions <- tcp.GeoDecision{Tags: []string{"country:NL"}}
+		c := s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("Hello"), []byte("country:NL"))
+
+		stats := s.TagStats()
+		if len(stats) != 1 || stats[0].Tag != "country:NL" || stats[0].Connections != 1 {
+			t.Fatalf("\tShould report the tag in the stats : %+v %s", stats, failed)
+		}
+		t.Log("\tShould tag the connection for the handlers and the stats.", success)
+
+		decisions <- tcp.GeoDecision{Deny: true}
+		c = s.Dial(t, tcptest.Lines)
+		c.ExpectClosed()
+
+		// The close is counted once the connection is released.
+		m := s.Metrics()
+		for end := time.Now().Add(time.Second); m.Closes[tcp.CloseGeoDenied] == 0 && time.Now().Before(end); m = s.Metrics() {
+			time.Sleep(time.Millisecond)
+		}
+		if m.GeoDenied != 1 || m.Closes[tcp.CloseGeoDenied] != 1 {
+			t.Fatalf("\tShould count the connection denied : %+v %s", m, failed)
+		}
+		t.Log("\tShould close the connection the policy denies.", success)
+	}
+}
+
+// TestConnect tests HTTP CONNECT requests are tunneled to their host.
+func TestConnect(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to tunnel the connections of HTTP proxy clients.")
+	{
+		upstream := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+		})
+
+		dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if addr != "echo:7" {
+				return nil, errors.New("connection refused")
+			}
+			return upstream.Listener.Dial()
+		}
+
+		s := tcptest.NewServer(t, tcp.Config{
+			OptProxy: tcp.OptProxy{
+				ProxyDial: dial,
+			},
+			OptConnect: tcp.OptConnect{
+				Connect: true,
+				ConnectAllow: func(addr string) bool {
+					return addr != "secret:22"
+				},
+			},
+		})
+
+		call := func(req string) (*http.Response, *bufio.Reader, net.Conn) {
+			conn, err := s.Listener.Dial()
+			if err != nil {
+				t.Fatalf("\tShould dial the server : %v %s", err, failed)
+			}
+			conn.SetDeadline(time.Now().Add(time.Second))
+
+			go conn.Write([]byte(req))
+
+			br := bufio.NewReader(conn)
+			resp, err := http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatalf("\tShould answer the request : %v %s", err, failed)
+			}
+			return resp, br, conn
+		}
+
+		resp, br, conn := call("CONNECT echo:7 HTTP/1.1\r\nHost: echo:7\r\n\r\nHello\n")
+		defer conn.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("\tShould establish the tunnel : %s %s", resp.Status, failed)
+		}
+		if line, err := br.ReadString('\n'); err != nil || line != "Hello\n" {
+			t.Fatalf("\tShould tunnel the bytes sent with the request : %q %v %s", line, err, failed)
+		}
+		t.Log("\tShould tunnel the connection to the host requested.", success)
+
+		for _, tt := range []struct {
+			req  string
+			want int
+		}{
+			{"CONNECT secret:22 HTTP/1.1\r\nHost: secret:22\r\n\r\n", http.StatusForbidden},
+			{"CONNECT down:1 HTTP/1.1\r\nHost: down:1\r\n\r\n", http.StatusBadGateway},
+			{"GET / HTTP/1.1\r\nHost: echo:7\r\n\r\n", http.StatusMethodNotAllowed},
+		} {
+			resp, _, conn := call(tt.req)
+			conn.Close()
+			if resp.StatusCode != tt.want {
+				t.Fatalf("\tShould answer %q with %d : %s %s", tt.req, tt.want, resp.Status, failed)
+			}
+		}
+		t.Log("\tShould refuse the requests that can't be tunneled.", success)
+
+		if stats := s.ProxyStats(); len(stats) != 1 || stats[0].Addr != tcp.UpstreamConnect || stats[0].Dials != 2 || stats[0].DialErrors != 1 {
+			t.Fatalf("\tShould report the tunnels together : %+v %s", stats, failed)
+		}
+		t.Log("\tShould report the tunnels together.", success)
+
+		cfg := tcp.Config{
+			NetType:    "tcp4",
+			Addr:       "127.0.0.1:0",
+			OptConnect: tcp.OptConnect{Connect: true},
+		}
+		if _, err := tcp.New("TEST", cfg); !errors.Is(err, tcp.ErrInvalidProxy) {
+			t.Fatalf("\tShould require the hosts allowed : %v %s", err, failed)
+		}
+		t.Log("\tShould require the hosts allowed.", success)
+	}
+}
+
+// TestSystemdNotify tests systemd is told when the server is ready and
+// when it stops.
+func TestSystemdNotify(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unixgram sockets are not supported")
+	}
+
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to integrate with the units of systemd.")
+	{
+		name := filepath.Join(t.TempDir(), "notify")
+		sock, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: name, Net: "unixgram"})
+		if err != nil {
+			t.Fatalf("\tShould listen for the notifications : %v %s", err, failed)
+		}
+		defer sock.Close()
+		t.Setenv("NOTIFY_SOCKET", name)
+
+		cfg := tcp.Config{
+			NetType:     "tcp4",
+			Addr:        "127.0.0.1:0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptInherit: tcp.OptInherit{
+				SystemdNotify: true,
+			},
+		}
+
+		u, err := tcp.New("TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+
+		recv := func() string {
+			b := make([]byte, 64)
+			sock.SetReadDeadline(time.Now().Add(time.Second))
+			n, err := sock.Read(b)
+			if err != nil {
+				t.Fatalf("\tShould receive a notification : %v %s", err, failed)
+			}
+			return string(b[:n])
+		}
+
+		if err := u.Start(); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", failed, err)
+		}
+		if state := recv(); state != "READY=1" {
+			t.Fatalf("\tShould notify the server is ready : %q %s", state, failed)
+		}
+		t.Log("\tShould notify the server is ready once started.", success)
+
+		u.Stop()
+		if state := recv(); state != "STOPPING=1" {
+			t.Fatalf("\tShould notify the server is stopping : %q %s", state, failed)
+		}
+		t.Log("\tShould notify the server is stopping.", success)
+	}
+}
+
+// TestMigrate tests a connection is handed off to another handler set.
+func TestMigrate(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to switch the handlers of a connection once negotiated.")
+	{
+		cfg := tcp.Config{
+			NetType:     "tcp4",
+			Addr:        ":0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  migrateReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptMigrate: tcp.OptMigrate{
+				HandlerSets: map[string]tcp.HandlerSet{
+					"alt": {ReqHandler: altReqHandler{}},
+				},
+			},
+		}
+
+		u, err := tcp.New("TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+		t.Log("\tShould be able to create a new TCP listener.", success)
+
+		if err := u.Start(); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", success)
+
+		defer u.Stop()
+
+		if err := u.Migrate(&net.TCPAddr{}, "nope"); !errors.Is(err, tcp.ErrUnknownHandlerSet) {
+			t.Error("\tShould refuse a handler set that isn't registered.", failed, err)
+		} else {
+			t.Log("\tShould refuse a handler set that isn't registered.", success)
+		}
+
+		conn, err := net.Dial("tcp4", u.Addr().String())
+		if err != nil {
+			t.Fatal("\tShould be able to dial a new TCP connection.", failed, err)
+		}
+		defer conn.Close()
+
+		// The line after the command is read along with it and must be
+		// served to the new handlers.
+		if _, err := conn.Write([]byte("hello\nMIGRATE alt\nhello\n")); err != nil {
+			t.Fatal("\tShould be able to send data to the connection.", failed, err)
+		}
+
+		r := bufio.NewReader(conn)
+		for _, want := range []string{"hello\n", "OK\n", "ALT\n"} {
+			line, err := r.ReadString('\n')
+			if err != nil || line != want {
+				t.Fatalf("\tShould receive %q.%s %q %v", want, failed, line, err)
+			}
+			t.Logf("\tShould receive %q.%s", want, success)
+		}
+	}
+}
+
+// TestAuth tests the clients are authenticated before their requests are
+// read.
+func TestAuth(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to authenticate the clients with a handshake.")
+	{
+		cfg := tcp.Config{
+			NetType:     "tcp4",
+			Addr:        ":0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  identityReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptAuth: tcp.OptAuth{
+				AuthHandler: tokenAuthHandler{token: "secret", user: "bill"},
+				AuthTimeout: 100 * time.Millisecond,
+			},
+		}
+
+		u, err := tcp.New("TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+		t.Log("\tShould be able to create a new TCP listener.", success)
+
+		if err := u.Start(); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", success)
+
+		defer u.Stop()
+
+		dial := func(send string) (string, error) {
+			conn, err := net.Dial("tcp4", u.Addr().String())
+			if err != nil {
+				t.Fatal("\tShould be able to dial a new TCP connection.", failed, err)
+			}
+			defer conn.Close()
+
+			if send != "" {
+				if _, err := conn.Write([]byte(send)); err != nil {
+					t.Fatal("\tShould be able to send data to the connection.", failed, err)
+				}
+			}
+
+			b, err := io.ReadAll(io.LimitReader(conn, 13))
+			return string(b), err
+		}
+
+		if got, err := dial("secret\nhello\n"); got != "WELCOME\nbill\n" {
+			t.Error("\tShould serve the client with its identity once authenticated.", failed, got, err)
+		} else {
+			t.Log("\tShould serve the client with its identity once authenticated.", success)
+		}
+
+		if got, _ := dial("guess\nhello\n"); got != "" {
+			t.Error("\tShould close the client failing to authenticate.", failed, got)
+		} else {
+			t.Log("\tShould close the client failing to authenticate.", success)
+		}
+
+		if got, _ := dial(""); got != "" {
+			t.Error("\tShould close the client not authenticating in time.", failed, got)
+		} else {
+			t.Log("\tShould close the client not authenticating in time.", success)
+		}
+
+		// The close is counted once the connection is released.
+		m := u.Metrics()
+		for end := time.Now().Add(time.Second); m.Closes[tcp.CloseAuthFailed] < 2 && time.Now().Before(end); m = u.Metrics() {
+			time.Sleep(time.Millisecond)
+		}
+		if m.Closes[tcp.CloseAuthFailed] != 2 {
+			t.Fatalf("\tShould count the clients failing to authenticate : %+v %s", m.Closes, failed)
+		}
+		t.Log("\tShould count the clients failing to authenticate.", success)
+	}
+}
+
+// TestQuota tests the identities are held to their quotas.
+func TestQuota(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to enforce the plans of the tenants.")
+	{
+		clock := tcptest.NewClock(time.Now())
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  identityReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptAuth: tcp.OptAuth{
+				AuthHandler: tokenAuthHandler{token: "secret", user: "bill"},
+			},
+			OptQuota: tcp.OptQuota{
+				Quotas: func(identity string) tcp.Quota {
+					return tcp.Quota{RequestsPerSec: 2, Connections: 1}
+				},
+				OnQuotaExceeded: func(tcpAddr *net.TCPAddr, err error) *tcp.Response {
+					if !errors.Is(err, tcp.ErrRateLimited) {
+						return nil
+					}
+					return &tcp.Response{Data: []byte("QUOTA\n"), Length: 6}
+				},
+			},
+			OptClock: tcp.OptClock{
+				Clock: clock,
+			},
+		})
+
+		c := s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("secret"), []byte("WELCOME"))
+		c.RoundTrip([]byte("hello"), []byte("bill"))
+		c.RoundTrip([]byte("hello"), []byte("bill"))
+		c.RoundTrip([]byte("hello"), []byte("QUOTA"))
+		t.Log("\tShould reject the requests over the quota per second.", success)
+
+		clock.Advance(time.Second)
+		c.RoundTrip([]byte("hello"), []byte("bill"))
+		t.Log("\tShould serve the requests again in the next second.", success)
+
+		other := s.Dial(t, tcptest.Lines)
+		other.RoundTrip([]byte("secret"), []byte("WELCOME"))
+		other.Expect([]byte("QUOTA"))
+		other.ExpectClosed()
+		t.Log("\tShould close the connections over the quota of connections.", success)
+
+		if m := s.Metrics(); m.QuotaRejects != 2 {
+			t.Fatalf("\tShould count the rejections : %d %s", m.QuotaRejects, failed)
+		}
+		t.Log("\tShould count the rejections.", success)
+
+		// The connection closed gives its place back.
+		c.Close()
+		for end := time.Now().Add(time.Second); s.Connections() > 0 && time.Now().Before(end); {
+			time.Sleep(time.Millisecond)
+		}
+
+		again := s.Dial(t, tcptest.Lines)
+		again.RoundTrip([]byte("secret"), []byte("WELCOME"))
+		again.RoundTrip([]byte("hello"), []byte("bill"))
+		t.Log("\tShould give the place of a closed connection back.", success)
+	}
+}
+
+// TestStore tests servers sharing a store share their bans and quotas.
+func TestStore(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to share the limits over a fleet of servers.")
+	{
+		store := tcp.NewMemoryStore()
+		newServer := func() *tcptest.Server {
+			return tcptest.NewServer(t, tcp.Config{
+				ConnHandler: tcpConnHandler{},
+				ReqHandler:  identityReqHandler{},
+				RespHandler: tcpRespHandler{},
+
+				OptBan: tcp.OptBan{
+					BanStrikes: 1,
+				},
+				OptAuth: tcp.OptAuth{
+					AuthHandler: tokenAuthHandler{token: "secret", user: "bill"},
+				},
+				OptQuota: tcp.OptQuota{
+					Quotas: func(identity string) tcp.Quota {
+						return tcp.Quota{Connections: 1}
+					},
+				},
+				OptStore: tcp.OptStore{
+					Store: store,
+				},
+			})
+		}
+		a, b := newServer(), newServer()
+
+		c := a.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("secret"), []byte("WELCOME"))
+		c.RoundTrip([]byte("hello"), []byte("bill"))
+
+		other := b.Dial(t, tcptest.Lines)
+		other.RoundTrip([]byte("secret"), []byte("WELCOME"))
+		other.ExpectClosed()
+		t.Log("\tShould hold the identity to its quota over both servers.", success)
+
+		ip := net.IPv4(127, 0, 0, 1)
+		if !a.Strike(ip, "auth failure") {
+			t.Fatalf("\tShould ban the IP on its strike %s", failed)
+		}
+		if !b.Banned(ip) {
+			t.Fatalf("\tShould see the ban from the other server %s", failed)
+		}
+		t.Log("\tShould see the ban from the other server.", success)
+
+		b.Unban(ip)
+		if a.Banned(ip) {
+			t.Fatalf("\tShould see the ban lifted by the other server %s", failed)
+		}
+		t.Log("\tShould see the ban lifted by the other server.", success)
+
+		now := time.Now()
+		store.Add("count", now, time.Second, 5)
+		if n, _ := store.Add("count", now.Add(time.Second), time.Second, 1); n != 1 {
+			t.Fatalf("\tShould count again in the next window : %d %s", n, failed)
+		}
+		t.Log("\tShould count again in the next window.", success)
+	}
+}
+
+// TestPush tests responses are pushed to an identity connected to another
+// server of the cluster.
+func TestPush(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to push to clients connected anywhere in a cluster.")
+	{
+		registry := tcp.NewMemoryRegistry()
+		servers := make(map[string]*tcptest.Server)
+
+		newServer := func(node string) *tcptest.Server {
+			return tcptest.NewServer(t, tcp.Config{
+				ConnHandler: tcpConnHandler{},
+				ReqHandler:  identityReqHandler{},
+				RespHandler: tcpRespHandler{},
+
+				OptAuth: tcp.OptAuth{
+					AuthHandler: tokenAuthHandler{token: "secret", user: "bill"},
+				},
+				OptCluster: tcp.OptCluster{
+					Registry: registry,
+					Node:     node,
+					Forward: func(ctx context.Context, node, identity string, r *tcp.Response) error {
+						return servers[node].PushLocal(ctx, identity, r)
+					},
+				},
+			})
+		}
+		servers["a"] = newServer("a")
+		servers["b"] = newServer("b")
+
+		c := servers["a"].Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("secret"), []byte("WELCOME"))
+
+		// The identity is registered once the authentication returns.
+		lookup := func(want int) []string {
+			nodes, _ := registry.Lookup("bill")
+			for end := time.Now().Add(time.Second); len(nodes) != want && time.Now().Before(end); nodes, _ = registry.Lookup("bill") {
+				time.Sleep(time.Millisecond)
+			}
+			return nodes
+		}
+
+		if nodes := lookup(1); len(nodes) != 1 || nodes[0] != "a" {
+			t.Fatalf("\tShould register the identity with its node : %v %s", nodes, failed)
+		}
+		t.Log("\tShould register the identity with its node.", success)
+
+		// The push is written once the client reads it.
+		resp := tcp.Response{Data: []byte("PUSH\n"), Length: 5}
+		pushed := make(chan error, 1)
+		go func() {
+			pushed <- servers["b"].Push(context.Background(), "bill", &resp)
+		}()
+		c.Expect([]byte("PUSH"))
+		if err := <-pushed; err != nil {
+			t.Fatalf("\tShould push through the other node : %v %s", err, failed)
+		}
+		t.Log("\tShould push through the other node.", success)
+
+		if err := servers["b"].Push(context.Background(), "jill", &resp); !errors.Is(err, tcp.ErrDisconnected) {
+			t.Fatalf("\tShould fail to push to an identity not connected : %v %s", err, failed)
+		}
+		t.Log("\tShould fail to push to an identity not connected.", success)
+
+		c.Close()
+		if nodes := lookup(0); len(nodes) != 0 {
+			t.Fatalf("\tShould deregister the identity once disconnected : %v %s", nodes, failed)
+		}
+		t.Log("\tShould deregister the identity once disconnected.", success)
+	}
+}
+
+// TestAdmin tests operators control the server through the admin socket.
+func TestAdmin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix sockets are not supported")
+	}
+
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to control a running server without building a control plane.")
+	{
+		sock := filepath.Join(t.TempDir(), "admin")
+		cfg := tcp.Config{
+			NetType:     "tcp4",
+			Addr:        ":0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptAdmin: tcp.OptAdmin{
+				AdminSocket: sock,
+			},
+		}
+
+		u, err := tcp.New("TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+		t.Log("\tShould be able to create a new TCP listener.", success)
+
+		if err := u.Start(); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", success)
+
+		defer u.Stop()
+
+		conn, err := net.Dial("tcp4", u.Addr().String())
+		if err != nil {
+			t.Fatal("\tShould be able to dial a new TCP connection.", failed, err)
+		}
+		defer conn.Close()
+
+		cr := bufio.NewReader(conn)
+		conn.Write([]byte("Hello\n"))
+		if _, err := cr.ReadString('\n'); err != nil {
+			t.Fatal("\tShould be able to read the response from the connection.", failed, err)
+		}
+
+		admin, err := net.Dial("unix", sock)
+		if err != nil {
+			t.Fatal("\tShould be able to dial the admin socket.", failed, err)
+		}
+		defer admin.Close()
+
+		// command sends the command and returns its output lines and
+		// the status line.
+		ar := bufio.NewReader(admin)
+		command := func(cmd string) ([]string, string) {
+			admin.SetDeadline(time.Now().Add(5 * time.Second))
+			if _, err := admin.Write([]byte(cmd + "\n")); err != nil {
+				t.Fatal("\tShould be able to send the admin command.", failed, err)
+			}
+
+			var lines []string
+			for {
+				line, err := ar.ReadString('\n')
+				if err != nil {
+					t.Fatal("\tShould be able to read the admin answer.", failed, err)
+				}
+				line = strings.TrimSuffix(line, "\n")
+				if line == "OK" || strings.HasPrefix(line, "ERR ") {
+					return lines, line
+				}
+				lines = append(lines, line)
+			}
+		}
+
+		lines, status := command("stats")
+		var m tcp.Metrics
+		if status != "OK" || len(lines) != 1 || json.Unmarshal([]byte(lines[0]), &m) != nil || m.Requests != 1 {
+			t.Fatal("\tShould answer the stats as JSON.", failed, status, lines)
+		}
+		t.Log("\tShould answer the stats as JSON.", success)
+
+		lines, status = command("conns")
+		if status != "OK" || len(lines) != 1 || !strings.HasPrefix(lines[0], conn.LocalAddr().String()+" ") {
+			t.Fatal("\tShould list the connections.", failed, status, lines)
+		}
+		t.Log("\tShould list the connections.", success)
+
+		if _, status = command("kick " + conn.LocalAddr().String()); status != "OK" {
+			t.Fatal("\tShould kick the connection.", failed, status)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := cr.ReadString('\n'); err != io.EOF {
+			t.Fatal("\tShould kick the connection.", failed, err)
+		}
+		t.Log("\tShould kick the connection.", success)
+
+		if _, status = command("drop on"); status != "OK" {
+			t.Fatal("\tShould drop the new connections.", failed, status)
+		}
+		if _, status = command("ratelimit 1s"); status != "OK" {
+			t.Fatal("\tShould adjust the rate limit.", failed, status)
+		}
+		if _, status = command("drain 1s"); status != "OK" {
+			t.Fatal("\tShould drain the connections.", failed, status)
+		}
+		t.Log("\tShould drop the new connections, adjust the rate limit and drain.", success)
+
+		if _, status = command("ratelimit -1s"); !strings.HasPrefix(status, "ERR ") {
+			t.Fatal("\tShould refuse an invalid command.", failed, status)
+		}
+		if _, status = command("bogus"); !strings.HasPrefix(status, "ERR ") {
+			t.Fatal("\tShould refuse an invalid command.", failed, status)
+		}
+		t.Log("\tShould refuse an invalid command.", success)
+
+		if fi, err := os.Lstat(sock); err != nil || fi.Mode().Perm() != 0600 {
+			t.Fatal("\tShould only let the user connect to the admin socket.", failed, fi, err)
+		}
+		t.Log("\tShould only let the user connect to the admin socket.", success)
+
+		file := filepath.Join(t.TempDir(), "file")
+		os.WriteFile(file, []byte("keep"), 0600)
+		for _, path := range []string{sock, file} {
+			cfg.AdminSocket = path
+			other, err := tcp.New("OTHER", cfg)
+			if err != nil {
+				t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+			}
+			if err := other.Start(); err == nil {
+				other.Stop()
+				t.Fatal("\tShould not take over a live socket or a file.", failed, path)
+			}
+		}
+		if data, err := os.ReadFile(file); err != nil || string(data) != "keep" {
+			t.Fatal("\tShould not take over a live socket or a file.", failed, err)
+		}
+		t.Log("\tShould not take over a live socket or a file.", success)
+	}
+}
+
+// TestKick tests the connections a predicate matches are closed.
+func TestKick(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to clean up connections in bulk.")
+	{
+		var n int32
+		clock := tcptest.NewClock(time.Now())
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptTags: tcp.OptTags{
+				Tags: func(conn net.Conn) []string {
+					if atomic.AddInt32(&n, 1) == 1 {
+						return []string{"first"}
+					}
+					return nil
+				},
+			},
+			OptClock: tcp.OptClock{
+				Clock: clock,
+			},
+		})
+
+		first := s.Dial(t, tcptest.Lines)
+		first.RoundTrip([]byte("hello"), []byte("hello"))
+		busy := s.Dial(t, tcptest.Lines)
+		busy.RoundTrip([]byte("hello"), []byte("hello"))
+		idle := s.Dial(t, tcptest.Lines)
+		idle.RoundTrip([]byte("hello"), []byte("hello"))
+
+		if n := s.Kick(tcp.MatchTag("first")); n != 1 {
+			t.Fatalf("\tShould kick the connection with the tag : %d %s", n, failed)
+		}
+		first.ExpectClosed()
+		t.Log("\tShould kick the connection with the tag.", success)
+
+		clock.Advance(time.Minute)
+		busy.RoundTrip([]byte("hello"), []byte("hello"))
+
+		if n := s.Kick(tcp.MatchIdle(clock.Now().Add(-30 * time.Second))); n != 1 {
+			t.Fatalf("\tShould kick the idle connection : %d %s", n, failed)
+		}
+		idle.ExpectClosed()
+		busy.RoundTrip([]byte("hello"), []byte("hello"))
+		t.Log("\tShould kick the idle connection.", success)
+
+		_, other, _ := net.ParseCIDR("10.0.0.0/8")
+		_, local, _ := net.ParseCIDR("127.0.0.0/8")
+		if n := s.Kick(tcp.MatchCIDR(other)); n != 0 {
+			t.Fatalf("\tShould kick the connections from the network only : %d %s", n, failed)
+		}
+		if n := s.Kick(tcp.MatchCIDR(local)); n != 1 {
+			t.Fatalf("\tShould kick the connections from the network only : %d %s", n, failed)
+		}
+		busy.ExpectClosed()
+		t.Log("\tShould kick the connections from the network only.", success)
+	}
+
+	t.Log("Given the need to kick a connection that stopped reading.")
+	{
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+		})
+
+		// The in-memory connection blocks the response until it's read.
+		c := s.Dial(t, tcptest.Lines)
+		c.Send([]byte("hello"))
+		for end := time.Now().Add(time.Second); s.Metrics().Requests == 0 && time.Now().Before(end); {
+			time.Sleep(time.Millisecond)
+		}
+
+		kicked := make(chan int, 1)
+		go func() {
+			kicked <- s.Kick(func(tcp.Stat) bool { return true })
+		}()
+
+		select {
+		case n := <-kicked:
+			if n != 1 {
+				t.Fatalf("\tShould kick the blocked connection : %d %s", n, failed)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("\tShould kick with a write blocked %s", failed)
+		}
+		for end := time.Now().Add(time.Second); s.ActiveConnections() != 0 && time.Now().Before(end); {
+			time.Sleep(time.Millisecond)
+		}
+		if n := s.ActiveConnections(); n != 0 {
+			t.Fatalf("\tShould close the blocked connection : %d %s", n, failed)
+		}
+		t.Log("\tShould kick the blocked connection.", success)
+	}
+}
+
+// TestClientStat tests the details reported about a connection.
+func TestClientStat(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to inspect a single connection.")
+	{
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  identityReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptAuth: tcp.OptAuth{
+				AuthHandler: tokenAuthHandler{token: "secret", user: "bill"},
+			},
+		})
+
+		c := s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("secret"), []byte("WELCOME"))
+		c.RoundTrip([]byte("hello"), []byte("bill"))
+		c.RoundTrip([]byte("hello"), []byte("bill"))
+
+		// The request is counted once processed and the write once it
+		// returns, both after the response is received.
+		addr := c.LocalAddr().(*net.TCPAddr)
+		st, err := s.ClientStat(addr)
+		for end := time.Now().Add(time.Second); err == nil && (st.Requests < 2 || st.Congestion.Queued != 0) && time.Now().Before(end); st, err = s.ClientStat(addr) {
+			time.Sleep(time.Millisecond)
+		}
+		if err != nil {
+			t.Fatal("\tShould be able to get the details of the connection.", failed, err)
+		}
+		t.Log("\tShould be able to get the details of the connection.", success)
+
+		if st.Identity != "bill" || st.Requests != 2 || st.BytesRead == 0 || st.TimeConn.IsZero() {
+			t.Fatalf("\tShould report the identity and the requests served : %+v %s", st, failed)
+		}
+		t.Log("\tShould report the identity and the requests served.", success)
+
+		if st.TLS != nil || st.Congestion.Queued != 0 {
+			t.Fatalf("\tShould report no TLS and no responses waiting : %+v %s", st, failed)
+		}
+		t.Log("\tShould report no TLS and no responses waiting.", success)
+
+		c.Close()
+		for end := time.Now().Add(time.Second); err == nil && time.Now().Before(end); _, err = s.ClientStat(addr) {
+			time.Sleep(time.Millisecond)
+		}
+		if !errors.Is(err, tcp.ErrDisconnected) {
+			t.Fatalf("\tShould fail for a connection no longer served : %v %s", err, failed)
+		}
+		t.Log("\tShould fail for a connection no longer served.", success)
+	}
+
+	t.Log("Given the need to inspect a connection that stopped reading.")
+	{
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  identityReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptAuth: tcp.OptAuth{
+				AuthHandler: tokenAuthHandler{token: "secret", user: "bill"},
+			},
+		})
+
+		c := s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("secret"), []byte("WELCOME"))
+
+		// The in-memory connection blocks the response until it's read.
+		c.Send([]byte("hello"))
+		for end := time.Now().Add(time.Second); s.Metrics().Requests == 0 && time.Now().Before(end); {
+			time.Sleep(time.Millisecond)
+		}
+
+		stats := make(chan []tcp.Stat, 1)
+		go func() {
+			stats <- s.ClientStats()
+		}()
+
+		select {
+		case st := <-stats:
+			if len(st) != 1 || st[0].Identity != "bill" {
+				t.Fatalf("\tShould report the identity of the blocked connection : %+v %s", st, failed)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("\tShould report the stats with a write blocked %s", failed)
+		}
+		t.Log("\tShould report the stats with a write blocked.", success)
+	}
+}
+
 // =============================================================================
 
 // Success and failure markers.
@@ -0,0 +1,253 @@
+// Package tcpbench provides a load generator for servers built on the tcp
+// package. Concurrent connections send framed requests at a target rate
+// and the latency of each response is reported in percentiles.
+package tcpbench
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ardanlabs/tcp/tcptest"
+)
+
+// Default values for a run.
+const (
+	defConns    = 1
+	defDuration = 10 * time.Second
+	defTimeout  = 5 * time.Second
+	defRedial   = 10 * time.Millisecond
+)
+
+// Config declares the load a run generates.
+type Config struct {
+	Addr     string                                      // Address of the server, used when Dial is nil.
+	Dial     func(ctx context.Context) (net.Conn, error) // Connects a client, defaults to dialing Addr.
+	Conns    int                                         // Connections sending requests at once, defaults to 1.
+	Rate     float64                                     // Requests per second over all the connections, 0 as fast as possible.
+	Duration time.Duration                               // Time the run lasts, defaults to 10 seconds.
+	Requests int                                         // Requests each connection sends before it stops, 0 for no limit.
+	Timeout  time.Duration                               // Time allowed for each request, defaults to 5 seconds.
+	Payload  func(conn, seq int) []byte                  // Message of each request, defaults to "PING".
+	Framing  tcptest.Framing                             // Defaults to tcptest.Lines.
+}
+
+// Result reports the requests of a run.
+type Result struct {
+	Conns      int
+	Requests   int64         // Requests answered.
+	Errors     int64         // Requests that failed or timed out.
+	Elapsed    time.Duration // Time the run lasted.
+	Throughput float64       // Requests answered per second.
+	Mean       time.Duration
+	P50        time.Duration
+	P90        time.Duration
+	P99        time.Duration
+	P999       time.Duration
+	Max        time.Duration
+}
+
+// String implements the fmt.Stringer interface.
+func (r Result) String() string {
+	return fmt.Sprintf("conns=%d requests=%d errors=%d elapsed=%v throughput=%.1f/s mean=%v p50=%v p90=%v p99=%v p99.9=%v max=%v",
+		r.Conns, r.Requests, r.Errors, r.Elapsed.Round(time.Millisecond), r.Throughput, r.Mean, r.P50, r.P90, r.P99, r.P999, r.Max)
+}
+
+// Run opens the connections and sends requests until the duration passes,
+// each connection sent its requests or the context is done. A connection
+// that fails is dialed again.
+func Run(ctx context.Context, cfg Config) (Result, error) {
+	if cfg.Dial == nil {
+		if cfg.Addr == "" {
+			return Result{}, errors.New("tcpbench : Addr or Dial is required")
+		}
+		var d net.Dialer
+		cfg.Dial = func(ctx context.Context) (net.Conn, error) {
+			return d.DialContext(ctx, "tcp", cfg.Addr)
+		}
+	}
+	if cfg.Conns <= 0 {
+		cfg.Conns = defConns
+	}
+	if cfg.Duration <= 0 {
+		cfg.Duration = defDuration
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defTimeout
+	}
+	if cfg.Payload == nil {
+		cfg.Payload = func(conn, seq int) []byte { return []byte("PING") }
+	}
+	if cfg.Framing.Encode == nil || cfg.Framing.Decode == nil {
+		cfg.Framing = tcptest.Lines
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+
+	// Each connection sends at its share of the rate.
+	var interval time.Duration
+	if cfg.Rate > 0 {
+		interval = time.Duration(float64(time.Second) * float64(cfg.Conns) / cfg.Rate)
+	}
+
+	start := time.Now()
+	workers := make([]worker, cfg.Conns)
+
+	var wg sync.WaitGroup
+	wg.Add(cfg.Conns)
+	for i := range workers {
+		go func(w *worker, id int) {
+			defer wg.Done()
+			w.run(ctx, &cfg, id, start, interval)
+		}(&workers[i], i)
+	}
+	wg.Wait()
+
+	res := Result{
+		Conns:   cfg.Conns,
+		Elapsed: time.Since(start),
+	}
+
+	var latencies []time.Duration
+	for _, w := range workers {
+		latencies = append(latencies, w.latencies...)
+		res.Errors += w.errors
+	}
+	res.Requests = int64(len(latencies))
+	res.Throughput = float64(res.Requests) / res.Elapsed.Seconds()
+
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+		var total time.Duration
+		for _, l := range latencies {
+			total += l
+		}
+
+		res.Mean = total / time.Duration(len(latencies))
+		res.P50 = percentile(latencies, 50)
+		res.P90 = percentile(latencies, 90)
+		res.P99 = percentile(latencies, 99)
+		res.P999 = percentile(latencies, 99.9)
+		res.Max = latencies[len(latencies)-1]
+	}
+
+	// Running out of time is how a run ends, any other reason is not.
+	if err := ctx.Err(); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return res, err
+	}
+
+	return res, nil
+}
+
+// percentile returns the latency below which the percentage of the sorted
+// latencies fall.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(float64(len(sorted))*p/100+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+// =============================================================================
+
+// worker is a connection of the run and the latencies it measured.
+type worker struct {
+	latencies []time.Duration
+	errors    int64
+}
+
+// run sends requests over the connection until the context is done or
+// the requests are sent.
+func (w *worker) run(ctx context.Context, cfg *Config, id int, start time.Time, interval time.Duration) {
+	var conn net.Conn
+	var reader *bufio.Reader
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	// Spread the first requests of the connections over the interval.
+	next := start
+	if interval > 0 {
+		next = start.Add(interval * time.Duration(id) / time.Duration(cfg.Conns))
+	}
+
+	for seq := 0; cfg.Requests == 0 || seq < cfg.Requests; seq++ {
+		if interval > 0 {
+			if d := time.Until(next); d > 0 {
+				select {
+				case <-time.After(d):
+				case <-ctx.Done():
+					return
+				}
+			}
+			next = next.Add(interval)
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if conn == nil {
+			var err error
+			if conn, err = cfg.Dial(ctx); err != nil {
+				w.errors++
+
+				// Back off so a server that's down isn't dialed in a
+				// tight loop.
+				select {
+				case <-time.After(defRedial):
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+			reader = bufio.NewReader(conn)
+		}
+
+		l, err := roundTrip(ctx, conn, reader, cfg, cfg.Payload(id, seq))
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			w.errors++
+			conn.Close()
+			conn = nil
+			continue
+		}
+
+		w.latencies = append(w.latencies, l)
+	}
+}
+
+// roundTrip sends the request and returns the time the response took.
+func roundTrip(ctx context.Context, conn net.Conn, reader *bufio.Reader, cfg *Config, msg []byte) (time.Duration, error) {
+	deadline := time.Now().Add(cfg.Timeout)
+	if end, ok := ctx.Deadline(); ok && end.Before(deadline) {
+		deadline = end
+	}
+	conn.SetDeadline(deadline)
+
+	sent := time.Now()
+	if _, err := conn.Write(cfg.Framing.Encode(msg)); err != nil {
+		return 0, err
+	}
+
+	if _, err := cfg.Framing.Decode(reader); err != nil {
+		return 0, err
+	}
+
+	return time.Since(sent), nil
+}
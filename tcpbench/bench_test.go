@@ -0,0 +1,115 @@
+package tcpbench_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/tcp"
+	"github.com/ardanlabs/tcp/tcpbench"
+	"github.com/ardanlabs/tcp/tcptest"
+)
+
+// Success and failure markers.
+var (
+	success = "✓"
+	failed  = "✗"
+)
+
+// TestRun tests the load is generated and the latencies reported.
+func TestRun(t *testing.T) {
+	t.Log("Given the need to measure a server under load.")
+	{
+		s := tcptest.NewServer(t, tcp.Config{
+			ReqHandler:  echoReqHandler{},
+			RespHandler: respHandler{},
+
+			OptBufferSize: tcp.OptBufferSize{
+				ReadBufferSize: 1024,
+			},
+		})
+
+		res, err := tcpbench.Run(context.Background(), tcpbench.Config{
+			Dial: func(ctx context.Context) (net.Conn, error) {
+				return s.Listener.Dial()
+			},
+			Conns:    4,
+			Requests: 25,
+			Duration: 5 * time.Second,
+		})
+		if err != nil {
+			t.Fatalf("\tShould be able to run the load : %v %s", err, failed)
+		}
+
+		if res.Requests != 100 || res.Errors != 0 {
+			t.Fatalf("\tShould send the requests of each connection : %v %s", res, failed)
+		}
+		if res.P50 <= 0 || res.P50 > res.P99 || res.P99 > res.Max || res.Throughput <= 0 {
+			t.Fatalf("\tShould report the latency percentiles : %v %s", res, failed)
+		}
+		t.Log("\tShould report the latency percentiles.", success)
+
+		start := time.Now()
+		res, err = tcpbench.Run(context.Background(), tcpbench.Config{
+			Dial: func(ctx context.Context) (net.Conn, error) {
+				return s.Listener.Dial()
+			},
+			Conns:    2,
+			Rate:     100,
+			Duration: 200 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatalf("\tShould be able to run the load : %v %s", err, failed)
+		}
+
+		if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > time.Second {
+			t.Fatalf("\tShould run for the duration : %v %s", elapsed, failed)
+		}
+		if res.Requests < 10 || res.Requests > 25 {
+			t.Fatalf("\tShould send at the rate : %v %s", res, failed)
+		}
+		t.Log("\tShould send at the rate for the duration.", success)
+	}
+}
+
+// =============================================================================
+
+// echoReqHandler answers every line with the line.
+type echoReqHandler struct{}
+
+// Read implements the tcp.ReqHandler interface.
+func (echoReqHandler) Read(ipAddress string, reader io.Reader) ([]byte, int, error) {
+	line, err := reader.(*bufio.Reader).ReadBytes('\n')
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return line, len(line), nil
+}
+
+// Process implements the tcp.ReqHandler interface.
+func (echoReqHandler) Process(r *tcp.Request) {
+	resp := tcp.Response{
+		TCPAddr: r.TCPAddr,
+		Data:    r.Data,
+		Length:  r.Length,
+	}
+
+	r.TCP.Send(r.Context, &resp)
+}
+
+// respHandler writes and flushes the response.
+type respHandler struct{}
+
+// Write implements the tcp.RespHandler interface.
+func (respHandler) Write(r *tcp.Response, writer io.Writer) error {
+	bw := writer.(*bufio.Writer)
+	if _, err := bw.Write(r.Data[:r.Length]); err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,96 @@
+// Package tcprun provides the glue running servers built on the tcp package
+// as a process: the servers are shut down gracefully when the process is
+// asked to stop, such as by Ctrl-C, a SIGTERM from a process manager or
+// the console of Windows closing.
+//
+//	t, err := tcp.New("echo", cfg)
+//	...
+//	if err := t.Start(); err != nil {
+//	    ...
+//	}
+//	err = tcprun.Run(context.Background(), tcprun.Config{Grace: 10 * time.Second}, t)
+//
+// On Windows, the console close, logoff and shutdown events arrive as a
+// SIGTERM. A Windows service handles the requests of the service control
+// manager in its own handler, such as with golang.org/x/sys/windows/svc,
+// and cancels the context given to Run on a stop request.
+package tcprun
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defGrace is the time the connections have to finish by default.
+const defGrace = 30 * time.Second
+
+// Server is a server Run shuts down, such as a *tcp.TCP value.
+type Server interface {
+	Shutdown(ctx context.Context, goodbye []byte) error
+}
+
+// Config declares how the servers are shut down.
+type Config struct {
+	Grace      time.Duration       // Time the connections have to finish, defaults to 30 seconds.
+	Goodbye    []byte              // Written to every connection through its RespHandler before it's closed.
+	Signals    []os.Signal         // Signals asking to stop, defaults to os.Interrupt and syscall.SIGTERM.
+	OnShutdown func(sig os.Signal) // Called as the shutdown starts, with nil when the context is done.
+}
+
+// Run waits for a signal asking the process to stop or for the context to
+// be done and shuts the servers down at once. The connections have the
+// grace period to finish and a second signal ends it early. The errors of
+// the servers are returned joined, including the context.DeadlineExceeded
+// of those whose connections didn't finish in time.
+func Run(ctx context.Context, cfg Config, servers ...Server) error {
+	if cfg.Grace <= 0 {
+		cfg.Grace = defGrace
+	}
+	if len(cfg.Signals) == 0 {
+		cfg.Signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, cfg.Signals...)
+	defer signal.Stop(sigs)
+
+	var sig os.Signal
+	select {
+	case sig = <-sigs:
+	case <-ctx.Done():
+	}
+
+	if cfg.OnShutdown != nil {
+		cfg.OnShutdown(sig)
+	}
+
+	grace, cancel := context.WithTimeout(context.Background(), cfg.Grace)
+	defer cancel()
+
+	// A second signal ends the grace period.
+	go func() {
+		select {
+		case <-sigs:
+			cancel()
+		case <-grace.Done():
+		}
+	}()
+
+	errs := make([]error, len(servers))
+	var wg sync.WaitGroup
+	for i, s := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.Shutdown(grace, cfg.Goodbye)
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
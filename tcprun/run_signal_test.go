@@ -0,0 +1,65 @@
+//go:build !windows
+
+package tcprun_test
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/tcp/tcprun"
+)
+
+// TestRunSignal tests the servers are shut down on a signal.
+func TestRunSignal(t *testing.T) {
+	t.Log("Given the need to stop on a signal.")
+	{
+		sigs := make(chan os.Signal, 1)
+		s := shutdowner{called: make(chan time.Duration, 1)}
+		done := make(chan error, 1)
+		go func() {
+			done <- tcprun.Run(context.Background(), tcprun.Config{
+				Grace:      time.Minute,
+				Signals:    []os.Signal{syscall.SIGUSR1},
+				OnShutdown: func(sig os.Signal) { sigs <- sig },
+			}, s)
+		}()
+
+		// Send the signal until Run is waiting for it.
+		var sig os.Signal
+		for sig == nil {
+			syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+			select {
+			case sig = <-sigs:
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+
+		if sig != syscall.SIGUSR1 {
+			t.Fatalf("\tShould report the signal : %v %s", sig, failed)
+		}
+		if d := <-s.called; d <= 0 || d > time.Minute {
+			t.Fatalf("\tShould give the grace period : %v %s", d, failed)
+		}
+		if err := <-done; err != nil {
+			t.Fatalf("\tShould shut down cleanly : %v %s", err, failed)
+		}
+		t.Log("\tShould shut down with the grace period on a signal.", success)
+	}
+}
+
+// =============================================================================
+
+// shutdowner reports the grace period it's given.
+type shutdowner struct {
+	called chan time.Duration
+}
+
+// Shutdown implements the tcprun.Server interface.
+func (s shutdowner) Shutdown(ctx context.Context, goodbye []byte) error {
+	deadline, _ := ctx.Deadline()
+	s.called <- time.Until(deadline)
+	return nil
+}
@@ -0,0 +1,109 @@
+package tcprun_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/tcp"
+	"github.com/ardanlabs/tcp/tcprun"
+	"github.com/ardanlabs/tcp/tcptest"
+)
+
+// Success and failure markers.
+var (
+	success = "✓"
+	failed  = "✗"
+)
+
+// TestRun tests the servers are shut down gracefully when asked to stop.
+func TestRun(t *testing.T) {
+	t.Log("Given the need to shut the servers down when the process stops.")
+	{
+		s := tcptest.NewServer(t, tcp.Config{
+			ReqHandler:  echoReqHandler{},
+			RespHandler: respHandler{},
+
+			OptBufferSize: tcp.OptBufferSize{
+				ReadBufferSize: 1024,
+			},
+		})
+
+		conn, err := s.Listener.Dial()
+		if err != nil {
+			t.Fatalf("\tShould dial the server : %v %s", err, failed)
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		conn.Write([]byte("Hello\n"))
+		if line, err := r.ReadString('\n'); err != nil || line != "Hello\n" {
+			t.Fatalf("\tShould serve the connection : %q %v %s", line, err, failed)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- tcprun.Run(ctx, tcprun.Config{Goodbye: []byte("BYE\n")}, s)
+		}()
+		cancel()
+
+		if line, err := r.ReadString('\n'); err != nil || line != "BYE\n" {
+			t.Fatalf("\tShould say goodbye to the connection : %q %v %s", line, err, failed)
+		}
+		if _, err := r.ReadByte(); err != io.EOF {
+			t.Fatalf("\tShould close the connection : %v %s", err, failed)
+		}
+
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("\tShould shut down cleanly : %v %s", err, failed)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("\tShould return once the server is shut down %s", failed)
+		}
+		t.Log("\tShould drain the connections and stop once the context is done.", success)
+	}
+}
+
+// =============================================================================
+
+// echoReqHandler echoes the lines it reads.
+type echoReqHandler struct{}
+
+// Read implements the tcp.ReqHandler interface.
+func (echoReqHandler) Read(ipAddress string, reader io.Reader) ([]byte, int, error) {
+	line, err := reader.(*bufio.Reader).ReadBytes('\n')
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return line, len(line), nil
+}
+
+// Process implements the tcp.ReqHandler interface.
+func (echoReqHandler) Process(r *tcp.Request) {
+	resp := tcp.Response{
+		TCPAddr: r.TCPAddr,
+		Data:    r.Data,
+		Length:  r.Length,
+	}
+
+	r.TCP.Send(r.Context, &resp)
+}
+
+// respHandler writes and flushes the response.
+type respHandler struct{}
+
+// Write implements the tcp.RespHandler interface.
+func (respHandler) Write(r *tcp.Response, writer io.Writer) error {
+	bw := writer.(*bufio.Writer)
+	if _, err := bw.Write(r.Data[:r.Length]); err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
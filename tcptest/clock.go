@@ -0,0 +1,150 @@
+// Package tcptest provides utilities for testing code built on the tcp
+// package.
+package tcptest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ardanlabs/tcp"
+)
+
+// Clock implements the tcp.Clock interface with a time that only moves
+// when Advance is called. Timers and tickers fire as the time passes
+// their deadlines.
+type Clock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*timer
+}
+
+// NewClock creates a clock set to the specified time.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns the current time of the clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// Advance moves the clock forward, firing the timers and tickers whose
+// deadlines have passed in order.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+
+	for {
+		// Find the next timer to fire before the end.
+		sort.Slice(c.timers, func(i, j int) bool {
+			return c.timers[i].when.Before(c.timers[j].when)
+		})
+
+		if len(c.timers) == 0 || c.timers[0].when.After(end) {
+			break
+		}
+
+		tm := c.timers[0]
+		c.now = tm.when
+
+		if tm.period > 0 {
+			tm.when = tm.when.Add(tm.period)
+		} else {
+			c.timers = c.timers[1:]
+		}
+
+		// Fire without holding the lock so the callbacks can use
+		// the clock.
+		c.mu.Unlock()
+		tm.fire(c.Now())
+		c.mu.Lock()
+	}
+
+	c.now = end
+	c.mu.Unlock()
+}
+
+// NewTimer creates a timer that fires once the clock has advanced by d.
+func (c *Clock) NewTimer(d time.Duration) tcp.Timer {
+	return c.add(d, 0, nil)
+}
+
+// NewTicker creates a ticker that fires every time the clock advances
+// by d.
+func (c *Clock) NewTicker(d time.Duration) tcp.Ticker {
+	return ticker{c.add(d, d, nil)}
+}
+
+// AfterFunc calls f on its own goroutine once the clock has advanced by d.
+func (c *Clock) AfterFunc(d time.Duration, f func()) tcp.Timer {
+	return c.add(d, 0, f)
+}
+
+// add registers a new timer with the clock.
+func (c *Clock) add(d, period time.Duration, f func()) *timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tm := timer{
+		clock:  c,
+		when:   c.now.Add(d),
+		period: period,
+		f:      f,
+		ch:     make(chan time.Time, 1),
+	}
+	c.timers = append(c.timers, &tm)
+
+	return &tm
+}
+
+// remove unregisters the timer, reporting if it was still pending.
+func (c *Clock) remove(tm *timer) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := range c.timers {
+		if c.timers[i] == tm {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// timer implements the tcp.Timer and tcp.Ticker interfaces.
+type timer struct {
+	clock  *Clock
+	when   time.Time
+	period time.Duration
+	f      func()
+	ch     chan time.Time
+}
+
+// fire delivers the time or calls the function. Like the time package,
+// ticks are dropped when the receiver is not keeping up.
+func (tm *timer) fire(now time.Time) {
+	if tm.f != nil {
+		go tm.f()
+		return
+	}
+
+	select {
+	case tm.ch <- now:
+	default:
+	}
+}
+
+func (tm *timer) C() <-chan time.Time { return tm.ch }
+
+func (tm *timer) Stop() bool { return tm.clock.remove(tm) }
+
+// ticker adapts a timer to the tcp.Ticker interface.
+type ticker struct {
+	*timer
+}
+
+func (t ticker) Stop() { t.timer.Stop() }
@@ -0,0 +1,127 @@
+package tcptest
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// firstPort is the port assigned to the first connection dialed.
+const firstPort = 10000
+
+// Listener implements the net.Listener interface in memory. Connections
+// are created with Dial using net.Pipe, so no sockets are opened. Each
+// connection reports a unique loopback address so the tcp package can
+// track it like a real client.
+type Listener struct {
+	addr  *net.TCPAddr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+	port  int32
+}
+
+// NewListener creates an in-memory listener.
+func NewListener() *Listener {
+	return &Listener{
+		addr:  &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+		port:  firstPort - 1,
+	}
+}
+
+// Listen returns the listener. It can be assigned to tcp.Config.Listen so
+// a TCP value runs over this listener. The listener can't be used again
+// once it's closed.
+func (l *Listener) Listen(netType, addr string) (net.Listener, error) {
+	return l, nil
+}
+
+// Accept waits for the next connection created by Dial.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the listener. Connections already accepted stay open.
+func (l *Listener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
+
+// Addr returns the listener's network address.
+func (l *Listener) Addr() net.Addr {
+	return l.addr
+}
+
+// Dial creates a connection to the listener and returns the client side.
+// It blocks until the connection is accepted.
+func (l *Listener) Dial() (net.Conn, error) {
+	raddr := net.TCPAddr{
+		IP:   net.IPv4(127, 0, 0, 1),
+		Port: int(atomic.AddInt32(&l.port, 1)),
+	}
+
+	server, client := net.Pipe()
+	sc := pipeConn{Conn: server, local: l.addr, remote: &raddr}
+	cc := pipeConn{Conn: client, local: &raddr, remote: l.addr}
+
+	select {
+	case l.conns <- &sc:
+		return &cc, nil
+	case <-l.done:
+		server.Close()
+		client.Close()
+		return nil, net.ErrClosed
+	}
+}
+
+// =============================================================================
+
+// pipeConn gives one side of a pipe the addresses and errors of a TCP
+// connection.
+type pipeConn struct {
+	net.Conn
+	local  net.Addr
+	remote net.Addr
+}
+
+// LocalAddr returns the local network address.
+func (pc *pipeConn) LocalAddr() net.Addr {
+	return pc.local
+}
+
+// RemoteAddr returns the remote network address.
+func (pc *pipeConn) RemoteAddr() net.Addr {
+	return pc.remote
+}
+
+// Read reads data from the connection.
+func (pc *pipeConn) Read(b []byte) (int, error) {
+	n, err := pc.Conn.Read(b)
+	return n, closedErr(err)
+}
+
+// Write writes data to the connection.
+func (pc *pipeConn) Write(b []byte) (int, error) {
+	n, err := pc.Conn.Write(b)
+	return n, closedErr(err)
+}
+
+// closedErr reports using a closed pipe the same way the net package
+// reports using a closed socket.
+func closedErr(err error) error {
+	if errors.Is(err, io.ErrClosedPipe) {
+		return net.ErrClosed
+	}
+	return err
+}
@@ -0,0 +1,190 @@
+package tcptest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/tcp"
+)
+
+// defTimeout is the time allowed for a send or receive.
+const defTimeout = 5 * time.Second
+
+// Framing describes how messages are delimited on a connection.
+type Framing struct {
+	Encode func(msg []byte) []byte
+	Decode func(r *bufio.Reader) ([]byte, error)
+}
+
+// Lines frames messages by a trailing newline. The newline is added on
+// encode and removed on decode.
+var Lines = Framing{
+	Encode: func(msg []byte) []byte {
+		return append(append([]byte(nil), msg...), '\n')
+	},
+	Decode: func(r *bufio.Reader) ([]byte, error) {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			return nil, err
+		}
+		return bytes.TrimSuffix(line, []byte("\n")), nil
+	},
+}
+
+// LengthPrefixed frames messages with their length as a 4 byte big endian
+// integer.
+var LengthPrefixed = Framing{
+	Encode: func(msg []byte) []byte {
+		b := make([]byte, 4+len(msg))
+		binary.BigEndian.PutUint32(b, uint32(len(msg)))
+		copy(b[4:], msg)
+		return b
+	},
+	Decode: func(r *bufio.Reader) ([]byte, error) {
+		var hdr [4]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return nil, err
+		}
+		msg := make([]byte, binary.BigEndian.Uint32(hdr[:]))
+		if _, err := io.ReadFull(r, msg); err != nil {
+			return nil, err
+		}
+		return msg, nil
+	},
+}
+
+// Server runs a TCP value over an in-memory listener so handlers can be
+// tested without opening sockets.
+type Server struct {
+	*tcp.TCP
+	Listener *Listener
+}
+
+// NewServer creates and starts a TCP value over an in-memory listener. The
+// NetType and Addr fields default to a loopback address when not set. The
+// value is stopped when the test completes.
+func NewServer(tb testing.TB, cfg tcp.Config) *Server {
+	tb.Helper()
+
+	if cfg.NetType == "" {
+		cfg.NetType = "tcp4"
+	}
+	if cfg.Addr == "" {
+		cfg.Addr = "127.0.0.1:0"
+	}
+
+	l := NewListener()
+	cfg.Listen = l.Listen
+
+	t, err := tcp.New("tcptest", cfg)
+	if err != nil {
+		tb.Fatalf("tcptest : new : %v", err)
+	}
+
+	if err := t.Start(); err != nil {
+		tb.Fatalf("tcptest : start : %v", err)
+	}
+
+	tb.Cleanup(func() {
+		t.Stop()
+	})
+
+	return &Server{TCP: t, Listener: l}
+}
+
+// Dial connects a client to the server that frames its messages with the
+// specified framing. The connection is closed when the test completes.
+func (s *Server) Dial(tb testing.TB, f Framing) *Conn {
+	tb.Helper()
+
+	conn, err := s.Listener.Dial()
+	if err != nil {
+		tb.Fatalf("tcptest : dial : %v", err)
+	}
+
+	tb.Cleanup(func() {
+		conn.Close()
+	})
+
+	return &Conn{
+		Conn:    conn,
+		Timeout: defTimeout,
+		tb:      tb,
+		framing: f,
+		reader:  bufio.NewReader(conn),
+	}
+}
+
+// Conn is the client side of a connection to a Server. Its methods fail
+// the test on error so they must be called from the test's goroutine.
+type Conn struct {
+	net.Conn
+	Timeout time.Duration // Time allowed for each send or receive.
+
+	tb      testing.TB
+	framing Framing
+	reader  *bufio.Reader
+}
+
+// Send frames the message and writes it to the server.
+func (c *Conn) Send(msg []byte) {
+	c.tb.Helper()
+
+	c.SetWriteDeadline(time.Now().Add(c.Timeout))
+	if _, err := c.Write(c.framing.Encode(msg)); err != nil {
+		c.tb.Fatalf("tcptest : send %q : %v", msg, err)
+	}
+}
+
+// Recv reads the next framed message from the server.
+func (c *Conn) Recv() []byte {
+	c.tb.Helper()
+
+	c.SetReadDeadline(time.Now().Add(c.Timeout))
+	msg, err := c.framing.Decode(c.reader)
+	if err != nil {
+		c.tb.Fatalf("tcptest : recv : %v", err)
+	}
+
+	return msg
+}
+
+// Expect reads the next framed message and fails the test if it's not the
+// message wanted.
+func (c *Conn) Expect(want []byte) {
+	c.tb.Helper()
+
+	if got := c.Recv(); !bytes.Equal(got, want) {
+		c.tb.Fatalf("tcptest : got %q, want %q", got, want)
+	}
+}
+
+// RoundTrip sends the request and expects the response.
+func (c *Conn) RoundTrip(req, want []byte) {
+	c.tb.Helper()
+
+	c.Send(req)
+	c.Expect(want)
+}
+
+// ExpectClosed fails the test unless the server closes the connection
+// before sending another message.
+func (c *Conn) ExpectClosed() {
+	c.tb.Helper()
+
+	c.SetReadDeadline(time.Now().Add(c.Timeout))
+	msg, err := c.framing.Decode(c.reader)
+	if err == nil {
+		c.tb.Fatalf("tcptest : got %q, want the connection closed", msg)
+	}
+
+	if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		c.tb.Fatalf("tcptest : want the connection closed : %v", err)
+	}
+}
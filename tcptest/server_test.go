@@ -0,0 +1,88 @@
+package tcptest_test
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/ardanlabs/tcp"
+	"github.com/ardanlabs/tcp/tcptest"
+)
+
+// Success and failure markers.
+var (
+	success = "✓"
+	failed  = "✗"
+)
+
+// TestServer tests handlers can be exercised over the in-memory transport.
+func TestServer(t *testing.T) {
+	t.Log("Given the need to test handlers without sockets.")
+	{
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: connHandler{},
+			ReqHandler:  upperReqHandler{},
+			RespHandler: respHandler{},
+		})
+
+		c := s.Dial(t, tcptest.Lines)
+		c.RoundTrip([]byte("hello"), []byte("HELLO"))
+		c.RoundTrip([]byte("world"), []byte("WORLD"))
+		t.Log("\tShould receive the responses to the requests.", success)
+
+		if n := s.Connections(); n != 1 {
+			t.Fatal("\tShould track the connection.", failed, n)
+		}
+		t.Log("\tShould track the connection.", success)
+
+		if err := s.Drop(c.LocalAddr().(*net.TCPAddr)); err != nil {
+			t.Fatal("\tShould be able to drop the connection.", failed, err)
+		}
+		c.ExpectClosed()
+		t.Log("\tShould see the connection closed once dropped.", success)
+	}
+}
+
+// =============================================================================
+
+// connHandler binds a buffered reader and the connection as the writer.
+type connHandler struct{}
+
+// Bind implements the tcp.ConnHandler interface.
+func (connHandler) Bind(conn net.Conn) (io.Reader, io.Writer) {
+	return bufio.NewReader(conn), conn
+}
+
+// upperReqHandler answers every line with the line in upper case.
+type upperReqHandler struct{}
+
+// Read implements the tcp.ReqHandler interface.
+func (upperReqHandler) Read(ipAddress string, reader io.Reader) ([]byte, int, error) {
+	line, err := reader.(*bufio.Reader).ReadBytes('\n')
+	if err != nil {
+		return nil, 0, err
+	}
+	return line, len(line), nil
+}
+
+// Process implements the tcp.ReqHandler interface.
+func (upperReqHandler) Process(r *tcp.Request) {
+	data := bytes.ToUpper(r.Data)
+	resp := tcp.Response{
+		TCPAddr: r.TCPAddr,
+		Data:    data,
+		Length:  len(data),
+	}
+	r.TCP.Send(r.Context, &resp)
+}
+
+// respHandler writes the response data as is.
+type respHandler struct{}
+
+// Write implements the tcp.RespHandler interface.
+func (respHandler) Write(r *tcp.Response, writer io.Writer) error {
+	_, err := writer.Write(r.Data[:r.Length])
+	return err
+}
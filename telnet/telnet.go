@@ -0,0 +1,185 @@
+// Package telnet provides a line codec for the tcp package that strips the
+// telnet negotiation sequences and normalizes the line endings, so admin
+// ports can be used with a telnet client as well as with netcat. The
+// connections must be bound to a reader implementing io.ByteScanner, such
+// as a bufio.Reader.
+//
+//	t, err := tcp.Serve("admin", cfg, telnet.Codec{}, func(r *tcp.Request, line string) (string, error) {
+//	    return "you said " + line + "\n", nil
+//	})
+package telnet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ardanlabs/tcp"
+)
+
+// defMaxLineSize is the largest line read by default.
+const defMaxLineSize = 4096
+
+// Set of telnet command bytes.
+const (
+	cmdSE   = 240 // End of subnegotiation.
+	cmdSB   = 250 // Start of subnegotiation.
+	cmdWILL = 251
+	cmdWONT = 252
+	cmdDO   = 253
+	cmdDONT = 254
+	cmdIAC  = 255 // Interpret as command.
+)
+
+// ErrNoByteScanner is returned when the connection is not bound to a
+// reader implementing io.ByteScanner.
+var ErrNoByteScanner = errors.New("telnet : reader must implement io.ByteScanner")
+
+// Codec reads lines without the telnet negotiation sequences and line
+// endings, and writes lines ending in CR LF. It implements tcp.Codec.
+type Codec struct {
+	MaxLineSize int // Largest line read, defaults to 4k.
+}
+
+// Read implements the tcp.Codec interface.
+func (c Codec) Read(reader io.Reader) ([]byte, error) {
+	br, ok := reader.(io.ByteScanner)
+	if !ok {
+		return nil, ErrNoByteScanner
+	}
+
+	max := c.MaxLineSize
+	if max <= 0 {
+		max = defMaxLineSize
+	}
+
+	return ReadLine(br, max)
+}
+
+// Decode implements the tcp.Codec interface.
+func (Codec) Decode(data []byte) (string, error) {
+	return string(data), nil
+}
+
+// Encode implements the tcp.Codec interface. A line not ending in LF is
+// terminated with CR LF.
+func (Codec) Encode(line string) ([]byte, error) {
+	if !strings.HasSuffix(line, "\n") {
+		line += "\r\n"
+	}
+	return Encode([]byte(line)), nil
+}
+
+// =============================================================================
+
+// ReadLine reads the next line, stripping the negotiation sequences and the
+// line ending. Lines end in LF, CR LF or CR NUL. A line longer than the
+// max fails with an error wrapping tcp.ErrFrameTooLarge.
+func ReadLine(br io.ByteScanner, max int) ([]byte, error) {
+	var line []byte
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+
+		switch b {
+		case cmdIAC:
+			lit, err := skipCommand(br)
+			if err != nil {
+				return nil, err
+			}
+			if !lit {
+				continue
+			}
+
+		case '\r':
+			next, err := br.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if next == '\n' || next == 0 {
+				return line, nil
+			}
+
+			// A bare CR is kept and the byte after it read again.
+			if err := br.UnreadByte(); err != nil {
+				return nil, err
+			}
+
+		case '\n':
+			return line, nil
+		}
+
+		if len(line) >= max {
+			return nil, fmt.Errorf("telnet : line over %d bytes : %w", max, tcp.ErrFrameTooLarge)
+		}
+		line = append(line, b)
+	}
+}
+
+// skipCommand reads the command following an IAC. It reports whether the
+// sequence is an escaped 255 byte that belongs to the line.
+func skipCommand(br io.ByteReader) (bool, error) {
+	cmd, err := br.ReadByte()
+	if err != nil {
+		return false, err
+	}
+
+	switch cmd {
+	case cmdIAC:
+		return true, nil
+
+	case cmdWILL, cmdWONT, cmdDO, cmdDONT:
+		_, err := br.ReadByte()
+		return false, err
+
+	case cmdSB:
+
+		// Skip the subnegotiation up to IAC SE.
+		var prev byte
+		for {
+			b, err := br.ReadByte()
+			if err != nil {
+				return false, err
+			}
+			if prev == cmdIAC && b == cmdSE {
+				return false, nil
+			}
+
+			// An escaped IAC inside the subnegotiation is data.
+			if prev == cmdIAC && b == cmdIAC {
+				b = 0
+			}
+			prev = b
+		}
+	}
+
+	// Every other command is a single byte.
+	return false, nil
+}
+
+// Encode escapes the 255 bytes of the data and ends its lines in CR LF.
+func Encode(data []byte) []byte {
+	var out bytes.Buffer
+	out.Grow(len(data) + 2)
+
+	for i, b := range data {
+		switch b {
+		case cmdIAC:
+			out.WriteByte(cmdIAC)
+		case '\n':
+			if i == 0 || data[i-1] != '\r' {
+				out.WriteByte('\r')
+			}
+		}
+		out.WriteByte(b)
+	}
+
+	return out.Bytes()
+}
@@ -0,0 +1,98 @@
+package telnet_test
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/ardanlabs/tcp"
+	"github.com/ardanlabs/tcp/tcptest"
+	"github.com/ardanlabs/tcp/telnet"
+)
+
+// Success and failure markers.
+var (
+	success = "✓"
+	failed  = "✗"
+)
+
+// TestReadLine tests the negotiation and line endings are stripped.
+func TestReadLine(t *testing.T) {
+	t.Log("Given the need to read lines sent by telnet clients.")
+	{
+		tests := []struct {
+			name string
+			in   string
+			want string
+		}{
+			{"LF", "status\n", "status"},
+			{"CR LF", "status\r\n", "status"},
+			{"CR NUL", "status\r\x00", "status"},
+			{"negotiation", "\xff\xfb\x01\xff\xfd\x03status\r\n", "status"},
+			{"subnegotiation", "\xff\xfa\x18\x00xterm\xff\xf0status\r\n", "status"},
+			{"command", "sta\xff\xf1tus\n", "status"},
+			{"escaped IAC", "a\xff\xffb\n", "a\xffb"},
+			{"bare CR", "a\rb\n", "a\rb"},
+			{"bare CR before CR LF", "a\r\r\n", "a\r"},
+		}
+
+		for _, tt := range tests {
+			line, err := telnet.ReadLine(bufio.NewReader(bytes.NewReader([]byte(tt.in))), 64)
+			if err != nil || string(line) != tt.want {
+				t.Errorf("\tShould read the line with %s : %q %v %s", tt.name, line, err, failed)
+				continue
+			}
+			t.Logf("\tShould read the line with %s. %s", tt.name, success)
+		}
+
+		_, err := telnet.ReadLine(bufio.NewReader(bytes.NewReader(bytes.Repeat([]byte("x"), 100))), 64)
+		if !errors.Is(err, tcp.ErrFrameTooLarge) {
+			t.Fatalf("\tShould reject lines over the max : %v %s", err, failed)
+		}
+		t.Log("\tShould reject lines over the max.", success)
+
+		_, err = telnet.ReadLine(bufio.NewReader(bytes.NewReader([]byte("partial"))), 64)
+		if err != io.ErrUnexpectedEOF {
+			t.Fatalf("\tShould report a line cut short : %v %s", err, failed)
+		}
+		t.Log("\tShould report a line cut short.", success)
+
+		if out := telnet.Encode([]byte("a\xffb\nc\r\n")); string(out) != "a\xff\xffb\r\nc\r\n" {
+			t.Fatalf("\tShould escape IAC and end lines in CR LF : %q %s", out, failed)
+		}
+		t.Log("\tShould escape IAC and end lines in CR LF.", success)
+	}
+}
+
+// TestCodec tests the codec serves a typed line handler.
+func TestCodec(t *testing.T) {
+	t.Log("Given the need to serve an admin port to telnet clients.")
+	{
+		hs := tcp.Handlers[string, string](telnet.Codec{}, func(r *tcp.Request, line string) (string, error) {
+			return "you said " + line + "\n", nil
+		})
+
+		s := tcptest.NewServer(t, tcp.Config{
+			ReqHandler:  hs.ReqHandler,
+			RespHandler: hs.RespHandler,
+
+			OptBufferSize: tcp.OptBufferSize{
+				ReadBufferSize: 1024,
+			},
+		})
+
+		c := s.Dial(t, tcptest.Lines)
+		c.Write([]byte("\xff\xfd\x01hello\r\n"))
+		c.Expect([]byte("you said hello\r"))
+		t.Log("\tShould answer the lines without the negotiation.", success)
+
+		for _, line := range []string{"hello", "hello\n", "hello\r\n"} {
+			if out, _ := (telnet.Codec{}).Encode(line); string(out) != "hello\r\n" {
+				t.Fatalf("\tShould end the lines in CR LF : %q %s", out, failed)
+			}
+		}
+		t.Log("\tShould end the lines in CR LF.", success)
+	}
+}
@@ -0,0 +1,527 @@
+package tcp_test
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/tcp"
+)
+
+// TestVerifyPeer tests client certificates can be used to reject or
+// identify a connection.
+func TestVerifyPeer(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to authenticate clients by certificate.")
+	{
+		ca, caKey := newCA(t)
+		pool := x509.NewCertPool()
+		pool.AddCert(ca)
+
+		cfg := tcp.Config{
+			NetType:     "tcp4",
+			Addr:        "127.0.0.1:0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptTLS: tcp.OptTLS{
+				TLSConfig: &tls.Config{
+					Certificates: []tls.Certificate{newCert(t, ca, caKey, "localhost")},
+					ClientAuth:   tls.RequireAndVerifyClientCert,
+					ClientCAs:    pool,
+				},
+				VerifyPeer: func(ipAddress string, state tls.ConnectionState) (string, error) {
+					cn := state.PeerCertificates[0].Subject.CommonName
+					if cn == "mallory" {
+						return "", errors.New("banned identity")
+					}
+					return cn, nil
+				},
+			},
+		}
+
+		u, err := tcp.New("TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+		if err := u.Start(); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", failed, err)
+		}
+		t.Log("\tShould be able to start the TLS listener.", success)
+
+		defer u.Stop()
+
+		call := func(cn string) (string, error) {
+			conn, err := tls.Dial("tcp4", u.Addr().String(), &tls.Config{
+				Certificates: []tls.Certificate{newCert(t, ca, caKey, cn)},
+				RootCAs:      pool,
+				ServerName:   "localhost",
+			})
+			if err != nil {
+				return "", err
+			}
+			defer conn.Close()
+
+			if _, err := conn.Write([]byte("Hello\n")); err != nil {
+				return "", err
+			}
+			return bufio.NewReader(conn).ReadString('\n')
+		}
+
+		if resp, err := call("alice"); err != nil || resp != "GOT IT\n" {
+			t.Fatal("\tShould accept a verified peer.", failed, resp, err)
+		}
+		t.Log("\tShould accept a verified peer.", success)
+
+		if _, err := call("mallory"); err == nil {
+			t.Fatal("\tShould reject a peer VerifyPeer refuses.", failed)
+		}
+		t.Log("\tShould reject a peer VerifyPeer refuses.", success)
+	}
+}
+
+// TestALPNProtocols tests the handlers are selected by the negotiated
+// ALPN protocol.
+func TestALPNProtocols(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to serve multiple protocols on one port.")
+	{
+		ca, caKey := newCA(t)
+		pool := x509.NewCertPool()
+		pool.AddCert(ca)
+
+		cfg := tcp.Config{
+			NetType:     "tcp4",
+			Addr:        "127.0.0.1:0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptTLS: tcp.OptTLS{
+				TLSConfig: &tls.Config{
+					Certificates: []tls.Certificate{newCert(t, ca, caKey, "localhost")},
+				},
+				Protocols: map[string]tcp.HandlerSet{
+					"alt/1": {ReqHandler: altReqHandler{}},
+				},
+			},
+		}
+
+		u, err := tcp.New("TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+		if err := u.Start(); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", failed, err)
+		}
+		defer u.Stop()
+
+		call := func(protos []string) (string, error) {
+			conn, err := tls.Dial("tcp4", u.Addr().String(), &tls.Config{
+				RootCAs:    pool,
+				ServerName: "localhost",
+				NextProtos: protos,
+			})
+			if err != nil {
+				return "", err
+			}
+			defer conn.Close()
+
+			if _, err := conn.Write([]byte("Hello\n")); err != nil {
+				return "", err
+			}
+			return bufio.NewReader(conn).ReadString('\n')
+		}
+
+		if resp, err := call([]string{"alt/1"}); err != nil || resp != "ALT\n" {
+			t.Fatal("\tShould use the handlers for the negotiated protocol.", failed, resp, err)
+		}
+		t.Log("\tShould use the handlers for the negotiated protocol.", success)
+
+		if resp, err := call(nil); err != nil || resp != "GOT IT\n" {
+			t.Fatal("\tShould use the default handlers without a protocol.", failed, resp, err)
+		}
+		t.Log("\tShould use the default handlers without a protocol.", success)
+	}
+}
+
+// TestStartTLS tests a plaintext connection can be upgraded to TLS by a
+// protocol command.
+func TestStartTLS(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to upgrade a connection to TLS mid-connection.")
+	{
+		ca, caKey := newCA(t)
+		pool := x509.NewCertPool()
+		pool.AddCert(ca)
+
+		srvCfg := tls.Config{
+			Certificates: []tls.Certificate{newCert(t, ca, caKey, "localhost")},
+		}
+
+		cfg := tcp.Config{
+			NetType:     "tcp4",
+			Addr:        "127.0.0.1:0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  startTLSReqHandler{cfg: &srvCfg},
+			RespHandler: tcpRespHandler{},
+		}
+
+		u, err := tcp.New("TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+		if err := u.Start(); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", failed, err)
+		}
+		defer u.Stop()
+
+		conn, err := net.Dial("tcp4", u.Addr().String())
+		if err != nil {
+			t.Fatal("\tShould be able to dial a new TCP connection.", failed, err)
+		}
+		defer conn.Close()
+
+		if _, err := conn.Write([]byte("STARTTLS\n")); err != nil {
+			t.Fatal("\tShould be able to send the STARTTLS command.", failed, err)
+		}
+
+		resp, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil || resp != "GO AHEAD\n" {
+			t.Fatal("\tShould be told to begin the handshake.", failed, resp, err)
+		}
+		t.Log("\tShould be told to begin the handshake.", success)
+
+		tlsConn := tls.Client(conn, &tls.Config{RootCAs: pool, ServerName: "localhost"})
+		if err := tlsConn.Handshake(); err != nil {
+			t.Fatal("\tShould be able to complete the handshake.", failed, err)
+		}
+		t.Log("\tShould be able to complete the handshake.", success)
+
+		if _, err := tlsConn.Write([]byte("Hello\n")); err != nil {
+			t.Fatal("\tShould be able to send data over TLS.", failed, err)
+		}
+
+		resp, err = bufio.NewReader(tlsConn).ReadString('\n')
+		if err != nil || resp != "GOT IT\n" {
+			t.Fatal("\tShould receive the response over TLS.", failed, resp, err)
+		}
+		t.Log("\tShould receive the response over TLS.", success)
+	}
+}
+
+// TestCertStore tests certificates are selected by SNI and reloaded from
+// their files.
+func TestCertStore(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to serve certificates for several server names.")
+	{
+		ca, caKey := newCA(t)
+		pool := x509.NewCertPool()
+		pool.AddCert(ca)
+
+		dir := t.TempDir()
+		certFile := filepath.Join(dir, "a.crt")
+		keyFile := filepath.Join(dir, "a.key")
+		writeCertFiles(t, newCert(t, ca, caKey, "a.test"), certFile, keyFile)
+
+		store := tcp.NewCertStore()
+		if err := store.AddFiles("a.test", certFile, keyFile); err != nil {
+			t.Fatal("\tShould be able to load the certificate files.", failed, err)
+		}
+		store.Add("*.b.test", newCert(t, ca, caKey, "*.b.test"))
+		store.Add("", newCert(t, ca, caKey, "default"))
+
+		cfg := tcp.Config{
+			NetType:     "tcp4",
+			Addr:        "127.0.0.1:0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptTLS: tcp.OptTLS{
+				GetCertificate: store.GetCertificate,
+			},
+		}
+
+		u, err := tcp.New("TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+		if err := u.Start(); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", failed, err)
+		}
+		defer u.Stop()
+
+		served := func(name string) *x509.Certificate {
+			conn, err := tls.Dial("tcp4", u.Addr().String(), &tls.Config{
+				RootCAs:            pool,
+				ServerName:         name,
+				InsecureSkipVerify: name == "unknown.test",
+			})
+			if err != nil {
+				t.Fatal("\tShould be able to complete the handshake.", failed, name, err)
+			}
+			defer conn.Close()
+
+			return conn.ConnectionState().PeerCertificates[0]
+		}
+
+		for name, cn := range map[string]string{"a.test": "a.test", "x.b.test": "*.b.test", "unknown.test": "default"} {
+			if got := served(name).Subject.CommonName; got != cn {
+				t.Fatalf("\tShould serve the certificate for %s : got %s %s", name, got, failed)
+			}
+		}
+		t.Log("\tShould serve the certificate for each server name.", success)
+
+		before := served("a.test").SerialNumber
+		writeCertFiles(t, newCert(t, ca, caKey, "a.test"), certFile, keyFile)
+		if err := store.Reload(); err != nil {
+			t.Fatal("\tShould be able to reload the certificate files.", failed, err)
+		}
+
+		if after := served("a.test").SerialNumber; after.Cmp(before) == 0 {
+			t.Fatal("\tShould serve the reloaded certificate.", failed)
+		}
+		t.Log("\tShould serve the reloaded certificate.", success)
+	}
+}
+
+// TestCertFileReload tests the certificate is reloaded once its files
+// change.
+func TestCertFileReload(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to pick up renewed certificates without a restart.")
+	{
+		ca, caKey := newCA(t)
+		pool := x509.NewCertPool()
+		pool.AddCert(ca)
+
+		dir := t.TempDir()
+		certFile := filepath.Join(dir, "tls.crt")
+		keyFile := filepath.Join(dir, "tls.key")
+		writeCertFiles(t, newCert(t, ca, caKey, "localhost"), certFile, keyFile)
+
+		cfg := tcp.Config{
+			NetType:     "tcp4",
+			Addr:        "127.0.0.1:0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptTLS: tcp.OptTLS{
+				CertFile:       certFile,
+				KeyFile:        keyFile,
+				CertCheckEvery: 10 * time.Millisecond,
+			},
+		}
+
+		u, err := tcp.New("TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+		if err := u.Start(); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", failed, err)
+		}
+		defer u.Stop()
+
+		served := func() *big.Int {
+			conn, err := tls.Dial("tcp4", u.Addr().String(), &tls.Config{RootCAs: pool, ServerName: "localhost"})
+			if err != nil {
+				t.Fatal("\tShould be able to complete the handshake.", failed, err)
+			}
+			defer conn.Close()
+
+			return conn.ConnectionState().PeerCertificates[0].SerialNumber
+		}
+
+		before := served()
+		t.Log("\tShould serve the certificate from the files.", success)
+
+		writeCertFiles(t, newCert(t, ca, caKey, "localhost"), certFile, keyFile)
+
+		deadline := time.Now().Add(time.Second)
+		for served().Cmp(before) == 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("\tShould serve the renewed certificate.", failed)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Log("\tShould serve the renewed certificate.", success)
+	}
+}
+
+// TestSniff tests connections are routed by their first bytes.
+func TestSniff(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to serve TLS and plaintext protocols on one port.")
+	{
+		ca, caKey := newCA(t)
+		pool := x509.NewCertPool()
+		pool.AddCert(ca)
+
+		cfg := tcp.Config{
+			NetType:     "tcp4",
+			Addr:        "127.0.0.1:0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptTLS: tcp.OptTLS{
+				TLSConfig: &tls.Config{
+					Certificates: []tls.Certificate{newCert(t, ca, caKey, "localhost")},
+				},
+			},
+			OptSniff: tcp.OptSniff{
+				Sniff: []tcp.SniffRoute{
+					{Name: "tls", Match: tcp.SniffTLS, TLS: true},
+					{Name: "alt", Match: tcp.SniffPrefix([]byte("ALT ")), Handlers: tcp.HandlerSet{ReqHandler: altReqHandler{}}},
+					{Name: "plain"},
+				},
+			},
+		}
+
+		u, err := tcp.New("TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+		if err := u.Start(); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", failed, err)
+		}
+		defer u.Stop()
+
+		call := func(conn net.Conn, msg string) (string, error) {
+			defer conn.Close()
+
+			conn.SetDeadline(time.Now().Add(time.Second))
+			if _, err := conn.Write([]byte(msg)); err != nil {
+				return "", err
+			}
+			return bufio.NewReader(conn).ReadString('\n')
+		}
+
+		conn, err := tls.Dial("tcp4", u.Addr().String(), &tls.Config{RootCAs: pool, ServerName: "localhost"})
+		if err != nil {
+			t.Fatal("\tShould complete the TLS handshake.", failed, err)
+		}
+		if resp, err := call(conn, "Hello\n"); err != nil || resp != "GOT IT\n" {
+			t.Fatal("\tShould serve the TLS connection.", failed, resp, err)
+		}
+		t.Log("\tShould serve the TLS connection.", success)
+
+		for _, tt := range []struct{ msg, want string }{
+			{"ALT Hello\n", "ALT\n"},
+			{"Hi\n", "GOT IT\n"},
+		} {
+			conn, err := net.Dial("tcp4", u.Addr().String())
+			if err != nil {
+				t.Fatal("\tShould be able to dial a new TCP connection.", failed, err)
+			}
+			if resp, err := call(conn, tt.msg); err != nil || resp != tt.want {
+				t.Fatalf("\tShould route %q by its first bytes : %q %v %s", tt.msg, resp, err, failed)
+			}
+		}
+		t.Log("\tShould route the plaintext connections by their first bytes.", success)
+	}
+}
+
+// =============================================================================
+
+// newCA creates a self-signed certificate authority for the tests.
+func newCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("\tShould be able to generate a key.", failed, err)
+	}
+
+	tmpl := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal("\tShould be able to create the CA certificate.", failed, err)
+	}
+
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal("\tShould be able to parse the CA certificate.", failed, err)
+	}
+
+	return ca, key
+}
+
+// newCert creates a certificate signed by the CA usable by both sides of
+// a connection.
+func newCert(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, cn string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("\tShould be able to generate a key.", failed, err)
+	}
+
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal("\tShould be able to create the certificate.", failed, err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// writeCertFiles writes the certificate and its key as PEM files.
+func writeCertFiles(t *testing.T, cert tls.Certificate, certFile, keyFile string) {
+	key, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal("\tShould be able to marshal the key.", failed, err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key})
+
+	if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal("\tShould be able to write the certificate file.", failed, err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal("\tShould be able to write the key file.", failed, err)
+	}
+}
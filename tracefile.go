@@ -0,0 +1,298 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// defTraceFileSize is the size a trace file is rotated at by default.
+const defTraceFileSize = 64 << 20
+
+// traceMagic identifies the trace format and its version.
+var traceMagic = []byte{'T', 'C', 'P', 'T', 1}
+
+// TraceRecord is the bytes read from or written to a connection at a point
+// in time.
+type TraceRecord struct {
+	Time time.Time
+	IP   string
+	Dir  TapDir
+	Data []byte
+}
+
+// TraceRecorder writes the bytes passed to its Tap method to trace files,
+// rotating the files by size and age. Its Tap method is used as the Tap of
+// the configuration. Recording is switched on and off at runtime for all
+// the connections or for each of them. Records are buffered until Flush,
+// Close or the file is rotated.
+type TraceRecorder struct {
+	Dir     string        // Directory for the trace files.
+	Name    string        // Prefix of the file names.
+	MaxSize int64         // Bytes written to a file before it's rotated, defaults to 64MB.
+	MaxAge  time.Duration // Time a file is written to before it's rotated, 0 for no limit.
+	Clock   Clock         // Times the records and the rotation, defaults to the system clock.
+
+	mu      sync.Mutex
+	enabled bool
+	conns   map[string]bool
+	file    *os.File
+	w       *bufio.Writer
+	size    int64
+	opened  time.Time
+	seq     int
+	err     error
+}
+
+// NewTraceRecorder creates a recorder writing to files in the directory
+// named after the prefix. Recording starts enabled for all connections.
+func NewTraceRecorder(dir, name string) *TraceRecorder {
+	tr := TraceRecorder{
+		Dir:     dir,
+		Name:    name,
+		enabled: true,
+		conns:   make(map[string]bool),
+	}
+
+	return &tr
+}
+
+// SetEnabled switches recording on or off for the connections without a
+// setting of their own.
+func (tr *TraceRecorder) SetEnabled(on bool) {
+	tr.mu.Lock()
+	{
+		tr.enabled = on
+	}
+	tr.mu.Unlock()
+}
+
+// SetConn switches recording on or off for the connection, whatever the
+// setting for all connections. The address is the one passed to Tap, so
+// it's anonymized when OptPrivacy is configured.
+func (tr *TraceRecorder) SetConn(ipAddress string, on bool) {
+	tr.mu.Lock()
+	{
+		tr.conns[ipAddress] = on
+	}
+	tr.mu.Unlock()
+}
+
+// ClearConn removes the setting of the connection.
+func (tr *TraceRecorder) ClearConn(ipAddress string) {
+	tr.mu.Lock()
+	{
+		delete(tr.conns, ipAddress)
+	}
+	tr.mu.Unlock()
+}
+
+// Tap records the bytes when recording is on for the connection.
+func (tr *TraceRecorder) Tap(ipAddress string, dir TapDir, data []byte) {
+	now := time.Now().UTC()
+	if tr.Clock != nil {
+		now = tr.Clock.Now().UTC()
+	}
+
+	var b bytes.Buffer
+	putInt(&b, now.UnixNano())
+	b.WriteByte(byte(dir))
+	putString(&b, ipAddress)
+	putInt(&b, int64(len(data)))
+	b.Write(data)
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	on, ok := tr.conns[ipAddress]
+	if !ok {
+		on = tr.enabled
+	}
+
+	if !on || tr.err != nil {
+		return
+	}
+
+	if err := tr.rotate(now, int64(b.Len())); err != nil {
+		tr.err = err
+		return
+	}
+
+	n, err := b.WriteTo(tr.w)
+	tr.size += n
+	if err != nil {
+		tr.err = err
+	}
+}
+
+// rotate opens the next file when there is none or the current one is
+// full or too old.
+func (tr *TraceRecorder) rotate(now time.Time, n int64) error {
+	maxSize := tr.MaxSize
+	if maxSize <= 0 {
+		maxSize = defTraceFileSize
+	}
+
+	if tr.file != nil {
+		full := tr.size > int64(len(traceMagic)) && tr.size+n > maxSize
+		old := tr.MaxAge > 0 && now.Sub(tr.opened) >= tr.MaxAge
+		if !full && !old {
+			return nil
+		}
+
+		if err := tr.closeFile(); err != nil {
+			return err
+		}
+	}
+
+	tr.seq++
+	name := filepath.Join(tr.Dir, fmt.Sprintf("%s-%d-%d.trace", tr.Name, now.UnixNano(), tr.seq))
+
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+
+	tr.file = f
+	tr.w = bufio.NewWriter(f)
+	tr.opened = now
+
+	written, err := tr.w.Write(traceMagic)
+	tr.size = int64(written)
+	return err
+}
+
+// closeFile flushes and closes the current file.
+func (tr *TraceRecorder) closeFile() error {
+	err := tr.w.Flush()
+	if cerr := tr.file.Close(); err == nil {
+		err = cerr
+	}
+
+	tr.file = nil
+	tr.w = nil
+	tr.size = 0
+
+	return err
+}
+
+// Flush writes the buffered records to the current file. It returns the
+// error that stopped the recording, if any.
+func (tr *TraceRecorder) Flush() error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	if tr.err != nil {
+		return tr.err
+	}
+
+	if tr.w != nil {
+		tr.err = tr.w.Flush()
+	}
+
+	return tr.err
+}
+
+// Close flushes and closes the current file. Recording continues to a new
+// file if the recorder is still tapped.
+func (tr *TraceRecorder) Close() error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	if tr.file == nil {
+		return tr.err
+	}
+
+	if err := tr.closeFile(); err != nil && tr.err == nil {
+		tr.err = err
+	}
+
+	return tr.err
+}
+
+// =============================================================================
+
+// TraceReader reads the records of a trace file.
+type TraceReader struct {
+	r *bufio.Reader
+}
+
+// NewTraceReader validates the reader holds a trace file and returns a
+// TraceReader reading its records.
+func NewTraceReader(r io.Reader) (*TraceReader, error) {
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(traceMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(magic, traceMagic) {
+		return nil, errors.New("invalid trace format")
+	}
+
+	return &TraceReader{r: br}, nil
+}
+
+// Next returns the next record, or io.EOF once all records are read.
+func (tr *TraceReader) Next() (TraceRecord, error) {
+	at, err := binary.ReadVarint(tr.r)
+	if err != nil {
+		return TraceRecord{}, err
+	}
+
+	var rec TraceRecord
+	rec.Time = time.Unix(0, at).UTC()
+
+	// A record cut short is an unexpected end of the file.
+	unexpected := func(err error) error {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	dir, err := tr.r.ReadByte()
+	if err != nil {
+		return TraceRecord{}, unexpected(err)
+	}
+	rec.Dir = TapDir(dir)
+
+	ip, err := tr.bytes(1 << 16)
+	if err != nil {
+		return TraceRecord{}, unexpected(err)
+	}
+	rec.IP = string(ip)
+
+	if rec.Data, err = tr.bytes(1 << 30); err != nil {
+		return TraceRecord{}, unexpected(err)
+	}
+
+	return rec, nil
+}
+
+// bytes reads the length of the bytes followed by the bytes.
+func (tr *TraceReader) bytes(max int64) ([]byte, error) {
+	l, err := binary.ReadVarint(tr.r)
+	if err != nil {
+		return nil, err
+	}
+
+	if l < 0 || l > max {
+		return nil, errors.New("invalid trace record length")
+	}
+
+	b := make([]byte, l)
+	if _, err := io.ReadFull(tr.r, b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
@@ -0,0 +1,215 @@
+package tcp_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/tcp"
+	"github.com/ardanlabs/tcp/tcptest"
+)
+
+// TestTraceRecorder tests the traffic tapped is written to trace files.
+func TestTraceRecorder(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to record the traffic to trace files.")
+	{
+		dir := t.TempDir()
+		rec := tcp.NewTraceRecorder(dir, "TEST")
+		rec.MaxSize = 64
+
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptTap: tcp.OptTap{
+				Tap: rec.Tap,
+			},
+		})
+
+		c1 := s.Dial(t, tcptest.Lines)
+		c2 := s.Dial(t, tcptest.Lines)
+
+		rec.SetEnabled(false)
+		rec.SetConn(c1.LocalAddr().String(), true)
+
+		c1.RoundTrip([]byte("Hello"), []byte("Hello"))
+		c2.RoundTrip([]byte("Other"), []byte("Other"))
+		c1.RoundTrip([]byte("World"), []byte("World"))
+
+		s.Stop()
+		if err := rec.Close(); err != nil {
+			t.Fatalf("\tShould be able to close the recorder : %v %s", err, failed)
+		}
+
+		files, _ := filepath.Glob(filepath.Join(dir, "TEST-*.trace"))
+		if len(files) < 2 {
+			t.Fatalf("\tShould rotate the files by size : %v %s", files, failed)
+		}
+		t.Log("\tShould rotate the files by size.", success)
+
+		// The names sort in the order the files were written.
+		sort.Strings(files)
+
+		var in, out bytes.Buffer
+		for _, file := range files {
+			f, err := os.Open(file)
+			if err != nil {
+				t.Fatalf("\tShould be able to open the trace file : %v %s", err, failed)
+			}
+
+			tr, err := tcp.NewTraceReader(f)
+			if err != nil {
+				t.Fatalf("\tShould be able to read the trace file : %v %s", err, failed)
+			}
+
+			for {
+				r, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("\tShould be able to read the records : %v %s", err, failed)
+				}
+				if r.IP != c1.LocalAddr().String() {
+					t.Fatalf("\tShould only record the connection enabled : %s %s", r.IP, failed)
+				}
+
+				switch r.Dir {
+				case tcp.TapIn:
+					in.Write(r.Data)
+				case tcp.TapOut:
+					out.Write(r.Data)
+				}
+			}
+			f.Close()
+		}
+
+		if in.String() != "Hello\nWorld\n" || out.String() != "Hello\nWorld\n" {
+			t.Fatalf("\tShould record the bytes in each direction : %q %q %s", in.String(), out.String(), failed)
+		}
+		t.Log("\tShould record the bytes of the connection enabled.", success)
+
+		if _, err := tcp.NewTraceReader(bytes.NewReader([]byte("garbage"))); err == nil {
+			t.Fatalf("\tShould reject data that is not a trace %s", failed)
+		}
+		t.Log("\tShould reject data that is not a trace.", success)
+
+		clock := tcptest.NewClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+		rec = tcp.NewTraceRecorder(t.TempDir(), "CLOCK")
+		rec.Clock = clock
+		rec.Tap("10.0.0.1:5000", tcp.TapIn, []byte("Hello"))
+		if err := rec.Close(); err != nil {
+			t.Fatalf("\tShould be able to close the recorder : %v %s", err, failed)
+		}
+
+		files, _ = filepath.Glob(filepath.Join(rec.Dir, "CLOCK-*.trace"))
+		if len(files) != 1 {
+			t.Fatalf("\tShould record one trace file : %v %s", files, failed)
+		}
+		f, err := os.Open(files[0])
+		if err != nil {
+			t.Fatalf("\tShould be able to open the trace file : %v %s", err, failed)
+		}
+		defer f.Close()
+
+		tr, err := tcp.NewTraceReader(f)
+		if err != nil {
+			t.Fatalf("\tShould be able to read the trace file : %v %s", err, failed)
+		}
+		if r, err := tr.Next(); err != nil || !r.Time.Equal(clock.Now()) {
+			t.Fatalf("\tShould time the records with the clock : %+v %v %s", r, err, failed)
+		}
+		t.Log("\tShould time the records with the clock.", success)
+	}
+}
+
+// TestReplay tests the traffic recorded is replayed against a server.
+func TestReplay(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to replay the traffic recorded for regressions.")
+	{
+		dir := t.TempDir()
+		rec := tcp.NewTraceRecorder(dir, "TEST")
+
+		s := tcptest.NewServer(t, tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  echoReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptTap: tcp.OptTap{
+				Tap: rec.Tap,
+			},
+		})
+
+		c1 := s.Dial(t, tcptest.Lines)
+		c2 := s.Dial(t, tcptest.Lines)
+		c1.RoundTrip([]byte("Hello"), []byte("Hello"))
+		c2.RoundTrip([]byte("Other"), []byte("Other"))
+		c1.RoundTrip([]byte("World"), []byte("World"))
+
+		s.Stop()
+		if err := rec.Close(); err != nil {
+			t.Fatalf("\tShould be able to close the recorder : %v %s", err, failed)
+		}
+
+		files, _ := filepath.Glob(filepath.Join(dir, "TEST-*.trace"))
+		if len(files) != 1 {
+			t.Fatalf("\tShould record one trace file : %v %s", files, failed)
+		}
+
+		replay := func(rh tcp.ReqHandler) tcp.ReplayResult {
+			s := tcptest.NewServer(t, tcp.Config{
+				ConnHandler: tcpConnHandler{},
+				ReqHandler:  rh,
+				RespHandler: tcpRespHandler{},
+			})
+
+			f, err := os.Open(files[0])
+			if err != nil {
+				t.Fatalf("\tShould be able to open the trace file : %v %s", err, failed)
+			}
+			defer f.Close()
+
+			tr, err := tcp.NewTraceReader(f)
+			if err != nil {
+				t.Fatalf("\tShould be able to read the trace file : %v %s", err, failed)
+			}
+
+			res, err := tcp.Replay(context.Background(), tr, tcp.ReplayConfig{
+				Dial: func(ctx context.Context) (net.Conn, error) {
+					return s.Listener.Dial()
+				},
+				Wait: 100 * time.Millisecond,
+			})
+			if err != nil {
+				t.Fatalf("\tShould be able to replay the trace : %v %s", err, failed)
+			}
+
+			return res
+		}
+
+		res := replay(echoReqHandler{})
+		if res.Conns != 2 || res.BytesSent != 18 || res.BytesReceived != 18 || len(res.Mismatches) != 0 {
+			t.Fatalf("\tShould get the responses recorded : %+v %s", res, failed)
+		}
+		t.Log("\tShould get the responses recorded.", success)
+
+		res = replay(altReqHandler{})
+		if len(res.Mismatches) != 2 {
+			t.Fatalf("\tShould report the responses that differ : %+v %s", res, failed)
+		}
+		t.Log("\tShould report the responses that differ.", success)
+	}
+}
@@ -0,0 +1,59 @@
+package tcp
+
+import (
+	"context"
+	"net"
+)
+
+// tracerName identifies this package to the TracerProvider.
+const tracerName = "github.com/ardanlabs/tcp"
+
+// Span represents a unit of work in a trace. The interfaces mirror the
+// shape of OpenTelemetry so an adapter takes a few lines of code without
+// this package depending on it.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+// Tracer creates spans as children of the span in the context.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// TracerProvider provides the tracer for an instrumentation name.
+type TracerProvider interface {
+	Tracer(name string) Tracer
+}
+
+// startSpan starts a span when tracing is configured. The returned span
+// is nil otherwise.
+func (t *TCP) startSpan(ctx context.Context, name string) (context.Context, Span) {
+	if t.tracer == nil {
+		return ctx, nil
+	}
+	return t.tracer.Start(ctx, name)
+}
+
+// startRequestSpan starts the span covering the lifetime of a request and
+// records the connection attributes on it.
+func (c *client) startRequestSpan(tcpAddr *net.TCPAddr, length int) (context.Context, Span) {
+	ctx, span := c.t.startSpan(context.Background(), "tcp.request")
+	if span == nil {
+		return ctx, nil
+	}
+
+	span.SetAttribute("net.peer.ip", c.t.anonymize(tcpAddr.IP.String()))
+	if c.t.Anonymize == nil {
+		span.SetAttribute("net.peer.port", tcpAddr.Port)
+	}
+	span.SetAttribute("tcp.server", c.t.Name)
+	span.SetAttribute("tcp.read.bytes", length)
+	span.SetAttribute("tcp.tls", c.tlsConn != nil)
+	if c.identity != "" {
+		span.SetAttribute("tcp.identity", c.identity)
+	}
+
+	return ctx, span
+}
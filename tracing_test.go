@@ -0,0 +1,120 @@
+package tcp_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/tcp"
+)
+
+// TestTracing tests a span is created for the request and its writes.
+func TestTracing(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to trace requests.")
+	{
+		var tr testTracer
+
+		cfg := tcp.Config{
+			NetType:     "tcp4",
+			Addr:        ":0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptTracing: tcp.OptTracing{
+				TracerProvider: &tr,
+			},
+		}
+
+		u, err := tcp.New("TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", failed, err)
+		}
+		if err := u.Start(); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", failed, err)
+		}
+		defer u.Stop()
+
+		conn, err := net.Dial("tcp4", u.Addr().String())
+		if err != nil {
+			t.Fatal("\tShould be able to dial a new TCP connection.", failed, err)
+		}
+		defer conn.Close()
+
+		conn.Write([]byte("Hello\n"))
+		if _, err := bufio.NewReader(conn).ReadString('\n'); err != nil {
+			t.Fatal("\tShould be able to read the response from the connection.", failed, err)
+		}
+
+		// The request span ends after the response is written.
+		spans := tr.ended()
+		for i := 0; i < 100 && len(spans) < 2; i++ {
+			time.Sleep(10 * time.Millisecond)
+			spans = tr.ended()
+		}
+
+		if len(spans) != 2 || spans[0].name != "tcp.write" || spans[1].name != "tcp.request" {
+			t.Fatalf("\tShould end a write span inside the request span. %s %v", failed, spans)
+		}
+		t.Log("\tShould end a write span inside the request span.", success)
+
+		if spans[0].parent != spans[1] {
+			t.Fatal("\tShould make the write span a child of the request span.", failed)
+		}
+		t.Log("\tShould make the write span a child of the request span.", success)
+
+		if spans[1].attrs["net.peer.ip"] != "127.0.0.1" {
+			t.Fatal("\tShould record the connection attributes.", failed, spans[1].attrs)
+		}
+		t.Log("\tShould record the connection attributes.", success)
+	}
+}
+
+// =============================================================================
+
+// testSpan records what is done to a span.
+type testSpan struct {
+	tr     *testTracer
+	name   string
+	parent *testSpan
+	attrs  map[string]interface{}
+}
+
+func (s *testSpan) SetAttribute(key string, value interface{}) { s.attrs[key] = value }
+func (s *testSpan) RecordError(err error)                      {}
+func (s *testSpan) End()                                       { s.tr.end(s) }
+
+// spanKey is the context key for the current span.
+type spanKey struct{}
+
+// testTracer implements tcp.TracerProvider and tcp.Tracer.
+type testTracer struct {
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+func (tr *testTracer) Tracer(name string) tcp.Tracer { return tr }
+
+func (tr *testTracer) Start(ctx context.Context, name string) (context.Context, tcp.Span) {
+	parent, _ := ctx.Value(spanKey{}).(*testSpan)
+	s := testSpan{tr: tr, name: name, parent: parent, attrs: make(map[string]interface{})}
+	return context.WithValue(ctx, spanKey{}, &s), &s
+}
+
+func (tr *testTracer) end(s *testSpan) {
+	tr.mu.Lock()
+	tr.spans = append(tr.spans, s)
+	tr.mu.Unlock()
+}
+
+func (tr *testTracer) ended() []*testSpan {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	return append([]*testSpan(nil), tr.spans...)
+}
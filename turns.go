@@ -0,0 +1,71 @@
+package tcp
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+)
+
+// Set of turn violations reported in half duplex mode.
+var (
+	ErrTurnOutOfTurn = errors.New("response written out of turn")
+	ErrTurnNoReply   = errors.New("request processed without a response")
+	ErrTurnEarlyRead = errors.New("request sent before the response")
+)
+
+// Turns of a half duplex connection.
+const (
+	turnClient = iota
+	turnServer
+)
+
+// requestTurn hands the turn to the server once a request is read. Data
+// already buffered behind the request means the client did not wait for
+// the response, which breaks the protocol state so it's reported false.
+func (c *client) requestTurn() bool {
+
+	// buffered is declared to test for the existence of the method
+	// coming from the bufio package.
+	type buffered interface {
+		Buffered() int
+	}
+
+	if b, ok := c.reader.(buffered); ok && b.Buffered() > 0 {
+		c.turnViolation(ErrTurnEarlyRead)
+		return false
+	}
+
+	atomic.StoreInt32(&c.turn, turnServer)
+	return true
+}
+
+// replyTurn takes the server's turn to write the response, so only one
+// response is written for each request.
+func (c *client) replyTurn() error {
+	if !c.t.HalfDuplex {
+		return nil
+	}
+
+	if !atomic.CompareAndSwapInt32(&c.turn, turnServer, turnClient) {
+		c.turnViolation(ErrTurnOutOfTurn)
+		return ErrTurnOutOfTurn
+	}
+
+	return nil
+}
+
+// endTurn hands the turn back to the client once the request is processed.
+func (c *client) endTurn() {
+	if atomic.SwapInt32(&c.turn, turnClient) == turnServer {
+		c.turnViolation(ErrTurnNoReply)
+	}
+}
+
+// turnViolation reports the violation of the turns.
+func (c *client) turnViolation(err error) {
+	c.t.Event(EvtRead, TypError, c.ipAddress, "half duplex : %v", err)
+	c.strike(StrikeTurnViolation)
+	if c.t.OnTurnViolation != nil {
+		c.t.OnTurnViolation(c.conn.RemoteAddr().(*net.TCPAddr), err)
+	}
+}
@@ -0,0 +1,139 @@
+package tcp
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrNoReply is returned by a typed handler to send no response to the
+// request.
+var ErrNoReply = errors.New("no reply")
+
+// Codec converts the messages of a protocol to typed values. Read returns
+// the bytes of the next request off the reader bound to the connection,
+// Decode converts them to a request and Encode converts a response to the
+// bytes written back.
+type Codec[Req, Resp any] interface {
+	Read(reader io.Reader) ([]byte, error)
+	Decode(data []byte) (Req, error)
+	Encode(resp Resp) ([]byte, error)
+}
+
+// Handler processes a decoded request and returns the response to send.
+// The request provides the connection and the context of the request.
+// Returning ErrNoReply sends nothing and any other error is reported as
+// an event without closing the connection.
+type Handler[Req, Resp any] func(r *Request, req Req) (Resp, error)
+
+// Handlers returns the request and response handlers serving the typed
+// handler with the codec, such as for a Config, a virtual server or a
+// UDP value. The ConnHandler is left for the configuration to provide.
+func Handlers[Req, Resp any](codec Codec[Req, Resp], fn Handler[Req, Resp]) HandlerSet {
+	th := typedHandler[Req, Resp]{
+		codec: codec,
+		fn:    fn,
+	}
+
+	return HandlerSet{
+		ReqHandler:  th,
+		RespHandler: th,
+	}
+}
+
+// Serve creates and starts a TCP value serving the typed handler with the
+// codec. The handlers of the configuration are replaced, and connections
+// are bound to pooled buffers when no ConnHandler is configured.
+func Serve[Req, Resp any](name string, cfg Config, codec Codec[Req, Resp], fn Handler[Req, Resp]) (*TCP, error) {
+	hs := Handlers(codec, fn)
+	cfg.ReqHandler = hs.ReqHandler
+	cfg.RespHandler = hs.RespHandler
+
+	if cfg.ConnHandler == nil {
+		cfg.ConnHandler = BufferedConnHandler{
+			ReadBufferSize:  cfg.ReadBufferSize,
+			WriteBufferSize: cfg.WriteBufferSize,
+		}
+	}
+
+	t, err := New(name, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.Start(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// =============================================================================
+
+// typedHandler adapts a typed handler and its codec to the ReqHandler and
+// RespHandler interfaces.
+type typedHandler[Req, Resp any] struct {
+	codec Codec[Req, Resp]
+	fn    Handler[Req, Resp]
+}
+
+// Read implements the ReqHandler interface.
+func (th typedHandler[Req, Resp]) Read(ipAddress string, reader io.Reader) ([]byte, int, error) {
+	data, err := th.codec.Read(reader)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return data, len(data), nil
+}
+
+// Process implements the ReqHandler interface.
+func (th typedHandler[Req, Resp]) Process(r *Request) {
+	req, err := th.codec.Decode(r.Data[:r.Length])
+	if err != nil {
+		r.event(EvtRead, TypError, "decode : %v", err)
+		return
+	}
+
+	resp, err := th.fn(r, req)
+	if err != nil {
+		if !errors.Is(err, ErrNoReply) {
+			r.event(EvtRoute, TypError, "handler : %v", err)
+		}
+		return
+	}
+
+	data, err := th.codec.Encode(resp)
+	if err != nil {
+		r.event(EvtWrite, TypError, "encode : %v", err)
+		return
+	}
+
+	out := Response{
+		TCPAddr: r.TCPAddr,
+		Data:    data,
+		Length:  len(data),
+	}
+
+	if err := r.Send(r.Context, &out); err != nil {
+		r.event(EvtWrite, TypError, "send : %v", err)
+	}
+}
+
+// Write implements the RespHandler interface.
+func (th typedHandler[Req, Resp]) Write(r *Response, writer io.Writer) error {
+	if _, err := writer.Write(r.Data[:r.Length]); err != nil {
+		return err
+	}
+
+	// flusher is declared to test for the existence of the method
+	// coming from the bufio package.
+	type flusher interface {
+		Flush() error
+	}
+
+	if f, ok := writer.(flusher); ok {
+		return f.Flush()
+	}
+
+	return nil
+}
@@ -0,0 +1,365 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defMaxDatagramSize is the largest datagram read by default.
+const defMaxDatagramSize = 64 * 1024
+
+// UDPConfig provides the configuration for a UDP value. The handlers are
+// the same ones used by TCP values so protocol code can be shared.
+type UDPConfig struct {
+	NetType string // "udp", udp4" or "udp6"
+	Addr    string // "host:port" or "[ipv6-host%zone]:port"
+
+	ConnHandler ConnHandler // Binds each datagram to a reader and writer.
+	ReqHandler  ReqHandler  // Reads the request out of each datagram.
+	RespHandler RespHandler // Writes each response as one datagram.
+
+	// *************************************************************************
+	// ** Not Required, optional                                              **
+	// *************************************************************************
+
+	MaxDatagramSize int // Defaults to 64k.
+
+	OptEvent
+	OptClock
+}
+
+// Validate checks the configuration to required items.
+func (cfg *UDPConfig) Validate() error {
+	if cfg == nil {
+		return ErrInvalidConfiguration
+	}
+
+	if cfg.NetType != "udp" && cfg.NetType != "udp4" && cfg.NetType != "udp6" {
+		return ErrInvalidNetType
+	}
+
+	if cfg.ConnHandler == nil {
+		return ErrInvalidConnHandler
+	}
+
+	if cfg.ReqHandler == nil {
+		return ErrInvalidReqHandler
+	}
+
+	if cfg.RespHandler == nil {
+		return ErrInvalidRespHandler
+	}
+
+	return nil
+}
+
+// Event fires events back to the user for important events.
+func (cfg *UDPConfig) Event(evt, typ int, ipAddress string, format string, a ...interface{}) {
+	if cfg.OptEvent.Event != nil {
+		cfg.OptEvent.Event(evt, typ, ipAddress, format, a...)
+	}
+}
+
+// clock returns the configured clock or the system clock.
+func (cfg *UDPConfig) clock() Clock {
+	if cfg.Clock == nil {
+		return systemClock{}
+	}
+	return cfg.Clock
+}
+
+// now returns the current time in UTC from the configured clock.
+func (cfg *UDPConfig) now() time.Time {
+	return cfg.clock().Now().UTC()
+}
+
+// UDP serves datagrams with the same handlers as a TCP value. Every
+// datagram is bound with the ConnHandler as if it was a connection holding
+// a single request, so codecs written for streams work unchanged as long
+// as a request fits in a datagram.
+type UDP struct {
+	UDPConfig
+	Name string
+
+	udpAddr *net.UDPAddr
+
+	conn   *net.UDPConn
+	connMu sync.Mutex
+
+	wg           sync.WaitGroup
+	done         chan struct{}
+	shuttingDown int32
+}
+
+// NewUDP creates a new manager to service datagrams.
+func NewUDP(name string, cfg UDPConfig) (*UDP, error) {
+
+	// Validate the configuration.
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Resolve the addr that is provided.
+	udpAddr, err := net.ResolveUDPAddr(cfg.NetType, cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	u := UDP{
+		UDPConfig: cfg,
+		Name:      name,
+		udpAddr:   udpAddr,
+	}
+
+	return &u, nil
+}
+
+// Start opens the socket and begins to read datagrams.
+func (u *UDP) Start() error {
+	var conn *net.UDPConn
+	var done chan struct{}
+	u.connMu.Lock()
+	{
+		// If the socket has been opened already, return an error.
+		if u.conn != nil {
+			u.connMu.Unlock()
+			return errors.New("this UDP has already been started")
+		}
+
+		var err error
+		if conn, err = net.ListenUDP(u.NetType, u.udpAddr); err != nil {
+			u.connMu.Unlock()
+			return err
+		}
+
+		// The read routine runs until this channel is closed. It's
+		// created and counted with the socket so Stop always finds the
+		// channel of the socket it closes and waits for the routine.
+		done = make(chan struct{})
+		u.done = done
+		u.conn = conn
+		u.wg.Add(1)
+		atomic.StoreInt32(&u.shuttingDown, 0)
+	}
+	u.connMu.Unlock()
+
+	u.Event(EvtAccept, TypInfo, conn.LocalAddr().String(), "waiting")
+
+	size := u.MaxDatagramSize
+	if size <= 0 {
+		size = defMaxDatagramSize
+	}
+
+	// Start the read routine.
+	go func() {
+		defer u.wg.Done()
+
+		// The extra byte tells a datagram of the maximum size apart from
+		// a larger one the read truncated.
+		buf := make([]byte, size+1)
+		var failures int
+		for {
+			n, raddr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				if atomic.LoadInt32(&u.shuttingDown) == 1 {
+					u.Event(EvtAccept, TypInfo, conn.LocalAddr().String(), "shutdown")
+					return
+				}
+
+				failures++
+				u.Event(EvtRead, TypError, conn.LocalAddr().String(), "Failures[ %d ] : %v", failures, err)
+
+				// Back off before reading again so a persistent error
+				// doesn't spin this routine.
+				timer := u.clock().NewTimer(backoff(failures, defAcceptBackoffMin, defAcceptBackoffMax))
+				select {
+				case <-timer.C():
+				case <-done:
+					timer.Stop()
+				}
+
+				continue
+			}
+			failures = 0
+
+			if n > size {
+				u.Event(EvtDrop, TypError, raddr.String(), "%v : Max[ %d ]", ErrFrameTooLarge, size)
+				continue
+			}
+
+			// The handlers may hold on to the data so each datagram
+			// gets its own copy.
+			u.process(append([]byte(nil), buf[:n]...), raddr)
+		}
+	}()
+
+	return nil
+}
+
+// process reads the request out of the datagram and processes it on the
+// read routine.
+func (u *UDP) process(datagram []byte, raddr *net.UDPAddr) {
+	ipAddress := raddr.String()
+	readAt := u.now()
+
+	dc := datagramConn{
+		Reader: bytes.NewReader(datagram),
+		local:  u.Addr(),
+		remote: raddr,
+	}
+
+	// The handler gives back what it bound once the datagram is done.
+	reader, writer := u.ConnHandler.Bind(&dc)
+	if ub, ok := u.ConnHandler.(Unbinder); ok {
+		defer ub.Unbind(reader, writer)
+	}
+
+	data, length, err := u.ReqHandler.Read(ipAddress, reader)
+	if err != nil {
+		u.Event(EvtRead, TypError, ipAddress, "%v", err)
+		return
+	}
+
+	r := Request{
+		UDP: u,
+		TCPAddr: &net.TCPAddr{
+			IP:   raddr.IP,
+			Port: raddr.Port,
+			Zone: raddr.Zone,
+		},
+		IsIPv6:  raddr.IP.To4() == nil,
+		ReadAt:  readAt,
+		Context: context.Background(),
+		Data:    data,
+		Length:  length,
+	}
+
+	u.ReqHandler.Process(&r)
+}
+
+// Stop closes the socket and waits for the read routine to finish.
+func (u *UDP) Stop() error {
+	var conn *net.UDPConn
+	var done chan struct{}
+	u.connMu.Lock()
+	{
+		// If the socket has been closed already, return an error.
+		if u.conn == nil {
+			u.connMu.Unlock()
+			return errors.New("this UDP has already been stopped")
+		}
+
+		conn, done = u.conn, u.done
+		u.conn = nil
+	}
+	u.connMu.Unlock()
+
+	atomic.StoreInt32(&u.shuttingDown, 1)
+	close(done)
+	err := conn.Close()
+	u.wg.Wait()
+
+	return err
+}
+
+// Send writes the response as a datagram to the address in the response.
+// The RespHandler writes to the writer bound by the ConnHandler and the
+// datagram is sent once the response is complete.
+func (u *UDP) Send(ctx context.Context, r *Response) error {
+	var conn *net.UDPConn
+	u.connMu.Lock()
+	{
+		conn = u.conn
+	}
+	u.connMu.Unlock()
+
+	if conn == nil {
+		return errors.New("this UDP has not been started")
+	}
+
+	raddr := net.UDPAddr{
+		IP:   r.TCPAddr.IP,
+		Port: r.TCPAddr.Port,
+		Zone: r.TCPAddr.Zone,
+	}
+
+	dc := datagramConn{
+		Reader: bytes.NewReader(nil),
+		local:  conn.LocalAddr(),
+		remote: &raddr,
+	}
+
+	// The buffers of a vectored response make up the datagram.
+	if vh, ok := u.RespHandler.(VectorRespHandler); ok {
+		bufs, err := vh.Buffers(r)
+		if err != nil {
+			return err
+		}
+		bufs.WriteTo(&dc.out)
+	} else {
+		reader, writer := u.ConnHandler.Bind(&dc)
+		err := u.RespHandler.Write(r, writer)
+		if ub, ok := u.ConnHandler.(Unbinder); ok {
+			ub.Unbind(reader, writer)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := conn.WriteToUDP(dc.out.Bytes(), &raddr)
+	return err
+}
+
+// Addr returns the socket's network address. This may be different than
+// the values provided in the configuration, for example if configuration
+// port value is 0.
+func (u *UDP) Addr() net.Addr {
+	u.connMu.Lock()
+	defer u.connMu.Unlock()
+
+	if u.conn == nil {
+		return nil
+	}
+	return u.conn.LocalAddr()
+}
+
+// =============================================================================
+
+// datagramConn presents a datagram as a connection to the ConnHandler.
+// Reading returns the datagram followed by io.EOF and writes are collected
+// into the datagram to send.
+type datagramConn struct {
+	*bytes.Reader
+	out    bytes.Buffer
+	local  net.Addr
+	remote net.Addr
+}
+
+// Write implements the io.Writer interface.
+func (dc *datagramConn) Write(b []byte) (int, error) {
+	return dc.out.Write(b)
+}
+
+// Close implements the io.Closer interface.
+func (dc *datagramConn) Close() error { return nil }
+
+// LocalAddr returns the local network address.
+func (dc *datagramConn) LocalAddr() net.Addr { return dc.local }
+
+// RemoteAddr returns the remote network address.
+func (dc *datagramConn) RemoteAddr() net.Addr { return dc.remote }
+
+// SetDeadline is a no-op since the datagram is held in memory.
+func (dc *datagramConn) SetDeadline(t time.Time) error { return nil }
+
+// SetReadDeadline is a no-op since the datagram is held in memory.
+func (dc *datagramConn) SetReadDeadline(t time.Time) error { return nil }
+
+// SetWriteDeadline is a no-op since the datagram is held in memory.
+func (dc *datagramConn) SetWriteDeadline(t time.Time) error { return nil }
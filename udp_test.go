@@ -0,0 +1,134 @@
+package tcp_test
+
+import (
+	"net"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/tcp"
+)
+
+// TestUDP tests the TCP handlers can serve datagrams.
+func TestUDP(t *testing.T) {
+	resetLog()
+	defer displayLog()
+
+	t.Log("Given the need to share handlers between TCP and UDP.")
+	{
+		rt := tcp.NewRouter(func(r *tcp.Request) string { return strings.TrimSpace(string(r.Data)) })
+		rt.Handle("PING", tcp.Reply([]byte("PONG\n")))
+
+		cfg := tcp.UDPConfig{
+			NetType:     "udp4",
+			Addr:        "127.0.0.1:0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  routedReqHandler{rt},
+			RespHandler: tcpRespHandler{},
+		}
+
+		u, err := tcp.NewUDP("TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new UDP listener.", failed, err)
+		}
+		if err := u.Start(); err != nil {
+			t.Fatal("\tShould be able to start the UDP listener.", failed, err)
+		}
+		t.Log("\tShould be able to start the UDP listener.", success)
+
+		defer u.Stop()
+
+		conn, err := net.Dial("udp4", u.Addr().String())
+		if err != nil {
+			t.Fatal("\tShould be able to dial the UDP listener.", failed, err)
+		}
+		defer conn.Close()
+
+		if _, err := conn.Write([]byte("PING\n")); err != nil {
+			t.Fatal("\tShould be able to send a datagram.", failed, err)
+		}
+
+		buf := make([]byte, 64)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, err := conn.Read(buf)
+		if err != nil || string(buf[:n]) != "PONG\n" {
+			t.Fatal("\tShould receive the response as a datagram.", failed, string(buf[:n]), err)
+		}
+		t.Log("\tShould receive the response as a datagram.", success)
+	}
+
+	t.Log("Given the need to give back what is bound for each datagram.")
+	{
+		var binds, unbinds int32
+		rt := tcp.NewRouter(func(r *tcp.Request) string { return strings.TrimSpace(string(r.Data)) })
+		rt.Handle("PING", tcp.Reply([]byte("PONG\n")))
+
+		u, err := tcp.NewUDP("TEST", tcp.UDPConfig{
+			NetType:     "udp4",
+			Addr:        "127.0.0.1:0",
+			ConnHandler: unbindConnHandler{binds: &binds, unbinds: &unbinds},
+			ReqHandler:  routedReqHandler{rt},
+			RespHandler: tcpRespHandler{},
+		})
+		if err != nil {
+			t.Fatal("\tShould be able to create a new UDP listener.", failed, err)
+		}
+		if err := u.Start(); err != nil {
+			t.Fatal("\tShould be able to start the UDP listener.", failed, err)
+		}
+		defer u.Stop()
+
+		conn, err := net.Dial("udp4", u.Addr().String())
+		if err != nil {
+			t.Fatal("\tShould be able to dial the UDP listener.", failed, err)
+		}
+		defer conn.Close()
+
+		buf := make([]byte, 64)
+		for i := 0; i < 3; i++ {
+			conn.Write([]byte("PING\n"))
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			if _, err := conn.Read(buf); err != nil {
+				t.Fatal("\tShould receive the response as a datagram.", failed, err)
+			}
+		}
+
+		// The datagram is unbound once processed, after the response.
+		for end := time.Now().Add(time.Second); atomic.LoadInt32(&unbinds) < 6 && time.Now().Before(end); {
+			time.Sleep(time.Millisecond)
+		}
+		if b, ub := atomic.LoadInt32(&binds), atomic.LoadInt32(&unbinds); b != 6 || ub != 6 {
+			t.Fatalf("\tShould unbind every request and response : %d %d %s", b, ub, failed)
+		}
+		t.Log("\tShould unbind every request and response.", success)
+	}
+
+	t.Log("Given the need to stop while the socket is starting.")
+	{
+		u, err := tcp.NewUDP("TEST", tcp.UDPConfig{
+			NetType:     "udp4",
+			Addr:        "127.0.0.1:0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+		})
+		if err != nil {
+			t.Fatal("\tShould be able to create a new UDP listener.", failed, err)
+		}
+
+		// Stop races the start of the socket every time.
+		for i := 0; i < 100; i++ {
+			started := make(chan error, 1)
+			go func(u *tcp.UDP) { started <- u.Start() }(u)
+			for u.Stop() != nil {
+				runtime.Gosched()
+			}
+			if err := <-started; err != nil {
+				t.Fatal("\tShould be able to start the UDP listener.", failed, err)
+			}
+		}
+		t.Log("\tShould stop while the socket is starting.", success)
+	}
+}
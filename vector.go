@@ -0,0 +1,61 @@
+package tcp
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// VectorRespHandler is implemented by response handlers that encode a
+// response into several buffers, such as a header and a body, so they are
+// written with one writev call instead of being copied into one buffer.
+// TCP and UDP values don't call Write for the handlers implementing it,
+// and the buffers of a UDP response make up its datagram. Anything buffered
+// in the writer bound to the connection is flushed first, and the Body of
+// the response follows the buffers.
+type VectorRespHandler interface {
+	RespHandler
+	Buffers(r *Response) (net.Buffers, error)
+}
+
+// buffersWriter is implemented by the connections that keep the vectored
+// write of the connection below them.
+type buffersWriter interface {
+	writeBuffers(bufs *net.Buffers) (int64, error)
+}
+
+// writeBuffers writes the buffers to the connection below, which uses
+// writev when it's a socket.
+func (cc *countConn) writeBuffers(bufs *net.Buffers) (int64, error) {
+	n, err := bufs.WriteTo(cc.Conn)
+	atomic.AddInt64(&cc.written, n)
+	return n, err
+}
+
+// writeVector writes the buffers of the response to the connection. The
+// caller must hold writeMu.
+func (c *client) writeVector(vh VectorRespHandler, r *Response) error {
+	bufs, err := vh.Buffers(r)
+	if err != nil {
+		return err
+	}
+
+	// flusher is declared to test for the existence of the method
+	// coming from the bufio package.
+	type flusher interface {
+		Flush() error
+	}
+
+	if f, ok := c.writer.(flusher); ok {
+		if err := f.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if bw, ok := c.rw.(buffersWriter); ok {
+		_, err = bw.writeBuffers(&bufs)
+		return err
+	}
+
+	_, err = bufs.WriteTo(c.rw)
+	return err
+}
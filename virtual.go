@@ -0,0 +1,149 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"time"
+)
+
+// defVirtualTimeout is the time allowed for the first bytes to arrive when
+// virtual servers are selected by prefix.
+const defVirtualTimeout = 5 * time.Second
+
+// VirtualServer is a logical service hosted on the listener. A connection
+// is served by the first virtual server whose ServerName matches the TLS
+// SNI or whose Prefix matches the first bytes the client sends. The bytes
+// are not consumed, so the handlers read them as usual.
+type VirtualServer struct {
+	Name       string
+	ServerName string // Matched against the server name requested in the TLS handshake.
+	Prefix     []byte // Matched against the first bytes the client sends.
+	Handlers   HandlerSet
+}
+
+// virtual selects the virtual server for the connection and returns the
+// connection the handlers must be bound to. The handlers of connections
+// no virtual server matches are returned unchanged.
+func (c *client) virtual(conn net.Conn, handlers HandlerSet) (net.Conn, HandlerSet, error) {
+	if len(c.t.Virtual) == 0 {
+		return conn, handlers, nil
+	}
+
+	fallback := handlers
+	if c.t.VirtualFallback != nil {
+		fallback = c.t.VirtualFallback.merge(handlers)
+	}
+
+	// Select by the server name first since it's known without reading.
+	if c.tlsConn != nil {
+		if name := c.tlsConn.ConnectionState().ServerName; name != "" {
+			for _, vs := range c.t.Virtual {
+				if vs.ServerName != "" && vs.ServerName == name {
+					c.t.Event(EvtRoute, TypInfo, c.ipAddress, "virtual server : %s", vs.Name)
+					return conn, vs.Handlers.merge(handlers), nil
+				}
+			}
+		}
+	}
+
+	var prefixed bool
+	for _, vs := range c.t.Virtual {
+		if len(vs.Prefix) > 0 {
+			prefixed = true
+			break
+		}
+	}
+
+	if !prefixed {
+		return conn, fallback, nil
+	}
+
+	pc := peekConn{Conn: conn, reader: bufio.NewReader(conn)}
+
+	timeout := c.t.VirtualTimeout
+	if timeout <= 0 {
+		timeout = defVirtualTimeout
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	vs, err := c.t.matchPrefix(pc.reader)
+	conn.SetReadDeadline(time.Time{})
+
+	// Clients that send nothing in time are served by the fallback, but
+	// a closed connection has nothing left to serve.
+	if err == io.EOF {
+		return nil, handlers, err
+	}
+
+	if vs == nil {
+		c.t.Event(EvtRoute, TypInfo, c.ipAddress, "virtual server : fallback")
+		return &pc, fallback, nil
+	}
+
+	c.t.Event(EvtRoute, TypInfo, c.ipAddress, "virtual server : %s", vs.Name)
+	return &pc, vs.Handlers.merge(handlers), nil
+}
+
+// matchPrefix peeks at the first bytes until they match the prefix of a
+// virtual server or can no longer match any.
+func (t *TCP) matchPrefix(r *bufio.Reader) (*VirtualServer, error) {
+	for n := 1; ; n++ {
+		b, err := r.Peek(n)
+		if err != nil {
+			return nil, err
+		}
+
+		var possible bool
+		for i := range t.Virtual {
+			prefix := t.Virtual[i].Prefix
+			if len(prefix) == 0 {
+				continue
+			}
+
+			if len(prefix) <= len(b) && bytes.HasPrefix(b, prefix) {
+				return &t.Virtual[i], nil
+			}
+
+			if bytes.HasPrefix(prefix, b) {
+				possible = true
+			}
+		}
+
+		if !possible {
+			return nil, nil
+		}
+	}
+}
+
+// =============================================================================
+
+// peekConn serves the bytes peeked at to select the virtual server before
+// reading from the connection.
+type peekConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// Read implements the io.Reader interface.
+func (pc *peekConn) Read(p []byte) (int, error) {
+	if pc.reader.Buffered() > 0 {
+		return pc.reader.Read(p)
+	}
+	return pc.Conn.Read(p)
+}
+
+// CloseWrite closes the write side of the connection when it supports it.
+func (pc *peekConn) CloseWrite() error {
+	if cw, ok := pc.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// ReadFrom copies from the reader to the connection, keeping the fast
+// paths the connection provides for files and sockets.
+func (pc *peekConn) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(pc.Conn, r)
+}
@@ -0,0 +1,48 @@
+package tcp
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// queueBytes adds n to the bytes waiting to be written to the client and
+// calls the callbacks for the watermarks crossed. The bytes count toward
+// the memory caps of the connection. The callbacks alternate,
+// starting with the high watermark.
+func (c *client) queueBytes(n int) {
+	if n == 0 {
+		return
+	}
+	c.account(n)
+
+	queued := atomic.AddInt64(&c.congestion.queuedBytes, int64(n))
+	if c.t.HighWatermark <= 0 {
+		return
+	}
+
+	switch {
+	case queued >= int64(c.t.HighWatermark):
+		if atomic.CompareAndSwapInt32(&c.congestion.aboveHigh, 0, 1) {
+			c.t.Event(EvtWrite, TypInfo, c.ipAddress, "high watermark : Queued[ %d ]", queued)
+			if c.t.OnHighWatermark != nil {
+				c.t.OnHighWatermark(c.conn.RemoteAddr().(*net.TCPAddr), int(queued))
+			}
+		}
+
+	case queued <= int64(c.t.lowWatermark()):
+		if atomic.CompareAndSwapInt32(&c.congestion.aboveHigh, 1, 0) {
+			c.t.Event(EvtWrite, TypInfo, c.ipAddress, "low watermark : Queued[ %d ]", queued)
+			if c.t.OnLowWatermark != nil {
+				c.t.OnLowWatermark(c.conn.RemoteAddr().(*net.TCPAddr), int(queued))
+			}
+		}
+	}
+}
+
+// lowWatermark returns the bytes queued to call OnLowWatermark.
+func (cfg *Config) lowWatermark() int {
+	if cfg.LowWatermark > 0 {
+		return cfg.LowWatermark
+	}
+	return cfg.HighWatermark / 2
+}
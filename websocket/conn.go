@@ -0,0 +1,248 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ardanlabs/tcp"
+)
+
+// Set of frame opcodes.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// Set of close codes.
+const (
+	closeNormal        = 1000
+	closeProtocolError = 1002
+	closeTooBig        = 1009
+)
+
+// maxControlSize is the largest payload of a control frame.
+const maxControlSize = 125
+
+// closeTimeout is the time allowed to send the close frame, so closing
+// doesn't wait on a client that stopped reading.
+const closeTimeout = time.Second
+
+// ProtocolError is returned by Read when the client breaks the WebSocket
+// protocol. The connection is closed since it can't be read any further.
+type ProtocolError struct {
+	Reason string
+}
+
+// Error implements the error interface for ProtocolError.
+func (pe *ProtocolError) Error() string {
+	return "websocket : " + pe.Reason
+}
+
+// Temporary reports the connection can't be read after a protocol error.
+func (pe *ProtocolError) Temporary() bool {
+	return false
+}
+
+// Conn implements the net.Conn interface over a WebSocket connection. The
+// payload of the messages received is read as one stream, and pings and
+// close frames are answered as they are read. Every Write is sent as one
+// message.
+type Conn struct {
+	net.Conn
+	reader   *bufio.Reader
+	text     bool
+	maxFrame int64
+
+	remaining int64   // Payload of the current frame left to read.
+	mask      [4]byte // Mask of the current frame.
+	maskPos   int
+
+	writeMu sync.Mutex
+	closed  int32
+}
+
+// Read reads the payload of the messages received.
+func (c *Conn) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if err := c.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+
+	n, err := c.reader.Read(p)
+	for i := range p[:n] {
+		p[i] ^= c.mask[c.maskPos&3]
+		c.maskPos++
+	}
+	c.remaining -= int64(n)
+
+	return n, err
+}
+
+// nextFrame reads the header of the next frame and answers the control
+// frames read on the way.
+func (c *Conn) nextFrame() error {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.reader, hdr[:]); err != nil {
+		return err
+	}
+
+	opcode := hdr[0] & 0x0F
+	if hdr[1]&0x80 == 0 {
+		return c.fail(closeProtocolError, &ProtocolError{Reason: "unmasked client frame"})
+	}
+
+	length := int64(hdr[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]) & (1<<63 - 1))
+	}
+
+	if _, err := io.ReadFull(c.reader, c.mask[:]); err != nil {
+		return err
+	}
+	c.maskPos = 0
+
+	switch opcode {
+	case opContinuation, opText, opBinary:
+		if length > c.maxFrame {
+			return c.fail(closeTooBig, fmt.Errorf("websocket : %d bytes : %w", length, tcp.ErrFrameTooLarge))
+		}
+		c.remaining = length
+		return nil
+
+	case opClose, opPing, opPong:
+		if length > maxControlSize {
+			return c.fail(closeProtocolError, &ProtocolError{Reason: "control frame too large"})
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.reader, payload); err != nil {
+			return err
+		}
+		for i := range payload {
+			payload[i] ^= c.mask[i&3]
+		}
+
+		switch opcode {
+		case opPing:
+			var err error
+			c.writeMu.Lock()
+			{
+				err = c.writeFrame(opPong, payload)
+			}
+			c.writeMu.Unlock()
+			return err
+
+		case opClose:
+			c.sendClose(closeNormal)
+			return io.EOF
+		}
+		return nil
+	}
+
+	return c.fail(closeProtocolError, &ProtocolError{Reason: fmt.Sprintf("unknown opcode %d", opcode)})
+}
+
+// fail closes the connection with the code and returns the error.
+func (c *Conn) fail(code int, err error) error {
+	c.sendClose(code)
+	return err
+}
+
+// Write sends the bytes as one message.
+func (c *Conn) Write(p []byte) (int, error) {
+	if atomic.LoadInt32(&c.closed) == 1 {
+		return 0, net.ErrClosed
+	}
+
+	op := byte(opBinary)
+	if c.text {
+		op = opText
+	}
+
+	var err error
+	c.writeMu.Lock()
+	{
+		err = c.writeFrame(op, p)
+	}
+	c.writeMu.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// writeFrame writes the payload as one unmasked frame.
+func (c *Conn) writeFrame(op byte, payload []byte) error {
+	frame := make([]byte, 0, 10+len(payload))
+	frame = append(frame, 0x80|op)
+
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, payload...)
+
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+// sendClose sends the close frame with the code once, so no message can
+// be written after it.
+func (c *Conn) sendClose(code int) {
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return
+	}
+
+	var payload [2]byte
+	binary.BigEndian.PutUint16(payload[:], uint16(code))
+
+	// Set the deadline before waiting for the lock so a write blocked on
+	// a slow client gives up and Close can't hang.
+	c.Conn.SetWriteDeadline(time.Now().Add(closeTimeout))
+
+	c.writeMu.Lock()
+	{
+		c.writeFrame(opClose, payload[:])
+	}
+	c.writeMu.Unlock()
+}
+
+// Close sends a close frame to the client and closes the connection.
+func (c *Conn) Close() error {
+	c.sendClose(closeNormal)
+	return c.Conn.Close()
+}
@@ -0,0 +1,206 @@
+// Package websocket provides a listener that accepts WebSocket connections
+// from an http.Server so browser clients can be served by the handlers of
+// a tcp.TCP value. The messages of each connection are read as one stream
+// of bytes and every write is sent as one message.
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// acceptGUID is appended to the key of the client to compute the accept
+// key of the handshake.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// defMaxFrameSize is the largest frame read by default.
+const defMaxFrameSize = 1 << 20
+
+// Listener implements the net.Listener and http.Handler interfaces. The
+// requests it serves are upgraded to WebSocket connections and returned
+// by Accept, so it can be set as tcp.Config.Listener while it's mounted
+// on an http.Server.
+type Listener struct {
+	CheckOrigin  func(r *http.Request) bool // Rejects cross origin requests, defaults to accept the same origin only.
+	Subprotocols []string                   // Subprotocols accepted in the order of preference.
+	Text         bool                       // Sends text messages instead of binary ones.
+	MaxFrameSize int                        // Largest frame read, defaults to 1MB.
+
+	addr  net.Addr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+// NewListener creates a listener reporting the address of the http.Server
+// it's mounted on.
+func NewListener(addr net.Addr) *Listener {
+	return &Listener{
+		addr:  addr,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+// ServeHTTP upgrades the request to a WebSocket connection and waits for
+// the connection to be accepted.
+func (l *Listener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-l.done:
+		http.Error(w, "listener closed", http.StatusServiceUnavailable)
+		return
+	default:
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "websocket : method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !headerHas(r.Header, "Connection", "upgrade") || !headerHas(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "websocket : not an upgrade request", http.StatusBadRequest)
+		return
+	}
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "websocket : unsupported version", http.StatusUpgradeRequired)
+		return
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if b, err := base64.StdEncoding.DecodeString(key); err != nil || len(b) != 16 {
+		http.Error(w, "websocket : invalid key", http.StatusBadRequest)
+		return
+	}
+
+	checkOrigin := l.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+
+	if !checkOrigin(r) {
+		http.Error(w, "websocket : origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket : connection can't be hijacked", http.StatusInternalServerError)
+		return
+	}
+
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		http.Error(w, "websocket : "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	brw.WriteString("Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n")
+	if proto := l.subprotocol(r); proto != "" {
+		brw.WriteString("Sec-WebSocket-Protocol: " + proto + "\r\n")
+	}
+	brw.WriteString("\r\n")
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return
+	}
+
+	maxFrame := l.MaxFrameSize
+	if maxFrame <= 0 {
+		maxFrame = defMaxFrameSize
+	}
+
+	c := Conn{
+		Conn:     conn,
+		reader:   brw.Reader,
+		text:     l.Text,
+		maxFrame: int64(maxFrame),
+	}
+
+	select {
+	case l.conns <- &c:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+// Accept waits for the next WebSocket connection.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the listener. Requests are refused from then on and the
+// connections already accepted stay open.
+func (l *Listener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
+
+// Addr returns the address provided to NewListener.
+func (l *Listener) Addr() net.Addr {
+	return l.addr
+}
+
+// subprotocol returns the first subprotocol requested by the client that
+// the listener accepts.
+func (l *Listener) subprotocol(r *http.Request) string {
+	for _, proto := range l.Subprotocols {
+		if headerHas(r.Header, "Sec-WebSocket-Protocol", proto) {
+			return proto
+		}
+	}
+	return ""
+}
+
+// =============================================================================
+
+// acceptKey returns the accept key of the handshake for the key of the
+// client.
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// sameOrigin reports whether the request comes from a page of the host it
+// was sent to. Requests without an origin don't come from a browser and
+// are accepted.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// headerHas reports whether the comma separated values of the header hold
+// the token, ignoring case.
+func headerHas(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
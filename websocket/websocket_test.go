@@ -0,0 +1,212 @@
+package websocket_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/tcp"
+	"github.com/ardanlabs/tcp/websocket"
+)
+
+// Success and failure markers.
+var (
+	success = "✓"
+	failed  = "✗"
+)
+
+// TestListener tests WebSocket clients are served by the tcp handlers.
+func TestListener(t *testing.T) {
+	t.Log("Given the need to serve browser clients with the same handlers.")
+	{
+		hs := httptest.NewUnstartedServer(nil)
+		l := websocket.NewListener(hs.Listener.Addr())
+		l.MaxFrameSize = 64
+		hs.Config.Handler = l
+		hs.Start()
+		defer hs.Close()
+
+		u, err := tcp.New("TEST", tcp.Config{
+			NetType:     "tcp4",
+			ReqHandler:  echoReqHandler{},
+			RespHandler: respHandler{},
+
+			OptBufferSize: tcp.OptBufferSize{
+				ReadBufferSize: 1024,
+			},
+			OptListen: tcp.OptListen{
+				Listener: l,
+			},
+		})
+		if err != nil {
+			t.Fatalf("\tShould be able to create a new TCP listener : %v %s", err, failed)
+		}
+		if err := u.Start(); err != nil {
+			t.Fatalf("\tShould be able to start the TCP listener : %v %s", err, failed)
+		}
+		defer u.Stop()
+
+		resp, err := http.Get(hs.URL)
+		if err != nil {
+			t.Fatalf("\tShould be able to send a request : %v %s", err, failed)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("\tShould refuse a request that's not an upgrade : %d %s", resp.StatusCode, failed)
+		}
+		t.Log("\tShould refuse a request that's not an upgrade.", success)
+
+		req, _ := http.NewRequest(http.MethodGet, hs.URL, nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		req.Header.Set("Origin", "http://evil.example")
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("\tShould be able to send a request : %v %s", err, failed)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("\tShould refuse a request from another origin : %d %s", resp.StatusCode, failed)
+		}
+		t.Log("\tShould refuse a request from another origin.", success)
+
+		conn, r := dial(t, hs.Listener.Addr().String())
+		defer conn.Close()
+		t.Log("\tShould complete the handshake.", success)
+
+		// The message is split over two frames to check the payload
+		// is read as a stream.
+		writeFrame(t, conn, 0x02, []byte("Hel"))
+		writeFrame(t, conn, 0x02, []byte("lo\n"))
+		if op, msg := readFrame(t, r); op != 0x02 || string(msg) != "Hello\n" {
+			t.Fatalf("\tShould receive the response as a binary message : %d %q %s", op, msg, failed)
+		}
+		t.Log("\tShould serve the messages through the handlers.", success)
+
+		writeFrame(t, conn, 0x09, []byte("ping"))
+		if op, msg := readFrame(t, r); op != 0x0A || string(msg) != "ping" {
+			t.Fatalf("\tShould answer a ping with a pong : %d %q %s", op, msg, failed)
+		}
+		t.Log("\tShould answer a ping with a pong.", success)
+
+		writeFrame(t, conn, 0x02, bytes.Repeat([]byte("x"), 100))
+		op, msg := readFrame(t, r)
+		if op != 0x08 || binary.BigEndian.Uint16(msg) != 1009 {
+			t.Fatalf("\tShould close the connection on a frame too large : %d %v %s", op, msg, failed)
+		}
+		if _, err := r.ReadByte(); err != io.EOF {
+			t.Fatalf("\tShould close the connection on a frame too large : %v %s", err, failed)
+		}
+		t.Log("\tShould close the connection on a frame too large.", success)
+	}
+}
+
+// =============================================================================
+
+// dial connects to the server and completes the WebSocket handshake.
+func dial(t *testing.T, addr string) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("\tShould be able to dial the server : %v %s", err, failed)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req, _ := http.NewRequest(http.MethodGet, "http://"+addr+"/", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("\tShould be able to send the handshake : %v %s", err, failed)
+	}
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		t.Fatalf("\tShould receive the handshake : %v %s", err, failed)
+	}
+
+	// The accept key of the sample nonce from RFC 6455.
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("\tShould switch protocols : %d %v %s", resp.StatusCode, resp.Header, failed)
+	}
+
+	return conn, r
+}
+
+// writeFrame writes a masked frame like a client.
+func writeFrame(t *testing.T, conn net.Conn, op byte, payload []byte) {
+	mask := [4]byte{1, 2, 3, 4}
+
+	frame := []byte{0x80 | op, 0x80 | byte(len(payload))}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i&3])
+	}
+
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatalf("\tShould be able to send a frame : %v %s", err, failed)
+	}
+}
+
+// readFrame reads a frame of the server.
+func readFrame(t *testing.T, r *bufio.Reader) (byte, []byte) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		t.Fatalf("\tShould receive a frame : %v %s", err, failed)
+	}
+
+	payload := make([]byte, hdr[1]&0x7F)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatalf("\tShould receive a frame : %v %s", err, failed)
+	}
+
+	return hdr[0] & 0x0F, payload
+}
+
+// =============================================================================
+
+// echoReqHandler answers every line with the line.
+type echoReqHandler struct{}
+
+// Read implements the tcp.ReqHandler interface.
+func (echoReqHandler) Read(ipAddress string, reader io.Reader) ([]byte, int, error) {
+	line, err := reader.(*bufio.Reader).ReadBytes('\n')
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return line, len(line), nil
+}
+
+// Process implements the tcp.ReqHandler interface.
+func (echoReqHandler) Process(r *tcp.Request) {
+	resp := tcp.Response{
+		TCPAddr: r.TCPAddr,
+		Data:    r.Data,
+		Length:  r.Length,
+	}
+
+	r.TCP.Send(r.Context, &resp)
+}
+
+// respHandler writes and flushes the response.
+type respHandler struct{}
+
+// Write implements the tcp.RespHandler interface.
+func (respHandler) Write(r *tcp.Response, writer io.Writer) error {
+	bw := writer.(*bufio.Writer)
+	if _, err := bw.Write(r.Data[:r.Length]); err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
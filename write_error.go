@@ -0,0 +1,59 @@
+package tcp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// WriteAction tells the TCP value what to do after a failed write.
+type WriteAction int
+
+// Set of actions OnWriteError can return.
+const (
+	WriteDrop  WriteAction = iota // Drop the response and return the error.
+	WriteRetry                    // Write the response again.
+	WriteClose                    // Close the connection and return the error.
+)
+
+// WriteError is returned when a response could not be written.
+type WriteError struct {
+	TCPAddr *net.TCPAddr
+	Attempt int   // Attempts made to write the response, starting at 1.
+	Written int64 // Bytes of the response that reached the connection.
+	Err     error
+}
+
+// Error implements the error interface for WriteError.
+func (we *WriteError) Error() string {
+	return fmt.Sprintf("write %v : Attempt[ %d ] Written[ %d ] : %v", we.TCPAddr, we.Attempt, we.Written, we.Err)
+}
+
+// Unwrap returns the error the write failed with.
+func (we *WriteError) Unwrap() error {
+	return we.Err
+}
+
+// Partial reports whether part of the response reached the connection, so
+// writing it again would repeat those bytes to the client.
+func (we *WriteError) Partial() bool {
+	return we.Written > 0
+}
+
+// Timeout reports whether the write failed because the WriteTimeout passed.
+func (we *WriteError) Timeout() bool {
+
+	// timeout is declared to test for the existence of the method
+	// coming from the net package.
+	type timeout interface {
+		Timeout() bool
+	}
+
+	var e timeout
+	return errors.As(we.Err, &e) && e.Timeout()
+}
+
+// Is reports a write that timed out as an ErrDeadline.
+func (we *WriteError) Is(target error) bool {
+	return target == ErrDeadline && we.Timeout()
+}